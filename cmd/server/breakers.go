@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/sony/gobreaker"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/circuitbreaker"
+	"zipperfly/internal/events"
+	"zipperfly/internal/metrics"
+	"zipperfly/internal/models"
+)
+
+// breakerEventTimeout bounds publishing one circuit breaker event
+const breakerEventTimeout = 10 * time.Second
+
+// breakerNotifier logs circuit breaker state changes and, with
+// CIRCUIT_BREAKER_EVENTS, publishes them to the event bus, so on-call hears
+// of storage going down as soon as a breaker opens
+type breakerNotifier struct {
+	logger    *zap.Logger
+	metrics   *metrics.Metrics
+	publisher events.BreakerPublisher // nil = log only
+}
+
+// watch reports each state change of b
+func (n *breakerNotifier) watch(b *circuitbreaker.Breaker) {
+	b.OnStateChange(n.stateChanged)
+}
+
+// stateChanged logs a state change, as a warning when the breaker opened,
+// and publishes it in the background
+func (n *breakerNotifier) stateChanged(change circuitbreaker.StateChange) {
+	level := zap.InfoLevel
+	if change.To == gobreaker.StateOpen {
+		level = zap.WarnLevel
+	}
+	n.logger.Log(level, "circuit breaker state changed",
+		zap.String("breaker", change.Name),
+		zap.String("key", change.Key),
+		zap.String("from", change.From.String()),
+		zap.String("to", change.To.String()),
+		zap.Uint32("requests", change.Counts.Requests),
+		zap.Uint32("total_failures", change.Counts.TotalFailures),
+		zap.Uint32("consecutive_failures", change.Counts.ConsecutiveFailures),
+	)
+
+	if n.publisher == nil {
+		return
+	}
+	event := breakerEvent(change, time.Now())
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), breakerEventTimeout)
+		defer cancel()
+		if err := n.publisher.PublishBreaker(ctx, event); err != nil {
+			n.metrics.EventsTotal.WithLabelValues(event.Type, "failure").Inc()
+			n.logger.Error("failed to publish event", zap.String("type", event.Type), zap.String("breaker", event.Breaker), zap.Error(err))
+			return
+		}
+		n.metrics.EventsTotal.WithLabelValues(event.Type, "success").Inc()
+	}()
+}
+
+// breakerEvent is the event bus message for a state change at now
+func breakerEvent(change circuitbreaker.StateChange, now time.Time) models.BreakerEvent {
+	return models.BreakerEvent{
+		Type:                events.BreakerStateChange,
+		Breaker:             change.Name,
+		Key:                 change.Key,
+		From:                change.From.String(),
+		To:                  change.To.String(),
+		Timestamp:           now.UTC().Format(time.RFC3339),
+		Requests:            change.Counts.Requests,
+		TotalFailures:       change.Counts.TotalFailures,
+		ConsecutiveFailures: change.Counts.ConsecutiveFailures,
+	}
+}
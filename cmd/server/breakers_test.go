@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"zipperfly/internal/circuitbreaker"
+	"zipperfly/internal/config"
+	"zipperfly/internal/events"
+	"zipperfly/internal/metrics"
+	"zipperfly/internal/models"
+)
+
+// breakerPublisher records the breaker events published
+type breakerPublisher struct {
+	events.Publisher
+	published chan models.BreakerEvent
+}
+
+func (p *breakerPublisher) PublishBreaker(_ context.Context, event models.BreakerEvent) error {
+	p.published <- event
+	return nil
+}
+
+func TestBreakerNotifier(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	m := metrics.NewWithRegistry(nil)
+	publisher := &breakerPublisher{published: make(chan models.BreakerEvent, 1)}
+	n := &breakerNotifier{logger: zap.New(core), metrics: m, publisher: publisher}
+
+	cfg := &config.Config{CircuitBreakerThreshold: 2, CircuitBreakerTimeout: time.Minute, CircuitBreakerMaxRequests: 1}
+	b := circuitbreaker.NewPerKey("storage", cfg, m)
+	n.watch(b)
+	for i := 0; i < 2; i++ {
+		b.ExecuteFor("cold-bucket", func() (interface{}, error) { return nil, errors.New("throttled") })
+	}
+
+	entries := logs.FilterMessage("circuit breaker state changed").All()
+	if len(entries) != 1 {
+		t.Fatalf("logged %d state changes, want 1", len(entries))
+	}
+	if entries[0].Level != zapcore.WarnLevel {
+		t.Errorf("logged at %s, want warn", entries[0].Level)
+	}
+	fields := entries[0].ContextMap()
+	if fields["key"] != "cold-bucket" || fields["to"] != "open" || fields["consecutive_failures"] != uint32(2) {
+		t.Errorf("log fields = %v, want the bucket opening after 2 failures", fields)
+	}
+
+	select {
+	case event := <-publisher.published:
+		if event.Type != events.BreakerStateChange || event.Breaker != "storage" || event.Key != "cold-bucket" || event.From != "closed" || event.To != "open" {
+			t.Errorf("event = %+v, want the storage breaker opening for cold-bucket", event)
+		}
+		if event.ConsecutiveFailures != 2 || event.TotalFailures != 2 || event.Requests != 2 {
+			t.Errorf("event counts = %d/%d/%d, want 2/2/2", event.ConsecutiveFailures, event.TotalFailures, event.Requests)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no breaker event published")
+	}
+	if got := testutil.ToFloat64(m.CircuitBreakerTransitions.WithLabelValues("storage", "open")); got != 1 {
+		t.Errorf("transitions to open = %g, want 1", got)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/config"
+	"zipperfly/internal/database"
+	"zipperfly/internal/handlers"
+	"zipperfly/internal/metrics"
+	"zipperfly/internal/models"
+)
+
+// buildCommand writes a record's archive to a file with the server's
+// fetch and archive pipeline, without serving anything, for offline and
+// batch exports:
+//
+//	zipperfly build --record record.json --out out.zip
+type buildCommand struct {
+	flags    *flag.FlagSet
+	record   string
+	id       string
+	out      string
+	format   string
+	files    string
+	password string
+	tenant   string
+}
+
+// newBuildCommand creates the build subcommand, whose --config sets
+// configFile
+func newBuildCommand(configFile *string) *buildCommand {
+	c := &buildCommand{flags: flag.NewFlagSet("build", flag.ExitOnError)}
+	c.flags.StringVar(configFile, "config", *configFile, "Path to config file (overrides CONFIG_FILE env var)")
+	c.flags.StringVar(&c.record, "record", "", "JSON record to build, - for standard input")
+	c.flags.StringVar(&c.id, "id", "", "ID of a record to build from the database, instead of --record")
+	c.flags.StringVar(&c.out, "out", "", "File to write the archive to, - for standard output (required)")
+	c.flags.StringVar(&c.format, "format", "", "Archive format (default: the record's)")
+	c.flags.StringVar(&c.files, "files", "", "Comma-separated object keys or 0-based indexes to build (default: the whole record)")
+	c.flags.StringVar(&c.password, "password", "", "ZIP password, for records with password_hash or password_required")
+	c.flags.StringVar(&c.tenant, "tenant", "", "Tenant profile whose settings and storage to use (default: the base configuration)")
+	return c
+}
+
+// run loads the configuration and the record, and builds its archive into
+// --out, reading the record from in and writing the archive to out when
+// they are -. It logs to stderr, and reports the build there.
+func (c *buildCommand) run(ctx context.Context, in io.Reader, out, stderr io.Writer) error {
+	if (c.record == "") == (c.id == "") {
+		return errors.New("one of --record and --id is required")
+	}
+	if c.out == "" {
+		return errors.New("--out is required")
+	}
+
+	cfg, err := loadCommandConfig(ctx, c.tenant, c.id != "")
+	if err != nil {
+		return err
+	}
+	logCfg, err := config.LoadLogConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load log config: %w", err)
+	}
+	if logCfg.Output == "stdout" {
+		logCfg.Output = "stderr" // stdout may be the archive
+	}
+	logger, logFile, err := newLogger(logCfg, zap.NewAtomicLevel())
+	if err != nil {
+		return fmt.Errorf("failed to init logger: %w", err)
+	}
+	defer logFile.Close()
+	defer logger.Sync()
+
+	// The command's metrics aren't served, so they're left unregistered
+	m := metrics.NewWithRegistry(nil)
+	record, err := c.loadRecord(ctx, cfg, m, in)
+	if err != nil {
+		return err
+	}
+	provider, err := newCommandStorage(ctx, cfg, m)
+	if err != nil {
+		return err
+	}
+	h := handlers.NewDownloadHandler(logger, nil, provider, nil, m, newHandlerOptions(cfg))
+
+	return c.build(ctx, h, record, out, stderr)
+}
+
+// build writes the archive to --out, or out for -, and reports it to
+// stderr. A file is written under a temporary name and renamed once
+// complete, so a failed build leaves nothing behind.
+func (c *buildCommand) build(ctx context.Context, h *handlers.Handler, record *models.DownloadRecord, out, stderr io.Writer) error {
+	opts := handlers.BuildOptions{Format: c.format, Files: c.files, Password: c.password}
+	if c.out == "-" {
+		_, err := h.Build(ctx, out, record, opts)
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.out), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(c.out), ".zipperfly-build-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	result, err := h.Build(ctx, f, record, opts)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), c.out); err != nil {
+		return err
+	}
+
+	what := string(result.Format)
+	if result.Raw {
+		what = "raw file"
+	}
+	fmt.Fprintf(stderr, "wrote %s (%s, %d bytes): %d of %d files\n", c.out, what, result.SizeBytes, result.FileCount, result.FileTotal)
+	return nil
+}
+
+// loadRecord reads the record of --record, or looks --id up in the
+// database
+func (c *buildCommand) loadRecord(ctx context.Context, cfg *config.Config, m *metrics.Metrics, in io.Reader) (*models.DownloadRecord, error) {
+	if c.id != "" {
+		db, err := database.New(ctx, cfg, m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize database: %w", err)
+		}
+		defer db.Close()
+		record, err := db.GetRecord(ctx, c.id)
+		if database.IsNotFound(err) {
+			return nil, fmt.Errorf("record %q not found", c.id)
+		}
+		return record, err
+	}
+	return readRecordFile(c.record, in)
+}
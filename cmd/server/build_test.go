@@ -0,0 +1,76 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildCommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "assets"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a.txt": "alpha", "b.txt": "bravo"} {
+		if err := os.WriteFile(filepath.Join(dir, "assets", name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recordFile := filepath.Join(t.TempDir(), "record.json")
+	if err := os.WriteFile(recordFile, []byte(`{"id": "export-1", "bucket": "assets", "objects": ["a.txt", "b.txt", "missing.txt"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DB_URL", "")
+	t.Setenv("ENABLE_HTTPS", "false")
+	t.Setenv("STORAGE_TYPE", "local")
+	t.Setenv("STORAGE_PATH", dir)
+	t.Setenv("LOG_LEVEL", "error")
+
+	build := func(args ...string) (string, error) {
+		var configFile string
+		cmd := newBuildCommand(&configFile)
+		if err := cmd.flags.Parse(args); err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		var stderr bytes.Buffer
+		err := cmd.run(context.Background(), strings.NewReader(""), &bytes.Buffer{}, &stderr)
+		return stderr.String(), err
+	}
+
+	out := filepath.Join(t.TempDir(), "exports", "out.zip")
+	if _, err := build("--record", recordFile, "--out", out); err == nil {
+		t.Fatal("build with a missing file succeeded without IGNORE_MISSING")
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("failed build left %s behind: %v", out, err)
+	}
+
+	t.Setenv("IGNORE_MISSING", "true")
+	report, err := build("--record", recordFile, "--out", out)
+	if err != nil {
+		t.Fatalf("build error = %v", err)
+	}
+	if !strings.Contains(report, "2 of 3 files") {
+		t.Errorf("build reported %q, want 2 of 3 files", report)
+	}
+	zr, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	defer zr.Close()
+	if len(zr.File) != 2 {
+		t.Errorf("zip has %d files, want 2", len(zr.File))
+	}
+
+	if _, err := build("--out", out); err == nil {
+		t.Error("build without --record or --id succeeded")
+	}
+	// Only a record from the database needs DB_URL
+	if _, err := build("--id", "export-1", "--out", out); err == nil || !strings.Contains(err.Error(), "DB_URL required") {
+		t.Errorf("build --id without DB_URL: error = %v, want DB_URL required", err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"zipperfly/internal/circuitbreaker"
+	"zipperfly/internal/config"
+	"zipperfly/internal/metrics"
+	"zipperfly/internal/models"
+	"zipperfly/internal/storage"
+)
+
+// loadCommandConfig loads the configuration for a command run instead of
+// the server, fetching secrets first like the server does. DB_URL is only
+// required with needDB. With tenant, it returns that profile's
+// configuration instead of the base one.
+func loadCommandConfig(ctx context.Context, tenant string, needDB bool) (*config.Config, error) {
+	secretsCfg, err := config.LoadSecretsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if secretsCfg.Provider != "none" {
+		if err := loadSecrets(ctx, secretsCfg); err != nil {
+			return nil, fmt.Errorf("failed to fetch secrets: %w", err)
+		}
+	}
+	load := config.LoadWithoutDB
+	if needDB {
+		load = config.Load
+	}
+	cfg, err := load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if tenant == "" {
+		return cfg, nil
+	}
+	for _, t := range cfg.Tenants {
+		if t.Name == strings.ToLower(tenant) {
+			return t.Config, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown tenant %q", tenant)
+}
+
+// newCommandStorage creates cfg's storage provider, failing over to its
+// failover storage like the server's
+func newCommandStorage(ctx context.Context, cfg *config.Config, m *metrics.Metrics) (storage.Provider, error) {
+	provider, err := storage.New(ctx, cfg, m, circuitbreaker.NewStorage("storage", cfg, m))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize storage provider: %w", err)
+	}
+	if failover := cfg.StorageFailover; failover != nil {
+		secondary, err := storage.New(ctx, failover.Config, m, circuitbreaker.NewStorage("storage_failover", failover.Config, m))
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize failover storage provider: %w", err)
+		}
+		provider = storage.NewFailover(provider, secondary, failover.Bucket, m)
+	}
+	return provider, nil
+}
+
+// readRecordFile reads a JSON record from path, or from in if path is -,
+// refusing unknown fields so a misspelled one isn't silently dropped
+func readRecordFile(path string, in io.Reader) (*models.DownloadRecord, error) {
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+	dec := json.NewDecoder(in)
+	dec.DisallowUnknownFields()
+	var record models.DownloadRecord
+	if err := dec.Decode(&record); err != nil {
+		return nil, fmt.Errorf("invalid record %s: %w", path, err)
+	}
+	return &record, nil
+}
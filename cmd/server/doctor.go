@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"zipperfly/internal/circuitbreaker"
+	"zipperfly/internal/config"
+	"zipperfly/internal/database"
+	"zipperfly/internal/metrics"
+	"zipperfly/internal/secrets"
+	"zipperfly/internal/storage"
+	"zipperfly/internal/version"
+)
+
+// doctorTimeout bounds each connection attempt of the doctor
+const doctorTimeout = 30 * time.Second
+
+// doctorReport writes one line per check of the diagnostic report
+type doctorReport struct {
+	w      io.Writer
+	failed bool
+}
+
+// ok reports a passed check
+func (r *doctorReport) ok(check, format string, args ...any) {
+	fmt.Fprintf(r.w, "[ OK ] %s: %s\n", check, fmt.Sprintf(format, args...))
+}
+
+// fail reports a failed check, indenting the lines of errors that have
+// several, like strict config validation's
+func (r *doctorReport) fail(check string, err error) {
+	r.failed = true
+	fmt.Fprintf(r.w, "[FAIL] %s: %s\n", check, strings.ReplaceAll(err.Error(), "\n", "\n       "))
+}
+
+// runDoctor checks the deployment without starting the server: it loads the
+// configuration, fetching secrets first like the server does, connects to
+// the database, which also verifies the table's required columns, and runs
+// a storage health check, for the base configuration and every tenant
+// profile. It writes a report of each check to w and returns
+// false if any failed, for CI and pre-deploy checks.
+func runDoctor(ctx context.Context, w io.Writer) bool {
+	r := &doctorReport{w: w}
+	build := version.Get()
+	fmt.Fprintf(w, "zipperfly %s (commit %s, built %s)\n", build.Version, build.Commit, build.BuildDate)
+
+	secretsCfg, err := config.LoadSecretsConfig()
+	if err != nil {
+		r.fail("secrets", err)
+		return false
+	}
+	if secretsCfg.Provider != "none" {
+		if err := loadSecrets(ctx, secretsCfg); err != nil {
+			r.fail("secrets", err)
+			return false
+		}
+		r.ok("secrets", "fetched from %s", secretsCfg.Provider)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		r.fail("config", err)
+		return false
+	}
+	r.ok("config", "database %s, storage %s", cfg.DBEngine, cfg.StorageType)
+
+	// The doctor's metrics aren't served, so they're left unregistered
+	m := metrics.NewWithRegistry(nil)
+
+	doctorBackends(ctx, r, "", cfg, m)
+	for _, tenant := range cfg.Tenants {
+		doctorBackends(ctx, r, " ("+tenant.Name+")", tenant.Config, m)
+	}
+
+	return !r.failed
+}
+
+// doctorBackends checks the database and storage of cfg, the base
+// configuration's or a tenant profile's, naming the checks with suffix
+func doctorBackends(ctx context.Context, r *doctorReport, suffix string, cfg *config.Config, m *metrics.Metrics) {
+	dbCtx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	if db, err := database.New(dbCtx, cfg, m); err != nil {
+		r.fail("database"+suffix, err)
+	} else {
+		defer db.Close()
+		if err := db.HealthCheck(dbCtx); err != nil {
+			r.fail("database"+suffix, err)
+		} else if lister, ok := db.(database.ColumnLister); ok {
+			r.ok("database"+suffix, "connected, table %s has columns %s", cfg.TableName, strings.Join(lister.Columns(), ", "))
+		} else {
+			r.ok("database"+suffix, "connected")
+		}
+	}
+
+	doctorStorage(ctx, r, "storage"+suffix, cfg, m)
+	if cfg.StorageFailover != nil {
+		doctorStorage(ctx, r, "failover storage"+suffix, cfg.StorageFailover.Config, m)
+	}
+}
+
+// doctorStorage runs the health check of cfg's storage, naming the check
+// name
+func doctorStorage(ctx context.Context, r *doctorReport, name string, cfg *config.Config, m *metrics.Metrics) {
+	storageCtx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	if provider, err := storage.New(storageCtx, cfg, m, circuitbreaker.NewStorage("storage", cfg, m)); err != nil {
+		r.fail(name, err)
+	} else if err := provider.HealthCheck(storageCtx); err != nil {
+		r.fail(name, err)
+	} else {
+		r.ok(name, "connected")
+	}
+}
+
+// loadSecrets fetches secrets from the manager into the environment
+func loadSecrets(ctx context.Context, cfg *config.SecretsConfig) error {
+	source, err := secrets.New(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	values, err := source.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+	return secrets.Export(values)
+}
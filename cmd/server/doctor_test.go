@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRunDoctor(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		wantLines []string
+	}{
+		{
+			name:      "invalid config",
+			env:       map[string]string{"DB_URL": "", "REQUEST_TIMEOUT": "30seconds"},
+			wantLines: []string{"[FAIL] config: "},
+		},
+		{
+			name: "database unreachable",
+			env: map[string]string{
+				"DB_URL":       "redis://127.0.0.1:1/0",
+				"STORAGE_TYPE": "local",
+				"STORAGE_PATH": t.TempDir(),
+			},
+			wantLines: []string{"[ OK ] config: database redis, storage local", "[FAIL] database: ", "[ OK ] storage: connected"},
+		},
+		{
+			name: "tenant storage missing",
+			env: map[string]string{
+				"DB_URL":                   "redis://127.0.0.1:1/0",
+				"STORAGE_TYPE":             "local",
+				"STORAGE_PATH":             t.TempDir(),
+				"TENANTS":                  "acme",
+				"TENANT_ACME_HOSTS":        "files.acme.com",
+				"TENANT_ACME_STORAGE_PATH": "/nonexistent/acme",
+			},
+			wantLines: []string{"[ OK ] storage: connected", "[FAIL] database (acme): ", "[FAIL] storage (acme): "},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ENABLE_HTTPS", "false")
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			var out bytes.Buffer
+			if runDoctor(context.Background(), &out) {
+				t.Errorf("runDoctor() = true, want false\n%s", out.String())
+			}
+			for _, want := range tt.wantLines {
+				if !strings.Contains(out.String(), "\n"+want) {
+					t.Errorf("report is missing %q:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}
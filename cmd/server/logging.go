@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"zipperfly/internal/config"
+)
+
+// newLogger creates the server logger from the log settings, at level, which
+// a config reload can change. It samples and adds callers and error
+// stacktraces like zap's production logger. The returned closer closes the
+// log file, if it writes to one.
+func newLogger(cfg *config.LogConfig, level zap.AtomicLevel) (*zap.Logger, io.Closer, error) {
+	level.SetLevel(parseLogLevel(cfg.Level))
+
+	encoderCfg := zap.NewProductionEncoderConfig()
+	var encoder zapcore.Encoder
+	if cfg.Format == "console" {
+		encoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoderCfg.EncodeLevel = zapcore.CapitalLevelEncoder
+		encoder = zapcore.NewConsoleEncoder(encoderCfg)
+	} else {
+		encoder = zapcore.NewJSONEncoder(encoderCfg)
+	}
+
+	var sink zapcore.WriteSyncer
+	var closer io.Closer = io.NopCloser(nil)
+	switch cfg.Output {
+	case "stdout":
+		sink = zapcore.Lock(os.Stdout)
+	case "stderr":
+		sink = zapcore.Lock(os.Stderr)
+	default:
+		file := &lumberjack.Logger{
+			Filename:   cfg.Output,
+			MaxSize:    cfg.MaxSizeMB,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAgeDays,
+			Compress:   cfg.Compress,
+		}
+		// Open the file now, so an unwritable path fails startup rather
+		// than dropping every entry
+		if _, err := file.Write(nil); err != nil {
+			return nil, nil, err
+		}
+		sink = zapcore.AddSync(file)
+		closer = file
+	}
+
+	core := zapcore.NewSamplerWithOptions(zapcore.NewCore(encoder, sink, level), time.Second, 100, 100)
+	logger := zap.New(core,
+		zap.AddCaller(),
+		zap.AddStacktrace(zapcore.ErrorLevel),
+		zap.ErrorOutput(zapcore.Lock(os.Stderr)),
+	)
+	return logger, closer, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/config"
+)
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		level   string
+		want    string
+		notWant string
+	}{
+		{name: "json", format: "json", level: "info", want: `"msg":"shown"`, notWant: "hidden"},
+		{name: "console", format: "console", level: "info", want: "INFO\t", notWant: "{"},
+		{name: "debug", format: "json", level: "debug", want: `"msg":"hidden"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "server.log")
+			logger, closer, err := newLogger(&config.LogConfig{Level: tt.level, Format: tt.format, Output: path, MaxSizeMB: 1}, zap.NewAtomicLevel())
+			if err != nil {
+				t.Fatalf("newLogger() error = %v", err)
+			}
+			logger.Debug("hidden")
+			logger.Info("shown")
+			logger.Sync()
+			closer.Close()
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), tt.want) {
+				t.Errorf("log = %q, want it to contain %q", data, tt.want)
+			}
+			if tt.notWant != "" && strings.Contains(string(data), tt.notWant) {
+				t.Errorf("log = %q, want it not to contain %q", data, tt.notWant)
+			}
+		})
+	}
+}
+
+func TestNewLogger_UnwritableFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "dir", "server.log")
+	if err := os.WriteFile(filepath.Dir(filepath.Dir(path)), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := newLogger(&config.LogConfig{Level: "info", Format: "json", Output: path, MaxSizeMB: 1}, zap.NewAtomicLevel()); err == nil {
+		t.Error("newLogger() error = nil, want an error for a file under a regular file")
+	}
+}
@@ -5,50 +5,152 @@ import (
 	"flag"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
 
+	"zipperfly/internal/adaptive"
 	"zipperfly/internal/auth"
+	"zipperfly/internal/callbacks"
 	"zipperfly/internal/circuitbreaker"
 	"zipperfly/internal/config"
 	"zipperfly/internal/database"
+	"zipperfly/internal/events"
+	"zipperfly/internal/geoip"
 	"zipperfly/internal/handlers"
+	"zipperfly/internal/limits"
 	"zipperfly/internal/metrics"
+	"zipperfly/internal/schedule"
+	"zipperfly/internal/secrets"
 	"zipperfly/internal/server"
 	"zipperfly/internal/storage"
+	"zipperfly/internal/tokens"
+	"zipperfly/internal/version"
 )
 
 func main() {
 	// Parse command-line flags
 	configFile := flag.String("config", "", "Path to config file (overrides CONFIG_FILE env var)")
+	validate := flag.Bool("validate", false, "Check the config, database, and storage, print a report, and exit (also: doctor)")
+	signCmd := newSignCommand(configFile)
+	recordCmd := newRecordCommand(configFile)
+	buildCmd := newBuildCommand(configFile)
 	flag.Parse()
+	switch flag.Arg(0) {
+	case "doctor":
+		*validate = true
+		flag.CommandLine.Parse(flag.Args()[1:])
+	case "sign":
+		signCmd.flags.Parse(flag.Args()[1:])
+	case "record":
+		if err := recordCmd.parse(flag.Args()[1:]); err != nil {
+			log.Fatal("zipperfly record: ", err)
+		}
+	case "build":
+		buildCmd.flags.Parse(flag.Args()[1:])
+	}
 
-	// Load environment variables from file
-	loadEnvFile(*configFile)
+	// Load environment variables from file, noting which were set before so
+	// a config reload leaves them alone
+	protected := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		protected[name] = true
+	}
+	loadedConfigFile := loadEnvFile(*configFile)
+	fileVars := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		if name, _, _ := strings.Cut(kv, "="); !protected[name] {
+			fileVars[name] = true
+		}
+	}
+
+	if *validate {
+		if !runDoctor(context.Background(), os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
+	if signCmd.flags.Parsed() {
+		if err := signCmd.run(context.Background(), os.Stdout); err != nil {
+			log.Fatal("zipperfly sign: ", err)
+		}
+		return
+	}
+	if recordCmd.flags.Parsed() {
+		if err := recordCmd.run(context.Background(), os.Stdin, os.Stdout); err != nil {
+			log.Fatal("zipperfly record: ", err)
+		}
+		return
+	}
+	if buildCmd.flags.Parsed() {
+		if err := buildCmd.run(context.Background(), os.Stdin, os.Stdout, os.Stderr); err != nil {
+			log.Fatal("zipperfly build: ", err)
+		}
+		return
+	}
 
-	// Initialize logger
-	logger, err := zap.NewProduction()
+	// Initialize logger, at a level a config reload can change
+	logCfg, err := config.LoadLogConfig()
+	if err != nil {
+		log.Fatal("failed to load log config: ", err)
+	}
+	logLevel := zap.NewAtomicLevel()
+	logger, logFile, err := newLogger(logCfg, logLevel)
 	if err != nil {
-		log.Fatal("failed to init logger:", err)
+		log.Fatal("failed to init logger: ", err)
 	}
+	defer logFile.Close()
 	defer logger.Sync()
 
+	build := version.Get()
+	logger.Info("starting zipperfly", zap.String("version", build.Version), zap.String("commit", build.Commit), zap.String("build_date", build.BuildDate))
+
+	ctx := context.Background()
+
+	// Fetch secrets from a secrets manager, exported into the environment so
+	// they're loaded like any other setting
+	secretsCfg, err := config.LoadSecretsConfig()
+	if err != nil {
+		logger.Fatal("failed to load secrets config", zap.Error(err))
+	}
+	secretSource, err := secrets.New(ctx, secretsCfg)
+	if err != nil {
+		logger.Fatal("failed to initialize secrets provider", zap.Error(err))
+	}
+	var secretValues map[string]string
+	if secretSource != nil {
+		if secretValues, err = secretSource.Fetch(ctx); err != nil {
+			logger.Fatal("failed to fetch secrets", zap.Error(err))
+		}
+		if err := secrets.Export(secretValues); err != nil {
+			logger.Fatal("failed to export secrets", zap.Error(err))
+		}
+		for name := range secretValues {
+			protected[name] = true
+		}
+		logger.Info("loaded secrets", zap.String("provider", secretsCfg.Provider), zap.Int("count", len(secretValues)))
+	}
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
 		logger.Fatal("failed to load config", zap.Error(err))
 	}
-
-	ctx := context.Background()
+	logLevel.SetLevel(parseLogLevel(cfg.LogLevel))
 
 	// Initialize metrics
 	m := metrics.New()
 	m.StartRuntimeMetricsCollector()
+	m.StartThroughputCollector()
 
 	// Initialize circuit breakers
-	storageBreaker := circuitbreaker.New("storage", cfg, m)
-	logger.Info("initialized circuit breaker", zap.String("name", "storage"))
+	storageBreaker := circuitbreaker.NewStorage("storage", cfg, m)
+	logger.Info("initialized circuit breaker", zap.String("name", "storage"), zap.String("scope", cfg.CircuitBreakerScope))
+	callbackBreaker := circuitbreaker.New("callback", cfg, m)
+	logger.Info("initialized circuit breaker", zap.String("name", "callback"))
 
 	// Initialize database
 	db, err := database.New(ctx, cfg, m)
@@ -65,35 +167,260 @@ func main() {
 	}
 	logger.Info("initialized storage provider", zap.String("type", cfg.StorageType))
 
-	// Initialize auth verifier
-	verifier := auth.NewVerifier(cfg.SigningSecret, cfg.EnforceSigning, m)
+	// Initialize auth verifier, accepting JWT bearer tokens if a key is configured
+	var jwtVerifier *auth.JWTVerifier
+	if cfg.JWTEnabled() {
+		jwtVerifier, err = auth.NewJWTVerifier(ctx, cfg.JWTSecret, cfg.JWTPublicKey, cfg.JWTJWKSURL, cfg.JWTIssuer, cfg.JWTAudience, cfg.JWTUserClaim)
+		if err != nil {
+			logger.Fatal("failed to initialize JWT verifier", zap.Error(err))
+		}
+		logger.Info("enabled JWT authentication")
+	}
+	verifier := auth.NewVerifier(cfg.SigningSecrets, cfg.EnforceSigning, cfg.MinSignatureVersion, m, jwtVerifier)
+
+	// Initialize access schedule
+	accessSchedule, err := schedule.New(cfg.AccessWindows, cfg.MaintenanceWindows, cfg.AccessTimezone)
+	if err != nil {
+		logger.Fatal("failed to parse access windows", zap.Error(err))
+	}
+
+	// Initialize the callback queue and its dispatcher (CALLBACK_QUEUE=memory
+	// leaves retries to the download handler, in process)
+	var callbackQueue callbacks.Queue
+	switch cfg.CallbackQueue {
+	case "bolt":
+		boltQueue, err := callbacks.NewBoltQueue(cfg.CallbackQueuePath)
+		if err != nil {
+			logger.Fatal("failed to open callback queue", zap.Error(err))
+		}
+		defer boltQueue.Close()
+		callbackQueue = boltQueue
+	case "database":
+		q, ok := db.(callbacks.Queue)
+		if !ok {
+			logger.Fatal("database engine can't hold the callback queue; use CALLBACK_QUEUE=bolt", zap.String("engine", cfg.DBEngine))
+		}
+		callbackQueue = q
+	}
+	if callbackQueue != nil {
+		dispatchCtx, stopDispatch := context.WithCancel(ctx)
+		defer stopDispatch()
+		dispatcher := callbacks.NewDispatcher(callbackQueue, logger, m, callbackBreaker, cfg.CallbackMaxRetries, cfg.CallbackRetryDelay, cfg.CallbackRetryMaxDelay, cfg.CallbackMaxElapsed, cfg.CallbackQueueInterval)
+		go dispatcher.Run(dispatchCtx)
+		logger.Info("started callback dispatcher", zap.String("queue", cfg.CallbackQueue))
+	}
+
+	// Initialize the event bus publisher (EVENT_BUS=none publishes nothing)
+	var publisher events.Publisher
+	if cfg.EventBus != "none" {
+		publisher, err = events.New(ctx, cfg.EventBus, cfg.EventBusURL, cfg.EventTopic)
+		if err != nil {
+			logger.Fatal("failed to connect to event bus", zap.Error(err))
+		}
+		defer publisher.Close()
+		logger.Info("initialized event bus", zap.String("bus", cfg.EventBus), zap.String("topic", cfg.EventTopic))
+	}
+
+	// Report circuit breaker state changes, also on the event bus with
+	// CIRCUIT_BREAKER_EVENTS
+	breakers := &breakerNotifier{logger: logger, metrics: m}
+	if cfg.CircuitBreakerEvents {
+		breakerPublisher, ok := publisher.(events.BreakerPublisher)
+		if !ok {
+			logger.Fatal("event bus can't publish circuit breaker events", zap.String("bus", cfg.EventBus))
+		}
+		breakers.publisher = breakerPublisher
+	}
+	breakers.watch(storageBreaker)
+	breakers.watch(callbackBreaker)
+
+	// Fail over to the secondary storage, through its own breakers, when the
+	// primary fails
+	if failover := cfg.StorageFailover; failover != nil {
+		failoverBreaker := circuitbreaker.NewStorage("storage_failover", failover.Config, m)
+		breakers.watch(failoverBreaker)
+		secondary, err := storage.New(ctx, failover.Config, m, failoverBreaker)
+		if err != nil {
+			logger.Fatal("failed to initialize failover storage provider", zap.Error(err))
+		}
+		storageProvider = storage.NewFailover(storageProvider, secondary, failover.Bucket, m)
+		logger.Info("initialized failover storage provider", zap.String("type", failover.Config.StorageType), zap.Int("mapped_buckets", len(failover.Buckets)))
+	}
+
+	// Initialize rate and concurrency limits, shared by every replica through
+	// Redis when LIMITS_REDIS_URL is set, else kept per process
+	newRate := func(_ string, perSecond float64, burst int) limits.Rate { return limits.NewLocalRate(perSecond, burst) }
+	newSlots := func(_ string, n int) limits.Slots { return limits.NewLocalSlots(n) }
+	if cfg.LimitsRedisURL != "" {
+		shared, err := limits.NewRedis(ctx, cfg.LimitsRedisURL, cfg.LimitsKeyPrefix)
+		if err != nil {
+			logger.Fatal("failed to connect to limits redis", zap.Error(err))
+		}
+		defer shared.Close()
+		newRate, newSlots = shared.Rate, shared.Slots
+		logger.Info("sharing limits through redis", zap.String("prefix", cfg.LimitsKeyPrefix))
+	}
+	// The limits exist even when off (0 = unlimited), so a config reload
+	// can turn them on
+	activeDownloads := newSlots("downloads", cfg.MaxActiveDownloads)
+
+	// Scale the download and fetch limits to memory and backpressure
+	var controller *adaptive.Controller
+	if cfg.AdaptiveConcurrency {
+		controller, err = adaptive.New(cfg.AdaptiveMemoryLimit, cfg.AdaptiveBackpressure, cfg.AdaptiveInterval, logger, m)
+		if err != nil {
+			logger.Fatal("failed to start adaptive concurrency", zap.Error(err))
+		}
+		activeDownloads = controller.Slots(activeDownloads, cfg.MaxActiveDownloads)
+		adaptiveCtx, stopAdaptive := context.WithCancel(ctx)
+		defer stopAdaptive()
+		go controller.Run(adaptiveCtx)
+		logger.Info("started adaptive concurrency", zap.Float64("backpressure", cfg.AdaptiveBackpressure))
+	}
+	perIP := newRate("ip", cfg.RateLimitPerIP, cfg.RateLimitBurst)
+	perRecord := newRate("record", cfg.RateLimitPerRecord, cfg.RateLimitRecordBurst)
+	rateLimiter := handlers.NewRateLimiter(logger, m, perIP, perRecord)
+
+	// Initialize the client IP and country filters
+	var ipFilter *handlers.IPFilter
+	if len(cfg.IPAllowlist) > 0 || len(cfg.IPDenylist) > 0 || cfg.GeoIPDatabase != "" {
+		var countries handlers.CountryLookup
+		if cfg.GeoIPDatabase != "" {
+			geoDB, err := geoip.Open(cfg.GeoIPDatabase)
+			if err != nil {
+				logger.Fatal("failed to open GeoIP database", zap.Error(err))
+			}
+			defer geoDB.Close()
+			countries = geoDB
+		}
+		ipFilter = handlers.NewIPFilter(logger, m, cfg.IPAllowlist, cfg.IPDenylist, countries, cfg.AllowedCountries, cfg.BlockedCountries)
+	}
+
+	// Initialize API key authentication, with keys from API_KEYS or the database
+	var apiKeys *handlers.APIKeyAuth
+	if cfg.APIKeysEnabled() {
+		var keyStore auth.KeyStore = auth.NewStaticKeys(cfg.APIKeys)
+		if cfg.APIKeyStore == "database" {
+			s, ok := db.(auth.KeyStore)
+			if !ok {
+				logger.Fatal("database engine can't hold API keys; use API_KEYS", zap.String("engine", cfg.DBEngine))
+			}
+			keyStore = s
+		}
+		apiKeys = handlers.NewAPIKeyAuth(logger, m, keyStore, cfg.APIKeyRateLimit, newRate)
+		logger.Info("enabled API key authentication", zap.String("store", cfg.APIKeyStore))
+	}
+
+	// Initialize one-time download tokens
+	var downloadTokens *handlers.DownloadTokens
+	if cfg.DownloadTokens {
+		tokenStore, closeStore := newTokenStore(ctx, logger, cfg)
+		defer closeStore()
+		downloadTokens = handlers.NewDownloadTokens(logger, m, verifier, tokenStore, cfg.DownloadTokenTTL, cfg.DownloadTokenOrigins)
+		logger.Info("enabled download tokens", zap.Duration("ttl", cfg.DownloadTokenTTL), zap.Bool("shared", cfg.DownloadTokenRedisURL != ""))
+	}
 
 	// Initialize download handler
-	downloadHandler := handlers.NewHandler(
-		logger,
-		db,
-		storageProvider,
-		verifier,
-		m,
-		cfg.AppendYMD,
-		cfg.SanitizeNames,
-		cfg.IgnoreMissing,
-		cfg.MaxConcurrent,
-		cfg.CallbackMaxRetries,
-		cfg.CallbackRetryDelay,
-		cfg.AllowPasswordProtected,
-		cfg.AllowedExtensions,
-		cfg.BlockedExtensions,
-		cfg.MaxActiveDownloads,
-		cfg.MaxFilesPerRequest,
-		cfg.RateLimitPerIP,
-	)
+	handlerOptions := newHandlerOptions(cfg)
+	handlerOptions.ActiveDownloads = activeDownloads
+	handlerOptions.Adaptive = controller
+	handlerOptions.Schedule = accessSchedule
+	handlerOptions.CallbackQueue = callbackQueue
+	handlerOptions.EventPublisher = publisher
+	handlerOptions.CallbackBreaker = callbackBreaker
+	downloadHandler := handlers.NewDownloadHandler(logger, db, storageProvider, verifier, m, handlerOptions)
 
 	// Initialize health handler
-	healthHandler := handlers.NewHealthHandler(logger, db, storageProvider, m)
+	healthHandler := handlers.NewHealthHandler(logger, db, storageProvider, m, cfg.HealthCacheTTL)
+
+	// Initialize signed URL handler, signing with the current key
+	signHandler := handlers.NewSignHandler(logger, cfg.SigningSecrets[cfg.SigningKeyID], cfg.SigningKeyID, cfg.SignBaseURL(), cfg.SignTTL)
+
+	// Initialize tenant profiles, each with its own database, storage,
+	// signing secret, and limits
+	tenants := make([]server.Tenant, 0, len(cfg.Tenants))
+	downloadHandlers := []*handlers.Handler{downloadHandler}
+	for _, tenant := range cfg.Tenants {
+		tenantDB, err := database.New(ctx, tenant.Config, m)
+		if err != nil {
+			logger.Fatal("failed to initialize tenant database", zap.String("tenant", tenant.Name), zap.Error(err))
+		}
+		defer tenantDB.Close()
+		tenantBreaker := circuitbreaker.NewStorage("storage_"+tenant.Name, tenant.Config, m)
+		breakers.watch(tenantBreaker)
+		tenantStorage, err := storage.New(ctx, tenant.Config, m, tenantBreaker)
+		if err != nil {
+			logger.Fatal("failed to initialize tenant storage provider", zap.String("tenant", tenant.Name), zap.Error(err))
+		}
+		tenantVerifier := auth.NewVerifier(tenant.Config.SigningSecrets, cfg.EnforceSigning, cfg.MinSignatureVersion, m, jwtVerifier)
+
+		opts := handlerOptions
+		opts.AllowedBuckets = tenant.Config.AllowedBuckets
+		opts.MaxFilesPerRequest = tenant.Config.MaxFilesPerRequest
+		opts.MaxFileSize = tenant.Config.MaxFileSize
+		opts.MaxArchiveSize = tenant.Config.MaxArchiveSize
+		tenantHandler := downloadHandler.ForTenant(tenantDB, tenantStorage, tenantVerifier, opts)
+		downloadHandlers = append(downloadHandlers, tenantHandler)
+
+		var tenantTokens *handlers.DownloadTokens
+		if cfg.DownloadTokens {
+			tokenStore, closeStore := newTokenStore(ctx, logger, tenant.Config)
+			defer closeStore()
+			tenantTokens = handlers.NewDownloadTokens(logger, m, tenantVerifier, tokenStore, cfg.DownloadTokenTTL, cfg.DownloadTokenOrigins)
+		}
+
+		tenants = append(tenants, server.Tenant{
+			Name:           tenant.Name,
+			Hosts:          tenant.Hosts,
+			PathPrefix:     tenant.PathPrefix,
+			Download:       tenantHandler,
+			Sign:           handlers.NewSignHandler(logger, tenant.Config.SigningSecrets[tenant.Config.SigningKeyID], tenant.Config.SigningKeyID, tenant.Config.SignBaseURL(), cfg.SignTTL),
+			DownloadTokens: tenantTokens,
+		})
+		logger.Info("initialized tenant", zap.String("tenant", tenant.Name), zap.Strings("hosts", tenant.Hosts), zap.String("path_prefix", tenant.PathPrefix), zap.String("storage", tenant.Config.StorageType))
+	}
+
+	// Refresh secrets in the background, swapping in those that can change
+	// while running
+	if secretSource != nil && secretsCfg.RefreshInterval > 0 {
+		refreshCtx, stopRefresh := context.WithCancel(ctx)
+		defer stopRefresh()
+		go secrets.Watch(refreshCtx, secretSource, secretsCfg.RefreshInterval, secretValues, logger, func(changed []string) {
+			applySecrets(logger, changed, verifier, signHandler, storageProvider)
+		})
+		logger.Info("refreshing secrets", zap.Duration("interval", secretsCfg.RefreshInterval))
+	}
+
+	// Reload tunables on SIGHUP, without dropping downloads in flight
+	reload := &reloader{
+		logger:           logger,
+		metrics:          m,
+		level:            logLevel,
+		configFile:       loadedConfigFile,
+		protected:        protected,
+		fileVars:         fileVars,
+		downloadHandlers: downloadHandlers,
+		activeDownloads:  activeDownloads,
+		perIP:            perIP,
+		perRecord:        perRecord,
+	}
+	reloadCtx, stopReload := context.WithCancel(ctx)
+	defer stopReload()
+	go reload.watch(reloadCtx)
+
+	// Initialize the access log
+	var accessLog *zap.Logger
+	if cfg.AccessLog {
+		accessLog, err = newAccessLogger(cfg.AccessLogOutput)
+		if err != nil {
+			logger.Fatal("failed to open access log", zap.Error(err))
+		}
+		defer accessLog.Sync()
+		logger.Info("enabled access log", zap.String("output", cfg.AccessLogOutput), zap.Float64("sample_rate", cfg.AccessLogSampleRate))
+	}
 
 	// Initialize and start server
-	srv := server.New(logger, cfg, m, downloadHandler, healthHandler)
+	srv := server.New(logger, cfg, m, downloadHandler, healthHandler, signHandler, rateLimiter, ipFilter, apiKeys, downloadTokens, accessLog, tenants)
 	if err := srv.Start(); err != nil {
 		logger.Fatal("failed to start server", zap.Error(err))
 	}
@@ -104,10 +431,137 @@ func main() {
 	}
 }
 
-// loadEnvFile loads environment variables from a file
+// applySecrets reloads the configuration after a secrets refresh and swaps
+// in those that can change while running: the signing secrets and the S3
+// access key. Other changes take effect on restart.
+func applySecrets(logger *zap.Logger, changed []string, verifier *auth.Verifier, signHandler *handlers.SignHandler, storageProvider storage.Provider) {
+	cfg, err := config.Load()
+	if err != nil {
+		logger.Error("refreshed secrets are invalid, keeping the current ones", zap.Error(err))
+		return
+	}
+
+	for _, name := range changed {
+		switch name {
+		case "SIGNING_SECRET":
+			verifier.SetSecrets(cfg.SigningSecrets)
+			signHandler.SetSecret(cfg.SigningSecrets[cfg.SigningKeyID], cfg.SigningKeyID)
+		case "S3_ACCESS_KEY_ID", "S3_SECRET_ACCESS_KEY":
+			setter, ok := storageProvider.(storage.CredentialsSetter)
+			if !ok {
+				logger.Warn("storage provider has no credentials to refresh", zap.String("name", name))
+				continue
+			}
+			if err := setter.SetCredentials(cfg.S3AccessKeyID, cfg.S3SecretAccessKey); err != nil {
+				logger.Error("failed to refresh S3 credentials", zap.Error(err))
+				continue
+			}
+		default:
+			logger.Warn("secret changed; restart to apply it", zap.String("name", name))
+			continue
+		}
+		logger.Info("applied refreshed secret", zap.String("name", name))
+	}
+}
+
+// newHandlerOptions returns the download handler options set by cfg; the
+// server adds its download slots, schedule, callback queue and breaker, and
+// event bus
+func newHandlerOptions(cfg *config.Config) handlers.HandlerOptions {
+	return handlers.HandlerOptions{
+		AppendYMD:              cfg.AppendYMD,
+		SanitizeNames:          cfg.SanitizeNames,
+		IgnoreMissing:          cfg.IgnoreMissing,
+		MaxConcurrent:          cfg.MaxConcurrent,
+		CallbackMaxRetries:     cfg.CallbackMaxRetries,
+		CallbackRetryDelay:     cfg.CallbackRetryDelay,
+		CallbackRetryMaxDelay:  cfg.CallbackRetryMaxDelay,
+		StorageRetryBudget:     cfg.StorageRetryBudget,
+		AllowPasswordProtected: cfg.AllowPasswordProtected,
+		AllowedExtensions:      cfg.AllowedExtensions,
+		BlockedExtensions:      cfg.BlockedExtensions,
+		DownloadQueueLength:    cfg.DownloadQueueLength,
+		DownloadQueueWait:      cfg.DownloadQueueWait,
+		MaxFilesPerRequest:     cfg.MaxFilesPerRequest,
+		AllowedBuckets:         cfg.AllowedBuckets,
+		AuthWebhookURL:         cfg.AuthWebhookURL,
+		AuthWebhookTimeout:     cfg.AuthWebhookTimeout,
+		ZstdLevel:              cfg.ZstdLevel,
+		ZipStoreOnly:           cfg.ZipStoreOnly,
+		ZipStoreExtensions:     cfg.ZipStoreExtensions,
+		CompressionLevel:       cfg.CompressionLevel,
+		ZipEncryption:          cfg.ZipEncryption,
+		ArchiveManifest:        cfg.ArchiveManifest,
+		ManifestFormat:         cfg.ManifestFormat,
+		ExtraFiles:             cfg.ExtraFiles,
+		EntryOrder:             cfg.EntryOrder,
+		SpoolMemoryLimit:       cfg.SpoolMemoryLimit,
+		SpoolDir:               cfg.SpoolDir,
+		FileFetchTimeout:       cfg.FileFetchTimeout,
+		StallTimeout:           cfg.StallTimeout,
+		FlushInterval:          cfg.FlushInterval,
+		AbortOnStreamError:     cfg.AbortOnStreamError,
+		AsyncBuilds:            cfg.AsyncBuilds,
+		StagingDir:             cfg.StagingDir,
+		StagingTTL:             cfg.StagingTTL,
+		SanitizeCharset:        cfg.SanitizeCharset,
+		AllowEmptyRecords:      cfg.AllowEmptyRecords,
+		MaxFileSize:            cfg.MaxFileSize,
+		MaxArchiveSize:         cfg.MaxArchiveSize,
+		ProgressEvents:         cfg.ProgressEvents,
+		DisableCallbacks:       cfg.DisableCallbacks,
+		CallbackStarted:        cfg.CallbackStarted,
+		CallbackProgressBytes:  cfg.CallbackProgressBytes,
+		CallbackFileResults:    cfg.CallbackFileResults,
+		CallbackMaxElapsed:     cfg.CallbackMaxElapsed,
+		DefaultCallbacks:       cfg.DefaultCallbacks,
+		MetricsTenantLabel:     cfg.MetricsTenantLabel,
+		MetricsTenantLimit:     cfg.MetricsTenantLimit,
+		SlowDownloadThreshold:  cfg.SlowDownloadThreshold,
+		LargeDownloadThreshold: cfg.LargeDownloadThreshold,
+		RecordCacheTTL:         cfg.RecordCacheTTL,
+		RecordCacheStale:       cfg.RecordCacheStale,
+		RecordCacheSize:        cfg.RecordCacheSize,
+	}
+}
+
+// newTokenStore creates the download token store: shared by every replica
+// through Redis when DOWNLOAD_TOKEN_REDIS_URL is set, else kept per process.
+// It returns a function that closes it.
+func newTokenStore(ctx context.Context, logger *zap.Logger, cfg *config.Config) (tokens.Store, func()) {
+	if cfg.DownloadTokenRedisURL == "" {
+		return tokens.NewMemory(), func() {}
+	}
+	shared, err := tokens.NewRedis(ctx, cfg.DownloadTokenRedisURL, cfg.DownloadTokenKeyPrefix)
+	if err != nil {
+		logger.Fatal("failed to connect to download token redis", zap.Error(err))
+	}
+	return shared, func() { shared.Close() }
+}
+
+// newAccessLogger creates the access logger, writing JSON to output. It
+// doesn't sample like the production logger does, which would drop all but
+// a few of the identical "request" entries each second; ACCESS_LOG_SAMPLE_RATE
+// applies instead.
+func newAccessLogger(output string) (*zap.Logger, error) {
+	logCfg := zap.NewProductionConfig()
+	logCfg.Sampling = nil
+	logCfg.OutputPaths = []string{output}
+	logCfg.DisableCaller = true
+	logCfg.DisableStacktrace = true
+	logger, err := logCfg.Build()
+	if err != nil {
+		return nil, err
+	}
+	return logger.Named("access"), nil
+}
+
+// loadEnvFile loads environment variables from a file: a YAML or TOML config
+// file by its extension, otherwise a .env file. It returns the file loaded,
+// or "" if none was.
 // Priority: --config flag > CONFIG_FILE env var > .env file
 // Silently continues if file doesn't exist (falls back to OS env vars)
-func loadEnvFile(flagConfigFile string) {
+func loadEnvFile(flagConfigFile string) string {
 	var configFile string
 
 	// 1. Check --config flag
@@ -115,21 +569,31 @@ func loadEnvFile(flagConfigFile string) {
 		configFile = flagConfigFile
 	} else {
 		// 2. Check CONFIG_FILE env var
-		configFile = os.Getenv("CONFIG_FILE")
+		configFile = config.Getenv("CONFIG_FILE")
 	}
 
 	// 3. Try specified file or default to .env
 	if configFile != "" {
 		// User specified a file - fail if it doesn't exist
-		if err := godotenv.Load(configFile); err != nil {
+		var err error
+		switch strings.ToLower(filepath.Ext(configFile)) {
+		case ".yaml", ".yml", ".toml":
+			err = config.LoadFile(configFile)
+		default:
+			err = godotenv.Load(configFile)
+		}
+		if err != nil {
 			log.Fatalf("failed to load config file %s: %v", configFile, err)
 		}
 		log.Printf("loaded config from: %s", configFile)
-	} else {
-		// Try .env but don't error if it doesn't exist
-		if err := godotenv.Load(); err == nil {
-			log.Println("loaded config from: .env")
-		}
-		// Silently continue if .env doesn't exist - will use OS env vars
+		return configFile
+	}
+
+	// Try .env but don't error if it doesn't exist
+	if err := godotenv.Load(); err == nil {
+		log.Println("loaded config from: .env")
+		return ".env"
 	}
+	// Silently continue if .env doesn't exist - will use OS env vars
+	return ""
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -42,6 +43,23 @@ func TestLoadEnvFile_WithConfigEnvVar(t *testing.T) {
 	}
 }
 
+func TestLoadEnvFile_YAML(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte("s3:\n  region: eu-west-1\n"), 0o600); err != nil {
+		t.Fatalf("failed to write temp config file: %v", err)
+	}
+
+	t.Setenv("S3_REGION", "")
+	_ = os.Unsetenv("S3_REGION")
+
+	loadEnvFile(configPath)
+
+	if got := os.Getenv("S3_REGION"); got != "eu-west-1" {
+		t.Fatalf("expected S3_REGION=eu-west-1 from YAML config file, got %q", got)
+	}
+}
+
 func TestLoadEnvFile_DefaultDotEnv(t *testing.T) {
 	// Work in an isolated temp dir so we don't collide with any real .env
 	tmpDir := t.TempDir()
@@ -68,3 +86,28 @@ func TestLoadEnvFile_DefaultDotEnv(t *testing.T) {
 		t.Fatalf("expected DOTENV_FOO=qux from .env, got %q", got)
 	}
 }
+
+func TestNewAccessLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	logger, err := newAccessLogger(path)
+	if err != nil {
+		t.Fatalf("newAccessLogger() error = %v", err)
+	}
+
+	// Identical entries aren't sampled away
+	for i := 0; i < 500; i++ {
+		logger.Info("request")
+	}
+	logger.Sync()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 500 {
+		t.Errorf("access log has %d entries, want 500", lines)
+	}
+	if !strings.Contains(string(data), `"logger":"access"`) {
+		t.Errorf("access log entries aren't named: %s", strings.SplitN(string(data), "\n", 2)[0])
+	}
+}
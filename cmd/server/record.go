@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"zipperfly/internal/config"
+	"zipperfly/internal/database"
+	"zipperfly/internal/metrics"
+	"zipperfly/internal/storage"
+)
+
+// recordCommand creates, shows, and deletes download records in the
+// configured database, so test downloads can be set up without SQL:
+//
+//	zipperfly record create --file record.json
+//	zipperfly record show --id 123
+//	zipperfly record delete --id 123
+type recordCommand struct {
+	flags        *flag.FlagSet
+	action       string
+	file         string
+	id           string
+	tenant       string
+	allowMissing bool
+}
+
+// newRecordCommand creates the record subcommand, whose --config sets
+// configFile
+func newRecordCommand(configFile *string) *recordCommand {
+	c := &recordCommand{flags: flag.NewFlagSet("record", flag.ExitOnError)}
+	c.flags.StringVar(configFile, "config", *configFile, "Path to config file (overrides CONFIG_FILE env var)")
+	c.flags.StringVar(&c.file, "file", "", "create: JSON record to create, - for standard input")
+	c.flags.StringVar(&c.id, "id", "", "Record ID to show or delete; with create, overrides the file's")
+	c.flags.StringVar(&c.tenant, "tenant", "", "Tenant profile whose database and storage to use (default: the base configuration)")
+	c.flags.BoolVar(&c.allowMissing, "allow-missing", false, "create: create the record even if some of its objects aren't in storage")
+	return c
+}
+
+// parse reads the action, create, show, or delete, and its flags from args
+func (c *recordCommand) parse(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: zipperfly record create|show|delete [flags]")
+	}
+	switch c.action = args[0]; c.action {
+	case "create", "show", "delete":
+	default:
+		return fmt.Errorf("unknown action %q: must be create, show, or delete", c.action)
+	}
+	return c.flags.Parse(args[1:])
+}
+
+// run loads the configuration and runs the action, reading a record to
+// create from in when --file is -, and writing its output to w
+func (c *recordCommand) run(ctx context.Context, in io.Reader, w io.Writer) error {
+	switch {
+	case c.action == "create" && c.file == "":
+		return errors.New("--file is required")
+	case c.action != "create" && c.id == "":
+		return errors.New("--id is required")
+	}
+
+	cfg, err := loadCommandConfig(ctx, c.tenant, true)
+	if err != nil {
+		return err
+	}
+	// The command's metrics aren't served, so they're left unregistered
+	m := metrics.NewWithRegistry(nil)
+	db, err := database.New(ctx, cfg, m)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	switch c.action {
+	case "show":
+		record, err := db.GetRecord(ctx, c.id)
+		if database.IsNotFound(err) {
+			return fmt.Errorf("record %q not found", c.id)
+		}
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(record, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case "delete":
+		writer, ok := db.(database.RecordWriter)
+		if !ok {
+			return fmt.Errorf("the %s database can't delete records", cfg.DBEngine)
+		}
+		if err := writer.DeleteRecord(ctx, c.id); err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "deleted record %s\n", c.id)
+		return err
+	default:
+		writer, ok := db.(database.RecordWriter)
+		if !ok {
+			return fmt.Errorf("the %s database can't create records", cfg.DBEngine)
+		}
+		return c.create(ctx, cfg, m, writer, in, w)
+	}
+}
+
+// create reads the record, checks that its objects exist in storage, and
+// writes it
+func (c *recordCommand) create(ctx context.Context, cfg *config.Config, m *metrics.Metrics, writer database.RecordWriter, in io.Reader, w io.Writer) error {
+	record, err := readRecordFile(c.file, in)
+	if err != nil {
+		return err
+	}
+	if c.id != "" {
+		record.ID = c.id
+	}
+	if !storage.BucketAllowed(cfg.AllowedBuckets, record.Bucket) {
+		return fmt.Errorf("bucket %q is not in ALLOWED_BUCKETS", record.Bucket)
+	}
+
+	provider, err := newCommandStorage(ctx, cfg, m)
+	if err != nil {
+		return err
+	}
+	infos, errs := storage.StatAll(ctx, provider, record.Bucket, record.Objects, int(cfg.MaxConcurrent))
+	var missing []string
+	var size int64
+	for i, err := range errs {
+		switch {
+		case storage.IsNotFound(err):
+			missing = append(missing, record.Objects[i])
+		case err != nil:
+			return fmt.Errorf("failed to check %s: %w", record.Objects[i], err)
+		case infos[i].Size > 0:
+			size += infos[i].Size
+		}
+	}
+	if len(missing) > 0 {
+		if !c.allowMissing {
+			return fmt.Errorf("%d objects not found in bucket %q: %s", len(missing), record.Bucket, strings.Join(missing, ", "))
+		}
+		fmt.Fprintf(w, "warning: %d objects not found in bucket %q: %s\n", len(missing), record.Bucket, strings.Join(missing, ", "))
+	}
+
+	if err := writer.CreateRecord(ctx, record); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "created record %s: %d objects, %d bytes\n", record.ID, len(record.Objects)-len(missing), size)
+	return err
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"zipperfly/internal/config"
+	"zipperfly/internal/metrics"
+	"zipperfly/internal/models"
+)
+
+// fakeRecordWriter keeps created records in memory
+type fakeRecordWriter struct {
+	records map[string]*models.DownloadRecord
+}
+
+func (f *fakeRecordWriter) CreateRecord(ctx context.Context, record *models.DownloadRecord) error {
+	f.records[record.ID] = record
+	return nil
+}
+
+func (f *fakeRecordWriter) UpdateRecord(ctx context.Context, record *models.DownloadRecord) error {
+	f.records[record.ID] = record
+	return nil
+}
+
+func (f *fakeRecordWriter) DeleteRecord(ctx context.Context, id string) error {
+	delete(f.records, id)
+	return nil
+}
+
+func TestRecordCommand_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "create", args: []string{"create", "--file", "record.json"}},
+		{name: "show", args: []string{"show", "--id", "abc"}},
+		{name: "no action", args: []string{}, wantErr: true},
+		{name: "unknown action", args: []string{"update", "--id", "abc"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configFile string
+			err := newRecordCommand(&configFile).parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecordCommand_Create(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "assets"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "assets", "2024"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "2024/a.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, "assets", name), []byte("hello"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.Config{
+		StorageType:             "local",
+		StoragePath:             dir,
+		StorageFetchTimeout:     time.Second,
+		MaxConcurrent:           4,
+		CircuitBreakerThreshold: 5,
+		CircuitBreakerTimeout:   time.Minute,
+	}
+
+	tests := []struct {
+		name         string
+		record       string
+		id           string
+		allowMissing bool
+		allowed      []string
+		wantID       string
+		wantErr      string
+		wantOut      string
+	}{
+		{name: "objects exist", record: `{"id": "qa-1", "bucket": "assets", "objects": ["a.txt"]}`, wantID: "qa-1", wantOut: "created record qa-1: 1 objects, 5 bytes"},
+		{name: "id override", record: `{"id": "qa-1", "bucket": "assets", "objects": ["a.txt"]}`, id: "qa-2", wantID: "qa-2"},
+		{name: "missing object", record: `{"id": "qa-1", "bucket": "assets", "objects": ["a.txt", "missing.txt"]}`, wantErr: "1 objects not found in bucket \"assets\": missing.txt"},
+		{name: "missing object allowed", record: `{"id": "qa-1", "bucket": "assets", "objects": ["a.txt", "missing.txt"]}`, allowMissing: true, wantID: "qa-1", wantOut: "warning: 1 objects not found"},
+		{name: "bucket not allowed", record: `{"id": "qa-1", "bucket": "assets", "objects": ["a.txt"]}`, allowed: []string{"other"}, wantErr: "ALLOWED_BUCKETS"},
+		{name: "path beneath allowed bucket", record: `{"id": "qa-1", "bucket": "assets/2024", "objects": ["a.txt"]}`, allowed: []string{"assets"}, wantID: "qa-1"},
+		{name: "parent segment", record: `{"id": "qa-1", "bucket": "other/../assets", "objects": ["a.txt"]}`, allowed: []string{"other"}, wantErr: "ALLOWED_BUCKETS"},
+		{name: "unknown field", record: `{"id": "qa-1", "bucket": "assets", "object": ["a.txt"]}`, wantErr: "unknown field"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := *cfg
+			cfg.AllowedBuckets = tt.allowed
+			cmd := &recordCommand{action: "create", file: "-", id: tt.id, allowMissing: tt.allowMissing}
+			writer := &fakeRecordWriter{records: make(map[string]*models.DownloadRecord)}
+
+			var out bytes.Buffer
+			err := cmd.create(context.Background(), &cfg, metrics.NewWithRegistry(nil), writer, strings.NewReader(tt.record), &out)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("create() error = %v, want %q", err, tt.wantErr)
+				}
+				if len(writer.records) > 0 {
+					t.Error("create() wrote the record despite failing")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("create() error = %v", err)
+			}
+			if writer.records[tt.wantID] == nil {
+				t.Errorf("create() wrote %v, want record %q", writer.records, tt.wantID)
+			}
+			if !strings.Contains(out.String(), tt.wantOut) {
+				t.Errorf("create() printed %q, want %q", out.String(), tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestRecordCommand(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping redis test in short mode")
+	}
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "assets"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "assets", "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DB_URL", "redis://localhost:6379/0")
+	t.Setenv("KEY_PREFIX", "test-record-command:")
+	t.Setenv("ENABLE_HTTPS", "false")
+	t.Setenv("STORAGE_TYPE", "local")
+	t.Setenv("STORAGE_PATH", dir)
+
+	run := func(args []string, stdin string) (string, error) {
+		var configFile string
+		cmd := newRecordCommand(&configFile)
+		if err := cmd.parse(args); err != nil {
+			t.Fatalf("parse(%q) error = %v", args, err)
+		}
+		var out bytes.Buffer
+		err := cmd.run(context.Background(), strings.NewReader(stdin), &out)
+		return out.String(), err
+	}
+
+	if _, err := run([]string{"show", "--id", "probe"}, ""); err != nil && strings.Contains(err.Error(), "failed to initialize database") {
+		t.Skipf("Redis not available: %v", err)
+	}
+	run([]string{"delete", "--id", "qa-1"}, "")
+
+	if _, err := run([]string{"create", "--file", "-"}, `{"id": "qa-1", "bucket": "assets", "objects": ["a.txt", "missing.txt"]}`); err == nil || !strings.Contains(err.Error(), "missing.txt") {
+		t.Fatalf("create with a missing object error = %v, want it listed", err)
+	}
+	if _, err := run([]string{"show", "--id", "qa-1"}, ""); err == nil {
+		t.Fatal("record with a missing object was created")
+	}
+
+	out, err := run([]string{"create", "--file", "-"}, `{"id": "qa-1", "bucket": "assets", "objects": ["a.txt"]}`)
+	if err != nil {
+		t.Fatalf("create error = %v", err)
+	}
+	if want := "created record qa-1: 1 objects, 5 bytes"; !strings.Contains(out, want) {
+		t.Errorf("create printed %q, want %q", out, want)
+	}
+	if out, err = run([]string{"show", "--id", "qa-1"}, ""); err != nil || !strings.Contains(out, `"a.txt"`) {
+		t.Errorf("show = %q, %v, want the record", out, err)
+	}
+	if _, err := run([]string{"delete", "--id", "qa-1"}, ""); err != nil {
+		t.Errorf("delete error = %v", err)
+	}
+	if _, err := run([]string{"show", "--id", "qa-1"}, ""); err == nil {
+		t.Error("show after delete succeeded")
+	}
+}
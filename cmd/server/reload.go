@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/joho/godotenv"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"zipperfly/internal/config"
+	"zipperfly/internal/handlers"
+	"zipperfly/internal/limits"
+	"zipperfly/internal/metrics"
+)
+
+// reloader applies config changes on SIGHUP without a restart, so
+// downloads in flight aren't dropped. Only the tunables it holds change:
+// LOG_LEVEL, the rate limits, MAX_ACTIVE_DOWNLOADS, and the extension
+// filters. Other settings take effect on restart.
+type reloader struct {
+	logger           *zap.Logger
+	metrics          *metrics.Metrics
+	level            zap.AtomicLevel
+	configFile       string              // re-read on reload, "" = none
+	protected        map[string]bool     // variables the config file doesn't override: the environment's and fetched secrets
+	fileVars         map[string]bool     // variables the config file set
+	downloadHandlers []*handlers.Handler // the default one and every tenant profile's
+	activeDownloads  limits.Slots
+	perIP            limits.Rate
+	perRecord        limits.Rate
+}
+
+// watch reloads the config on every SIGHUP until ctx is done
+func (r *reloader) watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reload()
+		}
+	}
+}
+
+// reload re-reads the config file into the environment and applies the
+// tunables. If the file or the resulting config is invalid, the environment
+// is restored and the current config kept.
+func (r *reloader) reload() {
+	restore, err := r.readConfigFile()
+	if err != nil {
+		r.metrics.ConfigReloadsTotal.WithLabelValues("failure").Inc()
+		r.logger.Error("failed to reload config file, keeping the current config", zap.String("file", r.configFile), zap.Error(err))
+		return
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		restore()
+		r.metrics.ConfigReloadsTotal.WithLabelValues("failure").Inc()
+		r.logger.Error("reloaded config is invalid, keeping the current one", zap.Error(err))
+		return
+	}
+
+	r.level.SetLevel(parseLogLevel(cfg.LogLevel))
+	r.perIP.SetLimit(cfg.RateLimitPerIP, cfg.RateLimitBurst)
+	r.perRecord.SetLimit(cfg.RateLimitPerRecord, cfg.RateLimitRecordBurst)
+	r.activeDownloads.SetLimit(cfg.MaxActiveDownloads)
+	for _, h := range r.downloadHandlers {
+		h.SetExtensionFilters(cfg.AllowedExtensions, cfg.BlockedExtensions)
+	}
+
+	r.metrics.ConfigReloadsTotal.WithLabelValues("success").Inc()
+	r.logger.Info("reloaded config",
+		zap.String("log_level", cfg.LogLevel),
+		zap.Float64("rate_limit_per_ip", cfg.RateLimitPerIP),
+		zap.Float64("rate_limit_per_record", cfg.RateLimitPerRecord),
+		zap.Int("max_active_downloads", cfg.MaxActiveDownloads),
+		zap.Strings("allowed_extensions", cfg.AllowedExtensions),
+		zap.Strings("blocked_extensions", cfg.BlockedExtensions),
+	)
+}
+
+// readConfigFile sets the variables in the config file, except protected
+// ones, and unsets those no longer in it. It returns a function that undoes
+// the changes.
+func (r *reloader) readConfigFile() (restore func(), err error) {
+	if r.configFile == "" {
+		return func() {}, nil
+	}
+	var values map[string]string
+	switch strings.ToLower(filepath.Ext(r.configFile)) {
+	case ".yaml", ".yml", ".toml":
+		values, err = config.ReadFile(r.configFile)
+	default:
+		values, err = godotenv.Read(r.configFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	previous := make(map[string]*string)
+	save := func(name string) {
+		if _, ok := previous[name]; ok {
+			return
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			previous[name] = &v
+		} else {
+			previous[name] = nil
+		}
+	}
+	fileVars := make(map[string]bool, len(values))
+	for name, value := range values {
+		if !r.protected[name] {
+			save(name)
+			os.Setenv(name, value)
+			fileVars[name] = true
+		}
+	}
+	for name := range r.fileVars {
+		if !fileVars[name] {
+			save(name)
+			os.Unsetenv(name)
+		}
+	}
+	oldFileVars := r.fileVars
+	r.fileVars = fileVars
+
+	return func() {
+		for name, v := range previous {
+			if v == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *v)
+			}
+		}
+		r.fileVars = oldFileVars
+	}, nil
+}
+
+// parseLogLevel converts a validated LOG_LEVEL to a zap level
+func parseLogLevel(level string) zapcore.Level {
+	l, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return zapcore.InfoLevel
+	}
+	return l
+}
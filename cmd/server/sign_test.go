@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"zipperfly/sign"
+)
+
+func TestSignCommand(t *testing.T) {
+	t.Setenv("DB_URL", "")
+	t.Setenv("ENABLE_HTTPS", "false")
+	t.Setenv("SIGNING_SECRET", "base-secret")
+	t.Setenv("PUBLIC_URL", "https://files.example.com")
+	t.Setenv("TENANTS", "acme")
+	t.Setenv("TENANT_ACME_HOSTS", "files.acme.com")
+	t.Setenv("TENANT_ACME_SIGNING_SECRET", "acme-secret")
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantPrefix string
+		secret     string
+		wantTTL    time.Duration
+		wantErr    bool
+	}{
+		{name: "default expiry", args: []string{"--id", "abc"}, wantPrefix: "https://files.example.com/abc?", secret: "base-secret", wantTTL: time.Hour},
+		{name: "expiry", args: []string{"--id", "abc", "--expiry", "15m", "--files", "a.txt, b.txt"}, wantPrefix: "https://files.example.com/abc?", secret: "base-secret", wantTTL: 15 * time.Minute},
+		{name: "tenant", args: []string{"--id", "abc", "--tenant", "ACME", "--base-url", "https://files.acme.com"}, wantPrefix: "https://files.acme.com/abc?", secret: "acme-secret", wantTTL: time.Hour},
+		{name: "missing id", args: []string{}, wantErr: true},
+		{name: "unknown tenant", args: []string{"--id", "abc", "--tenant", "globex"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configFile string
+			cmd := newSignCommand(&configFile)
+			if err := cmd.flags.Parse(tt.args); err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			var out bytes.Buffer
+			err := cmd.run(context.Background(), &out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			link := strings.TrimSpace(out.String())
+			if !strings.HasPrefix(link, tt.wantPrefix) {
+				t.Fatalf("URL = %q, want prefix %q", link, tt.wantPrefix)
+			}
+			u, err := url.Parse(link)
+			if err != nil {
+				t.Fatalf("invalid URL %q: %v", link, err)
+			}
+			query := u.Query()
+			expiry, _ := strconv.ParseInt(query.Get("expiry"), 10, 64)
+			if ttl := time.Until(time.Unix(expiry, 0)); ttl > tt.wantTTL || ttl < tt.wantTTL-time.Minute {
+				t.Errorf("link expires in %s, want %s", ttl, tt.wantTTL)
+			}
+			want := sign.SignatureV2([]byte(tt.secret), sign.Params{ID: "abc", Expiry: query.Get("expiry"), Files: query.Get("files")})
+			if got := query.Get("signature"); got != want {
+				t.Errorf("signature = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -10,6 +10,19 @@ CREATE TABLE IF NOT EXISTS downloads (
     callback TEXT,
     password TEXT,
     custom_headers JSONB,
+    download_count INTEGER NOT NULL DEFAULT 0,
+    max_downloads INTEGER,
+    store_only BOOLEAN,
+    compression_level SMALLINT,
+    encryption TEXT,
+    manifest BOOLEAN,
+    extra_files JSONB,
+    object_sizes JSONB,
+    format TEXT,
+    store_extensions JSONB,
+    object_metadata JSONB,
+    directories JSONB,
+    raw BOOLEAN,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
@@ -17,6 +30,14 @@ CREATE TABLE IF NOT EXISTS downloads (
 -- Create index on created_at for cleanup queries
 CREATE INDEX IF NOT EXISTS idx_downloads_created_at ON downloads(created_at);
 
+-- Callback queue for CALLBACK_QUEUE=database (CALLBACK_QUEUE_TABLE)
+CREATE TABLE IF NOT EXISTS callback_queue (
+    id VARCHAR(64) PRIMARY KEY,
+    next_attempt BIGINT NOT NULL, -- unix milliseconds
+    job TEXT NOT NULL             -- callback, payload, and attempts as JSON
+);
+CREATE INDEX IF NOT EXISTS idx_callback_queue_next_attempt ON callback_queue(next_attempt);
+
 -- Example: Insert a test record (optional - remove in production)
 -- INSERT INTO downloads (id, bucket, objects, name)
 -- VALUES (
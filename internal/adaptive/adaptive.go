@@ -0,0 +1,202 @@
+// Package adaptive scales concurrency to memory and write backpressure, so
+// a burst of huge archives or slow clients slows new work down instead of
+// growing the heap until the process is killed.
+package adaptive
+
+import (
+	"context"
+	"errors"
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/limits"
+	zmetrics "zipperfly/internal/metrics"
+)
+
+const (
+	highWater = 0.9 // share of the memory limit above which the scale is cut
+	lowWater  = 0.7 // share below which it is raised again
+	decrease  = 0.7 // factor the scale is cut by
+	increase  = 0.1 // step the scale is raised by
+	minScale  = 0.1 // lowest scale, so work never stops altogether
+)
+
+// heapSample is the runtime metric read for the heap in use
+const heapSample = "/memory/classes/heap/objects:bytes"
+
+// Controller holds a scale from 0.1 to 1 that limits are multiplied by.
+// Every interval it compares the heap to the memory limit, and the time
+// streaming downloads spent blocked writing to clients to the time they
+// streamed: when either is high the scale is cut by 30%, and when both are
+// low it is raised by 0.1, so limits shrink fast and recover gradually.
+type Controller struct {
+	memoryLimit  uint64
+	backpressure float64
+	interval     time.Duration
+	logger       *zap.Logger
+	metrics      *zmetrics.Metrics
+	heap         func() uint64 // bytes of heap in use, replaced in tests
+
+	scale   atomic.Uint64 // math.Float64bits of the scale
+	streams atomic.Int64  // responses streaming
+	blocked atomic.Int64  // nanoseconds spent in client writes since the last adjustment
+
+	mu    sync.Mutex
+	slots []*scaledSlots
+}
+
+// New creates a controller keeping the heap under memoryLimit bytes, or
+// under GOMEMLIMIT if memoryLimit is 0. backpressure is the share of
+// streaming time blocked on client writes that counts as high.
+func New(memoryLimit int64, backpressure float64, interval time.Duration, logger *zap.Logger, m *zmetrics.Metrics) (*Controller, error) {
+	if memoryLimit == 0 {
+		memoryLimit = debug.SetMemoryLimit(-1)
+		if memoryLimit == math.MaxInt64 {
+			return nil, errors.New("adaptive concurrency needs ADAPTIVE_MEMORY_LIMIT or GOMEMLIMIT")
+		}
+	}
+
+	c := &Controller{
+		memoryLimit:  uint64(memoryLimit),
+		backpressure: backpressure,
+		interval:     interval,
+		logger:       logger,
+		metrics:      m,
+		heap:         readHeap,
+	}
+	c.scale.Store(math.Float64bits(1))
+	m.AdaptiveScale.Set(1)
+	return c, nil
+}
+
+// readHeap returns the bytes of heap occupied by objects, live or not yet
+// swept
+func readHeap() uint64 {
+	sample := []metrics.Sample{{Name: heapSample}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
+
+// Run adjusts the scale every interval until ctx is done
+func (c *Controller) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.adjust()
+		}
+	}
+}
+
+// adjust moves the scale for the memory and backpressure seen since the
+// last call, and applies it to the wrapped slots if it changed
+func (c *Controller) adjust() {
+	memory := float64(c.heap()) / float64(c.memoryLimit)
+	blocked := time.Duration(c.blocked.Swap(0))
+	backpressure := 0.0
+	if streams := c.streams.Load(); streams > 0 {
+		backpressure = min(1, blocked.Seconds()/(c.interval.Seconds()*float64(streams)))
+	}
+
+	previous := c.Scale()
+	scale := previous
+	switch {
+	case memory > highWater:
+		scale = max(minScale, previous*decrease)
+		c.metrics.AdaptiveReductions.WithLabelValues("memory").Inc()
+	case backpressure > c.backpressure:
+		scale = max(minScale, previous*decrease)
+		c.metrics.AdaptiveReductions.WithLabelValues("backpressure").Inc()
+	case memory < lowWater:
+		scale = min(1, previous+increase)
+	}
+	if scale == previous {
+		return
+	}
+
+	c.scale.Store(math.Float64bits(scale))
+	c.metrics.AdaptiveScale.Set(scale)
+	c.mu.Lock()
+	for _, s := range c.slots {
+		s.apply()
+	}
+	c.mu.Unlock()
+	c.logger.Info("adjusted concurrency",
+		zap.Float64("scale", scale),
+		zap.Float64("memory", memory),
+		zap.Float64("backpressure", backpressure),
+	)
+}
+
+// Scale returns the current scale
+func (c *Controller) Scale() float64 {
+	return math.Float64frombits(c.scale.Load())
+}
+
+// Limit returns limit n at the current scale, at least 1; n <= 0, no
+// limit, is returned as is
+func (c *Controller) Limit(n int64) int64 {
+	if n <= 0 {
+		return n
+	}
+	return max(1, int64(math.Ceil(float64(n)*c.Scale())))
+}
+
+// Stream counts a response as streaming until the returned function is
+// called, for the backpressure share
+func (c *Controller) Stream() (done func()) {
+	c.streams.Add(1)
+	var once sync.Once
+	return func() { once.Do(func() { c.streams.Add(-1) }) }
+}
+
+// Blocked records time a streaming response spent writing to its client
+func (c *Controller) Blocked(d time.Duration) {
+	c.blocked.Add(int64(d))
+}
+
+// Slots wraps slots so their limit follows the scale. SetLimit on the
+// result sets the limit at full scale, e.g. on a config reload.
+func (c *Controller) Slots(slots limits.Slots, n int) limits.Slots {
+	s := &scaledSlots{Slots: slots, controller: c, max: n}
+	s.apply()
+	c.mu.Lock()
+	c.slots = append(c.slots, s)
+	c.mu.Unlock()
+	return s
+}
+
+// scaledSlots is Slots whose limit is the controller's scale of max
+type scaledSlots struct {
+	limits.Slots
+	controller *Controller
+
+	mu  sync.Mutex
+	max int // the limit at full scale, <= 0 = unlimited
+}
+
+// SetLimit implements limits.Slots
+func (s *scaledSlots) SetLimit(n int) {
+	s.mu.Lock()
+	s.max = n
+	s.mu.Unlock()
+	s.apply()
+}
+
+func (s *scaledSlots) apply() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Slots.SetLimit(int(s.controller.Limit(int64(s.max))))
+}
@@ -0,0 +1,135 @@
+package adaptive
+
+import (
+	"context"
+	"math"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/limits"
+	"zipperfly/internal/metrics"
+)
+
+func newTestController(t *testing.T, heap *uint64) *Controller {
+	t.Helper()
+	c, err := New(1000, 0.9, time.Second, zap.NewNop(), metrics.NewWithRegistry(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	c.heap = func() uint64 { return *heap }
+	return c
+}
+
+func TestController_Adjust(t *testing.T) {
+	tests := []struct {
+		name      string
+		heap      []uint64 // heap at each adjustment, of a 1000 byte limit
+		wantScale float64
+	}{
+		{name: "below the limit", heap: []uint64{500, 500}, wantScale: 1},
+		{name: "over high water", heap: []uint64{950}, wantScale: 0.7},
+		{name: "keeps cutting", heap: []uint64{950, 950, 950}, wantScale: 0.343},
+		{name: "floor", heap: []uint64{950, 950, 950, 950, 950, 950, 950, 950}, wantScale: minScale},
+		{name: "holds between the marks", heap: []uint64{950, 800}, wantScale: 0.7},
+		{name: "recovers gradually", heap: []uint64{950, 950, 500}, wantScale: 0.59},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var heap uint64
+			c := newTestController(t, &heap)
+			for _, h := range tt.heap {
+				heap = h
+				c.adjust()
+			}
+			if got := c.Scale(); math.Abs(got-tt.wantScale) > 1e-9 {
+				t.Errorf("Scale() = %v, want %v", got, tt.wantScale)
+			}
+		})
+	}
+}
+
+func TestController_Backpressure(t *testing.T) {
+	heap := uint64(100)
+	c := newTestController(t, &heap)
+
+	// Two streams blocked for most of the second
+	done := c.Stream()
+	defer done()
+	defer c.Stream()()
+	c.Blocked(1900 * time.Millisecond)
+	c.adjust()
+	if got := c.Scale(); got != 0.7 {
+		t.Fatalf("Scale() under backpressure = %v, want 0.7", got)
+	}
+
+	// Writing freely again
+	c.Blocked(100 * time.Millisecond)
+	c.adjust()
+	if got := c.Scale(); math.Abs(got-0.8) > 1e-9 {
+		t.Errorf("Scale() after backpressure = %v, want 0.8", got)
+	}
+}
+
+func TestController_Limits(t *testing.T) {
+	heap := uint64(950)
+	c := newTestController(t, &heap)
+	slots := c.Slots(limits.NewLocalSlots(10), 10)
+
+	acquire := func() int {
+		var taken int
+		for {
+			release, ok, _ := slots.TryAcquire(context.Background())
+			if !ok {
+				return taken
+			}
+			defer release()
+			taken++
+		}
+	}
+
+	if got := acquire(); got != 10 {
+		t.Errorf("slots at full scale = %d, want 10", got)
+	}
+	c.adjust()
+	if got := acquire(); got != 7 {
+		t.Errorf("slots at 0.7 = %d, want 7", got)
+	}
+	slots.SetLimit(20)
+	if got := acquire(); got != 14 {
+		t.Errorf("slots at 0.7 after SetLimit(20) = %d, want 14", got)
+	}
+
+	if got := c.Limit(3); got != 3 {
+		t.Errorf("Limit(3) at 0.7 = %d, want 3", got)
+	}
+	if got := c.Limit(1); got != 1 {
+		t.Errorf("Limit(1) at 0.7 = %d, want 1", got)
+	}
+	if got := c.Limit(0); got != 0 {
+		t.Errorf("Limit(0) = %d, want 0 (unlimited)", got)
+	}
+}
+
+func TestNew_MemoryLimit(t *testing.T) {
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	if debug.SetMemoryLimit(-1) == math.MaxInt64 {
+		if _, err := New(0, 0.9, time.Second, zap.NewNop(), m); err == nil {
+			t.Error("New() without ADAPTIVE_MEMORY_LIMIT or GOMEMLIMIT succeeded")
+		}
+	}
+
+	previous := debug.SetMemoryLimit(1 << 30)
+	defer debug.SetMemoryLimit(previous)
+	c, err := New(0, 0.9, time.Second, zap.NewNop(), m)
+	if err != nil {
+		t.Fatalf("New() with GOMEMLIMIT error = %v", err)
+	}
+	if c.memoryLimit != 1<<30 {
+		t.Errorf("memory limit = %d, want GOMEMLIMIT %d", c.memoryLimit, 1<<30)
+	}
+}
@@ -0,0 +1,182 @@
+package archive
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Format identifies an output archive format
+type Format string
+
+const (
+	FormatZip    Format = "zip"
+	FormatTar    Format = "tar"
+	FormatTarGz  Format = "tar.gz"
+	FormatTarZst Format = "tar.zst"
+)
+
+// ParseFormat parses a format name; empty means ZIP
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(s, ".")) {
+	case "", "zip":
+		return FormatZip, nil
+	case "tar":
+		return FormatTar, nil
+	case "tar.gz", "tgz":
+		return FormatTarGz, nil
+	case "tar.zst", "tzst":
+		return FormatTarZst, nil
+	default:
+		return "", fmt.Errorf("unsupported archive format %q", s)
+	}
+}
+
+// Extension returns the file extension for the format, including the leading dot
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// ContentType returns the MIME type for the format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatTar:
+		return "application/x-tar"
+	case FormatTarGz:
+		return "application/gzip"
+	case FormatTarZst:
+		return "application/zstd"
+	default:
+		return "application/zip"
+	}
+}
+
+// SupportsPassword reports whether the format can be password protected
+func (f Format) SupportsPassword() bool {
+	return f == FormatZip
+}
+
+// Encryption identifies the encryption method for password-protected ZIPs
+type Encryption string
+
+const (
+	// EncryptionZipCrypto is the legacy PKWARE method: readable everywhere, but weak
+	EncryptionZipCrypto Encryption = "zipcrypto"
+	// EncryptionAES256 is WinZip AES-256; needs 7-Zip, WinZip, or a similar tool to open
+	EncryptionAES256 Encryption = "aes256"
+)
+
+// Entry describes a file added to an archive
+type Entry struct {
+	Name    string
+	Size    int64       // -1 if unknown
+	ModTime time.Time   // zero = unknown
+	Mode    fs.FileMode // permission bits, 0 = 0644
+}
+
+// Writer writes entries to an archive stream. It is not safe for concurrent use.
+type Writer interface {
+	// AddFile copies r into the archive as a new entry and returns the bytes read
+	AddFile(entry Entry, r io.Reader) (int64, error)
+	// AddDirectory adds an empty directory entry; Size is ignored
+	AddDirectory(entry Entry) error
+	// Close finishes the archive; it does not close the underlying writer
+	Close() error
+}
+
+// Zip64Reporter is implemented by ZIP writers. Zip64 reports whether the
+// archive needed Zip64 records (over 4 GiB or 65,535 entries); call it after Close.
+type Zip64Reporter interface {
+	Zip64() bool
+}
+
+// Commenter is implemented by writers that can embed an archive comment
+// (unencrypted ZIP). SetComment must be called before Close.
+type Commenter interface {
+	SetComment(comment string) error
+}
+
+// Permissions of file and directory entries without a mode
+const (
+	defaultMode    fs.FileMode = 0o644
+	defaultDirMode fs.FileMode = 0o755
+)
+
+func (e Entry) mode() fs.FileMode {
+	if e.Mode == 0 {
+		return defaultMode
+	}
+	return e.Mode.Perm()
+}
+
+func (e Entry) dirMode() fs.FileMode {
+	if e.Mode == 0 {
+		return defaultDirMode
+	}
+	return e.Mode.Perm()
+}
+
+// dirName returns name with a single trailing slash, as archives name directories
+func dirName(name string) string {
+	return strings.TrimRight(name, "/") + "/"
+}
+
+// Options configures a Writer
+type Options struct {
+	Password         string     // ZIP only; ignored by formats without encryption
+	Encryption       Encryption // ZIP only; method used with Password, "" = ZipCrypto
+	CompressionLevel int        // Deflate level (1-9) for ZIP and tar.gz, 0 = library default
+	ZstdLevel        int        // zstd level (1-22) for tar.zst, 0 = library default
+	StoreOnly        bool       // ZIP only; write every entry uncompressed
+	StoreExtensions  []string   // ZIP only; extensions (e.g. ".jpg") written uncompressed
+}
+
+// NewWriter returns a Writer producing the given format on w
+func NewWriter(format Format, w io.Writer, opts Options) (Writer, error) {
+	if opts.CompressionLevel < 0 || opts.CompressionLevel > 9 {
+		return nil, fmt.Errorf("invalid compression level %d: must be between 0 and 9", opts.CompressionLevel)
+	}
+
+	switch opts.Encryption {
+	case "", EncryptionZipCrypto, EncryptionAES256:
+	default:
+		return nil, fmt.Errorf("unsupported encryption %q", opts.Encryption)
+	}
+
+	switch format {
+	case FormatZip:
+		if opts.Password != "" {
+			return newEncryptedZipWriter(w, opts), nil
+		}
+		return newZipWriter(w, opts), nil
+	case FormatTar:
+		return newTarWriter(w, nil), nil
+	case FormatTarGz:
+		level := gzip.DefaultCompression
+		if opts.CompressionLevel != 0 {
+			level = opts.CompressionLevel
+		}
+		gz, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		return newTarWriter(gz, gz), nil
+	case FormatTarZst:
+		var encOpts []zstd.EOption
+		if opts.ZstdLevel > 0 {
+			encOpts = append(encOpts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(opts.ZstdLevel)))
+		}
+		zw, err := zstd.NewWriter(w, encOpts...)
+		if err != nil {
+			return nil, err
+		}
+		return newTarWriter(zw, zw), nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format %q", format)
+	}
+}
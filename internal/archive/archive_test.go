@@ -0,0 +1,493 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/yeka/zip"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Format
+		wantErr bool
+	}{
+		{input: "", want: FormatZip},
+		{input: "zip", want: FormatZip},
+		{input: "TAR", want: FormatTar},
+		{input: "tar.gz", want: FormatTarGz},
+		{input: ".tgz", want: FormatTarGz},
+		{input: "tar.zst", want: FormatTarZst},
+		{input: "tzst", want: FormatTarZst},
+		{input: "rar", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseFormat(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFormat(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseFormat(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZipWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatZip, &buf, Options{})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+
+	n, err := w.AddFile(Entry{Name: "a.txt", Size: -1}, strings.NewReader("hello"))
+	if err != nil || n != 5 {
+		t.Fatalf("AddFile() = %d, %v; want 5, nil", n, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("failed to read zip: %v", err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "a.txt" {
+		t.Errorf("unexpected zip entries: %v", zr.File)
+	}
+}
+
+func TestZipWriter_StoreMethod(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want map[string]uint16
+	}{
+		{
+			name: "deflate by default",
+			want: map[string]uint16{"a.txt": zip.Deflate, "b.JPG": zip.Deflate},
+		},
+		{
+			name: "store only",
+			opts: Options{StoreOnly: true},
+			want: map[string]uint16{"a.txt": zip.Store, "b.JPG": zip.Store},
+		},
+		{
+			name: "store by extension",
+			opts: Options{StoreExtensions: []string{"jpg", ".MP4"}},
+			want: map[string]uint16{"a.txt": zip.Deflate, "b.JPG": zip.Store, "c.mp4": zip.Store},
+		},
+		{
+			name: "password forces deflate",
+			opts: Options{StoreOnly: true, Password: "secret"},
+			want: map[string]uint16{"a.txt": zip.Deflate},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, _ := NewWriter(FormatZip, &buf, tt.opts)
+			for name := range tt.want {
+				if _, err := w.AddFile(Entry{Name: name, Size: -1}, strings.NewReader("hello hello hello")); err != nil {
+					t.Fatalf("AddFile(%q) error = %v", name, err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("failed to read zip: %v", err)
+			}
+			for _, f := range zr.File {
+				if f.Method != tt.want[f.Name] {
+					t.Errorf("%s: Method = %d, want %d", f.Name, f.Method, tt.want[f.Name])
+				}
+
+				if tt.opts.Password != "" {
+					f.SetPassword(tt.opts.Password)
+				}
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatalf("%s: Open() error = %v", f.Name, err)
+				}
+				data, _ := io.ReadAll(rc)
+				rc.Close()
+				if string(data) != "hello hello hello" {
+					t.Errorf("%s: content = %q", f.Name, data)
+				}
+			}
+		})
+	}
+}
+
+func TestTarWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		size   int64
+		level  int
+	}{
+		{name: "tar with known size", format: FormatTar, size: 5},
+		{name: "tar with unknown size", format: FormatTar, size: -1},
+		{name: "tar.gz", format: FormatTarGz, size: 5},
+		{name: "tar.zst", format: FormatTarZst, size: 5},
+		{name: "tar.zst with level", format: FormatTarZst, size: 5, level: 19},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(tt.format, &buf, Options{ZstdLevel: tt.level})
+			if err != nil {
+				t.Fatalf("NewWriter() error = %v", err)
+			}
+
+			if _, err := w.AddFile(Entry{Name: "a.txt", Size: tt.size}, strings.NewReader("hello")); err != nil {
+				t.Fatalf("AddFile() error = %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			var r io.Reader = &buf
+			switch tt.format {
+			case FormatTarGz:
+				gz, err := gzip.NewReader(r)
+				if err != nil {
+					t.Fatalf("failed to open gzip stream: %v", err)
+				}
+				r = gz
+			case FormatTarZst:
+				zr, err := zstd.NewReader(r)
+				if err != nil {
+					t.Fatalf("failed to open zstd stream: %v", err)
+				}
+				defer zr.Close()
+				r = zr
+			}
+
+			tr := tar.NewReader(r)
+			hdr, err := tr.Next()
+			if err != nil {
+				t.Fatalf("failed to read tar header: %v", err)
+			}
+			data, _ := io.ReadAll(tr)
+			if hdr.Name != "a.txt" || string(data) != "hello" {
+				t.Errorf("entry = %q %q, want a.txt hello", hdr.Name, data)
+			}
+			if hdr.ModTime.IsZero() {
+				t.Error("entry ModTime is zero")
+			}
+		})
+	}
+}
+
+func TestWriter_EntryAttributes(t *testing.T) {
+	modTime := time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		format   Format
+		opts     Options
+		entry    Entry
+		wantTime time.Time
+		wantMode fs.FileMode
+	}{
+		{name: "zip", format: FormatZip, entry: Entry{ModTime: modTime, Mode: 0o755}, wantTime: modTime, wantMode: 0o755},
+		{name: "zip defaults", format: FormatZip, wantTime: zipEpoch, wantMode: 0o644},
+		{name: "encrypted zip", format: FormatZip, opts: Options{Password: "secret"}, entry: Entry{ModTime: modTime, Mode: 0o600}, wantTime: modTime, wantMode: 0o600},
+		{name: "tar", format: FormatTar, entry: Entry{ModTime: modTime, Mode: 0o750}, wantTime: modTime, wantMode: 0o750},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, _ := NewWriter(tt.format, &buf, tt.opts)
+			entry := tt.entry
+			entry.Name, entry.Size = "a.txt", 5
+			if _, err := w.AddFile(entry, strings.NewReader("hello")); err != nil {
+				t.Fatalf("AddFile() error = %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			var gotTime time.Time
+			var gotMode fs.FileMode
+			if tt.format == FormatZip {
+				zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+				if err != nil {
+					t.Fatalf("failed to read zip: %v", err)
+				}
+				gotTime, gotMode = zr.File[0].ModTime(), zr.File[0].Mode().Perm()
+			} else {
+				hdr, err := tar.NewReader(&buf).Next()
+				if err != nil {
+					t.Fatalf("failed to read tar header: %v", err)
+				}
+				gotTime, gotMode = hdr.ModTime, hdr.FileInfo().Mode().Perm()
+			}
+
+			if !gotTime.Equal(tt.wantTime) {
+				t.Errorf("ModTime = %s, want %s", gotTime, tt.wantTime)
+			}
+			if gotMode != tt.wantMode {
+				t.Errorf("Mode = %v, want %v", gotMode, tt.wantMode)
+			}
+		})
+	}
+}
+
+func TestWriter_AddDirectory(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		opts   Options
+	}{
+		{name: "zip", format: FormatZip},
+		{name: "encrypted zip", format: FormatZip, opts: Options{Password: "secret"}},
+		{name: "tar", format: FormatTar},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, _ := NewWriter(tt.format, &buf, tt.opts)
+			if err := w.AddDirectory(Entry{Name: "photos/2024"}); err != nil {
+				t.Fatalf("AddDirectory() error = %v", err)
+			}
+			if err := w.AddDirectory(Entry{Name: "private/", Mode: 0o700}); err != nil {
+				t.Fatalf("AddDirectory() error = %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			var infos []fs.FileInfo
+			var names []string
+			if tt.format == FormatZip {
+				zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+				if err != nil {
+					t.Fatalf("failed to read zip: %v", err)
+				}
+				for _, f := range zr.File {
+					infos, names = append(infos, f.FileInfo()), append(names, f.Name)
+				}
+			} else {
+				tr := tar.NewReader(&buf)
+				for {
+					hdr, err := tr.Next()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						t.Fatalf("failed to read tar: %v", err)
+					}
+					infos, names = append(infos, hdr.FileInfo()), append(names, hdr.Name)
+				}
+			}
+
+			wantNames := []string{"photos/2024/", "private/"}
+			wantModes := []fs.FileMode{0o755, 0o700}
+			if len(names) != len(wantNames) {
+				t.Fatalf("entries = %v, want %v", names, wantNames)
+			}
+			for i, info := range infos {
+				if names[i] != wantNames[i] || !info.IsDir() || info.Mode().Perm() != wantModes[i] {
+					t.Errorf("entry %d = %q dir=%v mode=%v, want %q dir with mode %v", i, names[i], info.IsDir(), info.Mode().Perm(), wantNames[i], wantModes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTarWriter_SizeMismatch(t *testing.T) {
+	w, _ := NewWriter(FormatTar, io.Discard, Options{})
+	if _, err := w.AddFile(Entry{Name: "a.txt", Size: 10}, strings.NewReader("short")); err == nil {
+		t.Error("AddFile() error = nil for short read")
+	}
+}
+
+func TestNewWriter_CompressionLevel(t *testing.T) {
+	data := strings.Repeat("zipperfly compresses repetitive content well. ", 2000)
+
+	sizes := make(map[int]int)
+	for _, level := range []int{1, 9} {
+		var buf bytes.Buffer
+		w, err := NewWriter(FormatZip, &buf, Options{CompressionLevel: level})
+		if err != nil {
+			t.Fatalf("NewWriter(level %d) error = %v", level, err)
+		}
+		if _, err := w.AddFile(Entry{Name: "a.txt", Size: -1}, strings.NewReader(data)); err != nil {
+			t.Fatalf("AddFile() error = %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		sizes[level] = buf.Len()
+	}
+	if sizes[9] > sizes[1] {
+		t.Errorf("level 9 output (%d bytes) larger than level 1 (%d bytes)", sizes[9], sizes[1])
+	}
+
+	for _, level := range []int{-1, 10} {
+		if _, err := NewWriter(FormatZip, io.Discard, Options{CompressionLevel: level}); err == nil {
+			t.Errorf("NewWriter(level %d) error = nil, want error", level)
+		}
+	}
+}
+
+func TestZipWriter_Encryption(t *testing.T) {
+	tests := []struct {
+		name       string
+		encryption Encryption
+		wantAES    bool
+		wantErr    bool
+	}{
+		{name: "default is zipcrypto"},
+		{name: "zipcrypto", encryption: EncryptionZipCrypto},
+		{name: "aes256", encryption: EncryptionAES256, wantAES: true},
+		{name: "unsupported", encryption: "rot13", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(FormatZip, &buf, Options{Password: "secret", Encryption: tt.encryption})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewWriter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if _, err := w.AddFile(Entry{Name: "a.txt", Size: -1}, strings.NewReader("hello")); err != nil {
+				t.Fatalf("AddFile() error = %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("failed to read zip: %v", err)
+			}
+			f := zr.File[0]
+			if !f.IsEncrypted() {
+				t.Fatal("entry is not encrypted")
+			}
+			// WinZip AES entries carry the 0x9901 extra field
+			if isAES := bytes.Contains(f.Extra, []byte{0x01, 0x99}); isAES != tt.wantAES {
+				t.Errorf("AES extra field present = %v, want %v", isAES, tt.wantAES)
+			}
+
+			f.SetPassword("secret")
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			if string(data) != "hello" {
+				t.Errorf("content = %q, want hello", data)
+			}
+		})
+	}
+}
+
+func TestZipWriter_UTF8Names(t *testing.T) {
+	for _, password := range []string{"", "secret"} {
+		t.Run("password="+password, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(FormatZip, &buf, Options{Password: password})
+			if err != nil {
+				t.Fatalf("NewWriter() error = %v", err)
+			}
+			for _, name := range []string{"plain.txt", "données/日本語.txt"} {
+				if _, err := w.AddFile(Entry{Name: name, Size: -1}, strings.NewReader("x")); err != nil {
+					t.Fatalf("AddFile() error = %v", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("failed to read zip: %v", err)
+			}
+			for i, wantUTF8 := range []bool{false, true} {
+				if got := zr.File[i].Flags&zipFlagUTF8 != 0; got != wantUTF8 {
+					t.Errorf("%s: UTF-8 flag = %v, want %v", zr.File[i].Name, got, wantUTF8)
+				}
+			}
+			if zr.File[1].Name != "données/日本語.txt" {
+				t.Errorf("name = %q", zr.File[1].Name)
+			}
+		})
+	}
+}
+
+func TestStoredZipSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []Entry
+		wantOK  bool
+	}{
+		{name: "empty archive", wantOK: true},
+		{name: "single entry", entries: []Entry{{Name: "a.txt", Size: 5}}, wantOK: true},
+		{
+			name:    "several entries",
+			entries: []Entry{{Name: "a.txt", Size: 5}, {Name: "empty", Size: 0}, {Name: "données.bin", Size: 4096}},
+			wantOK:  true,
+		},
+		{name: "directories", entries: []Entry{{Name: "docs/"}, {Name: "docs/a.txt", Size: 5}, {Name: "empty/"}}, wantOK: true},
+		{name: "unknown size", entries: []Entry{{Name: "a.txt", Size: -1}}},
+		{name: "needs zip64", entries: []Entry{{Name: "big.bin", Size: zip32Max}}},
+		{name: "too many entries", entries: make([]Entry, zip16Max)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := StoredZipSize(tt.entries)
+			if ok != tt.wantOK {
+				t.Fatalf("StoredZipSize() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+
+			var buf bytes.Buffer
+			w, _ := NewWriter(FormatZip, &buf, Options{StoreOnly: true})
+			for _, e := range tt.entries {
+				if strings.HasSuffix(e.Name, "/") {
+					if err := w.AddDirectory(e); err != nil {
+						t.Fatalf("AddDirectory(%q) error = %v", e.Name, err)
+					}
+					continue
+				}
+				if _, err := w.AddFile(e, bytes.NewReader(make([]byte, e.Size))); err != nil {
+					t.Fatalf("AddFile(%q) error = %v", e.Name, err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			if got != int64(buf.Len()) {
+				t.Errorf("StoredZipSize() = %d, archive is %d bytes", got, buf.Len())
+			}
+		})
+	}
+}
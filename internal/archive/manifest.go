@@ -0,0 +1,117 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ManifestFormat identifies the encoding of an archive manifest
+type ManifestFormat string
+
+const (
+	ManifestJSON ManifestFormat = "json"
+	ManifestCSV  ManifestFormat = "csv"
+)
+
+// ParseManifestFormat parses a manifest format name; empty means JSON
+func ParseManifestFormat(s string) (ManifestFormat, error) {
+	switch strings.ToLower(s) {
+	case "", "json":
+		return ManifestJSON, nil
+	case "csv":
+		return ManifestCSV, nil
+	default:
+		return "", fmt.Errorf("unsupported manifest format %q", s)
+	}
+}
+
+// FileName returns the archive entry name for the manifest
+func (f ManifestFormat) FileName() string {
+	return "manifest." + string(f)
+}
+
+// ManifestFile describes a file written to the archive
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest lists the files written to an archive and the ones left out,
+// so recipients can verify a (possibly partial) download. It is safe for
+// concurrent use.
+type Manifest struct {
+	mu      sync.Mutex
+	id      string
+	files   []ManifestFile
+	missing []string
+}
+
+// NewManifest returns an empty manifest for the download record id
+func NewManifest(id string) *Manifest {
+	return &Manifest{id: id}
+}
+
+// AddFile records a file written to the archive
+func (m *Manifest) AddFile(f ManifestFile) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files = append(m.files, f)
+}
+
+// AddMissing records a storage key that could not be added to the archive
+func (m *Manifest) AddMissing(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.missing = append(m.missing, key)
+}
+
+// Encode renders the manifest, with files and missing keys sorted for stable output
+func (m *Manifest) Encode(format ManifestFormat) ([]byte, error) {
+	m.mu.Lock()
+	files := append([]ManifestFile(nil), m.files...)
+	missing := append([]string{}, m.missing...)
+	m.mu.Unlock()
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Key < files[j].Key })
+	sort.Strings(missing)
+
+	switch format {
+	case ManifestJSON:
+		return json.MarshalIndent(struct {
+			ID          string         `json:"id"`
+			GeneratedAt string         `json:"generated_at"`
+			Complete    bool           `json:"complete"`
+			Files       []ManifestFile `json:"files"`
+			Missing     []string       `json:"missing"`
+		}{
+			ID:          m.id,
+			GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+			Complete:    len(missing) == 0,
+			Files:       files,
+			Missing:     missing,
+		}, "", "  ")
+	case ManifestCSV:
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"name", "key", "size", "sha256", "status"})
+		for _, f := range files {
+			w.Write([]string{f.Name, f.Key, strconv.FormatInt(f.Size, 10), f.SHA256, "included"})
+		}
+		for _, key := range missing {
+			w.Write([]string{"", key, "", "", "missing"})
+		}
+		w.Flush()
+		return buf.Bytes(), w.Error()
+	default:
+		return nil, fmt.Errorf("unsupported manifest format %q", format)
+	}
+}
@@ -0,0 +1,93 @@
+package archive
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func newTestManifest() *Manifest {
+	m := NewManifest("rec-1")
+	m.AddFile(ManifestFile{Name: "b.txt", Key: "dir/b.txt", Size: 2, SHA256: "bb"})
+	m.AddFile(ManifestFile{Name: "a.txt", Key: "dir/a.txt", Size: 1, SHA256: "aa"})
+	m.AddMissing("dir/c.txt")
+	return m
+}
+
+func TestManifest_EncodeJSON(t *testing.T) {
+	data, err := newTestManifest().Encode(ManifestJSON)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	var got struct {
+		ID       string         `json:"id"`
+		Complete bool           `json:"complete"`
+		Files    []ManifestFile `json:"files"`
+		Missing  []string       `json:"missing"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if got.ID != "rec-1" || got.Complete {
+		t.Errorf("id = %q, complete = %v; want rec-1, false", got.ID, got.Complete)
+	}
+	if len(got.Files) != 2 || got.Files[0].Key != "dir/a.txt" || got.Files[0].SHA256 != "aa" {
+		t.Errorf("unexpected files: %+v", got.Files)
+	}
+	if len(got.Missing) != 1 || got.Missing[0] != "dir/c.txt" {
+		t.Errorf("unexpected missing: %v", got.Missing)
+	}
+}
+
+func TestManifest_EncodeCSV(t *testing.T) {
+	data, err := newTestManifest().Encode(ManifestCSV)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	want := [][]string{
+		{"name", "key", "size", "sha256", "status"},
+		{"a.txt", "dir/a.txt", "1", "aa", "included"},
+		{"b.txt", "dir/b.txt", "2", "bb", "included"},
+		{"", "dir/c.txt", "", "", "missing"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(want))
+	}
+	for i := range want {
+		if strings.Join(rows[i], ",") != strings.Join(want[i], ",") {
+			t.Errorf("row %d = %v, want %v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestParseManifestFormat(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    ManifestFormat
+		wantErr bool
+	}{
+		{input: "", want: ManifestJSON},
+		{input: "JSON", want: ManifestJSON},
+		{input: "csv", want: ManifestCSV},
+		{input: "xml", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseManifestFormat(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseManifestFormat(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseManifestFormat(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// tarWriter writes POSIX tar entries, optionally through a compressor
+type tarWriter struct {
+	tw         *tar.Writer
+	compressor io.WriteCloser // nil for plain tar
+}
+
+// newTarWriter writes tar entries to w. If compressor is non-nil it must be w
+// (or wrap it) and is flushed and closed along with the archive.
+func newTarWriter(w io.Writer, compressor io.WriteCloser) *tarWriter {
+	return &tarWriter{
+		tw:         tar.NewWriter(w),
+		compressor: compressor,
+	}
+}
+
+func (t *tarWriter) AddFile(entry Entry, r io.Reader) (int64, error) {
+	// Tar headers carry the size up front, so spool unknown-size entries to disk first
+	if entry.Size < 0 {
+		spool, size, err := spoolToTemp(r)
+		if err != nil {
+			return 0, err
+		}
+		defer func() {
+			spool.Close()
+			os.Remove(spool.Name())
+		}()
+		entry.Size = size
+		r = spool
+	}
+
+	modTime := entry.ModTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     entry.Name,
+		Size:     entry.Size,
+		Mode:     int64(entry.mode()),
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	}
+	if err := t.tw.WriteHeader(header); err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(t.tw, r)
+	if err != nil {
+		return n, err
+	}
+	if n != entry.Size {
+		return n, fmt.Errorf("short read for %q: got %d of %d bytes", entry.Name, n, entry.Size)
+	}
+
+	return n, nil
+}
+
+func (t *tarWriter) AddDirectory(entry Entry) error {
+	modTime := entry.ModTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+
+	return t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     dirName(entry.Name),
+		Mode:     int64(entry.dirMode()),
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	})
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	if t.compressor != nil {
+		return t.compressor.Close()
+	}
+	return nil
+}
+
+// spoolToTemp copies r into a temporary file and rewinds it
+func spoolToTemp(r io.Reader) (*os.File, int64, error) {
+	f, err := os.CreateTemp("", "zipperfly-spool-*")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create spool file: %w", err)
+	}
+
+	size, err := io.Copy(f, r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, err
+	}
+
+	return f, size, nil
+}
@@ -0,0 +1,288 @@
+package archive
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	yekazip "github.com/yeka/zip"
+)
+
+// storePolicy decides which ZIP entries are written uncompressed
+type storePolicy struct {
+	storeOnly  bool
+	extensions map[string]bool
+}
+
+func newStorePolicy(opts Options) storePolicy {
+	p := storePolicy{
+		storeOnly:  opts.StoreOnly,
+		extensions: make(map[string]bool, len(opts.StoreExtensions)),
+	}
+	for _, ext := range opts.StoreExtensions {
+		p.extensions["."+strings.TrimPrefix(strings.ToLower(ext), ".")] = true
+	}
+	return p
+}
+
+// store reports whether the named entry should skip compression
+func (p storePolicy) store(name string) bool {
+	return p.storeOnly || p.extensions[strings.ToLower(path.Ext(name))]
+}
+
+// Limits of the classic ZIP format; past them entries or the central
+// directory need Zip64 records
+const (
+	zip16Max = 0xffff
+	zip32Max = 0xffffffff
+)
+
+// Lengths of the fixed-size records archive/zip writes for an entry, of the
+// extended timestamp field it adds to both headers, and of the end of
+// central directory record
+const (
+	zipLocalHeaderLen    = 30
+	zipDataDescriptorLen = 16
+	zipCentralHeaderLen  = 46
+	zipExtTimeLen        = 9
+	zipEndLen            = 22
+)
+
+// zipFlagUTF8 is the general purpose flag bit marking UTF-8 names
+const zipFlagUTF8 = 0x800
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// zipEpoch stands in for unknown modification times. Every entry then
+// carries a timestamp, so the archive size doesn't depend on which are known.
+var zipEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// zipModTime returns the entry's modification time in UTC, or zipEpoch
+func zipModTime(e Entry) time.Time {
+	if e.ModTime.IsZero() {
+		return zipEpoch
+	}
+	return e.ModTime.UTC()
+}
+
+// StoredZipSize returns the exact size of the unencrypted ZIP NewWriter
+// produces when every entry is stored uncompressed, so it can be announced
+// before streaming. Entries whose name ends in a slash are directories. It
+// reports false if an entry size is unknown or the archive would need
+// Zip64 records.
+func StoredZipSize(entries []Entry) (int64, bool) {
+	if len(entries) >= zip16Max {
+		return 0, false
+	}
+
+	var offset, dir int64
+	for _, e := range entries {
+		if e.Size < 0 || e.Size >= zip32Max || offset >= zip32Max {
+			return 0, false
+		}
+		offset += zipLocalHeaderLen + int64(len(e.Name)) + zipExtTimeLen + e.Size
+		// Directories have no data, so archive/zip writes no data descriptor
+		if !strings.HasSuffix(e.Name, "/") {
+			offset += zipDataDescriptorLen
+		}
+		dir += zipCentralHeaderLen + int64(len(e.Name)) + zipExtTimeLen
+	}
+	if offset >= zip32Max || dir >= zip32Max {
+		return 0, false
+	}
+	return offset + dir + zipEndLen, true
+}
+
+// zip64Tracker counts archive bytes and entries to report whether the
+// ZIP writer had to use Zip64 records. Both ZIP libraries switch to Zip64
+// on their own; this only makes it observable.
+type zip64Tracker struct {
+	w       io.Writer
+	written int64
+	entries int
+	large   bool // an entry reached the 32-bit size limit
+}
+
+func (t *zip64Tracker) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	t.written += int64(n)
+	return n, err
+}
+
+func (t *zip64Tracker) addEntry(size int64) {
+	t.entries++
+	if size >= zip32Max {
+		t.large = true
+	}
+}
+
+// zip64 reports whether the archive crossed a classic ZIP limit.
+// The total size is only final after Close.
+func (t *zip64Tracker) zip64() bool {
+	return t.large || t.entries >= zip16Max || t.written >= zip32Max
+}
+
+// zipWriter writes unencrypted ZIP entries with the standard library, which
+// supports a per-writer Deflate compressor (and therefore a custom level)
+type zipWriter struct {
+	zw      *zip.Writer
+	tracker *zip64Tracker
+	policy  storePolicy
+}
+
+func newZipWriter(w io.Writer, opts Options) *zipWriter {
+	tracker := &zip64Tracker{w: w}
+	zw := zip.NewWriter(tracker)
+	if opts.CompressionLevel != 0 {
+		level := opts.CompressionLevel
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
+
+	return &zipWriter{
+		zw:      zw,
+		tracker: tracker,
+		policy:  newStorePolicy(opts),
+	}
+}
+
+func (z *zipWriter) AddFile(entry Entry, r io.Reader) (int64, error) {
+	header := &zip.FileHeader{
+		Name:     entry.Name,
+		Method:   zip.Deflate,
+		Modified: zipModTime(entry),
+	}
+	header.SetMode(entry.mode())
+	if z.policy.store(entry.Name) {
+		header.Method = zip.Store
+	}
+
+	fw, err := z.zw.CreateHeader(header)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(fw, r)
+	z.tracker.addEntry(n)
+	return n, err
+}
+
+func (z *zipWriter) AddDirectory(entry Entry) error {
+	header := &zip.FileHeader{
+		Name:     dirName(entry.Name),
+		Method:   zip.Store,
+		Modified: zipModTime(entry),
+	}
+	header.SetMode(fs.ModeDir | entry.dirMode())
+
+	if _, err := z.zw.CreateHeader(header); err != nil {
+		return err
+	}
+	z.tracker.addEntry(0)
+	return nil
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
+
+func (z *zipWriter) SetComment(comment string) error {
+	return z.zw.SetComment(comment)
+}
+
+func (z *zipWriter) Zip64() bool {
+	return z.tracker.zip64()
+}
+
+// encryptedZipWriter writes password-protected ZIP entries with yeka/zip.
+// Its compressors are global and fixed, so the compression level can't be changed.
+type encryptedZipWriter struct {
+	zw       *yekazip.Writer
+	tracker  *zip64Tracker
+	password string
+	method   yekazip.EncryptionMethod
+}
+
+func newEncryptedZipWriter(w io.Writer, opts Options) *encryptedZipWriter {
+	tracker := &zip64Tracker{w: w}
+	z := &encryptedZipWriter{
+		zw:       yekazip.NewWriter(tracker),
+		tracker:  tracker,
+		password: opts.Password,
+		method:   yekazip.StandardEncryption,
+	}
+	if opts.Encryption == EncryptionAES256 {
+		z.method = yekazip.AES256Encryption
+	}
+	return z
+}
+
+func (z *encryptedZipWriter) AddFile(entry Entry, r io.Reader) (int64, error) {
+	// Always Deflate: yeka/zip's ZipCrypto writer reports short writes,
+	// which the Store path (unlike flate) treats as an error
+	header := &yekazip.FileHeader{
+		Name:   entry.Name,
+		Method: yekazip.Deflate,
+	}
+	header.SetModTime(zipModTime(entry))
+	header.SetMode(entry.mode())
+	// Unlike archive/zip, yeka/zip doesn't flag UTF-8 names itself, and
+	// extractors would otherwise decode them as CP437
+	if !isASCII(entry.Name) && utf8.ValidString(entry.Name) {
+		header.Flags |= zipFlagUTF8
+	}
+
+	// The encryption method must be set explicitly;
+	// the zero value is neither ZipCrypto nor AES and fails on write
+	header.SetPassword(z.password)
+	header.SetEncryptionMethod(z.method)
+
+	fw, err := z.zw.CreateHeader(header)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(fw, r)
+	z.tracker.addEntry(n)
+	return n, err
+}
+
+// AddDirectory adds an unencrypted directory entry; it has no content to protect
+func (z *encryptedZipWriter) AddDirectory(entry Entry) error {
+	header := &yekazip.FileHeader{
+		Name:   dirName(entry.Name),
+		Method: yekazip.Store,
+	}
+	header.SetModTime(zipModTime(entry))
+	header.SetMode(fs.ModeDir | entry.dirMode())
+	if !isASCII(header.Name) && utf8.ValidString(header.Name) {
+		header.Flags |= zipFlagUTF8
+	}
+
+	if _, err := z.zw.CreateHeader(header); err != nil {
+		return err
+	}
+	z.tracker.addEntry(0)
+	return nil
+}
+
+func (z *encryptedZipWriter) Close() error {
+	return z.zw.Close()
+}
+
+func (z *encryptedZipWriter) Zip64() bool {
+	return z.tracker.zip64()
+}
@@ -0,0 +1,187 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// zeroReader produces an endless stream of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// sparseArchive keeps only the head and tail of a streamed archive and reads
+// everything in between as zeros. Archives whose large entries are stored
+// zero-filled can then be verified without holding gigabytes in memory.
+type sparseArchive struct {
+	keep int
+	head []byte
+	tail []byte
+	size int64
+}
+
+func newSparseArchive(keep int) *sparseArchive {
+	return &sparseArchive{keep: keep}
+}
+
+func (s *sparseArchive) Write(p []byte) (int, error) {
+	n := len(p)
+	s.size += int64(n)
+
+	if room := s.keep - len(s.head); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		s.head = append(s.head, p[:room]...)
+		p = p[room:]
+	}
+
+	s.tail = append(s.tail, p...)
+	if len(s.tail) > 2*s.keep {
+		s.tail = append(s.tail[:0], s.tail[len(s.tail)-s.keep:]...)
+	}
+	return n, nil
+}
+
+func (s *sparseArchive) ReadAt(p []byte, off int64) (int, error) {
+	tailStart := s.size - int64(len(s.tail))
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		switch {
+		case pos >= s.size:
+			return n, io.EOF
+		case pos < int64(len(s.head)):
+			n += copy(p[n:], s.head[pos:])
+		case pos >= tailStart:
+			n += copy(p[n:], s.tail[pos-tailStart:])
+		default:
+			// Zero-filled gap between head and tail
+			gap := min(tailStart-pos, int64(len(p)-n))
+			clear(p[n : n+int(gap)])
+			n += int(gap)
+		}
+	}
+	return n, nil
+}
+
+func TestZipWriter_Zip64ManyEntries(t *testing.T) {
+	tests := []struct {
+		name      string
+		entries   int
+		wantZip64 bool
+	}{
+		{name: "below limit", entries: 1000},
+		{name: "over 65535 entries", entries: zip16Max + 10, wantZip64: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(FormatZip, &buf, Options{StoreOnly: true})
+			if err != nil {
+				t.Fatalf("NewWriter() error = %v", err)
+			}
+			for i := 0; i < tt.entries; i++ {
+				name := fmt.Sprintf("file-%06d.txt", i)
+				if _, err := w.AddFile(Entry{Name: name, Size: -1}, strings.NewReader(name)); err != nil {
+					t.Fatalf("AddFile(%q) error = %v", name, err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			if got := w.(Zip64Reporter).Zip64(); got != tt.wantZip64 {
+				t.Errorf("Zip64() = %v, want %v", got, tt.wantZip64)
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("failed to read zip: %v", err)
+			}
+			if len(zr.File) != tt.entries {
+				t.Fatalf("got %d entries, want %d", len(zr.File), tt.entries)
+			}
+			last := zr.File[tt.entries-1]
+			rc, err := last.Open()
+			if err != nil {
+				t.Fatalf("Open(%q) error = %v", last.Name, err)
+			}
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			if string(data) != last.Name {
+				t.Errorf("%s: content = %q", last.Name, data)
+			}
+		})
+	}
+}
+
+func TestZipWriter_Zip64LargeEntry(t *testing.T) {
+	if testing.Short() {
+		t.Skip("streams over 4 GiB; skipped in short mode")
+	}
+
+	const largeSize = zip32Max + 1<<20
+
+	out := newSparseArchive(1 << 20)
+	w, err := NewWriter(FormatZip, out, Options{StoreOnly: true})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	n, err := w.AddFile(Entry{Name: "large.bin", Size: largeSize}, io.LimitReader(zeroReader{}, largeSize))
+	if err != nil || n != largeSize {
+		t.Fatalf("AddFile(large.bin) = %d, %v; want %d, nil", n, err, int64(largeSize))
+	}
+	// An entry past the 4 GiB offset needs a Zip64 offset in the central directory
+	if _, err := w.AddFile(Entry{Name: "after.txt", Size: 5}, strings.NewReader("after")); err != nil {
+		t.Fatalf("AddFile(after.txt) error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if !w.(Zip64Reporter).Zip64() {
+		t.Error("Zip64() = false, want true")
+	}
+
+	zr, err := zip.NewReader(out, out.size)
+	if err != nil {
+		t.Fatalf("failed to read zip: %v", err)
+	}
+	if len(zr.File) != 2 {
+		t.Fatalf("got %d entries, want 2", len(zr.File))
+	}
+	if got := zr.File[0].UncompressedSize64; got != largeSize {
+		t.Errorf("large.bin size = %d, want %d", got, int64(largeSize))
+	}
+
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Open(%q) error = %v", f.Name, err)
+		}
+		// Reading to EOF verifies the CRC-32 and size
+		var data bytes.Buffer
+		if f.Name == "after.txt" {
+			_, err = io.Copy(&data, rc)
+		} else {
+			_, err = io.Copy(io.Discard, rc)
+		}
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%s: read error = %v", f.Name, err)
+		}
+		if f.Name == "after.txt" && data.String() != "after" {
+			t.Errorf("after.txt content = %q", data.String())
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"zipperfly/internal/models"
+)
+
+// APIKeyHeader is the request header that carries an API key
+const APIKeyHeader = "X-Api-Key"
+
+// KeyStore finds API keys. Keys are looked up by their hex SHA-256, so
+// stores never need to hold them in plain text.
+type KeyStore interface {
+	// LookupAPIKey returns the key whose hash is hash, or nil if there is none
+	LookupAPIKey(ctx context.Context, hash string) (*models.APIKey, error)
+}
+
+// HashAPIKey returns the hex SHA-256 of key, as KeyStore looks it up
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// StaticKeys is a KeyStore of fixed keys
+type StaticKeys map[string]*models.APIKey // hash -> key
+
+// NewStaticKeys creates a KeyStore from a map of API key to its settings
+func NewStaticKeys(keys map[string]models.APIKey) StaticKeys {
+	static := make(StaticKeys, len(keys))
+	for key, apiKey := range keys {
+		static[HashAPIKey(key)] = &apiKey
+	}
+	return static
+}
+
+// LookupAPIKey implements KeyStore
+func (s StaticKeys) LookupAPIKey(_ context.Context, hash string) (*models.APIKey, error) {
+	return s[hash], nil
+}
+
+type apiKeyContextKey struct{}
+
+// WithAPIKey returns a copy of ctx marking the request as authenticated by
+// key
+func WithAPIKey(ctx context.Context, key *models.APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey{}, key)
+}
+
+// APIKeyFromContext returns the API key that authenticated the request, or
+// nil if none did
+func APIKeyFromContext(ctx context.Context) *models.APIKey {
+	key, _ := ctx.Value(apiKeyContextKey{}).(*models.APIKey)
+	return key
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"zipperfly/internal/metrics"
+	"zipperfly/internal/models"
+	"zipperfly/sign"
+)
+
+func TestHashAPIKey(t *testing.T) {
+	// echo -n secret-key | sha256sum
+	want := "85dbe15d75ef9308c7ae0f33c7a324cc6f4bf519a2ed2f3027bd33c140a4f9aa"
+	if got := HashAPIKey("secret-key"); got != want {
+		t.Errorf("HashAPIKey() = %s, want %s", got, want)
+	}
+}
+
+func TestStaticKeys(t *testing.T) {
+	keys := NewStaticKeys(map[string]models.APIKey{
+		"key-one": {Name: "billing", RateLimit: 5},
+		"key-two": {Name: "reports"},
+	})
+	ctx := context.Background()
+
+	for key, want := range map[string]string{"key-one": "billing", "key-two": "reports"} {
+		apiKey, err := keys.LookupAPIKey(ctx, HashAPIKey(key))
+		if err != nil || apiKey == nil || apiKey.Name != want {
+			t.Errorf("LookupAPIKey(%q) = %+v, %v, want %s", key, apiKey, err, want)
+		}
+	}
+	if apiKey, err := keys.LookupAPIKey(ctx, HashAPIKey("key-three")); err != nil || apiKey != nil {
+		t.Errorf("LookupAPIKey(unknown) = %+v, %v, want nil", apiKey, err)
+	}
+}
+
+func TestVerifier_Verify_APIKey(t *testing.T) {
+	v := NewVerifier(map[string][]byte{"": []byte("test-secret")}, true, 1, metrics.New(), nil)
+	req := Request{Params: sign.Params{ID: "test-id"}}
+
+	if err := v.Verify(context.Background(), req); err == nil {
+		t.Error("Verify() without signature or API key succeeded")
+	}
+	ctx := WithAPIKey(context.Background(), &models.APIKey{Name: "billing"})
+	if err := v.Verify(ctx, req); err != nil {
+		t.Errorf("Verify() with API key error = %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksRefreshInterval is the least time between JWKS fetches, so tokens
+// with unknown key IDs can't make every request refetch the key set
+const jwksRefreshInterval = time.Minute
+
+// JWTVerifier validates JWT bearer tokens. A token authorizes the download
+// named by its "sub" claim until its "exp" claim; a "files" claim restricts
+// it to that subset selection.
+type JWTVerifier struct {
+	secret    []byte         // HS256 key
+	publicKey *rsa.PublicKey // RS256 key
+	jwks      *jwks          // RS256 keys by key ID
+	issuer    string
+	audience  string
+	userClaim string // claim naming the user, for records bound to one
+}
+
+// downloadClaims are the claims a download token carries
+type downloadClaims struct {
+	Files string `json:"files,omitempty"`
+	jwt.RegisteredClaims
+	all map[string]any // every claim, to read the user claim from
+}
+
+// UnmarshalJSON decodes the claims, keeping every claim in all
+func (c *downloadClaims) UnmarshalJSON(data []byte) error {
+	type plain downloadClaims
+	if err := json.Unmarshal(data, (*plain)(c)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &c.all)
+}
+
+// NewJWTVerifier creates a JWT verifier. secret validates HS256 tokens;
+// publicKeyPEM (an RSA public key) or the keys at jwksURL validate RS256
+// tokens. issuer and audience, when set, must match the token's claims.
+// userClaim names the claim Authenticate reports as the user.
+func NewJWTVerifier(ctx context.Context, secret, publicKeyPEM []byte, jwksURL, issuer, audience, userClaim string) (*JWTVerifier, error) {
+	v := &JWTVerifier{
+		secret:    secret,
+		issuer:    issuer,
+		audience:  audience,
+		userClaim: userClaim,
+	}
+	if len(publicKeyPEM) > 0 {
+		key, err := jwt.ParseRSAPublicKeyFromPEM(publicKeyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT public key: %w", err)
+		}
+		v.publicKey = key
+	}
+	if jwksURL != "" {
+		v.jwks = &jwks{url: jwksURL, client: &http.Client{Timeout: 10 * time.Second}}
+		if err := v.jwks.refresh(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if len(v.secret) == 0 && v.publicKey == nil && v.jwks == nil {
+		return nil, errors.New("no JWT key configured")
+	}
+	return v, nil
+}
+
+// Verify checks that token is valid and grants access to download id with
+// the given subset selection
+func (v *JWTVerifier) Verify(ctx context.Context, token, id, files string) error {
+	_, err := v.Authenticate(ctx, token, id, files)
+	return err
+}
+
+// Authenticate is Verify, also returning the token's user claim, "" if it
+// has none
+func (v *JWTVerifier) Authenticate(ctx context.Context, token, id, files string) (string, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(v.methods()),
+		jwt.WithExpirationRequired(),
+		jwt.WithSubject(id),
+	}
+	if v.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.issuer))
+	}
+	if v.audience != "" {
+		opts = append(opts, jwt.WithAudience(v.audience))
+	}
+
+	var claims downloadClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (any, error) {
+		return v.key(ctx, t)
+	}, opts...)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return "", errExpired
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid token: %w", err)
+	}
+	if claims.Files != files {
+		return "", errors.New("invalid token: files do not match")
+	}
+
+	// Numeric user IDs are accepted as they are written in the token
+	switch user := claims.all[v.userClaim].(type) {
+	case string:
+		return user, nil
+	case float64:
+		return strconv.FormatFloat(user, 'f', -1, 64), nil
+	}
+	return "", nil
+}
+
+// methods returns the signing methods there are keys for
+func (v *JWTVerifier) methods() []string {
+	var methods []string
+	if len(v.secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if v.publicKey != nil || v.jwks != nil {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	return methods
+}
+
+// key returns the key that validates t: the secret for HS256, and for
+// RS256 the JWKS key named by its "kid" header, or the public key
+func (v *JWTVerifier) key(ctx context.Context, t *jwt.Token) (any, error) {
+	if t.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+		return v.secret, nil
+	}
+	kid, _ := t.Header["kid"].(string)
+	if v.jwks != nil && (kid != "" || v.publicKey == nil) {
+		return v.jwks.key(ctx, kid)
+	}
+	return v.publicKey, nil
+}
+
+// jwks is a JSON Web Key Set, fetched again when a token names a key it
+// doesn't hold
+type jwks struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// key returns the RSA key with ID kid, refreshing the set if it is unknown.
+// An empty kid matches the set's only key.
+func (k *jwks) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if key := k.lookup(kid); key != nil {
+		return key, nil
+	}
+	if time.Since(k.fetched) >= jwksRefreshInterval {
+		if err := k.fetch(ctx); err != nil {
+			return nil, err
+		}
+		if key := k.lookup(kid); key != nil {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// refresh fetches the key set
+func (k *jwks) refresh(ctx context.Context) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.fetch(ctx)
+}
+
+func (k *jwks) lookup(kid string) *rsa.PublicKey {
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key
+		}
+	}
+	return k.keys[kid]
+}
+
+// fetch replaces the keys with the RSA signing keys at url; k.mu must be held
+func (k *jwks) fetch(ctx context.Context) error {
+	k.fetched = time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return fmt.Errorf("JWKS request creation error: %w", err)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("JWKS fetch error: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS fetch returned status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(strings.TrimRight(jwk.N, "="))
+		e, errE := base64.RawURLEncoding.DecodeString(strings.TrimRight(jwk.E, "="))
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return fmt.Errorf("invalid JWKS key %q", jwk.Kid)
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	k.keys = keys
+	return nil
+}
+
+// RequestToken returns the JWT a request carries: the bearer token in its
+// Authorization header, or else its ?token= query parameter
+func RequestToken(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return r.URL.Query().Get("token")
+}
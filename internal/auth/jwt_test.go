@@ -0,0 +1,313 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"zipperfly/internal/metrics"
+	"zipperfly/sign"
+)
+
+func TestJWTVerifier_Verify(t *testing.T) {
+	secret := []byte("jwt-secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	future := jwt.NewNumericDate(time.Now().Add(time.Hour))
+
+	v, err := NewJWTVerifier(context.Background(), secret, publicKeyPEM, "", "https://issuer.example.com", "zipperfly", "user_id")
+	if err != nil {
+		t.Fatalf("NewJWTVerifier() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		method      jwt.SigningMethod
+		key         any
+		claims      jwt.MapClaims
+		files       string
+		errContains string
+	}{
+		{
+			name:   "HS256",
+			method: jwt.SigningMethodHS256,
+			key:    secret,
+			claims: jwt.MapClaims{"sub": "test-id", "exp": future, "iss": "https://issuer.example.com", "aud": "zipperfly"},
+		},
+		{
+			name:   "RS256",
+			method: jwt.SigningMethodRS256,
+			key:    rsaKey,
+			claims: jwt.MapClaims{"sub": "test-id", "exp": future, "iss": "https://issuer.example.com", "aud": "zipperfly"},
+		},
+		{
+			name:   "files claim",
+			method: jwt.SigningMethodHS256,
+			key:    secret,
+			claims: jwt.MapClaims{"sub": "test-id", "exp": future, "iss": "https://issuer.example.com", "aud": "zipperfly", "files": "0,2"},
+			files:  "0,2",
+		},
+		{
+			name:        "files not in token",
+			method:      jwt.SigningMethodHS256,
+			key:         secret,
+			claims:      jwt.MapClaims{"sub": "test-id", "exp": future, "iss": "https://issuer.example.com", "aud": "zipperfly"},
+			files:       "0,2",
+			errContains: "files do not match",
+		},
+		{
+			name:        "other download",
+			method:      jwt.SigningMethodHS256,
+			key:         secret,
+			claims:      jwt.MapClaims{"sub": "other-id", "exp": future, "iss": "https://issuer.example.com", "aud": "zipperfly"},
+			errContains: "invalid token",
+		},
+		{
+			name:        "expired",
+			method:      jwt.SigningMethodHS256,
+			key:         secret,
+			claims:      jwt.MapClaims{"sub": "test-id", "exp": jwt.NewNumericDate(time.Now().Add(-time.Minute)), "iss": "https://issuer.example.com", "aud": "zipperfly"},
+			errContains: "expired",
+		},
+		{
+			name:        "no expiry",
+			method:      jwt.SigningMethodHS256,
+			key:         secret,
+			claims:      jwt.MapClaims{"sub": "test-id", "iss": "https://issuer.example.com", "aud": "zipperfly"},
+			errContains: "invalid token",
+		},
+		{
+			name:        "wrong issuer",
+			method:      jwt.SigningMethodHS256,
+			key:         secret,
+			claims:      jwt.MapClaims{"sub": "test-id", "exp": future, "iss": "https://other.example.com", "aud": "zipperfly"},
+			errContains: "invalid token",
+		},
+		{
+			name:        "wrong audience",
+			method:      jwt.SigningMethodHS256,
+			key:         secret,
+			claims:      jwt.MapClaims{"sub": "test-id", "exp": future, "iss": "https://issuer.example.com", "aud": "other"},
+			errContains: "invalid token",
+		},
+		{
+			name:        "wrong secret",
+			method:      jwt.SigningMethodHS256,
+			key:         []byte("other-secret"),
+			claims:      jwt.MapClaims{"sub": "test-id", "exp": future, "iss": "https://issuer.example.com", "aud": "zipperfly"},
+			errContains: "invalid token",
+		},
+		{
+			name:        "unaccepted method",
+			method:      jwt.SigningMethodHS512,
+			key:         secret,
+			claims:      jwt.MapClaims{"sub": "test-id", "exp": future, "iss": "https://issuer.example.com", "aud": "zipperfly"},
+			errContains: "invalid token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := jwt.NewWithClaims(tt.method, tt.claims).SignedString(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = v.Verify(context.Background(), token, "test-id", tt.files)
+			if tt.errContains == "" {
+				if err != nil {
+					t.Errorf("Verify() unexpected error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("Verify() error = %v, want error containing %q", err, tt.errContains)
+			}
+		})
+	}
+}
+
+func TestJWTVerifier_JWKS(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The set starts with the old key, and rotates in the new one
+	var rotated atomic.Bool
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		keys := []map[string]string{jwk("old", &oldKey.PublicKey)}
+		if rotated.Load() {
+			keys = append(keys, jwk("new", &newKey.PublicKey))
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	defer server.Close()
+
+	v, err := NewJWTVerifier(context.Background(), nil, nil, server.URL, "", "", "user_id")
+	if err != nil {
+		t.Fatalf("NewJWTVerifier() error = %v", err)
+	}
+
+	sign := func(kid string, key *rsa.PrivateKey) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "test-id", "exp": jwt.NewNumericDate(time.Now().Add(time.Hour))})
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	if err := v.Verify(context.Background(), sign("old", oldKey), "test-id", ""); err != nil {
+		t.Errorf("Verify() with known key error = %v", err)
+	}
+	if err := v.Verify(context.Background(), sign("old", newKey), "test-id", ""); err == nil {
+		t.Error("Verify() with wrong key error = nil")
+	}
+
+	// An unknown key refetches the set, at most once per interval
+	rotated.Store(true)
+	v.jwks.fetched = time.Time{}
+	if err := v.Verify(context.Background(), sign("new", newKey), "test-id", ""); err != nil {
+		t.Errorf("Verify() with rotated key error = %v", err)
+	}
+	if err := v.Verify(context.Background(), sign("unknown", newKey), "test-id", ""); err == nil {
+		t.Error("Verify() with unknown key error = nil")
+	}
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", got)
+	}
+}
+
+func TestJWTVerifier_Authenticate_User(t *testing.T) {
+	secret := []byte("jwt-secret")
+	v, err := NewJWTVerifier(context.Background(), secret, nil, "", "", "", "uid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := jwt.NewNumericDate(time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		want   string
+	}{
+		{name: "string", claims: jwt.MapClaims{"sub": "test-id", "exp": exp, "uid": "alice"}, want: "alice"},
+		{name: "number", claims: jwt.MapClaims{"sub": "test-id", "exp": exp, "uid": 42}, want: "42"},
+		{name: "other claim", claims: jwt.MapClaims{"sub": "test-id", "exp": exp, "user_id": "alice"}},
+		{name: "missing", claims: jwt.MapClaims{"sub": "test-id", "exp": exp}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, tt.claims).SignedString(secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := v.Authenticate(context.Background(), token, "test-id", "")
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Authenticate() user = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifier_Verify_JWT(t *testing.T) {
+	secret := []byte("jwt-secret")
+	jv, err := NewJWTVerifier(context.Background(), secret, nil, "", "", "", "user_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "test-id", "exp": jwt.NewNumericDate(time.Now().Add(time.Hour))}).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "test-id", "exp": jwt.NewNumericDate(time.Now().Add(-time.Hour))}).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := NewVerifier(map[string][]byte{"": []byte("test-secret")}, true, 1, metrics.New(), jv)
+	ctx := context.Background()
+
+	if err := v.Verify(ctx, Request{Params: sign.Params{ID: "test-id"}, Token: token}); err != nil {
+		t.Errorf("Verify() with token error = %v", err)
+	}
+	if err := v.Verify(ctx, Request{Params: sign.Params{ID: "test-id"}, Signature: generateSignature([]byte("test-secret"), "test-id", "", "")}); err != nil {
+		t.Errorf("Verify() with signature error = %v", err)
+	}
+	if err := v.Verify(ctx, Request{Params: sign.Params{ID: "test-id"}}); err == nil || !strings.Contains(err.Error(), "signature required") {
+		t.Errorf("Verify() without either error = %v, want signature required", err)
+	}
+	if err := v.Verify(ctx, Request{Params: sign.Params{ID: "test-id"}, Token: expired}); err != errExpired {
+		t.Errorf("Verify() with expired token error = %v, want %v", err, errExpired)
+	}
+}
+
+func TestRequestToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		header string
+		want   string
+	}{
+		{name: "none", url: "/d/1"},
+		{name: "header", url: "/d/1", header: "Bearer abc.def.ghi", want: "abc.def.ghi"},
+		{name: "lowercase scheme", url: "/d/1", header: "bearer abc.def.ghi", want: "abc.def.ghi"},
+		{name: "query", url: "/d/1?token=abc.def.ghi", want: "abc.def.ghi"},
+		{name: "header wins", url: "/d/1?token=query", header: "Bearer header", want: "header"},
+		{name: "other scheme", url: "/d/1", header: "Basic dXNlcjpwYXNz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if got := RequestToken(r); got != tt.want {
+				t.Errorf("RequestToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func jwk(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
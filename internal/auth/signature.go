@@ -1,69 +1,203 @@
 package auth
 
 import (
+	"context"
 	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
 	"zipperfly/internal/metrics"
+	"zipperfly/internal/models"
+	"zipperfly/sign"
 )
 
+// errExpired is returned for requests past their expiry
+var errExpired = errors.New("request has expired")
+
 // Verifier handles request signature verification
 type Verifier struct {
-	secret         []byte
+	mu             sync.RWMutex      // guards secrets
+	secrets        map[string][]byte // key ID -> secret; "" for an unnamed secret
 	enforceSigning bool
+	minVersion     int          // oldest signature scheme accepted
+	jwt            *JWTVerifier // nil = JWT bearer tokens are not accepted
 	metrics        *metrics.Metrics
 }
 
-// NewVerifier creates a new signature verifier
-func NewVerifier(secret []byte, enforceSigning bool, m *metrics.Metrics) *Verifier {
+// NewVerifier creates a new signature verifier. secrets maps key IDs to
+// signing secrets, so several can be active while one is rotated out.
+// Signatures older than minVersion are rejected. jwt, if not nil, also
+// accepts JWT bearer tokens in place of a signature.
+func NewVerifier(secrets map[string][]byte, enforceSigning bool, minVersion int, m *metrics.Metrics, jwt *JWTVerifier) *Verifier {
 	return &Verifier{
-		secret:         secret,
+		secrets:        secrets,
 		enforceSigning: enforceSigning,
+		minVersion:     minVersion,
+		jwt:            jwt,
 		metrics:        m,
 	}
 }
 
-// Verify checks the signature and expiry of a request
-func (v *Verifier) Verify(id, expiryStr, signature string) error {
-	hasExpiry := expiryStr != ""
+// SetSecrets replaces the signing secrets, e.g. when they are refreshed
+// from a secrets manager. Requests already being verified keep the old set.
+func (v *Verifier) SetSecrets(secrets map[string][]byte) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.secrets = secrets
+}
+
+// Request holds the parameters of a request that Verify checks
+type Request struct {
+	sign.Params        // method, id, expiry, files, format, and raw
+	Signature   string // hex HMAC, "" = unsigned
+	KeyID       string // secret that signed the request, "" = any
+	Version     string // signature scheme, "" = 1
+	Token       string // JWT bearer token, "" = none
+}
+
+// RequestParams returns the parameters of r, a request for download id
+func RequestParams(r *http.Request, id string) Request {
+	return requestParams(r.URL.Query(), r.Method, id, RequestToken(r))
+}
+
+// FormParams returns the parameters of a download link for id posted as a
+// form to r, which are verified as they would be for a GET of the link.
+// The form must already be parsed.
+func FormParams(r *http.Request, id string) Request {
+	return requestParams(r.PostForm, http.MethodGet, id, RequestToken(r))
+}
+
+func requestParams(values url.Values, method, id, token string) Request {
+	return Request{
+		Params: sign.Params{
+			Method:   method,
+			ID:       id,
+			Expiry:   values.Get("expiry"),
+			Files:    values.Get("files"),
+			Format:   values.Get("format"),
+			Raw:      values.Get("raw"),
+			Password: values.Get("password"),
+		},
+		Signature: values.Get("signature"),
+		KeyID:     values.Get("kid"),
+		Version:   values.Get("v"),
+		Token:     token,
+	}
+}
+
+// Identity is who a verified request proved to come from
+type Identity struct {
+	User   string         // the JWT's user claim, "" = none
+	APIKey *models.APIKey // key that authenticated the request, nil = none
+}
+
+type grantContextKey struct{}
+
+// WithGrant returns a copy of ctx marking the request as authenticated by a
+// redeemed download token, which was issued to identity
+func WithGrant(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, grantContextKey{}, identity)
+}
+
+// Verify checks the signature and expiry of a request. Version 1
+// signatures cover the id, expiry, and file selection; version 2 also
+// covers the method, format, and raw parameters. A request naming a key ID
+// must be signed with that secret; without one, any active secret is
+// accepted. With JWT enabled, a request carrying a token is verified by the
+// token alone, which must name the id and files. A request already
+// authenticated by an API key (see WithAPIKey) or a download token (see
+// WithGrant) needs neither.
+func (v *Verifier) Verify(ctx context.Context, req Request) error {
+	_, err := v.Authenticate(ctx, req)
+	return err
+}
+
+// Authenticate is Verify, also returning the identity the request carries
+func (v *Verifier) Authenticate(ctx context.Context, req Request) (Identity, error) {
+	if key := APIKeyFromContext(ctx); key != nil {
+		return Identity{APIKey: key}, nil
+	}
+	if identity, ok := ctx.Value(grantContextKey{}).(Identity); ok {
+		return identity, nil
+	}
+
+	if v.jwt != nil && req.Token != "" {
+		user, err := v.jwt.Authenticate(ctx, req.Token, req.ID, req.Files)
+		switch {
+		case errors.Is(err, errExpired):
+			v.metrics.ExpiredRequestsTotal.Inc()
+		case err != nil:
+			v.metrics.SignatureFailuresTotal.Inc()
+		}
+		return Identity{User: user}, err
+	}
 
 	// Check expiry if provided
-	if hasExpiry {
-		expiry, err := strconv.ParseInt(expiryStr, 10, 64)
+	if req.Expiry != "" {
+		expiry, err := strconv.ParseInt(req.Expiry, 10, 64)
 		if err != nil {
-			return fmt.Errorf("invalid expiry: %w", err)
+			return Identity{}, fmt.Errorf("invalid expiry: %w", err)
 		}
 		if time.Now().Unix() > expiry {
 			v.metrics.ExpiredRequestsTotal.Inc()
-			return fmt.Errorf("request has expired")
+			return Identity{}, errExpired
 		}
 	}
 
 	// Check signature if enforced or provided
-	if v.enforceSigning || signature != "" {
-		if signature == "" {
+	if v.enforceSigning || req.Signature != "" {
+		if req.Signature == "" {
 			v.metrics.SignatureFailuresTotal.Inc()
-			return fmt.Errorf("signature required")
+			return Identity{}, fmt.Errorf("signature required")
 		}
 
-		payload := id
-		if hasExpiry {
-			payload += "|" + expiryStr
+		version := 1
+		switch req.Version {
+		case "", "1":
+		case "2":
+			version = 2
+		default:
+			v.metrics.SignatureFailuresTotal.Inc()
+			return Identity{}, fmt.Errorf("unsupported signature version %q", req.Version)
+		}
+		if version < v.minVersion {
+			v.metrics.SignatureFailuresTotal.Inc()
+			return Identity{}, fmt.Errorf("signature version %d or later required", v.minVersion)
 		}
 
-		h := hmac.New(sha256.New, v.secret)
-		h.Write([]byte(payload))
-		expectedSig := hex.EncodeToString(h.Sum(nil))
+		v.mu.RLock()
+		secrets := v.secrets
+		v.mu.RUnlock()
+		if req.KeyID != "" {
+			secret, ok := secrets[req.KeyID]
+			if !ok {
+				v.metrics.SignatureFailuresTotal.Inc()
+				return Identity{}, fmt.Errorf("unknown signing key %q", req.KeyID)
+			}
+			secrets = map[string][]byte{req.KeyID: secret}
+		}
 
-		if signature != expectedSig {
+		valid := false
+		for _, secret := range secrets {
+			expected := sign.Signature(secret, req.ID, req.Expiry, req.Files)
+			if version == 2 {
+				expected = sign.SignatureV2(secret, req.Params)
+			}
+			if hmac.Equal([]byte(req.Signature), []byte(expected)) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
 			v.metrics.SignatureFailuresTotal.Inc()
-			return fmt.Errorf("invalid signature")
+			return Identity{}, fmt.Errorf("invalid signature")
 		}
 	}
 
-	return nil
+	return Identity{}, nil
 }
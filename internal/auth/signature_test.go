@@ -1,15 +1,19 @@
 package auth
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"net/http/httptest"
+	"net/url"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"zipperfly/internal/metrics"
+	"zipperfly/sign"
 )
 
 func TestVerifier_Verify(t *testing.T) {
@@ -21,6 +25,7 @@ func TestVerifier_Verify(t *testing.T) {
 		enforceSigning bool
 		id            string
 		expiryStr     string
+		files         string
 		signature     string
 		wantErr       bool
 		errContains   string
@@ -30,7 +35,7 @@ func TestVerifier_Verify(t *testing.T) {
 			enforceSigning: false,
 			id:            "test-id",
 			expiryStr:     "",
-			signature:     generateSignature(secret, "test-id", ""),
+			signature:     generateSignature(secret, "test-id", "", ""),
 			wantErr:       false,
 		},
 		{
@@ -77,6 +82,23 @@ func TestVerifier_Verify(t *testing.T) {
 			wantErr:       true,
 			errContains:   "invalid signature",
 		},
+		{
+			name:          "valid signature with files",
+			enforceSigning: true,
+			id:            "test-id",
+			files:         "a.txt,2",
+			signature:     generateSignature(secret, "test-id", "", "a.txt,2"),
+			wantErr:       false,
+		},
+		{
+			name:          "files not covered by signature",
+			enforceSigning: true,
+			id:            "test-id",
+			files:         "a.txt,2",
+			signature:     generateSignature(secret, "test-id", "", ""),
+			wantErr:       true,
+			errContains:   "invalid signature",
+		},
 		{
 			name:          "no enforcement, no signature - allowed",
 			enforceSigning: false,
@@ -89,15 +111,15 @@ func TestVerifier_Verify(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			v := NewVerifier(secret, tt.enforceSigning, m)
+			v := NewVerifier(map[string][]byte{"": secret}, tt.enforceSigning, 1, m, nil)
 
 			// Generate signature if needed and not testing invalid cases
 			sig := tt.signature
 			if sig == "" && !tt.wantErr && tt.name == "valid signature with future expiry" {
-				sig = generateSignature(secret, tt.id, tt.expiryStr)
+				sig = generateSignature(secret, tt.id, tt.expiryStr, tt.files)
 			}
 
-			err := v.Verify(tt.id, tt.expiryStr, sig)
+			err := v.Verify(context.Background(), Request{Params: sign.Params{ID: tt.id, Expiry: tt.expiryStr, Files: tt.files}, Signature: sig})
 
 			if tt.wantErr {
 				if err == nil {
@@ -116,12 +138,158 @@ func TestVerifier_Verify(t *testing.T) {
 	}
 }
 
-func generateSignature(secret []byte, id, expiryStr string) string {
+func generateSignature(secret []byte, id, expiryStr, files string) string {
 	payload := id
-	if expiryStr != "" {
+	if expiryStr != "" || files != "" {
 		payload += "|" + expiryStr
 	}
+	if files != "" {
+		payload += "|" + files
+	}
 	h := hmac.New(sha256.New, secret)
 	h.Write([]byte(payload))
 	return hex.EncodeToString(h.Sum(nil))
 }
+
+func TestVerifier_Verify_KeyRotation(t *testing.T) {
+	oldSecret := []byte("old-secret")
+	newSecret := []byte("new-secret")
+	v := NewVerifier(map[string][]byte{"2024": oldSecret, "2025": newSecret}, true, 1, metrics.New(), nil)
+
+	tests := []struct {
+		name        string
+		signature   string
+		keyID       string
+		errContains string
+	}{
+		{name: "new key", signature: generateSignature(newSecret, "test-id", "", ""), keyID: "2025"},
+		{name: "old key still active", signature: generateSignature(oldSecret, "test-id", "", ""), keyID: "2024"},
+		{name: "no key ID tries each key", signature: generateSignature(oldSecret, "test-id", "", "")},
+		{name: "wrong key ID", signature: generateSignature(oldSecret, "test-id", "", ""), keyID: "2025", errContains: "invalid signature"},
+		{name: "unknown key ID", signature: generateSignature(oldSecret, "test-id", "", ""), keyID: "2023", errContains: "unknown signing key"},
+		{name: "retired key", signature: generateSignature([]byte("retired-secret"), "test-id", "", ""), errContains: "invalid signature"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.Verify(context.Background(), Request{Params: sign.Params{ID: "test-id"}, Signature: tt.signature, KeyID: tt.keyID})
+			if tt.errContains == "" {
+				if err != nil {
+					t.Errorf("Verify() unexpected error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("Verify() error = %v, want error containing %q", err, tt.errContains)
+			}
+		})
+	}
+}
+
+func TestVerifier_SetSecrets(t *testing.T) {
+	v := NewVerifier(map[string][]byte{"2024": []byte("old-secret")}, true, 1, metrics.New(), nil)
+	v.SetSecrets(map[string][]byte{"2025": []byte("new-secret")})
+
+	ctx := context.Background()
+	if err := v.Verify(ctx, Request{Params: sign.Params{ID: "test-id"}, Signature: generateSignature([]byte("new-secret"), "test-id", "", ""), KeyID: "2025"}); err != nil {
+		t.Errorf("Verify() with the new secret error = %v", err)
+	}
+	if err := v.Verify(ctx, Request{Params: sign.Params{ID: "test-id"}, Signature: generateSignature([]byte("old-secret"), "test-id", "", "")}); err == nil {
+		t.Error("Verify() with the replaced secret succeeded, want an error")
+	}
+}
+
+func TestVerifier_Verify_V2(t *testing.T) {
+	secret := []byte("test-secret")
+	expiry := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	signed := sign.Params{Method: "GET", ID: "test-id", Expiry: expiry, Format: "tar.gz", Raw: "true"}
+	signature := sign.SignatureV2(secret, signed)
+
+	tests := []struct {
+		name        string
+		minVersion  int
+		params      sign.Params
+		signature   string
+		version     string
+		errContains string
+	}{
+		{name: "valid", minVersion: 1, params: signed, signature: signature, version: "2"},
+		{name: "HEAD uses the GET signature", minVersion: 1, params: withMethod(signed, "HEAD"), signature: signature, version: "2"},
+		{name: "method changed", minVersion: 1, params: withMethod(signed, "POST"), signature: signature, version: "2", errContains: "invalid signature"},
+		{name: "format changed", minVersion: 1, params: withFormat(signed, "zip"), signature: signature, version: "2", errContains: "invalid signature"},
+		{name: "checked as version 1", minVersion: 1, params: signed, signature: signature, errContains: "invalid signature"},
+		{
+			name:       "version 1 accepted",
+			minVersion: 1,
+			params:     signed,
+			signature:  generateSignature(secret, "test-id", expiry, ""),
+		},
+		{
+			name:        "version 1 below minimum",
+			minVersion:  2,
+			params:      signed,
+			signature:   generateSignature(secret, "test-id", expiry, ""),
+			errContains: "signature version 2 or later required",
+		},
+		{name: "unsupported version", minVersion: 1, params: signed, signature: signature, version: "3", errContains: "unsupported signature version"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewVerifier(map[string][]byte{"": secret}, true, tt.minVersion, metrics.New(), nil)
+			err := v.Verify(context.Background(), Request{Params: tt.params, Signature: tt.signature, Version: tt.version})
+			if tt.errContains == "" {
+				if err != nil {
+					t.Errorf("Verify() unexpected error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("Verify() error = %v, want error containing %q", err, tt.errContains)
+			}
+		})
+	}
+}
+
+func TestFormParams(t *testing.T) {
+	form := url.Values{"expiry": {"1764460487"}, "files": {"a.txt"}, "format": {"tar"}, "signature": {"abc"}, "kid": {"2025"}, "v": {"2"}}
+	r := httptest.NewRequest("POST", "/test-id?signature=ignored", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := r.ParseForm(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := FormParams(r, "test-id")
+	want := Request{
+		Params:    sign.Params{Method: "GET", ID: "test-id", Expiry: "1764460487", Files: "a.txt", Format: "tar"},
+		Signature: "abc",
+		KeyID:     "2025",
+		Version:   "2",
+	}
+	if got != want {
+		t.Errorf("FormParams() = %+v, want %+v", got, want)
+	}
+}
+
+func TestVerifier_Authenticate_Grant(t *testing.T) {
+	v := NewVerifier(map[string][]byte{"": []byte("test-secret")}, true, 1, metrics.New(), nil)
+
+	ctx := WithGrant(context.Background(), Identity{User: "42"})
+	identity, err := v.Authenticate(ctx, Request{Params: sign.Params{ID: "test-id"}})
+	if err != nil || identity.User != "42" {
+		t.Errorf("Authenticate() with a grant = %+v, %v, want user 42", identity, err)
+	}
+	if _, err := v.Authenticate(context.Background(), Request{Params: sign.Params{ID: "test-id"}}); err == nil {
+		t.Error("Authenticate() without a grant or signature succeeded, want an error")
+	}
+}
+
+func withMethod(p sign.Params, method string) sign.Params {
+	p.Method = method
+	return p
+}
+
+func withFormat(p sign.Params, format string) sign.Params {
+	p.Format = format
+	return p
+}
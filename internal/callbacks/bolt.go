@@ -0,0 +1,165 @@
+package callbacks
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"zipperfly/internal/models"
+)
+
+var (
+	// jobsBucket maps job ID -> next attempt (8 bytes, unix ms) + job JSON
+	jobsBucket = []byte("jobs")
+	// scheduleBucket orders jobs for claiming: next attempt + job ID -> nothing
+	scheduleBucket = []byte("schedule")
+)
+
+// BoltQueue is a Queue in a local bolt file, for single-instance deployments
+// without a shared database queue
+type BoltQueue struct {
+	db *bolt.DB
+}
+
+// NewBoltQueue opens (or creates) the queue file at path
+func NewBoltQueue(path string) (*BoltQueue, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open callback queue %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(jobsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(scheduleBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize callback queue %s: %w", path, err)
+	}
+	return &BoltQueue{db: db}, nil
+}
+
+// Close closes the queue file
+func (q *BoltQueue) Close() error {
+	return q.db.Close()
+}
+
+// EnqueueCallback stores a job for delivery at job.NextAttempt
+func (q *BoltQueue) EnqueueCallback(ctx context.Context, job *models.CallbackJob) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		return putJob(tx, job)
+	})
+}
+
+// ClaimCallbacks returns up to limit due jobs, moving them to now+lease
+func (q *BoltQueue) ClaimCallbacks(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.CallbackJob, error) {
+	var jobs []*models.CallbackJob
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		// Collect first: the schedule can't be modified while iterating it
+		var keys [][]byte
+		c := tx.Bucket(scheduleBucket).Cursor()
+		for k, _ := c.First(); k != nil && len(keys) < limit; k, _ = c.Next() {
+			if int64(binary.BigEndian.Uint64(k[:8])) > now.UnixMilli() {
+				break
+			}
+			keys = append(keys, bytes.Clone(k))
+		}
+
+		for _, key := range keys {
+			id := string(key[8:])
+			job, err := getJob(tx, id)
+			if err != nil {
+				// Undeliverable; drop it rather than block the queue
+				if err := tx.Bucket(scheduleBucket).Delete(key); err != nil {
+					return err
+				}
+				if err := tx.Bucket(jobsBucket).Delete([]byte(id)); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := deleteJob(tx, id); err != nil {
+				return err
+			}
+			job.NextAttempt = now.Add(lease)
+			if err := putJob(tx, job); err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// RescheduleCallback saves a failed job's attempts, error, and next attempt
+func (q *BoltQueue) RescheduleCallback(ctx context.Context, job *models.CallbackJob) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		if err := deleteJob(tx, job.ID); err != nil {
+			return err
+		}
+		return putJob(tx, job)
+	})
+}
+
+// DeleteCallback removes a delivered or abandoned job
+func (q *BoltQueue) DeleteCallback(ctx context.Context, id string) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		return deleteJob(tx, id)
+	})
+}
+
+// scheduleKey orders jobs by next attempt, then ID
+func scheduleKey(nextAttempt int64, id string) []byte {
+	key := binary.BigEndian.AppendUint64(nil, uint64(nextAttempt))
+	return append(key, id...)
+}
+
+func putJob(tx *bolt.Tx, job *models.CallbackJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	nextAttempt := job.NextAttempt.UnixMilli()
+	value := binary.BigEndian.AppendUint64(nil, uint64(nextAttempt))
+	if err := tx.Bucket(jobsBucket).Put([]byte(job.ID), append(value, data...)); err != nil {
+		return err
+	}
+	return tx.Bucket(scheduleBucket).Put(scheduleKey(nextAttempt, job.ID), []byte{})
+}
+
+func getJob(tx *bolt.Tx, id string) (*models.CallbackJob, error) {
+	value := tx.Bucket(jobsBucket).Get([]byte(id))
+	if len(value) < 8 {
+		return nil, fmt.Errorf("callback %s not found", id)
+	}
+	var job models.CallbackJob
+	if err := json.Unmarshal(value[8:], &job); err != nil {
+		return nil, fmt.Errorf("invalid callback %s: %w", id, err)
+	}
+	job.NextAttempt = time.UnixMilli(int64(binary.BigEndian.Uint64(value[:8])))
+	return &job, nil
+}
+
+// deleteJob removes a job and its schedule entry; a missing job is not an error
+func deleteJob(tx *bolt.Tx, id string) error {
+	jobs := tx.Bucket(jobsBucket)
+	value := jobs.Get([]byte(id))
+	if len(value) < 8 {
+		return nil
+	}
+	if err := tx.Bucket(scheduleBucket).Delete(scheduleKey(int64(binary.BigEndian.Uint64(value[:8])), id)); err != nil {
+		return err
+	}
+	return jobs.Delete([]byte(id))
+}
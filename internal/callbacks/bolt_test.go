@@ -0,0 +1,87 @@
+package callbacks
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"zipperfly/internal/models"
+)
+
+func TestBoltQueue(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "callbacks.db")
+	now := time.Now()
+
+	q, err := NewBoltQueue(path)
+	if err != nil {
+		t.Fatalf("NewBoltQueue() error = %v", err)
+	}
+
+	for _, job := range []*models.CallbackJob{
+		{ID: "late", NextAttempt: now.Add(-time.Second)},
+		{ID: "early", NextAttempt: now.Add(-time.Minute)},
+		{ID: "future", NextAttempt: now.Add(time.Hour)},
+	} {
+		job.Callback = &models.Callback{URL: "https://example.com/" + job.ID}
+		job.Payload = models.CallbackPayload{ID: job.ID, Status: "completed"}
+		if err := q.EnqueueCallback(ctx, job); err != nil {
+			t.Fatalf("EnqueueCallback(%s) error = %v", job.ID, err)
+		}
+	}
+
+	jobs, err := q.ClaimCallbacks(ctx, now, time.Minute, 10)
+	if err != nil {
+		t.Fatalf("ClaimCallbacks() error = %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != "early" || jobs[1].ID != "late" {
+		t.Fatalf("ClaimCallbacks() = %v, want the due jobs, earliest first", jobIDs(jobs))
+	}
+	if jobs[0].Callback.URL != "https://example.com/early" || jobs[0].Payload.Status != "completed" {
+		t.Errorf("claimed job = %+v, want the stored callback and payload", jobs[0])
+	}
+	if jobs[0].NextAttempt.UnixMilli() != now.Add(time.Minute).UnixMilli() {
+		t.Errorf("claimed NextAttempt = %v, want now plus the lease", jobs[0].NextAttempt)
+	}
+
+	// Claimed jobs are leased, so a second claim finds nothing due
+	if again, _ := q.ClaimCallbacks(ctx, now, time.Minute, 10); len(again) != 0 {
+		t.Errorf("second ClaimCallbacks() = %v, want none while leased", jobIDs(again))
+	}
+
+	// A rescheduled job comes due again, keeping its attempts
+	jobs[0].Attempts, jobs[0].LastError, jobs[0].NextAttempt = 1, "bad status: 500", now
+	if err := q.RescheduleCallback(ctx, jobs[0]); err != nil {
+		t.Fatalf("RescheduleCallback() error = %v", err)
+	}
+	if err := q.DeleteCallback(ctx, "late"); err != nil {
+		t.Fatalf("DeleteCallback() error = %v", err)
+	}
+
+	// Jobs survive reopening the file
+	if err := q.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	q, err = NewBoltQueue(path)
+	if err != nil {
+		t.Fatalf("reopening NewBoltQueue() error = %v", err)
+	}
+	defer q.Close()
+
+	jobs, err = q.ClaimCallbacks(ctx, now.Add(2*time.Minute), time.Minute, 10)
+	if err != nil {
+		t.Fatalf("ClaimCallbacks() after reopen error = %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != "early" || jobs[0].Attempts != 1 || jobs[0].LastError != "bad status: 500" {
+		t.Errorf("ClaimCallbacks() after reopen = %+v, want the rescheduled job only", jobs)
+	}
+}
+
+func jobIDs(jobs []*models.CallbackJob) []string {
+	ids := make([]string, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.ID
+	}
+	return ids
+}
@@ -0,0 +1,152 @@
+package callbacks
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/circuitbreaker"
+	"zipperfly/internal/metrics"
+	"zipperfly/internal/models"
+	"zipperfly/internal/retry"
+)
+
+const (
+	// claimLease is how long a claimed job is hidden from other dispatchers.
+	// It outlasts a delivery attempt, so a job is only claimed again if the
+	// dispatcher holding it died.
+	claimLease = 2 * time.Minute
+	// batchSize is the most jobs delivered at once
+	batchSize = 16
+)
+
+// Dispatcher delivers queued callbacks, retrying failures with exponential
+// backoff. Delivery is at least once: a job whose dispatcher stops mid-delivery
+// is retried once its lease expires.
+type Dispatcher struct {
+	queue      Queue
+	logger     *zap.Logger
+	metrics    *metrics.Metrics
+	breaker    *circuitbreaker.Breaker
+	maxRetries int
+	retryDelay time.Duration
+	maxDelay   time.Duration // cap on the delay between retries, 0 = none
+	maxElapsed time.Duration
+	interval   time.Duration
+}
+
+// NewDispatcher creates a dispatcher that checks queue for due callbacks
+// every interval, delivering through breaker if it isn't nil. Retries wait
+// for a jittered backoff from retryDelay, up to maxDelay (0 = no cap). A job is
+// abandoned after maxRetries retries, or once retrying would take it past
+// maxElapsed since it was queued (0 = no limit).
+func NewDispatcher(queue Queue, logger *zap.Logger, m *metrics.Metrics, breaker *circuitbreaker.Breaker, maxRetries int, retryDelay, maxDelay, maxElapsed, interval time.Duration) *Dispatcher {
+	return &Dispatcher{
+		queue:      queue,
+		logger:     logger,
+		metrics:    m,
+		breaker:    breaker,
+		maxRetries: maxRetries,
+		retryDelay: retryDelay,
+		maxDelay:   maxDelay,
+		maxElapsed: maxElapsed,
+		interval:   interval,
+	}
+}
+
+// Run delivers due callbacks until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		// Go straight on after a full batch, which suggests a backlog
+		if d.dispatch(ctx) == batchSize && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatch delivers one batch of due callbacks, returning how many it claimed
+func (d *Dispatcher) dispatch(ctx context.Context) int {
+	jobs, err := d.queue.ClaimCallbacks(ctx, time.Now(), claimLease, batchSize)
+	if err != nil {
+		if ctx.Err() == nil {
+			d.logger.Error("failed to claim queued callbacks", zap.Error(err))
+		}
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.deliver(ctx, job)
+		}()
+	}
+	wg.Wait()
+	return len(jobs)
+}
+
+// deliver makes one attempt at a job, then removes or reschedules it
+func (d *Dispatcher) deliver(ctx context.Context, job *models.CallbackJob) {
+	// A fresh context for queue updates, so shutdown can't lose the outcome
+	// of a finished attempt
+	storeCtx := context.WithoutCancel(ctx)
+	logger := d.logger
+	if job.Payload.RequestID != "" {
+		logger = logger.With(zap.String("request_id", job.Payload.RequestID))
+	}
+
+	if job.Callback == nil || job.Callback.URL == "" {
+		d.queue.DeleteCallback(storeCtx, job.ID)
+		return
+	}
+	if job.Attempts > 0 {
+		d.metrics.CallbackRetries.Inc()
+		logger.Info("retrying callback", zap.String("url", job.Callback.URL), zap.Int("attempt", job.Attempts))
+	}
+
+	err := Deliver(ctx, d.breaker, job.Callback, job.Payload)
+	if ctx.Err() != nil {
+		// Shutting down; the lease expires and the job is retried later
+		return
+	}
+	if err == nil {
+		d.metrics.CallbacksTotal.WithLabelValues("success").Inc()
+		if err := d.queue.DeleteCallback(storeCtx, job.ID); err != nil {
+			logger.Error("failed to remove delivered callback", zap.String("id", job.ID), zap.Error(err))
+		}
+		return
+	}
+
+	logger.Warn("callback attempt failed", zap.String("url", job.Callback.URL), zap.Int("attempt", job.Attempts), zap.Error(err))
+	delay := retry.Backoff(d.retryDelay, d.maxDelay, job.Attempts+1)
+	expired := d.maxElapsed > 0 && !job.CreatedAt.IsZero() && time.Since(job.CreatedAt)+delay > d.maxElapsed
+	if job.Attempts >= d.maxRetries || expired {
+		d.metrics.CallbacksTotal.WithLabelValues("failure").Inc()
+		logger.Error("callback failed after retries", zap.String("url", job.Callback.URL), zap.Int("total_attempts", job.Attempts+1), zap.Error(err))
+		if err := d.queue.DeleteCallback(storeCtx, job.ID); err != nil {
+			logger.Error("failed to remove abandoned callback", zap.String("id", job.ID), zap.Error(err))
+		}
+		return
+	}
+
+	// Exponential backoff with jitter: about retryDelay * 2^(attempts-1), up
+	// to CALLBACK_RETRY_MAX_DELAY
+	job.Attempts++
+	job.LastError = err.Error()
+	job.NextAttempt = time.Now().Add(delay)
+	if err := d.queue.RescheduleCallback(storeCtx, job); err != nil {
+		logger.Error("failed to reschedule callback", zap.String("id", job.ID), zap.Error(err))
+	}
+}
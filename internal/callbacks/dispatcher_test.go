@@ -0,0 +1,79 @@
+package callbacks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/metrics"
+	"zipperfly/internal/models"
+)
+
+func TestDispatcher_Dispatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		serverCode   int
+		attempts     int
+		age          time.Duration
+		maxRetries   int
+		maxElapsed   time.Duration
+		wantQueued   bool
+		wantAttempts int
+	}{
+		{name: "delivered", serverCode: http.StatusOK, maxRetries: 3},
+		{name: "failed with retries left", serverCode: http.StatusBadGateway, maxRetries: 3, wantQueued: true, wantAttempts: 1},
+		{name: "failed on last retry", serverCode: http.StatusBadGateway, attempts: 3, maxRetries: 3},
+		{name: "failed past max elapsed", serverCode: http.StatusBadGateway, age: time.Hour, maxRetries: 3, maxElapsed: time.Minute},
+		{name: "failed within max elapsed", serverCode: http.StatusBadGateway, age: time.Second, maxRetries: 3, maxElapsed: time.Minute, wantQueued: true, wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.serverCode)
+			}))
+			defer server.Close()
+
+			q, err := NewBoltQueue(filepath.Join(t.TempDir(), "callbacks.db"))
+			if err != nil {
+				t.Fatalf("NewBoltQueue() error = %v", err)
+			}
+			defer q.Close()
+
+			job := &models.CallbackJob{
+				ID:          "job-1",
+				Callback:    &models.Callback{URL: server.URL},
+				Payload:     models.CallbackPayload{ID: "test", Status: "completed"},
+				Attempts:    tt.attempts,
+				CreatedAt:   time.Now().Add(-tt.age),
+				NextAttempt: time.Now().Add(-time.Second),
+			}
+			if err := q.EnqueueCallback(ctx, job); err != nil {
+				t.Fatalf("EnqueueCallback() error = %v", err)
+			}
+
+			d := NewDispatcher(q, zap.NewNop(), metrics.New(), nil, tt.maxRetries, time.Millisecond, 0, tt.maxElapsed, time.Second)
+			if n := d.dispatch(ctx); n != 1 {
+				t.Fatalf("dispatch() = %d, want 1 job claimed", n)
+			}
+
+			// Look past the lease and any backoff for what remains queued
+			jobs, err := q.ClaimCallbacks(ctx, time.Now().Add(time.Hour), time.Minute, 10)
+			if err != nil {
+				t.Fatalf("ClaimCallbacks() error = %v", err)
+			}
+			if gotQueued := len(jobs) == 1; gotQueued != tt.wantQueued {
+				t.Fatalf("job queued = %v, want %v", gotQueued, tt.wantQueued)
+			}
+			if tt.wantQueued && (jobs[0].Attempts != tt.wantAttempts || jobs[0].LastError == "") {
+				t.Errorf("rescheduled job = %+v, want %d attempts and the last error", jobs[0], tt.wantAttempts)
+			}
+		})
+	}
+}
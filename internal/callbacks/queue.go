@@ -0,0 +1,27 @@
+// Package callbacks delivers download callbacks, optionally through a
+// durable queue so that pending deliveries survive restarts.
+package callbacks
+
+import (
+	"context"
+	"time"
+
+	"zipperfly/internal/models"
+)
+
+// Queue stores callbacks until they are delivered. It is implemented by the
+// bolt file queue and by database stores that can hold one:
+//
+//	if q, ok := store.(callbacks.Queue); ok { ... }
+type Queue interface {
+	// EnqueueCallback stores a job for delivery at job.NextAttempt
+	EnqueueCallback(ctx context.Context, job *models.CallbackJob) error
+	// ClaimCallbacks returns up to limit jobs due at now and hides them from
+	// other claims until now+lease, so concurrent dispatchers don't deliver
+	// the same job twice
+	ClaimCallbacks(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.CallbackJob, error)
+	// RescheduleCallback saves a failed job's attempts, error, and next attempt
+	RescheduleCallback(ctx context.Context, job *models.CallbackJob) error
+	// DeleteCallback removes a delivered or abandoned job
+	DeleteCallback(ctx context.Context, id string) error
+}
@@ -0,0 +1,84 @@
+package callbacks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sony/gobreaker"
+
+	"zipperfly/internal/circuitbreaker"
+	"zipperfly/internal/models"
+)
+
+// client sends callback requests, with a reasonable timeout for each
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// ErrCircuitOpen is returned by Deliver without sending while the callback
+// circuit breaker is open
+var ErrCircuitOpen = gobreaker.ErrOpenState
+
+// Deliver makes a single callback request like Send, through breaker when it
+// isn't nil, so a dead endpoint fails fast instead of costing a connection
+// and a timeout per attempt
+func Deliver(ctx context.Context, breaker *circuitbreaker.Breaker, callback *models.Callback, payload models.CallbackPayload) error {
+	if breaker == nil {
+		return Send(ctx, callback, payload)
+	}
+	_, err := breaker.Execute(func() (interface{}, error) {
+		return nil, Send(ctx, callback, payload)
+	})
+	return err
+}
+
+// Send makes a single callback request: the payload as JSON, or rendered
+// through the callback's template, with its method and headers
+func Send(ctx context.Context, callback *models.Callback, payload models.CallbackPayload) error {
+	tmpl, err := callback.Template()
+	if err != nil {
+		return err
+	}
+	var body []byte
+	if tmpl != nil {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, payload); err != nil {
+			return fmt.Errorf("payload template error: %w", err)
+		}
+		body = buf.Bytes()
+	} else if body, err = json.Marshal(payload); err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+
+	method := http.MethodPost
+	if callback.Method != "" {
+		method = strings.ToUpper(callback.Method)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, callback.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("request creation error: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range callback.Headers {
+		req.Header.Set(k, v)
+	}
+	if callback.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+callback.BearerToken)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("bad status: %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package callbacks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"zipperfly/internal/circuitbreaker"
+	"zipperfly/internal/config"
+	"zipperfly/internal/metrics"
+	"zipperfly/internal/models"
+)
+
+func TestDeliver_CircuitOpen(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	breaker := circuitbreaker.New("callback-deliver-test", &config.Config{
+		CircuitBreakerThreshold:   2,
+		CircuitBreakerTimeout:     time.Minute,
+		CircuitBreakerMaxRequests: 1,
+	}, metrics.New())
+	callback := &models.Callback{URL: server.URL}
+	payload := models.CallbackPayload{ID: "test", Status: "completed"}
+
+	for i := 0; i < 2; i++ {
+		if err := Deliver(context.Background(), breaker, callback, payload); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Deliver() attempt %d error = %v, want the bad status", i+1, err)
+		}
+	}
+	if err := Deliver(context.Background(), breaker, callback, payload); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Deliver() with the circuit open error = %v, want ErrCircuitOpen", err)
+	}
+	if requests != 2 {
+		t.Errorf("requests = %d, want 2 (none while open)", requests)
+	}
+}
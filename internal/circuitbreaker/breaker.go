@@ -1,48 +1,192 @@
 package circuitbreaker
 
 import (
+	"sync"
+
 	"github.com/sony/gobreaker"
 
 	"zipperfly/internal/config"
 	"zipperfly/internal/metrics"
 )
 
-// Breaker wraps gobreaker with metrics
+// Breaker wraps gobreaker with metrics. A per-key breaker keeps a separate
+// gobreaker for each key passed to ExecuteFor, e.g. each storage bucket,
+// so one failing key doesn't reject the others; its metrics are aggregated
+// under its name.
 type Breaker struct {
-	cb      *gobreaker.CircuitBreaker
 	metrics *metrics.Metrics
 	name    string
+	cfg     *config.Config
+	perKey  bool
+
+	mu        sync.Mutex
+	breakers  map[string]*gobreaker.CircuitBreaker // by key, "" for a shared breaker
+	states    map[string]gobreaker.State           // last state change of each, for the metrics
+	trips     map[string]gobreaker.Counts          // counts of each when it last tripped
+	listeners []func(StateChange)
+}
+
+// StateChange is a transition of one of a breaker's gobreakers
+type StateChange struct {
+	Name   string // breaker name, e.g. storage or callback
+	Key    string // bucket of a per-key breaker, "" for a shared one
+	From   gobreaker.State
+	To     gobreaker.State
+	Counts gobreaker.Counts // requests and failures that tripped it; zero unless it opened from closed
 }
 
 // New creates a new circuit breaker
 func New(name string, cfg *config.Config, m *metrics.Metrics) *Breaker {
-	settings := gobreaker.Settings{
-		Name:        name,
-		MaxRequests: uint32(cfg.CircuitBreakerMaxRequests),
-		Interval:    cfg.CircuitBreakerTimeout,
-		Timeout:     cfg.CircuitBreakerTimeout,
+	return &Breaker{
+		metrics:  m,
+		name:     name,
+		cfg:      cfg,
+		breakers: make(map[string]*gobreaker.CircuitBreaker),
+		states:   make(map[string]gobreaker.State),
+		trips:    make(map[string]gobreaker.Counts),
+	}
+}
+
+// NewPerKey creates a circuit breaker that trips separately for each key
+func NewPerKey(name string, cfg *config.Config, m *metrics.Metrics) *Breaker {
+	b := New(name, cfg, m)
+	b.perKey = true
+	return b
+}
+
+// NewStorage creates the storage breaker of cfg: one per bucket, or one
+// shared by all with CIRCUIT_BREAKER_SCOPE=global
+func NewStorage(name string, cfg *config.Config, m *metrics.Metrics) *Breaker {
+	if cfg.CircuitBreakerScope == "global" {
+		return New(name, cfg, m)
+	}
+	return NewPerKey(name, cfg, m)
+}
+
+// breaker returns the gobreaker for key, creating it on first use
+func (b *Breaker) breaker(key string) *gobreaker.CircuitBreaker {
+	if !b.perKey {
+		key = ""
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cb, ok := b.breakers[key]; ok {
+		return cb
+	}
+
+	cb := gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:        b.name,
+		MaxRequests: uint32(b.cfg.CircuitBreakerMaxRequests),
+		Interval:    b.cfg.CircuitBreakerTimeout,
+		Timeout:     b.cfg.CircuitBreakerTimeout,
 		ReadyToTrip: func(counts gobreaker.Counts) bool {
-			return counts.ConsecutiveFailures >= uint32(cfg.CircuitBreakerThreshold)
+			if counts.ConsecutiveFailures < uint32(b.cfg.CircuitBreakerThreshold) {
+				return false
+			}
+			// Keep the counts for the state change, which gobreaker makes
+			// after clearing them
+			b.mu.Lock()
+			b.trips[key] = counts
+			b.mu.Unlock()
+			return true
 		},
-		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
-			// Update metrics
-			m.CircuitBreakerState.WithLabelValues(name).Set(float64(to))
+		OnStateChange: func(_ string, from gobreaker.State, to gobreaker.State) {
+			b.stateChanged(key, from, to)
 		},
+	})
+	b.breakers[key] = cb
+	return cb
+}
+
+// OnStateChange adds a function called on each state change of the
+// breaker, or of any of its keys' breakers. It is called with that key's
+// gobreaker locked, so it must be quick and mustn't call back into the
+// breaker.
+func (b *Breaker) OnStateChange(fn func(StateChange)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, fn)
+}
+
+// stateChanged records key's new state, updates the metrics (the state of
+// the worst breaker and the number open), and tells the listeners. It is
+// called with the gobreaker's lock held, so it mustn't call back into any
+// gobreaker.
+func (b *Breaker) stateChanged(key string, from, to gobreaker.State) {
+	b.mu.Lock()
+	b.states[key] = to
+	change := StateChange{Name: b.name, Key: key, From: from, To: to}
+	if from == gobreaker.StateClosed && to == gobreaker.StateOpen {
+		change.Counts = b.trips[key]
 	}
+	listeners := b.listeners
+	b.updateMetrics(to)
+	b.mu.Unlock()
 
-	return &Breaker{
-		cb:      gobreaker.NewCircuitBreaker(settings),
-		metrics: m,
-		name:    name,
+	for _, fn := range listeners {
+		fn(change)
+	}
+}
+
+// updateMetrics sets the state and open breakers gauges from the recorded
+// states, and counts the transition to to. b.mu must be held.
+func (b *Breaker) updateMetrics(to gobreaker.State) {
+	b.metrics.CircuitBreakerTransitions.WithLabelValues(b.name, to.String()).Inc()
+
+	states := make([]gobreaker.State, 0, len(b.states))
+	for _, state := range b.states {
+		states = append(states, state)
+	}
+	b.metrics.CircuitBreakerState.WithLabelValues(b.name).Set(float64(worst(states)))
+	open := 0
+	for _, state := range states {
+		if state == gobreaker.StateOpen {
+			open++
+		}
 	}
+	b.metrics.CircuitBreakersOpen.WithLabelValues(b.name).Set(float64(open))
 }
 
 // Execute runs the given function through the circuit breaker
 func (b *Breaker) Execute(fn func() (interface{}, error)) (interface{}, error) {
-	return b.cb.Execute(fn)
+	return b.breaker("").Execute(fn)
 }
 
-// State returns the current state of the circuit breaker
+// ExecuteFor runs the given function through key's circuit breaker, or the
+// shared one if the breaker isn't per key
+func (b *Breaker) ExecuteFor(key string, fn func() (interface{}, error)) (interface{}, error) {
+	return b.breaker(key).Execute(fn)
+}
+
+// State returns the current state of the circuit breaker: for a per-key
+// breaker, open if any key's is, else half-open if any key's is
 func (b *Breaker) State() gobreaker.State {
-	return b.cb.State()
+	b.mu.Lock()
+	breakers := make([]*gobreaker.CircuitBreaker, 0, len(b.breakers))
+	for _, cb := range b.breakers {
+		breakers = append(breakers, cb)
+	}
+	b.mu.Unlock()
+
+	states := make([]gobreaker.State, len(breakers))
+	for i, cb := range breakers {
+		states[i] = cb.State()
+	}
+	return worst(states)
+}
+
+// worst returns open if any of states is, else half-open if any is, else
+// closed
+func worst(states []gobreaker.State) gobreaker.State {
+	result := gobreaker.StateClosed
+	for _, state := range states {
+		switch state {
+		case gobreaker.StateOpen:
+			return gobreaker.StateOpen
+		case gobreaker.StateHalfOpen:
+			result = gobreaker.StateHalfOpen
+		}
+	}
+	return result
 }
@@ -5,6 +5,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sony/gobreaker"
+
 	"zipperfly/internal/config"
 	"zipperfly/internal/metrics"
 )
@@ -65,3 +69,95 @@ func TestCircuitBreaker(t *testing.T) {
 		}
 	})
 }
+
+func TestCircuitBreaker_PerKey(t *testing.T) {
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	cfg := &config.Config{
+		CircuitBreakerThreshold:   2,
+		CircuitBreakerTimeout:     time.Minute,
+		CircuitBreakerMaxRequests: 1,
+	}
+	testErr := errors.New("throttled")
+	fail := func() (interface{}, error) { return nil, testErr }
+	succeed := func() (interface{}, error) { return "ok", nil }
+
+	tests := []struct {
+		name       string
+		breaker    *Breaker
+		wantHotErr bool // whether the healthy key is rejected once the failing one trips
+		wantOpen   float64
+	}{
+		{name: "per key", breaker: NewPerKey("storage_per_key", cfg, m), wantOpen: 1},
+		{name: "shared", breaker: New("storage_shared", cfg, m), wantHotErr: true, wantOpen: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				tt.breaker.ExecuteFor("cold", fail)
+			}
+			if _, err := tt.breaker.ExecuteFor("cold", succeed); !errors.Is(err, gobreaker.ErrOpenState) {
+				t.Errorf("failing key: Execute() error = %v, want %v", err, gobreaker.ErrOpenState)
+			}
+			if _, err := tt.breaker.ExecuteFor("hot", succeed); (err != nil) != tt.wantHotErr {
+				t.Errorf("healthy key: Execute() error = %v, want error %v", err, tt.wantHotErr)
+			}
+
+			if got := tt.breaker.State(); got != gobreaker.StateOpen {
+				t.Errorf("State() = %s, want open", got)
+			}
+			if got := testutil.ToFloat64(m.CircuitBreakerState.WithLabelValues(tt.breaker.name)); got != float64(gobreaker.StateOpen) {
+				t.Errorf("state metric = %g, want %d", got, gobreaker.StateOpen)
+			}
+			if got := testutil.ToFloat64(m.CircuitBreakersOpen.WithLabelValues(tt.breaker.name)); got != tt.wantOpen {
+				t.Errorf("open breakers metric = %g, want %g", got, tt.wantOpen)
+			}
+		})
+	}
+}
+
+func TestNewStorage(t *testing.T) {
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	if b := NewStorage("storage", &config.Config{CircuitBreakerScope: "bucket"}, m); !b.perKey {
+		t.Error("NewStorage() with bucket scope isn't per key")
+	}
+	if b := NewStorage("storage", &config.Config{CircuitBreakerScope: "global"}, m); b.perKey {
+		t.Error("NewStorage() with global scope is per key")
+	}
+}
+
+func TestCircuitBreaker_OnStateChange(t *testing.T) {
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	cfg := &config.Config{
+		CircuitBreakerThreshold:   2,
+		CircuitBreakerTimeout:     50 * time.Millisecond,
+		CircuitBreakerMaxRequests: 1,
+	}
+	b := NewPerKey("storage", cfg, m)
+	var changes []StateChange
+	b.OnStateChange(func(change StateChange) { changes = append(changes, change) })
+
+	b.ExecuteFor("hot", func() (interface{}, error) { return "ok", nil })
+	for i := 0; i < 2; i++ {
+		b.ExecuteFor("cold", func() (interface{}, error) { return nil, errors.New("throttled") })
+	}
+	time.Sleep(60 * time.Millisecond)
+	b.ExecuteFor("cold", func() (interface{}, error) { return "ok", nil })
+
+	want := []StateChange{
+		{Name: "storage", Key: "cold", From: gobreaker.StateClosed, To: gobreaker.StateOpen, Counts: gobreaker.Counts{Requests: 2, TotalFailures: 2, ConsecutiveFailures: 2}},
+		{Name: "storage", Key: "cold", From: gobreaker.StateOpen, To: gobreaker.StateHalfOpen},
+		{Name: "storage", Key: "cold", From: gobreaker.StateHalfOpen, To: gobreaker.StateClosed},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("got %d state changes %+v, want %d", len(changes), changes, len(want))
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("change %d = %+v, want %+v", i, changes[i], want[i])
+		}
+	}
+	if got := testutil.ToFloat64(m.CircuitBreakerTransitions.WithLabelValues("storage", "closed")); got != 1 {
+		t.Errorf("transitions to closed = %g, want 1", got)
+	}
+}
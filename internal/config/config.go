@@ -1,14 +1,27 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"html/template"
+	"math"
+	"net"
 	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"zipperfly/internal/models"
+	"zipperfly/sign"
 )
 
+// maxExtraFileSize caps each extra file, which is held in memory
+const maxExtraFileSize = 1 << 20
+
 // Config holds all application configuration
 type Config struct {
 	// Database
@@ -18,45 +31,135 @@ type Config struct {
 	TableName        string
 	IDField          string
 	KeyPrefix        string // For Redis
+	CassandraConsistency string // For Cassandra/Scylla (default: LOCAL_QUORUM)
+
+	// Database TLS (Postgres, MySQL, Redis)
+	DBTLSMode string // "disable", "require", "verify-ca", "verify-full"; empty = use DB_URL settings
+	DBTLSCA   string // PEM CA bundle used to verify the server
+	DBTLSCert string // PEM client certificate for mutual TLS
+	DBTLSKey  string // PEM client key for mutual TLS
 
 	// Storage
-	StorageType       string // "s3" or "local"
-	StoragePath       string // For local filesystem storage
+	StorageType       string           // "s3" or "local"
+	StoragePath       string           // For local filesystem storage
+	StorageFailover   *StorageFailover // secondary storage tried when this fails, nil = none
 
 	// S3
-	S3Endpoint        string
-	S3Region          string
-	S3AccessKeyID     string
-	S3SecretAccessKey string
-	S3UsePathStyle    bool
+	S3Endpoint          string
+	S3Region            string
+	S3AccessKeyID       string
+	S3SecretAccessKey   string
+	S3UsePathStyle      bool
+	S3HealthCheck       string // "list_buckets" (default), "head_bucket", "head_object", or "none"
+	S3HealthCheckBucket string // bucket probed by head_bucket and head_object
+	S3HealthCheckKey    string // object probed by head_object
 
 	// Security
-	EnforceSigning bool
-	SigningSecret  []byte
+	EnforceSigning      bool
+	SigningSecrets      map[string][]byte // key ID -> HMAC secret; "" for a single unnamed secret
+	SigningKeyID        string            // key that signs new links (the first listed), "" for a single secret
+	MinSignatureVersion int               // oldest signature scheme accepted: 1, or 2 to reject version 1 links
+	SignUsername        string            // basic auth for POST /sign, which is disabled without it
+	SignPassword        string
+	SignTTL             time.Duration            // default lifetime of links from POST /sign
+	PublicURL           string                   // base of links from POST /sign, "" = the request's scheme and host
+	JWTSecret           []byte                   // HS256 key for JWT bearer tokens
+	JWTPublicKey        []byte                   // PEM RSA public key for RS256 tokens
+	JWTJWKSURL          string                   // JWKS with RS256 keys, looked up by "kid"
+	JWTIssuer           string                   // required "iss" claim, "" = any
+	JWTAudience         string                   // required "aud" claim, "" = any
+	JWTUserClaim        string                   // claim checked against a record's bound_user_id
+	APIKeys             map[string]models.APIKey // API key -> its name and rate, for API_KEY_STORE=config
+	APIKeyStore         string                   // "config" (API_KEYS) or "database"
+	APIKeyTable         string                   // SQL table for API_KEY_STORE=database
+	APIKeyRateLimit     float64                  // requests/second per API key that doesn't set its own, 0 = unlimited
+
+	// Record cache
+	RecordCacheTTL   time.Duration // serve fetched records without a query for this long, 0 = always query
+	RecordCacheStale time.Duration // serve cached records up to this old while the database fails, 0 = never
+	RecordCacheSize  int           // records kept (default: 10000)
 
 	// Timeouts (in seconds)
 	DatabaseQueryTimeout time.Duration
 	StorageFetchTimeout  time.Duration
-	RequestTimeout       time.Duration
+	RequestTimeout       time.Duration // whole non-streaming requests, 0 = no limit
+	FileFetchTimeout     time.Duration // whole fetch of one file, 0 = no limit
+	StallTimeout         time.Duration // max gap between reads of one file, 0 = disabled
+	FlushInterval        time.Duration // max time streamed bytes wait in the server's buffers, 0 = until they fill (default: 1s)
 
 	// Resource Limits
-	MaxActiveDownloads int     // max concurrent downloads, 0 = unlimited
-	MaxFilesPerRequest int     // max files per download, 0 = unlimited
-	RateLimitPerIP     float64 // requests per second per IP, 0 = unlimited
+	MaxActiveDownloads   int     // max concurrent downloads, 0 = unlimited
+	DownloadQueueLength  int           // downloads that may wait for a slot at capacity, 0 = none (default: 0)
+	DownloadQueueWait    time.Duration // how long each may wait before 503 (default: 10s)
+
+	// Adaptive concurrency
+	AdaptiveConcurrency  bool          // scale MaxConcurrent and MaxActiveDownloads to memory and write backpressure
+	AdaptiveMemoryLimit  int64         // heap bytes to stay under, 0 = GOMEMLIMIT
+	AdaptiveBackpressure float64       // share of streaming time blocked on client writes that counts as backpressure (default: 0.9)
+	AdaptiveInterval     time.Duration // how often the limits are adjusted (default: 1s)
+	MaxFilesPerRequest   int     // max files per download, 0 = unlimited
+	RateLimitPerIP       float64 // requests per second per IP, 0 = unlimited
+	RateLimitBurst       int     // requests an IP may make at once before the rate applies
+	RateLimitPerRecord   float64 // requests per second per download ID, 0 = unlimited
+	RateLimitRecordBurst int     // requests for one ID at once before the rate applies
+	LimitsRedisURL       string  // Redis holding the limits shared by every replica, "" = per process
+	LimitsKeyPrefix      string  // prefix of the limit keys in LimitsRedisURL
+	SpoolMemoryLimit     int     // bytes of each prefetched file kept in memory before spilling to disk
+	SpoolDir             string  // directory for spill files (default: OS temp dir)
+	MaxFileSize          int64   // max bytes of one file, 0 = unlimited
+	MaxArchiveSize       int64   // max bytes of one archive as sent, 0 = unlimited
+
+	// Async Builds
+	AsyncBuilds bool          // enable POST /{id}/prepare and GET /{id}/status
+	StagingDir  string        // directory for prepared archives (default: OS temp dir/zipperfly-staging)
+	StagingTTL  time.Duration // how long a prepared archive stays downloadable (default: 1h)
+
+	// Progress Events
+	ProgressEvents bool // enable GET /{id}/progress (Server-Sent Events)
+
+	// Download Tokens
+	DownloadTokens         bool          // enable POST /{id}, redirecting to a one-time GET /{token}
+	DownloadTokenTTL       time.Duration // how long an unredeemed token is valid (default: 1m)
+	DownloadTokenRedisURL  string        // Redis holding tokens shared by every replica, "" = per process
+	DownloadTokenKeyPrefix string        // prefix of the token keys in DownloadTokenRedisURL
+	DownloadTokenOrigins   []string      // origins allowed to post for a token, empty = any
+
+	// CORS
+	CORSAllowedOrigins   []string      // origins browsers may call the API from, "*" = any, empty = CORS disabled
+	CORSAllowedMethods   []string      // methods allowed in preflight responses
+	CORSAllowedHeaders   []string      // request headers allowed in preflight responses
+	CORSExposedHeaders   []string      // response headers scripts may read
+	CORSAllowCredentials bool          // allow cookies and HTTP auth in cross-origin requests
+	CORSMaxAge           time.Duration // how long browsers cache a preflight response
+
+	// Error responses
+	ErrorFormat string             // text (default), auto (by Accept), or json
+	ErrorPage   *template.Template // HTML error page from ERROR_PAGE_TEMPLATE, nil = the built-in one
 
 	// Retries
-	StorageMaxRetries int
-	StorageRetryDelay time.Duration
+	StorageMaxRetries    int
+	StorageRetryDelay    time.Duration
+	StorageRetryMaxDelay time.Duration // cap on the delay between retries, 0 = none (default: 30s)
+	StorageRetryBudget   int           // retries shared by the fetches of one archive, 0 = unlimited (default: 10)
+
+	// Hedging
+	StorageHedgePercentile float64       // send a second S3 request once the first is slower than this percentile of recent ones, 0 = off (default: 0)
+	StorageHedgeMinDelay   time.Duration // never hedge sooner than this (default: 50ms)
 
 	// Circuit Breaker
 	CircuitBreakerThreshold   int           // failures before opening
 	CircuitBreakerTimeout     time.Duration // time to wait before half-open
 	CircuitBreakerMaxRequests int           // max requests in half-open state
+	CircuitBreakerScope       string        // storage breakers: "bucket" (one per bucket) or "global" (default: bucket)
+	CircuitBreakerEvents      bool          // publish breaker state changes to the event bus
 
 	// Features
 	AppendYMD             bool
 	SanitizeNames         bool
+	SanitizeCharset       string // characters kept when sanitizing: unicode or ascii (default: unicode)
+	AllowEmptyRecords     bool   // serve records without objects as empty archives instead of 422
 	IgnoreMissing         bool
+	AbortOnStreamError    bool // reset the connection instead of finishing a failed archive
 	MaxConcurrent         int64
 	AllowPasswordProtected bool
 
@@ -64,13 +167,95 @@ type Config struct {
 	AllowedExtensions []string // empty = allow all
 	BlockedExtensions []string
 
+	// Bucket Policy
+	AllowedBuckets []string // buckets/path roots records may reference, empty = allow all
+
+	// Access Windows
+	AccessWindows      []string // e.g. "Mon-Fri 08:00-18:00", empty = always open
+	MaintenanceWindows []string // e.g. "Sun 02:00-04:00"
+	AccessTimezone     string   // IANA timezone for windows (default: UTC)
+
+	// Authorization Webhook
+	AuthWebhookURL     string        // optional endpoint consulted before each download
+	AuthWebhookTimeout time.Duration // timeout for the authorization request
+
+	// Archive Output
+	CompressionLevel   int               // Deflate level (1-9) for ZIP and tar.gz, 0 = library default
+	ZstdLevel          int               // zstd level (1-22) for tar.zst output (default: 3)
+	ZipStoreOnly       bool              // write every ZIP entry uncompressed
+	ZipStoreExtensions []string          // extensions written uncompressed, e.g. ".jpg,.mp4,.gz"
+	ZipEncryption      string            // encryption for password-protected ZIPs: zipcrypto or aes256 (default: zipcrypto)
+	ArchiveManifest    bool              // append a manifest entry to every archive
+	ManifestFormat     string            // manifest encoding: json or csv (default: json)
+	ExtraFiles         map[string]string // entries added to every archive: file name -> content
+	EntryOrder         string            // archive entry order: completion, record, or sorted (default: completion)
+
 	// Callback
-	CallbackMaxRetries int
-	CallbackRetryDelay time.Duration
+	CallbackMaxRetries    int
+	CallbackRetryDelay    time.Duration
+	CallbackRetryMaxDelay time.Duration    // cap on the delay between retries, 0 = none (default: 1m)
+	CallbackMaxElapsed    time.Duration    // stop retrying a callback this long after the first attempt (0 = no limit)
+	CallbackQueue         string           // "memory" (in-process retries), "bolt", or "database"
+	CallbackQueuePath     string           // bolt file for CALLBACK_QUEUE=bolt
+	CallbackQueueTable    string           // SQL table for CALLBACK_QUEUE=database
+	CallbackQueueInterval time.Duration    // how often queued callbacks are checked for delivery
+	DisableCallbacks      bool             // don't send any callbacks (e.g. when an event bus replaces them)
+	DefaultCallbacks      models.Callbacks // notified of every download, in addition to the record's callbacks
+	CallbackStarted       bool             // also send a "started" callback when a download begins
+	CallbackProgressBytes int64            // also send a "progress" callback every this many bytes (0 = never)
+	CallbackFileResults   bool             // include each object's outcome in the callback payload
+
+	// Events
+	EventBus    string // "none", "kafka", "nats", or "sqs"
+	EventBusURL string // Kafka brokers (comma-separated), NATS server URL, or SQS queue URL
+	EventTopic  string // Kafka topic or NATS subject prefix (default: zipperfly.downloads)
 
 	// Server
-	Port        string
-	EnableHTTPS bool
+	Port            string
+	UnixSocket      string      // unix socket path listened on instead of PORT or HTTPS_PORT, "" = TCP
+	UnixSocketMode  os.FileMode // permissions of UnixSocket (default: 0660)
+	EnableHTTPS     bool
+	TrustedProxies  []*net.IPNet  // proxies whose X-Forwarded-For / X-Real-IP are trusted
+	ClientIPHeader  string        // the one forwarding header TrustedProxies set: forwarded, x-forwarded-for, or x-real-ip; "" = any
+	ShutdownTimeout time.Duration // how long active downloads get to finish on SIGTERM before they're cut off, 0 = none (default: 10s)
+	BasePath        string        // path the signing and download endpoints are served under, e.g. "/download"; "" = the root
+	LegacyRedirects bool          // with BasePath, redirect the endpoints' root paths to it (default: true)
+
+	// HTTP server hardening
+	ReadHeaderTimeout time.Duration // reading a request's headers, 0 = no limit (default: 10s)
+	IdleTimeout       time.Duration // keep-alive connections idle between requests, 0 = no limit (default: 120s)
+	WriteTimeout      time.Duration // writing a response, except downloads and progress streams, 0 = no limit
+	MaxHeaderBytes    int           // request header size limit, 0 = net/http's 1 MB
+	EnableHTTP2       bool          // negotiate HTTP/2 on the HTTPS listener (default: true)
+	EnableH2C         bool          // accept HTTP/2 without TLS (h2c) on the HTTP listener, for proxies that speak it
+
+	// Tenant profiles, each served to requests for its hosts or under its
+	// path prefix
+	Tenants []Tenant
+
+	// Admin listener (pprof, expvar, goroutine dump)
+	AdminPort     string // "" = disabled
+	AdminUsername string
+	AdminPassword string
+
+	// Internal listener (/metrics and the health checks)
+	InternalPort string // "" = served on the public listener
+
+	// Client Certificates (mutual TLS on the HTTPS listener)
+	ClientCAFile    string   // PEM CA bundle client certificates must chain to; empty = none required
+	ClientCertNames []string // CN or SAN one of which the client certificate must carry, none = any
+
+	// Client Filters (checked before any record lookup)
+	IPAllowlist      []*net.IPNet // client networks allowed, none = all
+	IPDenylist       []*net.IPNet // client networks refused, even when allowlisted
+	GeoIPDatabase    string       // MaxMind .mmdb file for country lookups
+	AllowedCountries []string     // ISO 3166-1 alpha-2 codes allowed, none = all
+	BlockedCountries []string     // ISO 3166-1 alpha-2 codes refused
+
+	// HTTPS, with a certificate from Let's Encrypt or TLS_CERT_FILE
+	HTTPSPort   string // HTTPS listener port (default: 443)
+	TLSCertFile string // PEM certificate chain, "" = Let's Encrypt
+	TLSKeyFile  string // PEM private key of TLSCertFile
 
 	// Let's Encrypt
 	LetsEncryptDomains  []string
@@ -78,14 +263,57 @@ type Config struct {
 	LetsEncryptEmail    string
 
 	// Metrics
-	MetricsUsername string
-	MetricsPassword string
+	MetricsUsername    string
+	MetricsPassword    string
+	MetricsTenantLabel string // label download metrics by "bucket" or the record's "tenant" field, "" = off
+	MetricsTenantLimit int    // distinct tenant label values before the rest are counted as "other" (default: 100)
+
+	// Slow and large download reporting
+	SlowDownloadThreshold  time.Duration // warn about downloads taking longer than this, 0 = off
+	LargeDownloadThreshold int64         // warn about downloads sending more bytes than this, 0 = off
+
+	// Health checks
+	HealthCacheTTL time.Duration // how long /readyz reuses dependency check results, 0 = check every probe (default: 5s)
+
+	// Access Log
+	AccessLog           bool    // log one structured entry per request
+	AccessLogOutput     string  // zap output path for the access log, e.g. a file or "stdout" (default: stderr)
+	AccessLogSampleRate float64 // share of successful requests logged, errors always are (default: 1)
+
+	// Logging
+	LogLevel string // debug, info (default), warn, or error
 }
 
 // Load reads configuration from environment variables
 func Load() (*Config, error) {
-	dbURL := os.Getenv("DB_URL")
-	if dbURL == "" {
+	return load(true)
+}
+
+// LoadWithoutDB is Load for commands that don't use the database, e.g.
+// signing a link: DB_URL is optional, and DBURL and DBEngine are empty
+// without it
+func LoadWithoutDB() (*Config, error) {
+	return load(false)
+}
+
+func load(requireDB bool) (*Config, error) {
+	strict, err := strictConfig()
+	if err != nil {
+		return nil, err
+	}
+	if strict {
+		if err := validateSettings(os.Environ()); err != nil {
+			return nil, fmt.Errorf("invalid configuration (set STRICT_CONFIG=false to fall back to defaults instead):\n%w", err)
+		}
+	}
+
+	secrets, err := readSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	dbURL := secrets["DB_URL"]
+	if dbURL == "" && requireDB {
 		return nil, fmt.Errorf("DB_URL required")
 	}
 
@@ -94,7 +322,7 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("invalid DB_URL: %w", err)
 	}
 
-	maxConcurrentStr := os.Getenv("MAX_CONCURRENT_FETCHES")
+	maxConcurrentStr := Getenv("MAX_CONCURRENT_FETCHES")
 	maxConcurrent := int64(10) // default
 	if maxConcurrentStr != "" {
 		maxConcurrent, err = strconv.ParseInt(maxConcurrentStr, 10, 64)
@@ -103,56 +331,154 @@ func Load() (*Config, error) {
 		}
 	}
 
-	enforceSigning, _ := strconv.ParseBool(os.Getenv("ENFORCE_SIGNING"))
-	appendYMD, _ := strconv.ParseBool(os.Getenv("APPEND_YMD"))
-	sanitizeNames, _ := strconv.ParseBool(os.Getenv("SANITIZE_FILENAMES"))
-	ignoreMissing, _ := strconv.ParseBool(os.Getenv("IGNORE_MISSING"))
-	enableHTTPS, _ := strconv.ParseBool(os.Getenv("ENABLE_HTTPS"))
+	spoolMemoryLimit := parseInt(Getenv("SPOOL_MEMORY_LIMIT"), 1<<20)
+	if spoolMemoryLimit < 0 {
+		return nil, fmt.Errorf("invalid SPOOL_MEMORY_LIMIT %d: cannot be negative", spoolMemoryLimit)
+	}
+
+	enforceSigning, _ := strconv.ParseBool(Getenv("ENFORCE_SIGNING"))
+	appendYMD, _ := strconv.ParseBool(Getenv("APPEND_YMD"))
+	sanitizeNames, _ := strconv.ParseBool(Getenv("SANITIZE_FILENAMES"))
+	ignoreMissing, _ := strconv.ParseBool(Getenv("IGNORE_MISSING"))
+	allowEmptyRecords, _ := strconv.ParseBool(Getenv("ALLOW_EMPTY_RECORDS"))
+	sanitizeCharset := strings.ToLower(Getenv("SANITIZE_CHARSET"))
+	switch sanitizeCharset {
+	case "":
+		sanitizeCharset = "unicode"
+	case "unicode", "ascii":
+	default:
+		return nil, fmt.Errorf("invalid SANITIZE_CHARSET %q: must be unicode or ascii", sanitizeCharset)
+	}
+	abortOnStreamError, _ := strconv.ParseBool(Getenv("ABORT_ON_STREAM_ERROR"))
+	enableHTTPS, _ := strconv.ParseBool(Getenv("ENABLE_HTTPS"))
 
-	idField := os.Getenv("ID_FIELD")
+	idField := Getenv("ID_FIELD")
 	if idField == "" {
 		idField = "id"
 	}
 
-	tableName := os.Getenv("TABLE_NAME")
+	tableName := Getenv("TABLE_NAME")
 	if tableName == "" {
 		tableName = "downloads"
 	}
 
-	port := os.Getenv("PORT")
+	port := Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	s3Region := os.Getenv("S3_REGION")
+	// Parse the unix socket listened on instead of a TCP port
+	unixSocket := Getenv("UNIX_SOCKET")
+	unixSocketMode := os.FileMode(0660)
+	if v := Getenv("UNIX_SOCKET_MODE"); v != "" {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || mode > 0777 {
+			return nil, fmt.Errorf("invalid UNIX_SOCKET_MODE %q: must be octal permissions, e.g. 0660", v)
+		}
+		unixSocketMode = os.FileMode(mode)
+	}
+
+	// Parse the path the download endpoints are served under
+	var basePath string
+	if v := Getenv("BASE_PATH"); v != "" {
+		basePath = "/" + strings.Trim(v, "/")
+		if basePath == "/" {
+			basePath = ""
+		} else if strings.ContainsAny(basePath, "{}?#") {
+			return nil, fmt.Errorf("invalid BASE_PATH %q: must be a plain path such as /download", v)
+		}
+	}
+	legacyRedirects := true
+	if v := Getenv("LEGACY_REDIRECTS"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			legacyRedirects = parsed
+		}
+	}
+
+	// Parse HTTP server hardening settings
+	readHeaderTimeout := parseDuration(Getenv("READ_HEADER_TIMEOUT"), 10*time.Second)
+	idleTimeout := parseDuration(Getenv("IDLE_TIMEOUT"), 120*time.Second)
+	writeTimeout := parseDuration(Getenv("WRITE_TIMEOUT"), 0)
+	if readHeaderTimeout < 0 || idleTimeout < 0 || writeTimeout < 0 {
+		return nil, fmt.Errorf("READ_HEADER_TIMEOUT, IDLE_TIMEOUT, and WRITE_TIMEOUT cannot be negative")
+	}
+	maxHeaderBytes := parseInt(Getenv("MAX_HEADER_BYTES"), 0)
+	if maxHeaderBytes < 0 {
+		return nil, fmt.Errorf("invalid MAX_HEADER_BYTES %d: cannot be negative", maxHeaderBytes)
+	}
+	enableHTTP2 := true
+	if v := Getenv("ENABLE_HTTP2"); v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			enableHTTP2 = parsed
+		}
+	}
+	enableH2C, _ := strconv.ParseBool(Getenv("ENABLE_H2C"))
+
+	s3Region := Getenv("S3_REGION")
 	if s3Region == "" {
 		s3Region = "auto"
 	}
 
     s3UsePathStyle := false
-	if v := os.Getenv("S3_USE_PATH_STYLE"); v != "" {
+	if v := Getenv("S3_USE_PATH_STYLE"); v != "" {
 		if parsed, err := strconv.ParseBool(v); err == nil {
 			s3UsePathStyle = parsed
 		}
 	}
 
+	// Parse the S3 health check mode
+	s3HealthCheck := strings.ToLower(Getenv("S3_HEALTH_CHECK"))
+	if s3HealthCheck == "" {
+		s3HealthCheck = "list_buckets"
+	}
+	s3HealthCheckBucket := Getenv("S3_HEALTH_CHECK_BUCKET")
+	s3HealthCheckKey := Getenv("S3_HEALTH_CHECK_KEY")
+	switch s3HealthCheck {
+	case "list_buckets", "none":
+	case "head_bucket":
+		if s3HealthCheckBucket == "" {
+			return nil, fmt.Errorf("S3_HEALTH_CHECK_BUCKET required when S3_HEALTH_CHECK=head_bucket")
+		}
+	case "head_object":
+		if s3HealthCheckBucket == "" || s3HealthCheckKey == "" {
+			return nil, fmt.Errorf("S3_HEALTH_CHECK_BUCKET and S3_HEALTH_CHECK_KEY required when S3_HEALTH_CHECK=head_object")
+		}
+	default:
+		return nil, fmt.Errorf("invalid S3_HEALTH_CHECK %q: must be list_buckets, head_bucket, head_object, or none", s3HealthCheck)
+	}
+
+	// A static certificate, for internal CAs where ACME isn't possible,
+	// replaces Let's Encrypt
+	tlsCertFile := Getenv("TLS_CERT_FILE")
+	tlsKeyFile := Getenv("TLS_KEY_FILE")
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if tlsCertFile != "" && !enableHTTPS {
+		return nil, fmt.Errorf("TLS_CERT_FILE requires ENABLE_HTTPS=true")
+	}
+	httpsPort := Getenv("HTTPS_PORT")
+	if httpsPort == "" {
+		httpsPort = "443"
+	}
+
 	var letsEncryptDomains []string
-	if enableHTTPS {
-		domains := strings.Split(os.Getenv("LETSENCRYPT_DOMAINS"), ",")
+	if enableHTTPS && tlsCertFile == "" {
+		domains := strings.Split(Getenv("LETSENCRYPT_DOMAINS"), ",")
 		if len(domains) == 0 || domains[0] == "" {
-			return nil, fmt.Errorf("LETSENCRYPT_DOMAINS required when ENABLE_HTTPS=true")
+			return nil, fmt.Errorf("LETSENCRYPT_DOMAINS or TLS_CERT_FILE required when ENABLE_HTTPS=true")
 		}
 		letsEncryptDomains = domains
 	}
 
-	letsEncryptCacheDir := os.Getenv("LETSENCRYPT_CACHE_DIR")
+	letsEncryptCacheDir := Getenv("LETSENCRYPT_CACHE_DIR")
 	if letsEncryptCacheDir == "" {
 		letsEncryptCacheDir = "./certs"
 	}
 
 	// Determine storage type
-	storageType := os.Getenv("STORAGE_TYPE")
-	storagePath := os.Getenv("STORAGE_PATH")
+	storageType := Getenv("STORAGE_TYPE")
+	storagePath := Getenv("STORAGE_PATH")
 
 	// Auto-detect storage type if not specified
 	if storageType == "" {
@@ -162,84 +488,819 @@ func Load() (*Config, error) {
 			storageType = "s3"
 		}
 	}
+	switch storageType {
+	case "s3":
+		if (secrets["S3_ACCESS_KEY_ID"] == "") != (secrets["S3_SECRET_ACCESS_KEY"] == "") {
+			return nil, fmt.Errorf("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY must be set together")
+		}
+	case "local":
+		if storagePath == "" {
+			return nil, fmt.Errorf("STORAGE_PATH required when STORAGE_TYPE=local")
+		}
+	default:
+		return nil, fmt.Errorf("invalid STORAGE_TYPE %q: must be s3 or local", storageType)
+	}
 
 	// Parse database settings
-	dbMaxConnections := parseInt(os.Getenv("DB_MAX_CONNECTIONS"), 20)
+	dbMaxConnections := parseInt(Getenv("DB_MAX_CONNECTIONS"), 20)
+
+	cassandraConsistency := Getenv("CASSANDRA_CONSISTENCY")
+	if cassandraConsistency == "" {
+		cassandraConsistency = "LOCAL_QUORUM"
+	}
+
+	// Parse database TLS settings
+	dbTLSMode := strings.ToLower(Getenv("DB_TLS_MODE"))
+	switch dbTLSMode {
+	case "", "disable", "require", "verify-ca", "verify-full":
+	default:
+		return nil, fmt.Errorf("invalid DB_TLS_MODE %q: must be disable, require, verify-ca, or verify-full", dbTLSMode)
+	}
+	dbTLSCert := Getenv("DB_TLS_CERT")
+	dbTLSKey := Getenv("DB_TLS_KEY")
+	if (dbTLSCert == "") != (dbTLSKey == "") {
+		return nil, fmt.Errorf("DB_TLS_CERT and DB_TLS_KEY must be set together")
+	}
+
+	signingSecrets, signingKeyID, err := parseSigningSecrets(secrets["SIGNING_SECRET"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid SIGNING_SECRET: %w", err)
+	}
+	minSignatureVersion := parseInt(Getenv("MIN_SIGNATURE_VERSION"), 1)
+	if minSignatureVersion != 1 && minSignatureVersion != 2 {
+		return nil, fmt.Errorf("invalid MIN_SIGNATURE_VERSION %d: must be 1 or 2", minSignatureVersion)
+	}
+	signTTL := parseDuration(Getenv("SIGN_DEFAULT_TTL"), time.Hour)
+	if signTTL <= 0 {
+		return nil, fmt.Errorf("invalid SIGN_DEFAULT_TTL %s: must be positive", signTTL)
+	}
+
+	var jwtPublicKey []byte
+	if path := Getenv("JWT_PUBLIC_KEY_FILE"); path != "" {
+		if jwtPublicKey, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("invalid JWT_PUBLIC_KEY_FILE: %w", err)
+		}
+	}
+
+	jwtUserClaim := Getenv("JWT_USER_CLAIM")
+	if jwtUserClaim == "" {
+		jwtUserClaim = "user_id"
+	}
+
+	// Parse API keys
+	apiKeys, err := parseAPIKeys(secrets["API_KEYS"])
+	if err != nil {
+		return nil, err
+	}
+	apiKeyStore := strings.ToLower(Getenv("API_KEY_STORE"))
+	switch apiKeyStore {
+	case "":
+		apiKeyStore = "config"
+	case "config", "database":
+	default:
+		return nil, fmt.Errorf("invalid API_KEY_STORE %q: must be config or database", apiKeyStore)
+	}
+	if apiKeyStore == "database" && len(apiKeys) > 0 {
+		return nil, fmt.Errorf("API_KEYS can't be used with API_KEY_STORE=database")
+	}
+	apiKeyTable := Getenv("API_KEY_TABLE")
+	if apiKeyTable == "" {
+		apiKeyTable = "api_keys"
+	}
+	apiKeyRateLimit := parseFloat(Getenv("API_KEY_RATE_LIMIT"), 0)
+	if apiKeyRateLimit < 0 {
+		return nil, fmt.Errorf("invalid API_KEY_RATE_LIMIT %g: cannot be negative", apiKeyRateLimit)
+	}
+
+	// Parse record cache settings
+	recordCacheTTL := parseDuration(Getenv("RECORD_CACHE_TTL"), 0)
+	recordCacheStale := parseDuration(Getenv("RECORD_CACHE_STALE"), 0)
+	if recordCacheTTL < 0 || recordCacheStale < 0 {
+		return nil, fmt.Errorf("invalid RECORD_CACHE_TTL %s or RECORD_CACHE_STALE %s: cannot be negative", recordCacheTTL, recordCacheStale)
+	}
+	recordCacheSize := parseInt(Getenv("RECORD_CACHE_SIZE"), 10000)
+	if recordCacheSize <= 0 {
+		return nil, fmt.Errorf("invalid RECORD_CACHE_SIZE %d: must be positive", recordCacheSize)
+	}
 
 	// Parse timeouts
-	dbTimeout := parseDuration(os.Getenv("DATABASE_QUERY_TIMEOUT"), 5*time.Second)
-	storageTimeout := parseDuration(os.Getenv("STORAGE_FETCH_TIMEOUT"), 60*time.Second)
-	requestTimeout := parseDuration(os.Getenv("REQUEST_TIMEOUT"), 300*time.Second)
+	dbTimeout := parseDuration(Getenv("DATABASE_QUERY_TIMEOUT"), 5*time.Second)
+	storageTimeout := parseDuration(Getenv("STORAGE_FETCH_TIMEOUT"), 60*time.Second)
+	requestTimeout := parseDuration(Getenv("REQUEST_TIMEOUT"), 300*time.Second)
+	fileFetchTimeout := parseDuration(Getenv("FILE_FETCH_TIMEOUT"), 0)
+	stallTimeout := parseDuration(Getenv("STALL_TIMEOUT"), 60*time.Second)
+	if requestTimeout < 0 {
+		return nil, fmt.Errorf("invalid REQUEST_TIMEOUT %s: cannot be negative", requestTimeout)
+	}
+	if fileFetchTimeout < 0 || stallTimeout < 0 {
+		return nil, fmt.Errorf("FILE_FETCH_TIMEOUT and STALL_TIMEOUT cannot be negative")
+	}
+	flushInterval := parseDuration(Getenv("FLUSH_INTERVAL"), time.Second)
+	if flushInterval < 0 {
+		return nil, fmt.Errorf("invalid FLUSH_INTERVAL %s: cannot be negative", flushInterval)
+	}
+
+	// Parse async build settings
+	asyncBuilds, _ := strconv.ParseBool(Getenv("ASYNC_BUILDS"))
+	stagingDir := Getenv("STAGING_DIR")
+	if stagingDir == "" {
+		stagingDir = filepath.Join(os.TempDir(), "zipperfly-staging")
+	}
+	stagingTTL := parseDuration(Getenv("STAGING_TTL"), time.Hour)
+	if stagingTTL <= 0 {
+		return nil, fmt.Errorf("invalid STAGING_TTL %s: must be positive", stagingTTL)
+	}
+
+	progressEvents, _ := strconv.ParseBool(Getenv("PROGRESS_EVENTS"))
+
+	// Parse download token settings
+	downloadTokens, _ := strconv.ParseBool(Getenv("DOWNLOAD_TOKENS"))
+	downloadTokenTTL := parseDuration(Getenv("DOWNLOAD_TOKEN_TTL"), time.Minute)
+	if downloadTokenTTL <= 0 {
+		return nil, fmt.Errorf("invalid DOWNLOAD_TOKEN_TTL %s: must be positive", downloadTokenTTL)
+	}
+	downloadTokenKeyPrefix := Getenv("DOWNLOAD_TOKEN_KEY_PREFIX")
+	if downloadTokenKeyPrefix == "" {
+		downloadTokenKeyPrefix = "zipperfly:tokens:"
+	}
+	// Origin headers never end in a slash
+	downloadTokenOrigins := parseStringList(Getenv("DOWNLOAD_TOKEN_ORIGINS"))
+	for i, origin := range downloadTokenOrigins {
+		downloadTokenOrigins[i] = strings.TrimSuffix(origin, "/")
+	}
+
+	// Parse CORS settings
+	corsAllowedOrigins := parseStringList(Getenv("CORS_ALLOWED_ORIGINS"))
+	for i, origin := range corsAllowedOrigins {
+		origin = strings.TrimSuffix(origin, "/")
+		if u, err := url.Parse(origin); origin != "*" && (err != nil || u.Scheme == "" || u.Host == "" || u.Path != "") {
+			return nil, fmt.Errorf("invalid CORS_ALLOWED_ORIGINS entry %q: must be * or scheme://host[:port]", origin)
+		}
+		corsAllowedOrigins[i] = origin
+	}
+	corsAllowedMethods := parseStringList(Getenv("CORS_ALLOWED_METHODS"))
+	if len(corsAllowedMethods) == 0 {
+		corsAllowedMethods = []string{"GET", "HEAD", "POST"}
+	}
+	for i, method := range corsAllowedMethods {
+		corsAllowedMethods[i] = strings.ToUpper(method)
+	}
+	corsAllowedHeaders := parseStringList(Getenv("CORS_ALLOWED_HEADERS"))
+	if len(corsAllowedHeaders) == 0 {
+		corsAllowedHeaders = []string{"Authorization", "Content-Type", "If-Range", "Range", "X-Api-Key", "X-Request-ID", "X-Zipperfly-Password"}
+	}
+	corsExposedHeaders := parseStringList(Getenv("CORS_EXPOSED_HEADERS"))
+	if len(corsExposedHeaders) == 0 {
+		corsExposedHeaders = []string{"Accept-Ranges", "Content-Disposition", "Content-Length", "Content-Range", "ETag", "Retry-After", "X-Request-ID"}
+	}
+	corsAllowCredentials, _ := strconv.ParseBool(Getenv("CORS_ALLOW_CREDENTIALS"))
+	if corsAllowCredentials && slices.Contains(corsAllowedOrigins, "*") {
+		return nil, fmt.Errorf("CORS_ALLOW_CREDENTIALS requires CORS_ALLOWED_ORIGINS to list origins, not *")
+	}
+	corsMaxAge := parseDuration(Getenv("CORS_MAX_AGE"), 10*time.Minute)
+	if corsMaxAge < 0 {
+		return nil, fmt.Errorf("invalid CORS_MAX_AGE %s: cannot be negative", corsMaxAge)
+	}
+
+	// Parse error response settings
+	errorFormat := strings.ToLower(Getenv("ERROR_FORMAT"))
+	switch errorFormat {
+	case "":
+		errorFormat = "text"
+	case "text", "auto", "json":
+	default:
+		return nil, fmt.Errorf("invalid ERROR_FORMAT %q: must be text, auto, or json", errorFormat)
+	}
+	var errorPage *template.Template
+	if path := Getenv("ERROR_PAGE_TEMPLATE"); path != "" {
+		if errorPage, err = template.ParseFiles(path); err != nil {
+			return nil, fmt.Errorf("invalid ERROR_PAGE_TEMPLATE: %w", err)
+		}
+	}
+
+	// Parse admin listener settings
+	adminPort := Getenv("ADMIN_PORT")
+	adminUsername := Getenv("ADMIN_USERNAME")
+	adminPassword := secrets["ADMIN_PASSWORD"]
+	publicPorts := []string{port}
+	if enableHTTPS && tlsCertFile != "" {
+		publicPorts = []string{httpsPort}
+	} else if enableHTTPS {
+		publicPorts = []string{httpsPort, "80"}
+	}
+	if slices.Contains(publicPorts, adminPort) {
+		return nil, fmt.Errorf("invalid ADMIN_PORT %s: must differ from the public ports %v", adminPort, publicPorts)
+	}
+	if (adminUsername == "") != (adminPassword == "") {
+		return nil, fmt.Errorf("ADMIN_USERNAME and ADMIN_PASSWORD must be set together")
+	}
+
+	// Parse the internal listener, which may share the admin listener's port
+	internalPort := Getenv("INTERNAL_PORT")
+	if slices.Contains(publicPorts, internalPort) {
+		return nil, fmt.Errorf("invalid INTERNAL_PORT %s: must differ from the public ports %v", internalPort, publicPorts)
+	}
+
+	// Parse per-tenant metrics settings
+	metricsTenantLabel := strings.ToLower(Getenv("METRICS_TENANT_LABEL"))
+	switch metricsTenantLabel {
+	case "", "bucket", "tenant":
+	default:
+		return nil, fmt.Errorf("invalid METRICS_TENANT_LABEL %q: must be bucket or tenant", metricsTenantLabel)
+	}
+	metricsTenantLimit := parseInt(Getenv("METRICS_TENANT_LIMIT"), 100)
+	if metricsTenantLimit < 1 {
+		return nil, fmt.Errorf("invalid METRICS_TENANT_LIMIT %d: must be positive", metricsTenantLimit)
+	}
+
+	// Parse slow and large download thresholds
+	slowDownloadThreshold := parseDuration(Getenv("SLOW_DOWNLOAD_THRESHOLD"), 0)
+	if slowDownloadThreshold < 0 {
+		return nil, fmt.Errorf("invalid SLOW_DOWNLOAD_THRESHOLD %s: cannot be negative", slowDownloadThreshold)
+	}
+	largeDownloadThreshold := int64(parseInt(Getenv("LARGE_DOWNLOAD_THRESHOLD"), 0))
+	if largeDownloadThreshold < 0 {
+		return nil, fmt.Errorf("invalid LARGE_DOWNLOAD_THRESHOLD %d: cannot be negative", largeDownloadThreshold)
+	}
+
+	// Parse the shutdown drain timeout
+	shutdownTimeout := parseDuration(Getenv("SHUTDOWN_TIMEOUT"), 10*time.Second)
+	if shutdownTimeout < 0 {
+		return nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT %s: cannot be negative", shutdownTimeout)
+	}
+
+	// Parse health check settings
+	healthCacheTTL := parseDuration(Getenv("HEALTH_CACHE_TTL"), 5*time.Second)
+	if healthCacheTTL < 0 {
+		return nil, fmt.Errorf("invalid HEALTH_CACHE_TTL %s: cannot be negative", healthCacheTTL)
+	}
+
+	// Parse access log settings
+	accessLog, _ := strconv.ParseBool(Getenv("ACCESS_LOG"))
+	accessLogOutput := Getenv("ACCESS_LOG_OUTPUT")
+	if accessLogOutput == "" {
+		accessLogOutput = "stderr"
+	}
+	accessLogSampleRate := parseFloat(Getenv("ACCESS_LOG_SAMPLE_RATE"), 1)
+	if accessLogSampleRate <= 0 || accessLogSampleRate > 1 {
+		return nil, fmt.Errorf("invalid ACCESS_LOG_SAMPLE_RATE %g: must be above 0 and at most 1", accessLogSampleRate)
+	}
+
+	// Log settings, already applied at startup, are checked so a reload
+	// can't pass with invalid ones
+	logCfg, err := LoadLogConfig()
+	if err != nil {
+		return nil, err
+	}
 
 	// Parse resource limits
-	maxActiveDownloads := parseInt(os.Getenv("MAX_ACTIVE_DOWNLOADS"), 0)
-	maxFilesPerRequest := parseInt(os.Getenv("MAX_FILES_PER_REQUEST"), 0)
-	rateLimitPerIP := parseFloat(os.Getenv("RATE_LIMIT_PER_IP"), 0)
+	maxActiveDownloads := parseInt(Getenv("MAX_ACTIVE_DOWNLOADS"), 0)
+	downloadQueueLength := parseInt(Getenv("DOWNLOAD_QUEUE_LENGTH"), 0)
+	downloadQueueWait := parseDuration(Getenv("DOWNLOAD_QUEUE_WAIT"), 10*time.Second)
+	if downloadQueueLength < 0 || downloadQueueWait < 0 {
+		return nil, fmt.Errorf("DOWNLOAD_QUEUE_LENGTH and DOWNLOAD_QUEUE_WAIT cannot be negative")
+	}
+
+	// Parse adaptive concurrency
+	adaptiveConcurrency, _ := strconv.ParseBool(Getenv("ADAPTIVE_CONCURRENCY"))
+	adaptiveMemoryLimit := int64(parseInt(Getenv("ADAPTIVE_MEMORY_LIMIT"), 0))
+	if adaptiveMemoryLimit < 0 {
+		return nil, fmt.Errorf("invalid ADAPTIVE_MEMORY_LIMIT %d: cannot be negative", adaptiveMemoryLimit)
+	}
+	adaptiveBackpressure := parseFloat(Getenv("ADAPTIVE_BACKPRESSURE"), 0.9)
+	if adaptiveBackpressure <= 0 || adaptiveBackpressure > 1 {
+		return nil, fmt.Errorf("invalid ADAPTIVE_BACKPRESSURE %g: must be above 0 and at most 1", adaptiveBackpressure)
+	}
+	adaptiveInterval := parseDuration(Getenv("ADAPTIVE_INTERVAL"), time.Second)
+	if adaptiveInterval <= 0 {
+		return nil, fmt.Errorf("invalid ADAPTIVE_INTERVAL %s: must be positive", adaptiveInterval)
+	}
+	maxFilesPerRequest := parseInt(Getenv("MAX_FILES_PER_REQUEST"), 0)
+	rateLimitPerIP := parseFloat(Getenv("RATE_LIMIT_PER_IP"), 0)
+	if rateLimitPerIP < 0 {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_PER_IP %g: cannot be negative", rateLimitPerIP)
+	}
+	rateLimitBurst := parseInt(Getenv("RATE_LIMIT_BURST"), int(math.Ceil(rateLimitPerIP)))
+	if rateLimitBurst < 1 {
+		rateLimitBurst = 1
+	}
+	rateLimitPerRecord := parseFloat(Getenv("RATE_LIMIT_PER_RECORD"), 0)
+	if rateLimitPerRecord < 0 {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_PER_RECORD %g: cannot be negative", rateLimitPerRecord)
+	}
+	rateLimitRecordBurst := parseInt(Getenv("RATE_LIMIT_RECORD_BURST"), int(math.Ceil(rateLimitPerRecord)))
+	if rateLimitRecordBurst < 1 {
+		rateLimitRecordBurst = 1
+	}
+	limitsKeyPrefix := Getenv("LIMITS_KEY_PREFIX")
+	if limitsKeyPrefix == "" {
+		limitsKeyPrefix = "zipperfly:limits:"
+	}
+	trustedProxies, err := parseCIDRList("TRUSTED_PROXIES", Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, err
+	}
+	clientIPHeader := strings.ToLower(Getenv("CLIENT_IP_HEADER"))
+	switch clientIPHeader {
+	case "", "forwarded", "x-forwarded-for", "x-real-ip":
+	default:
+		return nil, fmt.Errorf("invalid CLIENT_IP_HEADER %q: must be forwarded, x-forwarded-for, or x-real-ip", clientIPHeader)
+	}
+
+	// Parse client certificate settings
+	clientCAFile := Getenv("CLIENT_CA_FILE")
+	clientCertNames := parseStringList(Getenv("CLIENT_CERT_NAMES"))
+	if clientCAFile != "" && !enableHTTPS {
+		return nil, fmt.Errorf("CLIENT_CA_FILE requires ENABLE_HTTPS=true")
+	}
+	if len(clientCertNames) > 0 && clientCAFile == "" {
+		return nil, fmt.Errorf("CLIENT_CA_FILE required with CLIENT_CERT_NAMES")
+	}
+
+	// Parse client IP and country filters
+	ipAllowlist, err := parseCIDRList("IP_ALLOWLIST", Getenv("IP_ALLOWLIST"))
+	if err != nil {
+		return nil, err
+	}
+	ipDenylist, err := parseCIDRList("IP_DENYLIST", Getenv("IP_DENYLIST"))
+	if err != nil {
+		return nil, err
+	}
+	allowedCountries := parseCountryList(Getenv("ALLOWED_COUNTRIES"))
+	blockedCountries := parseCountryList(Getenv("BLOCKED_COUNTRIES"))
+	geoIPDatabase := Getenv("GEOIP_DATABASE")
+	if (len(allowedCountries) > 0 || len(blockedCountries) > 0) && geoIPDatabase == "" {
+		return nil, fmt.Errorf("GEOIP_DATABASE required with ALLOWED_COUNTRIES or BLOCKED_COUNTRIES")
+	}
+	maxFileSize := int64(parseInt(Getenv("MAX_FILE_SIZE"), 0))
+	if maxFileSize < 0 {
+		return nil, fmt.Errorf("invalid MAX_FILE_SIZE %d: cannot be negative", maxFileSize)
+	}
+	maxArchiveSize := int64(parseInt(Getenv("MAX_ARCHIVE_SIZE"), 0))
+	if maxArchiveSize < 0 {
+		return nil, fmt.Errorf("invalid MAX_ARCHIVE_SIZE %d: cannot be negative", maxArchiveSize)
+	}
 
 	// Parse retry settings
-	storageMaxRetries := parseInt(os.Getenv("STORAGE_MAX_RETRIES"), 3)
-	storageRetryDelay := parseDuration(os.Getenv("STORAGE_RETRY_DELAY"), 1*time.Second)
+	storageMaxRetries := parseInt(Getenv("STORAGE_MAX_RETRIES"), 3)
+	storageRetryDelay := parseDuration(Getenv("STORAGE_RETRY_DELAY"), 1*time.Second)
+	storageRetryMaxDelay := parseDuration(Getenv("STORAGE_RETRY_MAX_DELAY"), 30*time.Second)
+	storageRetryBudget := parseInt(Getenv("STORAGE_RETRY_BUDGET"), 10)
+	if storageRetryMaxDelay < 0 || storageRetryBudget < 0 {
+		return nil, fmt.Errorf("STORAGE_RETRY_MAX_DELAY and STORAGE_RETRY_BUDGET cannot be negative")
+	}
+	storageHedgePercentile := parseFloat(Getenv("STORAGE_HEDGE_PERCENTILE"), 0)
+	if storageHedgePercentile < 0 || storageHedgePercentile >= 100 {
+		return nil, fmt.Errorf("invalid STORAGE_HEDGE_PERCENTILE %v: must be from 0 (off) up to 100", storageHedgePercentile)
+	}
+	storageHedgeMinDelay := parseDuration(Getenv("STORAGE_HEDGE_MIN_DELAY"), 50*time.Millisecond)
+	if storageHedgeMinDelay < 0 {
+		return nil, fmt.Errorf("invalid STORAGE_HEDGE_MIN_DELAY %s: cannot be negative", storageHedgeMinDelay)
+	}
 
 	// Parse circuit breaker settings
-	cbThreshold := parseInt(os.Getenv("CIRCUIT_BREAKER_THRESHOLD"), 5)
-	cbTimeout := parseDuration(os.Getenv("CIRCUIT_BREAKER_TIMEOUT"), 60*time.Second)
-	cbMaxRequests := parseInt(os.Getenv("CIRCUIT_BREAKER_MAX_REQUESTS"), 2)
+	cbThreshold := parseInt(Getenv("CIRCUIT_BREAKER_THRESHOLD"), 5)
+	cbTimeout := parseDuration(Getenv("CIRCUIT_BREAKER_TIMEOUT"), 60*time.Second)
+	cbMaxRequests := parseInt(Getenv("CIRCUIT_BREAKER_MAX_REQUESTS"), 2)
+	cbScope := strings.ToLower(Getenv("CIRCUIT_BREAKER_SCOPE"))
+	switch cbScope {
+	case "":
+		cbScope = "bucket"
+	case "bucket", "global":
+	default:
+		return nil, fmt.Errorf("invalid CIRCUIT_BREAKER_SCOPE %q: must be bucket or global", cbScope)
+	}
+	cbEvents := Getenv("CIRCUIT_BREAKER_EVENTS") == "true"
 
 	// Parse feature flags
-	allowPasswordProtected, _ := strconv.ParseBool(os.Getenv("ALLOW_PASSWORD_PROTECTED"))
+	allowPasswordProtected, _ := strconv.ParseBool(Getenv("ALLOW_PASSWORD_PROTECTED"))
 
 	// Parse file extension filters
-	allowedExts := parseStringList(os.Getenv("ALLOWED_EXTENSIONS"))
-	blockedExts := parseStringList(os.Getenv("BLOCKED_EXTENSIONS"))
+	allowedExts := parseStringList(Getenv("ALLOWED_EXTENSIONS"))
+	blockedExts := parseStringList(Getenv("BLOCKED_EXTENSIONS"))
+
+	// Parse bucket allowlist
+	allowedBuckets := parseStringList(Getenv("ALLOWED_BUCKETS"))
+
+	// Parse access windows
+	accessWindows := parseStringList(Getenv("ACCESS_WINDOWS"))
+	maintenanceWindows := parseStringList(Getenv("MAINTENANCE_WINDOWS"))
+
+	// Parse authorization webhook settings
+	authWebhookTimeout := parseDuration(Getenv("AUTH_WEBHOOK_TIMEOUT"), 5*time.Second)
+
+	// Parse archive output settings
+	compressionLevel := parseInt(Getenv("COMPRESSION_LEVEL"), 0)
+	if compressionLevel < 0 || compressionLevel > 9 {
+		return nil, fmt.Errorf("invalid COMPRESSION_LEVEL %d: must be between 0 and 9", compressionLevel)
+	}
+	zstdLevel := parseInt(Getenv("ZSTD_LEVEL"), 3)
+	if zstdLevel < 1 || zstdLevel > 22 {
+		return nil, fmt.Errorf("invalid ZSTD_LEVEL %d: must be between 1 and 22", zstdLevel)
+	}
+	zipStoreOnly, _ := strconv.ParseBool(Getenv("ZIP_STORE_ONLY"))
+	zipStoreExtensions := parseStringList(Getenv("ZIP_STORE_EXTENSIONS"))
+	zipEncryption := strings.ToLower(Getenv("ZIP_ENCRYPTION"))
+	switch zipEncryption {
+	case "":
+		zipEncryption = "zipcrypto"
+	case "zipcrypto", "aes256":
+	default:
+		return nil, fmt.Errorf("invalid ZIP_ENCRYPTION %q: must be zipcrypto or aes256", zipEncryption)
+	}
+	archiveManifest, _ := strconv.ParseBool(Getenv("ARCHIVE_MANIFEST"))
+	manifestFormat := strings.ToLower(Getenv("MANIFEST_FORMAT"))
+	switch manifestFormat {
+	case "":
+		manifestFormat = "json"
+	case "json", "csv":
+	default:
+		return nil, fmt.Errorf("invalid MANIFEST_FORMAT %q: must be json or csv", manifestFormat)
+	}
+
+	entryOrder := strings.ToLower(Getenv("ENTRY_ORDER"))
+	switch entryOrder {
+	case "":
+		entryOrder = "completion"
+	case "completion", "record", "sorted":
+	default:
+		return nil, fmt.Errorf("invalid ENTRY_ORDER %q: must be completion, record, or sorted", entryOrder)
+	}
+	extraFiles, err := loadExtraFiles(Getenv("EXTRA_FILES"), Getenv("EXTRA_FILES_INLINE"))
+	if err != nil {
+		return nil, err
+	}
 
 	// Parse callback settings
-	callbackMaxRetries := parseInt(os.Getenv("CALLBACK_MAX_RETRIES"), 3)
-	callbackRetryDelay := parseDuration(os.Getenv("CALLBACK_RETRY_DELAY"), 5*time.Second)
+	callbackMaxRetries := parseInt(Getenv("CALLBACK_MAX_RETRIES"), 3)
+	callbackRetryDelay := parseDuration(Getenv("CALLBACK_RETRY_DELAY"), 5*time.Second)
+	callbackRetryMaxDelay := parseDuration(Getenv("CALLBACK_RETRY_MAX_DELAY"), time.Minute)
+	if callbackRetryMaxDelay < 0 {
+		return nil, fmt.Errorf("invalid CALLBACK_RETRY_MAX_DELAY %s: cannot be negative", callbackRetryMaxDelay)
+	}
+	callbackMaxElapsed := parseDuration(Getenv("CALLBACK_MAX_ELAPSED"), 10*time.Minute)
+	if callbackMaxElapsed < 0 {
+		return nil, fmt.Errorf("invalid CALLBACK_MAX_ELAPSED %s: cannot be negative", callbackMaxElapsed)
+	}
+	callbackQueue := strings.ToLower(Getenv("CALLBACK_QUEUE"))
+	switch callbackQueue {
+	case "":
+		callbackQueue = "memory"
+	case "memory", "bolt", "database":
+	default:
+		return nil, fmt.Errorf("invalid CALLBACK_QUEUE %q: must be memory, bolt, or database", callbackQueue)
+	}
+	callbackQueuePath := Getenv("CALLBACK_QUEUE_PATH")
+	if callbackQueuePath == "" {
+		callbackQueuePath = "zipperfly-callbacks.db"
+	}
+	callbackQueueTable := Getenv("CALLBACK_QUEUE_TABLE")
+	if callbackQueueTable == "" {
+		callbackQueueTable = "callback_queue"
+	}
+	callbackQueueInterval := parseDuration(Getenv("CALLBACK_QUEUE_INTERVAL"), time.Second)
+	if callbackQueueInterval <= 0 {
+		return nil, fmt.Errorf("invalid CALLBACK_QUEUE_INTERVAL %s: must be positive", callbackQueueInterval)
+	}
+	disableCallbacks := Getenv("DISABLE_CALLBACKS") == "true"
+	defaultCallbacks, err := models.ParseCallbacks(Getenv("DEFAULT_CALLBACK"))
+	if err == nil {
+		err = defaultCallbacks.Validate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid DEFAULT_CALLBACK: %w", err)
+	}
+	callbackStarted := Getenv("CALLBACK_STARTED") == "true"
+	callbackFileResults := Getenv("CALLBACK_FILE_RESULTS") == "true"
+	callbackProgressBytes := int64(parseInt(Getenv("CALLBACK_PROGRESS_BYTES"), 0))
+	if callbackProgressBytes < 0 {
+		return nil, fmt.Errorf("invalid CALLBACK_PROGRESS_BYTES %d: cannot be negative", callbackProgressBytes)
+	}
+
+	// Parse event bus settings
+	eventBus := strings.ToLower(Getenv("EVENT_BUS"))
+	switch eventBus {
+	case "":
+		eventBus = "none"
+	case "none", "kafka", "nats", "sqs":
+	default:
+		return nil, fmt.Errorf("invalid EVENT_BUS %q: must be none, kafka, nats, or sqs", eventBus)
+	}
+	eventBusURL := secrets["EVENT_BUS_URL"]
+	if eventBus != "none" && eventBusURL == "" {
+		return nil, fmt.Errorf("EVENT_BUS_URL required when EVENT_BUS=%s", eventBus)
+	}
+	if cbEvents && eventBus == "none" {
+		return nil, fmt.Errorf("CIRCUIT_BREAKER_EVENTS requires an EVENT_BUS")
+	}
+	eventTopic := Getenv("EVENT_TOPIC")
+	if eventTopic == "" {
+		eventTopic = "zipperfly.downloads"
+	}
 
-	return &Config{
+	cfg := &Config{
 		DBURL:            dbURL,
 		DBEngine:         u.Scheme,
 		DBMaxConnections: dbMaxConnections,
 		TableName:        tableName,
 		IDField:          idField,
-		KeyPrefix:        os.Getenv("KEY_PREFIX"),
+		KeyPrefix:        Getenv("KEY_PREFIX"),
+		CassandraConsistency: cassandraConsistency,
+		DBTLSMode:           dbTLSMode,
+		DBTLSCA:             Getenv("DB_TLS_CA"),
+		DBTLSCert:           dbTLSCert,
+		DBTLSKey:            dbTLSKey,
 		StorageType:         storageType,
 		StoragePath:         storagePath,
-		S3Endpoint:          os.Getenv("S3_ENDPOINT"),
+		S3Endpoint:          Getenv("S3_ENDPOINT"),
 		S3Region:            s3Region,
-		S3AccessKeyID:       os.Getenv("S3_ACCESS_KEY_ID"),
-		S3SecretAccessKey:   os.Getenv("S3_SECRET_ACCESS_KEY"),
+		S3AccessKeyID:       secrets["S3_ACCESS_KEY_ID"],
+		S3SecretAccessKey:   secrets["S3_SECRET_ACCESS_KEY"],
 		S3UsePathStyle:      s3UsePathStyle,
+		S3HealthCheck:       s3HealthCheck,
+		S3HealthCheckBucket: s3HealthCheckBucket,
+		S3HealthCheckKey:    s3HealthCheckKey,
 		EnforceSigning:      enforceSigning,
-		SigningSecret:       []byte(os.Getenv("SIGNING_SECRET")),
+		SigningSecrets:      signingSecrets,
+		SigningKeyID:        signingKeyID,
+		MinSignatureVersion: minSignatureVersion,
+		SignUsername:        Getenv("SIGN_USERNAME"),
+		SignPassword:        secrets["SIGN_PASSWORD"],
+		SignTTL:             signTTL,
+		PublicURL:           Getenv("PUBLIC_URL"),
+		JWTSecret:           []byte(secrets["JWT_SECRET"]),
+		JWTPublicKey:        jwtPublicKey,
+		JWTJWKSURL:          Getenv("JWT_JWKS_URL"),
+		JWTIssuer:           Getenv("JWT_ISSUER"),
+		JWTAudience:         Getenv("JWT_AUDIENCE"),
+		JWTUserClaim:        jwtUserClaim,
+		APIKeys:             apiKeys,
+		APIKeyStore:         apiKeyStore,
+		APIKeyTable:         apiKeyTable,
+		APIKeyRateLimit:     apiKeyRateLimit,
+		RecordCacheTTL:      recordCacheTTL,
+		RecordCacheStale:    recordCacheStale,
+		RecordCacheSize:     recordCacheSize,
 		DatabaseQueryTimeout: dbTimeout,
 		StorageFetchTimeout:  storageTimeout,
 		RequestTimeout:       requestTimeout,
+		FileFetchTimeout:     fileFetchTimeout,
+		StallTimeout:         stallTimeout,
+		FlushInterval:        flushInterval,
 		MaxActiveDownloads:   maxActiveDownloads,
+		DownloadQueueLength:  downloadQueueLength,
+		DownloadQueueWait:    downloadQueueWait,
+		AdaptiveConcurrency:  adaptiveConcurrency,
+		AdaptiveMemoryLimit:  adaptiveMemoryLimit,
+		AdaptiveBackpressure: adaptiveBackpressure,
+		AdaptiveInterval:     adaptiveInterval,
 		MaxFilesPerRequest:   maxFilesPerRequest,
 		RateLimitPerIP:       rateLimitPerIP,
+		RateLimitBurst:       rateLimitBurst,
+		RateLimitPerRecord:   rateLimitPerRecord,
+		RateLimitRecordBurst: rateLimitRecordBurst,
+		LimitsRedisURL:       secrets["LIMITS_REDIS_URL"],
+		LimitsKeyPrefix:      limitsKeyPrefix,
+		TrustedProxies:       trustedProxies,
+		ClientIPHeader:       clientIPHeader,
+		BasePath:             basePath,
+		LegacyRedirects:      legacyRedirects,
+		ReadHeaderTimeout:    readHeaderTimeout,
+		IdleTimeout:          idleTimeout,
+		WriteTimeout:         writeTimeout,
+		MaxHeaderBytes:       maxHeaderBytes,
+		EnableHTTP2:          enableHTTP2,
+		EnableH2C:            enableH2C,
+		ClientCAFile:         clientCAFile,
+		ClientCertNames:      clientCertNames,
+		IPAllowlist:          ipAllowlist,
+		IPDenylist:           ipDenylist,
+		GeoIPDatabase:        geoIPDatabase,
+		AllowedCountries:     allowedCountries,
+		BlockedCountries:     blockedCountries,
+		SpoolMemoryLimit:     spoolMemoryLimit,
+		MaxFileSize:          maxFileSize,
+		MaxArchiveSize:       maxArchiveSize,
+		SpoolDir:             Getenv("SPOOL_DIR"),
+		AsyncBuilds:          asyncBuilds,
+		StagingDir:           stagingDir,
+		StagingTTL:           stagingTTL,
+		ProgressEvents:       progressEvents,
+		DownloadTokens:         downloadTokens,
+		DownloadTokenTTL:       downloadTokenTTL,
+		DownloadTokenRedisURL:  secrets["DOWNLOAD_TOKEN_REDIS_URL"],
+		DownloadTokenKeyPrefix: downloadTokenKeyPrefix,
+		DownloadTokenOrigins:   downloadTokenOrigins,
+		CORSAllowedOrigins:     corsAllowedOrigins,
+		CORSAllowedMethods:     corsAllowedMethods,
+		CORSAllowedHeaders:     corsAllowedHeaders,
+		CORSExposedHeaders:     corsExposedHeaders,
+		CORSAllowCredentials:   corsAllowCredentials,
+		CORSMaxAge:             corsMaxAge,
+		ErrorFormat:            errorFormat,
+		ErrorPage:              errorPage,
 		StorageMaxRetries:    storageMaxRetries,
 		StorageRetryDelay:    storageRetryDelay,
+		StorageRetryMaxDelay: storageRetryMaxDelay,
+		StorageRetryBudget:   storageRetryBudget,
+		StorageHedgePercentile: storageHedgePercentile,
+		StorageHedgeMinDelay:   storageHedgeMinDelay,
 		CircuitBreakerThreshold:   cbThreshold,
 		CircuitBreakerTimeout:     cbTimeout,
 		CircuitBreakerMaxRequests: cbMaxRequests,
+		CircuitBreakerScope:       cbScope,
+		CircuitBreakerEvents:      cbEvents,
 		AppendYMD:             appendYMD,
 		SanitizeNames:         sanitizeNames,
+		SanitizeCharset:       sanitizeCharset,
+		AllowEmptyRecords:     allowEmptyRecords,
 		IgnoreMissing:         ignoreMissing,
+		AbortOnStreamError:    abortOnStreamError,
 		MaxConcurrent:         maxConcurrent,
 		AllowPasswordProtected: allowPasswordProtected,
 		AllowedExtensions:     allowedExts,
 		BlockedExtensions:     blockedExts,
+		AllowedBuckets:        allowedBuckets,
+		AccessWindows:         accessWindows,
+		MaintenanceWindows:    maintenanceWindows,
+		AccessTimezone:        Getenv("ACCESS_TIMEZONE"),
+		AuthWebhookURL:        Getenv("AUTH_WEBHOOK_URL"),
+		AuthWebhookTimeout:    authWebhookTimeout,
+		CompressionLevel:      compressionLevel,
+		ZstdLevel:             zstdLevel,
+		ZipStoreOnly:          zipStoreOnly,
+		ZipStoreExtensions:    zipStoreExtensions,
+		ZipEncryption:         zipEncryption,
+		ArchiveManifest:       archiveManifest,
+		ManifestFormat:        manifestFormat,
+		ExtraFiles:            extraFiles,
+		EntryOrder:            entryOrder,
 		CallbackMaxRetries:    callbackMaxRetries,
 		CallbackRetryDelay:    callbackRetryDelay,
+		CallbackRetryMaxDelay: callbackRetryMaxDelay,
+		CallbackMaxElapsed:    callbackMaxElapsed,
+		CallbackQueue:         callbackQueue,
+		CallbackQueuePath:     callbackQueuePath,
+		CallbackQueueTable:    callbackQueueTable,
+		CallbackQueueInterval: callbackQueueInterval,
+		DisableCallbacks:      disableCallbacks,
+		DefaultCallbacks:      defaultCallbacks,
+		CallbackStarted:       callbackStarted,
+		CallbackProgressBytes: callbackProgressBytes,
+		CallbackFileResults:   callbackFileResults,
+		EventBus:              eventBus,
+		EventBusURL:           eventBusURL,
+		EventTopic:            eventTopic,
 		Port:                  port,
+		UnixSocket:            unixSocket,
+		UnixSocketMode:        unixSocketMode,
 		EnableHTTPS:           enableHTTPS,
+		HTTPSPort:             httpsPort,
+		TLSCertFile:           tlsCertFile,
+		TLSKeyFile:            tlsKeyFile,
 		LetsEncryptDomains:    letsEncryptDomains,
 		LetsEncryptCacheDir:   letsEncryptCacheDir,
-		LetsEncryptEmail:      os.Getenv("LETSENCRYPT_EMAIL"),
-		MetricsUsername:       os.Getenv("METRICS_USERNAME"),
-		MetricsPassword:       os.Getenv("METRICS_PASSWORD"),
-	}, nil
+		LetsEncryptEmail:      Getenv("LETSENCRYPT_EMAIL"),
+		MetricsUsername:       Getenv("METRICS_USERNAME"),
+		MetricsPassword:       secrets["METRICS_PASSWORD"],
+		MetricsTenantLabel:    metricsTenantLabel,
+		MetricsTenantLimit:    metricsTenantLimit,
+		SlowDownloadThreshold: slowDownloadThreshold,
+		LargeDownloadThreshold: largeDownloadThreshold,
+		HealthCacheTTL:        healthCacheTTL,
+		ShutdownTimeout:       shutdownTimeout,
+		AdminPort:             adminPort,
+		AdminUsername:         adminUsername,
+		AdminPassword:         adminPassword,
+		InternalPort:          internalPort,
+		AccessLog:             accessLog,
+		AccessLogOutput:       accessLogOutput,
+		AccessLogSampleRate:   accessLogSampleRate,
+		LogLevel:              logCfg.Level,
+	}
+	if cfg.StorageFailover, err = loadFailover(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Tenants, err = loadTenants(cfg, os.Environ()); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadExtraFiles reads the files added to every archive. paths is a comma-separated
+// list of local files, each added under its base name or as "name=path";
+// inline is a JSON object mapping file names to content.
+func loadExtraFiles(paths, inline string) (map[string]string, error) {
+	files := make(map[string]string)
+
+	for _, entry := range parseStringList(paths) {
+		name, path, found := strings.Cut(entry, "=")
+		if !found {
+			name, path = filepath.Base(entry), entry
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EXTRA_FILES entry %q: %w", entry, err)
+		}
+		if info.Size() > maxExtraFileSize {
+			return nil, fmt.Errorf("invalid EXTRA_FILES entry %q: larger than %d bytes", entry, maxExtraFileSize)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EXTRA_FILES entry %q: %w", entry, err)
+		}
+		files[name] = string(content)
+	}
+
+	if inline != "" {
+		var inlineFiles map[string]string
+		if err := json.Unmarshal([]byte(inline), &inlineFiles); err != nil {
+			return nil, fmt.Errorf("invalid EXTRA_FILES_INLINE: %w", err)
+		}
+		for name, content := range inlineFiles {
+			if len(content) > maxExtraFileSize {
+				return nil, fmt.Errorf("invalid EXTRA_FILES_INLINE entry %q: larger than %d bytes", name, maxExtraFileSize)
+			}
+			files[name] = content
+		}
+	}
+
+	for name := range files {
+		if !models.IsPlainFileName(name) {
+			return nil, fmt.Errorf("invalid extra file name %q: must be a plain file name", name)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, nil
+	}
+	return files, nil
+}
+
+// signingKeyID matches a "kid:secret" entry of SIGNING_SECRET
+var signingKeyID = regexp.MustCompile(`^[A-Za-z0-9_.-]+:`)
+
+// parseSigningSecrets parses SIGNING_SECRET: either a single secret, or
+// comma-separated "kid:secret" entries so several keys can be active during
+// a rotation. A single secret is stored under the empty key ID. It also
+// returns the key ID new links are signed with: the first one listed.
+func parseSigningSecrets(s string) (map[string][]byte, string, error) {
+	entries := strings.Split(s, ",")
+	for _, entry := range entries {
+		if !signingKeyID.MatchString(strings.TrimSpace(entry)) {
+			return map[string][]byte{"": []byte(s)}, "", nil
+		}
+	}
+
+	secrets := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		kid, secret, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		if secret == "" {
+			return nil, "", fmt.Errorf("key %q has an empty secret", kid)
+		}
+		if _, ok := secrets[kid]; ok {
+			return nil, "", fmt.Errorf("duplicate key %q", kid)
+		}
+		secrets[kid] = []byte(secret)
+	}
+	current, _, _ := strings.Cut(strings.TrimSpace(entries[0]), ":")
+	return secrets, current, nil
+}
+
+// SignBaseURL returns the base of links from POST /sign: PUBLIC_URL under
+// BASE_PATH, or "" to use the request's scheme, host, and path
+func (c *Config) SignBaseURL() string {
+	if c.PublicURL == "" {
+		return ""
+	}
+	return strings.TrimRight(c.PublicURL, "/") + c.BasePath
+}
+
+// SignURL returns a signed download URL for link, signed like the links of
+// POST /sign: a version 2 signature with the key new links are signed with,
+// under SignBaseURL. It needs SIGNING_SECRET and PUBLIC_URL.
+func (c *Config) SignURL(link sign.Link) (string, error) {
+	secret, ok := c.SigningSecrets[c.SigningKeyID]
+	if !ok {
+		return "", fmt.Errorf("SIGNING_SECRET is required to sign links")
+	}
+	baseURL := c.SignBaseURL()
+	if baseURL == "" {
+		return "", fmt.Errorf("PUBLIC_URL is required to sign links outside a request")
+	}
+	signer := sign.Signer{BaseURL: baseURL, Secret: secret, KeyID: c.SigningKeyID, Version: 2}
+	return signer.SignURL(link)
+}
+
+// APIKeysEnabled reports whether requests may authenticate with an API key
+func (c *Config) APIKeysEnabled() bool {
+	return len(c.APIKeys) > 0 || c.APIKeyStore == "database"
+}
+
+// JWTEnabled reports whether a JWT key is configured, so bearer tokens are accepted
+func (c *Config) JWTEnabled() bool {
+	return len(c.JWTSecret) > 0 || len(c.JWTPublicKey) > 0 || c.JWTJWKSURL != ""
 }
 
 // Helper functions for parsing configuration values
@@ -277,6 +1338,70 @@ func parseFloat(s string, defaultValue float64) float64 {
 	return val
 }
 
+// parseCIDRList parses the comma-separated CIDRs or single IPs of the
+// variable name
+func parseCIDRList(name, s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range parseStringList(s) {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid %s entry %q: not an IP or CIDR", name, entry)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: %w", name, entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// parseCountryList parses comma-separated country codes, uppercased
+func parseCountryList(s string) []string {
+	countries := parseStringList(s)
+	for i, country := range countries {
+		countries[i] = strings.ToUpper(country)
+	}
+	return countries
+}
+
+// parseAPIKeys parses comma-separated name:key entries, each optionally
+// followed by :rate in requests/second
+func parseAPIKeys(s string) (map[string]models.APIKey, error) {
+	entries := parseStringList(s)
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	keys := make(map[string]models.APIKey, len(entries))
+	for _, entry := range entries {
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid API_KEYS entry %q: must be name:key or name:key:rate", entry)
+		}
+		apiKey := models.APIKey{Name: parts[0]}
+		if len(parts) == 3 {
+			rate, err := strconv.ParseFloat(parts[2], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid API_KEYS rate for %q: %w", parts[0], err)
+			}
+			apiKey.RateLimit = rate
+		}
+		if _, ok := keys[parts[1]]; ok {
+			return nil, fmt.Errorf("duplicate API_KEYS key for %q", parts[0])
+		}
+		keys[parts[1]] = apiKey
+	}
+	return keys, nil
+}
+
 func parseStringList(s string) []string {
 	if s == "" {
 		return nil
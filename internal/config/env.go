@@ -0,0 +1,17 @@
+package config
+
+import "os"
+
+// envPrefix namespaces zipperfly's environment variables, for environments
+// shared with other services: ZIPPERFLY_<NAME> may be set for any setting
+// instead of <NAME>, and takes precedence over it
+const envPrefix = "ZIPPERFLY_"
+
+// Getenv returns the value of the setting name, read from ZIPPERFLY_<NAME>
+// if that is set and from <NAME> otherwise
+func Getenv(name string) string {
+	if v := os.Getenv(envPrefix + name); v != "" {
+		return v
+	}
+	return os.Getenv(name)
+}
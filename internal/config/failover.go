@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// failoverPrefix starts the variables of the failover storage, named
+// FAILOVER_<SETTING>
+const failoverPrefix = "FAILOVER_"
+
+// failoverSettings are the settings of the failover storage: BUCKETS maps
+// primary buckets to their replicas, the rest override the base storage
+// settings
+var failoverSettings = []string{
+	"BUCKETS",
+	"S3_ENDPOINT",
+	"S3_REGION",
+	"S3_USE_PATH_STYLE",
+	"STORAGE_PATH",
+	"STORAGE_TYPE",
+}
+
+// failoverSecretSettings are the failover settings that may also be read
+// from a file named by their _FILE variant
+var failoverSecretSettings = []string{
+	"S3_ACCESS_KEY_ID",
+	"S3_SECRET_ACCESS_KEY",
+}
+
+// StorageFailover is a secondary storage provider, e.g. a replica bucket in
+// another region, tried when the primary fails
+type StorageFailover struct {
+	Config  *Config           // the base configuration with the failover storage settings
+	Buckets map[string]string // primary bucket -> its replica; unlisted buckets keep their name
+}
+
+// Bucket returns the failover bucket for a primary bucket
+func (f *StorageFailover) Bucket(primary string) string {
+	if replica, ok := f.Buckets[primary]; ok {
+		return replica
+	}
+	return primary
+}
+
+// loadFailover reads the failover storage settings over a copy of base. It
+// returns nil if neither FAILOVER_STORAGE_TYPE nor FAILOVER_STORAGE_PATH is
+// set.
+func loadFailover(base *Config) (*StorageFailover, error) {
+	get := func(setting string) string { return Getenv(failoverPrefix + setting) }
+
+	// Storage, auto-detected like the base configuration's when only a path
+	// is set
+	storageType, storagePath := strings.ToLower(get("STORAGE_TYPE")), get("STORAGE_PATH")
+	if storageType == "" && storagePath == "" {
+		return nil, nil
+	}
+	if storageType == "" {
+		storageType = "local"
+	}
+
+	cfg := *base
+	cfg.Tenants = nil
+	cfg.StorageFailover = nil
+	failover := &StorageFailover{Config: &cfg, Buckets: make(map[string]string)}
+
+	cfg.StorageType = storageType
+	if storagePath != "" {
+		cfg.StoragePath = storagePath
+	}
+	switch cfg.StorageType {
+	case "s3", "local":
+	default:
+		return nil, fmt.Errorf("invalid %sSTORAGE_TYPE %q: must be s3 or local", failoverPrefix, cfg.StorageType)
+	}
+	if cfg.StorageType == "local" && storagePath == "" {
+		return nil, fmt.Errorf("%sSTORAGE_PATH required when its storage type is local", failoverPrefix)
+	}
+	if v := get("S3_ENDPOINT"); v != "" {
+		cfg.S3Endpoint = v
+	}
+	if v := get("S3_REGION"); v != "" {
+		cfg.S3Region = v
+	}
+	if v := get("S3_USE_PATH_STYLE"); v != "" {
+		usePathStyle, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %sS3_USE_PATH_STYLE %q: must be true or false", failoverPrefix, v)
+		}
+		cfg.S3UsePathStyle = usePathStyle
+	}
+	accessKeyID, err := getSecret(failoverPrefix + "S3_ACCESS_KEY_ID")
+	if err != nil {
+		return nil, err
+	}
+	secretAccessKey, err := getSecret(failoverPrefix + "S3_SECRET_ACCESS_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if (accessKeyID == "") != (secretAccessKey == "") {
+		return nil, fmt.Errorf("%sS3_ACCESS_KEY_ID and %sS3_SECRET_ACCESS_KEY must be set together", failoverPrefix, failoverPrefix)
+	}
+	if accessKeyID != "" {
+		cfg.S3AccessKeyID, cfg.S3SecretAccessKey = accessKeyID, secretAccessKey
+	}
+
+	// Replica buckets, as primary:replica entries
+	for _, entry := range parseStringList(get("BUCKETS")) {
+		primary, replica, ok := strings.Cut(entry, ":")
+		primary, replica = strings.TrimSpace(primary), strings.TrimSpace(replica)
+		if !ok || primary == "" || replica == "" {
+			return nil, fmt.Errorf("invalid %sBUCKETS entry %q: must be primary:replica", failoverPrefix, entry)
+		}
+		failover.Buckets[primary] = replica
+	}
+
+	return failover, nil
+}
@@ -0,0 +1,336 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// fileSettings are the environment variables a config file may set, besides
+// secretSettings and VAULT_TOKEN, which may also be set with their _FILE
+// variants
+var fileSettings = []string{
+	"ABORT_ON_STREAM_ERROR",
+	"ACCESS_LOG",
+	"ACCESS_LOG_OUTPUT",
+	"ACCESS_LOG_SAMPLE_RATE",
+	"ACCESS_TIMEZONE",
+	"ACCESS_WINDOWS",
+	"ADAPTIVE_BACKPRESSURE",
+	"ADAPTIVE_CONCURRENCY",
+	"ADAPTIVE_INTERVAL",
+	"ADAPTIVE_MEMORY_LIMIT",
+	"ADMIN_PORT",
+	"ADMIN_USERNAME",
+	"ALLOWED_BUCKETS",
+	"ALLOWED_COUNTRIES",
+	"ALLOWED_EXTENSIONS",
+	"ALLOW_EMPTY_RECORDS",
+	"ALLOW_PASSWORD_PROTECTED",
+	"API_KEY_RATE_LIMIT",
+	"API_KEY_STORE",
+	"API_KEY_TABLE",
+	"APPEND_YMD",
+	"ARCHIVE_MANIFEST",
+	"ASYNC_BUILDS",
+	"AUTH_WEBHOOK_TIMEOUT",
+	"AUTH_WEBHOOK_URL",
+	"BASE_PATH",
+	"BLOCKED_COUNTRIES",
+	"BLOCKED_EXTENSIONS",
+	"CALLBACK_FILE_RESULTS",
+	"CALLBACK_MAX_ELAPSED",
+	"CALLBACK_MAX_RETRIES",
+	"CALLBACK_PROGRESS_BYTES",
+	"CALLBACK_QUEUE",
+	"CALLBACK_QUEUE_INTERVAL",
+	"CALLBACK_QUEUE_PATH",
+	"CALLBACK_QUEUE_TABLE",
+	"CALLBACK_RETRY_DELAY",
+	"CALLBACK_RETRY_MAX_DELAY",
+	"CALLBACK_STARTED",
+	"CASSANDRA_CONSISTENCY",
+	"CIRCUIT_BREAKER_EVENTS",
+	"CIRCUIT_BREAKER_MAX_REQUESTS",
+	"CIRCUIT_BREAKER_SCOPE",
+	"CIRCUIT_BREAKER_THRESHOLD",
+	"CIRCUIT_BREAKER_TIMEOUT",
+	"CLIENT_CA_FILE",
+	"CLIENT_CERT_NAMES",
+	"CLIENT_IP_HEADER",
+	"COMPRESSION_LEVEL",
+	"CORS_ALLOWED_HEADERS",
+	"CORS_ALLOWED_METHODS",
+	"CORS_ALLOWED_ORIGINS",
+	"CORS_ALLOW_CREDENTIALS",
+	"CORS_EXPOSED_HEADERS",
+	"CORS_MAX_AGE",
+	"DATABASE_QUERY_TIMEOUT",
+	"DB_MAX_CONNECTIONS",
+	"DB_TLS_CA",
+	"DB_TLS_CERT",
+	"DB_TLS_KEY",
+	"DB_TLS_MODE",
+	"DEFAULT_CALLBACK",
+	"DISABLE_CALLBACKS",
+	"DOWNLOAD_QUEUE_LENGTH",
+	"DOWNLOAD_QUEUE_WAIT",
+	"DOWNLOAD_TOKENS",
+	"DOWNLOAD_TOKEN_KEY_PREFIX",
+	"DOWNLOAD_TOKEN_ORIGINS",
+	"DOWNLOAD_TOKEN_TTL",
+	"ENABLE_H2C",
+	"ENABLE_HTTP2",
+	"ENABLE_HTTPS",
+	"ENFORCE_SIGNING",
+	"ENTRY_ORDER",
+	"ERROR_FORMAT",
+	"ERROR_PAGE_TEMPLATE",
+	"EVENT_BUS",
+	"EVENT_TOPIC",
+	"EXTRA_FILES",
+	"EXTRA_FILES_INLINE",
+	"FILE_FETCH_TIMEOUT",
+	"FLUSH_INTERVAL",
+	"GEOIP_DATABASE",
+	"HEALTH_CACHE_TTL",
+	"HTTPS_PORT",
+	"IDLE_TIMEOUT",
+	"ID_FIELD",
+	"IGNORE_MISSING",
+	"INTERNAL_PORT",
+	"IP_ALLOWLIST",
+	"IP_DENYLIST",
+	"JWT_AUDIENCE",
+	"JWT_ISSUER",
+	"JWT_JWKS_URL",
+	"JWT_PUBLIC_KEY_FILE",
+	"JWT_USER_CLAIM",
+	"KEY_PREFIX",
+	"LARGE_DOWNLOAD_THRESHOLD",
+	"LEGACY_REDIRECTS",
+	"LETSENCRYPT_CACHE_DIR",
+	"LETSENCRYPT_DOMAINS",
+	"LETSENCRYPT_EMAIL",
+	"LIMITS_KEY_PREFIX",
+	"LOG_COMPRESS",
+	"LOG_FORMAT",
+	"LOG_LEVEL",
+	"LOG_MAX_AGE_DAYS",
+	"LOG_MAX_BACKUPS",
+	"LOG_MAX_SIZE_MB",
+	"LOG_OUTPUT",
+	"MAINTENANCE_WINDOWS",
+	"MANIFEST_FORMAT",
+	"MAX_ACTIVE_DOWNLOADS",
+	"MAX_ARCHIVE_SIZE",
+	"MAX_CONCURRENT_FETCHES",
+	"MAX_FILES_PER_REQUEST",
+	"MAX_FILE_SIZE",
+	"MAX_HEADER_BYTES",
+	"METRICS_TENANT_LABEL",
+	"METRICS_TENANT_LIMIT",
+	"METRICS_USERNAME",
+	"MIN_SIGNATURE_VERSION",
+	"PORT",
+	"PROGRESS_EVENTS",
+	"PUBLIC_URL",
+	"RATE_LIMIT_BURST",
+	"RATE_LIMIT_PER_IP",
+	"RATE_LIMIT_PER_RECORD",
+	"RATE_LIMIT_RECORD_BURST",
+	"READ_HEADER_TIMEOUT",
+	"RECORD_CACHE_SIZE",
+	"RECORD_CACHE_STALE",
+	"RECORD_CACHE_TTL",
+	"REQUEST_TIMEOUT",
+	"S3_ENDPOINT",
+	"S3_HEALTH_CHECK",
+	"S3_HEALTH_CHECK_BUCKET",
+	"S3_HEALTH_CHECK_KEY",
+	"S3_REGION",
+	"S3_USE_PATH_STYLE",
+	"SANITIZE_CHARSET",
+	"SANITIZE_FILENAMES",
+	"SECRETS_PATH",
+	"SECRETS_PROVIDER",
+	"SECRETS_REFRESH_INTERVAL",
+	"SHUTDOWN_TIMEOUT",
+	"SIGN_DEFAULT_TTL",
+	"SIGN_USERNAME",
+	"SLOW_DOWNLOAD_THRESHOLD",
+	"SPOOL_DIR",
+	"SPOOL_MEMORY_LIMIT",
+	"STAGING_DIR",
+	"STAGING_TTL",
+	"STALL_TIMEOUT",
+	"STORAGE_FETCH_TIMEOUT",
+	"STORAGE_HEDGE_MIN_DELAY",
+	"STORAGE_HEDGE_PERCENTILE",
+	"STORAGE_MAX_RETRIES",
+	"STORAGE_PATH",
+	"STORAGE_RETRY_BUDGET",
+	"STORAGE_RETRY_DELAY",
+	"STORAGE_RETRY_MAX_DELAY",
+	"STORAGE_TYPE",
+	"STRICT_CONFIG",
+	"TABLE_NAME",
+	"TENANTS",
+	"TLS_CERT_FILE",
+	"TLS_KEY_FILE",
+	"TRUSTED_PROXIES",
+	"UNIX_SOCKET",
+	"UNIX_SOCKET_MODE",
+	"VAULT_ADDR",
+	"WRITE_TIMEOUT",
+	"ZIP_ENCRYPTION",
+	"ZIP_STORE_EXTENSIONS",
+	"ZIP_STORE_ONLY",
+	"ZSTD_LEVEL",
+}
+
+// knownSettings returns every setting a config file may set
+func knownSettings() map[string]bool {
+	known := make(map[string]bool, len(fileSettings)+2*len(secretSettings)+2)
+	for _, name := range fileSettings {
+		known[name] = true
+	}
+	for _, name := range append(slices.Clone(secretSettings), "VAULT_TOKEN") {
+		known[name] = true
+		known[name+"_FILE"] = true
+	}
+	for _, name := range failoverSettings {
+		known[failoverPrefix+name] = true
+	}
+	for _, name := range failoverSecretSettings {
+		known[failoverPrefix+name] = true
+		known[failoverPrefix+name+"_FILE"] = true
+	}
+	return known
+}
+
+// LoadFile reads settings from a YAML (.yaml, .yml) or TOML (.toml) file and
+// sets them as environment variables, so Load validates them like any other.
+// Keys are the environment variable names, in any case; a nested table
+// prefixes its keys with its own name, so s3: {endpoint: ...} sets
+// S3_ENDPOINT. Lists are joined with commas. Variables already set in the
+// environment take precedence over the file, and unknown keys are an error.
+func LoadFile(path string) error {
+	settings, err := ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for name, value := range settings {
+		if _, ok := os.LookupEnv(name); ok {
+			continue
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadFile reads the settings in a YAML or TOML file, as LoadFile does,
+// without setting them
+func ReadFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]any)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported config file extension %q (must be .yaml, .yml, or .toml)", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	settings := make(map[string]string)
+	if err := flattenSettings(settings, "", raw); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+
+	known := knownSettings()
+	var unknown []string
+	for name := range settings {
+		if !known[name] && !isTenantSetting(name) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		slices.Sort(unknown)
+		return nil, fmt.Errorf("invalid %s: unknown settings %s", path, strings.Join(unknown, ", "))
+	}
+	return settings, nil
+}
+
+// flattenSettings adds the settings in table to settings, naming each by its
+// key upper-cased and prefixed with the names of the tables it is nested in
+func flattenSettings(settings map[string]string, prefix string, table map[string]any) error {
+	for key, value := range table {
+		name := strings.ToUpper(key)
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		if _, ok := settings[name]; ok {
+			return fmt.Errorf("%s is set more than once", name)
+		}
+
+		switch v := value.(type) {
+		case map[string]any:
+			if err := flattenSettings(settings, name, v); err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				s, err := settingValue(item)
+				if err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+				items[i] = s
+			}
+			settings[name] = strings.Join(items, ",")
+		default:
+			s, err := settingValue(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			settings[name] = s
+		}
+	}
+	return nil
+}
+
+// settingValue formats a scalar from a config file as an environment variable
+func settingValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v (%T)", v, v)
+	}
+}
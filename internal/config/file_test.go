@@ -0,0 +1,158 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// unsetenv unsets name for the rest of the test, restoring it afterwards
+func unsetenv(t *testing.T, name string) {
+	t.Setenv(name, "")
+	os.Unsetenv(name)
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		env     map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "yaml",
+			file: "config.yaml",
+			content: `db_url: postgres://localhost/db
+s3:
+  endpoint: https://s3.example.com
+  use_path_style: true
+MAX_FILE_SIZE: 1048576
+allowed_buckets: [public, reports]
+`,
+			want: map[string]string{
+				"DB_URL":            "postgres://localhost/db",
+				"S3_ENDPOINT":       "https://s3.example.com",
+				"S3_USE_PATH_STYLE": "true",
+				"MAX_FILE_SIZE":     "1048576",
+				"ALLOWED_BUCKETS":   "public,reports",
+			},
+		},
+		{
+			name: "toml",
+			file: "config.toml",
+			content: `db_url = "postgres://localhost/db"
+access_log_sample_rate = 0.5
+request_timeout = "5m"
+
+[s3]
+region = "eu-west-1"
+`,
+			want: map[string]string{
+				"DB_URL":                 "postgres://localhost/db",
+				"ACCESS_LOG_SAMPLE_RATE": "0.5",
+				"REQUEST_TIMEOUT":        "5m",
+				"S3_REGION":              "eu-west-1",
+			},
+		},
+		{
+			name:    "environment takes precedence",
+			file:    "config.yml",
+			content: "db_url: postgres://file/db\ntable_name: files\n",
+			env:     map[string]string{"DB_URL": "postgres://env/db"},
+			want:    map[string]string{"DB_URL": "postgres://env/db", "TABLE_NAME": "files"},
+		},
+		{
+			name:    "secret file",
+			file:    "config.yaml",
+			content: "signing_secret_file: /run/secrets/signing\n",
+			want:    map[string]string{"SIGNING_SECRET_FILE": "/run/secrets/signing"},
+		},
+		{
+			name:    "unknown setting",
+			file:    "config.yaml",
+			content: "db_url: postgres://localhost/db\ns3:\n  endpiont: https://s3.example.com\n",
+			wantErr: true,
+		},
+		{
+			name:    "set twice",
+			file:    "config.yaml",
+			content: "s3_region: us-east-1\ns3:\n  region: eu-west-1\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid yaml",
+			file:    "config.yaml",
+			content: "db_url: [\n",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported extension",
+			file:    "config.json",
+			content: `{"db_url": "postgres://localhost/db"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name := range knownSettings() {
+				unsetenv(t, name)
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			err := LoadFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for name, want := range tt.want {
+				if got := os.Getenv(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadFile_Validated(t *testing.T) {
+	for name := range knownSettings() {
+		unsetenv(t, name)
+	}
+	t.Setenv("ENABLE_HTTPS", "false")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("db_url: postgres://localhost/db\nhealth_cache_ttl: -1s\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadFile(path); err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	if _, err := Load(); err == nil {
+		t.Error("Load() error = nil, want an error for HEALTH_CACHE_TTL from the config file")
+	}
+}
+
+func TestKnownSettings(t *testing.T) {
+	// Every setting Load reads must be settable from a config file
+	known := knownSettings()
+	read := regexp.MustCompile(`(?:Getenv|getSecret)\("([A-Z0-9_]+)"\)`)
+	for _, file := range []string{"config.go", "secrets.go", "logging.go"} {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range read.FindAllStringSubmatch(string(src), -1) {
+			if !known[m[1]] {
+				t.Errorf("%s is read by %s but missing from fileSettings", m[1], file)
+			}
+		}
+	}
+}
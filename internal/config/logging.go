@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LogConfig holds the server log settings. They are read on their own,
+// before Load, since the logger is built before secrets are fetched and
+// the rest of the configuration is loaded.
+type LogConfig struct {
+	Level      string // debug, info (default), warn, or error
+	Format     string // json (default) or console
+	Output     string // stderr (default), stdout, or a file path, rotated by size
+	MaxSizeMB  int    // size at which the log file is rotated (default: 100)
+	MaxBackups int    // rotated files kept, 0 = all
+	MaxAgeDays int    // days rotated files are kept, 0 = forever
+	Compress   bool   // gzip rotated files
+}
+
+// LoadLogConfig reads the log settings from environment variables
+func LoadLogConfig() (*LogConfig, error) {
+	level := strings.ToLower(Getenv("LOG_LEVEL"))
+	switch level {
+	case "":
+		level = "info"
+	case "debug", "info", "warn", "error":
+	default:
+		return nil, fmt.Errorf("invalid LOG_LEVEL %q: must be debug, info, warn, or error", level)
+	}
+
+	format := strings.ToLower(Getenv("LOG_FORMAT"))
+	switch format {
+	case "":
+		format = "json"
+	case "json", "console":
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q: must be json or console", format)
+	}
+
+	output := Getenv("LOG_OUTPUT")
+	if output == "" {
+		output = "stderr"
+	}
+
+	maxSizeMB := parseInt(Getenv("LOG_MAX_SIZE_MB"), 100)
+	if maxSizeMB <= 0 {
+		return nil, fmt.Errorf("invalid LOG_MAX_SIZE_MB %d: must be positive", maxSizeMB)
+	}
+	maxBackups := parseInt(Getenv("LOG_MAX_BACKUPS"), 0)
+	if maxBackups < 0 {
+		return nil, fmt.Errorf("invalid LOG_MAX_BACKUPS %d: cannot be negative", maxBackups)
+	}
+	maxAgeDays := parseInt(Getenv("LOG_MAX_AGE_DAYS"), 0)
+	if maxAgeDays < 0 {
+		return nil, fmt.Errorf("invalid LOG_MAX_AGE_DAYS %d: cannot be negative", maxAgeDays)
+	}
+	compress, _ := strconv.ParseBool(Getenv("LOG_COMPRESS"))
+
+	return &LogConfig{
+		Level:      level,
+		Format:     format,
+		Output:     output,
+		MaxSizeMB:  maxSizeMB,
+		MaxBackups: maxBackups,
+		MaxAgeDays: maxAgeDays,
+		Compress:   compress,
+	}, nil
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadLogConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    LogConfig
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			want: LogConfig{Level: "info", Format: "json", Output: "stderr", MaxSizeMB: 100},
+		},
+		{
+			name: "console file",
+			env: map[string]string{
+				"LOG_LEVEL":        "Debug",
+				"LOG_FORMAT":       "CONSOLE",
+				"LOG_OUTPUT":       "/var/log/zipperfly.log",
+				"LOG_MAX_SIZE_MB":  "10",
+				"LOG_MAX_BACKUPS":  "5",
+				"LOG_MAX_AGE_DAYS": "7",
+				"LOG_COMPRESS":     "true",
+			},
+			want: LogConfig{Level: "debug", Format: "console", Output: "/var/log/zipperfly.log", MaxSizeMB: 10, MaxBackups: 5, MaxAgeDays: 7, Compress: true},
+		},
+		{name: "invalid level", env: map[string]string{"LOG_LEVEL": "loud"}, wantErr: "LOG_LEVEL"},
+		{name: "invalid format", env: map[string]string{"LOG_FORMAT": "xml"}, wantErr: "LOG_FORMAT"},
+		{name: "zero size", env: map[string]string{"LOG_MAX_SIZE_MB": "0"}, wantErr: "LOG_MAX_SIZE_MB"},
+		{name: "negative backups", env: map[string]string{"LOG_MAX_BACKUPS": "-1"}, wantErr: "LOG_MAX_BACKUPS"},
+		{name: "negative age", env: map[string]string{"LOG_MAX_AGE_DAYS": "-1"}, wantErr: "LOG_MAX_AGE_DAYS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"LOG_LEVEL", "LOG_FORMAT", "LOG_OUTPUT", "LOG_MAX_SIZE_MB", "LOG_MAX_BACKUPS", "LOG_MAX_AGE_DAYS", "LOG_COMPRESS"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			cfg, err := LoadLogConfig()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadLogConfig() error = %v, want it to mention %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadLogConfig() error = %v", err)
+			}
+			if *cfg != tt.want {
+				t.Errorf("LoadLogConfig() = %+v, want %+v", *cfg, tt.want)
+			}
+		})
+	}
+}
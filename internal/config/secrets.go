@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// secretSettings are the settings that may also be read from a file named
+// by <NAME>_FILE, e.g. a mounted Docker or Kubernetes secret
+var secretSettings = []string{
+	"DB_URL",
+	"SIGNING_SECRET",
+	"S3_ACCESS_KEY_ID",
+	"S3_SECRET_ACCESS_KEY",
+	"JWT_SECRET",
+	"API_KEYS",
+	"SIGN_PASSWORD",
+	"METRICS_PASSWORD",
+	"ADMIN_PASSWORD",
+	"LIMITS_REDIS_URL",
+	"EVENT_BUS_URL",
+	"DOWNLOAD_TOKEN_REDIS_URL",
+}
+
+// SecretsConfig holds the settings for fetching secrets from an external
+// manager. They are read on their own, before Load, since the secrets
+// they fetch may be needed by the rest of the configuration.
+type SecretsConfig struct {
+	Provider        string        // none (default), vault, or aws
+	Path            string        // Vault KV path or AWS secret ID
+	VaultAddr       string        // Vault server address
+	VaultToken      string        // Vault token
+	RefreshInterval time.Duration // how often secrets are fetched again, 0 = only at startup
+}
+
+// LoadSecretsConfig reads the secrets manager settings from environment variables
+func LoadSecretsConfig() (*SecretsConfig, error) {
+	provider := strings.ToLower(Getenv("SECRETS_PROVIDER"))
+	switch provider {
+	case "":
+		provider = "none"
+	case "none", "vault", "aws":
+	default:
+		return nil, fmt.Errorf("invalid SECRETS_PROVIDER %q: must be none, vault, or aws", provider)
+	}
+
+	path := Getenv("SECRETS_PATH")
+	if provider != "none" && path == "" {
+		return nil, fmt.Errorf("SECRETS_PATH required when SECRETS_PROVIDER=%s", provider)
+	}
+
+	vaultAddr := Getenv("VAULT_ADDR")
+	vaultToken, err := getSecret("VAULT_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	if provider == "vault" && (vaultAddr == "" || vaultToken == "") {
+		return nil, fmt.Errorf("VAULT_ADDR and VAULT_TOKEN required when SECRETS_PROVIDER=vault")
+	}
+
+	refreshInterval := parseDuration(Getenv("SECRETS_REFRESH_INTERVAL"), 0)
+	if refreshInterval < 0 {
+		return nil, fmt.Errorf("invalid SECRETS_REFRESH_INTERVAL %s: cannot be negative", refreshInterval)
+	}
+
+	return &SecretsConfig{
+		Provider:        provider,
+		Path:            path,
+		VaultAddr:       vaultAddr,
+		VaultToken:      vaultToken,
+		RefreshInterval: refreshInterval,
+	}, nil
+}
+
+// readSecrets reads every secret setting, from its environment variable or
+// the file its _FILE variant names
+func readSecrets() (map[string]string, error) {
+	secrets := make(map[string]string, len(secretSettings))
+	for _, name := range secretSettings {
+		value, err := getSecret(name)
+		if err != nil {
+			return nil, err
+		}
+		secrets[name] = value
+	}
+	return secrets, nil
+}
+
+// getSecret returns the value of the environment variable name, or the
+// contents of the file named by name_FILE. Trailing newlines are trimmed
+// from files, since most tools that write secrets add one.
+func getSecret(name string) (string, error) {
+	// The prefixed variables are read together, so an unprefixed one
+	// another service set isn't taken for a conflicting value
+	if os.Getenv(envPrefix+name) != "" || os.Getenv(envPrefix+name+"_FILE") != "" {
+		name = envPrefix + name
+	}
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return os.Getenv(name), nil
+	}
+	if os.Getenv(name) != "" {
+		return "", fmt.Errorf("%s and %s_FILE are mutually exclusive", name, name)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,231 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// tenantPrefix starts the variables of tenant profiles, named
+// TENANT_<NAME>_<SETTING>
+const tenantPrefix = "TENANT_"
+
+// tenantSettings are the settings a tenant profile may set: HOSTS and
+// PATH_PREFIX select the profile, the rest override the base configuration
+var tenantSettings = []string{
+	"ALLOWED_BUCKETS",
+	"HOSTS",
+	"KEY_PREFIX",
+	"MAX_ARCHIVE_SIZE",
+	"MAX_FILES_PER_REQUEST",
+	"MAX_FILE_SIZE",
+	"PATH_PREFIX",
+	"PUBLIC_URL",
+	"S3_ENDPOINT",
+	"S3_REGION",
+	"S3_USE_PATH_STYLE",
+	"STORAGE_PATH",
+	"STORAGE_TYPE",
+	"TABLE_NAME",
+}
+
+// tenantSecretSettings are the tenant settings that may also be read from a
+// file named by their _FILE variant
+var tenantSecretSettings = []string{
+	"SIGNING_SECRET",
+	"S3_ACCESS_KEY_ID",
+	"S3_SECRET_ACCESS_KEY",
+}
+
+// tenantName matches a profile name in TENANTS. Underscores aren't allowed,
+// so a variable's profile and setting can be told apart.
+var tenantName = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// Tenant is a tenant profile: a customer served from the same deployment
+// with its own signing secret, storage, database table, and limits
+type Tenant struct {
+	Name       string
+	Hosts      []string // hostnames that select the profile, empty = any
+	PathPrefix string   // path prefix that selects the profile, e.g. "/acme"; "" = none
+	Config     *Config  // the base configuration with the profile's overrides
+}
+
+// isTenantSetting reports whether name is a TENANT_<NAME>_<SETTING>
+// variable, without checking that the profile is listed in TENANTS
+func isTenantSetting(name string) bool {
+	rest, ok := strings.CutPrefix(name, tenantPrefix)
+	if !ok {
+		return false
+	}
+	_, setting, ok := strings.Cut(rest, "_")
+	if !ok {
+		return false
+	}
+	setting = strings.TrimSuffix(setting, "_FILE")
+	return slices.Contains(tenantSettings, setting) || slices.Contains(tenantSecretSettings, setting)
+}
+
+// loadTenants reads the profiles listed in TENANTS from their
+// TENANT_<NAME>_<SETTING> variables in environ, each applied over a copy of
+// base. Variables of unlisted profiles and unknown settings are an error.
+func loadTenants(base *Config, environ []string) ([]Tenant, error) {
+	var names []string
+	for _, name := range parseStringList(Getenv("TENANTS")) {
+		name = strings.ToLower(name)
+		if !tenantName.MatchString(name) {
+			return nil, fmt.Errorf("invalid TENANTS name %q: must be letters and digits", name)
+		}
+		if slices.Contains(names, name) {
+			return nil, fmt.Errorf("invalid TENANTS: %s is listed twice", name)
+		}
+		names = append(names, name)
+	}
+
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		setting := strings.TrimPrefix(name, envPrefix)
+		if !strings.HasPrefix(setting, tenantPrefix) {
+			continue
+		}
+		if !isTenantSetting(setting) {
+			return nil, fmt.Errorf("unknown tenant setting %s", name)
+		}
+		profile, _, _ := strings.Cut(strings.TrimPrefix(setting, tenantPrefix), "_")
+		if !slices.Contains(names, strings.ToLower(profile)) {
+			return nil, fmt.Errorf("%s is set but %s isn't listed in TENANTS", name, strings.ToLower(profile))
+		}
+	}
+
+	tenants := make([]Tenant, 0, len(names))
+	for _, name := range names {
+		tenant, err := loadTenant(base, name)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, nil
+}
+
+// loadTenant reads one profile's settings over a copy of base
+func loadTenant(base *Config, name string) (Tenant, error) {
+	prefix := tenantPrefix + strings.ToUpper(name) + "_"
+	get := func(setting string) string { return Getenv(prefix + setting) }
+
+	cfg := *base
+	cfg.Tenants = nil
+	cfg.StorageFailover = nil // the base failover storage is for the base buckets
+	tenant := Tenant{Name: name, Config: &cfg}
+
+	for _, host := range parseStringList(get("HOSTS")) {
+		tenant.Hosts = append(tenant.Hosts, strings.ToLower(host))
+	}
+	if pathPrefix := get("PATH_PREFIX"); pathPrefix != "" {
+		tenant.PathPrefix = "/" + strings.Trim(pathPrefix, "/")
+		if tenant.PathPrefix == "/" || strings.ContainsAny(tenant.PathPrefix, "{}") {
+			return Tenant{}, fmt.Errorf("invalid %sPATH_PREFIX %q", prefix, pathPrefix)
+		}
+	}
+	if len(tenant.Hosts) == 0 && tenant.PathPrefix == "" {
+		return Tenant{}, fmt.Errorf("%sHOSTS or %sPATH_PREFIX required for tenant %s", prefix, prefix, name)
+	}
+
+	// Signing
+	if secret, err := getSecret(prefix + "SIGNING_SECRET"); err != nil {
+		return Tenant{}, err
+	} else if secret != "" {
+		if cfg.SigningSecrets, cfg.SigningKeyID, err = parseSigningSecrets(secret); err != nil {
+			return Tenant{}, fmt.Errorf("invalid %sSIGNING_SECRET: %w", prefix, err)
+		}
+	}
+	if publicURL := get("PUBLIC_URL"); publicURL != "" {
+		cfg.PublicURL = publicURL
+	} else if cfg.PublicURL != "" && tenant.PathPrefix != "" {
+		cfg.PublicURL = strings.TrimRight(cfg.PublicURL, "/") + tenant.PathPrefix
+	}
+
+	// Storage, auto-detected like the base configuration's when only a path
+	// is set
+	storageType, storagePath := get("STORAGE_TYPE"), get("STORAGE_PATH")
+	if storagePath != "" {
+		cfg.StoragePath = storagePath
+		if storageType == "" {
+			storageType = "local"
+		}
+	}
+	if storageType != "" {
+		cfg.StorageType = storageType
+	}
+	switch cfg.StorageType {
+	case "s3", "local":
+	default:
+		return Tenant{}, fmt.Errorf("invalid %sSTORAGE_TYPE %q: must be s3 or local", prefix, cfg.StorageType)
+	}
+	if cfg.StorageType == "local" && cfg.StoragePath == "" {
+		return Tenant{}, fmt.Errorf("%sSTORAGE_PATH required when its storage type is local", prefix)
+	}
+	if v := get("S3_ENDPOINT"); v != "" {
+		cfg.S3Endpoint = v
+	}
+	if v := get("S3_REGION"); v != "" {
+		cfg.S3Region = v
+	}
+	if v := get("S3_USE_PATH_STYLE"); v != "" {
+		usePathStyle, err := strconv.ParseBool(v)
+		if err != nil {
+			return Tenant{}, fmt.Errorf("invalid %sS3_USE_PATH_STYLE %q: must be true or false", prefix, v)
+		}
+		cfg.S3UsePathStyle = usePathStyle
+	}
+	accessKeyID, err := getSecret(prefix + "S3_ACCESS_KEY_ID")
+	if err != nil {
+		return Tenant{}, err
+	}
+	secretAccessKey, err := getSecret(prefix + "S3_SECRET_ACCESS_KEY")
+	if err != nil {
+		return Tenant{}, err
+	}
+	if (accessKeyID == "") != (secretAccessKey == "") {
+		return Tenant{}, fmt.Errorf("%sS3_ACCESS_KEY_ID and %sS3_SECRET_ACCESS_KEY must be set together", prefix, prefix)
+	}
+	if accessKeyID != "" {
+		cfg.S3AccessKeyID, cfg.S3SecretAccessKey = accessKeyID, secretAccessKey
+	}
+
+	// Database
+	if v := get("TABLE_NAME"); v != "" {
+		cfg.TableName = v
+	}
+	if v := get("KEY_PREFIX"); v != "" {
+		cfg.KeyPrefix = v
+	}
+
+	// Limits
+	if v := get("ALLOWED_BUCKETS"); v != "" {
+		cfg.AllowedBuckets = parseStringList(v)
+	}
+	for setting, limit := range map[string]*int64{"MAX_FILE_SIZE": &cfg.MaxFileSize, "MAX_ARCHIVE_SIZE": &cfg.MaxArchiveSize} {
+		if v := get(setting); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return Tenant{}, fmt.Errorf("invalid %s%s %q: must be a non-negative integer", prefix, setting, v)
+			}
+			*limit = n
+		}
+	}
+	if v := get("MAX_FILES_PER_REQUEST"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return Tenant{}, fmt.Errorf("invalid %sMAX_FILES_PER_REQUEST %q: must be a non-negative integer", prefix, v)
+		}
+		cfg.MaxFilesPerRequest = n
+	}
+
+	// Download tokens are kept apart, so one profile's token can't be
+	// redeemed for another's record with the same ID
+	cfg.DownloadTokenKeyPrefix = base.DownloadTokenKeyPrefix + name + ":"
+
+	return tenant, nil
+}
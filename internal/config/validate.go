@@ -0,0 +1,160 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings Load parses as numbers, durations, or booleans. Without strict
+// validation an invalid value silently falls back to the default.
+var (
+	intSettings = []string{
+		"ADAPTIVE_MEMORY_LIMIT",
+		"CALLBACK_MAX_RETRIES",
+		"CALLBACK_PROGRESS_BYTES",
+		"CIRCUIT_BREAKER_MAX_REQUESTS",
+		"CIRCUIT_BREAKER_THRESHOLD",
+		"COMPRESSION_LEVEL",
+		"DB_MAX_CONNECTIONS",
+		"DOWNLOAD_QUEUE_LENGTH",
+		"LARGE_DOWNLOAD_THRESHOLD",
+		"LOG_MAX_AGE_DAYS",
+		"LOG_MAX_BACKUPS",
+		"LOG_MAX_SIZE_MB",
+		"MAX_ACTIVE_DOWNLOADS",
+		"MAX_ARCHIVE_SIZE",
+		"MAX_FILES_PER_REQUEST",
+		"MAX_FILE_SIZE",
+		"MAX_HEADER_BYTES",
+		"METRICS_TENANT_LIMIT",
+		"MIN_SIGNATURE_VERSION",
+		"RATE_LIMIT_BURST",
+		"RATE_LIMIT_RECORD_BURST",
+		"RECORD_CACHE_SIZE",
+		"SPOOL_MEMORY_LIMIT",
+		"STORAGE_MAX_RETRIES",
+		"STORAGE_RETRY_BUDGET",
+		"ZSTD_LEVEL",
+	}
+	floatSettings = []string{
+		"ACCESS_LOG_SAMPLE_RATE",
+		"ADAPTIVE_BACKPRESSURE",
+		"API_KEY_RATE_LIMIT",
+		"RATE_LIMIT_PER_IP",
+		"RATE_LIMIT_PER_RECORD",
+		"STORAGE_HEDGE_PERCENTILE",
+	}
+	durationSettings = []string{
+		"ADAPTIVE_INTERVAL",
+		"AUTH_WEBHOOK_TIMEOUT",
+		"CALLBACK_MAX_ELAPSED",
+		"CALLBACK_QUEUE_INTERVAL",
+		"CALLBACK_RETRY_DELAY",
+		"CALLBACK_RETRY_MAX_DELAY",
+		"CIRCUIT_BREAKER_TIMEOUT",
+		"CORS_MAX_AGE",
+		"DATABASE_QUERY_TIMEOUT",
+		"DOWNLOAD_QUEUE_WAIT",
+		"DOWNLOAD_TOKEN_TTL",
+		"FILE_FETCH_TIMEOUT",
+		"FLUSH_INTERVAL",
+		"HEALTH_CACHE_TTL",
+		"IDLE_TIMEOUT",
+		"READ_HEADER_TIMEOUT",
+		"RECORD_CACHE_STALE",
+		"RECORD_CACHE_TTL",
+		"REQUEST_TIMEOUT",
+		"SECRETS_REFRESH_INTERVAL",
+		"SHUTDOWN_TIMEOUT",
+		"SIGN_DEFAULT_TTL",
+		"SLOW_DOWNLOAD_THRESHOLD",
+		"STAGING_TTL",
+		"STALL_TIMEOUT",
+		"STORAGE_FETCH_TIMEOUT",
+		"STORAGE_HEDGE_MIN_DELAY",
+		"STORAGE_RETRY_DELAY",
+		"STORAGE_RETRY_MAX_DELAY",
+		"WRITE_TIMEOUT",
+	}
+	boolSettings = []string{
+		"ABORT_ON_STREAM_ERROR",
+		"ACCESS_LOG",
+		"ADAPTIVE_CONCURRENCY",
+		"ALLOW_EMPTY_RECORDS",
+		"ALLOW_PASSWORD_PROTECTED",
+		"APPEND_YMD",
+		"ARCHIVE_MANIFEST",
+		"ASYNC_BUILDS",
+		"CALLBACK_FILE_RESULTS",
+		"CALLBACK_STARTED",
+		"CIRCUIT_BREAKER_EVENTS",
+		"CORS_ALLOW_CREDENTIALS",
+		"DISABLE_CALLBACKS",
+		"DOWNLOAD_TOKENS",
+		"ENABLE_H2C",
+		"ENABLE_HTTP2",
+		"ENABLE_HTTPS",
+		"ENFORCE_SIGNING",
+		"IGNORE_MISSING",
+		"LEGACY_REDIRECTS",
+		"LOG_COMPRESS",
+		"PROGRESS_EVENTS",
+		"S3_USE_PATH_STYLE",
+		"SANITIZE_FILENAMES",
+		"ZIP_STORE_ONLY",
+	}
+)
+
+// strictConfig reports whether STRICT_CONFIG is on, which it is by default
+func strictConfig() (bool, error) {
+	v := Getenv("STRICT_CONFIG")
+	if v == "" {
+		return true, nil
+	}
+	strict, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid STRICT_CONFIG %q: must be true or false", v)
+	}
+	return strict, nil
+}
+
+// validateSettings checks that every typed setting in the environment parses
+// and that no unknown ZIPPERFLY_ variables are set, returning all problems
+// found at once rather than only the first
+func validateSettings(environ []string) error {
+	var errs []error
+	check := func(names []string, parse func(string) error, want string) {
+		for _, name := range names {
+			if v := Getenv(name); v != "" {
+				if err := parse(v); err != nil {
+					errs = append(errs, fmt.Errorf("invalid %s %q: must be %s", name, v, want))
+				}
+			}
+		}
+	}
+	check(intSettings, func(v string) error { _, err := strconv.Atoi(v); return err }, "an integer")
+	check(floatSettings, func(v string) error { _, err := strconv.ParseFloat(v, 64); return err }, "a number")
+	check(durationSettings, func(v string) error { _, err := time.ParseDuration(v); return err }, `a duration such as "30s" or "5m"`)
+	check(boolSettings, func(v string) error { _, err := strconv.ParseBool(v); return err }, "true or false")
+
+	// Variables with the prefix are meant for zipperfly; any that aren't
+	// settings are likely typos
+	known := knownSettings()
+	var unknown []string
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if setting, ok := strings.CutPrefix(name, envPrefix); ok && !known[setting] && !isTenantSetting(setting) {
+			unknown = append(unknown, name)
+		}
+	}
+	slices.Sort(unknown)
+	for _, name := range unknown {
+		errs = append(errs, fmt.Errorf("unknown setting %s", name))
+	}
+
+	return errors.Join(errs...)
+}
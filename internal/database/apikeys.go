@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+
+	"zipperfly/internal/models"
+)
+
+// The SQL and Redis stores implement auth.KeyStore, so API_KEY_STORE=database
+// can keep API keys next to the records. A SQL key table holds:
+//
+//	key_hash    CHAR(64) PRIMARY KEY  -- hex SHA-256 of the key
+//	name        VARCHAR(255) NOT NULL
+//	rate_limit  FLOAT                 -- requests/second; NULL or 0 = API_KEY_RATE_LIMIT, negative = unlimited
+//
+// Redis keeps each key in a hash at KEY_PREFIX + "apikeys:" + key_hash,
+// with name and rate_limit fields.
+
+// sqlAPIKeys implements auth.KeyStore on a SQL table. Stores embed it so
+// its method becomes theirs.
+type sqlAPIKeys struct {
+	queryRow func(ctx context.Context, query string, args ...interface{}) rowScanner
+	query    string
+	timeout  time.Duration
+}
+
+// newSQLAPIKeys looks keys up in table through queryRow, with bind
+// parameters in format (see placeholder)
+func newSQLAPIKeys(queryRow func(ctx context.Context, query string, args ...interface{}) rowScanner, table, format string, timeout time.Duration) *sqlAPIKeys {
+	return &sqlAPIKeys{
+		queryRow: queryRow,
+		query:    fmt.Sprintf("SELECT name, rate_limit FROM %s WHERE key_hash = %s", table, placeholder(format, 1)),
+		timeout:  timeout,
+	}
+}
+
+// LookupAPIKey returns the key whose hex SHA-256 is hash, or nil if there is none
+func (k *sqlAPIKeys) LookupAPIKey(ctx context.Context, hash string) (*models.APIKey, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, k.timeout)
+	defer cancel()
+
+	var name string
+	var rateLimit sql.NullFloat64
+	err := k.queryRow(queryCtx, k.query, hash).Scan(&name, &rateLimit)
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("api key lookup error: %w", err)
+	}
+	return &models.APIKey{Name: name, RateLimit: rateLimit.Float64}, nil
+}
+
+// apiKeyKey is the hash holding the API key whose hex SHA-256 is hash
+func (s *RedisStore) apiKeyKey(hash string) string {
+	return s.keyPrefix + "apikeys:" + hash
+}
+
+// LookupAPIKey returns the key whose hex SHA-256 is hash, or nil if there is none
+func (s *RedisStore) LookupAPIKey(ctx context.Context, hash string) (*models.APIKey, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	fields, err := s.client.HMGet(queryCtx, s.apiKeyKey(hash), "name", "rate_limit").Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("api key lookup error: %w", err)
+	}
+	name, _ := fields[0].(string)
+	if name == "" {
+		return nil, nil
+	}
+
+	apiKey := &models.APIKey{Name: name}
+	if rate, ok := fields[1].(string); ok && rate != "" {
+		if apiKey.RateLimit, err = strconv.ParseFloat(rate, 64); err != nil {
+			return nil, fmt.Errorf("invalid rate_limit for api key %q: %w", name, err)
+		}
+	}
+	return apiKey, nil
+}
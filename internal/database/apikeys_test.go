@@ -0,0 +1,56 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestSQLAPIKeys_LookupAPIKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		row      *fakeRow
+		wantName string
+		wantRate float64
+		wantNil  bool
+		wantErr  bool
+	}{
+		{name: "found", row: &fakeRow{values: []interface{}{"billing", 25.0}}, wantName: "billing", wantRate: 25},
+		{name: "no rate", row: &fakeRow{values: []interface{}{"reports", nil}}, wantName: "reports"},
+		{name: "missing", row: &fakeRow{err: sql.ErrNoRows}, wantNil: true},
+		{name: "missing in postgres", row: &fakeRow{err: pgx.ErrNoRows}, wantNil: true},
+		{name: "query error", row: &fakeRow{err: errors.New("connection reset")}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotQuery string
+			var gotArgs []interface{}
+			keys := newSQLAPIKeys(func(ctx context.Context, query string, args ...interface{}) rowScanner {
+				gotQuery, gotArgs = query, args
+				return tt.row
+			}, "api_keys", "$%d", time.Second)
+
+			apiKey, err := keys.LookupAPIKey(context.Background(), "abc123")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LookupAPIKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gotQuery != "SELECT name, rate_limit FROM api_keys WHERE key_hash = $1" || len(gotArgs) != 1 || gotArgs[0] != "abc123" {
+				t.Errorf("query = %q %v", gotQuery, gotArgs)
+			}
+			if err != nil {
+				return
+			}
+			if (apiKey == nil) != tt.wantNil {
+				t.Fatalf("LookupAPIKey() = %+v, want nil %v", apiKey, tt.wantNil)
+			}
+			if apiKey != nil && (apiKey.Name != tt.wantName || apiKey.RateLimit != tt.wantRate) {
+				t.Errorf("LookupAPIKey() = %+v, want %s at %g", apiKey, tt.wantName, tt.wantRate)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+
+	"zipperfly/internal/models"
+)
+
+// The SQL and Redis stores implement callbacks.Queue, so CALLBACK_QUEUE=database
+// can keep pending callbacks next to the records. A SQL queue table holds:
+//
+//	id            VARCHAR(64) PRIMARY KEY
+//	next_attempt  BIGINT NOT NULL  -- unix milliseconds
+//	job           TEXT NOT NULL    -- models.CallbackJob as JSON
+
+// callbackQueries are the callback queue statements for one SQL dialect
+type callbackQueries struct {
+	insert     string
+	due        string // binds the limit first when limitFirst is set, else last
+	limitFirst bool
+	claim      string // binds the new next attempt, ID, and the old next attempt
+	reschedule string // binds the next attempt, job, and ID
+	delete     string
+}
+
+// newCallbackQueries builds the queue statements for table, with bind
+// parameters in format (see placeholder). SQL Server takes TOP instead of LIMIT.
+func newCallbackQueries(table, format string, top bool) callbackQueries {
+	p := func(n int) string { return placeholder(format, n) }
+	q := callbackQueries{
+		insert:     fmt.Sprintf("INSERT INTO %s (id, next_attempt, job) VALUES (%s, %s, %s)", table, p(1), p(2), p(3)),
+		due:        fmt.Sprintf("SELECT id, next_attempt, job FROM %s WHERE next_attempt <= %s ORDER BY next_attempt LIMIT %s", table, p(1), p(2)),
+		claim:      fmt.Sprintf("UPDATE %s SET next_attempt = %s WHERE id = %s AND next_attempt = %s", table, p(1), p(2), p(3)),
+		reschedule: fmt.Sprintf("UPDATE %s SET next_attempt = %s, job = %s WHERE id = %s", table, p(1), p(2), p(3)),
+		delete:     fmt.Sprintf("DELETE FROM %s WHERE id = %s", table, p(1)),
+	}
+	if top {
+		q.due = fmt.Sprintf("SELECT TOP (%s) id, next_attempt, job FROM %s WHERE next_attempt <= %s ORDER BY next_attempt", p(1), table, p(2))
+		q.limitFirst = true
+	}
+	return q
+}
+
+// callbackRow is one queued job as read from the table
+type callbackRow struct {
+	id          string
+	nextAttempt int64
+	job         string
+}
+
+// callbackConn runs queue statements on a database/sql or pgx connection
+type callbackConn interface {
+	queryCallbacks(ctx context.Context, query string, args ...interface{}) ([]callbackRow, error)
+	exec(ctx context.Context, query string, args ...interface{}) (int64, error)
+}
+
+// sqlCallbackQueue implements callbacks.Queue on a SQL table. Stores embed
+// it so its methods become theirs.
+type sqlCallbackQueue struct {
+	conn    callbackConn
+	queries callbackQueries
+	timeout time.Duration
+}
+
+// EnqueueCallback stores a job for delivery at job.NextAttempt
+func (q *sqlCallbackQueue) EnqueueCallback(ctx context.Context, job *models.CallbackJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+
+	_, err = q.conn.exec(queryCtx, q.queries.insert, job.ID, job.NextAttempt.UnixMilli(), string(data))
+	return err
+}
+
+// ClaimCallbacks returns up to limit due jobs, moving them to now+lease. Each
+// job is claimed with a conditional update, so a job another instance
+// claimed in the meantime is skipped.
+func (q *sqlCallbackQueue) ClaimCallbacks(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.CallbackJob, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+
+	args := []interface{}{now.UnixMilli(), limit}
+	if q.queries.limitFirst {
+		args[0], args[1] = args[1], args[0]
+	}
+	rows, err := q.conn.queryCallbacks(queryCtx, q.queries.due, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	leased := now.Add(lease)
+	var jobs []*models.CallbackJob
+	for _, row := range rows {
+		claimed, err := q.conn.exec(queryCtx, q.queries.claim, leased.UnixMilli(), row.id, row.nextAttempt)
+		if err != nil {
+			return jobs, err
+		}
+		if claimed == 0 {
+			continue
+		}
+
+		var job models.CallbackJob
+		if err := json.Unmarshal([]byte(row.job), &job); err != nil {
+			// Undeliverable; drop it rather than claim it forever
+			q.conn.exec(queryCtx, q.queries.delete, row.id)
+			continue
+		}
+		job.ID, job.NextAttempt = row.id, leased
+		jobs = append(jobs, &job)
+	}
+	return jobs, nil
+}
+
+// RescheduleCallback saves a failed job's attempts, error, and next attempt
+func (q *sqlCallbackQueue) RescheduleCallback(ctx context.Context, job *models.CallbackJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+
+	_, err = q.conn.exec(queryCtx, q.queries.reschedule, job.NextAttempt.UnixMilli(), string(data), job.ID)
+	return err
+}
+
+// DeleteCallback removes a delivered or abandoned job
+func (q *sqlCallbackQueue) DeleteCallback(ctx context.Context, id string) error {
+	queryCtx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+
+	_, err := q.conn.exec(queryCtx, q.queries.delete, id)
+	return err
+}
+
+// sqlCallbackConn runs queue statements through database/sql (MySQL, SQL Server)
+type sqlCallbackConn struct {
+	db *sql.DB
+}
+
+func (c sqlCallbackConn) queryCallbacks(ctx context.Context, query string, args ...interface{}) ([]callbackRow, error) {
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []callbackRow
+	for rows.Next() {
+		var row callbackRow
+		if err := rows.Scan(&row.id, &row.nextAttempt, &row.job); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+func (c sqlCallbackConn) exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	result, err := c.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// pgxCallbackConn runs queue statements through a pgx pool (Postgres)
+type pgxCallbackConn struct {
+	pool *pgxpool.Pool
+}
+
+func (c pgxCallbackConn) queryCallbacks(ctx context.Context, query string, args ...interface{}) ([]callbackRow, error) {
+	rows, err := c.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []callbackRow
+	for rows.Next() {
+		var row callbackRow
+		if err := rows.Scan(&row.id, &row.nextAttempt, &row.job); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+func (c pgxCallbackConn) exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	tag, err := c.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// claimCallbacksScript moves up to ARGV[3] jobs due at ARGV[1] to ARGV[2] and
+// returns them as ID, job pairs, atomically so concurrent instances never
+// claim the same job
+var claimCallbacksScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+local result = {}
+for _, id in ipairs(ids) do
+	redis.call('ZADD', KEYS[1], ARGV[2], id)
+	table.insert(result, id)
+	table.insert(result, redis.call('HGET', KEYS[2], id) or '')
+end
+return result
+`)
+
+// callbackScheduleKey is the sorted set of queued job IDs by next attempt
+func (s *RedisStore) callbackScheduleKey() string {
+	return s.keyPrefix + "callbacks:schedule"
+}
+
+// callbackJobsKey is the hash of queued job ID -> job JSON
+func (s *RedisStore) callbackJobsKey() string {
+	return s.keyPrefix + "callbacks:jobs"
+}
+
+// EnqueueCallback stores a job for delivery at job.NextAttempt
+func (s *RedisStore) EnqueueCallback(ctx context.Context, job *models.CallbackJob) error {
+	return s.saveCallback(ctx, job)
+}
+
+// ClaimCallbacks returns up to limit due jobs, moving them to now+lease
+func (s *RedisStore) ClaimCallbacks(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.CallbackJob, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	leased := now.Add(lease)
+	values, err := claimCallbacksScript.Run(queryCtx, s.client,
+		[]string{s.callbackScheduleKey(), s.callbackJobsKey()},
+		now.UnixMilli(), leased.UnixMilli(), limit,
+	).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*models.CallbackJob
+	for i := 0; i+1 < len(values); i += 2 {
+		var job models.CallbackJob
+		if err := json.Unmarshal([]byte(values[i+1]), &job); err != nil {
+			// Undeliverable; drop it rather than claim it forever
+			s.DeleteCallback(ctx, values[i])
+			continue
+		}
+		job.ID, job.NextAttempt = values[i], leased
+		jobs = append(jobs, &job)
+	}
+	return jobs, nil
+}
+
+// RescheduleCallback saves a failed job's attempts, error, and next attempt
+func (s *RedisStore) RescheduleCallback(ctx context.Context, job *models.CallbackJob) error {
+	return s.saveCallback(ctx, job)
+}
+
+// DeleteCallback removes a delivered or abandoned job
+func (s *RedisStore) DeleteCallback(ctx context.Context, id string) error {
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	pipe := s.client.TxPipeline()
+	pipe.ZRem(queryCtx, s.callbackScheduleKey(), id)
+	pipe.HDel(queryCtx, s.callbackJobsKey(), id)
+	_, err := pipe.Exec(queryCtx)
+	return err
+}
+
+// saveCallback writes a job and its next attempt in one transaction
+func (s *RedisStore) saveCallback(ctx context.Context, job *models.CallbackJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	pipe := s.client.TxPipeline()
+	pipe.HSet(queryCtx, s.callbackJobsKey(), job.ID, data)
+	pipe.ZAdd(queryCtx, s.callbackScheduleKey(), redis.Z{Score: float64(job.NextAttempt.UnixMilli()), Member: job.ID})
+	_, err = pipe.Exec(queryCtx)
+	return err
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"zipperfly/internal/models"
+)
+
+func TestNewCallbackQueries(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		top     bool
+		wantDue string
+	}{
+		{name: "postgres", format: "$%d", wantDue: "SELECT id, next_attempt, job FROM callback_queue WHERE next_attempt <= $1 ORDER BY next_attempt LIMIT $2"},
+		{name: "mysql", format: "?", wantDue: "SELECT id, next_attempt, job FROM callback_queue WHERE next_attempt <= ? ORDER BY next_attempt LIMIT ?"},
+		{name: "sqlserver", format: "@p%d", top: true, wantDue: "SELECT TOP (@p1) id, next_attempt, job FROM callback_queue WHERE next_attempt <= @p2 ORDER BY next_attempt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newCallbackQueries("callback_queue", tt.format, tt.top)
+			if q.due != tt.wantDue {
+				t.Errorf("due = %q, want %q", q.due, tt.wantDue)
+			}
+			if q.limitFirst != tt.top {
+				t.Errorf("limitFirst = %v, want %v", q.limitFirst, tt.top)
+			}
+		})
+	}
+}
+
+// fakeCallbackConn serves due rows and records executed statements; claims
+// of the IDs in taken affect no rows, as if another instance got there first
+type fakeCallbackConn struct {
+	rows  []callbackRow
+	taken map[string]bool
+	execs [][]interface{}
+}
+
+func (c *fakeCallbackConn) queryCallbacks(ctx context.Context, query string, args ...interface{}) ([]callbackRow, error) {
+	return c.rows, nil
+}
+
+func (c *fakeCallbackConn) exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	c.execs = append(c.execs, args)
+	if len(args) == 3 && c.taken[args[1].(string)] {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+func TestSQLCallbackQueue_ClaimCallbacks(t *testing.T) {
+	conn := &fakeCallbackConn{
+		rows: []callbackRow{
+			{id: "a", nextAttempt: 100, job: `{"callback":"https://example.com/a","attempts":2}`},
+			{id: "b", nextAttempt: 200, job: `{"callback":"https://example.com/b"}`},
+			{id: "c", nextAttempt: 300, job: `not json`},
+		},
+		taken: map[string]bool{"b": true},
+	}
+	q := &sqlCallbackQueue{conn: conn, queries: newCallbackQueries("callback_queue", "?", false), timeout: time.Second}
+
+	now := time.UnixMilli(1000)
+	jobs, err := q.ClaimCallbacks(context.Background(), now, time.Minute, 10)
+	if err != nil {
+		t.Fatalf("ClaimCallbacks() error = %v", err)
+	}
+	want := models.CallbackJob{ID: "a", Callback: &models.Callback{URL: "https://example.com/a"}, Attempts: 2, NextAttempt: now.Add(time.Minute)}
+	if len(jobs) != 1 || jobs[0].ID != want.ID || jobs[0].Callback.URL != want.Callback.URL || jobs[0].Attempts != want.Attempts || !jobs[0].NextAttempt.Equal(want.NextAttempt) {
+		t.Fatalf("ClaimCallbacks() = %+v, want only %+v", jobs, want)
+	}
+
+	// Claim each row conditionally on its old next attempt, then drop the
+	// row that can't be decoded
+	if len(conn.execs) != 4 {
+		t.Fatalf("executed %d statements, want 3 claims and 1 delete", len(conn.execs))
+	}
+	if args := conn.execs[0]; args[0] != now.Add(time.Minute).UnixMilli() || args[1] != "a" || args[2] != int64(100) {
+		t.Errorf("claim args = %v, want the lease end, ID, and old next attempt", args)
+	}
+	if args := conn.execs[3]; len(args) != 1 || args[0] != "c" {
+		t.Errorf("delete args = %v, want the undecodable row's ID", args)
+	}
+}
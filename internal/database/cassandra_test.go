@@ -0,0 +1,191 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"zipperfly/internal/config"
+	"zipperfly/internal/metrics"
+)
+
+func TestCassandraStore_RecordFromRow(t *testing.T) {
+	s := &CassandraStore{}
+
+	tests := []struct {
+		name        string
+		row         map[string]interface{}
+		wantObjects int
+		wantHeaders int
+		wantExtra   int
+		wantSizes   int
+		wantExts    int
+		wantMax     int
+		wantErr     bool
+	}{
+		{
+			name: "native collections",
+			row: map[string]interface{}{
+				"bucket":           "bucket",
+				"objects":          []string{"a.txt", "b.txt"},
+				"custom_headers":   map[string]string{"Cache-Control": "no-store"},
+				"extra_files":      map[string]string{"LICENSE.txt": "MIT"},
+				"object_sizes":     map[string]int64{"a.txt": 5, "b.txt": 7},
+				"store_extensions": []string{".jpg", ".mp4"},
+				"max_downloads":    3,
+			},
+			wantObjects: 2,
+			wantHeaders: 1,
+			wantExtra:   1,
+			wantSizes:   2,
+			wantExts:    2,
+			wantMax:     3,
+		},
+		{
+			name: "json text columns",
+			row: map[string]interface{}{
+				"bucket":           "bucket",
+				"objects":          `["a.txt"]`,
+				"custom_headers":   `{"X-One": "1", "X-Two": "2"}`,
+				"extra_files":      `{"README.txt": "hi"}`,
+				"object_sizes":     `{"a.txt": 5}`,
+				"store_extensions": `[".jpg"]`,
+				"max_downloads":    int64(5),
+			},
+			wantObjects: 1,
+			wantHeaders: 2,
+			wantExtra:   1,
+			wantSizes:   1,
+			wantExts:    1,
+			wantMax:     5,
+		},
+		{
+			name: "null optional columns",
+			row: map[string]interface{}{
+				"bucket":         "bucket",
+				"objects":        []string{"a.txt"},
+				"custom_headers": map[string]string{},
+			},
+			wantObjects: 1,
+		},
+		{
+			name: "invalid objects json",
+			row: map[string]interface{}{
+				"bucket":  "bucket",
+				"objects": `not json`,
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid extra files json",
+			row: map[string]interface{}{
+				"bucket":      "bucket",
+				"objects":     []string{"a.txt"},
+				"extra_files": `nope`,
+			},
+			wantErr: true,
+		},
+		{
+			name: "unsupported objects type",
+			row: map[string]interface{}{
+				"bucket":  "bucket",
+				"objects": 42,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := s.recordFromRow("id-1", tt.row)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("recordFromRow() error = nil, wantErr true")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("recordFromRow() error = %v", err)
+			}
+
+			if record.ID != "id-1" {
+				t.Errorf("record.ID = %q, want id-1", record.ID)
+			}
+			if len(record.Objects) != tt.wantObjects {
+				t.Errorf("len(Objects) = %d, want %d", len(record.Objects), tt.wantObjects)
+			}
+			if len(record.CustomHeaders) != tt.wantHeaders {
+				t.Errorf("len(CustomHeaders) = %d, want %d", len(record.CustomHeaders), tt.wantHeaders)
+			}
+			if len(record.ExtraFiles) != tt.wantExtra {
+				t.Errorf("len(ExtraFiles) = %d, want %d", len(record.ExtraFiles), tt.wantExtra)
+			}
+			if len(record.ObjectSizes) != tt.wantSizes {
+				t.Errorf("len(ObjectSizes) = %d, want %d", len(record.ObjectSizes), tt.wantSizes)
+			}
+			if len(record.StoreExtensions) != tt.wantExts {
+				t.Errorf("len(StoreExtensions) = %d, want %d", len(record.StoreExtensions), tt.wantExts)
+			}
+			if record.MaxDownloads != tt.wantMax {
+				t.Errorf("MaxDownloads = %d, want %d", record.MaxDownloads, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestCassandraStore_GetRecord(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping cassandra test in short mode")
+	}
+
+	m := metrics.New()
+	cfg := &config.Config{
+		DBURL:                "cassandra://localhost:9042/zipperfly_test",
+		TableName:            "downloads",
+		IDField:              "id",
+		CassandraConsistency: "ONE",
+		DatabaseQueryTimeout: 2 * time.Second,
+	}
+
+	store, err := NewCassandraStore(cfg, m)
+	if err != nil {
+		t.Skipf("Cassandra not available: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+
+	if err := store.HealthCheck(ctx); err != nil {
+		t.Errorf("HealthCheck() error = %v", err)
+	}
+
+	if _, err := store.GetRecord(ctx, "does-not-exist"); err == nil {
+		t.Error("GetRecord() error = nil for nonexistent record")
+	}
+}
+
+func TestNewCassandraStore_InvalidConfig(t *testing.T) {
+	m := metrics.New()
+
+	tests := []struct {
+		name string
+		cfg  *config.Config
+	}{
+		{
+			name: "missing keyspace",
+			cfg:  &config.Config{DBURL: "cassandra://localhost:9042", CassandraConsistency: "ONE"},
+		},
+		{
+			name: "invalid consistency",
+			cfg:  &config.Config{DBURL: "cassandra://localhost:9042/ks", CassandraConsistency: "MOSTLY"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCassandraStore(tt.cfg, m); err == nil {
+				t.Error("NewCassandraStore() error = nil, want error")
+			}
+		})
+	}
+}
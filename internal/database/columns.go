@@ -0,0 +1,411 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"zipperfly/internal/models"
+)
+
+// optionalColumns lists the optional record columns, in query order
+var optionalColumns = []string{
+	"name",
+	"callback",
+	"password",
+	"custom_headers",
+	"download_count",
+	"max_downloads",
+	"store_only",
+	"compression_level",
+	"encryption",
+	"manifest",
+	"extra_files",
+	"object_sizes",
+	"format",
+	"store_extensions",
+	"object_metadata",
+	"directories",
+	"raw",
+	"allowed_ips",
+	"bound_user_id",
+	"single_use",
+	"password_hash",
+	"password_required",
+	"tenant",
+}
+
+// rowScanner is satisfied by *sql.Row, *sql.Rows, and pgx rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// trackColumns validates required columns and records which optional ones exist
+func trackColumns(columns map[string]bool, available map[string]bool, idField, tableName string) error {
+	// Check for required columns
+	if !columns[idField] {
+		return fmt.Errorf("required column %q not found in table %q", idField, tableName)
+	}
+	if !columns["bucket"] {
+		return fmt.Errorf("required column 'bucket' not found in table %q", tableName)
+	}
+	if !columns["objects"] {
+		return fmt.Errorf("required column 'objects' not found in table %q", tableName)
+	}
+
+	// Track optional columns
+	for _, col := range optionalColumns {
+		available[col] = columns[col]
+	}
+
+	return nil
+}
+
+// recordColumns returns the SELECT column list for the available columns
+func recordColumns(available map[string]bool) []string {
+	selectCols := []string{"bucket", "objects"}
+	for _, col := range optionalColumns {
+		if available[col] {
+			selectCols = append(selectCols, col)
+		}
+	}
+	return selectCols
+}
+
+// recordValues returns the columns and bind values for writing a record,
+// excluding the ID and download_count. Empty optional fields are written as NULL.
+// It fails if the record sets a field the table has no column for, rather than
+// silently dropping it (e.g. a password).
+func recordValues(record *models.DownloadRecord, available map[string]bool) ([]string, []interface{}, error) {
+	objectsJSON, err := json.Marshal(record.Objects)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	customHeaders, err := jsonMap(record.CustomHeaders)
+	if err != nil {
+		return nil, nil, err
+	}
+	extraFiles, err := jsonMap(record.ExtraFiles)
+	if err != nil {
+		return nil, nil, err
+	}
+	objectSizes, err := jsonMap(record.ObjectSizes)
+	if err != nil {
+		return nil, nil, err
+	}
+	storeExtensions, err := jsonList(record.StoreExtensions)
+	if err != nil {
+		return nil, nil, err
+	}
+	objectMetadata, err := jsonMap(record.ObjectMetadata)
+	if err != nil {
+		return nil, nil, err
+	}
+	directories, err := jsonList(record.Directories)
+	if err != nil {
+		return nil, nil, err
+	}
+	allowedIPs, err := jsonList(record.AllowedIPs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	optional := map[string]interface{}{
+		"name":              nullString(record.Name),
+		"callback":          nullString(record.Callbacks.Encode()),
+		"password":          nullString(record.Password),
+		"custom_headers":    customHeaders,
+		"max_downloads":     nil,
+		"store_only":        nil,
+		"compression_level": nil,
+		"encryption":        nullString(record.Encryption),
+		"manifest":          nil,
+		"extra_files":       extraFiles,
+		"object_sizes":      objectSizes,
+		"format":            nullString(record.Format),
+		"store_extensions":  storeExtensions,
+		"object_metadata":   objectMetadata,
+		"directories":       directories,
+		"raw":               nil,
+		"allowed_ips":       allowedIPs,
+		"bound_user_id":     nullString(record.BoundUserID),
+		"single_use":        nil,
+		"password_hash":     nullString(record.PasswordHash),
+		"password_required": nil,
+		"tenant":            nullString(record.Tenant),
+	}
+	if record.MaxDownloads > 0 {
+		optional["max_downloads"] = record.MaxDownloads
+	}
+	if record.StoreOnly {
+		optional["store_only"] = true
+	}
+	if record.CompressionLevel != 0 {
+		optional["compression_level"] = record.CompressionLevel
+	}
+	if record.Manifest {
+		optional["manifest"] = true
+	}
+	if record.Raw {
+		optional["raw"] = true
+	}
+	if record.SingleUse {
+		optional["single_use"] = true
+	}
+	if record.PasswordRequired {
+		optional["password_required"] = true
+	}
+
+	cols := []string{"bucket", "objects"}
+	values := []interface{}{record.Bucket, string(objectsJSON)}
+	for _, col := range optionalColumns {
+		value, writable := optional[col]
+		if !writable {
+			continue
+		}
+		if !available[col] {
+			if value != nil {
+				return nil, nil, fmt.Errorf("record sets %q but the table has no such column", col)
+			}
+			continue
+		}
+		cols = append(cols, col)
+		values = append(values, value)
+	}
+
+	return cols, values, nil
+}
+
+// jsonMap encodes a map as JSON text, mapping empty maps to NULL
+func jsonMap[V any](m map[string]V) (interface{}, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// jsonList encodes a list as JSON text, mapping empty lists to NULL
+func jsonList(l []string) (interface{}, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// nullString maps empty strings to NULL
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// placeholder returns the i-th (1-based) bind parameter for format,
+// numbered when format contains a verb (e.g. "$%d", "@p%d") or as-is ("?")
+func placeholder(format string, i int) string {
+	if strings.Contains(format, "%") {
+		return fmt.Sprintf(format, i)
+	}
+	return format
+}
+
+// placeholders returns n comma-separated bind parameters, numbered from 1
+func placeholders(format string, n int) string {
+	params := make([]string, n)
+	for i := range params {
+		params[i] = placeholder(format, i+1)
+	}
+	return strings.Join(params, ", ")
+}
+
+// maxBatchIDs is the most IDs a batch lookup binds in one query, keeping
+// GetRecords under SQL Server's limit of 2100 parameters
+const maxBatchIDs = 1000
+
+// selectRecordsQuery builds a SELECT of the ID and the record columns for n
+// IDs, binding the IDs
+func selectRecordsQuery(tableName, idField string, available map[string]bool, format string, n int) string {
+	return fmt.Sprintf(
+		"SELECT %s, %s FROM %s WHERE %s IN (%s)",
+		idField,
+		strings.Join(recordColumns(available), ", "),
+		tableName,
+		idField,
+		placeholders(format, n),
+	)
+}
+
+// insertQuery builds an INSERT for the ID plus cols, binding the ID first
+func insertQuery(tableName, idField string, cols []string, format string) string {
+	return fmt.Sprintf(
+		"INSERT INTO %s (%s, %s) VALUES (%s)",
+		tableName,
+		idField,
+		strings.Join(cols, ", "),
+		placeholders(format, len(cols)+1),
+	)
+}
+
+// updateQuery builds an UPDATE of cols, binding the ID last
+func updateQuery(tableName, idField string, cols []string, format string) string {
+	sets := make([]string, len(cols))
+	for i, col := range cols {
+		sets[i] = fmt.Sprintf("%s = %s", col, placeholder(format, i+1))
+	}
+	return fmt.Sprintf(
+		"UPDATE %s SET %s WHERE %s = %s",
+		tableName,
+		strings.Join(sets, ", "),
+		idField,
+		placeholder(format, len(cols)+1),
+	)
+}
+
+// claimDownloadQuery builds an UPDATE that increments download_count only
+// while it is below a limit, binding the ID then the limit
+func claimDownloadQuery(tableName, idField, format string) string {
+	return fmt.Sprintf(
+		"UPDATE %s SET download_count = COALESCE(download_count, 0) + 1 WHERE %s = %s AND COALESCE(download_count, 0) < %s",
+		tableName,
+		idField,
+		placeholder(format, 1),
+		placeholder(format, 2),
+	)
+}
+
+// releaseDownloadQuery builds an UPDATE that decrements a positive
+// download_count, binding the ID
+func releaseDownloadQuery(tableName, idField, format string) string {
+	return fmt.Sprintf(
+		"UPDATE %s SET download_count = download_count - 1 WHERE %s = %s AND download_count > 0",
+		tableName,
+		idField,
+		placeholder(format, 1),
+	)
+}
+
+// scanRecord scans a row selected with recordColumns into a record.
+// Any prefix destinations are scanned first (e.g. the ID for batch queries).
+func scanRecord(row rowScanner, available map[string]bool, prefix ...interface{}) (*models.DownloadRecord, error) {
+	var record models.DownloadRecord
+	var objectsJSON []byte
+
+	// Prepare scan destinations based on available columns
+	scanDests := append(prefix, &record.Bucket, &objectsJSON)
+
+	var nameVal, callbackVal, passwordVal, customHeadersJSON, encryptionVal, extraFilesJSON, objectSizesJSON, formatVal, storeExtensionsJSON, objectMetadataJSON, directoriesJSON sql.NullString
+	var downloadCountVal, maxDownloadsVal, compressionLevelVal sql.NullInt64
+	var boundUserIDVal, allowedIPsJSON, passwordHashVal, tenantVal sql.NullString
+	var storeOnlyVal, manifestVal, rawVal, singleUseVal, passwordRequiredVal sql.NullBool
+	optionalDests := map[string]interface{}{
+		"name":              &nameVal,
+		"callback":          &callbackVal,
+		"password":          &passwordVal,
+		"custom_headers":    &customHeadersJSON,
+		"download_count":    &downloadCountVal,
+		"max_downloads":     &maxDownloadsVal,
+		"store_only":        &storeOnlyVal,
+		"compression_level": &compressionLevelVal,
+		"encryption":        &encryptionVal,
+		"manifest":          &manifestVal,
+		"extra_files":       &extraFilesJSON,
+		"object_sizes":      &objectSizesJSON,
+		"format":            &formatVal,
+		"store_extensions":  &storeExtensionsJSON,
+		"object_metadata":   &objectMetadataJSON,
+		"directories":       &directoriesJSON,
+		"raw":               &rawVal,
+		"allowed_ips":       &allowedIPsJSON,
+		"bound_user_id":     &boundUserIDVal,
+		"single_use":        &singleUseVal,
+		"password_hash":     &passwordHashVal,
+		"password_required": &passwordRequiredVal,
+		"tenant":            &tenantVal,
+	}
+	for _, col := range optionalColumns {
+		if available[col] {
+			scanDests = append(scanDests, optionalDests[col])
+		}
+	}
+
+	if err := row.Scan(scanDests...); err != nil {
+		return nil, err
+	}
+
+	// Parse required fields
+	if err := json.Unmarshal(objectsJSON, &record.Objects); err != nil {
+		return nil, err
+	}
+
+	// Parse optional fields (NULL and missing columns leave zero values)
+	record.Name = nameVal.String
+	callbacks, err := models.ParseCallbacks(callbackVal.String)
+	if err != nil {
+		return nil, err
+	}
+	record.Callbacks = callbacks
+	record.Password = passwordVal.String
+
+	if customHeadersJSON.Valid && customHeadersJSON.String != "" {
+		if err := json.Unmarshal([]byte(customHeadersJSON.String), &record.CustomHeaders); err != nil {
+			return nil, err
+		}
+	}
+
+	record.DownloadCount = int(downloadCountVal.Int64)
+	record.MaxDownloads = int(maxDownloadsVal.Int64)
+	record.StoreOnly = storeOnlyVal.Bool
+	record.CompressionLevel = int(compressionLevelVal.Int64)
+	record.Encryption = encryptionVal.String
+	record.Manifest = manifestVal.Bool
+
+	if extraFilesJSON.Valid && extraFilesJSON.String != "" {
+		if err := json.Unmarshal([]byte(extraFilesJSON.String), &record.ExtraFiles); err != nil {
+			return nil, err
+		}
+	}
+	if objectSizesJSON.Valid && objectSizesJSON.String != "" {
+		if err := json.Unmarshal([]byte(objectSizesJSON.String), &record.ObjectSizes); err != nil {
+			return nil, err
+		}
+	}
+	record.Format = formatVal.String
+	if storeExtensionsJSON.Valid && storeExtensionsJSON.String != "" {
+		if err := json.Unmarshal([]byte(storeExtensionsJSON.String), &record.StoreExtensions); err != nil {
+			return nil, err
+		}
+	}
+	if objectMetadataJSON.Valid && objectMetadataJSON.String != "" {
+		if err := json.Unmarshal([]byte(objectMetadataJSON.String), &record.ObjectMetadata); err != nil {
+			return nil, err
+		}
+	}
+	if directoriesJSON.Valid && directoriesJSON.String != "" {
+		if err := json.Unmarshal([]byte(directoriesJSON.String), &record.Directories); err != nil {
+			return nil, err
+		}
+	}
+	record.Raw = rawVal.Bool
+	if allowedIPsJSON.Valid && allowedIPsJSON.String != "" {
+		if err := json.Unmarshal([]byte(allowedIPsJSON.String), &record.AllowedIPs); err != nil {
+			return nil, err
+		}
+	}
+	record.BoundUserID = boundUserIDVal.String
+	record.SingleUse = singleUseVal.Bool
+	record.PasswordHash = passwordHashVal.String
+	record.PasswordRequired = passwordRequiredVal.Bool
+	record.Tenant = tenantVal.String
+
+	return &record, nil
+}
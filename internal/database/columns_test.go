@@ -0,0 +1,256 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"zipperfly/internal/models"
+)
+
+// fakeRow fills scan destinations from a fixed list of values
+type fakeRow struct {
+	values []interface{}
+	err    error
+}
+
+func (r *fakeRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(dest) != len(r.values) {
+		return errors.New("column count mismatch")
+	}
+	for i, d := range dest {
+		switch d := d.(type) {
+		case *string:
+			*d = r.values[i].(string)
+		case *[]byte:
+			*d = []byte(r.values[i].(string))
+		case *sql.NullString:
+			if err := d.Scan(r.values[i]); err != nil {
+				return err
+			}
+		case *sql.NullInt64:
+			if err := d.Scan(r.values[i]); err != nil {
+				return err
+			}
+		case *sql.NullBool:
+			if err := d.Scan(r.values[i]); err != nil {
+				return err
+			}
+		case *sql.NullFloat64:
+			if err := d.Scan(r.values[i]); err != nil {
+				return err
+			}
+		default:
+			return errors.New("unsupported destination")
+		}
+	}
+	return nil
+}
+
+func TestRecordColumns(t *testing.T) {
+	got := recordColumns(map[string]bool{"name": true, "max_downloads": true, "password": false})
+	want := "bucket, objects, name, max_downloads"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("recordColumns() = %v, want %s", got, want)
+	}
+}
+
+func TestColumns(t *testing.T) {
+	available := map[string]bool{"name": true, "tenant": true}
+	for _, store := range []ColumnLister{
+		&PostgresStore{idField: "uuid", availableColumns: available},
+		&MySQLStore{idField: "uuid", availableColumns: available},
+		&MSSQLStore{idField: "uuid", availableColumns: available},
+		&CassandraStore{idField: "uuid", availableColumns: available},
+	} {
+		want := "uuid, bucket, objects, name, tenant"
+		if got := strings.Join(store.Columns(), ", "); got != want {
+			t.Errorf("%T.Columns() = %s, want %s", store, got, want)
+		}
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	tests := []struct {
+		format string
+		n      int
+		want   string
+	}{
+		{format: "$%d", n: 3, want: "$1, $2, $3"},
+		{format: "?", n: 2, want: "?, ?"},
+		{format: "@p%d", n: 1, want: "@p1"},
+	}
+
+	for _, tt := range tests {
+		if got := placeholders(tt.format, tt.n); got != tt.want {
+			t.Errorf("placeholders(%q, %d) = %q, want %q", tt.format, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestSelectRecordsQuery(t *testing.T) {
+	got := selectRecordsQuery("downloads", "id", map[string]bool{"name": true}, "@p%d", 2)
+	if want := "SELECT id, bucket, objects, name FROM downloads WHERE id IN (@p1, @p2)"; got != want {
+		t.Errorf("selectRecordsQuery() = %q, want %q", got, want)
+	}
+	// SQL Server allows 2100 parameters per query
+	if maxBatchIDs > 2100 {
+		t.Errorf("maxBatchIDs = %d, over SQL Server's parameter limit", maxBatchIDs)
+	}
+}
+
+func TestTrackColumns(t *testing.T) {
+	available := make(map[string]bool)
+	columns := map[string]bool{"id": true, "bucket": true, "objects": true, "callback": true}
+
+	if err := trackColumns(columns, available, "id", "downloads"); err != nil {
+		t.Fatalf("trackColumns() error = %v", err)
+	}
+	if !available["callback"] || available["name"] {
+		t.Errorf("unexpected available columns: %v", available)
+	}
+
+	delete(columns, "objects")
+	if err := trackColumns(columns, available, "id", "downloads"); err == nil {
+		t.Error("expected error when required column is missing")
+	}
+}
+
+func TestScanRecord(t *testing.T) {
+	available := map[string]bool{"name": true, "custom_headers": true, "max_downloads": true, "store_only": true, "compression_level": true, "encryption": true, "manifest": true, "extra_files": true, "object_sizes": true, "format": true, "store_extensions": true, "object_metadata": true, "directories": true}
+
+	t.Run("all columns", func(t *testing.T) {
+		row := &fakeRow{values: []interface{}{
+			"id-1", "bucket", `["a.txt","b.txt"]`, "archive", `{"X-Test":"1"}`, int64(2), true, int64(9), "aes256", true, `{"LICENSE.txt":"MIT"}`, `{"a.txt":5}`, "tar.zst", `[".jpg"]`, `{"a.txt":{"mode":"0755"}}`, `["empty/"]`,
+		}}
+
+		var id string
+		record, err := scanRecord(row, available, &id)
+		if err != nil {
+			t.Fatalf("scanRecord() error = %v", err)
+		}
+		if id != "id-1" {
+			t.Errorf("id = %q, want id-1", id)
+		}
+		if record.Bucket != "bucket" || len(record.Objects) != 2 || record.Name != "archive" {
+			t.Errorf("unexpected record: %+v", record)
+		}
+		if record.CustomHeaders["X-Test"] != "1" {
+			t.Errorf("unexpected custom headers: %v", record.CustomHeaders)
+		}
+		if record.MaxDownloads != 2 {
+			t.Errorf("MaxDownloads = %d, want 2", record.MaxDownloads)
+		}
+		if !record.StoreOnly {
+			t.Error("StoreOnly = false, want true")
+		}
+		if record.CompressionLevel != 9 {
+			t.Errorf("CompressionLevel = %d, want 9", record.CompressionLevel)
+		}
+		if record.Encryption != "aes256" {
+			t.Errorf("Encryption = %q, want aes256", record.Encryption)
+		}
+		if !record.Manifest {
+			t.Error("Manifest = false, want true")
+		}
+		if record.ExtraFiles["LICENSE.txt"] != "MIT" {
+			t.Errorf("unexpected extra files: %v", record.ExtraFiles)
+		}
+		if record.ObjectSizes["a.txt"] != 5 {
+			t.Errorf("unexpected object sizes: %v", record.ObjectSizes)
+		}
+		if record.Format != "tar.zst" || len(record.StoreExtensions) != 1 || record.StoreExtensions[0] != ".jpg" {
+			t.Errorf("Format = %q, StoreExtensions = %v; want tar.zst, [.jpg]", record.Format, record.StoreExtensions)
+		}
+		if record.ObjectMetadata["a.txt"].Mode != "0755" {
+			t.Errorf("unexpected object metadata: %v", record.ObjectMetadata)
+		}
+		if len(record.Directories) != 1 || record.Directories[0] != "empty/" {
+			t.Errorf("unexpected directories: %v", record.Directories)
+		}
+	})
+
+	t.Run("null optional columns", func(t *testing.T) {
+		row := &fakeRow{values: []interface{}{"bucket", `["a.txt"]`, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}}
+
+		record, err := scanRecord(row, available)
+		if err != nil {
+			t.Fatalf("scanRecord() error = %v", err)
+		}
+		if record.Name != "" || record.CustomHeaders != nil || record.MaxDownloads != 0 || record.StoreOnly || record.CompressionLevel != 0 || record.Encryption != "" || record.Manifest || record.ExtraFiles != nil || record.ObjectSizes != nil || record.Format != "" || record.StoreExtensions != nil || record.ObjectMetadata != nil || record.Directories != nil {
+			t.Errorf("expected zero values for NULL columns, got %+v", record)
+		}
+	})
+
+	t.Run("invalid objects json", func(t *testing.T) {
+		row := &fakeRow{values: []interface{}{"bucket", `nope`, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}}
+		if _, err := scanRecord(row, available); err == nil {
+			t.Error("expected error for invalid objects JSON")
+		}
+	})
+
+	t.Run("scan error", func(t *testing.T) {
+		row := &fakeRow{err: sql.ErrNoRows}
+		if _, err := scanRecord(row, available); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("scanRecord() error = %v, want sql.ErrNoRows", err)
+		}
+	})
+}
+
+func TestRecordValues(t *testing.T) {
+	record := &models.DownloadRecord{
+		ID:            "id-1",
+		Bucket:        "bucket",
+		Objects:       []string{"a.txt"},
+		Name:          "archive",
+		CustomHeaders: map[string]string{"X-Test": "1"},
+		MaxDownloads:  3,
+	}
+
+	t.Run("available columns", func(t *testing.T) {
+		available := map[string]bool{"name": true, "password": true, "custom_headers": true, "download_count": true, "max_downloads": true}
+
+		cols, values, err := recordValues(record, available)
+		if err != nil {
+			t.Fatalf("recordValues() error = %v", err)
+		}
+
+		want := "bucket, objects, name, password, custom_headers, max_downloads"
+		if strings.Join(cols, ", ") != want {
+			t.Errorf("cols = %v, want %s", cols, want)
+		}
+		if values[1] != `["a.txt"]` || values[2] != "archive" || values[3] != nil || values[4] != `{"X-Test":"1"}` || values[5] != 3 {
+			t.Errorf("unexpected values: %#v", values)
+		}
+	})
+
+	t.Run("missing column for set field", func(t *testing.T) {
+		if _, _, err := recordValues(record, map[string]bool{"name": true, "custom_headers": true}); err == nil {
+			t.Error("expected error when max_downloads column is missing")
+		}
+	})
+}
+
+func TestWriteQueries(t *testing.T) {
+	cols := []string{"bucket", "objects"}
+
+	if got, want := insertQuery("downloads", "id", cols, "$%d"), "INSERT INTO downloads (id, bucket, objects) VALUES ($1, $2, $3)"; got != want {
+		t.Errorf("insertQuery() = %q, want %q", got, want)
+	}
+	if got, want := updateQuery("downloads", "id", cols, "?"), "UPDATE downloads SET bucket = ?, objects = ? WHERE id = ?"; got != want {
+		t.Errorf("updateQuery() = %q, want %q", got, want)
+	}
+	if got, want := updateQuery("downloads", "id", cols, "@p%d"), "UPDATE downloads SET bucket = @p1, objects = @p2 WHERE id = @p3"; got != want {
+		t.Errorf("updateQuery() = %q, want %q", got, want)
+	}
+	if got, want := claimDownloadQuery("downloads", "id", "$%d"), "UPDATE downloads SET download_count = COALESCE(download_count, 0) + 1 WHERE id = $1 AND COALESCE(download_count, 0) < $2"; got != want {
+		t.Errorf("claimDownloadQuery() = %q, want %q", got, want)
+	}
+	if got, want := releaseDownloadQuery("downloads", "id", "@p%d"), "UPDATE downloads SET download_count = download_count - 1 WHERE id = @p1 AND download_count > 0"; got != want {
+		t.Errorf("releaseDownloadQuery() = %q, want %q", got, want)
+	}
+}
@@ -2,8 +2,16 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 
+	"github.com/gocql/gocql"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+
+	"zipperfly/internal/archive"
 	"zipperfly/internal/config"
 	"zipperfly/internal/metrics"
 	"zipperfly/internal/models"
@@ -12,9 +20,132 @@ import (
 // Store defines the interface for database operations
 type Store interface {
 	GetRecord(ctx context.Context, id string) (*models.DownloadRecord, error)
+	// GetRecords fetches several records at once; missing IDs are omitted
+	GetRecords(ctx context.Context, ids []string) (map[string]*models.DownloadRecord, error)
+	IncrementDownloadCount(ctx context.Context, id string) error
+	// HealthCheck performs a lightweight connectivity check
+	HealthCheck(ctx context.Context) error
 	Close() error
 }
 
+// RecordWriter is implemented by stores that can create and modify records.
+// Not every engine supports writes, so check with a type assertion:
+//
+//	if w, ok := store.(RecordWriter); ok { ... }
+type RecordWriter interface {
+	// CreateRecord inserts a new record; returns ErrRecordExists if the ID is taken
+	CreateRecord(ctx context.Context, record *models.DownloadRecord) error
+	// UpdateRecord replaces an existing record; returns ErrRecordNotFound if missing.
+	// The download count is left untouched.
+	UpdateRecord(ctx context.Context, record *models.DownloadRecord) error
+	// DeleteRecord removes a record; returns ErrRecordNotFound if missing
+	DeleteRecord(ctx context.Context, id string) error
+}
+
+// DownloadClaimer is implemented by stores that can count a download as it
+// starts, atomically, so a single-use record is served only once. Check
+// with a type assertion, as for RecordWriter.
+type DownloadClaimer interface {
+	// ClaimDownload increments the download count if it is below limit,
+	// returning false if it isn't
+	ClaimDownload(ctx context.Context, id string, limit int) (bool, error)
+
+	// ReleaseDownload gives back a claim whose download failed, so the
+	// link stays usable
+	ReleaseDownload(ctx context.Context, id string) error
+}
+
+// ColumnLister is implemented by stores that detect their table's columns
+// at startup. Check with a type assertion, as for RecordWriter.
+type ColumnLister interface {
+	// Columns returns the record columns the table has, required ones first
+	Columns() []string
+}
+
+var (
+	ErrRecordNotFound = errors.New("record not found")
+	ErrRecordExists   = errors.New("record already exists")
+)
+
+// IsNotFound reports whether err from GetRecord means the record doesn't
+// exist, as opposed to the database failing. Each engine reports a missing
+// record its own way.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrRecordNotFound) ||
+		errors.Is(err, sql.ErrNoRows) ||
+		errors.Is(err, pgx.ErrNoRows) ||
+		errors.Is(err, redis.Nil) ||
+		errors.Is(err, gocql.ErrNotFound)
+}
+
+// errNoDownloadCount is returned by ClaimDownload and ReleaseDownload when the table can't count downloads
+var errNoDownloadCount = errors.New("table has no download_count column")
+
+// validateRecord checks the fields every write requires
+func validateRecord(record *models.DownloadRecord) error {
+	if record.ID == "" {
+		return errors.New("record id is required")
+	}
+	if record.Bucket == "" {
+		return errors.New("record bucket is required")
+	}
+	if len(record.Objects) == 0 && len(record.Directories) == 0 {
+		return errors.New("record must reference at least one object or directory")
+	}
+	if record.MaxDownloads < 0 {
+		return errors.New("record max_downloads cannot be negative")
+	}
+	if record.CompressionLevel < 0 || record.CompressionLevel > 9 {
+		return errors.New("record compression_level must be between 0 and 9")
+	}
+	switch record.Encryption {
+	case "", "zipcrypto", "aes256":
+	default:
+		return errors.New(`record encryption must be "zipcrypto" or "aes256"`)
+	}
+	for name := range record.ExtraFiles {
+		if !models.IsPlainFileName(name) {
+			return fmt.Errorf("record extra_files name %q must be a plain file name", name)
+		}
+	}
+	for key, size := range record.ObjectSizes {
+		if size < 0 {
+			return fmt.Errorf("record object_sizes entry %q cannot be negative", key)
+		}
+	}
+	if record.Raw && (len(record.Objects) != 1 || len(record.Directories) > 0) {
+		return errors.New("record raw requires exactly one object")
+	}
+	for _, dir := range record.Directories {
+		if !models.IsDirectoryPath(dir) {
+			return fmt.Errorf("record directories entry %q must be a relative path", dir)
+		}
+	}
+	for key, meta := range record.ObjectMetadata {
+		if _, err := meta.FileMode(); err != nil {
+			return fmt.Errorf("record object_metadata entry %q: %w", key, err)
+		}
+	}
+	if _, err := archive.ParseFormat(record.Format); err != nil {
+		return fmt.Errorf("record format: %w", err)
+	}
+	if err := record.Callbacks.Validate(); err != nil {
+		return fmt.Errorf("record callback: %w", err)
+	}
+	if _, err := record.AllowedNetworks(); err != nil {
+		return fmt.Errorf("record %w", err)
+	}
+	if record.PasswordHash != "" {
+		if _, err := bcrypt.Cost([]byte(record.PasswordHash)); err != nil {
+			return fmt.Errorf("record password_hash must be a bcrypt hash: %w", err)
+		}
+	}
+	if record.Password != "" && (record.PasswordHash != "" || record.PasswordRequired) {
+		return errors.New("record password can't be combined with password_hash or password_required")
+	}
+	return nil
+}
+
 // These indirection variables allow tests to override the concrete
 // store constructors so we can exercise New(...) without real DBs.
 var (
@@ -24,6 +155,12 @@ var (
 	newMySQLStoreFunc = func(cfg *config.Config, m *metrics.Metrics) (Store, error) {
 		return NewMySQLStore(cfg, m)
 	}
+	newMSSQLStoreFunc = func(cfg *config.Config, m *metrics.Metrics) (Store, error) {
+		return NewMSSQLStore(cfg, m)
+	}
+	newCassandraStoreFunc = func(cfg *config.Config, m *metrics.Metrics) (Store, error) {
+		return NewCassandraStore(cfg, m)
+	}
 	newRedisStoreFunc = func(ctx context.Context, cfg *config.Config, m *metrics.Metrics) (Store, error) {
 		return NewRedisStore(ctx, cfg, m)
 	}
@@ -36,6 +173,10 @@ func New(ctx context.Context, cfg *config.Config, m *metrics.Metrics) (Store, er
 		return newPostgresStoreFunc(ctx, cfg, m)
 	case "mysql":
 		return newMySQLStoreFunc(cfg, m)
+	case "sqlserver":
+		return newMSSQLStoreFunc(cfg, m)
+	case "cassandra", "scylla":
+		return newCassandraStoreFunc(cfg, m)
 	case "redis":
 		return newRedisStoreFunc(ctx, cfg, m)
 	default:
@@ -2,9 +2,15 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+
 	"zipperfly/internal/config"
 	"zipperfly/internal/metrics"
 	"zipperfly/internal/models"
@@ -20,6 +26,18 @@ func (f *fakeStore) GetRecord(ctx context.Context, id string) (*models.DownloadR
 	return nil, nil
 }
 
+func (f *fakeStore) GetRecords(ctx context.Context, ids []string) (map[string]*models.DownloadRecord, error) {
+	return nil, nil
+}
+
+func (f *fakeStore) IncrementDownloadCount(ctx context.Context, id string) error {
+	return nil
+}
+
+func (f *fakeStore) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
 func (f *fakeStore) Close() error {
 	return nil
 }
@@ -93,6 +111,70 @@ func TestNew_MySQLDispatch(t *testing.T) {
 	}
 }
 
+func TestNew_MSSQLDispatch(t *testing.T) {
+	ctx := context.Background()
+	m := metrics.New()
+
+	cfg := newTestConfig("sqlserver")
+
+	orig := newMSSQLStoreFunc
+	defer func() { newMSSQLStoreFunc = orig }()
+
+	called := false
+	expected := &fakeStore{name: "sqlserver"}
+
+	newMSSQLStoreFunc = func(cfg *config.Config, m *metrics.Metrics) (Store, error) {
+		called = true
+		return expected, nil
+	}
+
+	store, err := New(ctx, cfg, m)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	if !called {
+		t.Fatalf("expected newMSSQLStoreFunc to be called")
+	}
+
+	if store != expected {
+		t.Fatalf("expected store %v, got %v", expected, store)
+	}
+}
+
+func TestNew_CassandraDispatch(t *testing.T) {
+	ctx := context.Background()
+	m := metrics.New()
+
+	for _, engine := range []string{"cassandra", "scylla"} {
+		cfg := newTestConfig(engine)
+
+		orig := newCassandraStoreFunc
+		called := false
+		expected := &fakeStore{name: engine}
+
+		newCassandraStoreFunc = func(cfg *config.Config, m *metrics.Metrics) (Store, error) {
+			called = true
+			return expected, nil
+		}
+
+		store, err := New(ctx, cfg, m)
+		newCassandraStoreFunc = orig
+
+		if err != nil {
+			t.Fatalf("New(%s) returned error: %v", engine, err)
+		}
+
+		if !called {
+			t.Fatalf("expected newCassandraStoreFunc to be called for %s", engine)
+		}
+
+		if store != expected {
+			t.Fatalf("expected store %v, got %v", expected, store)
+		}
+	}
+}
+
 func TestNew_RedisDispatch(t *testing.T) {
 	ctx := context.Background()
 	m := metrics.New()
@@ -143,3 +225,78 @@ func TestNew_UnsupportedEngine(t *testing.T) {
 		t.Fatalf("unexpected error message: %v", err)
 	}
 }
+
+func TestRecordWriterSupport(t *testing.T) {
+	writers := []Store{&PostgresStore{}, &MySQLStore{}, &MSSQLStore{}, &RedisStore{}}
+	for _, s := range writers {
+		if _, ok := s.(RecordWriter); !ok {
+			t.Errorf("%T does not implement RecordWriter", s)
+		}
+	}
+
+	var readOnly Store = &CassandraStore{}
+	if _, ok := readOnly.(RecordWriter); ok {
+		t.Errorf("%T unexpectedly implements RecordWriter", readOnly)
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{ErrRecordNotFound, true},
+		{sql.ErrNoRows, true},
+		{fmt.Errorf("scan: %w", pgx.ErrNoRows), true},
+		{redis.Nil, true},
+		{errors.New("connection refused"), false},
+		{context.DeadlineExceeded, false},
+	}
+	for _, tt := range tests {
+		if got := IsNotFound(tt.err); got != tt.want {
+			t.Errorf("IsNotFound(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  models.DownloadRecord
+		wantErr bool
+	}{
+		{name: "valid", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}}},
+		{name: "missing id", record: models.DownloadRecord{Bucket: "b", Objects: []string{"c"}}, wantErr: true},
+		{name: "missing bucket", record: models.DownloadRecord{ID: "a", Objects: []string{"c"}}, wantErr: true},
+		{name: "no objects", record: models.DownloadRecord{ID: "a", Bucket: "b"}, wantErr: true},
+		{name: "raw single object", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, Raw: true}},
+		{name: "raw multiple objects", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c", "d"}, Raw: true}, wantErr: true},
+		{name: "directories only", record: models.DownloadRecord{ID: "a", Bucket: "b", Directories: []string{"empty"}}},
+		{name: "directory outside archive", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, Directories: []string{"../up"}}, wantErr: true},
+		{name: "negative max downloads", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, MaxDownloads: -1}, wantErr: true},
+		{name: "compression level too high", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, CompressionLevel: 10}, wantErr: true},
+		{name: "aes256 encryption", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, Encryption: "aes256"}},
+		{name: "unsupported encryption", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, Encryption: "des"}, wantErr: true},
+		{name: "extra file with path", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, ExtraFiles: map[string]string{"../LICENSE": "x"}}, wantErr: true},
+		{name: "object mode", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, ObjectMetadata: map[string]models.ObjectMetadata{"c": {Mode: "0755"}}}},
+		{name: "invalid object mode", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, ObjectMetadata: map[string]models.ObjectMetadata{"c": {Mode: "999"}}}, wantErr: true},
+		{name: "record format", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, Format: "tar.zst"}},
+		{name: "unsupported format", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, Format: "rar"}, wantErr: true},
+		{name: "structured callback", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, Callbacks: models.Callbacks{{URL: "https://example.com", Method: "PUT", PayloadTemplate: `{"id": {{json .ID}}}`}}}},
+		{name: "unsupported callback method", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, Callbacks: models.Callbacks{{URL: "https://example.com", Method: "DELETE"}}}, wantErr: true},
+		{name: "invalid callback template", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, Callbacks: models.Callbacks{{URL: "https://example.com", PayloadTemplate: "{{.ID"}}}, wantErr: true},
+		{name: "negative object size", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, ObjectSizes: map[string]int64{"c": -1}}, wantErr: true},
+		{name: "invalid allowed ip", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, AllowedIPs: []string{"10.0.0.0/33"}}, wantErr: true},
+		{name: "password hash", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, PasswordHash: "$2a$04$eXjjnz90HyG6zbqmQnfENu.xtNgo5i.FStcPA65zyqZtL4XDUk/Xu"}},
+		{name: "password hash not bcrypt", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, PasswordHash: "hunter2"}, wantErr: true},
+		{name: "password with password required", record: models.DownloadRecord{ID: "a", Bucket: "b", Objects: []string{"c"}, Password: "hunter2", PasswordRequired: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateRecord(&tt.record); (err != nil) != tt.wantErr {
+				t.Errorf("validateRecord() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
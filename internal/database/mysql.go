@@ -3,13 +3,14 @@ package database
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 
 	"zipperfly/internal/config"
 	"zipperfly/internal/metrics"
@@ -24,6 +25,12 @@ type MySQLStore struct {
 	timeout          time.Duration
 	metrics          *metrics.Metrics
 	availableColumns map[string]bool // tracks which optional columns exist
+
+	// Callback queue table, for CALLBACK_QUEUE=database
+	*sqlCallbackQueue
+
+	// API key table, for API_KEY_STORE=database
+	*sqlAPIKeys
 }
 
 // NewMySQLStore creates a new MySQL store
@@ -34,10 +41,30 @@ func NewMySQLStore(cfg *config.Config, m *metrics.Metrics) (*MySQLStore, error)
 		return nil, fmt.Errorf("invalid mysql url: %w", err)
 	}
 
-	db, err := sql.Open("mysql", dsn)
+	mysqlCfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mysql dsn: %w", err)
+	}
+
+	// DB_TLS_* settings override any tls parameter in the DSN
+	if cfg.DBTLSMode != "" {
+		tlsCfg, err := buildTLSConfig(cfg, hostFromAddr(mysqlCfg.Addr))
+		if err != nil {
+			return nil, fmt.Errorf("mysql tls config error: %w", err)
+		}
+		mysqlCfg.TLS = tlsCfg
+		mysqlCfg.TLSConfig = "false"
+		mysqlCfg.AllowFallbackToPlaintext = false
+	}
+
+	// Report matched rather than changed rows so UpdateRecord can detect missing records
+	mysqlCfg.ClientFoundRows = true
+
+	connector, err := mysql.NewConnector(mysqlCfg)
 	if err != nil {
 		return nil, fmt.Errorf("mysql connect error: %w", err)
 	}
+	db := sql.OpenDB(connector)
 
 	// Configure connection pool
 	db.SetMaxOpenConns(cfg.DBMaxConnections)
@@ -52,6 +79,14 @@ func NewMySQLStore(cfg *config.Config, m *metrics.Metrics) (*MySQLStore, error)
 		timeout:          cfg.DatabaseQueryTimeout,
 		metrics:          m,
 		availableColumns: make(map[string]bool),
+		sqlCallbackQueue: &sqlCallbackQueue{
+			conn:    sqlCallbackConn{db: db},
+			queries: newCallbackQueries(cfg.CallbackQueueTable, "?", false),
+			timeout: cfg.DatabaseQueryTimeout,
+		},
+		sqlAPIKeys: newSQLAPIKeys(func(ctx context.Context, query string, args ...interface{}) rowScanner {
+			return db.QueryRowContext(ctx, query, args...)
+		}, cfg.APIKeyTable, "?", cfg.DatabaseQueryTimeout),
 	}
 
 	// Detect which optional columns exist in the table
@@ -135,24 +170,7 @@ func (s *MySQLStore) detectColumns(ctx context.Context) error {
 		return fmt.Errorf("error iterating columns: %w", err)
 	}
 
-	// Check for required columns
-	if !columns[s.idField] {
-		return fmt.Errorf("required column %q not found in table %q", s.idField, s.tableName)
-	}
-	if !columns["bucket"] {
-		return fmt.Errorf("required column 'bucket' not found in table %q", s.tableName)
-	}
-	if !columns["objects"] {
-		return fmt.Errorf("required column 'objects' not found in table %q", s.tableName)
-	}
-
-	// Track optional columns
-	s.availableColumns["name"] = columns["name"]
-	s.availableColumns["callback"] = columns["callback"]
-	s.availableColumns["password"] = columns["password"]
-	s.availableColumns["custom_headers"] = columns["custom_headers"]
-
-	return nil
+	return trackColumns(columns, s.availableColumns, s.idField, s.tableName)
 }
 
 // GetRecord retrieves a download record by ID
@@ -167,80 +185,214 @@ func (s *MySQLStore) GetRecord(ctx context.Context, id string) (*models.Download
 	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
-	var record models.DownloadRecord
-	var objectsJSON []byte
+	query := fmt.Sprintf(
+		"SELECT %s FROM %s WHERE %s = ?",
+		strings.Join(recordColumns(s.availableColumns), ", "),
+		s.tableName,
+		s.idField,
+	)
+
+	// Execute query
+	record, err := scanRecord(s.db.QueryRowContext(queryCtx, query, id), s.availableColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	record.ID = id
+	return record, nil
+}
+
+// GetRecords retrieves multiple download records by ID, in one query per
+// maxBatchIDs IDs. IDs with no matching record are omitted from the result.
+func (s *MySQLStore) GetRecords(ctx context.Context, ids []string) (map[string]*models.DownloadRecord, error) {
+	records := make(map[string]*models.DownloadRecord, len(ids))
+	if len(ids) == 0 {
+		return records, nil
+	}
+
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		s.metrics.DatabaseQueryDuration.WithLabelValues("mysql").Observe(duration.Seconds())
+	}()
 
-	// Build dynamic SELECT query based on available columns
-	selectCols := []string{"bucket", "objects"}
-	if s.availableColumns["name"] {
-		selectCols = append(selectCols, "name")
+	for batch := range slices.Chunk(ids, maxBatchIDs) {
+		if err := s.getRecordBatch(ctx, batch, records); err != nil {
+			return nil, err
+		}
 	}
-	if s.availableColumns["callback"] {
-		selectCols = append(selectCols, "callback")
+	return records, nil
+}
+
+// getRecordBatch looks up at most maxBatchIDs records into records
+func (s *MySQLStore) getRecordBatch(ctx context.Context, ids []string, records map[string]*models.DownloadRecord) error {
+	// Apply timeout
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := selectRecordsQuery(s.tableName, s.idField, s.availableColumns, "?", len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
 	}
-	if s.availableColumns["password"] {
-		selectCols = append(selectCols, "password")
+
+	rows, err := s.db.QueryContext(queryCtx, query, args...)
+	if err != nil {
+		return err
 	}
-	if s.availableColumns["custom_headers"] {
-		selectCols = append(selectCols, "custom_headers")
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		record, err := scanRecord(rows, s.availableColumns, &id)
+		if err != nil {
+			return err
+		}
+		record.ID = id
+		records[id] = record
+	}
+	return rows.Err()
+}
+
+// IncrementDownloadCount atomically bumps the download_count column.
+// It is a no-op when the table has no download_count column.
+func (s *MySQLStore) IncrementDownloadCount(ctx context.Context, id string) error {
+	if !s.availableColumns["download_count"] {
+		return nil
 	}
 
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
 	query := fmt.Sprintf(
-		"SELECT %s FROM %s WHERE %s = ?",
-		strings.Join(selectCols, ", "),
+		"UPDATE %s SET download_count = COALESCE(download_count, 0) + 1 WHERE %s = ?",
 		s.tableName,
 		s.idField,
 	)
 
-	// Prepare scan destinations based on available columns
-	scanDests := []interface{}{&record.Bucket, &objectsJSON}
+	_, err := s.db.ExecContext(queryCtx, query, id)
+	return err
+}
 
-	var nameVal, callbackVal, passwordVal, customHeadersJSON sql.NullString
-	if s.availableColumns["name"] {
-		scanDests = append(scanDests, &nameVal)
+// ClaimDownload atomically bumps download_count if it is below limit
+func (s *MySQLStore) ClaimDownload(ctx context.Context, id string, limit int) (bool, error) {
+	if !s.availableColumns["download_count"] {
+		return false, errNoDownloadCount
 	}
-	if s.availableColumns["callback"] {
-		scanDests = append(scanDests, &callbackVal)
+
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	result, err := s.db.ExecContext(queryCtx, claimDownloadQuery(s.tableName, s.idField, "?"), id, limit)
+	if err != nil {
+		return false, err
 	}
-	if s.availableColumns["password"] {
-		scanDests = append(scanDests, &passwordVal)
+	claimed, err := result.RowsAffected()
+	return claimed > 0, err
+}
+
+// ReleaseDownload decrements download_count, giving back a failed claim
+func (s *MySQLStore) ReleaseDownload(ctx context.Context, id string) error {
+	if !s.availableColumns["download_count"] {
+		return errNoDownloadCount
 	}
-	if s.availableColumns["custom_headers"] {
-		scanDests = append(scanDests, &customHeadersJSON)
+
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(queryCtx, releaseDownloadQuery(s.tableName, s.idField, "?"), id)
+	return err
+}
+
+// CreateRecord inserts a new download record
+func (s *MySQLStore) CreateRecord(ctx context.Context, record *models.DownloadRecord) error {
+	if err := validateRecord(record); err != nil {
+		return err
 	}
 
-	// Execute query
-	err := s.db.QueryRowContext(queryCtx, query, id).Scan(scanDests...)
+	cols, values, err := recordValues(record, s.availableColumns)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Parse required fields
-	if err := json.Unmarshal(objectsJSON, &record.Objects); err != nil {
-		return nil, err
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := insertQuery(s.tableName, s.idField, cols, "?")
+	if _, err := s.db.ExecContext(queryCtx, query, append([]interface{}{record.ID}, values...)...); err != nil {
+		if isMySQLDuplicate(err) {
+			return ErrRecordExists
+		}
+		return err
 	}
 
-	// Parse optional fields if they exist
-	if s.availableColumns["name"] && nameVal.Valid {
-		record.Name = nameVal.String
+	return nil
+}
+
+// UpdateRecord replaces an existing record's fields, leaving download_count untouched
+func (s *MySQLStore) UpdateRecord(ctx context.Context, record *models.DownloadRecord) error {
+	if err := validateRecord(record); err != nil {
+		return err
 	}
 
-	if s.availableColumns["callback"] && callbackVal.Valid {
-		record.Callback = callbackVal.String
+	cols, values, err := recordValues(record, s.availableColumns)
+	if err != nil {
+		return err
 	}
 
-	if s.availableColumns["password"] && passwordVal.Valid {
-		record.Password = passwordVal.String
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := updateQuery(s.tableName, s.idField, cols, "?")
+	result, err := s.db.ExecContext(queryCtx, query, append(values, record.ID)...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRecordNotFound
 	}
 
-	if s.availableColumns["custom_headers"] && customHeadersJSON.Valid && customHeadersJSON.String != "" {
-		if err := json.Unmarshal([]byte(customHeadersJSON.String), &record.CustomHeaders); err != nil {
-			return nil, err
-		}
+	return nil
+}
+
+// DeleteRecord removes a download record
+func (s *MySQLStore) DeleteRecord(ctx context.Context, id string) error {
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", s.tableName, s.idField)
+	result, err := s.db.ExecContext(queryCtx, query, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrRecordNotFound
 	}
 
-	record.ID = id
-	return &record, nil
+	return nil
+}
+
+// isMySQLDuplicate reports whether err is a duplicate key error
+func isMySQLDuplicate(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
+
+// Columns returns the record columns the table has, required ones first
+func (s *MySQLStore) Columns() []string {
+	return append([]string{s.idField}, recordColumns(s.availableColumns)...)
+}
+
+// HealthCheck pings the database
+func (s *MySQLStore) HealthCheck(ctx context.Context) error {
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	return s.db.PingContext(queryCtx)
 }
 
 // Close closes the database connection
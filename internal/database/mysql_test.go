@@ -30,6 +30,10 @@ func TestMySQLStore_GetRecord(t *testing.T) {
 
 	ctx := context.Background()
 
+	if err := store.HealthCheck(ctx); err != nil {
+		t.Errorf("HealthCheck() error = %v", err)
+	}
+
 	tests := []struct {
 		name    string
 		id      string
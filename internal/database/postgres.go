@@ -2,12 +2,13 @@ package database
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"zipperfly/internal/config"
@@ -23,6 +24,12 @@ type PostgresStore struct {
 	timeout          time.Duration
 	metrics          *metrics.Metrics
 	availableColumns map[string]bool // tracks which optional columns exist
+
+	// Callback queue table, for CALLBACK_QUEUE=database
+	*sqlCallbackQueue
+
+	// API key table, for API_KEY_STORE=database
+	*sqlAPIKeys
 }
 
 // NewPostgresStore creates a new PostgreSQL store
@@ -33,6 +40,16 @@ func NewPostgresStore(ctx context.Context, cfg *config.Config, m *metrics.Metric
 		return nil, fmt.Errorf("postgres parse config error: %w", err)
 	}
 
+	// DB_TLS_* settings override sslmode and friends in the URL
+	if cfg.DBTLSMode != "" {
+		tlsCfg, err := buildTLSConfig(cfg, poolConfig.ConnConfig.Host)
+		if err != nil {
+			return nil, fmt.Errorf("postgres tls config error: %w", err)
+		}
+		poolConfig.ConnConfig.TLSConfig = tlsCfg
+		poolConfig.ConnConfig.Fallbacks = nil // no silent plaintext retry
+	}
+
 	// Configure connection pool
 	poolConfig.MaxConns = int32(cfg.DBMaxConnections)
 	poolConfig.MinConns = int32(min(2, cfg.DBMaxConnections)) // Keep a few connections warm (or max if max < 2)
@@ -51,6 +68,14 @@ func NewPostgresStore(ctx context.Context, cfg *config.Config, m *metrics.Metric
 		timeout:          cfg.DatabaseQueryTimeout,
 		metrics:          m,
 		availableColumns: make(map[string]bool),
+		sqlCallbackQueue: &sqlCallbackQueue{
+			conn:    pgxCallbackConn{pool: pool},
+			queries: newCallbackQueries(cfg.CallbackQueueTable, "$%d", false),
+			timeout: cfg.DatabaseQueryTimeout,
+		},
+		sqlAPIKeys: newSQLAPIKeys(func(ctx context.Context, query string, args ...interface{}) rowScanner {
+			return pool.QueryRow(ctx, query, args...)
+		}, cfg.APIKeyTable, "$%d", cfg.DatabaseQueryTimeout),
 	}
 
 	// Detect which optional columns exist in the table
@@ -89,24 +114,7 @@ func (s *PostgresStore) detectColumns(ctx context.Context) error {
 		return fmt.Errorf("error iterating columns: %w", err)
 	}
 
-	// Check for required columns
-	if !columns[s.idField] {
-		return fmt.Errorf("required column %q not found in table %q", s.idField, s.tableName)
-	}
-	if !columns["bucket"] {
-		return fmt.Errorf("required column 'bucket' not found in table %q", s.tableName)
-	}
-	if !columns["objects"] {
-		return fmt.Errorf("required column 'objects' not found in table %q", s.tableName)
-	}
-
-	// Track optional columns
-	s.availableColumns["name"] = columns["name"]
-	s.availableColumns["callback"] = columns["callback"]
-	s.availableColumns["password"] = columns["password"]
-	s.availableColumns["custom_headers"] = columns["custom_headers"]
-
-	return nil
+	return trackColumns(columns, s.availableColumns, s.idField, s.tableName)
 }
 
 // GetRecord retrieves a download record by ID
@@ -121,80 +129,209 @@ func (s *PostgresStore) GetRecord(ctx context.Context, id string) (*models.Downl
 	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
-	var record models.DownloadRecord
-	var objectsJSON []byte
+	query := fmt.Sprintf(
+		"SELECT %s FROM %s WHERE %s = $1",
+		strings.Join(recordColumns(s.availableColumns), ", "),
+		s.tableName,
+		s.idField,
+	)
+
+	// Execute query
+	record, err := scanRecord(s.pool.QueryRow(queryCtx, query, id), s.availableColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	record.ID = id
+	return record, nil
+}
+
+// GetRecords retrieves multiple download records by ID, in one query per
+// maxBatchIDs IDs. IDs with no matching record are omitted from the result.
+func (s *PostgresStore) GetRecords(ctx context.Context, ids []string) (map[string]*models.DownloadRecord, error) {
+	records := make(map[string]*models.DownloadRecord, len(ids))
+	if len(ids) == 0 {
+		return records, nil
+	}
+
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		s.metrics.DatabaseQueryDuration.WithLabelValues("postgres").Observe(duration.Seconds())
+	}()
+
+	for batch := range slices.Chunk(ids, maxBatchIDs) {
+		if err := s.getRecordBatch(ctx, batch, records); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// getRecordBatch looks up at most maxBatchIDs records into records
+func (s *PostgresStore) getRecordBatch(ctx context.Context, ids []string, records map[string]*models.DownloadRecord) error {
+	// Apply timeout
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
 
-	// Build dynamic SELECT query based on available columns
-	selectCols := []string{"bucket", "objects"}
-	if s.availableColumns["name"] {
-		selectCols = append(selectCols, "name")
+	query := selectRecordsQuery(s.tableName, s.idField, s.availableColumns, "$%d", len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
 	}
-	if s.availableColumns["callback"] {
-		selectCols = append(selectCols, "callback")
+
+	rows, err := s.pool.Query(queryCtx, query, args...)
+	if err != nil {
+		return err
 	}
-	if s.availableColumns["password"] {
-		selectCols = append(selectCols, "password")
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		record, err := scanRecord(rows, s.availableColumns, &id)
+		if err != nil {
+			return err
+		}
+		record.ID = id
+		records[id] = record
 	}
-	if s.availableColumns["custom_headers"] {
-		selectCols = append(selectCols, "custom_headers")
+	return rows.Err()
+}
+
+// IncrementDownloadCount atomically bumps the download_count column.
+// It is a no-op when the table has no download_count column.
+func (s *PostgresStore) IncrementDownloadCount(ctx context.Context, id string) error {
+	if !s.availableColumns["download_count"] {
+		return nil
 	}
 
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
 	query := fmt.Sprintf(
-		"SELECT %s FROM %s WHERE %s = $1",
-		strings.Join(selectCols, ", "),
+		"UPDATE %s SET download_count = COALESCE(download_count, 0) + 1 WHERE %s = $1",
 		s.tableName,
 		s.idField,
 	)
 
-	// Prepare scan destinations based on available columns
-	scanDests := []interface{}{&record.Bucket, &objectsJSON}
+	_, err := s.pool.Exec(queryCtx, query, id)
+	return err
+}
 
-	var nameVal, callbackVal, passwordVal, customHeadersJSON sql.NullString
-	if s.availableColumns["name"] {
-		scanDests = append(scanDests, &nameVal)
+// ClaimDownload atomically bumps download_count if it is below limit
+func (s *PostgresStore) ClaimDownload(ctx context.Context, id string, limit int) (bool, error) {
+	if !s.availableColumns["download_count"] {
+		return false, errNoDownloadCount
 	}
-	if s.availableColumns["callback"] {
-		scanDests = append(scanDests, &callbackVal)
+
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	tag, err := s.pool.Exec(queryCtx, claimDownloadQuery(s.tableName, s.idField, "$%d"), id, limit)
+	if err != nil {
+		return false, err
 	}
-	if s.availableColumns["password"] {
-		scanDests = append(scanDests, &passwordVal)
+	return tag.RowsAffected() > 0, nil
+}
+
+// ReleaseDownload decrements download_count, giving back a failed claim
+func (s *PostgresStore) ReleaseDownload(ctx context.Context, id string) error {
+	if !s.availableColumns["download_count"] {
+		return errNoDownloadCount
 	}
-	if s.availableColumns["custom_headers"] {
-		scanDests = append(scanDests, &customHeadersJSON)
+
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	_, err := s.pool.Exec(queryCtx, releaseDownloadQuery(s.tableName, s.idField, "$%d"), id)
+	return err
+}
+
+// CreateRecord inserts a new download record
+func (s *PostgresStore) CreateRecord(ctx context.Context, record *models.DownloadRecord) error {
+	if err := validateRecord(record); err != nil {
+		return err
 	}
 
-	// Execute query
-	err := s.pool.QueryRow(queryCtx, query, id).Scan(scanDests...)
+	cols, values, err := recordValues(record, s.availableColumns)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Parse required fields
-	if err := json.Unmarshal(objectsJSON, &record.Objects); err != nil {
-		return nil, err
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := insertQuery(s.tableName, s.idField, cols, "$%d")
+	if _, err := s.pool.Exec(queryCtx, query, append([]interface{}{record.ID}, values...)...); err != nil {
+		if isPostgresDuplicate(err) {
+			return ErrRecordExists
+		}
+		return err
 	}
 
-	// Parse optional fields if they exist
-	if s.availableColumns["name"] && nameVal.Valid {
-		record.Name = nameVal.String
+	return nil
+}
+
+// UpdateRecord replaces an existing record's fields, leaving download_count untouched
+func (s *PostgresStore) UpdateRecord(ctx context.Context, record *models.DownloadRecord) error {
+	if err := validateRecord(record); err != nil {
+		return err
 	}
 
-	if s.availableColumns["callback"] && callbackVal.Valid {
-		record.Callback = callbackVal.String
+	cols, values, err := recordValues(record, s.availableColumns)
+	if err != nil {
+		return err
 	}
 
-	if s.availableColumns["password"] && passwordVal.Valid {
-		record.Password = passwordVal.String
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := updateQuery(s.tableName, s.idField, cols, "$%d")
+	tag, err := s.pool.Exec(queryCtx, query, append(values, record.ID)...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRecordNotFound
 	}
 
-	if s.availableColumns["custom_headers"] && customHeadersJSON.Valid && customHeadersJSON.String != "" {
-		if err := json.Unmarshal([]byte(customHeadersJSON.String), &record.CustomHeaders); err != nil {
-			return nil, err
-		}
+	return nil
+}
+
+// DeleteRecord removes a download record
+func (s *PostgresStore) DeleteRecord(ctx context.Context, id string) error {
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = $1", s.tableName, s.idField)
+	tag, err := s.pool.Exec(queryCtx, query, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRecordNotFound
 	}
 
-	record.ID = id
-	return &record, nil
+	return nil
+}
+
+// isPostgresDuplicate reports whether err is a unique constraint violation
+func isPostgresDuplicate(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// Columns returns the record columns the table has, required ones first
+func (s *PostgresStore) Columns() []string {
+	return append([]string{s.idField}, recordColumns(s.availableColumns)...)
+}
+
+// HealthCheck pings the database
+func (s *PostgresStore) HealthCheck(ctx context.Context) error {
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	return s.pool.Ping(queryCtx)
 }
 
 // Close closes the database connection
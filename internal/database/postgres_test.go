@@ -30,6 +30,10 @@ func TestPostgresStore_GetRecord(t *testing.T) {
 	}
 	defer store.Close()
 
+	if err := store.HealthCheck(ctx); err != nil {
+		t.Errorf("HealthCheck() error = %v", err)
+	}
+
 	tests := []struct {
 		name    string
 		id      string
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -28,6 +29,15 @@ func NewRedisStore(ctx context.Context, cfg *config.Config, m *metrics.Metrics)
 		return nil, fmt.Errorf("redis parse url error: %w", err)
 	}
 
+	// DB_TLS_* settings override the rediss:// scheme
+	if cfg.DBTLSMode != "" {
+		tlsCfg, err := buildTLSConfig(cfg, hostFromAddr(opts.Addr))
+		if err != nil {
+			return nil, fmt.Errorf("redis tls config error: %w", err)
+		}
+		opts.TLSConfig = tlsCfg
+	}
+
 	// Configure connection pool
 	opts.PoolSize = cfg.DBMaxConnections
 	opts.MinIdleConns = min(2, cfg.DBMaxConnections) // Keep a few connections warm (or max if max < 2)
@@ -70,10 +80,203 @@ func (s *RedisStore) GetRecord(ctx context.Context, id string) (*models.Download
 		return nil, err
 	}
 
+	// The counter lives under its own key so it can be bumped with INCR
+	count, err := s.client.Get(queryCtx, s.downloadCountKey(id)).Int()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if err == nil {
+		record.DownloadCount = count
+	}
+
 	record.ID = id
 	return &record, nil
 }
 
+// GetRecords retrieves multiple download records by ID using MGET.
+// IDs with no matching record are omitted from the result.
+func (s *RedisStore) GetRecords(ctx context.Context, ids []string) (map[string]*models.DownloadRecord, error) {
+	records := make(map[string]*models.DownloadRecord, len(ids))
+	if len(ids) == 0 {
+		return records, nil
+	}
+
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		s.metrics.DatabaseQueryDuration.WithLabelValues("redis").Observe(duration.Seconds())
+	}()
+
+	// Apply timeout
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	// Fetch records and their counters in one round trip
+	keys := make([]string, 0, len(ids)*2)
+	for _, id := range ids {
+		keys = append(keys, s.keyPrefix+id)
+	}
+	for _, id := range ids {
+		keys = append(keys, s.downloadCountKey(id))
+	}
+
+	values, err := s.client.MGet(queryCtx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, id := range ids {
+		data, ok := values[i].(string)
+		if !ok {
+			continue
+		}
+
+		var record models.DownloadRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			return nil, fmt.Errorf("record %s: %w", id, err)
+		}
+
+		if countStr, ok := values[len(ids)+i].(string); ok {
+			if count, err := strconv.Atoi(countStr); err == nil {
+				record.DownloadCount = count
+			}
+		}
+
+		record.ID = id
+		records[id] = &record
+	}
+
+	return records, nil
+}
+
+// IncrementDownloadCount atomically bumps the record's download counter
+func (s *RedisStore) IncrementDownloadCount(ctx context.Context, id string) error {
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	return s.client.Incr(queryCtx, s.downloadCountKey(id)).Err()
+}
+
+// claimDownloadScript increments the counter at KEYS[1] if it is below
+// ARGV[1], returning 1 if it did
+var claimDownloadScript = redis.NewScript(`
+if tonumber(redis.call('GET', KEYS[1]) or '0') >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('INCR', KEYS[1])
+return 1
+`)
+
+// ClaimDownload atomically bumps the record's download counter if it is below limit
+func (s *RedisStore) ClaimDownload(ctx context.Context, id string, limit int) (bool, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	claimed, err := claimDownloadScript.Run(queryCtx, s.client, []string{s.downloadCountKey(id)}, limit).Int()
+	return claimed == 1, err
+}
+
+// releaseDownloadScript decrements the counter at KEYS[1] if it is positive
+var releaseDownloadScript = redis.NewScript(`
+if tonumber(redis.call('GET', KEYS[1]) or '0') > 0 then
+	redis.call('DECR', KEYS[1])
+end
+return 0
+`)
+
+// ReleaseDownload decrements the record's download counter, giving back a
+// failed claim
+func (s *RedisStore) ReleaseDownload(ctx context.Context, id string) error {
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	return releaseDownloadScript.Run(queryCtx, s.client, []string{s.downloadCountKey(id)}).Err()
+}
+
+// CreateRecord stores a new record, failing if the key already exists
+func (s *RedisStore) CreateRecord(ctx context.Context, record *models.DownloadRecord) error {
+	data, err := s.encodeRecord(record)
+	if err != nil {
+		return err
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	created, err := s.client.SetNX(queryCtx, s.keyPrefix+record.ID, data, 0).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return ErrRecordExists
+	}
+
+	// Drop any counter left behind by a deleted record with the same ID
+	return s.client.Del(queryCtx, s.downloadCountKey(record.ID)).Err()
+}
+
+// UpdateRecord replaces an existing record, leaving its download counter untouched
+func (s *RedisStore) UpdateRecord(ctx context.Context, record *models.DownloadRecord) error {
+	data, err := s.encodeRecord(record)
+	if err != nil {
+		return err
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	updated, err := s.client.SetXX(queryCtx, s.keyPrefix+record.ID, data, redis.KeepTTL).Result()
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteRecord removes a record and its download counter
+func (s *RedisStore) DeleteRecord(ctx context.Context, id string) error {
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	deleted, err := s.client.Del(queryCtx, s.keyPrefix+id).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrRecordNotFound
+	}
+
+	return s.client.Del(queryCtx, s.downloadCountKey(id)).Err()
+}
+
+// encodeRecord validates a record and marshals it in the stored JSON format.
+// The download count is kept out of the JSON since it lives under its own key.
+func (s *RedisStore) encodeRecord(record *models.DownloadRecord) ([]byte, error) {
+	if err := validateRecord(record); err != nil {
+		return nil, err
+	}
+
+	stored := *record
+	stored.DownloadCount = 0
+	return json.Marshal(&stored)
+}
+
+// downloadCountKey returns the key holding the download counter for a record
+func (s *RedisStore) downloadCountKey(id string) string {
+	return s.keyPrefix + id + ":download_count"
+}
+
+// HealthCheck sends a PING
+func (s *RedisStore) HealthCheck(ctx context.Context) error {
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	return s.client.Ping(queryCtx).Err()
+}
+
 // Close closes the Redis connection
 func (s *RedisStore) Close() error {
 	return s.client.Close()
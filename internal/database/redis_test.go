@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"testing"
 	"time"
 
@@ -33,6 +34,10 @@ func TestRedisStore_GetRecord(t *testing.T) {
 	}
 	defer store.Close()
 
+	if err := store.HealthCheck(ctx); err != nil {
+		t.Errorf("HealthCheck() error = %v", err)
+	}
+
 	// Insert a test record
 	testRecord := &models.DownloadRecord{
 		ID:      "test-redis-1",
@@ -107,3 +112,65 @@ func TestRedisStore_GetRecord(t *testing.T) {
 		})
 	}
 }
+
+func TestRedisStore_RecordWriter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping redis test in short mode")
+	}
+
+	m := metrics.New()
+	cfg := &config.Config{
+		DBURL:                "redis://localhost:6379/0",
+		KeyPrefix:            "test:",
+		DatabaseQueryTimeout: 5 * time.Second,
+	}
+
+	ctx := context.Background()
+
+	store, err := NewRedisStore(ctx, cfg, m)
+	if err != nil {
+		t.Skipf("Redis not available: %v", err)
+	}
+	defer store.Close()
+
+	record := &models.DownloadRecord{
+		ID:      "test-redis-write",
+		Bucket:  "test-bucket",
+		Objects: []string{"file1.txt"},
+	}
+	defer store.DeleteRecord(ctx, record.ID)
+
+	if err := store.CreateRecord(ctx, record); err != nil {
+		t.Fatalf("CreateRecord() error = %v", err)
+	}
+	if err := store.CreateRecord(ctx, record); !errors.Is(err, ErrRecordExists) {
+		t.Errorf("second CreateRecord() error = %v, want ErrRecordExists", err)
+	}
+
+	if err := store.IncrementDownloadCount(ctx, record.ID); err != nil {
+		t.Fatalf("IncrementDownloadCount() error = %v", err)
+	}
+
+	record.Name = "renamed"
+	if err := store.UpdateRecord(ctx, record); err != nil {
+		t.Fatalf("UpdateRecord() error = %v", err)
+	}
+
+	got, err := store.GetRecord(ctx, record.ID)
+	if err != nil {
+		t.Fatalf("GetRecord() error = %v", err)
+	}
+	if got.Name != "renamed" || got.DownloadCount != 1 {
+		t.Errorf("GetRecord() = %+v, want renamed record with download count 1", got)
+	}
+
+	if err := store.DeleteRecord(ctx, record.ID); err != nil {
+		t.Fatalf("DeleteRecord() error = %v", err)
+	}
+	if err := store.DeleteRecord(ctx, record.ID); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("second DeleteRecord() error = %v, want ErrRecordNotFound", err)
+	}
+	if err := store.UpdateRecord(ctx, record); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("UpdateRecord() on deleted record error = %v, want ErrRecordNotFound", err)
+	}
+}
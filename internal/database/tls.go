@@ -0,0 +1,103 @@
+package database
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"zipperfly/internal/config"
+)
+
+// buildTLSConfig builds the client TLS config described by the DB_TLS_* settings.
+// It returns nil for mode "disable"; callers only consult it when DBTLSMode is set.
+//
+// Modes follow libpq semantics:
+//   - require:     encrypt, but do not verify the server certificate
+//   - verify-ca:   verify the certificate chain, but not the hostname
+//   - verify-full: verify the certificate chain and the hostname
+func buildTLSConfig(cfg *config.Config, serverName string) (*tls.Config, error) {
+	if cfg.DBTLSMode == "disable" {
+		return nil, nil
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+
+	if cfg.DBTLSCA != "" {
+		pem, err := os.ReadFile(cfg.DBTLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read DB_TLS_CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in DB_TLS_CA %q", cfg.DBTLSCA)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if cfg.DBTLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.DBTLSCert, cfg.DBTLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load DB_TLS_CERT/DB_TLS_KEY: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	switch cfg.DBTLSMode {
+	case "require":
+		tlsCfg.InsecureSkipVerify = true
+	case "verify-ca":
+		// Skip the built-in check (which includes the hostname) and verify the chain ourselves
+		tlsCfg.InsecureSkipVerify = true
+		tlsCfg.VerifyPeerCertificate = verifyChain(tlsCfg.RootCAs)
+	case "verify-full":
+	default:
+		return nil, fmt.Errorf("unsupported DB_TLS_MODE %q", cfg.DBTLSMode)
+	}
+
+	return tlsCfg, nil
+}
+
+// verifyChain returns a callback that verifies the peer chain against roots
+// (the system pool when nil) without checking the hostname
+func verifyChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("server presented no certificate")
+		}
+
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("failed to parse server certificate: %w", err)
+			}
+			certs[i] = cert
+		}
+
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+
+		_, err := certs[0].Verify(opts)
+		return err
+	}
+}
+
+// hostFromAddr returns the host part of a host:port address
+func hostFromAddr(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
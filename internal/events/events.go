@@ -0,0 +1,57 @@
+// Package events publishes download lifecycle events to a message bus
+// (Kafka, NATS, or SQS), for consumers that would rather subscribe than
+// receive per-record HTTP callbacks.
+package events
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"zipperfly/internal/models"
+)
+
+// Lifecycle event types. A download publishes Started, then one of
+// Completed, Partial, or Failed; ClientDisconnect comes before the outcome
+// when the client went away mid-download.
+const (
+	Started          = "started"
+	Completed        = "completed"
+	Partial          = "partial"
+	Failed           = "failed"
+	ClientDisconnect = "client_disconnect"
+)
+
+// BreakerStateChange is the type of circuit breaker events, published
+// outside any download
+const BreakerStateChange = "circuit_breaker"
+
+// Publisher sends events to a message bus
+type Publisher interface {
+	Publish(ctx context.Context, event models.DownloadEvent) error
+	Close() error
+}
+
+// BreakerPublisher is a Publisher that can also send circuit breaker
+// events. Those are keyed (and on SQS FIFO queues grouped) by breaker name
+// rather than record ID.
+type BreakerPublisher interface {
+	Publisher
+	PublishBreaker(ctx context.Context, event models.BreakerEvent) error
+}
+
+// New connects a publisher for backend ("kafka", "nats", or "sqs"). url is
+// the Kafka brokers (comma-separated), NATS server URL, or SQS queue URL;
+// topic is the Kafka topic or NATS subject prefix, and unused for SQS.
+func New(ctx context.Context, backend, url, topic string) (Publisher, error) {
+	switch backend {
+	case "kafka":
+		return NewKafka(strings.Split(url, ","), topic), nil
+	case "nats":
+		return NewNATS(url, topic)
+	case "sqs":
+		return NewSQS(ctx, url)
+	default:
+		return nil, fmt.Errorf("unsupported event bus: %s", backend)
+	}
+}
@@ -0,0 +1,65 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"zipperfly/internal/models"
+)
+
+func TestNew_UnsupportedBackend(t *testing.T) {
+	if _, err := New(context.Background(), "carrier-pigeon", "", ""); err == nil {
+		t.Error("New() error = nil, want an error for an unknown bus")
+	}
+}
+
+func TestNATSSubject(t *testing.T) {
+	if got := natsSubject("zipperfly.downloads", ClientDisconnect); got != "zipperfly.downloads.client_disconnect" {
+		t.Errorf("natsSubject() = %q", got)
+	}
+}
+
+func TestSQSPublisher_Message(t *testing.T) {
+	event := models.DownloadEvent{
+		Type:            Completed,
+		CallbackPayload: models.CallbackPayload{ID: "rec-1", RequestID: "req-1", Status: "completed", FileCount: 2},
+	}
+
+	tests := []struct {
+		name     string
+		queueURL string
+		fifo     bool
+	}{
+		{"standard queue", "https://sqs.us-east-1.amazonaws.com/123456789012/downloads", false},
+		{"FIFO queue", "https://sqs.us-east-1.amazonaws.com/123456789012/downloads.fifo", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_REGION", "us-east-1")
+			p, err := NewSQS(context.Background(), tt.queueURL)
+			if err != nil {
+				t.Fatalf("NewSQS() error = %v", err)
+			}
+			input := p.message(event.ID, event.Type, event)
+
+			var body map[string]interface{}
+			if err := json.Unmarshal([]byte(*input.MessageBody), &body); err != nil {
+				t.Fatalf("body is not JSON: %v", err)
+			}
+			if body["type"] != "completed" || body["id"] != "rec-1" || body["request_id"] != "req-1" {
+				t.Errorf("body = %v, want the event with its payload flattened", body)
+			}
+			if got := *input.MessageAttributes["type"].StringValue; got != "completed" {
+				t.Errorf("type attribute = %q, want completed", got)
+			}
+			if (input.MessageGroupId != nil) != tt.fifo || (input.MessageDeduplicationId != nil) != tt.fifo {
+				t.Errorf("group/dedup IDs set = %v/%v, want %v", input.MessageGroupId != nil, input.MessageDeduplicationId != nil, tt.fifo)
+			}
+			if tt.fifo && *input.MessageGroupId != "rec-1" {
+				t.Errorf("MessageGroupId = %q, want the record ID", *input.MessageGroupId)
+			}
+		})
+	}
+}
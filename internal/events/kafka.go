@@ -0,0 +1,59 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+
+	"zipperfly/internal/models"
+)
+
+// KafkaPublisher writes events to a Kafka topic, keyed by record ID so a
+// download's events land on one partition, in order
+type KafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+// NewKafka creates a publisher for topic on brokers. Connections are made
+// on first publish.
+func NewKafka(brokers []string, topic string) *KafkaPublisher {
+	for i := range brokers {
+		brokers[i] = strings.TrimSpace(brokers[i])
+	}
+	return &KafkaPublisher{writer: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireOne,
+	}}
+}
+
+// Publish writes one event, waiting for the leader to acknowledge it
+func (p *KafkaPublisher) Publish(ctx context.Context, event models.DownloadEvent) error {
+	return p.write(ctx, event.ID, event.Type, event)
+}
+
+// PublishBreaker writes one circuit breaker event, keyed by breaker name
+func (p *KafkaPublisher) PublishBreaker(ctx context.Context, event models.BreakerEvent) error {
+	return p.write(ctx, event.Breaker, event.Type, event)
+}
+
+// write sends event as JSON under key, with a type header
+func (p *KafkaPublisher) write(ctx context.Context, key, eventType string, event interface{}) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return p.writer.WriteMessages(ctx, kafka.Message{
+		Key:     []byte(key),
+		Value:   data,
+		Headers: []kafka.Header{{Key: "type", Value: []byte(eventType)}},
+	})
+}
+
+// Close flushes pending writes and closes broker connections
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,58 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/nats-io/nats.go"
+
+	"zipperfly/internal/models"
+)
+
+// NATSPublisher publishes events to <subject>.<type>, so subscribers can
+// pick event types with subject wildcards
+type NATSPublisher struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// NewNATS connects to the NATS server at url
+func NewNATS(url, subject string) (*NATSPublisher, error) {
+	conn, err := nats.Connect(url, nats.Name("zipperfly"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	return &NATSPublisher{conn: conn, subject: subject}, nil
+}
+
+// Publish sends one event, waiting for the server to receive it
+func (p *NATSPublisher) Publish(ctx context.Context, event models.DownloadEvent) error {
+	return p.publish(ctx, event.Type, event)
+}
+
+// PublishBreaker sends one circuit breaker event
+func (p *NATSPublisher) PublishBreaker(ctx context.Context, event models.BreakerEvent) error {
+	return p.publish(ctx, event.Type, event)
+}
+
+// publish sends event as JSON on the subject for its type
+func (p *NATSPublisher) publish(ctx context.Context, eventType string, event interface{}) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := p.conn.Publish(natsSubject(p.subject, eventType), data); err != nil {
+		return err
+	}
+	return p.conn.FlushWithContext(ctx)
+}
+
+// Close delivers buffered events, then closes the connection
+func (p *NATSPublisher) Close() error {
+	return p.conn.Drain()
+}
+
+// natsSubject is the subject an event type is published on
+func natsSubject(prefix, eventType string) string {
+	return prefix + "." + eventType
+}
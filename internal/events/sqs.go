@@ -0,0 +1,75 @@
+package events
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"zipperfly/internal/models"
+)
+
+// SQSPublisher sends events to an SQS queue, with the event type as a
+// message attribute. On a FIFO queue a download's events share a message
+// group, so they are received in order.
+type SQSPublisher struct {
+	client   *sqs.Client
+	queueURL string
+	fifo     bool
+}
+
+// NewSQS creates a publisher for queueURL, with credentials and region from
+// the standard AWS environment (AWS_REGION, AWS_ACCESS_KEY_ID, ...)
+func NewSQS(ctx context.Context, queueURL string) (*SQSPublisher, error) {
+	awsCfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &SQSPublisher{
+		client:   sqs.NewFromConfig(awsCfg),
+		queueURL: queueURL,
+		fifo:     strings.HasSuffix(queueURL, ".fifo"),
+	}, nil
+}
+
+// Publish sends one event
+func (p *SQSPublisher) Publish(ctx context.Context, event models.DownloadEvent) error {
+	_, err := p.client.SendMessage(ctx, p.message(event.ID, event.Type, event))
+	return err
+}
+
+// PublishBreaker sends one circuit breaker event, grouped by breaker name
+func (p *SQSPublisher) PublishBreaker(ctx context.Context, event models.BreakerEvent) error {
+	_, err := p.client.SendMessage(ctx, p.message(event.Breaker, event.Type, event))
+	return err
+}
+
+// message builds the SendMessage request for an event, in message group
+// group on a FIFO queue
+func (p *SQSPublisher) message(group, eventType string, event interface{}) *sqs.SendMessageInput {
+	data, _ := json.Marshal(event)
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(p.queueURL),
+		MessageBody: aws.String(string(data)),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"type": {DataType: aws.String("String"), StringValue: aws.String(eventType)},
+		},
+	}
+	if p.fifo {
+		input.MessageGroupId = aws.String(group)
+		sum := sha256.Sum256(data)
+		input.MessageDeduplicationId = aws.String(hex.EncodeToString(sum[:]))
+	}
+	return input
+}
+
+// Close is a no-op; the SQS client holds no connections to release
+func (p *SQSPublisher) Close() error {
+	return nil
+}
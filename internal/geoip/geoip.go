@@ -0,0 +1,52 @@
+// Package geoip looks up client countries in a MaxMind database, such as
+// GeoLite2-Country or GeoIP2-City.
+package geoip
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// DB is an open MaxMind database
+type DB struct {
+	reader *maxminddb.Reader
+}
+
+// record holds the fields read from a lookup
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// Open opens the .mmdb file at path
+func Open(path string) (*DB, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("geoip open error: %w", err)
+	}
+	return &DB{reader: reader}, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of ip's country, falling
+// back to the country its network is registered in, or "" if unknown
+func (db *DB) Country(ip net.IP) (string, error) {
+	var r record
+	if err := db.reader.Lookup(ip, &r); err != nil {
+		return "", fmt.Errorf("geoip lookup error: %w", err)
+	}
+	if r.Country.ISOCode != "" {
+		return r.Country.ISOCode, nil
+	}
+	return r.RegisteredCountry.ISOCode, nil
+}
+
+// Close closes the database
+func (db *DB) Close() error {
+	return db.reader.Close()
+}
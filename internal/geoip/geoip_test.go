@@ -0,0 +1,21 @@
+package geoip
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpen_Invalid(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Error("Open() of a missing file error = nil")
+	}
+
+	path := filepath.Join(t.TempDir(), "invalid.mmdb")
+	if err := os.WriteFile(path, []byte("not a maxmind database"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Error("Open() of an invalid file error = nil")
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// AccessLogMiddleware writes one entry per request to logger once the
+// response is done: method, path, download ID, status, bytes sent,
+// duration, client IP, request ID, and user agent. The query string is
+// left out, since it carries signatures and passwords. Responses with a
+// status below 400 are logged with probability sampleRate; errors always
+// are. It must run after RequestIDMiddleware and ClientIPMiddleware.
+func AccessLogMiddleware(logger *zap.Logger, sampleRate float64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			lw := &accessLogWriter{ResponseWriter: w}
+
+			defer func() {
+				// Log downloads aborted mid-stream too, then let the server
+				// reset the connection as before
+				panicked := recover()
+				status := lw.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				if panicked != nil || status >= 400 || sampleRate >= 1 || rand.Float64() < sampleRate {
+					logger.Info("request",
+						zap.String("method", r.Method),
+						zap.String("path", r.URL.Path),
+						zap.String("id", mux.Vars(r)["id"]),
+						zap.Int("status", status),
+						zap.Int64("bytes", lw.bytes),
+						zap.Duration("duration", time.Since(start)),
+						zap.String("client_ip", GetClientIP(r)),
+						zap.String("request_id", GetRequestID(r.Context())),
+						zap.String("user_agent", r.UserAgent()),
+						zap.Bool("aborted", panicked != nil),
+					)
+				}
+				if panicked != nil {
+					panic(panicked)
+				}
+			}()
+
+			next.ServeHTTP(lw, r)
+		})
+	}
+}
+
+// accessLogWriter records the status and body size of a response
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int   // first final status written, 0 = none yet
+	bytes  int64 // body bytes written
+}
+
+func (w *accessLogWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the underlying writer's io.ReaderFrom, so staged archives
+// are still sent with sendfile
+func (w *accessLogWriter) ReadFrom(src io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := io.Copy(w.ResponseWriter, src)
+	w.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g.
+// to flush progress events
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		sampleRate float64
+		handler    http.HandlerFunc
+		wantLogged bool
+		wantStatus int64
+		wantBytes  int64
+	}{
+		{
+			name:       "implicit 200",
+			sampleRate: 1,
+			handler:    func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("alpha")) },
+			wantLogged: true,
+			wantStatus: 200,
+			wantBytes:  5,
+		},
+		{
+			name:       "error",
+			sampleRate: 1,
+			handler:    func(w http.ResponseWriter, r *http.Request) { http.Error(w, "not found", http.StatusNotFound) },
+			wantLogged: true,
+			wantStatus: 404,
+			wantBytes:  10,
+		},
+		{
+			name:       "success sampled out",
+			handler:    func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("alpha")) },
+			wantLogged: false,
+		},
+		{
+			name:       "error never sampled out",
+			handler:    func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusServiceUnavailable) },
+			wantLogged: true,
+			wantStatus: 503,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.InfoLevel)
+			router := mux.NewRouter()
+			router.Use(RequestIDMiddleware, ClientIPMiddleware(nil, ""), AccessLogMiddleware(zap.New(core), tt.sampleRate))
+			router.Handle("/{id}", tt.handler)
+
+			req := httptest.NewRequest("GET", "/123?signature=secret", nil)
+			req.Header.Set("X-Request-ID", "req-1")
+			req.Header.Set("User-Agent", "curl/8.0")
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got := logs.Len() == 1; got != tt.wantLogged {
+				t.Fatalf("logged %d entries, want logged = %v", logs.Len(), tt.wantLogged)
+			}
+			if !tt.wantLogged {
+				return
+			}
+			fields := logs.All()[0].ContextMap()
+			want := map[string]interface{}{
+				"method":     "GET",
+				"path":       "/123",
+				"id":         "123",
+				"status":     tt.wantStatus,
+				"bytes":      tt.wantBytes,
+				"client_ip":  "192.0.2.1",
+				"request_id": "req-1",
+				"user_agent": "curl/8.0",
+				"aborted":    false,
+			}
+			for key, value := range want {
+				if fields[key] != value {
+					t.Errorf("%s = %v, want %v", key, fields[key], value)
+				}
+			}
+			if _, ok := fields["duration"].(time.Duration); !ok {
+				t.Errorf("duration = %v, want a duration", fields["duration"])
+			}
+		})
+	}
+}
+
+func TestAccessLogMiddleware_Aborted(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	handler := AccessLogMiddleware(zap.New(core), 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic(http.ErrAbortHandler)
+	}))
+
+	func() {
+		defer func() {
+			if panicked := recover(); panicked != http.ErrAbortHandler {
+				t.Errorf("panic = %v, want http.ErrAbortHandler", panicked)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/123", nil))
+	}()
+
+	if logs.Len() != 1 || logs.All()[0].ContextMap()["aborted"] != true {
+		t.Errorf("aborted download not logged: %v", logs.All())
+	}
+}
+
+func TestAccessLogWriter_Unwrap(t *testing.T) {
+	w := httptest.NewRecorder()
+	lw := &accessLogWriter{ResponseWriter: w}
+	if err := http.NewResponseController(lw).Flush(); err != nil {
+		t.Errorf("Flush() through the access log writer error = %v", err)
+	}
+	if !w.Flushed {
+		t.Error("underlying writer was not flushed")
+	}
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// errCancelledByOperator is the cause of a download's context when it was
+// cancelled through the admin listener
+var errCancelledByOperator = errors.New("download cancelled by operator")
+
+// errShuttingDown is the cause of a download's context when it was still
+// streaming once the shutdown drain timeout ran out
+var errShuttingDown = errors.New("download cut off by shutdown")
+
+// ActiveDownload is a snapshot of a download being streamed, as listed at
+// /admin/downloads
+type ActiveDownload struct {
+	RequestID      string  `json:"request_id"`
+	ID             string  `json:"id"`
+	ClientIP       string  `json:"client_ip"`
+	BytesSent      int64   `json:"bytes_sent"`
+	FilesCompleted int64   `json:"files_completed"`
+	FilesTotal     int     `json:"files_total"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+// activeDownload is a download in progress, with what's needed to report
+// on it and cancel it
+type activeDownload struct {
+	requestID  string
+	id         string
+	clientIP   string
+	filesTotal int
+	started    time.Time
+	bytes      atomic.Int64
+	files      atomic.Int64
+	ctx        context.Context
+	cancel     context.CancelCauseFunc
+}
+
+// err returns why the download was cancelled, or nil if it wasn't or a is nil
+func (a *activeDownload) err() error {
+	if a == nil || a.ctx.Err() == nil {
+		return nil
+	}
+	return context.Cause(a.ctx)
+}
+
+// addBytes counts bytes sent to the client; a nil download ignores them
+func (a *activeDownload) addBytes(n int64) {
+	if a != nil {
+		a.bytes.Add(n)
+	}
+}
+
+// fileDone counts an object fetched from storage; a nil download ignores it
+func (a *activeDownload) fileDone() {
+	if a != nil {
+		a.files.Add(1)
+	}
+}
+
+type activeDownloadKey struct{}
+
+// activeFromContext returns the download a request's context belongs to, or nil
+func activeFromContext(ctx context.Context) *activeDownload {
+	a, _ := ctx.Value(activeDownloadKey{}).(*activeDownload)
+	return a
+}
+
+// activeTracker holds the downloads being streamed
+type activeTracker struct {
+	mu        sync.Mutex
+	downloads map[*activeDownload]struct{}
+	draining  atomic.Bool // set on shutdown, new downloads are refused
+}
+
+// add registers a download of record id, returning the request with a
+// context that cancel can end, and the download to pass to remove once it
+// has finished
+func (t *activeTracker) add(r *http.Request, id string, filesTotal int) (*http.Request, *activeDownload) {
+	ctx, cancel := context.WithCancelCause(r.Context())
+	a := &activeDownload{
+		requestID:  GetRequestID(ctx),
+		id:         id,
+		clientIP:   GetClientIP(r),
+		filesTotal: filesTotal,
+		started:    time.Now(),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.downloads[a] = struct{}{}
+	return r.WithContext(context.WithValue(ctx, activeDownloadKey{}, a)), a
+}
+
+// remove forgets a finished download
+func (t *activeTracker) remove(a *activeDownload) {
+	t.mu.Lock()
+	delete(t.downloads, a)
+	t.mu.Unlock()
+	a.cancel(nil)
+}
+
+// list returns a snapshot of every active download, oldest first
+func (t *activeTracker) list() []ActiveDownload {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := make([]ActiveDownload, 0, len(t.downloads))
+	for a := range t.downloads {
+		list = append(list, ActiveDownload{
+			RequestID:      a.requestID,
+			ID:             a.id,
+			ClientIP:       a.clientIP,
+			BytesSent:      a.bytes.Load(),
+			FilesCompleted: a.files.Load(),
+			FilesTotal:     a.filesTotal,
+			ElapsedSeconds: time.Since(a.started).Seconds(),
+		})
+	}
+	slices.SortFunc(list, func(x, y ActiveDownload) int { return cmp.Compare(y.ElapsedSeconds, x.ElapsedSeconds) })
+	return list
+}
+
+// cancel ends the downloads with the given request ID, returning how many
+// there were
+func (t *activeTracker) cancel(requestID string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for a := range t.downloads {
+		if a.requestID == requestID {
+			a.cancel(errCancelledByOperator)
+			n++
+		}
+	}
+	return n
+}
+
+// cancelAll ends every download with cause, returning how many there were
+func (t *activeTracker) cancelAll(cause error) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for a := range t.downloads {
+		a.cancel(cause)
+	}
+	return len(t.downloads)
+}
+
+// count returns the number of active downloads
+func (t *activeTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.downloads)
+}
+
+// ActiveDownloads lists the downloads being streamed as JSON, oldest first:
+// record ID, request ID, client IP, bytes sent, objects fetched of the
+// total, and elapsed time. It is served on the admin listener.
+func (h *Handler) ActiveDownloads(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.active.list())
+}
+
+// CancelDownload ends the active download with the request ID in the path:
+// its context is cancelled and further writes fail, so the response is cut
+// off and the download reported as failed. It is served on the admin
+// listener.
+func (h *Handler) CancelDownload(w http.ResponseWriter, r *http.Request) {
+	requestID := r.PathValue("request_id")
+	if h.active.cancel(requestID) == 0 {
+		http.Error(w, "no active download with that request ID", http.StatusNotFound)
+		return
+	}
+	h.logger.Warn("download cancelled by operator", zap.String("request_id", requestID), zap.String("client_ip", GetClientIP(r)))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Drain refuses downloads that start from now on with 503, for a graceful
+// shutdown, while those in progress carry on. Tenant profiles' handlers
+// share the downloads, so they're drained too.
+func (h *Handler) Drain() {
+	h.active.draining.Store(true)
+}
+
+// ActiveCount returns the number of downloads being streamed
+func (h *Handler) ActiveCount() int {
+	return h.active.count()
+}
+
+// CutOff ends every download still streaming once the shutdown drain
+// timeout has run out, as CancelDownload does, and returns how many there
+// were
+func (h *Handler) CutOff() int {
+	n := h.active.cancelAll(errShuttingDown)
+	h.metrics.ShutdownCutOffTotal.Add(float64(n))
+	return n
+}
+
+// logCancelled logs why a download's context ended early: an operator's
+// cancel, a shutdown, or else a client disconnect, which is also counted
+func (h *Handler) logCancelled(ctx context.Context, id string) {
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, errCancelledByOperator):
+		h.log(ctx).Warn("download cancelled by operator", zap.String("id", id))
+		return
+	case errors.Is(cause, errShuttingDown):
+		h.log(ctx).Warn("download cut off by shutdown", zap.String("id", id))
+		return
+	}
+	h.metrics.ClientDisconnectsTotal.Inc()
+	h.log(ctx).Warn("client disconnected", zap.String("id", id), zap.Error(ctx.Err()))
+}
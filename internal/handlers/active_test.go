@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/auth"
+	"zipperfly/internal/models"
+	"zipperfly/internal/storage"
+)
+
+func TestActiveTracker(t *testing.T) {
+	tracker := &activeTracker{downloads: make(map[*activeDownload]struct{})}
+	newRequest := func(requestID string) *http.Request {
+		r := httptest.NewRequest("GET", "/test", nil)
+		return r.WithContext(context.WithValue(r.Context(), requestIDKey, requestID))
+	}
+
+	r1, a1 := tracker.add(newRequest("req-1"), "first", 3)
+	time.Sleep(time.Millisecond)
+	_, a2 := tracker.add(newRequest("req-2"), "second", 1)
+	a1.addBytes(100)
+	a1.fileDone()
+
+	list := tracker.list()
+	if len(list) != 2 {
+		t.Fatalf("list() has %d downloads, want 2", len(list))
+	}
+	if got := list[0]; got.RequestID != "req-1" || got.ID != "first" || got.BytesSent != 100 || got.FilesCompleted != 1 || got.FilesTotal != 3 {
+		t.Errorf("list()[0] = %+v, want the oldest download with its progress", got)
+	}
+
+	if n := tracker.cancel("req-1"); n != 1 {
+		t.Errorf("cancel(req-1) = %d, want 1", n)
+	}
+	if !errors.Is(context.Cause(r1.Context()), errCancelledByOperator) || !errors.Is(a1.err(), errCancelledByOperator) {
+		t.Errorf("cancelled download's cause = %v, want errCancelledByOperator", context.Cause(r1.Context()))
+	}
+	if a2.err() != nil {
+		t.Errorf("other download's err() = %v, want nil", a2.err())
+	}
+	if n := tracker.cancel("req-3"); n != 0 {
+		t.Errorf("cancel(req-3) = %d, want 0", n)
+	}
+
+	tracker.remove(a1)
+	tracker.remove(a2)
+	if list := tracker.list(); len(list) != 0 {
+		t.Errorf("list() after remove = %+v, want empty", list)
+	}
+}
+
+// blockingStorage serves objects whose bodies block until the download's
+// context is done, like an S3 stream of a huge object
+type blockingStorage struct {
+	mockDownloadStorage
+}
+
+func (s *blockingStorage) GetObject(ctx context.Context, bucket, key string) (*storage.Object, error) {
+	return &storage.Object{ReadCloser: io.NopCloser(ctxReader{ctx}), Size: -1}, nil
+}
+
+type ctxReader struct{ ctx context.Context }
+
+func (r ctxReader) Read([]byte) (int, error) {
+	<-r.ctx.Done()
+	return 0, r.ctx.Err()
+}
+
+func TestHandler_CancelDownload(t *testing.T) {
+	db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+		"test": {ID: "test", Bucket: "bucket", Objects: []string{"huge.bin"}},
+	}}
+	verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+	h := NewDownloadHandler(zap.NewNop(), db, &blockingStorage{}, verifier, sharedMetrics, HandlerOptions{
+		MaxConcurrent:    10,
+		SpoolMemoryLimit: 1 << 20,
+		SanitizeCharset:  "ascii",
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	req = mux.SetURLVars(req, map[string]string{"id": "test"})
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		RequestIDMiddleware(http.HandlerFunc(h.Download)).ServeHTTP(w, req)
+	}()
+
+	// Wait for the download to be listed
+	var list []ActiveDownload
+	for deadline := time.Now().Add(5 * time.Second); len(list) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("download never listed")
+		}
+		lw := httptest.NewRecorder()
+		h.ActiveDownloads(lw, httptest.NewRequest("GET", "/admin/downloads", nil))
+		if err := json.NewDecoder(lw.Body).Decode(&list); err != nil {
+			t.Fatalf("failed to decode list: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if list[0].RequestID != "req-1" || list[0].ID != "test" || list[0].FilesTotal != 1 {
+		t.Errorf("listed %+v, want req-1 for record test", list[0])
+	}
+
+	cancel := func(requestID string) int {
+		cr := httptest.NewRequest("DELETE", "/admin/downloads/"+requestID, nil)
+		cr.SetPathValue("request_id", requestID)
+		cw := httptest.NewRecorder()
+		h.CancelDownload(cw, cr)
+		return cw.Code
+	}
+	if code := cancel("req-2"); code != http.StatusNotFound {
+		t.Errorf("cancel of an unknown download = %d, want %d", code, http.StatusNotFound)
+	}
+	if code := cancel("req-1"); code != http.StatusNoContent {
+		t.Errorf("cancel = %d, want %d", code, http.StatusNoContent)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("download still running after cancel")
+	}
+	if got := w.Result().Trailer.Get(StatusTrailer); got != "failed" {
+		t.Errorf("%s trailer = %q, want failed", StatusTrailer, got)
+	}
+	if list := h.active.list(); len(list) != 0 {
+		t.Errorf("active downloads after cancel = %+v, want none", list)
+	}
+}
+
+func TestHandler_ForTenant(t *testing.T) {
+	base := NewDownloadHandler(zap.NewNop(), nil, nil, nil, sharedMetrics, HandlerOptions{MaxFileSize: 1})
+	tenant := base.ForTenant(nil, nil, nil, HandlerOptions{MaxFileSize: 2})
+
+	if tenant.maxFileSize != 2 {
+		t.Errorf("tenant maxFileSize = %d, want its own option", tenant.maxFileSize)
+	}
+	if tenant.active != base.active {
+		t.Error("tenant doesn't share the base handler's active downloads")
+	}
+}
+
+func TestHandler_DrainAndCutOff(t *testing.T) {
+	db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+		"test": {ID: "test", Bucket: "bucket", Objects: []string{"huge.bin"}},
+	}}
+	verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+	h := NewDownloadHandler(zap.NewNop(), db, &blockingStorage{}, verifier, sharedMetrics, HandlerOptions{
+		MaxConcurrent:    10,
+		SpoolMemoryLimit: 1 << 20,
+		SanitizeCharset:  "ascii",
+	})
+	tenant := h.ForTenant(db, &blockingStorage{}, verifier, HandlerOptions{MaxConcurrent: 10, SpoolMemoryLimit: 1 << 20, SanitizeCharset: "ascii"})
+
+	download := func(h *Handler) *httptest.ResponseRecorder {
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/test", nil), map[string]string{"id": "test"})
+		w := httptest.NewRecorder()
+		h.Download(w, req)
+		return w
+	}
+
+	// A download in progress when draining starts
+	var inProgress *httptest.ResponseRecorder
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		inProgress = download(h)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); h.ActiveCount() == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("download never started")
+		}
+	}
+
+	h.Drain()
+	for _, handler := range []*Handler{h, tenant} {
+		w := download(handler)
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Connection") != "close" {
+			t.Errorf("download while draining = %d, Connection %q, want %d and close", w.Code, w.Header().Get("Connection"), http.StatusServiceUnavailable)
+		}
+	}
+	if n := h.ActiveCount(); n != 1 {
+		t.Errorf("ActiveCount() while draining = %d, want the download in progress", n)
+	}
+
+	if n := h.CutOff(); n != 1 {
+		t.Errorf("CutOff() = %d, want 1", n)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("download still running after CutOff")
+	}
+	if got := inProgress.Result().Trailer.Get(StatusTrailer); got != "failed" {
+		t.Errorf("%s trailer = %q, want failed", StatusTrailer, got)
+	}
+	if n := h.ActiveCount(); n != 0 {
+		t.Errorf("ActiveCount() after CutOff = %d, want 0", n)
+	}
+}
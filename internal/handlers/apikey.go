@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/auth"
+	"zipperfly/internal/limits"
+	"zipperfly/internal/metrics"
+)
+
+// NewRateFunc creates a rate of perSecond per key, allowing bursts of
+// burst. name separates it from other rates sharing a store.
+type NewRateFunc func(name string, perSecond float64, burst int) limits.Rate
+
+// APIKeyAuth authenticates requests carrying an API key, in place of a
+// signature, and limits each key's request rate
+type APIKeyAuth struct {
+	limiter     *RateLimiter // writes 429 responses
+	keys        auth.KeyStore
+	defaultRate float64 // 0 = keys without their own rate are unlimited
+	newRate     NewRateFunc
+
+	mu    sync.Mutex
+	rates map[float64]limits.Rate // one per distinct rate
+}
+
+// NewAPIKeyAuth creates API key authentication looking keys up in keys.
+// Keys are limited to their own rate, or defaultRate when they set none,
+// through rates made by newRate.
+func NewAPIKeyAuth(logger *zap.Logger, m *metrics.Metrics, keys auth.KeyStore, defaultRate float64, newRate NewRateFunc) *APIKeyAuth {
+	return &APIKeyAuth{
+		limiter:     NewRateLimiter(logger, m, nil, nil),
+		keys:        keys,
+		defaultRate: defaultRate,
+		newRate:     newRate,
+		rates:       make(map[float64]limits.Rate),
+	}
+}
+
+// Middleware passes requests without an API key on unchanged. A request
+// with one is refused with 401 if the key is unknown and 429 if the key is
+// over its rate; otherwise its context carries the key (auth.WithAPIKey),
+// which stands in for a signature.
+func (a *APIKeyAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(auth.APIKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		logger, m := a.limiter.logger, a.limiter.metrics
+		apiKey, err := a.keys.LookupAPIKey(r.Context(), auth.HashAPIKey(key))
+		if err != nil {
+			logger.Error("api key lookup failed", zap.Error(err))
+			http.Error(w, "api key lookup failed", http.StatusServiceUnavailable)
+			m.RequestsTotal.WithLabelValues("503").Inc()
+			return
+		}
+		if apiKey == nil {
+			logger.Warn("request rejected: unknown api key", zap.String("path", r.URL.Path))
+			http.Error(w, "invalid api key", http.StatusUnauthorized)
+			m.RequestsTotal.WithLabelValues("401").Inc()
+			m.SignatureFailuresTotal.Inc()
+			return
+		}
+
+		if rate := a.rate(apiKey.RateLimit); a.limiter.throttled(w, r, rate, apiKey.Name, zap.String("api_key", apiKey.Name)) {
+			return
+		}
+		m.APIKeyRequestsTotal.WithLabelValues(apiKey.Name).Inc()
+		next.ServeHTTP(w, r.WithContext(auth.WithAPIKey(r.Context(), apiKey)))
+	})
+}
+
+// rate returns the limit for a key with the given rate, nil if unlimited
+func (a *APIKeyAuth) rate(perSecond float64) limits.Rate {
+	if perSecond == 0 {
+		perSecond = a.defaultRate
+	}
+	if perSecond <= 0 {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rate, ok := a.rates[perSecond]
+	if !ok {
+		burst := max(int(math.Ceil(perSecond)), 1)
+		rate = a.newRate("apikey:"+strconv.FormatFloat(perSecond, 'g', -1, 64), perSecond, burst)
+		a.rates[perSecond] = rate
+	}
+	return rate
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/auth"
+	"zipperfly/internal/limits"
+	"zipperfly/internal/models"
+)
+
+// failingKeys is an auth.KeyStore whose store is down
+type failingKeys struct{}
+
+func (failingKeys) LookupAPIKey(context.Context, string) (*models.APIKey, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestAPIKeyAuth_Middleware(t *testing.T) {
+	keys := auth.NewStaticKeys(map[string]models.APIKey{
+		"billing-key": {Name: "billing"},
+		"reports-key": {Name: "reports", RateLimit: 0.5},
+		"batch-key":   {Name: "batch", RateLimit: -1},
+	})
+	newRate := func(_ string, perSecond float64, burst int) limits.Rate {
+		return limits.NewLocalRate(perSecond, burst)
+	}
+
+	tests := []struct {
+		name        string
+		keys        auth.KeyStore
+		defaultRate float64
+		requests    []string // API key of each request, "" = none
+		want        []int
+		wantKey     string // key name the handler sees on success
+	}{
+		{name: "no key", keys: keys, requests: []string{""}, want: []int{http.StatusOK}},
+		{name: "valid key", keys: keys, requests: []string{"billing-key"}, want: []int{http.StatusOK}, wantKey: "billing"},
+		{name: "unknown key", keys: keys, requests: []string{"guess"}, want: []int{http.StatusUnauthorized}},
+		{
+			name:        "default rate",
+			keys:        keys,
+			defaultRate: 0.5,
+			requests:    []string{"billing-key", "billing-key", "reports-key"},
+			want:        []int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK},
+		},
+		{
+			name:     "key's own rate",
+			keys:     keys,
+			requests: []string{"reports-key", "reports-key", "billing-key", "billing-key"},
+			want:     []int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:        "unlimited key",
+			keys:        keys,
+			defaultRate: 0.5,
+			requests:    []string{"batch-key", "batch-key"},
+			want:        []int{http.StatusOK, http.StatusOK},
+		},
+		{name: "store down", keys: failingKeys{}, requests: []string{"billing-key"}, want: []int{http.StatusServiceUnavailable}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotKey *models.APIKey
+			apiKeys := NewAPIKeyAuth(zap.NewNop(), sharedMetrics, tt.keys, tt.defaultRate, newRate)
+			handler := apiKeys.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotKey = auth.APIKeyFromContext(r.Context())
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			for i, key := range tt.requests {
+				r := httptest.NewRequest(http.MethodGet, "/abc", nil)
+				if key != "" {
+					r.Header.Set(auth.APIKeyHeader, key)
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+
+				if w.Code != tt.want[i] {
+					t.Fatalf("request %d: status = %d, want %d", i+1, w.Code, tt.want[i])
+				}
+			}
+			if tt.wantKey != "" && (gotKey == nil || gotKey.Name != tt.wantKey) {
+				t.Errorf("handler saw API key %+v, want %s", gotKey, tt.wantKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"zipperfly/internal/models"
+)
+
+// errNotAuthorized is returned when the authorization webhook denies a download
+var errNotAuthorized = errors.New("download not authorized")
+
+// authorizeDownload asks the authorization webhook whether the download may proceed.
+// Only a 200 response grants access; any other status returns errNotAuthorized.
+func (h *Handler) authorizeDownload(ctx context.Context, r *http.Request, id string) error {
+	query := make(map[string]string)
+	for key, values := range r.URL.Query() {
+		if len(values) > 0 {
+			query[key] = values[0]
+		}
+	}
+
+	body, err := json.Marshal(models.AuthorizationRequest{
+		ID:       id,
+		ClientIP: GetClientIP(r),
+		Query:    query,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", h.authWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("request creation error: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if requestID := GetRequestID(ctx); requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
+
+	resp, err := h.authClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("send error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: webhook returned %d", errNotAuthorized, resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/auth"
+	"zipperfly/internal/models"
+)
+
+func TestHandler_AuthorizeDownload(t *testing.T) {
+	tests := []struct {
+		name       string
+		serverCode int
+		wantErr    bool
+		wantDenied bool
+	}{
+		{
+			name:       "webhook allows",
+			serverCode: http.StatusOK,
+		},
+		{
+			name:       "webhook denies",
+			serverCode: http.StatusForbidden,
+			wantErr:    true,
+			wantDenied: true,
+		},
+		{
+			name:       "non-200 success code is not enough",
+			serverCode: http.StatusNoContent,
+			wantErr:    true,
+			wantDenied: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got models.AuthorizationRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != "POST" {
+					t.Errorf("method = %s, want POST", r.Method)
+				}
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Errorf("failed to decode payload: %v", err)
+				}
+				w.WriteHeader(tt.serverCode)
+			}))
+			defer server.Close()
+
+			h := &Handler{
+				authWebhookURL: server.URL,
+				authClient:     &http.Client{Timeout: time.Second},
+			}
+
+			req := httptest.NewRequest("GET", "/test?expiry=123&seat=42", nil)
+			req.RemoteAddr = "203.0.113.7:5555"
+
+			err := h.authorizeDownload(req.Context(), req, "test")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("authorizeDownload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, errNotAuthorized) != tt.wantDenied {
+				t.Errorf("errors.Is(err, errNotAuthorized) = %v, want %v", !tt.wantDenied, tt.wantDenied)
+			}
+
+			if got.ID != "test" {
+				t.Errorf("payload ID = %q, want %q", got.ID, "test")
+			}
+			if got.ClientIP != "203.0.113.7" {
+				t.Errorf("payload ClientIP = %q, want %q", got.ClientIP, "203.0.113.7")
+			}
+			if got.Query["seat"] != "42" || got.Query["expiry"] != "123" {
+				t.Errorf("unexpected payload query: %#v", got.Query)
+			}
+		})
+	}
+}
+
+func TestHandler_AuthorizeDownload_Unreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	h := &Handler{
+		authWebhookURL: url,
+		authClient:     &http.Client{Timeout: time.Second},
+	}
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	err := h.authorizeDownload(req.Context(), req, "test")
+	if err == nil {
+		t.Fatal("expected error for unreachable webhook")
+	}
+	if errors.Is(err, errNotAuthorized) {
+		t.Errorf("transport failure should not be reported as a denial: %v", err)
+	}
+}
+
+func TestHandler_Download_AuthWebhook(t *testing.T) {
+	tests := []struct {
+		name       string
+		serverCode int
+		wantStatus int
+	}{
+		{name: "allowed", serverCode: http.StatusOK, wantStatus: http.StatusOK},
+		{name: "denied", serverCode: http.StatusPaymentRequired, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.serverCode)
+			}))
+			defer server.Close()
+
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {ID: "test", Bucket: "bucket", Objects: []string{"file.txt"}},
+			}}
+			storage := &mockDownloadStorage{files: map[string]string{"bucket:file.txt": "data"}}
+			verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				MaxConcurrent:      10,
+				AuthWebhookURL:     server.URL,
+				AuthWebhookTimeout: time.Second,
+				SpoolMemoryLimit:   1 << 20,
+				SanitizeCharset:    "ascii",
+			})
+
+			req := httptest.NewRequest("GET", "/test", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			h.Download(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"zipperfly/internal/archive"
+	"zipperfly/internal/models"
+)
+
+// BuildOptions are the parameters of a build that a download takes from its
+// request
+type BuildOptions struct {
+	Format   string // archive format, as ?format=; "" = the record's
+	Files    string // file selection, as ?files=; "" = the whole record
+	Password string // ZIP password, for records with password_hash or password_required
+}
+
+// BuildResult describes a finished build
+type BuildResult struct {
+	Format    archive.Format
+	Raw       bool // the record's single object was written as itself
+	FileCount int  // objects written
+	FileTotal int  // objects requested, after the selection and extension filters
+	SizeBytes int64
+	Files     []models.FileResult // each object's outcome, with CALLBACK_FILE_RESULTS
+}
+
+// Build writes record's archive to w with the same settings and pipeline
+// as a download of it, for offline exports. It skips what only applies to
+// a request: signatures, access policies, download limits and counts,
+// download slots, and callbacks. A raw record's object is written as
+// itself.
+func (h *Handler) Build(ctx context.Context, w io.Writer, record *models.DownloadRecord, opts BuildOptions) (*BuildResult, error) {
+	format, err := archive.ParseFormat(opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Format == "" && record.Format != "" {
+		if format, err = archive.ParseFormat(record.Format); err != nil {
+			return nil, fmt.Errorf("invalid record format: %w", err)
+		}
+	}
+
+	// The record's objects are narrowed below, so work on a copy
+	copied := *record
+	record = &copied
+
+	if !h.isBucketAllowed(record.Bucket) {
+		return nil, fmt.Errorf("bucket %q not allowed", record.Bucket)
+	}
+	if opts.Files != "" {
+		if record.Objects, err = selectObjects(record.Objects, opts.Files); err != nil {
+			return nil, err
+		}
+	}
+	if h.maxFilesPerRequest > 0 && len(record.Objects) > h.maxFilesPerRequest {
+		return nil, fmt.Errorf("too many files: requested %d, max %d", len(record.Objects), h.maxFilesPerRequest)
+	}
+	if len(record.Objects) == 0 && len(record.Directories) == 0 && !h.allowEmptyRecords {
+		return nil, errors.New("record has no files")
+	}
+	if len(record.Objects) > 0 {
+		if record.Objects = h.filterFilesByExtension(record.Objects); len(record.Objects) == 0 {
+			return nil, errors.New("no allowed files in record")
+		}
+	}
+
+	zipPassword, err := h.recordPassword(record, opts.Password)
+	if err != nil {
+		return nil, err
+	}
+	if record.Raw && (len(record.Objects) != 1 || len(record.Directories) > 0) {
+		return nil, errors.New("raw records need exactly one file")
+	}
+	if zipPassword != "" && (record.Raw || !format.SupportsPassword()) {
+		return nil, errors.New("password-protected records can only be built as zip")
+	}
+
+	plan := h.archivePlan(record.ID, record, format, opts.Files, zipPassword, record.Raw)
+	result := &BuildResult{Format: format, Raw: plan.raw, FileTotal: len(record.Objects)}
+	if plan.raw {
+		result.SizeBytes, err = h.buildRaw(ctx, w, record)
+		if err != nil {
+			return nil, err
+		}
+		result.FileCount = 1
+		return result, nil
+	}
+
+	bc := &models.ByteCounter{Writer: w}
+	aw, err := archive.NewWriter(plan.format, h.limitArchiveSize(bc), plan.opts)
+	if err != nil {
+		return nil, err
+	}
+	result.FileCount, _, result.Files, err = h.buildArchive(ctx, aw, plan)
+	if err != nil {
+		aw.Close()
+		return nil, err
+	}
+	if c, ok := aw.(archive.Commenter); ok && result.FileCount < result.FileTotal {
+		c.SetComment(incompleteComment(nil))
+	}
+	if err := aw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	result.SizeBytes = bc.Count
+	return result, nil
+}
+
+// buildRaw copies a raw record's single object to w, as serveRaw does
+func (h *Handler) buildRaw(ctx context.Context, w io.Writer, record *models.DownloadRecord) (int64, error) {
+	key := record.Objects[0]
+	obj, err := h.storage.GetObject(ctx, record.Bucket, key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch %s: %w", key, err)
+	}
+	defer obj.Close()
+	if h.maxFileSize > 0 && obj.Size > h.maxFileSize {
+		return 0, fmt.Errorf("%w: %s is %d bytes, limit %d", errFileTooLarge, key, obj.Size, h.maxFileSize)
+	}
+
+	var src io.Reader = obj
+	if h.maxFileSize > 0 {
+		src = &fileSizeReader{r: obj, key: key, limit: h.maxFileSize}
+	}
+	return io.Copy(w, src)
+}
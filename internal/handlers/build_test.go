@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"slices"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/archive"
+	"zipperfly/internal/metrics"
+	"zipperfly/internal/models"
+)
+
+func TestHandler_Build(t *testing.T) {
+	store := &mockDownloadStorage{files: map[string]string{
+		"bucket:a.txt":   "alpha",
+		"bucket:b.txt":   "bravo",
+		"bucket:c.exe":   "charlie",
+		"bucket:raw.pdf": "%PDF",
+	}}
+	record := &models.DownloadRecord{ID: "rec", Bucket: "bucket", Objects: []string{"a.txt", "b.txt", "c.exe"}}
+
+	tests := []struct {
+		name       string
+		record     *models.DownloadRecord
+		opts       BuildOptions
+		handler    HandlerOptions
+		wantFormat archive.Format
+		wantFiles  []string
+		wantRaw    string
+		wantErr    bool
+	}{
+		{name: "whole record", record: record, wantFormat: archive.FormatZip, wantFiles: []string{"a.txt", "b.txt", "c.exe"}},
+		{name: "selection", record: record, opts: BuildOptions{Files: "b.txt"}, wantFormat: archive.FormatZip, wantFiles: []string{"b.txt"}},
+		{name: "extension filter", record: record, handler: HandlerOptions{BlockedExtensions: []string{".exe"}}, wantFormat: archive.FormatZip, wantFiles: []string{"a.txt", "b.txt"}},
+		{name: "format", record: record, opts: BuildOptions{Format: "tar"}, wantFormat: archive.FormatTar},
+		{name: "raw", record: &models.DownloadRecord{ID: "raw", Bucket: "bucket", Objects: []string{"raw.pdf"}, Raw: true}, wantRaw: "%PDF"},
+		{name: "bucket not allowed", record: record, handler: HandlerOptions{AllowedBuckets: []string{"other"}}, wantErr: true},
+		{name: "missing file", record: &models.DownloadRecord{ID: "missing", Bucket: "bucket", Objects: []string{"missing.txt"}}, wantErr: true},
+		{name: "password required", record: &models.DownloadRecord{ID: "locked", Bucket: "bucket", Objects: []string{"a.txt"}, PasswordRequired: true}, handler: HandlerOptions{AllowPasswordProtected: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.handler.MaxConcurrent = 2
+			h := NewDownloadHandler(zap.NewNop(), nil, store, nil, metrics.NewWithRegistry(nil), tt.handler)
+
+			var out bytes.Buffer
+			result, err := h.Build(context.Background(), &out, tt.record, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Build() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if result.SizeBytes != int64(out.Len()) {
+				t.Errorf("SizeBytes = %d, wrote %d", result.SizeBytes, out.Len())
+			}
+			if tt.wantRaw != "" {
+				if !result.Raw || out.String() != tt.wantRaw {
+					t.Errorf("Build() wrote %q (raw %v), want %q", out.String(), result.Raw, tt.wantRaw)
+				}
+				return
+			}
+			if result.Format != tt.wantFormat {
+				t.Errorf("Format = %q, want %q", result.Format, tt.wantFormat)
+			}
+			if tt.wantFormat != archive.FormatZip {
+				return
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+			if err != nil {
+				t.Fatalf("invalid zip: %v", err)
+			}
+			var names []string
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+				rc, _ := f.Open()
+				data, _ := io.ReadAll(rc)
+				rc.Close()
+				if want := store.files["bucket:"+f.Name]; string(data) != want {
+					t.Errorf("%s = %q, want %q", f.Name, data, want)
+				}
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, tt.wantFiles) {
+				t.Errorf("zip has %v, want %v", names, tt.wantFiles)
+			}
+			if result.FileCount != len(tt.wantFiles) || result.FileTotal != len(tt.wantFiles) {
+				t.Errorf("FileCount, FileTotal = %d, %d, want %d", result.FileCount, result.FileTotal, len(tt.wantFiles))
+			}
+		})
+	}
+
+	if len(record.Objects) != 3 {
+		t.Errorf("Build() changed the record's objects to %v", record.Objects)
+	}
+}
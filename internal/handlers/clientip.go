@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	clientIPKey     contextKey = "client_ip"
+	clientSchemeKey contextKey = "client_scheme"
+)
+
+// Forwarding headers ClientIP can be restricted to, e.g. with
+// CLIENT_IP_HEADER, naming the one the proxy sets
+const (
+	HeaderForwarded     = "forwarded"
+	HeaderXForwardedFor = "x-forwarded-for"
+	HeaderXRealIP       = "x-real-ip"
+)
+
+// ClientIPMiddleware resolves the client's address and the scheme it used
+// once per request, for GetClientIP and GetClientScheme. Forwarding headers
+// are only trusted from trustedProxies; without any, the client is the
+// connection's address. A non-empty header is the only forwarding header
+// read, as for ClientIP.
+func ClientIPMiddleware(trustedProxies []*net.IPNet, header string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), clientIPKey, ClientIP(r, trustedProxies, header))
+			ctx = context.WithValue(ctx, clientSchemeKey, ClientScheme(r, trustedProxies, header))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GetClientIP returns the client address ClientIPMiddleware resolved for r,
+// or the connection's address without the middleware
+func GetClientIP(r *http.Request) string {
+	if ip := clientIPFromContext(r.Context()); ip != "" {
+		return ip
+	}
+	return ClientIP(r, nil, "")
+}
+
+// clientIPFromContext returns the client address ClientIPMiddleware
+// resolved, or "" without the middleware
+func clientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey).(string)
+	return ip
+}
+
+// GetClientScheme returns the scheme, http or https, ClientIPMiddleware
+// resolved for r, or the connection's without the middleware
+func GetClientScheme(r *http.Request) string {
+	if scheme, ok := r.Context().Value(clientSchemeKey).(string); ok {
+		return scheme
+	}
+	return ClientScheme(r, nil, "")
+}
+
+// ClientIP returns the address of the client that sent r. Forwarding
+// headers are only read when the connection comes from one of
+// trustedProxies or over a unix socket: header alone if set, else
+// X-Forwarded-For, Forwarded, and X-Real-IP, in that order. A proxy that
+// appends X-Forwarded-For passes a client's own Forwarded header through
+// untouched, so it is only preferred when named. The hops of Forwarded and
+// X-Forwarded-For are walked from the right, skipping trusted proxies, so a
+// client can't choose its address by sending the header itself.
+func ClientIP(r *http.Request, trustedProxies []*net.IPNet, header string) string {
+	remote := remoteIP(r)
+	if !fromTrustedProxy(r, remote, trustedProxies) {
+		return remote
+	}
+
+	switch header {
+	case HeaderForwarded:
+		if ip := forwardedClientIP(r, trustedProxies); ip != "" {
+			return ip
+		}
+	case HeaderXForwardedFor:
+		if ip := xffClientIP(r, trustedProxies); ip != "" {
+			return ip
+		}
+	case HeaderXRealIP:
+		if ip := realIP(r); ip != "" {
+			return ip
+		}
+	default:
+		if ip := xffClientIP(r, trustedProxies); ip != "" {
+			return ip
+		}
+		if r.Header.Get("X-Forwarded-For") == "" {
+			if ip := forwardedClientIP(r, trustedProxies); ip != "" {
+				return ip
+			}
+		}
+		if ip := realIP(r); ip != "" {
+			return ip
+		}
+	}
+	return remote
+}
+
+// forwardedClientIP returns the client's hop of the Forwarded header, or ""
+func forwardedClientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	elements := forwardedElements(r.Header.Values("Forwarded"))
+	if i := clientHop(elements, trustedProxies); i >= 0 {
+		return elements[i].ip
+	}
+	return ""
+}
+
+// xffClientIP returns the client's hop of the X-Forwarded-For header, or ""
+func xffClientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	xff := r.Header.Values("X-Forwarded-For")
+	if len(xff) == 0 {
+		return ""
+	}
+	var hops []forwardedElement
+	for _, hop := range strings.Split(strings.Join(xff, ","), ",") {
+		hops = append(hops, forwardedElement{ip: strings.TrimSpace(hop)})
+	}
+	if i := clientHop(hops, trustedProxies); i >= 0 {
+		return hops[i].ip
+	}
+	return ""
+}
+
+// realIP returns the X-Real-IP header if it is an IP address, or ""
+func realIP(r *http.Request) string {
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(xri) != nil {
+		return xri
+	}
+	return ""
+}
+
+// ClientScheme returns the scheme, http or https, the client that sent r
+// used. Behind one of trustedProxies it is the proto of the Forwarded hop
+// ClientIP picks when header is Forwarded, X-Forwarded-Proto, as set by the
+// nearest proxy, when header names another, and without one
+// X-Forwarded-Proto or else the Forwarded proto; otherwise it is the
+// connection's.
+func ClientScheme(r *http.Request, trustedProxies []*net.IPNet, header string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if !fromTrustedProxy(r, remoteIP(r), trustedProxies) {
+		return scheme
+	}
+
+	if header != HeaderForwarded {
+		if proto := forwardedProto(r); proto != "" {
+			return proto
+		}
+	}
+	if header == "" || header == HeaderForwarded {
+		elements := forwardedElements(r.Header.Values("Forwarded"))
+		if i := clientHop(elements, trustedProxies); i >= 0 {
+			if proto := validScheme(elements[i].proto); proto != "" {
+				return proto
+			}
+		}
+	}
+	return scheme
+}
+
+// forwardedProto returns the nearest proxy's X-Forwarded-Proto if it is
+// http or https, or ""
+func forwardedProto(r *http.Request) string {
+	xfp := r.Header.Values("X-Forwarded-Proto")
+	if len(xfp) == 0 {
+		return ""
+	}
+	protos := strings.Split(strings.Join(xfp, ","), ",")
+	return validScheme(protos[len(protos)-1])
+}
+
+// forwardedElement is one hop of a Forwarded or X-Forwarded-For header
+type forwardedElement struct {
+	ip    string // the for parameter, without a port; "" if not an IP address
+	proto string
+}
+
+// forwardedElements parses Forwarded header values (RFC 7239) into their
+// hops, left to right. A hop whose for parameter is missing, obfuscated, or
+// "unknown" has no IP.
+func forwardedElements(values []string) []forwardedElement {
+	var elements []forwardedElement
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			var e forwardedElement
+			for _, pair := range strings.Split(element, ";") {
+				key, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				v = strings.Trim(v, `"`)
+				switch strings.ToLower(key) {
+				case "for":
+					e.ip = forwardedIP(v)
+				case "proto":
+					e.proto = v
+				}
+			}
+			elements = append(elements, e)
+		}
+	}
+	return elements
+}
+
+// forwardedIP returns the IP address of a Forwarded for parameter, e.g.
+// 192.0.2.1, 192.0.2.1:4711, or [2001:db8::1]:4711, or "" if it has none
+func forwardedIP(node string) string {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	node = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	if net.ParseIP(node) == nil {
+		return ""
+	}
+	return node
+}
+
+// clientHop returns the index of the client's hop: the rightmost that isn't
+// one of trustedProxies, or the leftmost if all are. It returns -1 if a hop
+// without a valid IP is reached first, since no address past it can be
+// trusted.
+func clientHop(hops []forwardedElement, trustedProxies []*net.IPNet) int {
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i].ip) == nil {
+			return -1
+		}
+		if !isTrustedProxy(hops[i].ip, trustedProxies) || i == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// validScheme returns proto lowercased if it is http or https, else ""
+func validScheme(proto string) string {
+	proto = strings.ToLower(strings.TrimSpace(proto))
+	if proto != "http" && proto != "https" {
+		return ""
+	}
+	return proto
+}
+
+// remoteIP returns the address of the connection r came in on
+func remoteIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return remote
+}
+
+// fromTrustedProxy reports whether r came from one of trustedProxies, or
+// over a unix socket, which only a local proxy can connect to
+func fromTrustedProxy(r *http.Request, remote string, trustedProxies []*net.IPNet) bool {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && addr.Network() == "unix" {
+		return true
+	}
+	return isTrustedProxy(remote, trustedProxies)
+}
+
+func isTrustedProxy(ip string, trustedProxies []*net.IPNet) bool {
+	addr := net.ParseIP(ip)
+	return addr != nil && containsIP(trustedProxies, addr)
+}
+
+// containsIP reports whether ip is in any of networks
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
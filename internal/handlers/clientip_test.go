@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{proxies}
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		header         map[string]string
+		trusted        []*net.IPNet
+		clientIPHeader string
+		want           string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:5555", want: "203.0.113.7"},
+		{
+			name:       "untrusted forwarding header",
+			remoteAddr: "203.0.113.7:5555",
+			header:     map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			trusted:    trusted,
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "no trusted proxies",
+			remoteAddr: "10.0.0.2:5555",
+			header:     map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.0.0.2:5555",
+			header:     map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			trusted:    trusted,
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "spoofed hop ignored",
+			remoteAddr: "10.0.0.2:5555",
+			header:     map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 10.0.0.3"},
+			trusted:    trusted,
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "all hops trusted",
+			remoteAddr: "10.0.0.2:5555",
+			header:     map[string]string{"X-Forwarded-For": "10.0.0.4, 10.0.0.3"},
+			trusted:    trusted,
+			want:       "10.0.0.4",
+		},
+		{
+			name:       "real IP",
+			remoteAddr: "10.0.0.2:5555",
+			header:     map[string]string{"X-Real-IP": "198.51.100.1"},
+			trusted:    trusted,
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "forwarded",
+			remoteAddr: "10.0.0.2:5555",
+			header:     map[string]string{"Forwarded": `for=1.2.3.4, for="198.51.100.1:4711";proto=https, for=10.0.0.3`},
+			trusted:    trusted,
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "client-injected forwarded behind an X-Forwarded-For proxy",
+			remoteAddr: "10.0.0.2:5555",
+			header:     map[string]string{"Forwarded": "for=1.2.3.4", "X-Forwarded-For": "198.51.100.1"},
+			trusted:    trusted,
+			want:       "198.51.100.1",
+		},
+		{
+			name:           "named forwarded header",
+			remoteAddr:     "10.0.0.2:5555",
+			header:         map[string]string{"Forwarded": "for=198.51.100.1", "X-Forwarded-For": "1.2.3.4"},
+			trusted:        trusted,
+			clientIPHeader: HeaderForwarded,
+			want:           "198.51.100.1",
+		},
+		{
+			name:           "only the named header is read",
+			remoteAddr:     "10.0.0.2:5555",
+			header:         map[string]string{"Forwarded": "for=1.2.3.4", "X-Real-IP": "1.2.3.5"},
+			trusted:        trusted,
+			clientIPHeader: HeaderXForwardedFor,
+			want:           "10.0.0.2",
+		},
+		{
+			name:           "named real IP",
+			remoteAddr:     "10.0.0.2:5555",
+			header:         map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-IP": "198.51.100.1"},
+			trusted:        trusted,
+			clientIPHeader: HeaderXRealIP,
+			want:           "198.51.100.1",
+		},
+		{
+			name:       "forwarded IPv6",
+			remoteAddr: "10.0.0.2:5555",
+			header:     map[string]string{"Forwarded": `For="[2001:db8::1]:4711"`},
+			trusted:    trusted,
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "obfuscated forwarded hop falls back",
+			remoteAddr: "10.0.0.2:5555",
+			header:     map[string]string{"Forwarded": "for=_hidden", "X-Forwarded-For": "198.51.100.1"},
+			trusted:    trusted,
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "untrusted forwarded",
+			remoteAddr: "203.0.113.7:5555",
+			header:     map[string]string{"Forwarded": "for=198.51.100.1"},
+			trusted:    trusted,
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "invalid real IP",
+			remoteAddr: "10.0.0.2:5555",
+			header:     map[string]string{"X-Real-IP": "unknown"},
+			trusted:    trusted,
+			want:       "10.0.0.2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/test", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			if got := ClientIP(r, tt.trusted, tt.clientIPHeader); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIP_UnixSocket(t *testing.T) {
+	// Requests over a unix socket come from a local proxy, so its headers
+	// are trusted without TRUSTED_PROXIES
+	r := httptest.NewRequest(http.MethodGet, "/test", nil)
+	r.RemoteAddr = "@"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "/run/zipperfly.sock", Net: "unix"}))
+
+	if got := ClientIP(r, nil, ""); got != "198.51.100.1" {
+		t.Errorf("ClientIP() = %q, want %q", got, "198.51.100.1")
+	}
+	if got := ClientScheme(r, nil, ""); got != "https" {
+		t.Errorf("ClientScheme() = %q, want %q", got, "https")
+	}
+}
+
+func TestClientScheme(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{proxies}
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		tls            bool
+		header         map[string]string
+		trusted        []*net.IPNet
+		clientIPHeader string
+		want           string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:5555", want: "http"},
+		{name: "direct TLS", remoteAddr: "203.0.113.7:5555", tls: true, want: "https"},
+		{
+			name:       "untrusted forwarded proto",
+			remoteAddr: "203.0.113.7:5555",
+			header:     map[string]string{"X-Forwarded-Proto": "https"},
+			trusted:    trusted,
+			want:       "http",
+		},
+		{
+			name:       "forwarded proto",
+			remoteAddr: "10.0.0.2:5555",
+			header:     map[string]string{"X-Forwarded-Proto": "HTTPS"},
+			trusted:    trusted,
+			want:       "https",
+		},
+		{
+			name:       "nearest proxy's forwarded proto",
+			remoteAddr: "10.0.0.2:5555",
+			header:     map[string]string{"X-Forwarded-Proto": "https, http"},
+			trusted:    trusted,
+			want:       "http",
+		},
+		{
+			name:       "invalid forwarded proto",
+			remoteAddr: "10.0.0.2:5555",
+			tls:        true,
+			header:     map[string]string{"X-Forwarded-Proto": "gopher"},
+			trusted:    trusted,
+			want:       "https",
+		},
+		{
+			name:           "client hop's forwarded proto",
+			remoteAddr:     "10.0.0.2:5555",
+			header:         map[string]string{"Forwarded": "for=198.51.100.1;proto=https, for=10.0.0.3;proto=http", "X-Forwarded-Proto": "http"},
+			trusted:        trusted,
+			clientIPHeader: HeaderForwarded,
+			want:           "https",
+		},
+		{
+			name:       "forwarded proto without X-Forwarded-Proto",
+			remoteAddr: "10.0.0.2:5555",
+			header:     map[string]string{"Forwarded": "for=198.51.100.1;proto=https"},
+			trusted:    trusted,
+			want:       "https",
+		},
+		{
+			name:       "client-injected forwarded proto",
+			remoteAddr: "10.0.0.2:5555",
+			header:     map[string]string{"Forwarded": "for=1.2.3.4;proto=http", "X-Forwarded-Proto": "https"},
+			trusted:    trusted,
+			want:       "https",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/test", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			if got := ClientScheme(r, tt.trusted, tt.clientIPHeader); got != tt.want {
+				t.Errorf("ClientScheme() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPMiddleware(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+
+	var got, gotScheme string
+	handler := ClientIPMiddleware([]*net.IPNet{proxies}, "")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = GetClientIP(r)
+		gotScheme = GetClientScheme(r)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/test", nil)
+	r.RemoteAddr = "10.0.0.2:5555"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if got != "198.51.100.1" {
+		t.Errorf("GetClientIP() = %q, want %q", got, "198.51.100.1")
+	}
+	if gotScheme != "https" {
+		t.Errorf("GetClientScheme() = %q, want %q", gotScheme, "https")
+	}
+
+	// Without the middleware, only the connection's address is used
+	if got := GetClientIP(r); got != "10.0.0.2" {
+		t.Errorf("GetClientIP() without middleware = %q, want %q", got, "10.0.0.2")
+	}
+}
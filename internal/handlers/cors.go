@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures CORSMiddleware
+type CORSOptions struct {
+	AllowedOrigins   []string // origins allowed, "*" = any
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration // how long browsers cache a preflight response
+}
+
+// CORSMiddleware lets pages on the allowed origins call the API with fetch
+// and read the response, its status, and the exposed headers. It answers
+// preflight requests itself, before routing, since the routes don't accept
+// OPTIONS. Requests from other origins are served without CORS headers, so
+// browsers keep their responses from the page.
+func CORSMiddleware(opts CORSOptions) func(http.Handler) http.Handler {
+	anyOrigin := slices.Contains(opts.AllowedOrigins, "*")
+	methods := strings.Join(opts.AllowedMethods, ", ")
+	headers := strings.Join(opts.AllowedHeaders, ", ")
+	exposed := strings.Join(opts.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(opts.MaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			allowed := anyOrigin || slices.Contains(opts.AllowedOrigins, origin)
+			if allowed {
+				if anyOrigin && !opts.AllowCredentials {
+					h.Set("Access-Control-Allow-Origin", "*")
+				} else {
+					h.Set("Access-Control-Allow-Origin", origin)
+				}
+				if opts.AllowCredentials {
+					h.Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+
+			if !preflight {
+				if allowed && exposed != "" {
+					h.Set("Access-Control-Expose-Headers", exposed)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			if allowed {
+				h.Set("Access-Control-Allow-Methods", methods)
+				if headers != "" {
+					h.Set("Access-Control-Allow-Headers", headers)
+				}
+				h.Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
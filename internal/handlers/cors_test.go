@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	opts := CORSOptions{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{"GET", "HEAD", "POST"},
+		AllowedHeaders: []string{"Authorization", "X-Request-ID"},
+		ExposedHeaders: []string{"Content-Disposition", "X-Request-ID"},
+		MaxAge:         10 * time.Minute,
+	}
+
+	tests := []struct {
+		name        string
+		opts        CORSOptions
+		method      string
+		origin      string
+		preflight   bool
+		wantStatus  int
+		wantOrigin  string
+		wantExposed string
+		wantMethods string
+		wantCreds   string
+	}{
+		{name: "same origin", opts: opts, method: http.MethodGet, wantStatus: http.StatusTeapot},
+		{
+			name:        "allowed origin",
+			opts:        opts,
+			method:      http.MethodGet,
+			origin:      "https://app.example.com",
+			wantStatus:  http.StatusTeapot,
+			wantOrigin:  "https://app.example.com",
+			wantExposed: "Content-Disposition, X-Request-ID",
+		},
+		{name: "other origin", opts: opts, method: http.MethodGet, origin: "https://evil.example.com", wantStatus: http.StatusTeapot},
+		{
+			name:        "preflight",
+			opts:        opts,
+			method:      http.MethodOptions,
+			origin:      "https://app.example.com",
+			preflight:   true,
+			wantStatus:  http.StatusNoContent,
+			wantOrigin:  "https://app.example.com",
+			wantMethods: "GET, HEAD, POST",
+		},
+		{name: "preflight from other origin", opts: opts, method: http.MethodOptions, origin: "https://evil.example.com", preflight: true, wantStatus: http.StatusNoContent},
+		{name: "plain OPTIONS", opts: opts, method: http.MethodOptions, origin: "https://app.example.com", wantStatus: http.StatusTeapot, wantOrigin: "https://app.example.com", wantExposed: "Content-Disposition, X-Request-ID"},
+		{
+			name:       "any origin",
+			opts:       CORSOptions{AllowedOrigins: []string{"*"}},
+			method:     http.MethodGet,
+			origin:     "https://app.example.com",
+			wantStatus: http.StatusTeapot,
+			wantOrigin: "*",
+		},
+		{
+			name:       "credentials",
+			opts:       CORSOptions{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true},
+			method:     http.MethodGet,
+			origin:     "https://app.example.com",
+			wantStatus: http.StatusTeapot,
+			wantOrigin: "https://app.example.com",
+			wantCreds:  "true",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := CORSMiddleware(tt.opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}))
+
+			r := httptest.NewRequest(tt.method, "/test", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				r.Header.Set("Access-Control-Request-Method", "POST")
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			h := w.Header()
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := h.Get("Access-Control-Expose-Headers"); got != tt.wantExposed {
+				t.Errorf("Access-Control-Expose-Headers = %q, want %q", got, tt.wantExposed)
+			}
+			if got := h.Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+				t.Errorf("Access-Control-Allow-Methods = %q, want %q", got, tt.wantMethods)
+			}
+			if got := h.Get("Access-Control-Allow-Credentials"); got != tt.wantCreds {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, tt.wantCreds)
+			}
+			if tt.preflight && tt.wantOrigin != "" && h.Get("Access-Control-Max-Age") != "600" {
+				t.Errorf("Access-Control-Max-Age = %q, want 600", h.Get("Access-Control-Max-Age"))
+			}
+		})
+	}
+}
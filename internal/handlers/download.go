@@ -3,30 +3,65 @@ package handlers
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
-	"github.com/yeka/zip"
 	"go.uber.org/zap"
-	"golang.org/x/sync/semaphore"
-	"golang.org/x/time/rate"
 
+	"zipperfly/internal/adaptive"
+	"zipperfly/internal/archive"
 	"zipperfly/internal/auth"
+	"zipperfly/internal/callbacks"
+	"zipperfly/internal/circuitbreaker"
 	"zipperfly/internal/database"
+	"zipperfly/internal/events"
+	"zipperfly/internal/limits"
 	"zipperfly/internal/metrics"
 	"zipperfly/internal/models"
+	"zipperfly/internal/retry"
+	"zipperfly/internal/schedule"
 	"zipperfly/internal/storage"
 )
 
+// Archive entry orders; an empty order means completion order
+const (
+	EntryOrderCompletion = "completion" // as fetches finish; fastest, not reproducible
+	EntryOrderRecord     = "record"     // the record's objects order
+	EntryOrderSorted     = "sorted"     // by entry name
+)
+
+// StatusTrailer is the HTTP trailer reporting how a download ended:
+// "completed", "partial", or "failed". The 200 status line goes out before
+// any file is fetched, so it can't carry a late failure.
+const StatusTrailer = "X-Zipperfly-Status"
+
+// RequestIDTrailer repeats the request's X-Request-ID next to the status
+// trailer, so a failed download's outcome and the ID to look it up by in
+// logs and callbacks arrive together
+const RequestIDTrailer = "X-Zipperfly-Request-ID"
+
+// FilesTotalHeader is the number of objects the archive should contain, and
+// FilesIncludedTrailer the number actually written; the two differ for
+// partial and failed downloads. Extra files and the manifest aren't counted.
+// Archives served from an async build send both, and the status, as headers.
+const (
+	FilesTotalHeader     = "X-Zipperfly-Files-Total"
+	FilesIncludedTrailer = "X-Zipperfly-Files-Included"
+)
+
 // Handler handles download requests
 type Handler struct {
 	logger                 *zap.Logger
@@ -38,18 +73,219 @@ type Handler struct {
 	sanitizeNames          bool
 	ignoreMissing          bool
 	maxConcurrent          int64
+	adaptive               *adaptive.Controller // nil = fixed limits
 	callbackMaxRetries     int
 	callbackRetryDelay     time.Duration
+	callbackRetryMaxDelay  time.Duration
+	storageRetryBudget     int
 	allowPasswordProtected bool
+	extensionsMu           sync.RWMutex // guards the extension lists, which a config reload replaces
 	allowedExtensions      []string
 	blockedExtensions      []string
-	maxActiveDownloads     *semaphore.Weighted
+	activeDownloads        *slotQueue // nil = unlimited
 	maxFilesPerRequest     int
-	rateLimiters           *sync.Map // map[string]*rate.Limiter
-	rateLimitPerIP         float64
+	allowedBuckets         []string
+	schedule               *schedule.Schedule // nil = always open
+	authWebhookURL         string
+	authClient             *http.Client
+	zstdLevel              int
+	zipStoreOnly           bool
+	zipStoreExtensions     []string
+	compressionLevel       int
+	zipEncryption          string
+	archiveManifest        bool
+	manifestFormat         string
+	extraFiles             map[string]string
+	entryOrder             string
+	spoolMemoryLimit       int
+	spoolDir               string
+	fileFetchTimeout       time.Duration
+	stallTimeout           time.Duration
+	flushInterval          time.Duration
+	abortOnStreamError     bool
+	asyncBuilds            bool
+	stagingDir             string
+	stagingTTL             time.Duration
+	builds                 *stagedBuilds
+	sanitizeCharset        string
+	allowEmptyRecords      bool
+	maxFileSize            int64
+	maxArchiveSize         int64
+	progressEvents         bool
+	progress               *progressTracker
+	active                 *activeTracker
+	callbackQueue          callbacks.Queue // nil = deliver callbacks in process
+	eventPublisher         events.Publisher // nil = no event bus
+	disableCallbacks       bool
+	callbackStarted        bool
+	callbackProgressBytes  int64 // 0 = no progress callbacks
+	callbackFileResults    bool
+	callbackBreaker        *circuitbreaker.Breaker // nil = no breaker
+	callbackMaxElapsed     time.Duration           // 0 = retry regardless of time
+	defaultCallbacks       models.Callbacks        // notified of every download, after the record's callbacks
+	metricsTenantLabel     string                  // "bucket" or "tenant" to label download metrics, "" = off
+	tenants                *metrics.LabelCap
+	slowDownloadThreshold  time.Duration           // 0 = don't report slow downloads
+	largeDownloadThreshold int64                   // 0 = don't report large downloads
+	records                *recordCache            // nil = no record cache
+}
+
+// HandlerOptions configures a download Handler. Each option's zero value
+// turns it off or leaves it unlimited, so callers set only what they use.
+type HandlerOptions struct {
+	AppendYMD              bool  // append the date to the download's filename
+	SanitizeNames          bool  // sanitize the download's filename
+	IgnoreMissing          bool  // skip files that can't be fetched instead of failing
+	MaxConcurrent          int64 // parallel fetches per download, 0 = one at a time
+	Adaptive               *adaptive.Controller // scales MaxConcurrent to memory and backpressure, nil = fixed
+	CallbackMaxRetries     int
+	CallbackRetryDelay     time.Duration
+	CallbackRetryMaxDelay  time.Duration // cap on the delay between callback retries, 0 = none
+	StorageRetryBudget     int           // storage retries shared by the fetches of one archive, 0 = unlimited
+	AllowPasswordProtected bool
+	AllowedExtensions      []string
+	BlockedExtensions      []string
+	ActiveDownloads        limits.Slots       // nil = unlimited
+	DownloadQueueLength    int                // downloads that may wait for a slot at once, 0 = none
+	DownloadQueueWait      time.Duration      // how long each may wait, 0 = none
+	MaxFilesPerRequest     int                // 0 = unlimited
+	AllowedBuckets         []string           // nil = any bucket
+	Schedule               *schedule.Schedule // nil = always open
+	AuthWebhookURL         string             // "" = no authorization webhook
+	AuthWebhookTimeout     time.Duration
+	ZstdLevel              int
+	ZipStoreOnly           bool
+	ZipStoreExtensions     []string
+	CompressionLevel       int
+	ZipEncryption          string
+	ArchiveManifest        bool
+	ManifestFormat         string
+	ExtraFiles             map[string]string
+	EntryOrder             string // "" = completion order
+	SpoolMemoryLimit       int    // bytes of each prefetched file kept in memory, the rest spills to SpoolDir
+	SpoolDir               string
+	FileFetchTimeout       time.Duration // 0 = no limit
+	StallTimeout           time.Duration // 0 = disabled
+	FlushInterval          time.Duration // 0 = flush only when the server's buffers fill
+	AbortOnStreamError     bool
+	AsyncBuilds            bool
+	StagingDir             string
+	StagingTTL             time.Duration
+	SanitizeCharset        string // "ascii" to also replace non-ASCII characters
+	AllowEmptyRecords      bool
+	MaxFileSize            int64 // 0 = unlimited
+	MaxArchiveSize         int64 // 0 = unlimited
+	ProgressEvents         bool
+	CallbackQueue          callbacks.Queue  // nil = deliver callbacks in process
+	EventPublisher         events.Publisher // nil = no event bus
+	DisableCallbacks       bool
+	CallbackStarted        bool
+	CallbackProgressBytes  int64 // 0 = no progress callbacks
+	CallbackFileResults    bool
+	CallbackBreaker        *circuitbreaker.Breaker // nil = no breaker
+	CallbackMaxElapsed     time.Duration           // 0 = retry regardless of time
+	DefaultCallbacks       models.Callbacks        // notified of every download, after the record's callbacks
+	MetricsTenantLabel     string                  // "bucket" or "tenant" to label download metrics, "" = off
+	MetricsTenantLimit     int
+	SlowDownloadThreshold  time.Duration // 0 = don't report slow downloads
+	LargeDownloadThreshold int64         // 0 = don't report large downloads
+	RecordCacheTTL         time.Duration // serve fetched records without a query for this long, 0 = always query
+	RecordCacheStale       time.Duration // serve cached records up to this old while the database fails, 0 = never
+	RecordCacheSize        int           // records the cache holds
+}
+
+// NewDownloadHandler creates a new download handler
+func NewDownloadHandler(logger *zap.Logger, db database.Store, storageProvider storage.Provider, verifier *auth.Verifier, m *metrics.Metrics, opts HandlerOptions) *Handler {
+	maxConcurrent := opts.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	var activeDownloads *slotQueue
+	if opts.ActiveDownloads != nil {
+		activeDownloads = newSlotQueue(opts.ActiveDownloads, opts.DownloadQueueLength, opts.DownloadQueueWait, m)
+	}
+
+	return &Handler{
+		logger:                 logger,
+		db:                     db,
+		storage:                storageProvider,
+		verifier:               verifier,
+		metrics:                m,
+		appendYMD:              opts.AppendYMD,
+		sanitizeNames:          opts.SanitizeNames,
+		ignoreMissing:          opts.IgnoreMissing,
+		maxConcurrent:          maxConcurrent,
+		adaptive:               opts.Adaptive,
+		callbackMaxRetries:     opts.CallbackMaxRetries,
+		callbackRetryDelay:     opts.CallbackRetryDelay,
+		callbackRetryMaxDelay:  opts.CallbackRetryMaxDelay,
+		storageRetryBudget:     opts.StorageRetryBudget,
+		allowPasswordProtected: opts.AllowPasswordProtected,
+		allowedExtensions:      opts.AllowedExtensions,
+		blockedExtensions:      opts.BlockedExtensions,
+		activeDownloads:        activeDownloads,
+		maxFilesPerRequest:     opts.MaxFilesPerRequest,
+		allowedBuckets:         opts.AllowedBuckets,
+		schedule:               opts.Schedule,
+		authWebhookURL:         opts.AuthWebhookURL,
+		authClient:             &http.Client{Timeout: opts.AuthWebhookTimeout},
+		zstdLevel:              opts.ZstdLevel,
+		zipStoreOnly:           opts.ZipStoreOnly,
+		zipStoreExtensions:     opts.ZipStoreExtensions,
+		compressionLevel:       opts.CompressionLevel,
+		zipEncryption:          opts.ZipEncryption,
+		archiveManifest:        opts.ArchiveManifest,
+		manifestFormat:         opts.ManifestFormat,
+		extraFiles:             opts.ExtraFiles,
+		entryOrder:             opts.EntryOrder,
+		spoolMemoryLimit:       opts.SpoolMemoryLimit,
+		spoolDir:               opts.SpoolDir,
+		fileFetchTimeout:       opts.FileFetchTimeout,
+		stallTimeout:           opts.StallTimeout,
+		flushInterval:          opts.FlushInterval,
+		abortOnStreamError:     opts.AbortOnStreamError,
+		asyncBuilds:            opts.AsyncBuilds,
+		stagingDir:             opts.StagingDir,
+		stagingTTL:             opts.StagingTTL,
+		builds:                 &stagedBuilds{builds: make(map[string]*stagedBuild)},
+		sanitizeCharset:        opts.SanitizeCharset,
+		allowEmptyRecords:      opts.AllowEmptyRecords,
+		maxFileSize:            opts.MaxFileSize,
+		maxArchiveSize:         opts.MaxArchiveSize,
+		progressEvents:         opts.ProgressEvents,
+		progress:               &progressTracker{downloads: make(map[string]*DownloadProgress)},
+		active:                 &activeTracker{downloads: make(map[*activeDownload]struct{})},
+		callbackQueue:          opts.CallbackQueue,
+		eventPublisher:         opts.EventPublisher,
+		disableCallbacks:       opts.DisableCallbacks,
+		callbackStarted:        opts.CallbackStarted,
+		callbackProgressBytes:  opts.CallbackProgressBytes,
+		callbackFileResults:    opts.CallbackFileResults,
+		callbackBreaker:        opts.CallbackBreaker,
+		callbackMaxElapsed:     opts.CallbackMaxElapsed,
+		defaultCallbacks:       opts.DefaultCallbacks,
+		metricsTenantLabel:     opts.MetricsTenantLabel,
+		tenants:                metrics.NewLabelCap(opts.MetricsTenantLimit),
+		slowDownloadThreshold:  opts.SlowDownloadThreshold,
+		largeDownloadThreshold: opts.LargeDownloadThreshold,
+		records:                newRecordCache(opts.RecordCacheTTL, opts.RecordCacheStale, opts.RecordCacheSize),
+	}
+}
+
+// ForTenant creates a download handler for a tenant profile, with its own
+// database, storage, verifier, and options. It shares h's list of active
+// downloads, so the admin listener lists and cancels every profile's, and
+// h's download slots and their queue, so a freed slot wakes any waiter.
+func (h *Handler) ForTenant(db database.Store, storageProvider storage.Provider, verifier *auth.Verifier, opts HandlerOptions) *Handler {
+	tenant := NewDownloadHandler(h.logger, db, storageProvider, verifier, h.metrics, opts)
+	tenant.active = h.active
+	tenant.activeDownloads = h.activeDownloads
+	return tenant
 }
 
-// NewHandler creates a new download handler
+// NewHandler creates a new download handler from positional options.
+//
+// Deprecated: Use NewDownloadHandler, which takes the options by name.
 func NewHandler(
 	logger *zap.Logger,
 	db database.Store,
@@ -65,176 +301,327 @@ func NewHandler(
 	allowPasswordProtected bool,
 	allowedExtensions []string,
 	blockedExtensions []string,
-	maxActiveDownloads int,
+	activeDownloads limits.Slots,
 	maxFilesPerRequest int,
-	rateLimitPerIP float64,
+	allowedBuckets []string,
+	accessSchedule *schedule.Schedule,
+	authWebhookURL string,
+	authWebhookTimeout time.Duration,
+	zstdLevel int,
+	zipStoreOnly bool,
+	zipStoreExtensions []string,
+	compressionLevel int,
+	zipEncryption string,
+	archiveManifest bool,
+	manifestFormat string,
+	extraFiles map[string]string,
+	entryOrder string,
+	spoolMemoryLimit int,
+	spoolDir string,
+	fileFetchTimeout time.Duration,
+	stallTimeout time.Duration,
+	abortOnStreamError bool,
+	asyncBuilds bool,
+	stagingDir string,
+	stagingTTL time.Duration,
+	sanitizeCharset string,
+	allowEmptyRecords bool,
+	maxFileSize int64,
+	maxArchiveSize int64,
+	progressEvents bool,
+	callbackQueue callbacks.Queue,
+	eventPublisher events.Publisher,
+	disableCallbacks bool,
+	callbackStarted bool,
+	callbackProgressBytes int64,
+	callbackFileResults bool,
+	callbackBreaker *circuitbreaker.Breaker,
+	callbackMaxElapsed time.Duration,
+	defaultCallbacks models.Callbacks,
+	metricsTenantLabel string,
+	metricsTenantLimit int,
+	slowDownloadThreshold time.Duration,
+	largeDownloadThreshold int64,
 ) *Handler {
-	// Create semaphore for active download limiting (0 = unlimited)
-	var downloadSem *semaphore.Weighted
-	if maxActiveDownloads > 0 {
-		downloadSem = semaphore.NewWeighted(int64(maxActiveDownloads))
-	}
-
-	h := &Handler{
-		logger:             logger,
-		db:                 db,
-		storage:            storageProvider,
-		verifier:           verifier,
-		metrics:            m,
-		appendYMD:          appendYMD,
-		sanitizeNames:      sanitizeNames,
-		ignoreMissing:      ignoreMissing,
-		maxConcurrent:      maxConcurrent,
-		callbackMaxRetries: callbackMaxRetries,
-		callbackRetryDelay: callbackRetryDelay,
-		allowPasswordProtected: allowPasswordProtected,
-		allowedExtensions:      allowedExtensions,
-		blockedExtensions:      blockedExtensions,
-		maxActiveDownloads:     downloadSem,
-		maxFilesPerRequest:     maxFilesPerRequest,
-		rateLimitPerIP:         rateLimitPerIP,
-	}
-
-	// Initialize rate limiter map if rate limiting is enabled
-	if rateLimitPerIP > 0 {
-		h.rateLimiters = &sync.Map{}
-	}
-
-	return h
+	return NewDownloadHandler(logger, db, storageProvider, verifier, m, HandlerOptions{
+		AppendYMD:              appendYMD,
+		SanitizeNames:          sanitizeNames,
+		IgnoreMissing:          ignoreMissing,
+		MaxConcurrent:          maxConcurrent,
+		CallbackMaxRetries:     callbackMaxRetries,
+		CallbackRetryDelay:     callbackRetryDelay,
+		AllowPasswordProtected: allowPasswordProtected,
+		AllowedExtensions:      allowedExtensions,
+		BlockedExtensions:      blockedExtensions,
+		ActiveDownloads:        activeDownloads,
+		MaxFilesPerRequest:     maxFilesPerRequest,
+		AllowedBuckets:         allowedBuckets,
+		Schedule:               accessSchedule,
+		AuthWebhookURL:         authWebhookURL,
+		AuthWebhookTimeout:     authWebhookTimeout,
+		ZstdLevel:              zstdLevel,
+		ZipStoreOnly:           zipStoreOnly,
+		ZipStoreExtensions:     zipStoreExtensions,
+		CompressionLevel:       compressionLevel,
+		ZipEncryption:          zipEncryption,
+		ArchiveManifest:        archiveManifest,
+		ManifestFormat:         manifestFormat,
+		ExtraFiles:             extraFiles,
+		EntryOrder:             entryOrder,
+		SpoolMemoryLimit:       spoolMemoryLimit,
+		SpoolDir:               spoolDir,
+		FileFetchTimeout:       fileFetchTimeout,
+		StallTimeout:           stallTimeout,
+		AbortOnStreamError:     abortOnStreamError,
+		AsyncBuilds:            asyncBuilds,
+		StagingDir:             stagingDir,
+		StagingTTL:             stagingTTL,
+		SanitizeCharset:        sanitizeCharset,
+		AllowEmptyRecords:      allowEmptyRecords,
+		MaxFileSize:            maxFileSize,
+		MaxArchiveSize:         maxArchiveSize,
+		ProgressEvents:         progressEvents,
+		CallbackQueue:          callbackQueue,
+		EventPublisher:         eventPublisher,
+		DisableCallbacks:       disableCallbacks,
+		CallbackStarted:        callbackStarted,
+		CallbackProgressBytes:  callbackProgressBytes,
+		CallbackFileResults:    callbackFileResults,
+		CallbackBreaker:        callbackBreaker,
+		CallbackMaxElapsed:     callbackMaxElapsed,
+		DefaultCallbacks:       defaultCallbacks,
+		MetricsTenantLabel:     metricsTenantLabel,
+		MetricsTenantLimit:     metricsTenantLimit,
+		SlowDownloadThreshold:  slowDownloadThreshold,
+		LargeDownloadThreshold: largeDownloadThreshold,
+	})
 }
 
-// Download handles the download request
+// Download handles the download request. HEAD requests are validated the
+// same way and get the response headers, including the size when it is
+// known up front, without building the archive.
 func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
+	head := r.Method == http.MethodHead
 
-	// Check rate limit (if enabled)
-	if h.rateLimitPerIP > 0 {
-		clientIP := getClientIP(r)
-		if !h.checkRateLimit(clientIP) {
-			http.Error(w, "rate limit exceeded, please retry later", http.StatusTooManyRequests)
-			h.metrics.RequestsTotal.WithLabelValues("429").Inc()
-			h.logger.Warn("download rejected: rate limit exceeded", zap.String("ip", clientIP))
-			return
+	// Check access and maintenance windows (if configured)
+	if !h.schedule.Allowed(start) {
+		message := "downloads are unavailable outside the access window"
+		if h.schedule.InMaintenance(start) {
+			message = "scheduled maintenance in progress, please retry later"
 		}
+		if retryAfter := h.schedule.NextAllowed(start); retryAfter > 0 {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(retryAfter.Seconds())))
+		}
+		http.Error(w, message, http.StatusServiceUnavailable)
+		h.metrics.RequestsTotal.WithLabelValues("503").Inc()
+		h.log(r.Context()).Warn("download rejected: outside access window")
+		return
+	}
+
+	// Refuse new downloads while shutting down, closing the connection so
+	// the client's retry goes to another instance
+	if h.active.draining.Load() {
+		w.Header().Set("Connection", "close")
+		http.Error(w, "server shutting down, please retry", http.StatusServiceUnavailable)
+		h.metrics.RequestsTotal.WithLabelValues("503").Inc()
+		h.log(r.Context()).Warn("download rejected: server shutting down")
+		return
 	}
 
 	// Check if we're at capacity (if limit is enabled)
-	if h.maxActiveDownloads != nil {
-		if !h.maxActiveDownloads.TryAcquire(1) {
+	if !head {
+		release, ok := h.acquireDownloadSlot(r.Context())
+		if !ok {
+			w.Header().Set("Retry-After", h.activeDownloads.retryAfter())
 			http.Error(w, "server at capacity, please retry", http.StatusServiceUnavailable)
 			h.metrics.RequestsTotal.WithLabelValues("503").Inc()
-			h.logger.Warn("download rejected: server at capacity")
+			h.log(r.Context()).Warn("download rejected: server at capacity")
 			return
 		}
-		defer h.maxActiveDownloads.Release(1)
+		defer release()
 	}
 
-	// Track active downloads
-	h.metrics.ActiveDownloads.Inc()
-	defer h.metrics.ActiveDownloads.Dec()
+	// Track active downloads, and their bytes as they stream
+	var sw *streamMetricsWriter
+	if !head {
+		h.metrics.ActiveDownloads.Inc()
+		defer h.metrics.ActiveDownloads.Dec()
 
-	ctx := r.Context()
-	vars := mux.Vars(r)
-	id := vars["id"]
+		sw = &streamMetricsWriter{ResponseWriter: w, metrics: h.metrics, adaptive: h.adaptive}
+		defer sw.done()
+		w = sw
+	}
 
-	if id == "" {
-		http.Error(w, "missing id", http.StatusBadRequest)
-		h.metrics.RequestsTotal.WithLabelValues("400").Inc()
+	plan := h.planDownload(w, r)
+	if plan == nil {
 		return
 	}
-
-	query := r.URL.Query()
-	expiryStr := query.Get("expiry")
-	sig := query.Get("signature")
-
-	// Verify signature and expiry
-	if err := h.verifier.Verify(id, expiryStr, sig); err != nil {
-		statusCode := http.StatusUnauthorized
-		if strings.Contains(err.Error(), "expired") {
-			statusCode = http.StatusGone
-			h.logger.Warn("expired request", zap.String("id", id))
-		} else {
-			h.logger.Warn("verification failed", zap.String("id", id), zap.Error(err))
+	if !head && downloadLimit(plan.record) > 0 {
+		if !h.claimDownload(w, r, plan) {
+			return
 		}
-		http.Error(w, err.Error(), statusCode)
-		h.metrics.RequestsTotal.WithLabelValues(fmt.Sprintf("%d", statusCode)).Inc()
-		return
+		defer h.releaseDownload(r.Context(), plan)
 	}
 
-	// Get record from database
-	record, err := h.db.GetRecord(ctx, id)
-	if err != nil {
-		http.Error(w, "not found", http.StatusNotFound)
-		h.logger.Error("record not found", zap.Error(err), zap.String("id", id))
-		h.metrics.RequestsTotal.WithLabelValues("404").Inc()
-		return
+	// List the download on the admin listener, where it can be cancelled
+	if !head {
+		var active *activeDownload
+		r, active = h.active.add(r, plan.id, len(plan.record.Objects))
+		defer h.active.remove(active)
+		sw.active = active
 	}
+	ctx := r.Context()
 
-	// Check resource limits
-	if h.maxFilesPerRequest > 0 && len(record.Objects) > h.maxFilesPerRequest {
-		http.Error(w, fmt.Sprintf("too many files: requested %d, max %d", len(record.Objects), h.maxFilesPerRequest), http.StatusBadRequest)
-		h.logger.Warn("too many files requested", zap.String("id", id), zap.Int("requested", len(record.Objects)), zap.Int("max", h.maxFilesPerRequest))
-		h.metrics.RequestsTotal.WithLabelValues("400").Inc()
+	if plan.raw {
+		h.serveRaw(w, r, plan, start)
 		return
 	}
 
-	// Filter files by extension
-	filteredObjects := h.filterFilesByExtension(record.Objects)
-	if len(filteredObjects) == 0 {
-		http.Error(w, "no allowed files in request", http.StatusBadRequest)
-		h.logger.Warn("all files filtered by extension", zap.String("id", id), zap.Int("original", len(record.Objects)))
-		h.metrics.RequestsTotal.WithLabelValues("400").Inc()
+	// Serve an archive prepared by an async build, if one is ready
+	if h.asyncBuilds {
+		if b, ok := h.builds.get(buildKey(plan.id, plan.format, plan.files, requestPassword(r))); ok && b.status.Status == BuildStatusReady {
+			if h.serveStaged(w, r, plan, b, start) {
+				return
+			}
+		}
+	}
+
+	id, record, format := plan.id, plan.record, plan.format
+	zipEncryption := plan.encryption
+
+	// Create archive writer with byte counting
+	outBc := &models.ByteCounter{Writer: w}
+	aw, err := archive.NewWriter(format, h.limitArchiveSize(outBc), plan.opts)
+	if err != nil {
+		http.Error(w, "invalid archive settings", http.StatusInternalServerError)
+		h.log(ctx).Error("failed to create archive writer", zap.Error(err), zap.String("id", id))
+		h.metrics.RequestsTotal.WithLabelValues("500").Inc()
 		return
 	}
-	record.Objects = filteredObjects
 
-	// Prepare filename
-	filename := h.prepareFilename(record.Name)
+	h.setArchiveHeaders(w, plan)
 
-	// Apply custom headers from record (before standard headers)
-	for key, value := range record.CustomHeaders {
-		w.Header().Set(key, value)
+	// Announce the exact size when it is known up front, so clients can show
+	// progress and proxies needn't buffer a chunked response
+	contentLength := h.contentLength(plan)
+	if contentLength >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(contentLength, 10))
+	}
+
+	// An archive of known size is reproducible byte for byte when entries are
+	// written in a fixed order, so a range (e.g. a resumed download) is served by
+	// regenerating the archive and discarding the bytes outside it, as long as
+	// storage can tell whether an object was replaced since
+	var rng byteRange
+	ranged := false
+	var versions map[string]int64
+	rangeable := contentLength >= 0 && h.orderedEntries()
+	if rangeable {
+		versions, rangeable = h.objectVersions(ctx, record)
+	}
+	if rangeable {
+		etag := archiveETag(record, plan.extras, h.entryOrder, versions)
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("ETag", etag)
+
+		if header := r.Header.Get("Range"); header != "" && !head && ifRangeMatches(r, etag) {
+			rng, ranged, err = parseRange(header, contentLength)
+			if err != nil {
+				w.Header().Del("Content-Disposition")
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", contentLength))
+				http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+				h.metrics.RequestsTotal.WithLabelValues("416").Inc()
+				return
+			}
+		}
+		if ranged {
+			w.Header().Set("Content-Length", strconv.FormatInt(rng.length(), 10))
+			w.Header().Set("Content-Range", contentRange(rng, contentLength))
+			outBc.Writer = &rangeWriter{w: w, rng: rng}
+
+			// A range counts as a download when it starts, wherever it ends,
+			// so partial ranges can't sidestep max_downloads
+			h.countDownload(ctx, plan)
+		}
 	}
 
-	// Set response headers
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	if head {
+		// Release the writer (zstd runs encoder goroutines) without sending anything
+		outBc.Writer = io.Discard
+		aw.Close()
+		w.WriteHeader(http.StatusOK)
+		h.metrics.RequestsTotal.WithLabelValues("200").Inc()
+		return
+	}
+	w.Header().Set("Trailer", StatusTrailer+", "+FilesIncludedTrailer+", "+RequestIDTrailer)
+	started := h.notifyStarted(ctx, record.Callbacks, id, len(record.Objects))
+	outBc.Writer = h.withFlush(w, outBc.Writer)
+	outBc.Writer = h.withCallbackProgress(ctx, outBc.Writer, record.Callbacks, id, len(record.Objects), start)
+
+	// Publish progress for GET /{id}/progress under the request ID
+	requestID := ""
+	if h.progressEvents {
+		requestID = GetRequestID(ctx)
+		h.progress.start(requestID, id, len(record.Objects))
+		outBc.Writer = &progressWriter{w: outBc.Writer, tracker: h.progress, requestID: requestID}
+	}
 
-	// Create ZIP writer with byte counting
-	outBc := &models.ByteCounter{Writer: w}
-	zw := zip.NewWriter(outBc)
-	defer zw.Close()
+	successCount, inBytes, files, fetchErr := h.buildArchive(ctx, aw, plan)
 
-	// Determine password for ZIP encryption
-	zipPassword := ""
-	if record.Password != "" && h.allowPasswordProtected {
-		zipPassword = record.Password
-		h.logger.Debug("password protection enabled", zap.String("id", id))
+	// A range stops the build once its last byte is sent, which isn't a
+	// failure: the archive it is part of is complete
+	rangeSent := ranged && errors.Is(fetchErr, errRangeSent)
+	if rangeSent {
+		fetchErr, successCount = nil, len(record.Objects)
 	}
 
-	// Stream files from storage
-	var inBytes int64
-	successCount, fetchErr := h.streamFilesFromStorage(ctx, zw, record, &inBytes, zipPassword)
+	// Finish the archive before recording metrics so the byte counts include
+	// the central directory / trailer. In abort mode a failed archive is left
+	// unfinished, so it can't be mistaken for a complete one.
+	abort := fetchErr != nil && h.abortOnStreamError
+	if !abort {
+		// Mark incomplete ZIPs for clients that can't read HTTP trailers
+		if c, ok := aw.(archive.Commenter); ok && (fetchErr != nil || successCount < len(record.Objects)) {
+			c.SetComment(incompleteComment(fetchErr))
+		}
+		if err := aw.Close(); err != nil && fetchErr == nil && !rangeSent {
+			fetchErr = fmt.Errorf("failed to finish archive: %w", err)
+		}
+		if contentLength >= 0 && outBc.Count != contentLength && fetchErr == nil && !rangeSent {
+			fetchErr = fmt.Errorf("archive is %d bytes but %d were announced; object_sizes may be out of date", outBc.Count, contentLength)
+		}
+	}
 
-	// Check if client disconnected
+	// Check if client disconnected, or an operator cancelled the download
 	if ctx.Err() != nil {
-		h.metrics.ClientDisconnectsTotal.Inc()
-		h.logger.Warn("client disconnected", zap.String("id", id), zap.Error(ctx.Err()))
+		h.logCancelled(ctx, id)
 		// Still continue to finish the request and metrics
 	}
 
 	// Determine download status
 	status := "completed"
 	message := ""
+	limitExceeded := sizeLimitExceeded(fetchErr)
 	if fetchErr != nil {
 		status = "failed"
 		message = fetchErr.Error()
-		h.logger.Error("fetch error", zap.Error(fetchErr), zap.String("id", id))
+		h.log(ctx).Error("fetch error", zap.Error(fetchErr), zap.String("id", id))
+		if limitExceeded != "" {
+			h.metrics.SizeLimitsTotal.WithLabelValues(limitExceeded).Inc()
+		}
 	} else if successCount < len(record.Objects) {
 		// Some files were missing but we continued (ignoreMissing=true)
 		status = "partial"
 		message = fmt.Sprintf("processed %d of %d files (some files missing)", successCount, len(record.Objects))
-		h.logger.Warn("incomplete download", zap.String("id", id), zap.Int("success", successCount), zap.Int("requested", len(record.Objects)))
+		h.log(ctx).Warn("incomplete download", zap.String("id", id), zap.Int("success", successCount), zap.Int("requested", len(record.Objects)))
+	}
+	h.progress.finish(requestID, status)
+
+	// Count successful downloads against the record's limit (ranges were
+	// counted as they started)
+	if status != "failed" && ctx.Err() == nil {
+		h.countDownload(ctx, plan)
 	}
 
 	// Record metrics
@@ -242,7 +629,7 @@ func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
 
 	// Performance metrics
 	h.metrics.DurationHist.Observe(duration.Seconds())
-	h.metrics.OutgoingBytesHist.Observe(float64(outBc.Count))
+	h.metrics.OutgoingBytesHist.WithLabelValues(h.tenantLabel(record)).Observe(float64(outBc.Count))
 	h.metrics.IncomingBytesHist.Observe(float64(inBytes))
 
 	// Compression ratio (compressed/uncompressed)
@@ -250,17 +637,39 @@ func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
 		ratio := float64(outBc.Count) / float64(inBytes)
 		h.metrics.CompressionRatio.Observe(ratio)
 	}
+	if zr, ok := aw.(archive.Zip64Reporter); ok && zr.Zip64() {
+		h.metrics.Zip64ArchivesTotal.Inc()
+	}
+
+	// Report the outcome: a real error status if nothing has been sent yet
+	// (abort mode only), otherwise in the trailer after the 200 response
+	statusCode := http.StatusOK
+	if ranged {
+		statusCode = http.StatusPartialContent
+	}
+	if abort && outBc.Count == 0 {
+		statusCode = http.StatusBadGateway
+		w.Header().Del("Content-Disposition")
+		w.Header().Del("Trailer")
+		http.Error(w, "failed to build archive", statusCode)
+		abort = false
+	} else {
+		w.Header().Set(StatusTrailer, status)
+		w.Header().Set(FilesIncludedTrailer, strconv.Itoa(successCount))
+		w.Header().Set(RequestIDTrailer, GetRequestID(ctx))
+	}
 
 	// Download outcome metrics
-	h.metrics.DownloadsTotal.WithLabelValues(status).Inc()
-	h.metrics.RequestsTotal.WithLabelValues("200").Inc()
+	h.metrics.DownloadsTotal.WithLabelValues(status, h.tenantLabel(record)).Inc()
+	h.metrics.RequestsTotal.WithLabelValues(fmt.Sprintf("%d", statusCode)).Inc()
 
 	// File-level metrics
 	h.metrics.FilesRequestedHist.Observe(float64(len(record.Objects)))
 	h.metrics.FilesSuccessHist.Observe(float64(successCount))
+	h.reportThresholds(ctx, downloadSummary{id: id, status: status, duration: duration, outBytes: outBc.Count, inBytes: inBytes, files: files})
 
-	// Send callback
-	go h.sendCallbackWithRetry(record.Callback, models.CallbackPayload{
+	// Send events and callback
+	h.notifyOutcome(ctx, started, record.Callbacks, models.CallbackPayload{
 		ID:                  id,
 		Status:              status,
 		Timestamp:           time.Now().UTC().Format(time.RFC3339),
@@ -268,227 +677,538 @@ func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
 		DurationMs:          duration.Milliseconds(),
 		FileCount:           len(record.Objects),
 		CompressedSizeBytes: outBc.Count,
+		Encryption:          zipEncryption,
+		LimitExceeded:       limitExceeded,
+		Files:               files,
 	})
 
-	h.logger.Info("download handled", zap.String("id", id), zap.String("status", status), zap.Duration("duration", duration))
+	h.log(ctx).Info("download handled", zap.String("id", id), zap.String("status", status), zap.Duration("duration", duration))
+
+	if abort {
+		// Break the response so the client sees a failed transfer rather than
+		// a truncated 200: net/http skips the final chunk on HTTP/1.1 and
+		// resets the stream on HTTP/2
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// limitArchiveSize caps the archive bytes written to w at maxArchiveSize, if set
+func (h *Handler) limitArchiveSize(w io.Writer) io.Writer {
+	if h.maxArchiveSize <= 0 {
+		return w
+	}
+	return &archiveSizeWriter{w: w, limit: h.maxArchiveSize}
+}
+
+// incompleteComment is the ZIP comment marking an archive that is missing
+// files (partial) or was cut short by an error (failed)
+func incompleteComment(fetchErr error) string {
+	if fetchErr != nil {
+		return "zipperfly: INCOMPLETE ARCHIVE (failed)"
+	}
+	return "zipperfly: INCOMPLETE ARCHIVE (partial)"
+}
+
+// downloadPlan is a validated download request, ready to be built
+type downloadPlan struct {
+	id         string
+	record     *models.DownloadRecord // objects narrowed to the selection and filtered by extension
+	format     archive.Format
+	files      string // ?files= selection as requested, "" for the whole record
+	opts       archive.Options
+	encryption string // ZIP encryption method, "" unless password-protected
+	manifest   bool   // append a manifest
+	extras     []extraFile
+	raw        bool // serve the single object as itself, not in an archive
+	claimed    bool // the download was claimed as it started (limited records), and is released unless counted
+	counted    bool // the download was counted against the record's limit
 }
 
-func (h *Handler) prepareFilename(name string) string {
+// planDownload validates a download request: signature, record, limits,
+// authorization, and archive settings. On rejection it writes the error
+// response, records metrics, and returns nil.
+func (h *Handler) planDownload(w http.ResponseWriter, r *http.Request) *downloadPlan {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		h.metrics.RequestsTotal.WithLabelValues("400").Inc()
+		return nil
+	}
+
+	query := r.URL.Query()
+	files := query.Get("files")
+
+	// Validate the requested format; without one the record's format applies
+	formatParam := query.Get("format")
+	format, err := archive.ParseFormat(formatParam)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.metrics.RequestsTotal.WithLabelValues("400").Inc()
+		return nil
+	}
+
+	// Verify signature and expiry
+	identity, err := h.verifier.Authenticate(ctx, auth.RequestParams(r, id))
+	if err != nil {
+		statusCode := http.StatusUnauthorized
+		if strings.Contains(err.Error(), "expired") {
+			statusCode = http.StatusGone
+			h.log(ctx).Warn("expired request", zap.String("id", id))
+		} else {
+			h.log(ctx).Warn("verification failed", zap.String("id", id), zap.Error(err))
+		}
+		http.Error(w, err.Error(), statusCode)
+		h.metrics.RequestsTotal.WithLabelValues(fmt.Sprintf("%d", statusCode)).Inc()
+		return nil
+	}
+
+	// Get record from database, or the record cache
+	record, err := h.getRecord(ctx, id)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		h.log(ctx).Error("record not found", zap.Error(err), zap.String("id", id))
+		h.metrics.RequestsTotal.WithLabelValues("404").Inc()
+		return nil
+	}
+
+	// Enforce download limit (one-time links, etc.)
+	maxDownloads := downloadLimit(record)
+	if maxDownloads > 0 && record.DownloadCount >= maxDownloads {
+		http.Error(w, "download limit reached", http.StatusGone)
+		h.log(ctx).Warn("download limit reached", zap.String("id", id), zap.Int("count", record.DownloadCount), zap.Int("max", maxDownloads))
+		h.metrics.RequestsTotal.WithLabelValues("410").Inc()
+		return nil
+	}
+
+	// Enforce the record's own access policy
+	if err := checkAccessPolicy(GetClientIP(r), record, identity); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		h.log(ctx).Warn("record access policy rejected download", zap.String("id", id), zap.Error(err))
+		h.metrics.RequestsTotal.WithLabelValues("403").Inc()
+		return nil
+	}
+
+	if formatParam == "" && record.Format != "" {
+		if format, err = archive.ParseFormat(record.Format); err != nil {
+			http.Error(w, "invalid archive settings", http.StatusInternalServerError)
+			h.log(ctx).Error("invalid record format", zap.Error(err), zap.String("id", id))
+			h.metrics.RequestsTotal.WithLabelValues("500").Inc()
+			return nil
+		}
+	}
+
+	// Reject records that reference buckets outside the allowlist
+	if !h.isBucketAllowed(record.Bucket) {
+		http.Error(w, "bucket not allowed", http.StatusForbidden)
+		h.log(ctx).Warn("bucket not in allowlist", zap.String("id", id), zap.String("bucket", record.Bucket))
+		h.metrics.RequestsTotal.WithLabelValues("403").Inc()
+		return nil
+	}
+
+	// Narrow the record to the requested subset
+	if files != "" {
+		selected, err := selectObjects(record.Objects, files)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			h.log(ctx).Warn("invalid file selection", zap.String("id", id), zap.Error(err))
+			h.metrics.RequestsTotal.WithLabelValues("400").Inc()
+			return nil
+		}
+		record.Objects = selected
+	}
+
+	// Check resource limits
+	if h.maxFilesPerRequest > 0 && len(record.Objects) > h.maxFilesPerRequest {
+		http.Error(w, fmt.Sprintf("too many files: requested %d, max %d", len(record.Objects), h.maxFilesPerRequest), http.StatusBadRequest)
+		h.log(ctx).Warn("too many files requested", zap.String("id", id), zap.Int("requested", len(record.Objects)), zap.Int("max", h.maxFilesPerRequest))
+		h.metrics.RequestsTotal.WithLabelValues("400").Inc()
+		return nil
+	}
+
+	// Consult the authorization webhook (if configured)
+	if h.authWebhookURL != "" {
+		if err := h.authorizeDownload(ctx, r, id); err != nil {
+			statusCode := http.StatusServiceUnavailable
+			message := "authorization service unavailable"
+			if errors.Is(err, errNotAuthorized) {
+				statusCode = http.StatusForbidden
+				message = "download not authorized"
+			}
+			http.Error(w, message, statusCode)
+			h.log(ctx).Warn("authorization webhook rejected download", zap.String("id", id), zap.Error(err))
+			h.metrics.RequestsTotal.WithLabelValues(fmt.Sprintf("%d", statusCode)).Inc()
+			return nil
+		}
+	}
+
+	// Records without objects or directories are rejected unless empty archives are allowed
+	if len(record.Objects) == 0 && len(record.Directories) == 0 && !h.allowEmptyRecords {
+		http.Error(w, "record has no files", http.StatusUnprocessableEntity)
+		h.log(ctx).Warn("empty record", zap.String("id", id))
+		h.metrics.RequestsTotal.WithLabelValues("422").Inc()
+		return nil
+	}
+
+	// Filter files by extension
+	if len(record.Objects) > 0 {
+		filteredObjects := h.filterFilesByExtension(record.Objects)
+		if len(filteredObjects) == 0 {
+			http.Error(w, "no allowed files in request", http.StatusBadRequest)
+			h.log(ctx).Warn("all files filtered by extension", zap.String("id", id), zap.Int("original", len(record.Objects)))
+			h.metrics.RequestsTotal.WithLabelValues("400").Inc()
+			return nil
+		}
+		record.Objects = filteredObjects
+	}
+
+	// Determine password and method for ZIP encryption; records may override the method
+	zipPassword, err := h.zipPassword(r, record)
+	if err != nil {
+		statusCode := http.StatusForbidden
+		if errors.Is(err, errPasswordRequired) {
+			statusCode = http.StatusUnauthorized
+		}
+		http.Error(w, err.Error(), statusCode)
+		h.log(ctx).Warn("zip password rejected", zap.String("id", id), zap.Error(err))
+		h.metrics.RequestsTotal.WithLabelValues(fmt.Sprintf("%d", statusCode)).Inc()
+		return nil
+	}
+	// Raw downloads pass one object through unwrapped; ?raw= overrides the record
+	raw := record.Raw
+	if v := query.Get("raw"); v != "" {
+		if raw, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "invalid raw parameter", http.StatusBadRequest)
+			h.metrics.RequestsTotal.WithLabelValues("400").Inc()
+			return nil
+		}
+	}
+	if raw && (len(record.Objects) != 1 || len(record.Directories) > 0) {
+		http.Error(w, "raw downloads need exactly one file", http.StatusBadRequest)
+		h.log(ctx).Warn("raw download of multiple files", zap.String("id", id), zap.Int("files", len(record.Objects)))
+		h.metrics.RequestsTotal.WithLabelValues("400").Inc()
+		return nil
+	}
+
+	// Never fall back to an unencrypted format for a password-protected record
+	if zipPassword != "" && (raw || !format.SupportsPassword()) {
+		http.Error(w, "password-protected downloads are only available as zip", http.StatusBadRequest)
+		h.log(ctx).Warn("password-protected record requested as non-zip", zap.String("id", id), zap.String("format", string(format)))
+		h.metrics.RequestsTotal.WithLabelValues("400").Inc()
+		return nil
+	}
+
+	plan := h.archivePlan(id, record, format, files, zipPassword, raw)
+	if zipPassword != "" {
+		h.log(ctx).Debug("password protection enabled", zap.String("id", id), zap.String("encryption", plan.encryption))
+	}
+	return plan
+}
+
+// archivePlan returns the plan for a validated download of record: its
+// archive settings, from the record where it sets them and the server's
+// otherwise
+func (h *Handler) archivePlan(id string, record *models.DownloadRecord, format archive.Format, files, zipPassword string, raw bool) *downloadPlan {
+	// Records may override the encryption method and compression level
+	zipEncryption := ""
+	if zipPassword != "" {
+		zipEncryption = h.zipEncryption
+		if record.Encryption != "" {
+			zipEncryption = record.Encryption
+		}
+	}
+	compressionLevel := h.compressionLevel
+	if record.CompressionLevel != 0 {
+		compressionLevel = record.CompressionLevel
+	}
+
+	return &downloadPlan{
+		id:     id,
+		record: record,
+		format: format,
+		files:  files,
+		opts: archive.Options{
+			Password:         zipPassword,
+			Encryption:       archive.Encryption(zipEncryption),
+			CompressionLevel: compressionLevel,
+			ZstdLevel:        h.zstdLevel,
+			StoreOnly:        h.zipStoreOnly || record.StoreOnly,
+			StoreExtensions:  append(slices.Clip(h.zipStoreExtensions), record.StoreExtensions...),
+		},
+		encryption: zipEncryption,
+		manifest:   h.archiveManifest || record.Manifest,
+		extras:     h.extraFilesFor(record),
+		raw:        raw,
+	}
+}
+
+// setArchiveHeaders sets the record's custom headers and the archive's
+// content type, filename, and file count
+func (h *Handler) setArchiveHeaders(w http.ResponseWriter, plan *downloadPlan) {
+	// Apply custom headers from record (before standard headers)
+	for key, value := range plan.record.CustomHeaders {
+		w.Header().Set(key, value)
+	}
+
+	filename := h.prepareFilename(plan.record.Name, plan.format)
+	w.Header().Set("Content-Type", plan.format.ContentType())
+	w.Header().Set("Content-Disposition", contentDisposition(filename))
+	w.Header().Set(FilesTotalHeader, strconv.Itoa(len(plan.record.Objects)))
+	w.Header().Set("X-Accel-Buffering", "no") // stop nginx from buffering the stream
+}
+
+// buildArchive writes the plan's extra files, objects, and manifest to aw and
+// returns the number of objects written, the bytes read from storage, and,
+// with CALLBACK_FILE_RESULTS or a download threshold, each object's outcome.
+// It does not close aw.
+func (h *Handler) buildArchive(ctx context.Context, aw archive.Writer, plan *downloadPlan) (int, int64, []models.FileResult, error) {
+	// Collect a manifest of the archive contents if enabled server-wide or for this record
+	var manifest *archive.Manifest
+	if plan.manifest {
+		manifest = archive.NewManifest(plan.id)
+	}
+
+	// Add directories and extra files (legal notices, READMEs) ahead of the requested objects
+	extraErr := h.addDirectories(aw, plan.record)
+	if extraErr == nil {
+		extraErr = h.addExtraFiles(aw, plan.extras)
+	}
+
+	// Stream files from storage, noting each object's outcome for the callback
+	// if enabled, unless a range ended in the entries above. The fetches share
+	// one retry budget, so a storage outage fails the archive instead of
+	// retrying every file in turn.
+	ctx = retry.WithBudget(ctx, h.storageRetryBudget)
+	var inBytes int64
+	var results *[]models.FileResult
+	if h.collectFileResults() {
+		results = &[]models.FileResult{}
+	}
+	successCount, fetchErr := 0, extraErr
+	if !errors.Is(extraErr, errRangeSent) {
+		successCount, fetchErr = h.streamFilesFromStorage(ctx, aw, plan.record, h.reproducible(plan), &inBytes, manifest, results)
+	}
+	if extraErr != nil && fetchErr == nil {
+		fetchErr = fmt.Errorf("failed to add extra entries: %w", extraErr)
+	}
+
+	// Append the manifest last so it covers every file and omission
+	if manifest != nil && ctx.Err() == nil {
+		if err := h.addManifest(aw, manifest); err != nil && fetchErr == nil {
+			fetchErr = fmt.Errorf("failed to add manifest: %w", err)
+		}
+	}
+
+	var files []models.FileResult
+	if results != nil {
+		files = *results
+	}
+	return successCount, inBytes, files, fetchErr
+}
+
+func (h *Handler) prepareFilename(name string, format archive.Format) string {
 	filename := name
 	if filename == "" {
 		filename = "download"
 	} else if h.sanitizeNames {
-		filename = sanitizeFilename(filename)
+		filename = sanitizeFilename(filename, h.sanitizeCharset == "ascii")
 	}
 
-	// Strip .zip if present
-	if strings.HasSuffix(strings.ToLower(filename), ".zip") {
-		filename = filename[:len(filename)-4]
+	// Strip any archive extension if present
+	for _, ext := range []string{".zip", ".tar.gz", ".tgz", ".tar.zst", ".tzst", ".tar"} {
+		if strings.HasSuffix(strings.ToLower(filename), ext) {
+			filename = filename[:len(filename)-len(ext)]
+			break
+		}
 	}
 
 	if h.appendYMD {
 		filename += "-" + time.Now().Format("20060102")
 	}
 
-	filename += ".zip"
+	filename += format.Extension()
 	return filename
 }
 
-func (h *Handler) streamFilesFromStorage(
-    ctx context.Context,
-    zw *zip.Writer,
-    record *models.DownloadRecord,
-    inBytes *int64,
-    password string,
-) (int, error) {
-    sem := semaphore.NewWeighted(h.maxConcurrent)
-    var zipMu sync.Mutex
-
-    type result struct {
-        err     error
-        success bool
-    }
-    resultChan := make(chan result, len(record.Objects))
-
-    for _, obj := range record.Objects {
-        key := obj
-
-        go func(key string) {
-            if err := sem.Acquire(ctx, 1); err != nil {
-                h.metrics.FilesFetchTotal.WithLabelValues("error").Inc()
-                resultChan <- result{err: err, success: false}
-                return
-            }
-            defer sem.Release(1)
-
-            // Get object from storage provider
-            body, err := h.storage.GetObject(ctx, record.Bucket, key)
-            if err != nil {
-                if h.ignoreMissing {
-                    h.logger.Warn(
-                        "skipping missing file",
-                        zap.String("bucket", record.Bucket),
-                        zap.String("key", key),
-                        zap.Error(err),
-                    )
-                    h.metrics.FilesFetchTotal.WithLabelValues("missing").Inc()
-                    h.metrics.MissingFilesTotal.Inc()
-                    resultChan <- result{err: nil, success: false}
-                    return
-                }
-
-                h.metrics.FilesFetchTotal.WithLabelValues("error").Inc()
-                resultChan <- result{err: err, success: false}
-                return
-            }
-            defer body.Close()
-
-            // --- Serialize ZIP writing ---
-            zipMu.Lock()
-            header := &zip.FileHeader{
-                Name:   filepath.Base(key),
-                Method: zip.Deflate,
-            }
-
-            // Set password if provided
-            if password != "" {
-                header.SetPassword(password)
-            }
-
-            fw, err := zw.CreateHeader(header)
-            if err != nil {
-                zipMu.Unlock()
-                h.metrics.FilesFetchTotal.WithLabelValues("error").Inc()
-                resultChan <- result{err: err, success: false}
-                return
-            }
-
-            // Wrap writer to count bytes
-            inBc := &models.ByteCounter{Writer: fw}
-
-            // Copy data from body -> ZIP entry
-            buf := make([]byte, 32*1024)
-            for {
-                n, readErr := body.Read(buf)
-                if n > 0 {
-                    if _, writeErr := inBc.Write(buf[:n]); writeErr != nil {
-                        zipMu.Unlock()
-                        h.metrics.FilesFetchTotal.WithLabelValues("error").Inc()
-                        resultChan <- result{err: writeErr, success: false}
-                        return
-                    }
-                }
-
-                if readErr != nil {
-                    if readErr == io.EOF {
-                        break
-                    }
-
-                    zipMu.Unlock()
-                    h.metrics.FilesFetchTotal.WithLabelValues("error").Inc()
-                    resultChan <- result{err: readErr, success: false}
-                    return
-                }
-            }
-
-            zipMu.Unlock()
-            // --- end critical section ---
-
-            atomic.AddInt64(inBytes, inBc.Count)
-            h.metrics.FilesFetchTotal.WithLabelValues("success").Inc()
-            resultChan <- result{err: nil, success: true}
-        }(key)
-    }
-
-    var fetchErr error
-    successCount := 0
-
-    for range record.Objects {
-        res := <-resultChan
-        if res.success {
-            successCount++
-        } else if res.err != nil && fetchErr == nil {
-            // Store first error encountered
-            fetchErr = res.err
-        }
-    }
-
-    // If ignoring missing files, only fail if ALL files failed
-    if h.ignoreMissing && successCount == 0 && len(record.Objects) > 0 {
-        return 0, fmt.Errorf("all %d files missing or failed to fetch", len(record.Objects))
-    }
-
-    // If not ignoring missing and we had an error, return it
-    if !h.ignoreMissing && fetchErr != nil {
-        return successCount, fetchErr
-    }
-
-    return successCount, nil
+// extraFile is a static archive entry added ahead of the record's objects
+type extraFile struct {
+	name    string
+	content string
 }
 
-// sendCallbackWithRetry sends a callback with exponential backoff retry logic
-func (h *Handler) sendCallbackWithRetry(url string, payload models.CallbackPayload) {
-	if url == "" {
-		return
+// extraFilesFor merges the server's and the record's extra files, sorted by
+// name. Record entries replace server entries with the same name.
+func (h *Handler) extraFilesFor(record *models.DownloadRecord) []extraFile {
+	files := make(map[string]string, len(h.extraFiles)+len(record.ExtraFiles))
+	for name, content := range h.extraFiles {
+		files[name] = content
+	}
+	for name, content := range record.ExtraFiles {
+		if !models.IsPlainFileName(name) {
+			h.logger.Warn("skipping extra file with invalid name", zap.String("id", record.ID), zap.String("name", name))
+			continue
+		}
+		files[name] = content
 	}
 
-	for attempt := 0; attempt <= h.callbackMaxRetries; attempt++ {
-		if attempt > 0 {
-			h.metrics.CallbackRetries.Inc()
-			// Exponential backoff: callbackRetryDelay * 2^(attempt-1)
-			delay := h.callbackRetryDelay * time.Duration(1<<(attempt-1))
-			time.Sleep(delay)
-			h.logger.Info("retrying callback", zap.String("url", url), zap.Int("attempt", attempt))
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	extras := make([]extraFile, len(names))
+	for i, name := range names {
+		extras[i] = extraFile{name: name, content: files[name]}
+	}
+	return extras
+}
+
+// addExtraFiles adds extra files to the archive in order. They have no
+// modification time, so ZIP entries stay identical across requests.
+func (h *Handler) addExtraFiles(aw archive.Writer, extras []extraFile) error {
+	for _, f := range extras {
+		if _, err := aw.AddFile(archive.Entry{
+			Name: f.name,
+			Size: int64(len(f.content)),
+		}, strings.NewReader(f.content)); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		err := h.sendCallback(url, payload)
-		if err == nil {
-			h.metrics.CallbacksTotal.WithLabelValues("success").Inc()
-			return
+// addDirectories adds the record's directory entries in order. Like extra
+// files they have no modification time.
+func (h *Handler) addDirectories(aw archive.Writer, record *models.DownloadRecord) error {
+	for _, dir := range record.Directories {
+		if !models.IsDirectoryPath(dir) {
+			h.logger.Warn("skipping directory with invalid path", zap.String("id", record.ID), zap.String("directory", dir))
+			continue
+		}
+		if err := aw.AddDirectory(archive.Entry{Name: dir}); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		h.logger.Warn("callback attempt failed", zap.String("url", url), zap.Int("attempt", attempt), zap.Error(err))
+// contentLength returns the exact archive size if it is known before
+// streaming, or -1. That takes an unencrypted, store-only ZIP whose record
+// lists every object's size, and nothing that can change the size while
+// streaming: skipped missing files or a manifest.
+func (h *Handler) contentLength(plan *downloadPlan) int64 {
+	if plan.format != archive.FormatZip || plan.opts.Password != "" || !plan.opts.StoreOnly || h.ignoreMissing || plan.manifest {
+		return -1
+	}
+	record, extras := plan.record, plan.extras
 
-		// If this was the last attempt, record failure
-		if attempt == h.callbackMaxRetries {
-			h.metrics.CallbacksTotal.WithLabelValues("failure").Inc()
-			h.logger.Error("callback failed after retries", zap.String("url", url), zap.Int("total_attempts", attempt+1), zap.Error(err))
+	entries := make([]archive.Entry, 0, len(record.Directories)+len(extras)+len(record.Objects))
+	for _, dir := range record.Directories {
+		if models.IsDirectoryPath(dir) {
+			entries = append(entries, archive.Entry{Name: strings.TrimSuffix(dir, "/") + "/"})
 		}
 	}
+	for _, f := range extras {
+		entries = append(entries, archive.Entry{Name: f.name, Size: int64(len(f.content))})
+	}
+	for _, key := range record.Objects {
+		size, ok := record.ObjectSizes[key]
+		if !ok {
+			return -1
+		}
+		entries = append(entries, archive.Entry{Name: filepath.Base(key), Size: size})
+	}
+
+	size, ok := archive.StoredZipSize(entries)
+	if !ok {
+		return -1
+	}
+	return size
 }
 
-// sendCallback sends a single callback request
-func (h *Handler) sendCallback(url string, payload models.CallbackPayload) error {
-	body, err := json.Marshal(payload)
+// addManifest encodes the manifest and appends it to the archive
+func (h *Handler) addManifest(aw archive.Writer, manifest *archive.Manifest) error {
+	format, err := archive.ParseManifestFormat(h.manifestFormat)
 	if err != nil {
-		return fmt.Errorf("marshal error: %w", err)
+		return err
 	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	data, err := manifest.Encode(format)
 	if err != nil {
-		return fmt.Errorf("request creation error: %w", err)
+		return err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	_, err = aw.AddFile(archive.Entry{
+		Name:    format.FileName(),
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}, bytes.NewReader(data))
+	return err
+}
 
-	// Set a reasonable timeout for callback requests
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("send error: %w", err)
+// sendCallbackWithRetry sends a callback with exponential backoff retry logic,
+// or queues it for the callback dispatcher when a queue is configured
+func (h *Handler) sendCallbackWithRetry(callback *models.Callback, payload models.CallbackPayload) {
+	if callback == nil || callback.URL == "" {
+		return
 	}
-	defer resp.Body.Close()
+	url := callback.URL
+	logger := withRequestID(h.logger, payload.RequestID)
+
+	// Hand off to the durable queue when configured; fall back to in-process
+	// delivery if the job can't be stored
+	if h.callbackQueue != nil {
+		now := time.Now()
+		job := &models.CallbackJob{ID: uuid.NewString(), Callback: callback, Payload: payload, CreatedAt: now, NextAttempt: now}
+		err := h.callbackQueue.EnqueueCallback(context.Background(), job)
+		if err == nil {
+			return
+		}
+		logger.Error("failed to queue callback, delivering in process", zap.String("url", url), zap.Error(err))
+	}
+
+	first := time.Now()
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			h.metrics.CallbackRetries.Inc()
+			logger.Info("retrying callback", zap.String("url", url), zap.Int("attempt", attempt))
+		}
 
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("bad status: %d", resp.StatusCode)
+		err := h.sendCallback(callback, payload)
+		if err == nil {
+			h.metrics.CallbacksTotal.WithLabelValues("success").Inc()
+			return
+		}
+
+		logger.Warn("callback attempt failed", zap.String("url", url), zap.Int("attempt", attempt), zap.Error(err))
+
+		// Give up after the last retry, when the next one would run past
+		// CALLBACK_MAX_ELAPSED, or while the circuit is open, rather than
+		// hold a sleeping goroutine for an endpoint that is down
+		delay := retry.Backoff(h.callbackRetryDelay, h.callbackRetryMaxDelay, attempt+1)
+		expired := h.callbackMaxElapsed > 0 && time.Since(first)+delay > h.callbackMaxElapsed
+		if attempt == h.callbackMaxRetries || expired || errors.Is(err, callbacks.ErrCircuitOpen) {
+			h.metrics.CallbacksTotal.WithLabelValues("failure").Inc()
+			logger.Error("callback failed after retries", zap.String("url", url), zap.Int("total_attempts", attempt+1), zap.Error(err))
+			return
+		}
+		time.Sleep(delay)
 	}
+}
 
-	return nil
+// sendCallback sends a single callback request, through the callback
+// circuit breaker if configured
+func (h *Handler) sendCallback(callback *models.Callback, payload models.CallbackPayload) error {
+	return callbacks.Deliver(context.Background(), h.callbackBreaker, callback, payload)
 }
 
-func sanitizeFilename(name string) string {
+func sanitizeFilename(name string, asciiOnly bool) string {
 	name = strings.Map(func(r rune) rune {
-		if r < 32 || r > 126 || strings.ContainsRune(`\/:*?"<>|`, r) {
+		if r < 32 || r == 127 || strings.ContainsRune(`\/:*?"<>|`, r) {
+			return '_'
+		}
+		if r > 126 && (asciiOnly || !safeFilenameRune(r)) {
 			return '_'
 		}
 		return r
@@ -497,10 +1217,98 @@ func sanitizeFilename(name string) string {
 	return name
 }
 
+// safeFilenameRune reports whether a non-ASCII rune may appear in a
+// unicode-safe filename: printable characters, plus the zero-width joiner
+// used in emoji sequences. Invalid UTF-8, control characters, and invisible
+// formatting such as bidi overrides are not.
+func safeFilenameRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsPrint(r) || r == '\u200d')
+}
+
+// contentDisposition returns an attachment Content-Disposition for filename.
+// Names that aren't plain ASCII get an ASCII fallback in filename and the
+// exact name in RFC 5987 filename*, which current browsers prefer.
+func contentDisposition(filename string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 32 || r > 126 || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+	if fallback == filename {
+		return fmt.Sprintf(`attachment; filename="%s"`, filename)
+	}
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, fallback, encodeRFC5987(filename))
+}
+
+// encodeRFC5987 percent-encodes s as an RFC 5987 ext-value, leaving only attr-chars
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+	return b.String()
+}
+
+// selectObjects returns the objects named by a ?files= selection, in record
+// order. Each comma-separated item is an object key or, failing that, a
+// 0-based index into objects; anything else is rejected.
+func selectObjects(objects []string, files string) ([]string, error) {
+	index := make(map[string]int, len(objects))
+	for i, obj := range objects {
+		if _, ok := index[obj]; !ok {
+			index[obj] = i
+		}
+	}
+
+	selected := make([]bool, len(objects))
+	for _, item := range strings.Split(files, ",") {
+		item = strings.TrimSpace(item)
+		if i, ok := index[item]; ok {
+			selected[i] = true
+			continue
+		}
+		i, err := strconv.Atoi(item)
+		if err != nil || i < 0 || i >= len(objects) {
+			return nil, fmt.Errorf("file %q is not in this download", item)
+		}
+		selected[i] = true
+	}
+
+	subset := make([]string, 0, len(objects))
+	for i, obj := range objects {
+		if selected[i] {
+			subset = append(subset, obj)
+		}
+	}
+	return subset, nil
+}
+
+// SetExtensionFilters replaces the allowed and blocked extension lists,
+// e.g. on a config reload. Downloads already planned keep the old lists.
+func (h *Handler) SetExtensionFilters(allowed, blocked []string) {
+	h.extensionsMu.Lock()
+	defer h.extensionsMu.Unlock()
+	h.allowedExtensions = allowed
+	h.blockedExtensions = blocked
+}
+
 // filterFilesByExtension filters files based on allowed/blocked extension lists
 func (h *Handler) filterFilesByExtension(files []string) []string {
+	h.extensionsMu.RLock()
+	allowedExtensions, blockedExtensions := h.allowedExtensions, h.blockedExtensions
+	h.extensionsMu.RUnlock()
+
 	// If no filtering configured, return all files
-	if len(h.allowedExtensions) == 0 && len(h.blockedExtensions) == 0 {
+	if len(allowedExtensions) == 0 && len(blockedExtensions) == 0 {
 		return files
 	}
 
@@ -510,7 +1318,7 @@ func (h *Handler) filterFilesByExtension(files []string) []string {
 
 		// Check blocked list first
 		blocked := false
-		for _, blockedExt := range h.blockedExtensions {
+		for _, blockedExt := range blockedExtensions {
 			if ext == blockedExt {
 				blocked = true
 				break
@@ -521,9 +1329,9 @@ func (h *Handler) filterFilesByExtension(files []string) []string {
 		}
 
 		// If allowed list is specified, file must be in it
-		if len(h.allowedExtensions) > 0 {
+		if len(allowedExtensions) > 0 {
 			allowed := false
-			for _, allowedExt := range h.allowedExtensions {
+			for _, allowedExt := range allowedExtensions {
 				if ext == allowedExt {
 					allowed = true
 					break
@@ -541,37 +1349,8 @@ func (h *Handler) filterFilesByExtension(files []string) []string {
 	return filtered
 }
 
-// getClientIP extracts the real client IP from the request
-// Checks X-Forwarded-For and X-Real-IP headers (common in reverse proxy setups)
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header (may contain multiple IPs)
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// Take the first IP (original client)
-		if idx := strings.Index(xff, ","); idx != -1 {
-			return strings.TrimSpace(xff[:idx])
-		}
-		return strings.TrimSpace(xff)
-	}
-
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return strings.TrimSpace(xri)
-	}
-
-	// Fall back to remote address
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-	return ip
-}
-
-// checkRateLimit checks if the client IP is allowed based on rate limiting
-func (h *Handler) checkRateLimit(ip string) bool {
-	// Get or create limiter for this IP
-	limiterInterface, _ := h.rateLimiters.LoadOrStore(ip, rate.NewLimiter(rate.Limit(h.rateLimitPerIP), 1))
-	limiter := limiterInterface.(*rate.Limiter)
-
-	// Check if request is allowed
-	return limiter.Allow()
+// isBucketAllowed checks the record's bucket against the configured
+// allowlist, as storage.BucketAllowed
+func (h *Handler) isBucketAllowed(bucket string) bool {
+	return storage.BucketAllowed(h.allowedBuckets, bucket)
 }
@@ -1,25 +1,43 @@
 package handlers
 
 import (
+	"archive/tar"
 	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
+	"github.com/klauspost/compress/zstd"
 	"go.uber.org/zap"
 
+	"zipperfly/internal/archive"
 	"zipperfly/internal/auth"
+	"zipperfly/internal/callbacks"
+	"zipperfly/internal/circuitbreaker"
+	"zipperfly/internal/config"
 	"zipperfly/internal/metrics"
 	"zipperfly/internal/models"
+	"zipperfly/internal/schedule"
+	"zipperfly/internal/storage"
 )
 
 // Shared metrics instance to avoid duplicate Prometheus registration
@@ -37,6 +55,23 @@ func (m *mockDownloadDB) GetRecord(ctx context.Context, id string) (*models.Down
 	return nil, errors.New("record not found")
 }
 
+func (m *mockDownloadDB) GetRecords(ctx context.Context, ids []string) (map[string]*models.DownloadRecord, error) {
+	records := make(map[string]*models.DownloadRecord)
+	for _, id := range ids {
+		if record, ok := m.records[id]; ok {
+			records[id] = record
+		}
+	}
+	return records, nil
+}
+
+func (m *mockDownloadDB) IncrementDownloadCount(ctx context.Context, id string) error {
+	if record, ok := m.records[id]; ok {
+		record.DownloadCount++
+	}
+	return nil
+}
+
 func (m *mockDownloadDB) HealthCheck(ctx context.Context) error {
 	return nil
 }
@@ -47,13 +82,15 @@ func (m *mockDownloadDB) Close() error {
 
 // mockDownloadStorage implements storage.Provider for testing downloads
 type mockDownloadStorage struct {
-	files map[string]string // bucket:key -> content
+	files  map[string]string        // bucket:key -> content
+	delays map[string]time.Duration // bucket:key -> fetch latency
 }
 
-func (m *mockDownloadStorage) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+func (m *mockDownloadStorage) GetObject(ctx context.Context, bucket, key string) (*storage.Object, error) {
 	mapKey := fmt.Sprintf("%s:%s", bucket, key)
+	time.Sleep(m.delays[mapKey])
 	if content, ok := m.files[mapKey]; ok {
-		return io.NopCloser(strings.NewReader(content)), nil
+		return &storage.Object{ReadCloser: io.NopCloser(strings.NewReader(content)), Size: int64(len(content))}, nil
 	}
 	return nil, errors.New("file not found")
 }
@@ -80,6 +117,7 @@ func TestHandler_Download(t *testing.T) {
 		queryExpiry     string
 		querySignature  string
 		ignoreMissing   bool
+		allowedBuckets  []string
 		wantStatus      int
 		wantFilesInZip  []string
 		checkCallback   bool
@@ -137,6 +175,39 @@ func TestHandler_Download(t *testing.T) {
 			},
 			wantStatus: http.StatusNotFound,
 		},
+		{
+			name: "download limit reached",
+			id:   "test",
+			records: map[string]*models.DownloadRecord{
+				"test": {
+					ID:            "test",
+					Bucket:        "bucket",
+					Objects:       []string{"file.txt"},
+					DownloadCount: 1,
+					MaxDownloads:  1,
+				},
+			},
+			files: map[string]string{
+				"bucket:file.txt": "Hello, World!",
+			},
+			wantStatus: http.StatusGone,
+		},
+		{
+			name: "bucket not in allowlist",
+			id:   "test",
+			records: map[string]*models.DownloadRecord{
+				"test": {
+					ID:      "test",
+					Bucket:  "secrets",
+					Objects: []string{"file.txt"},
+				},
+			},
+			files: map[string]string{
+				"secrets:file.txt": "classified",
+			},
+			allowedBuckets: []string{"bucket"},
+			wantStatus:     http.StatusForbidden,
+		},
 		{
 			name: "successful single file download",
 			id:   "test",
@@ -212,27 +283,15 @@ func TestHandler_Download(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			db := &mockDownloadDB{records: tt.records}
 			storage := &mockDownloadStorage{files: tt.files}
-			verifier := auth.NewVerifier([]byte("test-secret"), tt.enforceSigning, m)
-
-			h := NewHandler(
-				logger,
-				db,
-				storage,
-				verifier,
-				m,
-				false, // appendYMD
-				false, // sanitizeNames
-				tt.ignoreMissing,
-				10, // maxConcurrent
-				0,  // callbackMaxRetries
-				0,  // callbackRetryDelay
-				false, // allowPasswordProtected
-				nil,   // allowedExtensions
-				nil,   // blockedExtensions
-			0,     // maxActiveDownloads
-			0.0,   // rateLimitPerIP
-				0,     // maxFilesPerRequest
-			)
+			verifier := auth.NewVerifier(map[string][]byte{"": []byte("test-secret")}, tt.enforceSigning, 1, m, nil)
+
+			h := NewDownloadHandler(logger, db, storage, verifier, m, HandlerOptions{
+				IgnoreMissing:    tt.ignoreMissing,
+				MaxConcurrent:    10,
+				AllowedBuckets:   tt.allowedBuckets,
+				SpoolMemoryLimit: 1 << 20,
+				SanitizeCharset:  "ascii",
+			})
 
 			// Create request
 			var req *http.Request
@@ -298,10 +357,78 @@ func TestHandler_Download(t *testing.T) {
 	}
 }
 
+func TestHandler_Download_OneTimeLink(t *testing.T) {
+	db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+		"once": {
+			ID:           "once",
+			Bucket:       "bucket",
+			Objects:      []string{"file.txt"},
+			MaxDownloads: 1,
+		},
+	}}
+	storage := &mockDownloadStorage{files: map[string]string{"bucket:file.txt": "data"}}
+	verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+	h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+		MaxConcurrent:    10,
+		SpoolMemoryLimit: 1 << 20,
+		SanitizeCharset:  "ascii",
+	})
+
+	for i, wantStatus := range []int{http.StatusOK, http.StatusGone} {
+		req := httptest.NewRequest("GET", "/once", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "once"})
+		w := httptest.NewRecorder()
+		h.Download(w, req)
+
+		if w.Code != wantStatus {
+			t.Errorf("attempt %d: status = %d, want %d", i+1, w.Code, wantStatus)
+		}
+	}
+
+	if got := db.records["once"].DownloadCount; got != 1 {
+		t.Errorf("DownloadCount = %d, want 1", got)
+	}
+}
+
+func TestHandler_Download_MaintenanceWindow(t *testing.T) {
+	// A whole-day maintenance window on every day is always in effect
+	sched, err := schedule.New(nil, []string{"00:00-00:00"}, "")
+	if err != nil {
+		t.Fatalf("schedule.New() error = %v", err)
+	}
+
+	db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+		"test": {ID: "test", Bucket: "bucket", Objects: []string{"file.txt"}},
+	}}
+	storage := &mockDownloadStorage{files: map[string]string{"bucket:file.txt": "data"}}
+	verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+	h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+		MaxConcurrent:    10,
+		Schedule:         sched,
+		SpoolMemoryLimit: 1 << 20,
+		SanitizeCharset:  "ascii",
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "test"})
+	w := httptest.NewRecorder()
+	h.Download(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if !strings.Contains(w.Body.String(), "maintenance") {
+		t.Errorf("body = %q, want maintenance message", w.Body.String())
+	}
+}
+
 func TestHandler_PrepareFilename(t *testing.T) {
 	tests := []struct {
 		name          string
 		inputName     string
+		format        archive.Format
 		appendYMD     bool
 		sanitizeNames bool
 		wantContains  []string // Strings that should be in the result
@@ -345,31 +472,37 @@ func TestHandler_PrepareFilename(t *testing.T) {
 			wantContains:  []string{"file_with_invalid_chars"},
 			wantSuffix:    ".zip",
 		},
+		{
+			name:         "tar.gz extension",
+			inputName:    "my-file",
+			format:       archive.FormatTarGz,
+			wantContains: []string{"my-file"},
+			wantSuffix:   ".tar.gz",
+		},
+		{
+			name:         "strips .tgz suffix for tar",
+			inputName:    "my-file.tgz",
+			format:       archive.FormatTar,
+			wantContains: []string{"my-file"},
+			wantSuffix:   "my-file.tar",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			h := NewHandler(
-				zap.NewNop(),
-				nil,
-				nil,
-				nil,
-				sharedMetrics,
-				tt.appendYMD,
-				tt.sanitizeNames,
-				false,
-				10,
-				0,
-				0,
-			false, // allowPasswordProtected
-			nil,   // allowedExtensions
-			nil,   // blockedExtensions
-			0,     // maxActiveDownloads
-			0.0,   // rateLimitPerIP
-			0,     // maxFilesPerRequest
-			)
-
-			result := h.prepareFilename(tt.inputName)
+			h := NewDownloadHandler(zap.NewNop(), nil, nil, nil, sharedMetrics, HandlerOptions{
+				AppendYMD:        tt.appendYMD,
+				SanitizeNames:    tt.sanitizeNames,
+				MaxConcurrent:    10,
+				SpoolMemoryLimit: 1 << 20,
+				SanitizeCharset:  "ascii",
+			})
+
+			format := tt.format
+			if format == "" {
+				format = archive.FormatZip
+			}
+			result := h.prepareFilename(tt.inputName, format)
 
 			for _, want := range tt.wantContains {
 				if !strings.Contains(result, want) {
@@ -386,9 +519,10 @@ func TestHandler_PrepareFilename(t *testing.T) {
 
 func TestSanitizeFilename(t *testing.T) {
 	tests := []struct {
-		name  string
-		input string
-		want  string
+		name      string
+		input     string
+		asciiOnly bool
+		want      string
 	}{
 		{
 			name:  "no change needed",
@@ -415,11 +549,27 @@ func TestSanitizeFilename(t *testing.T) {
 			input: "...filename",
 			want:  "filename",
 		},
+		{
+			name:  "unicode kept",
+			input: "Résumé 日本語 👩\u200d💻",
+			want:  "Résumé 日本語 👩\u200d💻",
+		},
+		{
+			name:  "bidi override and invalid utf-8",
+			input: "invoice\u202efdp\xff",
+			want:  "invoice_fdp_",
+		},
+		{
+			name:      "ascii only",
+			input:     "Résumé",
+			asciiOnly: true,
+			want:      "R_sum_",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := sanitizeFilename(tt.input)
+			got := sanitizeFilename(tt.input, tt.asciiOnly)
 			if got != tt.want {
 				t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.input, got, tt.want)
 			}
@@ -427,6 +577,25 @@ func TestSanitizeFilename(t *testing.T) {
 	}
 }
 
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{filename: "download.zip", want: `attachment; filename="download.zip"`},
+		{filename: "Résumé 2024.zip", want: `attachment; filename="R_sum_ 2024.zip"; filename*=UTF-8''R%C3%A9sum%C3%A9%202024.zip`},
+		{filename: `say "hi".zip`, want: `attachment; filename="say _hi_.zip"; filename*=UTF-8''say%20%22hi%22.zip`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			if got := contentDisposition(tt.filename); got != tt.want {
+				t.Errorf("contentDisposition(%q) = %q, want %q", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestHandler_SendCallback(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -478,25 +647,11 @@ func TestHandler_SendCallback(t *testing.T) {
 			}))
 			defer server.Close()
 
-			h := NewHandler(
-				zap.NewNop(),
-				nil,
-				nil,
-				nil,
-				sharedMetrics,
-				false,
-				false,
-				false,
-				10,
-				0,
-				0,
-			false, // allowPasswordProtected
-			nil,   // allowedExtensions
-			nil,   // blockedExtensions
-			0,     // maxActiveDownloads
-			0.0,   // rateLimitPerIP
-			0,     // maxFilesPerRequest
-			)
+			h := NewDownloadHandler(zap.NewNop(), nil, nil, nil, sharedMetrics, HandlerOptions{
+				MaxConcurrent:    10,
+				SpoolMemoryLimit: 1 << 20,
+				SanitizeCharset:  "ascii",
+			})
 
 			payload := models.CallbackPayload{
 				ID:         "test-id",
@@ -505,7 +660,7 @@ func TestHandler_SendCallback(t *testing.T) {
 				DurationMs: 1234,
 			}
 
-			err := h.sendCallback(server.URL, payload)
+			err := h.sendCallback(&models.Callback{URL: server.URL}, payload)
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("sendCallback() error = %v, wantErr %v", err, tt.wantErr)
@@ -514,12 +669,54 @@ func TestHandler_SendCallback(t *testing.T) {
 	}
 }
 
+func TestHandler_SendCallback_Custom(t *testing.T) {
+	var method, auth, tenant, contentType, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, auth, tenant, contentType, body = r.Method, r.Header.Get("Authorization"), r.Header.Get("X-Tenant"), r.Header.Get("Content-Type"), string(data)
+	}))
+	defer server.Close()
+
+	h := NewDownloadHandler(zap.NewNop(), nil, nil, nil, sharedMetrics, HandlerOptions{
+		MaxConcurrent:    10,
+		SpoolMemoryLimit: 1 << 20,
+		SanitizeCharset:  "ascii",
+	})
+
+	callback := &models.Callback{
+		URL:             server.URL,
+		Method:          "put",
+		Headers:         map[string]string{"X-Tenant": "7", "Content-Type": "text/plain"},
+		BearerToken:     "t0k",
+		PayloadTemplate: `{{.ID}} {{.Status}} {{json .Message}}`,
+	}
+	payload := models.CallbackPayload{ID: "test-id", Status: "failed", Message: `missing "a.txt"`}
+	if err := h.sendCallback(callback, payload); err != nil {
+		t.Fatalf("sendCallback() error = %v", err)
+	}
+
+	if method != http.MethodPut {
+		t.Errorf("method = %s, want PUT", method)
+	}
+	if auth != "Bearer t0k" {
+		t.Errorf("Authorization = %q, want Bearer t0k", auth)
+	}
+	if tenant != "7" || contentType != "text/plain" {
+		t.Errorf("X-Tenant = %q, Content-Type = %q; want the callback's headers", tenant, contentType)
+	}
+	if want := `test-id failed "missing \"a.txt\""`; body != want {
+		t.Errorf("body = %s, want %s", body, want)
+	}
+}
+
 func TestHandler_SendCallbackWithRetry(t *testing.T) {
 	tests := []struct {
 		name            string
 		serverBehavior  []int // Response codes for each attempt
 		maxRetries      int
 		retryDelay      time.Duration
+		maxElapsed      time.Duration
+		breaker         *circuitbreaker.Breaker
 		wantAttempts    int
 		wantMetricLabel string
 	}{
@@ -547,6 +744,28 @@ func TestHandler_SendCallbackWithRetry(t *testing.T) {
 			wantAttempts:    4, // Initial + 3 retries
 			wantMetricLabel: "failure",
 		},
+		{
+			name:            "retries would exceed max elapsed",
+			serverBehavior:  []int{http.StatusInternalServerError, http.StatusOK},
+			maxRetries:      3,
+			retryDelay:      time.Second,
+			maxElapsed:      100 * time.Millisecond,
+			wantAttempts:    1,
+			wantMetricLabel: "failure",
+		},
+		{
+			name:           "circuit opens",
+			serverBehavior: []int{http.StatusInternalServerError, http.StatusOK},
+			maxRetries:     3,
+			retryDelay:     1 * time.Millisecond,
+			breaker: circuitbreaker.New("callback-test", &config.Config{
+				CircuitBreakerThreshold:   1,
+				CircuitBreakerTimeout:     time.Minute,
+				CircuitBreakerMaxRequests: 1,
+			}, sharedMetrics),
+			wantAttempts:    1, // The retry is refused by the open circuit
+			wantMetricLabel: "failure",
+		},
 	}
 
 	for _, tt := range tests {
@@ -563,25 +782,15 @@ func TestHandler_SendCallbackWithRetry(t *testing.T) {
 			}))
 			defer server.Close()
 
-			h := NewHandler(
-				zap.NewNop(),
-				nil,
-				nil,
-				nil,
-				sharedMetrics,
-				false,
-				false,
-				false,
-				10,
-				tt.maxRetries,
-				tt.retryDelay,
-			false, // allowPasswordProtected
-			nil,   // allowedExtensions
-			nil,   // blockedExtensions
-			0,     // maxActiveDownloads
-			0.0,   // rateLimitPerIP
-			0,     // maxFilesPerRequest
-			)
+			h := NewDownloadHandler(zap.NewNop(), nil, nil, nil, sharedMetrics, HandlerOptions{
+				MaxConcurrent:      10,
+				CallbackMaxRetries: tt.maxRetries,
+				CallbackRetryDelay: tt.retryDelay,
+				SpoolMemoryLimit:   1 << 20,
+				SanitizeCharset:    "ascii",
+				CallbackBreaker:    tt.breaker,
+				CallbackMaxElapsed: tt.maxElapsed,
+			})
 
 			payload := models.CallbackPayload{
 				ID:         "test-id",
@@ -591,7 +800,7 @@ func TestHandler_SendCallbackWithRetry(t *testing.T) {
 			}
 
 			// Run callback (it's async in real code, but we call it directly here)
-			h.sendCallbackWithRetry(server.URL, payload)
+			h.sendCallbackWithRetry(&models.Callback{URL: server.URL}, payload)
 
 			if attemptCount != tt.wantAttempts {
 				t.Errorf("attempts = %d, want %d", attemptCount, tt.wantAttempts)
@@ -601,25 +810,13 @@ func TestHandler_SendCallbackWithRetry(t *testing.T) {
 }
 
 func TestHandler_SendCallbackWithRetry_EmptyURL(t *testing.T) {
-	h := NewHandler(
-		zap.NewNop(),
-		nil,
-		nil,
-		nil,
-		sharedMetrics,
-		false,
-		false,
-		false,
-		10,
-		3,
-		1*time.Millisecond,
-		false, // allowPasswordProtected
-		nil,   // allowedExtensions
-		nil,   // blockedExtensions
-			0,     // maxActiveDownloads
-			0.0,   // rateLimitPerIP
-		0,     // maxFilesPerRequest
-	)
+	h := NewDownloadHandler(zap.NewNop(), nil, nil, nil, sharedMetrics, HandlerOptions{
+		MaxConcurrent:      10,
+		CallbackMaxRetries: 3,
+		CallbackRetryDelay: 1 * time.Millisecond,
+		SpoolMemoryLimit:   1 << 20,
+		SanitizeCharset:    "ascii",
+	})
 
 	payload := models.CallbackPayload{
 		ID:     "test-id",
@@ -627,6 +824,1493 @@ func TestHandler_SendCallbackWithRetry_EmptyURL(t *testing.T) {
 	}
 
 	// Should return immediately without making any requests
-	h.sendCallbackWithRetry("", payload)
+	h.sendCallbackWithRetry(nil, payload)
 	// If this doesn't panic or hang, the test passes
 }
+
+// mockCallbackQueue records enqueued callback jobs
+type mockCallbackQueue struct {
+	callbacks.Queue
+	jobs []*models.CallbackJob
+	err  error
+}
+
+func (q *mockCallbackQueue) EnqueueCallback(ctx context.Context, job *models.CallbackJob) error {
+	if q.err != nil {
+		return q.err
+	}
+	q.jobs = append(q.jobs, job)
+	return nil
+}
+
+func TestHandler_SendCallbackWithRetry_Queued(t *testing.T) {
+	tests := []struct {
+		name         string
+		queueErr     error
+		wantQueued   bool
+		wantRequests int32
+	}{
+		{name: "queued", wantQueued: true},
+		{name: "queue unavailable", queueErr: errors.New("queue down"), wantRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+			}))
+			defer server.Close()
+
+			queue := &mockCallbackQueue{err: tt.queueErr}
+			h := NewDownloadHandler(zap.NewNop(), nil, nil, nil, sharedMetrics, HandlerOptions{
+				MaxConcurrent:    10,
+				SpoolMemoryLimit: 1 << 20,
+				SanitizeCharset:  "ascii",
+				CallbackQueue:    queue,
+			})
+
+			callback := &models.Callback{URL: server.URL}
+			h.sendCallbackWithRetry(callback, models.CallbackPayload{ID: "test-id", Status: "completed"})
+
+			if gotQueued := len(queue.jobs) == 1; gotQueued != tt.wantQueued {
+				t.Fatalf("queued = %v, want %v", gotQueued, tt.wantQueued)
+			}
+			if tt.wantQueued && (queue.jobs[0].Callback != callback || queue.jobs[0].Payload.ID != "test-id" || queue.jobs[0].ID == "") {
+				t.Errorf("queued job = %+v, want the callback and payload under a new ID", queue.jobs[0])
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("callback requests = %d, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+// mockPublisher records published events
+type mockPublisher struct {
+	events chan models.DownloadEvent
+}
+
+func (p *mockPublisher) Publish(ctx context.Context, event models.DownloadEvent) error {
+	p.events <- event
+	return nil
+}
+
+func (p *mockPublisher) Close() error {
+	return nil
+}
+
+func TestHandler_Download_Events(t *testing.T) {
+	tests := []struct {
+		name             string
+		disconnect       bool
+		disableCallbacks bool
+		wantTypes        []string
+		wantCallback     bool
+	}{
+		{name: "completed", wantTypes: []string{"started", "completed"}, wantCallback: true},
+		{name: "events instead of callbacks", disableCallbacks: true, wantTypes: []string{"started", "completed"}},
+		{name: "client disconnect", disconnect: true, wantTypes: []string{"started", "client_disconnect", "failed"}, wantCallback: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			callbacks := make(chan struct{}, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				callbacks <- struct{}{}
+			}))
+			defer server.Close()
+
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt"}, Callbacks: models.Callbacks{{URL: server.URL}}},
+			}}
+			storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha"}}
+			verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+			publisher := &mockPublisher{events: make(chan models.DownloadEvent, 3)}
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				MaxConcurrent:    10,
+				SpoolMemoryLimit: 1 << 20,
+				SanitizeCharset:  "ascii",
+				EventPublisher:   publisher,
+				DisableCallbacks: tt.disableCallbacks,
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.disconnect {
+				cancel()
+			}
+			req := httptest.NewRequest(http.MethodGet, "/test?request_id=req-1", nil).WithContext(ctx)
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			RequestIDMiddleware(http.HandlerFunc(h.Download)).ServeHTTP(httptest.NewRecorder(), req)
+
+			for _, want := range tt.wantTypes {
+				select {
+				case event := <-publisher.events:
+					if event.Type != want || event.ID != "test" || event.RequestID != "req-1" {
+						t.Errorf("event = %+v, want a %s event for test under req-1", event, want)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("no %s event published", want)
+				}
+			}
+
+			select {
+			case <-callbacks:
+				if !tt.wantCallback {
+					t.Error("callback sent with callbacks disabled")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.wantCallback {
+					t.Error("callback not sent")
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_Download_TarFormats(t *testing.T) {
+	tests := []struct {
+		name         string
+		format       string
+		recordFormat string
+		wantType     string
+		wantSuffix   string
+		gzipped      bool
+		zstd         bool
+	}{
+		{name: "tar", format: "tar", wantType: "application/x-tar", wantSuffix: `.tar"`},
+		{name: "tar.gz", format: "tar.gz", wantType: "application/gzip", wantSuffix: `.tar.gz"`, gzipped: true},
+		{name: "tgz alias", format: "tgz", wantType: "application/gzip", wantSuffix: `.tar.gz"`, gzipped: true},
+		{name: "tar.zst", format: "tar.zst", wantType: "application/zstd", wantSuffix: `.tar.zst"`, zstd: true},
+		{name: "record format", recordFormat: "tar.zst", wantType: "application/zstd", wantSuffix: `.tar.zst"`, zstd: true},
+		{name: "query overrides record format", format: "tar", recordFormat: "tar.zst", wantType: "application/x-tar", wantSuffix: `.tar"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt", "b.txt"}, Format: tt.recordFormat},
+			}}
+			storage := &mockDownloadStorage{files: map[string]string{
+				"bucket:a.txt": "alpha",
+				"bucket:b.txt": "bravo!",
+			}}
+			verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				MaxConcurrent:    10,
+				SpoolMemoryLimit: 1 << 20,
+				SanitizeCharset:  "ascii",
+			})
+
+			req := httptest.NewRequest("GET", "/test?format="+tt.format, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			h.Download(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := w.Header().Get("Content-Disposition"); !strings.HasSuffix(got, tt.wantSuffix) {
+				t.Errorf("Content-Disposition = %q, want suffix %q", got, tt.wantSuffix)
+			}
+
+			var r io.Reader = w.Body
+			if tt.gzipped {
+				gz, err := gzip.NewReader(r)
+				if err != nil {
+					t.Fatalf("failed to open gzip stream: %v", err)
+				}
+				r = gz
+			}
+			if tt.zstd {
+				zr, err := zstd.NewReader(r)
+				if err != nil {
+					t.Fatalf("failed to open zstd stream: %v", err)
+				}
+				defer zr.Close()
+				r = zr
+			}
+
+			contents := make(map[string]string)
+			tr := tar.NewReader(r)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("failed to read tar: %v", err)
+				}
+				data, _ := io.ReadAll(tr)
+				contents[hdr.Name] = string(data)
+			}
+
+			if contents["a.txt"] != "alpha" || contents["b.txt"] != "bravo!" {
+				t.Errorf("unexpected tar contents: %v", contents)
+			}
+		})
+	}
+}
+
+func TestHandler_Download_FormatValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		password   string
+		wantStatus int
+	}{
+		{name: "unknown format", query: "?format=rar", wantStatus: http.StatusBadRequest},
+		{name: "password requires zip", query: "?format=tar", password: "secret", wantStatus: http.StatusBadRequest},
+		{name: "password with zip", query: "?format=zip", password: "secret", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt"}, Password: tt.password},
+			}}
+			storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha"}}
+			verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				MaxConcurrent:          10,
+				AllowPasswordProtected: true,
+				SpoolMemoryLimit:       1 << 20,
+				SanitizeCharset:        "ascii",
+			})
+
+			req := httptest.NewRequest("GET", "/test"+tt.query, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			h.Download(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestSelectObjects(t *testing.T) {
+	objects := []string{"a.txt", "b.txt", "c.txt", "3"}
+
+	tests := []struct {
+		name    string
+		files   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "keys", files: "c.txt,a.txt", want: []string{"a.txt", "c.txt"}},
+		{name: "indexes", files: "1, 2", want: []string{"b.txt", "c.txt"}},
+		{name: "mixed with duplicates", files: "0,a.txt,b.txt", want: []string{"a.txt", "b.txt"}},
+		{name: "key wins over index", files: "3", want: []string{"3"}},
+		{name: "unknown key", files: "d.txt", wantErr: true},
+		{name: "index out of range", files: "4", wantErr: true},
+		{name: "negative index", files: "-1", wantErr: true},
+		{name: "empty item", files: "a.txt,", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectObjects(objects, tt.files)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectObjects(%q) error = %v, wantErr %v", tt.files, err, tt.wantErr)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("selectObjects(%q) = %v, want %v", tt.files, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_Download_FileSelection(t *testing.T) {
+	secret := []byte("test-secret")
+	sign := func(payload string) string {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(payload))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantFiles  []string
+	}{
+		{name: "signed selection", query: "?files=b.txt,0&signature=" + sign("test||b.txt,0"), wantStatus: http.StatusOK, wantFiles: []string{"a.txt", "b.txt"}},
+		{name: "whole record", query: "?signature=" + sign("test"), wantStatus: http.StatusOK, wantFiles: []string{"a.txt", "b.txt", "c.txt"}},
+		{name: "selection added to a whole-record link", query: "?files=a.txt&signature=" + sign("test"), wantStatus: http.StatusUnauthorized},
+		{name: "unknown file", query: "?files=d.txt&signature=" + sign("test||d.txt"), wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt", "b.txt", "c.txt"}},
+			}}
+			storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha", "bucket:b.txt": "bravo", "bucket:c.txt": "charlie"}}
+			verifier := auth.NewVerifier(map[string][]byte{"": secret}, true, 1, sharedMetrics, nil)
+
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				MaxConcurrent:    10,
+				EntryOrder:       "record",
+				SpoolMemoryLimit: 1 << 20,
+				SanitizeCharset:  "ascii",
+			})
+
+			req := httptest.NewRequest("GET", "/test"+tt.query, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			h.Download(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+			if err != nil {
+				t.Fatalf("failed to read zip: %v", err)
+			}
+			var names []string
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tt.wantFiles) {
+				t.Errorf("entries = %v, want %v", names, tt.wantFiles)
+			}
+		})
+	}
+}
+
+func TestHandler_Download_JWT(t *testing.T) {
+	secret := []byte("jwt-secret")
+	token := func(sub string, exp time.Duration) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": sub, "exp": jwt.NewNumericDate(time.Now().Add(exp))}).SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	jwtVerifier, err := auth.NewJWTVerifier(context.Background(), secret, nil, "", "", "", "user_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		header     string
+		wantStatus int
+	}{
+		{name: "bearer header", header: "Bearer " + token("test", time.Hour), wantStatus: http.StatusOK},
+		{name: "token query", query: "?token=" + token("test", time.Hour), wantStatus: http.StatusOK},
+		{name: "other download", header: "Bearer " + token("other", time.Hour), wantStatus: http.StatusUnauthorized},
+		{name: "expired", header: "Bearer " + token("test", -time.Hour), wantStatus: http.StatusGone},
+		{name: "no token", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt"}},
+			}}
+			storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha"}}
+			verifier := auth.NewVerifier(map[string][]byte{"": []byte("test-secret")}, true, 1, sharedMetrics, jwtVerifier)
+
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				MaxConcurrent:    10,
+				EntryOrder:       "record",
+				SpoolMemoryLimit: 1 << 20,
+				SanitizeCharset:  "ascii",
+			})
+
+			req := httptest.NewRequest("GET", "/test"+tt.query, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			h.Download(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_Download_StoreOnly(t *testing.T) {
+	tests := []struct {
+		name             string
+		recordStoreOnly  bool
+		globalStoreOnly  bool
+		storeExtensions  []string
+		recordExtensions []string
+		want             map[string]uint16
+	}{
+		{
+			name: "deflate by default",
+			want: map[string]uint16{"a.txt": zip.Deflate, "b.jpg": zip.Deflate},
+		},
+		{
+			name:            "global store only",
+			globalStoreOnly: true,
+			want:            map[string]uint16{"a.txt": zip.Store, "b.jpg": zip.Store},
+		},
+		{
+			name:            "record store only",
+			recordStoreOnly: true,
+			want:            map[string]uint16{"a.txt": zip.Store, "b.jpg": zip.Store},
+		},
+		{
+			name:            "store by extension",
+			storeExtensions: []string{".jpg"},
+			want:            map[string]uint16{"a.txt": zip.Deflate, "b.jpg": zip.Store},
+		},
+		{
+			name:             "record store extensions add to global",
+			storeExtensions:  []string{".jpg"},
+			recordExtensions: []string{"txt"},
+			want:             map[string]uint16{"a.txt": zip.Store, "b.jpg": zip.Store},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt", "b.jpg"}, StoreOnly: tt.recordStoreOnly, StoreExtensions: tt.recordExtensions},
+			}}
+			storage := &mockDownloadStorage{files: map[string]string{
+				"bucket:a.txt": "alpha",
+				"bucket:b.jpg": "bravo",
+			}}
+			verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				MaxConcurrent:      10,
+				ZipStoreOnly:       tt.globalStoreOnly,
+				ZipStoreExtensions: tt.storeExtensions,
+				SpoolMemoryLimit:   1 << 20,
+				SanitizeCharset:    "ascii",
+			})
+
+			req := httptest.NewRequest("GET", "/test", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			h.Download(w, req)
+
+			zipData := w.Body.Bytes()
+			zr, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+			if err != nil {
+				t.Fatalf("failed to read ZIP: %v", err)
+			}
+			for _, f := range zr.File {
+				if f.Method != tt.want[f.Name] {
+					t.Errorf("%s: Method = %d, want %d", f.Name, f.Method, tt.want[f.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_Download_ObjectMetadata(t *testing.T) {
+	modTime := time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)
+	db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+		"test": {
+			ID:             "test",
+			Bucket:         "bucket",
+			Objects:        []string{"a.txt", "b.sh"},
+			ObjectMetadata: map[string]models.ObjectMetadata{"b.sh": {ModTime: modTime, Mode: "0755"}},
+		},
+	}}
+	storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha", "bucket:b.sh": "#!/bin/sh"}}
+	verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+	h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+		MaxConcurrent:    10,
+		SpoolMemoryLimit: 1 << 20,
+		SanitizeCharset:  "ascii",
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "test"})
+	w := httptest.NewRecorder()
+	h.Download(w, req)
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("failed to read zip: %v", err)
+	}
+	for _, f := range zr.File {
+		wantTime, wantMode := time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC), os.FileMode(0o644)
+		if f.Name == "b.sh" {
+			wantTime, wantMode = modTime, 0o755
+		}
+		if !f.Modified.Equal(wantTime) || f.Mode().Perm() != wantMode {
+			t.Errorf("%s: Modified, Mode = %s, %v; want %s, %v", f.Name, f.Modified, f.Mode().Perm(), wantTime, wantMode)
+		}
+	}
+}
+
+func TestHandler_Download_EmptyRecord(t *testing.T) {
+	tests := []struct {
+		name              string
+		directories       []string
+		allowEmptyRecords bool
+		wantStatus        int
+		wantEntries       []string
+	}{
+		{name: "rejected", wantStatus: http.StatusUnprocessableEntity},
+		{name: "empty archive", allowEmptyRecords: true, wantStatus: http.StatusOK},
+		{name: "directories only", directories: []string{"empty", "nested/dir/"}, wantStatus: http.StatusOK, wantEntries: []string{"empty/", "nested/dir/"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {ID: "test", Bucket: "bucket", Directories: tt.directories},
+			}}
+			verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+			h := NewDownloadHandler(zap.NewNop(), db, &mockDownloadStorage{}, verifier, sharedMetrics, HandlerOptions{
+				MaxConcurrent:     10,
+				SpoolMemoryLimit:  1 << 20,
+				SanitizeCharset:   "ascii",
+				AllowEmptyRecords: tt.allowEmptyRecords,
+			})
+
+			req := httptest.NewRequest("GET", "/test", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			h.Download(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+			if err != nil {
+				t.Fatalf("failed to read zip: %v", err)
+			}
+			var names []string
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+			}
+			if !slices.Equal(names, tt.wantEntries) {
+				t.Errorf("entries = %v, want %v", names, tt.wantEntries)
+			}
+		})
+	}
+}
+
+func TestHandler_Download_ContentLength(t *testing.T) {
+	sizes := map[string]int64{"a.txt": 5, "b.jpg": 5}
+	tests := []struct {
+		name          string
+		storeOnly     bool
+		ignoreMissing bool
+		manifest      bool
+		sizes         map[string]int64
+		wantLength    bool
+	}{
+		{name: "store only with sizes", storeOnly: true, sizes: sizes, wantLength: true},
+		{name: "deflate", sizes: sizes},
+		{name: "size missing", storeOnly: true, sizes: map[string]int64{"a.txt": 5}},
+		{name: "ignore missing", storeOnly: true, ignoreMissing: true, sizes: sizes},
+		{name: "manifest", storeOnly: true, manifest: true, sizes: sizes},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {
+					ID:          "test",
+					Bucket:      "bucket",
+					Objects:     []string{"a.txt", "b.jpg"},
+					StoreOnly:   tt.storeOnly,
+					Manifest:    tt.manifest,
+					ExtraFiles:  map[string]string{"NOTICE.txt": "hello"},
+					ObjectSizes: tt.sizes,
+					Directories: []string{"empty"},
+				},
+			}}
+			storage := &mockDownloadStorage{files: map[string]string{
+				"bucket:a.txt": "alpha",
+				"bucket:b.jpg": "bravo",
+			}}
+			verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				IgnoreMissing:    tt.ignoreMissing,
+				MaxConcurrent:    10,
+				SpoolMemoryLimit: 1 << 20,
+				SanitizeCharset:  "ascii",
+			})
+
+			req := httptest.NewRequest("GET", "/test", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			h.Download(w, req)
+
+			got := w.Header().Get("Content-Length")
+			if !tt.wantLength {
+				if got != "" {
+					t.Errorf("Content-Length = %s, want none", got)
+				}
+				return
+			}
+			if want := strconv.Itoa(w.Body.Len()); got != want {
+				t.Errorf("Content-Length = %q, body is %s bytes", got, want)
+			}
+		})
+	}
+}
+
+func TestHandler_Download_CompressionLevel(t *testing.T) {
+	tests := []struct {
+		name        string
+		recordLevel int
+		serverLevel int
+		wantStatus  int
+	}{
+		{name: "server default", wantStatus: http.StatusOK},
+		{name: "server level", serverLevel: 9, wantStatus: http.StatusOK},
+		{name: "record override", recordLevel: 1, serverLevel: 9, wantStatus: http.StatusOK},
+		{name: "invalid record level", recordLevel: 12, wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt"}, CompressionLevel: tt.recordLevel},
+			}}
+			storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha"}}
+			verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				MaxConcurrent:    10,
+				CompressionLevel: tt.serverLevel,
+				SpoolMemoryLimit: 1 << 20,
+				SanitizeCharset:  "ascii",
+			})
+
+			req := httptest.NewRequest("GET", "/test", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			h.Download(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			zipData := w.Body.Bytes()
+			zr, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+			if err != nil {
+				t.Fatalf("failed to read ZIP: %v", err)
+			}
+			if len(zr.File) != 1 {
+				t.Errorf("got %d entries, want 1", len(zr.File))
+			}
+		})
+	}
+}
+
+func TestHandler_Download_Encryption(t *testing.T) {
+	tests := []struct {
+		name             string
+		password         string
+		serverEncryption string
+		recordEncryption string
+		want             string
+	}{
+		{name: "no password", serverEncryption: "aes256", want: ""},
+		{name: "server default", password: "secret", serverEncryption: "zipcrypto", want: "zipcrypto"},
+		{name: "server aes256", password: "secret", serverEncryption: "aes256", want: "aes256"},
+		{name: "record override", password: "secret", serverEncryption: "zipcrypto", recordEncryption: "aes256", want: "aes256"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payloads := make(chan models.CallbackPayload, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var payload models.CallbackPayload
+				json.NewDecoder(r.Body).Decode(&payload)
+				payloads <- payload
+			}))
+			defer server.Close()
+
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {
+					ID:         "test",
+					Bucket:     "bucket",
+					Objects:    []string{"a.txt"},
+					Callbacks:  models.Callbacks{{URL: server.URL}},
+					Password:   tt.password,
+					Encryption: tt.recordEncryption,
+				},
+			}}
+			storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha"}}
+			verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				MaxConcurrent:          10,
+				AllowPasswordProtected: true,
+				ZipEncryption:          tt.serverEncryption,
+				SpoolMemoryLimit:       1 << 20,
+				SanitizeCharset:        "ascii",
+			})
+
+			req := httptest.NewRequest("GET", "/test", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			h.Download(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", w.Code)
+			}
+
+			select {
+			case payload := <-payloads:
+				if payload.Encryption != tt.want {
+					t.Errorf("callback encryption = %q, want %q", payload.Encryption, tt.want)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("callback not received")
+			}
+		})
+	}
+}
+
+func TestHandler_Download_Manifest(t *testing.T) {
+	tests := []struct {
+		name           string
+		serverManifest bool
+		recordManifest bool
+		wantManifest   bool
+	}{
+		{name: "disabled"},
+		{name: "server enabled", serverManifest: true, wantManifest: true},
+		{name: "record enabled", recordManifest: true, wantManifest: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt", "gone.txt"}, Manifest: tt.recordManifest},
+			}}
+			storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha"}}
+			verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				IgnoreMissing:    true,
+				MaxConcurrent:    10,
+				ArchiveManifest:  tt.serverManifest,
+				ManifestFormat:   "json",
+				SpoolMemoryLimit: 1 << 20,
+				SanitizeCharset:  "ascii",
+			})
+
+			req := httptest.NewRequest("GET", "/test", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			h.Download(w, req)
+
+			zipData := w.Body.Bytes()
+			zr, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+			if err != nil {
+				t.Fatalf("failed to read ZIP: %v", err)
+			}
+
+			var manifestFile *zip.File
+			for _, f := range zr.File {
+				if f.Name == "manifest.json" {
+					manifestFile = f
+				}
+			}
+			if (manifestFile != nil) != tt.wantManifest {
+				t.Fatalf("manifest present = %v, want %v", manifestFile != nil, tt.wantManifest)
+			}
+			if manifestFile == nil {
+				return
+			}
+
+			rc, err := manifestFile.Open()
+			if err != nil {
+				t.Fatalf("failed to open manifest: %v", err)
+			}
+			defer rc.Close()
+
+			var manifest struct {
+				Files   []archive.ManifestFile `json:"files"`
+				Missing []string               `json:"missing"`
+			}
+			if err := json.NewDecoder(rc).Decode(&manifest); err != nil {
+				t.Fatalf("invalid manifest: %v", err)
+			}
+			// sha256("alpha")
+			wantSum := "8ed3f6ad685b959ead7022518e1af76cd816f8e8ec7ccdda1ed4018e8f2223f8"
+			if len(manifest.Files) != 1 || manifest.Files[0].Key != "a.txt" || manifest.Files[0].Size != 5 || manifest.Files[0].SHA256 != wantSum {
+				t.Errorf("unexpected manifest files: %+v", manifest.Files)
+			}
+			if len(manifest.Missing) != 1 || manifest.Missing[0] != "gone.txt" {
+				t.Errorf("missing = %v, want [gone.txt]", manifest.Missing)
+			}
+		})
+	}
+}
+
+func TestHandler_Download_ExtraFiles(t *testing.T) {
+	db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+		"test": {
+			ID:      "test",
+			Bucket:  "bucket",
+			Objects: []string{"a.txt"},
+			ExtraFiles: map[string]string{
+				"NOTICE.txt":   "record notice",
+				"../escape.sh": "ignored",
+			},
+		},
+	}}
+	storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha"}}
+	verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+	serverFiles := map[string]string{
+		"LICENSE.txt": "MIT",
+		"NOTICE.txt":  "server notice",
+	}
+
+	h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+		MaxConcurrent:    10,
+		ExtraFiles:       serverFiles,
+		SpoolMemoryLimit: 1 << 20,
+		SanitizeCharset:  "ascii",
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "test"})
+	w := httptest.NewRecorder()
+	h.Download(w, req)
+
+	zipData := w.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+	if err != nil {
+		t.Fatalf("failed to read ZIP: %v", err)
+	}
+
+	got := make(map[string]string)
+	var names []string
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		got[f.Name] = string(data)
+		names = append(names, f.Name)
+	}
+
+	// Extra files come first, sorted, with the record's NOTICE.txt replacing the server's
+	wantNames := []string{"LICENSE.txt", "NOTICE.txt", "a.txt"}
+	if strings.Join(names, ",") != strings.Join(wantNames, ",") {
+		t.Errorf("entries = %v, want %v", names, wantNames)
+	}
+	if got["NOTICE.txt"] != "record notice" || got["LICENSE.txt"] != "MIT" {
+		t.Errorf("unexpected extra file contents: %v", got)
+	}
+}
+
+func TestHandler_Download_EntryOrder(t *testing.T) {
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{order: EntryOrderRecord, want: []string{"z.txt", "m.txt", "a.txt"}},
+		{order: EntryOrderSorted, want: []string{"a.txt", "m.txt", "z.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {ID: "test", Bucket: "bucket", Objects: []string{"c/z.txt", "gone.txt", "a/m.txt", "b/a.txt"}},
+			}}
+			// Earlier objects finish last, so completion order would reverse them
+			storage := &mockDownloadStorage{
+				files: map[string]string{
+					"bucket:c/z.txt": "zulu",
+					"bucket:a/m.txt": "mike",
+					"bucket:b/a.txt": "alpha",
+				},
+				delays: map[string]time.Duration{
+					"bucket:c/z.txt": 30 * time.Millisecond,
+					"bucket:a/m.txt": 15 * time.Millisecond,
+				},
+			}
+			verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				IgnoreMissing:    true,
+				MaxConcurrent:    10,
+				EntryOrder:       tt.order,
+				SpoolMemoryLimit: 1 << 20,
+				SanitizeCharset:  "ascii",
+			})
+
+			var archives [][]byte
+			for run := 0; run < 2; run++ {
+				req := httptest.NewRequest("GET", "/test", nil)
+				req = mux.SetURLVars(req, map[string]string{"id": "test"})
+				w := httptest.NewRecorder()
+				h.Download(w, req)
+				archives = append(archives, w.Body.Bytes())
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(archives[0]), int64(len(archives[0])))
+			if err != nil {
+				t.Fatalf("failed to read ZIP: %v", err)
+			}
+			var names []string
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("entries = %v, want %v", names, tt.want)
+			}
+			if !bytes.Equal(archives[0], archives[1]) {
+				t.Error("archives from identical requests differ")
+			}
+		})
+	}
+}
+
+func TestHandler_Download_StreamErrorSignaling(t *testing.T) {
+	large := strings.Repeat("x", 64<<10)
+	tests := []struct {
+		name          string
+		objects       []string
+		ignoreMissing bool
+		abort         bool
+		wantCode      int
+		wantStatus    string // trailer value, "" if none
+		wantIncluded  string // files included trailer value, "" if none
+		wantComment   string
+		wantPanic     bool
+	}{
+		{name: "completed", objects: []string{"a.txt"}, wantCode: http.StatusOK, wantStatus: "completed", wantIncluded: "1"},
+		{
+			name:          "partial",
+			objects:       []string{"a.txt", "gone.txt"},
+			ignoreMissing: true,
+			wantCode:      http.StatusOK,
+			wantStatus:    "partial",
+			wantIncluded:  "1",
+			wantComment:   "zipperfly: INCOMPLETE ARCHIVE (partial)",
+		},
+		{
+			name:         "failed",
+			objects:      []string{"a.txt", "gone.txt"},
+			wantCode:     http.StatusOK,
+			wantStatus:   "failed",
+			wantIncluded: "1",
+			wantComment:  "zipperfly: INCOMPLETE ARCHIVE (failed)",
+		},
+		{name: "abort before output", objects: []string{"gone.txt", "a.txt"}, abort: true, wantCode: http.StatusBadGateway},
+		{name: "abort mid-stream", objects: []string{"large.bin", "gone.txt"}, abort: true, wantPanic: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {ID: "test", Bucket: "bucket", Objects: tt.objects, StoreOnly: true},
+			}}
+			storage := &mockDownloadStorage{files: map[string]string{
+				"bucket:a.txt":     "alpha",
+				"bucket:large.bin": large,
+			}}
+			verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				IgnoreMissing:      tt.ignoreMissing,
+				MaxConcurrent:      10,
+				EntryOrder:         EntryOrderRecord,
+				SpoolMemoryLimit:   1 << 20,
+				AbortOnStreamError: tt.abort,
+				SanitizeCharset:    "ascii",
+			})
+
+			req := httptest.NewRequest("GET", "/test", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+
+			panicked := func() (recovered interface{}) {
+				defer func() { recovered = recover() }()
+				h.Download(w, req)
+				return nil
+			}()
+			if tt.wantPanic {
+				if panicked != http.ErrAbortHandler {
+					t.Fatalf("Download() panic = %v, want http.ErrAbortHandler", panicked)
+				}
+				if _, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len())); err == nil {
+					t.Error("aborted response is a readable ZIP")
+				}
+				return
+			}
+			if panicked != nil {
+				t.Fatalf("Download() panicked: %v", panicked)
+			}
+
+			resp := w.Result()
+			if resp.StatusCode != tt.wantCode {
+				t.Fatalf("status code = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			if got := resp.Trailer.Get(StatusTrailer); got != tt.wantStatus {
+				t.Errorf("%s trailer = %q, want %q", StatusTrailer, got, tt.wantStatus)
+			}
+			if got := resp.Trailer.Get(FilesIncludedTrailer); got != tt.wantIncluded {
+				t.Errorf("%s trailer = %q, want %q", FilesIncludedTrailer, got, tt.wantIncluded)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			if got := resp.Header.Get(FilesTotalHeader); got != strconv.Itoa(len(tt.objects)) {
+				t.Errorf("%s = %q, want %d", FilesTotalHeader, got, len(tt.objects))
+			}
+			if got := resp.Header.Get("X-Accel-Buffering"); got != "no" {
+				t.Errorf("X-Accel-Buffering = %q, want no", got)
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+			if err != nil {
+				t.Fatalf("failed to read ZIP: %v", err)
+			}
+			if zr.Comment != tt.wantComment {
+				t.Errorf("ZIP comment = %q, want %q", zr.Comment, tt.wantComment)
+			}
+		})
+	}
+}
+
+// versionedStorage is a statStorage of files that reports each of them
+// last modified at the same time, so ranges of their archives are offered
+func versionedStorage(files map[string]string) *statStorage {
+	modTimes := make(map[string]time.Time, len(files))
+	for key := range files {
+		modTimes[key] = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return &statStorage{mockDownloadStorage: mockDownloadStorage{files: files}, modTimes: modTimes}
+}
+
+func TestHandler_Download_Range(t *testing.T) {
+	record := &models.DownloadRecord{
+		ID:          "test",
+		Bucket:      "bucket",
+		Objects:     []string{"a.txt", "b.jpg"},
+		StoreOnly:   true,
+		ObjectSizes: map[string]int64{"a.txt": 5, "b.jpg": 5},
+	}
+	storage := versionedStorage(map[string]string{
+		"bucket:a.txt": "alpha",
+		"bucket:b.jpg": "bravo",
+	})
+	verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+	download := func(entryOrder string, headers map[string]string) *httptest.ResponseRecorder {
+		db := &mockDownloadDB{records: map[string]*models.DownloadRecord{"test": record}}
+		h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+			MaxConcurrent:    10,
+			EntryOrder:       entryOrder,
+			SpoolMemoryLimit: 1 << 20,
+			SanitizeCharset:  "ascii",
+		})
+
+		req := httptest.NewRequest("GET", "/test", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		req = mux.SetURLVars(req, map[string]string{"id": "test"})
+		w := httptest.NewRecorder()
+		h.Download(w, req)
+		return w
+	}
+
+	full := download(EntryOrderRecord, nil)
+	if full.Code != http.StatusOK || full.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("full download: status %d, Accept-Ranges %q", full.Code, full.Header().Get("Accept-Ranges"))
+	}
+	whole := full.Body.Bytes()
+	etag := full.Header().Get("ETag")
+	size := len(whole)
+
+	tests := []struct {
+		name      string
+		order     string
+		headers   map[string]string
+		wantCode  int
+		wantBody  []byte
+		wantRange string
+	}{
+		{
+			name:      "resume",
+			order:     EntryOrderRecord,
+			headers:   map[string]string{"Range": "bytes=40-"},
+			wantCode:  http.StatusPartialContent,
+			wantBody:  whole[40:],
+			wantRange: fmt.Sprintf("bytes 40-%d/%d", size-1, size),
+		},
+		{
+			name:      "suffix with matching If-Range",
+			order:     EntryOrderRecord,
+			headers:   map[string]string{"Range": "bytes=-22", "If-Range": etag},
+			wantCode:  http.StatusPartialContent,
+			wantBody:  whole[size-22:],
+			wantRange: fmt.Sprintf("bytes %d-%d/%d", size-22, size-1, size),
+		},
+		{
+			name:     "stale If-Range",
+			order:    EntryOrderRecord,
+			headers:  map[string]string{"Range": "bytes=40-", "If-Range": `"stale"`},
+			wantCode: http.StatusOK,
+			wantBody: whole,
+		},
+		{
+			name:      "unsatisfiable",
+			order:     EntryOrderRecord,
+			headers:   map[string]string{"Range": fmt.Sprintf("bytes=%d-", size)},
+			wantCode:  http.StatusRequestedRangeNotSatisfiable,
+			wantRange: fmt.Sprintf("bytes */%d", size),
+		},
+		{
+			name:     "completion order ignores range",
+			order:    EntryOrderCompletion,
+			headers:  map[string]string{"Range": "bytes=40-"},
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := download(tt.order, tt.headers)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.wantRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantRange)
+			}
+			if tt.wantBody != nil && !bytes.Equal(w.Body.Bytes(), tt.wantBody) {
+				t.Errorf("body is %d bytes, want %d matching the full archive", w.Body.Len(), len(tt.wantBody))
+			}
+			if tt.wantCode == http.StatusPartialContent {
+				if got, want := w.Header().Get("Content-Length"), strconv.Itoa(len(tt.wantBody)); got != want {
+					t.Errorf("Content-Length = %s, want %s", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_Download_RangeCounted(t *testing.T) {
+	record := &models.DownloadRecord{
+		ID:           "test",
+		Bucket:       "bucket",
+		Objects:      []string{"a.txt"},
+		StoreOnly:    true,
+		ObjectSizes:  map[string]int64{"a.txt": 5},
+		MaxDownloads: 2,
+	}
+	db := &mockDownloadDB{records: map[string]*models.DownloadRecord{"test": record}}
+	storage := versionedStorage(map[string]string{"bucket:a.txt": "alpha"})
+	h := NewDownloadHandler(zap.NewNop(), db, storage, auth.NewVerifier(nil, false, 1, sharedMetrics, nil), sharedMetrics, HandlerOptions{
+		MaxConcurrent:    10,
+		EntryOrder:       EntryOrderRecord,
+		SpoolMemoryLimit: 1 << 20,
+		SanitizeCharset:  "ascii",
+	})
+
+	// Ranges that stop short of the end still count, so they run out
+	for i, want := range []int{http.StatusPartialContent, http.StatusPartialContent, http.StatusGone} {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("Range", "bytes=0-9")
+		req = mux.SetURLVars(req, map[string]string{"id": "test"})
+		w := httptest.NewRecorder()
+		h.Download(w, req)
+		if w.Code != want {
+			t.Fatalf("request %d: status = %d, want %d", i+1, w.Code, want)
+		}
+	}
+	if record.DownloadCount != 2 {
+		t.Errorf("DownloadCount = %d, want 2", record.DownloadCount)
+	}
+}
+
+func TestHandler_Download_RangeObjectReplaced(t *testing.T) {
+	record := &models.DownloadRecord{
+		ID:          "test",
+		Bucket:      "bucket",
+		Objects:     []string{"a.txt"},
+		StoreOnly:   true,
+		ObjectSizes: map[string]int64{"a.txt": 5},
+	}
+	db := &mockDownloadDB{records: map[string]*models.DownloadRecord{"test": record}}
+	store := versionedStorage(map[string]string{"bucket:a.txt": "alpha"})
+	download := func(provider storage.Provider, headers map[string]string) *httptest.ResponseRecorder {
+		h := NewDownloadHandler(zap.NewNop(), db, provider, auth.NewVerifier(nil, false, 1, sharedMetrics, nil), sharedMetrics, HandlerOptions{
+			MaxConcurrent:    10,
+			EntryOrder:       EntryOrderRecord,
+			SpoolMemoryLimit: 1 << 20,
+			SanitizeCharset:  "ascii",
+		})
+		req := httptest.NewRequest("GET", "/test", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		req = mux.SetURLVars(req, map[string]string{"id": "test"})
+		w := httptest.NewRecorder()
+		h.Download(w, req)
+		return w
+	}
+
+	etag := download(store, nil).Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag for a reproducible archive")
+	}
+
+	// The object is replaced with one of the same size, so resuming with
+	// the old ETag gets the whole new archive
+	store.files["bucket:a.txt"] = "ALPHA"
+	store.modTimes["bucket:a.txt"] = store.modTimes["bucket:a.txt"].Add(time.Minute)
+	w := download(store, map[string]string{"Range": "bytes=40-", "If-Range": etag})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("after replacement: status %d, ETag %s, want 200 with a new ETag", w.Code, w.Header().Get("ETag"))
+	}
+
+	// Storage that can't tell when an object changed gets no ranges
+	w = download(&store.mockDownloadStorage, map[string]string{"Range": "bytes=40-"})
+	if w.Code != http.StatusOK || w.Header().Get("Accept-Ranges") != "" || w.Header().Get("ETag") != "" {
+		t.Errorf("without StatObject: status %d, Accept-Ranges %q, ETag %q, want 200 without ranges", w.Code, w.Header().Get("Accept-Ranges"), w.Header().Get("ETag"))
+	}
+}
+
+// touchingStorage is a statStorage that counts its fetches, and whose
+// fetched objects' modification time and mode change on every fetch, as
+// from replicas that disagree on them
+type touchingStorage struct {
+	statStorage
+	fetches atomic.Int32
+}
+
+func (s *touchingStorage) GetObject(ctx context.Context, bucket, key string) (*storage.Object, error) {
+	obj, err := s.statStorage.GetObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	n := s.fetches.Add(1)
+	obj.ModTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(n) * time.Hour)
+	obj.Mode = fs.FileMode(0o600 + n)
+	return obj, nil
+}
+
+func TestHandler_Download_RangeIgnoresStorageTimes(t *testing.T) {
+	record := &models.DownloadRecord{
+		ID:          "test",
+		Bucket:      "bucket",
+		Objects:     []string{"a.txt"},
+		StoreOnly:   true,
+		ObjectSizes: map[string]int64{"a.txt": 5},
+	}
+	db := &mockDownloadDB{records: map[string]*models.DownloadRecord{"test": record}}
+	store := &touchingStorage{statStorage: *versionedStorage(map[string]string{"bucket:a.txt": "alpha"})}
+	h := NewDownloadHandler(zap.NewNop(), db, store, auth.NewVerifier(nil, false, 1, sharedMetrics, nil), sharedMetrics, HandlerOptions{
+		MaxConcurrent:    10,
+		EntryOrder:       EntryOrderRecord,
+		SpoolMemoryLimit: 1 << 20,
+		SanitizeCharset:  "ascii",
+	})
+
+	download := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "test"})
+		w := httptest.NewRecorder()
+		h.Download(w, req)
+		return w
+	}
+	first, second := download(), download()
+	if first.Header().Get("ETag") != second.Header().Get("ETag") {
+		t.Fatalf("ETag changed between downloads")
+	}
+	if !bytes.Equal(first.Body.Bytes(), second.Body.Bytes()) {
+		t.Errorf("archive bytes changed with storage's modification times under the same ETag")
+	}
+}
+
+func TestHandler_Download_RangeStopsEarly(t *testing.T) {
+	const objects = 50
+	record := &models.DownloadRecord{ID: "test", Bucket: "bucket", StoreOnly: true, ObjectSizes: map[string]int64{}}
+	files := map[string]string{}
+	content := strings.Repeat("x", 4096)
+	for i := range objects {
+		key := fmt.Sprintf("file%02d.bin", i)
+		record.Objects = append(record.Objects, key)
+		record.ObjectSizes[key] = int64(len(content))
+		files["bucket:"+key] = content
+	}
+	store := &touchingStorage{statStorage: *versionedStorage(files)}
+	db := &mockDownloadDB{records: map[string]*models.DownloadRecord{"test": record}}
+	h := NewDownloadHandler(zap.NewNop(), db, store, auth.NewVerifier(nil, false, 1, sharedMetrics, nil), sharedMetrics, HandlerOptions{
+		MaxConcurrent:    2,
+		EntryOrder:       EntryOrderRecord,
+		SpoolMemoryLimit: 1 << 20,
+		SanitizeCharset:  "ascii",
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Range", "bytes=0-0")
+	req = mux.SetURLVars(req, map[string]string{"id": "test"})
+	w := httptest.NewRecorder()
+	h.Download(w, req)
+
+	if w.Code != http.StatusPartialContent || w.Body.String() != "P" {
+		t.Fatalf("response = %d %q, want 206 \"P\"", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get(StatusTrailer); got != "completed" {
+		t.Errorf("status trailer = %q, want completed", got)
+	}
+	if got := w.Header().Get(FilesIncludedTrailer); got != strconv.Itoa(objects) {
+		t.Errorf("files included trailer = %q, want %d", got, objects)
+	}
+	if fetches := store.fetches.Load(); fetches > 10 {
+		t.Errorf("%d of %d objects fetched for a one-byte range", fetches, objects)
+	}
+}
+
+func TestHandler_Download_Head(t *testing.T) {
+	tests := []struct {
+		name       string
+		id         string
+		record     *models.DownloadRecord
+		wantStatus int
+		wantLength bool
+	}{
+		{
+			name:       "known size",
+			id:         "test",
+			record:     &models.DownloadRecord{ID: "test", Bucket: "bucket", Objects: []string{"a.txt"}, StoreOnly: true, ObjectSizes: map[string]int64{"a.txt": 5}},
+			wantStatus: http.StatusOK,
+			wantLength: true,
+		},
+		{
+			name:       "unknown size",
+			id:         "test",
+			record:     &models.DownloadRecord{ID: "test", Bucket: "bucket", Objects: []string{"a.txt"}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "download limit reached",
+			id:         "test",
+			record:     &models.DownloadRecord{ID: "test", Bucket: "bucket", Objects: []string{"a.txt"}, MaxDownloads: 1, DownloadCount: 1},
+			wantStatus: http.StatusGone,
+		},
+		{
+			name:       "not found",
+			id:         "missing",
+			record:     &models.DownloadRecord{ID: "test", Bucket: "bucket", Objects: []string{"a.txt"}},
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{"test": tt.record}}
+			storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha"}}
+			verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				MaxConcurrent:    10,
+				SpoolMemoryLimit: 1 << 20,
+				SanitizeCharset:  "ascii",
+			})
+
+			serve := func(method string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(method, "/"+tt.id, nil)
+				req = mux.SetURLVars(req, map[string]string{"id": tt.id})
+				w := httptest.NewRecorder()
+				h.Download(w, req)
+				return w
+			}
+
+			downloads := tt.record.DownloadCount
+			w := serve(http.MethodHead)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if w.Body.Len() != 0 {
+				t.Errorf("HEAD returned a %d byte body", w.Body.Len())
+			}
+			if tt.record.DownloadCount != downloads {
+				t.Error("HEAD counted as a download")
+			}
+			if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="download.zip"` {
+				t.Errorf("Content-Disposition = %q", got)
+			}
+
+			length := w.Header().Get("Content-Length")
+			if !tt.wantLength {
+				if length != "" {
+					t.Errorf("Content-Length = %s, want none", length)
+				}
+				return
+			}
+			if get := serve(http.MethodGet); length != strconv.Itoa(get.Body.Len()) {
+				t.Errorf("HEAD Content-Length = %s, GET body is %d bytes", length, get.Body.Len())
+			}
+		})
+	}
+}
+
+func TestHandler_SetExtensionFilters(t *testing.T) {
+	h := &Handler{allowedExtensions: []string{".pdf"}}
+	files := []string{"a.pdf", "b.zip", "c.exe"}
+
+	if got := h.filterFilesByExtension(files); !slices.Equal(got, []string{"a.pdf"}) {
+		t.Errorf("filterFilesByExtension() = %v, want [a.pdf]", got)
+	}
+	h.SetExtensionFilters(nil, []string{".exe"})
+	if got := h.filterFilesByExtension(files); !slices.Equal(got, []string{"a.pdf", "b.zip"}) {
+		t.Errorf("filterFilesByExtension() after SetExtensionFilters = %v, want [a.pdf b.zip]", got)
+	}
+}
+
+func TestNewDownloadHandler_Options(t *testing.T) {
+	h := NewDownloadHandler(zap.NewNop(), nil, nil, nil, sharedMetrics, HandlerOptions{})
+	if h.maxConcurrent != 1 {
+		t.Errorf("maxConcurrent = %d, want 1 for the zero value", h.maxConcurrent)
+	}
+
+	// The deprecated positional constructor maps onto the same options
+	h = NewHandler(zap.NewNop(), nil, nil, nil, sharedMetrics,
+		true, false, true, 4, 3, time.Second, false, []string{".txt"}, nil, nil, 7, nil, nil, "", 0, 0, false, nil, 0, "", false, "", nil, EntryOrderSorted, 1<<20, "", 0, 0, false, false, "", 0, "ascii", false, 1024, 0, false, nil, nil, false, false, 0, false, nil, 0, nil, "bucket", 5, time.Minute, 2048)
+	if !h.appendYMD || h.sanitizeNames || !h.ignoreMissing || h.maxConcurrent != 4 || h.callbackMaxRetries != 3 || h.maxFilesPerRequest != 7 {
+		t.Errorf("leading options not mapped: %+v", h)
+	}
+	if !slices.Equal(h.allowedExtensions, []string{".txt"}) || h.entryOrder != EntryOrderSorted || h.sanitizeCharset != "ascii" || h.maxFileSize != 1024 {
+		t.Errorf("middle options not mapped: %+v", h)
+	}
+	if h.metricsTenantLabel != "bucket" || h.slowDownloadThreshold != time.Minute || h.largeDownloadThreshold != 2048 {
+		t.Errorf("trailing options not mapped: %+v", h)
+	}
+}
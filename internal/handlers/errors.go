@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Error response formats, for ERROR_FORMAT
+const (
+	ErrorFormatText = "text" // http.Error's plain text, unchanged
+	ErrorFormatAuto = "auto" // by the Accept header: problem JSON, the HTML page, or plain text
+	ErrorFormatJSON = "json" // always problem JSON
+)
+
+// maxErrorDetail bounds the error message kept from a response
+const maxErrorDetail = 4096
+
+// DefaultErrorPage is the HTML error page used without ERROR_PAGE_TEMPLATE
+var DefaultErrorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Status}} {{.Title}}</title>
+<style>body{font-family:system-ui,sans-serif;max-width:40rem;margin:4rem auto;padding:0 1rem;color:#222}small{color:#777}</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Detail}}</p>
+{{if .RequestID}}<p><small>Request ID: {{.RequestID}}</small></p>{{end}}
+</body>
+</html>
+`))
+
+// ErrorPage is the data an error page template is rendered with
+type ErrorPage struct {
+	Status    int    // HTTP status code, e.g. 404
+	Title     string // status text, e.g. "Not Found"
+	Detail    string // the error message
+	RequestID string // X-Request-ID of the request, "" if none
+}
+
+// problem is an RFC 9457 problem details body
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// ErrorResponseMiddleware rewrites the plain-text error responses written
+// with http.Error in format: as application/problem+json for API clients,
+// or as page for browsers, with the request ID from the X-Request-ID
+// response header. Other responses pass through untouched. It goes around
+// the router, so mux's own 404 responses are rewritten too.
+func ErrorResponseMiddleware(format string, page *template.Template) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if format == "" || format == ErrorFormatText {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ew := &errorWriter{ResponseWriter: w}
+			next.ServeHTTP(ew, r)
+			if ew.status != 0 {
+				writeErrorResponse(w, r, format, page, ew.status, strings.TrimSpace(ew.body.String()))
+			}
+		})
+	}
+}
+
+// writeErrorResponse writes the error in the format the client accepts
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, format string, page *template.Template, status int, detail string) {
+	h := w.Header()
+	data := ErrorPage{
+		Status:    status,
+		Title:     http.StatusText(status),
+		Detail:    detail,
+		RequestID: h.Get("X-Request-ID"),
+	}
+
+	accept := strings.ToLower(r.Header.Get("Accept"))
+	switch {
+	case format == ErrorFormatJSON, strings.Contains(accept, "application/json"), strings.Contains(accept, "application/problem+json"):
+		body, _ := json.Marshal(problem{Type: "about:blank", Title: data.Title, Status: status, Detail: detail, RequestID: data.RequestID})
+		h.Set("Content-Type", "application/problem+json")
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(status)
+		w.Write(body)
+	case strings.Contains(accept, "text/html"):
+		var body bytes.Buffer
+		if err := page.Execute(&body, data); err != nil {
+			body.Reset()
+			DefaultErrorPage.Execute(&body, data)
+		}
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		h.Set("Content-Length", strconv.Itoa(body.Len()))
+		w.WriteHeader(status)
+		w.Write(body.Bytes())
+	default:
+		w.WriteHeader(status)
+		io.WriteString(w, detail+"\n")
+	}
+}
+
+// errorWriter holds back error responses written with http.Error, which
+// sets a plain-text Content-Type and X-Content-Type-Options, for
+// ErrorResponseMiddleware to rewrite
+type errorWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	status      int // status of the error held back, 0 = none
+	body        bytes.Buffer
+}
+
+func (w *errorWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	h := w.Header()
+	if code >= 400 && h.Get("Content-Type") == "text/plain; charset=utf-8" && h.Get("X-Content-Type-Options") == "nosniff" {
+		w.wroteHeader = true
+		w.status = code
+		return
+	}
+	if code >= 200 {
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status != 0 {
+		if room := maxErrorDetail - w.body.Len(); room > 0 {
+			w.body.Write(b[:min(len(b), room)])
+		}
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// ReadFrom keeps the underlying writer's io.ReaderFrom, so staged archives
+// are still sent with sendfile
+func (w *errorWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status != 0 {
+		return io.Copy(io.Discard, src)
+	}
+	return io.Copy(w.ResponseWriter, src)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g.
+// to flush progress events
+func (w *errorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
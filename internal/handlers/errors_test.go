@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorResponseMiddleware(t *testing.T) {
+	notFound := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-ID", "req-1")
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+	custom := template.Must(template.New("page").Parse(`<p>{{.Status}}: {{.Detail}} ({{.RequestID}})</p>`))
+
+	tests := []struct {
+		name            string
+		format          string
+		page            *template.Template
+		accept          string
+		handler         http.HandlerFunc
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{name: "text", format: ErrorFormatText, accept: "application/json", handler: notFound, wantStatus: 404, wantContentType: "text/plain; charset=utf-8", wantBody: "not found\n"},
+		{name: "auto without accept", format: ErrorFormatAuto, handler: notFound, wantStatus: 404, wantContentType: "text/plain; charset=utf-8", wantBody: "not found\n"},
+		{
+			name:            "auto JSON",
+			format:          ErrorFormatAuto,
+			accept:          "application/json",
+			handler:         notFound,
+			wantStatus:      404,
+			wantContentType: "application/problem+json",
+			wantBody:        `{"type":"about:blank","title":"Not Found","status":404,"detail":"not found","request_id":"req-1"}`,
+		},
+		{
+			name:            "auto HTML",
+			format:          ErrorFormatAuto,
+			accept:          "text/html,application/xhtml+xml,*/*;q=0.8",
+			handler:         notFound,
+			wantStatus:      404,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "Request ID: req-1",
+		},
+		{
+			name:            "custom page",
+			format:          ErrorFormatAuto,
+			page:            custom,
+			accept:          "text/html",
+			handler:         notFound,
+			wantStatus:      404,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "<p>404: not found (req-1)</p>",
+		},
+		{name: "json", format: ErrorFormatJSON, accept: "text/html", handler: notFound, wantStatus: 404, wantContentType: "application/problem+json", wantBody: `"detail":"not found"`},
+		{
+			name:   "success untouched",
+			format: ErrorFormatJSON,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/zip")
+				w.Write([]byte("archive"))
+			},
+			wantStatus:      200,
+			wantContentType: "application/zip",
+			wantBody:        "archive",
+		},
+		{
+			name:   "other errors untouched",
+			format: ErrorFormatJSON,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"bad"}`))
+			},
+			wantStatus:      400,
+			wantContentType: "application/json",
+			wantBody:        `{"error":"bad"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := tt.page
+			if page == nil {
+				page = DefaultErrorPage
+			}
+			r := httptest.NewRequest(http.MethodGet, "/test", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			ErrorResponseMiddleware(tt.format, page)(tt.handler).ServeHTTP(w, r)
+
+			resp := w.Result()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+			if !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", body, tt.wantBody)
+			}
+			if tt.wantContentType == "application/problem+json" && !json.Valid(body) {
+				t.Errorf("invalid JSON body %q", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// withFlush returns dst flushing w at most every FLUSH_INTERVAL as it is
+// written to, or dst itself if flushing is off. Without it, bytes of a
+// slowly growing archive can sit in the server's buffers, and a proxy that
+// waits for a full buffer shows the download as hanging.
+func (h *Handler) withFlush(w http.ResponseWriter, dst io.Writer) io.Writer {
+	if h.flushInterval <= 0 {
+		return dst
+	}
+	return &flushWriter{w: dst, rc: http.NewResponseController(w), interval: h.flushInterval}
+}
+
+// flushWriter flushes the response after a write once interval has passed
+// since the last flush, and after the first write, so the client sees the
+// response start as soon as the archive does
+type flushWriter struct {
+	w        io.Writer
+	rc       *http.ResponseController
+	interval time.Duration
+	last     time.Time
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if err != nil || time.Since(fw.last) < fw.interval {
+		return n, err
+	}
+	fw.last = time.Now()
+	if err := fw.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return n, err
+	}
+	return n, nil
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// flushCounter is a ResponseWriter that counts its flushes
+type flushCounter struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (f *flushCounter) Flush() {
+	f.flushes++
+	f.ResponseRecorder.Flush()
+}
+
+// noFlushWriter is a ResponseWriter that can't flush
+type noFlushWriter struct {
+	http.ResponseWriter
+}
+
+func TestWithFlush(t *testing.T) {
+	tests := []struct {
+		name        string
+		interval    time.Duration
+		writes      int
+		wantFlushes int
+	}{
+		{name: "disabled", interval: 0, writes: 3, wantFlushes: 0},
+		{name: "first write only", interval: time.Hour, writes: 3, wantFlushes: 1},
+		{name: "every write", interval: time.Nanosecond, writes: 3, wantFlushes: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{flushInterval: tt.interval}
+			w := &flushCounter{ResponseRecorder: httptest.NewRecorder()}
+			dst := h.withFlush(w, w)
+			for i := 0; i < tt.writes; i++ {
+				time.Sleep(time.Microsecond)
+				if _, err := dst.Write([]byte("chunk")); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+			if w.flushes != tt.wantFlushes {
+				t.Errorf("flushes = %d, want %d", w.flushes, tt.wantFlushes)
+			}
+			if got := w.Body.Len(); got != 5*tt.writes {
+				t.Errorf("body length = %d, want %d", got, 5*tt.writes)
+			}
+		})
+	}
+
+	// Writers that can't flush are written to as they are
+	h := &Handler{flushInterval: time.Nanosecond}
+	w := noFlushWriter{httptest.NewRecorder()}
+	if _, err := h.withFlush(w, w).Write([]byte("chunk")); err != nil {
+		t.Errorf("Write() to a writer without Flush error = %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -11,64 +12,60 @@ import (
 	"zipperfly/internal/database"
 	"zipperfly/internal/metrics"
 	"zipperfly/internal/storage"
+	"zipperfly/internal/version"
 )
 
+// healthCheckTimeout bounds each run of the dependency checks
+const healthCheckTimeout = 5 * time.Second
+
 // HealthHandler handles health check requests
 type HealthHandler struct {
-	logger  *zap.Logger
-	db      database.Store
-	storage storage.Provider
-	metrics *metrics.Metrics
+	logger   *zap.Logger
+	db       database.Store
+	storage  storage.Provider
+	metrics  *metrics.Metrics
+	cacheTTL time.Duration // how long dependency check results are reused, 0 = check on every probe
+
+	mu      sync.Mutex // held while checking, so concurrent probes share one run
+	checks  map[string]string
+	checked time.Time
 }
 
 // NewHealthHandler creates a new health check handler
-func NewHealthHandler(logger *zap.Logger, db database.Store, storageProvider storage.Provider, m *metrics.Metrics) *HealthHandler {
+func NewHealthHandler(logger *zap.Logger, db database.Store, storageProvider storage.Provider, m *metrics.Metrics, cacheTTL time.Duration) *HealthHandler {
 	return &HealthHandler{
-		logger:  logger,
-		db:      db,
-		storage: storageProvider,
-		metrics: m,
+		logger:   logger,
+		db:       db,
+		storage:  storageProvider,
+		metrics:  m,
+		cacheTTL: cacheTTL,
 	}
 }
 
 type healthResponse struct {
-	Status  string            `json:"status"`
-	Checks  map[string]string `json:"checks,omitempty"`
-	Version string            `json:"version,omitempty"`
+	Status    string            `json:"status"`
+	Checks    map[string]string `json:"checks,omitempty"`
+	CheckedAt string            `json:"checked_at,omitempty"`
+	Version   string            `json:"version,omitempty"`
 }
 
-// Health returns health status (checks dependencies)
-func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-
-	checks := make(map[string]string)
-	allHealthy := true
+// Live reports that the process is up and serving, without touching any
+// dependency, for liveness probes
+func (h *HealthHandler) Live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(healthResponse{Status: "ok", Version: version.Version})
+}
 
-	// Check database connectivity
-	dbHealthy := h.checkDatabase(ctx)
-	if dbHealthy {
-		checks["database"] = "ok"
-		h.metrics.HealthStatus.WithLabelValues("database").Set(1)
-	} else {
-		checks["database"] = "unavailable"
-		allHealthy = false
-		h.metrics.HealthStatus.WithLabelValues("database").Set(0)
-		h.metrics.HealthChecksFailed.WithLabelValues("database").Inc()
-		h.logger.Warn("database health check failed")
-	}
+// Ready returns 200 if the database and storage are reachable and 503 if
+// not, for readiness probes. Results are cached for HEALTH_CACHE_TTL.
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	checks, checked := h.check(r.Context())
 
-	// Check storage connectivity
-	storageHealthy := h.checkStorage(ctx)
-	if storageHealthy {
-		checks["storage"] = "ok"
-		h.metrics.HealthStatus.WithLabelValues("storage").Set(1)
-	} else {
-		checks["storage"] = "unavailable"
-		allHealthy = false
-		h.metrics.HealthStatus.WithLabelValues("storage").Set(0)
-		h.metrics.HealthChecksFailed.WithLabelValues("storage").Inc()
-		h.logger.Warn("storage health check failed")
+	allHealthy := true
+	for _, status := range checks {
+		if status != "ok" {
+			allHealthy = false
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -77,23 +74,70 @@ func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
 	}
 
 	json.NewEncoder(w).Encode(healthResponse{
-		Status:  map[bool]string{true: "healthy", false: "unhealthy"}[allHealthy],
-		Checks:  checks,
-		Version: "1.0.0",
+		Status:    map[bool]string{true: "healthy", false: "unhealthy"}[allHealthy],
+		Checks:    checks,
+		CheckedAt: checked.UTC().Format(time.RFC3339),
+		Version:   version.Version,
 	})
 }
 
-func (h *HealthHandler) checkDatabase(ctx context.Context) bool {
-	// Try to perform a simple operation with timeout
-	_, err := h.db.GetRecord(ctx, "__health_check__")
-	// We expect this to fail (record doesn't exist), but if it fails due to
-	// connection issues (timeout/unavailable), that's what we're checking for
-	if err == nil {
-		return true // Unexpectedly found the record, but DB is working
+// Health is Ready, kept at /health for existing probes and monitors
+func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
+	h.Ready(w, r)
+}
+
+// check returns each dependency's status and when it was checked, running
+// the checks in parallel unless the last results are under cacheTTL old
+func (h *HealthHandler) check(ctx context.Context) (map[string]string, time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.checks != nil && time.Since(h.checked) < h.cacheTTL {
+		return h.checks, h.checked
 	}
-	// Check if error is a timeout (bad) vs not found (good)
-	errStr := err.Error()
-	return errStr != "context deadline exceeded" && errStr != "connection refused"
+
+	// Results are shared with later probes, so don't let this probe's
+	// disconnect fail them
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), healthCheckTimeout)
+	defer cancel()
+
+	var dbHealthy, storageHealthy bool
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		dbHealthy = h.checkDatabase(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		storageHealthy = h.checkStorage(ctx)
+	}()
+	wg.Wait()
+
+	h.checks = map[string]string{
+		"database": h.record(ctx, "database", dbHealthy),
+		"storage":  h.record(ctx, "storage", storageHealthy),
+	}
+	h.checked = time.Now()
+	return h.checks, h.checked
+}
+
+// record updates a component's health metrics and returns its status
+func (h *HealthHandler) record(ctx context.Context, component string, healthy bool) string {
+	if healthy {
+		h.metrics.HealthStatus.WithLabelValues(component).Set(1)
+		return "ok"
+	}
+	h.metrics.HealthStatus.WithLabelValues(component).Set(0)
+	h.metrics.HealthChecksFailed.WithLabelValues(component).Inc()
+	requestLogger(ctx, h.logger).Warn(component + " health check failed")
+	return "unavailable"
+}
+
+func (h *HealthHandler) checkDatabase(ctx context.Context) bool {
+	// Use the store's built-in health check
+	err := h.db.HealthCheck(ctx)
+	return err == nil
 }
 
 func (h *HealthHandler) checkStorage(ctx context.Context) bool {
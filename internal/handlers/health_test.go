@@ -7,11 +7,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
 
 	"zipperfly/internal/models"
+	"zipperfly/internal/storage"
 )
 
 // Mock database store
@@ -23,12 +26,24 @@ func (m *mockDB) GetRecord(ctx context.Context, id string) (*models.DownloadReco
 	if m.shouldFail {
 		return nil, context.DeadlineExceeded
 	}
-	if id == "__health_check__" {
-		return nil, nil // Not found, but connection works
-	}
 	return &models.DownloadRecord{ID: id}, nil
 }
 
+func (m *mockDB) GetRecords(ctx context.Context, ids []string) (map[string]*models.DownloadRecord, error) {
+	return nil, nil
+}
+
+func (m *mockDB) IncrementDownloadCount(ctx context.Context, id string) error {
+	return nil
+}
+
+func (m *mockDB) HealthCheck(ctx context.Context) error {
+	if m.shouldFail {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
 func (m *mockDB) Close() error {
 	return nil
 }
@@ -38,11 +53,11 @@ type mockStorage struct {
 	shouldFail bool
 }
 
-func (m *mockStorage) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+func (m *mockStorage) GetObject(ctx context.Context, bucket, key string) (*storage.Object, error) {
 	if m.shouldFail {
 		return nil, context.DeadlineExceeded
 	}
-	return io.NopCloser(strings.NewReader("mock data")), nil
+	return &storage.Object{ReadCloser: io.NopCloser(strings.NewReader("mock data")), Size: 9}, nil
 }
 
 func (m *mockStorage) HealthCheck(ctx context.Context) error {
@@ -108,7 +123,7 @@ func TestHealthHandler_Health(t *testing.T) {
 			db := &mockDB{shouldFail: tt.dbFails}
 			storage := &mockStorage{shouldFail: tt.storageFails}
 
-			handler := NewHealthHandler(logger, db, storage, m)
+			handler := NewHealthHandler(logger, db, storage, m, 0)
 
 			req := httptest.NewRequest("GET", "/health", nil)
 			w := httptest.NewRecorder()
@@ -147,3 +162,97 @@ func TestHealthHandler_Health(t *testing.T) {
 		})
 	}
 }
+
+func TestHealthHandler_Live(t *testing.T) {
+	// Liveness doesn't depend on the database or storage
+	handler := NewHealthHandler(zap.NewNop(), &mockDB{shouldFail: true}, &mockStorage{shouldFail: true}, sharedMetrics, 0)
+
+	w := httptest.NewRecorder()
+	handler.Live(w, httptest.NewRequest("GET", "/livez", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Live() status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var resp healthResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Status != "ok" || resp.Checks != nil {
+		t.Errorf("Live() = %+v, want status ok without checks", resp)
+	}
+}
+
+// healthCountingStorage counts health checks, optionally waiting on ready first
+type healthCountingStorage struct {
+	mockStorage
+	calls atomic.Int32
+	ready chan struct{}
+}
+
+func (s *healthCountingStorage) HealthCheck(ctx context.Context) error {
+	s.calls.Add(1)
+	if s.ready != nil {
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return s.mockStorage.HealthCheck(ctx)
+}
+
+// signalingDB closes started when checked
+type signalingDB struct {
+	mockDB
+	started chan struct{}
+}
+
+func (d *signalingDB) HealthCheck(ctx context.Context) error {
+	close(d.started)
+	return d.mockDB.HealthCheck(ctx)
+}
+
+func TestHealthHandler_ReadyCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		wantCalls int32
+	}{
+		{name: "cached", ttl: time.Hour, wantCalls: 1},
+		{name: "uncached", ttl: 0, wantCalls: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &healthCountingStorage{}
+			handler := NewHealthHandler(zap.NewNop(), &mockDB{}, storage, sharedMetrics, tt.ttl)
+
+			for range 3 {
+				w := httptest.NewRecorder()
+				handler.Ready(w, httptest.NewRequest("GET", "/readyz", nil))
+				if w.Code != http.StatusOK {
+					t.Fatalf("Ready() status = %d, want %d", w.Code, http.StatusOK)
+				}
+			}
+
+			if got := storage.calls.Load(); got != tt.wantCalls {
+				t.Errorf("storage checked %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestHealthHandler_ReadyParallel(t *testing.T) {
+	// The storage check only finishes once the database check has started,
+	// so checking one after the other would time out
+	db := &signalingDB{started: make(chan struct{})}
+	storage := &healthCountingStorage{ready: db.started}
+	handler := NewHealthHandler(zap.NewNop(), db, storage, sharedMetrics, 0)
+
+	w := httptest.NewRecorder()
+	handler.Ready(w, httptest.NewRequest("GET", "/readyz", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Ready() status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/metrics"
+)
+
+// CountryLookup finds the ISO 3166-1 alpha-2 country of an address, "" if
+// unknown
+type CountryLookup interface {
+	Country(ip net.IP) (string, error)
+}
+
+// IPFilter refuses clients by network and by country
+type IPFilter struct {
+	logger           *zap.Logger
+	metrics          *metrics.Metrics
+	allow            []*net.IPNet // none = all networks
+	deny             []*net.IPNet
+	countries        CountryLookup // nil = no country rules
+	allowedCountries map[string]bool
+	blockedCountries map[string]bool
+}
+
+// NewIPFilter creates a filter that refuses clients in deny, clients
+// outside allow when it is set, and, using countries, clients from
+// blockedCountries or outside allowedCountries when that is set. Clients
+// are told apart by GetClientIP.
+func NewIPFilter(logger *zap.Logger, m *metrics.Metrics, allow, deny []*net.IPNet, countries CountryLookup, allowedCountries, blockedCountries []string) *IPFilter {
+	f := &IPFilter{
+		logger:           logger,
+		metrics:          m,
+		allow:            allow,
+		deny:             deny,
+		countries:        countries,
+		allowedCountries: make(map[string]bool),
+		blockedCountries: make(map[string]bool),
+	}
+	for _, country := range allowedCountries {
+		f.allowedCountries[country] = true
+	}
+	for _, country := range blockedCountries {
+		f.blockedCountries[country] = true
+	}
+	return f
+}
+
+// Middleware answers refused clients with 403
+func (f *IPFilter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := GetClientIP(r)
+		if reason := f.refuse(ip); reason != "" {
+			http.Error(w, "access denied", http.StatusForbidden)
+			f.metrics.RequestsTotal.WithLabelValues("403").Inc()
+			f.metrics.RequestsBlockedTotal.WithLabelValues(reason).Inc()
+			requestLogger(r.Context(), f.logger).Warn("request rejected: client not allowed", zap.String("ip", ip), zap.String("reason", reason), zap.String("path", r.URL.Path))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// refuse returns why ip is refused, "ip" or "country", or "" if it is
+// allowed. An address that can't be parsed is refused.
+func (f *IPFilter) refuse(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil || containsIP(f.deny, addr) {
+		return "ip"
+	}
+	if len(f.allow) > 0 && !containsIP(f.allow, addr) {
+		return "ip"
+	}
+	if f.countries == nil {
+		return ""
+	}
+
+	// Addresses with no known country match no country code
+	country, err := f.countries.Country(addr)
+	if err != nil {
+		f.logger.Warn("country lookup failed", zap.String("ip", ip), zap.Error(err))
+		country = ""
+	}
+	if f.blockedCountries[country] {
+		return "country"
+	}
+	if len(f.allowedCountries) > 0 && !f.allowedCountries[country] {
+		return "country"
+	}
+	return ""
+}
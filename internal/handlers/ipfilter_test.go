@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// fakeCountries is a CountryLookup from a fixed table
+type fakeCountries map[string]string
+
+func (c fakeCountries) Country(ip net.IP) (string, error) {
+	if ip.String() == "192.0.2.99" {
+		return "", errors.New("database corrupt")
+	}
+	return c[ip.String()], nil
+}
+
+func mustCIDRs(t *testing.T, cidrs ...string) []*net.IPNet {
+	t.Helper()
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("ParseCIDR(%q): %v", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func TestIPFilter_Middleware(t *testing.T) {
+	countries := fakeCountries{"203.0.113.7": "DE", "198.51.100.1": "US", "2001:db8::1": "FR"}
+
+	tests := []struct {
+		name             string
+		allow            []string
+		deny             []string
+		allowedCountries []string
+		blockedCountries []string
+		ip               string
+		want             int
+	}{
+		{name: "no rules", ip: "203.0.113.7", want: http.StatusOK},
+		{name: "denied network", deny: []string{"203.0.113.0/24"}, ip: "203.0.113.7", want: http.StatusForbidden},
+		{name: "outside denied network", deny: []string{"203.0.113.0/24"}, ip: "198.51.100.1", want: http.StatusOK},
+		{name: "inside allowlist", allow: []string{"198.51.100.0/24"}, ip: "198.51.100.1", want: http.StatusOK},
+		{name: "outside allowlist", allow: []string{"198.51.100.0/24"}, ip: "203.0.113.7", want: http.StatusForbidden},
+		{name: "deny beats allow", allow: []string{"198.51.100.0/24"}, deny: []string{"198.51.100.1/32"}, ip: "198.51.100.1", want: http.StatusForbidden},
+		{name: "blocked country", blockedCountries: []string{"DE"}, ip: "203.0.113.7", want: http.StatusForbidden},
+		{name: "other country", blockedCountries: []string{"DE"}, ip: "198.51.100.1", want: http.StatusOK},
+		{name: "allowed country", allowedCountries: []string{"DE", "FR"}, ip: "2001:db8::1", want: http.StatusOK},
+		{name: "outside allowed countries", allowedCountries: []string{"DE", "FR"}, ip: "198.51.100.1", want: http.StatusForbidden},
+		{name: "unknown country with allowed countries", allowedCountries: []string{"DE"}, ip: "192.0.2.1", want: http.StatusForbidden},
+		{name: "unknown country with blocked countries", blockedCountries: []string{"DE"}, ip: "192.0.2.1", want: http.StatusOK},
+		{name: "lookup error counts as unknown", allowedCountries: []string{"DE"}, ip: "192.0.2.99", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := NewIPFilter(zap.NewNop(), sharedMetrics, mustCIDRs(t, tt.allow...), mustCIDRs(t, tt.deny...), countries, tt.allowedCountries, tt.blockedCountries)
+			handler := filter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/abc", nil)
+			r.RemoteAddr = net.JoinHostPort(tt.ip, "5555")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.uber.org/zap"
+)
+
+var (
+	errFileTooLarge    = errors.New("file exceeds MAX_FILE_SIZE")
+	errArchiveTooLarge = errors.New("archive exceeds MAX_ARCHIVE_SIZE")
+)
+
+// sizeLimitExceeded returns which size limit err reports, "file" or
+// "archive", or "" if it isn't a limit breach
+func sizeLimitExceeded(err error) string {
+	switch {
+	case errors.Is(err, errFileTooLarge):
+		return "file"
+	case errors.Is(err, errArchiveTooLarge):
+		return "archive"
+	default:
+		return ""
+	}
+}
+
+// fileSizeReader fails with errFileTooLarge once more than limit bytes have
+// been read. It never returns bytes past the limit.
+type fileSizeReader struct {
+	r     io.Reader
+	key   string
+	limit int64
+	read  int64
+}
+
+func (l *fileSizeReader) Read(p []byte) (int, error) {
+	if l.read >= l.limit {
+		// Probe for one more byte to tell an exact fit from an overflow
+		var probe [1]byte
+		if n, err := l.r.Read(probe[:]); n == 0 {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w: %s is over %d bytes", errFileTooLarge, l.key, l.limit)
+	}
+	if int64(len(p)) > l.limit-l.read {
+		p = p[:l.limit-l.read]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	return n, err
+}
+
+// archiveSizeWriter fails with errArchiveTooLarge instead of writing past
+// limit bytes, so the client never receives more than the limit
+type archiveSizeWriter struct {
+	w       io.Writer
+	limit   int64
+	written int64
+}
+
+func (l *archiveSizeWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.limit-l.written {
+		return 0, fmt.Errorf("%w: over %d bytes", errArchiveTooLarge, l.limit)
+	}
+	n, err := l.w.Write(p)
+	l.written += int64(n)
+	return n, err
+}
+
+// acquireDownloadSlot takes one of the MAX_ACTIVE_DOWNLOADS slots, waiting
+// in the download queue if all are in use, and returns false if none is
+// freed in time. If the slot store fails, the download goes ahead without
+// one.
+func (h *Handler) acquireDownloadSlot(ctx context.Context) (release func(), ok bool) {
+	if h.activeDownloads == nil {
+		return func() {}, true
+	}
+	release, ok, err := h.activeDownloads.acquire(ctx)
+	if err != nil {
+		h.metrics.LimiterErrorsTotal.Inc()
+		h.log(ctx).Warn("download slots unavailable, allowing download", zap.Error(err))
+		return func() {}, true
+	}
+	return release, ok
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/auth"
+	"zipperfly/internal/models"
+)
+
+func TestFileSizeReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		limit   int64
+		wantErr bool
+	}{
+		{name: "under limit", content: "abc", limit: 5},
+		{name: "exact fit", content: "abcde", limit: 5},
+		{name: "over limit", content: "abcdef", limit: 5, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &fileSizeReader{r: strings.NewReader(tt.content), key: "a.txt", limit: tt.limit}
+			data, err := io.ReadAll(r)
+			if gotErr := errors.Is(err, errFileTooLarge); gotErr != tt.wantErr {
+				t.Fatalf("ReadAll() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if int64(len(data)) > tt.limit {
+				t.Errorf("read %d bytes past the %d byte limit", len(data), tt.limit)
+			}
+		})
+	}
+}
+
+func TestArchiveSizeWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &archiveSizeWriter{w: &buf, limit: 8}
+
+	if _, err := w.Write([]byte("12345")); err != nil {
+		t.Fatalf("Write() under limit error = %v", err)
+	}
+	if _, err := w.Write([]byte("6789")); !errors.Is(err, errArchiveTooLarge) {
+		t.Fatalf("Write() over limit error = %v, want errArchiveTooLarge", err)
+	}
+	if buf.String() != "12345" {
+		t.Errorf("written = %q, want only the bytes under the limit", buf.String())
+	}
+	if got := sizeLimitExceeded(errArchiveTooLarge); got != "archive" {
+		t.Errorf("sizeLimitExceeded() = %q, want archive", got)
+	}
+}
+
+func TestHandler_Download_SizeLimits(t *testing.T) {
+	large := strings.Repeat("x", 4096)
+	tests := []struct {
+		name           string
+		maxFileSize    int64
+		maxArchiveSize int64
+		ignoreMissing  bool
+		wantStatus     string
+	}{
+		{name: "within limits", maxFileSize: 8192, maxArchiveSize: 1 << 20, wantStatus: "completed"},
+		{name: "file too large", maxFileSize: 1024, wantStatus: "failed"},
+		{name: "file too large is not skipped", maxFileSize: 1024, ignoreMissing: true, wantStatus: "failed"},
+		{name: "archive too large", maxArchiveSize: 1024, wantStatus: "failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt", "large.bin"}, StoreOnly: true},
+			}}
+			storage := &mockDownloadStorage{files: map[string]string{
+				"bucket:a.txt":     "alpha",
+				"bucket:large.bin": large,
+			}}
+			verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				IgnoreMissing:    tt.ignoreMissing,
+				MaxConcurrent:    10,
+				EntryOrder:       EntryOrderRecord,
+				SpoolMemoryLimit: 1 << 20,
+				SanitizeCharset:  "ascii",
+				MaxFileSize:      tt.maxFileSize,
+				MaxArchiveSize:   tt.maxArchiveSize,
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			h.Download(w, req)
+
+			resp := w.Result()
+			if got := resp.Trailer.Get(StatusTrailer); got != tt.wantStatus {
+				t.Errorf("%s trailer = %q, want %q", StatusTrailer, got, tt.wantStatus)
+			}
+			if tt.maxArchiveSize > 0 && int64(w.Body.Len()) > tt.maxArchiveSize {
+				t.Errorf("sent %d bytes, over the %d byte archive limit", w.Body.Len(), tt.maxArchiveSize)
+			}
+			wantCount := 0
+			if tt.wantStatus == "completed" {
+				wantCount = 1
+			}
+			if got := db.records["test"].DownloadCount; got != wantCount {
+				t.Errorf("DownloadCount = %d, want %d", got, wantCount)
+			}
+		})
+	}
+}
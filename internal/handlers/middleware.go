@@ -1,15 +1,21 @@
 package handlers
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"time"
 )
 
-// BasicAuth wraps a handler with HTTP basic authentication
+// BasicAuth wraps a handler with HTTP basic authentication. Credentials are
+// compared in constant time, so response timing doesn't reveal how much of
+// them a guess got right.
 func BasicAuth(username, password string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			user, pass, ok := r.BasicAuth()
-			if !ok || user != username || pass != password {
+			userOK := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+			passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+			if !ok || !userOK || !passOK {
 				w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
@@ -18,3 +24,24 @@ func BasicAuth(username, password string) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// Timeout bounds a handler to d, canceling its request context and replying
+// 503 if it runs over. It buffers the response, so it doesn't suit streaming
+// endpoints. d <= 0 disables it.
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		return http.TimeoutHandler(next, d, "request timed out")
+	}
+}
+
+// NoWriteDeadline clears the server's write deadline, set by WRITE_TIMEOUT,
+// for a streaming handler, which runs as long as it makes progress instead
+func NoWriteDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestBasicAuth(t *testing.T) {
@@ -116,3 +118,77 @@ func TestBasicAuth(t *testing.T) {
 		})
 	}
 }
+
+func TestTimeout(t *testing.T) {
+	// The handler waits for its request context, so it only finishes early
+	// if the timeout cancels it
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+			w.Write([]byte("done"))
+		}
+	})
+
+	tests := []struct {
+		name       string
+		timeout    time.Duration
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "timed out", timeout: 10 * time.Millisecond, wantStatus: http.StatusServiceUnavailable, wantBody: "request timed out"},
+		{name: "disabled", timeout: 0, wantStatus: http.StatusOK, wantBody: "done"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Timeout(tt.timeout)(slow).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestNoWriteDeadline(t *testing.T) {
+	// The handler streams past the server's write timeout
+	stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a"))
+		http.NewResponseController(w).Flush()
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("b"))
+	})
+
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		wantBody string
+	}{
+		{name: "cleared", handler: NoWriteDeadline(stream), wantBody: "ab"},
+		{name: "write timeout", handler: stream, wantBody: "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(tt.handler)
+			srv.Config.WriteTimeout = 100 * time.Millisecond
+			srv.Start()
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
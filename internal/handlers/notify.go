@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/events"
+	"zipperfly/internal/models"
+)
+
+// eventPublishTimeout bounds publishing one lifecycle event
+const eventPublishTimeout = 10 * time.Second
+
+// notifyStarted reports a download starting: as a started event and, with
+// CALLBACK_STARTED, a started callback. The returned channel is closed once
+// the event has been published, for notifyOutcome to wait on.
+func (h *Handler) notifyStarted(ctx context.Context, targets models.Callbacks, id string, fileCount int) <-chan struct{} {
+	payload := models.CallbackPayload{
+		ID:        id,
+		RequestID: GetRequestID(ctx),
+		ClientIP:  clientIPFromContext(ctx),
+		Status:    events.Started,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		FileCount: fileCount,
+	}
+	if h.callbackStarted {
+		for _, callback := range h.callbackTargets(targets) {
+			go h.sendNotification(callback, payload)
+		}
+	}
+	return h.publishEvents(ctx, nil, payload, events.Started)
+}
+
+// notifyOutcome reports a finished download: as events on the event bus,
+// after its started event, and to each callback unless callbacks are
+// disabled. The payload gets the request ID and client address from ctx,
+// and per-object results only with CALLBACK_FILE_RESULTS.
+func (h *Handler) notifyOutcome(ctx context.Context, started <-chan struct{}, targets models.Callbacks, payload models.CallbackPayload) {
+	payload.RequestID = GetRequestID(ctx)
+	payload.ClientIP = clientIPFromContext(ctx)
+	if !h.callbackFileResults {
+		payload.Files = nil
+	}
+	types := []string{payload.Status}
+	if ctx.Err() != nil {
+		types = []string{events.ClientDisconnect, payload.Status}
+	}
+	h.publishEvents(ctx, started, payload, types...)
+
+	for _, callback := range h.callbackTargets(targets) {
+		go h.sendCallbackWithRetry(callback, payload)
+	}
+}
+
+// callbackTargets returns the record's callbacks followed by the default
+// callbacks, or none if callbacks are disabled
+func (h *Handler) callbackTargets(record models.Callbacks) models.Callbacks {
+	if h.disableCallbacks {
+		return nil
+	}
+	var targets models.Callbacks
+	for _, callback := range slices.Concat(record, h.defaultCallbacks) {
+		if callback != nil && callback.URL != "" {
+			targets = append(targets, callback)
+		}
+	}
+	return targets
+}
+
+// publishEvents publishes an event of each type with payload, in order and
+// in the background, once after (if not nil) is closed. The returned channel is closed when they are done;
+// it is nil when there is no event bus.
+func (h *Handler) publishEvents(ctx context.Context, after <-chan struct{}, payload models.CallbackPayload, types ...string) <-chan struct{} {
+	if h.eventPublisher == nil {
+		return nil
+	}
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		if after != nil {
+			<-after
+		}
+		for _, eventType := range types {
+			publishCtx, cancel := context.WithTimeout(context.Background(), eventPublishTimeout)
+			err := h.eventPublisher.Publish(publishCtx, models.DownloadEvent{Type: eventType, CallbackPayload: payload})
+			cancel()
+			if err != nil {
+				h.metrics.EventsTotal.WithLabelValues(eventType, "failure").Inc()
+				h.log(ctx).Error("failed to publish event", zap.String("type", eventType), zap.String("id", payload.ID), zap.Error(err))
+				continue
+			}
+			h.metrics.EventsTotal.WithLabelValues(eventType, "success").Inc()
+		}
+	}()
+	return done
+}
+
+// withCallbackProgress wraps w to send a progress callback each time another
+// CALLBACK_PROGRESS_BYTES have been written, if enabled and there is a
+// callback to send it to
+func (h *Handler) withCallbackProgress(ctx context.Context, w io.Writer, targets models.Callbacks, id string, fileCount int, start time.Time) io.Writer {
+	targets = h.callbackTargets(targets)
+	if h.callbackProgressBytes <= 0 || len(targets) == 0 {
+		return w
+	}
+	return &callbackProgressWriter{w: w, every: h.callbackProgressBytes, notify: func(written int64) {
+		payload := models.CallbackPayload{
+			ID:                  id,
+			RequestID:           GetRequestID(ctx),
+			ClientIP:            clientIPFromContext(ctx),
+			Status:              "progress",
+			Timestamp:           time.Now().UTC().Format(time.RFC3339),
+			DurationMs:          time.Since(start).Milliseconds(),
+			FileCount:           fileCount,
+			CompressedSizeBytes: written,
+		}
+		for _, callback := range targets {
+			go h.sendNotification(callback, payload)
+		}
+	}}
+}
+
+// callbackProgressWriter calls notify with the bytes written so far each
+// time a multiple of every is passed
+type callbackProgressWriter struct {
+	w       io.Writer
+	every   int64
+	written int64
+	notify  func(written int64)
+}
+
+func (pw *callbackProgressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	before := pw.written
+	pw.written += int64(n)
+	if pw.written/pw.every > before/pw.every {
+		pw.notify(pw.written)
+	}
+	return n, err
+}
+
+// sendNotification sends a started or progress callback once. It isn't
+// retried or queued, so a slow retry can't land after the download's final
+// callback.
+func (h *Handler) sendNotification(callback *models.Callback, payload models.CallbackPayload) {
+	if err := h.sendCallback(callback, payload); err != nil {
+		h.metrics.CallbacksTotal.WithLabelValues("failure").Inc()
+		withRequestID(h.logger, payload.RequestID).Warn("notification callback failed", zap.String("url", callback.URL), zap.String("status", payload.Status), zap.Error(err))
+		return
+	}
+	h.metrics.CallbacksTotal.WithLabelValues("success").Inc()
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/auth"
+	"zipperfly/internal/models"
+)
+
+func TestCallbackProgressWriter(t *testing.T) {
+	var notified []int64
+	pw := &callbackProgressWriter{w: io.Discard, every: 10, notify: func(written int64) {
+		notified = append(notified, written)
+	}}
+
+	for _, n := range []int{4, 4, 4, 25, 2, 1} {
+		pw.Write(make([]byte, n))
+	}
+
+	// One notification per write that passes a multiple of 10, however many it passes
+	want := []int64{12, 37, 40}
+	if len(notified) != len(want) {
+		t.Fatalf("notified at %v, want %v", notified, want)
+	}
+	for i := range want {
+		if notified[i] != want[i] {
+			t.Errorf("notified at %v, want %v", notified, want)
+			break
+		}
+	}
+}
+
+func TestHandler_CallbackTargets(t *testing.T) {
+	record := models.Callbacks{{URL: "https://example.com/a"}, {URL: ""}, {URL: "https://example.com/b"}}
+	defaults := models.Callbacks{{URL: "https://audit.example.com"}}
+
+	tests := []struct {
+		name     string
+		record   models.Callbacks
+		defaults models.Callbacks
+		disabled bool
+		want     []string
+	}{
+		{name: "record only", record: record, want: []string{"https://example.com/a", "https://example.com/b"}},
+		{name: "default only", defaults: defaults, want: []string{"https://audit.example.com"}},
+		{name: "record then default", record: record, defaults: defaults, want: []string{"https://example.com/a", "https://example.com/b", "https://audit.example.com"}},
+		{name: "disabled", record: record, defaults: defaults, disabled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{defaultCallbacks: tt.defaults, disableCallbacks: tt.disabled}
+			var got []string
+			for _, c := range h.callbackTargets(tt.record) {
+				got = append(got, c.URL)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("callbackTargets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_Download_Notifications(t *testing.T) {
+	tests := []struct {
+		name             string
+		started          bool
+		progressBytes    int64
+		disableCallbacks bool
+		want             map[string]bool
+	}{
+		{name: "final callback only", want: map[string]bool{"completed": true}},
+		{name: "started and progress", started: true, progressBytes: 64, want: map[string]bool{"started": true, "progress": true, "completed": true}},
+		{name: "callbacks disabled", started: true, progressBytes: 64, disableCallbacks: true, want: map[string]bool{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statuses := make(chan models.CallbackPayload, 64)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var payload models.CallbackPayload
+				json.NewDecoder(r.Body).Decode(&payload)
+				statuses <- payload
+			}))
+			defer server.Close()
+
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt"}, Callbacks: models.Callbacks{{URL: server.URL}}},
+			}}
+			storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": strings.Repeat("x", 1024)}}
+			verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				MaxConcurrent:         10,
+				SpoolMemoryLimit:      1 << 20,
+				SanitizeCharset:       "ascii",
+				DisableCallbacks:      tt.disableCallbacks,
+				CallbackStarted:       tt.started,
+				CallbackProgressBytes: tt.progressBytes,
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("X-Request-ID", "req-1")
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			RequestIDMiddleware(ClientIPMiddleware(nil, "")(http.HandlerFunc(h.Download))).ServeHTTP(w, req)
+
+			if got := w.Result().Trailer.Get(RequestIDTrailer); got != "req-1" {
+				t.Errorf("%s trailer = %q, want req-1", RequestIDTrailer, got)
+			}
+
+			got := make(map[string]bool)
+			for {
+				select {
+				case payload := <-statuses:
+					if payload.ID != "test" || payload.RequestID != "req-1" || payload.ClientIP != "192.0.2.1" {
+						t.Errorf("callback for %q, request %q, client %q; want test, req-1, 192.0.2.1", payload.ID, payload.RequestID, payload.ClientIP)
+					}
+					if payload.Status == "progress" && payload.CompressedSizeBytes < tt.progressBytes {
+						t.Errorf("progress callback at %d bytes, want at least %d", payload.CompressedSizeBytes, tt.progressBytes)
+					}
+					got[payload.Status] = true
+					continue
+				case <-time.After(200 * time.Millisecond):
+				}
+				break
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("callback statuses = %v, want %v", got, tt.want)
+			}
+			for status := range tt.want {
+				if !got[status] {
+					t.Errorf("no %s callback, got %v", status, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"zipperfly/internal/models"
+)
+
+// PasswordHeader carries the ZIP password for records whose requester
+// supplies it, as an alternative to the signed ?password= parameter
+const PasswordHeader = "X-Zipperfly-Password"
+
+var (
+	errPasswordRequired = errors.New("password required")
+	errPasswordMismatch = errors.New("incorrect password")
+	errPasswordDisabled = errors.New("password-protected downloads are disabled")
+)
+
+// requestPassword returns the ZIP password the request supplies: the
+// X-Zipperfly-Password header, else the password query parameter
+func requestPassword(r *http.Request) string {
+	if password := r.Header.Get(PasswordHeader); password != "" {
+		return password
+	}
+	return r.URL.Query().Get("password")
+}
+
+// zipPassword returns the password to encrypt record's archive with, ""
+// for none. Records storing a password use it as before. Records with a
+// password_hash or password_required take the requester's password, which
+// must match the hash when there is one, so the plaintext never has to be
+// stored in the downloads table.
+func (h *Handler) zipPassword(r *http.Request, record *models.DownloadRecord) (string, error) {
+	return h.recordPassword(record, requestPassword(r))
+}
+
+// recordPassword is zipPassword with the requester's password, "" for none
+func (h *Handler) recordPassword(record *models.DownloadRecord, password string) (string, error) {
+	if record.PasswordHash == "" && !record.PasswordRequired {
+		if !h.allowPasswordProtected {
+			return "", nil
+		}
+		return record.Password, nil
+	}
+
+	// Never serve such a record unencrypted
+	if !h.allowPasswordProtected {
+		return "", errPasswordDisabled
+	}
+	if password == "" {
+		return "", errPasswordRequired
+	}
+	if record.PasswordHash != "" && bcrypt.CompareHashAndPassword([]byte(record.PasswordHash), []byte(password)) != nil {
+		return "", errPasswordMismatch
+	}
+	return password, nil
+}
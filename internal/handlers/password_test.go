@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/auth"
+	"zipperfly/internal/models"
+)
+
+// hunter2Hash is a bcrypt hash of "hunter2" at the minimum cost
+const hunter2Hash = "$2a$04$eXjjnz90HyG6zbqmQnfENu.xtNgo5i.FStcPA65zyqZtL4XDUk/Xu"
+
+func TestHandler_ZipPassword(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  models.DownloadRecord
+		allowed bool
+		query   string
+		header  string
+		want    string
+		wantErr error
+	}{
+		{name: "no password", allowed: true},
+		{name: "stored password", record: models.DownloadRecord{Password: "stored"}, allowed: true, want: "stored"},
+		{name: "stored password disabled", record: models.DownloadRecord{Password: "stored"}},
+		{name: "stored password ignores request", record: models.DownloadRecord{Password: "stored"}, allowed: true, query: "?password=other", want: "stored"},
+		{name: "hash from query", record: models.DownloadRecord{PasswordHash: hunter2Hash}, allowed: true, query: "?password=hunter2", want: "hunter2"},
+		{name: "hash from header", record: models.DownloadRecord{PasswordHash: hunter2Hash}, allowed: true, header: "hunter2", want: "hunter2"},
+		{name: "header wins", record: models.DownloadRecord{PasswordHash: hunter2Hash}, allowed: true, query: "?password=wrong", header: "hunter2", want: "hunter2"},
+		{name: "hash mismatch", record: models.DownloadRecord{PasswordHash: hunter2Hash}, allowed: true, query: "?password=wrong", wantErr: errPasswordMismatch},
+		{name: "hash missing", record: models.DownloadRecord{PasswordHash: hunter2Hash}, allowed: true, wantErr: errPasswordRequired},
+		{name: "required takes any", record: models.DownloadRecord{PasswordRequired: true}, allowed: true, header: "anything", want: "anything"},
+		{name: "required missing", record: models.DownloadRecord{PasswordRequired: true}, allowed: true, wantErr: errPasswordRequired},
+		{name: "hash disabled", record: models.DownloadRecord{PasswordHash: hunter2Hash}, query: "?password=hunter2", wantErr: errPasswordDisabled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{allowPasswordProtected: tt.allowed}
+			req := httptest.NewRequest("GET", "/test"+tt.query, nil)
+			if tt.header != "" {
+				req.Header.Set(PasswordHeader, tt.header)
+			}
+
+			got, err := h.zipPassword(req, &tt.record)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("zipPassword() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("zipPassword() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_Download_PasswordHash(t *testing.T) {
+	tests := []struct {
+		name          string
+		header        string
+		wantStatus    int
+		wantEncrypted bool
+	}{
+		{name: "correct password", header: "hunter2", wantStatus: http.StatusOK, wantEncrypted: true},
+		{name: "wrong password", header: "letmein", wantStatus: http.StatusForbidden},
+		{name: "no password", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt"}, PasswordHash: hunter2Hash},
+			}}
+			storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha"}}
+			verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				MaxConcurrent:          10,
+				AllowPasswordProtected: true,
+				EntryOrder:             "record",
+				SpoolMemoryLimit:       1 << 20,
+				SanitizeCharset:        "ascii",
+			})
+
+			req := httptest.NewRequest("GET", "/test", nil)
+			if tt.header != "" {
+				req.Header.Set(PasswordHeader, tt.header)
+			}
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			h.Download(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if !tt.wantEncrypted {
+				return
+			}
+
+			reader, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+			if err != nil {
+				t.Fatalf("invalid zip: %v", err)
+			}
+			if len(reader.File) != 1 || reader.File[0].Flags&0x1 == 0 {
+				t.Errorf("archive entries not encrypted")
+			}
+		})
+	}
+}
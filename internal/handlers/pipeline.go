@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/semaphore"
+
+	"zipperfly/internal/archive"
+	"zipperfly/internal/models"
+	"zipperfly/internal/storage"
+)
+
+var (
+	errFileTimeout = errors.New("file fetch timeout exceeded")
+	errFileStalled = errors.New("file fetch stalled")
+)
+
+// fetchedFile is an object handed from a fetch worker to the archive writer
+type fetchedFile struct {
+	index   int
+	key     string
+	obj     *storage.Object // metadata only; the body is copied into buf
+	buf     *spoolBuffer    // nil if the fetch failed
+	err     error
+	missing bool      // the object could not be fetched; skipped with ignoreMissing
+	held    bool      // holds a prefetch slot the writer must release
+	started time.Time // when the fetch began; zero if it never did
+}
+
+// fetchConcurrency returns how many objects a download fetches at once:
+// MaxConcurrent, scaled down by adaptive concurrency under pressure
+func (h *Handler) fetchConcurrency() int64 {
+	if h.adaptive == nil {
+		return h.maxConcurrent
+	}
+	return h.adaptive.Limit(h.maxConcurrent)
+}
+
+// streamFilesFromStorage adds the record's objects to the archive through a
+// bounded prefetch pipeline. Up to fetchConcurrency objects are fetched at once,
+// each into a spool buffer that keeps spoolMemoryLimit bytes in memory and
+// spills the rest to disk, so a slow client never stalls the fetches and
+// memory per request stays bounded. The calling goroutine is the only
+// archive writer; it appends entries in h.entryOrder, streaming each one
+// while it is still being fetched. If manifest is non-nil, each file's
+// checksum and every omitted key are recorded in it; if results is non-nil,
+// each object's outcome is appended to it.
+//
+// Each fetch is bounded by h.fileFetchTimeout and aborted if no data arrives
+// for h.stallTimeout. With ignoreMissing, a file is only written once its
+// fetch has completed, so a failed or stalled fetch is skipped rather than
+// leaving a truncated entry. A file over h.maxFileSize is never skipped: it
+// stops the download.
+func (h *Handler) streamFilesFromStorage(
+	ctx context.Context,
+	aw archive.Writer,
+	record *models.DownloadRecord,
+	reproducible bool,
+	inBytes *int64,
+	manifest *archive.Manifest,
+	results *[]models.FileResult,
+) (int, error) {
+	keys := record.Objects
+	if h.entryOrder == EntryOrderSorted {
+		keys = sortedKeys(record.Objects)
+	}
+	ordered := h.orderedEntries()
+	progressID := GetRequestID(ctx)  // counts completed files for GET /{id}/progress, if tracked
+	active := activeFromContext(ctx) // and for /admin/downloads
+	tenant := h.tenantLabel(record)
+
+	// Cancelled when the writer gives up, to stop outstanding fetches
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// A slot is held from dispatch until the writer has consumed the file,
+	// which bounds the number of buffered files. Slots are taken in order,
+	// so in ordered modes the next file to be written always has one.
+	slots := semaphore.NewWeighted(h.fetchConcurrency())
+	ready := make(chan fetchedFile, len(keys))
+
+	go func() {
+		for i, key := range keys {
+			if err := slots.Acquire(ctx, 1); err != nil {
+				for j, skipped := range keys[i:] {
+					ready <- fetchedFile{index: i + j, key: skipped, err: err}
+				}
+				return
+			}
+			go h.fetchToSpool(ctx, record.Bucket, i, key, ready)
+		}
+	}()
+
+	var fetchErr error
+	successCount := 0
+	aborted := false
+
+	// Record an object's outcome, if results are collected
+	result := func(f fetchedFile, status string, n int64, err error) {
+		if results == nil {
+			return
+		}
+		r := models.FileResult{Key: f.key, Status: status, Bytes: n}
+		if !f.started.IsZero() {
+			r.DurationMs = time.Since(f.started).Milliseconds()
+		}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		*results = append(*results, r)
+	}
+
+	write := func(f fetchedFile) {
+		if f.held {
+			defer slots.Release(1)
+		}
+		if f.buf != nil {
+			defer f.buf.Close()
+		}
+		if errors.Is(fetchErr, errRangeSent) {
+			return
+		}
+
+		if f.err == nil && aborted {
+			f.err = context.Canceled
+		}
+		if f.err == nil && h.ignoreMissing {
+			if err := f.buf.Wait(); err != nil {
+				f.err, f.missing = err, !errors.Is(err, errFileTooLarge)
+			}
+		}
+		if f.err != nil {
+			if manifest != nil {
+				manifest.AddMissing(f.key)
+			}
+			if f.missing {
+				result(f, "missing", 0, f.err)
+			} else {
+				result(f, "error", 0, f.err)
+			}
+			if h.ignoreMissing && f.missing {
+				h.log(ctx).Warn(
+					"skipping missing file",
+					zap.String("bucket", record.Bucket),
+					zap.String("key", f.key),
+					zap.Error(f.err),
+				)
+				h.metrics.FilesFetchTotal.WithLabelValues(fetchFailureResult(f.err, "missing"), tenant).Inc()
+				h.metrics.MissingFilesTotal.Inc()
+				return
+			}
+			h.metrics.FilesFetchTotal.WithLabelValues(fetchFailureResult(f.err, "error"), tenant).Inc()
+			if fetchErr == nil {
+				fetchErr = f.err
+			}
+			// A file over the size limit stops the download rather than being skipped
+			if errors.Is(f.err, errFileTooLarge) {
+				aborted = true
+				cancel()
+			}
+			return
+		}
+
+		n, sum, err := h.addToArchive(aw, f, record.ObjectMetadata[f.key], reproducible, manifest != nil)
+		if errors.Is(err, errRangeSent) {
+			// The requested range is sent; skip the rest of the archive
+			fetchErr, aborted = err, true
+			cancel()
+			return
+		}
+		if err != nil {
+			// The archive stream is broken; stop fetching the rest
+			if manifest != nil {
+				manifest.AddMissing(f.key)
+			}
+			result(f, "error", n, err)
+			h.metrics.FilesFetchTotal.WithLabelValues(fetchFailureResult(err, "error"), tenant).Inc()
+			if fetchErr == nil {
+				fetchErr = err
+			}
+			aborted = true
+			cancel()
+			return
+		}
+
+		if manifest != nil {
+			manifest.AddFile(archive.ManifestFile{
+				Name:   filepath.Base(f.key),
+				Key:    f.key,
+				Size:   n,
+				SHA256: sum,
+			})
+		}
+		result(f, "success", n, nil)
+		*inBytes += n
+		successCount++
+		h.progress.update(progressID, func(p *DownloadProgress) { p.FilesCompleted++ })
+		active.fileDone()
+		h.metrics.FilesFetchTotal.WithLabelValues("success", tenant).Inc()
+	}
+
+	// Write files as they arrive, or in index order for ordered modes
+	pending := make(map[int]fetchedFile)
+	next := 0
+	for range keys {
+		f := <-ready
+		if !ordered {
+			write(f)
+			continue
+		}
+		pending[f.index] = f
+		for {
+			f, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			write(f)
+			next++
+		}
+	}
+
+	// If ignoring missing files, only fail if ALL files failed
+	if h.ignoreMissing && successCount == 0 && len(keys) > 0 {
+		return 0, fmt.Errorf("all %d files missing or failed to fetch", len(keys))
+	}
+
+	// If not ignoring missing and we had an error, return it
+	if (!h.ignoreMissing || aborted) && fetchErr != nil {
+		return successCount, fetchErr
+	}
+
+	return successCount, nil
+}
+
+// orderedEntries reports whether entries are written in a fixed order,
+// independent of how fast each fetch completes
+func (h *Handler) orderedEntries() bool {
+	return h.entryOrder == EntryOrderRecord || h.entryOrder == EntryOrderSorted
+}
+
+// reproducible reports whether plan's archive is the same byte for byte on
+// every download, so ranges of it can be served: its size is known up
+// front and its entries are written in a fixed order
+func (h *Handler) reproducible(plan *downloadPlan) bool {
+	return h.orderedEntries() && h.contentLength(plan) >= 0
+}
+
+// fetchToSpool opens an object, hands it to the writer, and copies its body
+// into a spool buffer. It always sends exactly one fetchedFile.
+func (h *Handler) fetchToSpool(ctx context.Context, bucket string, index int, key string, ready chan<- fetchedFile) {
+	started := time.Now()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	if h.fileFetchTimeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeoutCause(ctx, h.fileFetchTimeout, errFileTimeout)
+		defer stop()
+	}
+
+	obj, err := h.storage.GetObject(ctx, bucket, key)
+	if err != nil {
+		ready <- fetchedFile{index: index, key: key, err: fetchError(ctx, err), missing: true, held: true, started: started}
+		return
+	}
+	defer obj.Close()
+
+	// Reject a file storage reports as too large before reading any of it
+	if h.maxFileSize > 0 && obj.Size > h.maxFileSize {
+		err := fmt.Errorf("%w: %s is %d bytes, limit %d", errFileTooLarge, key, obj.Size, h.maxFileSize)
+		ready <- fetchedFile{index: index, key: key, err: err, held: true, started: started}
+		return
+	}
+
+	// Storage readers don't all honor ctx once the body is open;
+	// closing the body unblocks a read stuck on the network
+	stopClose := context.AfterFunc(ctx, func() { obj.Close() })
+	defer stopClose()
+
+	var body io.Reader = obj
+	if h.stallTimeout > 0 {
+		watchdog := time.AfterFunc(h.stallTimeout, func() { cancel(errFileStalled) })
+		defer watchdog.Stop()
+		body = &progressReader{r: obj, progress: func() { watchdog.Reset(h.stallTimeout) }}
+	}
+	// Sizes can be unknown or wrong, so the limit also applies to the bytes read
+	if h.maxFileSize > 0 {
+		body = &fileSizeReader{r: body, key: key, limit: h.maxFileSize}
+	}
+
+	buf := newSpoolBuffer(h.spoolMemoryLimit, h.spoolDir)
+	ready <- fetchedFile{index: index, key: key, obj: obj, buf: buf, held: true, started: started}
+
+	_, err = io.Copy(buf, body)
+	buf.CloseWithError(fetchError(ctx, err))
+}
+
+// progressReader calls progress after every read that returns data
+type progressReader struct {
+	r        io.Reader
+	progress func()
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.progress()
+	}
+	return n, err
+}
+
+// fetchError attributes a fetch failure to the per-file timeout or the
+// stall watchdog when either cancelled ctx
+func fetchError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if cause := context.Cause(ctx); errors.Is(cause, errFileTimeout) || errors.Is(cause, errFileStalled) {
+		return fmt.Errorf("%w: %v", cause, err)
+	}
+	return err
+}
+
+// fetchFailureResult returns the files_fetch_total result label for err,
+// or fallback if it wasn't a timeout or stall
+func fetchFailureResult(err error, fallback string) string {
+	switch {
+	case errors.Is(err, errFileTimeout):
+		return "timeout"
+	case errors.Is(err, errFileStalled):
+		return "stalled"
+	case errors.Is(err, errFileTooLarge):
+		return "too_large"
+	default:
+		return fallback
+	}
+}
+
+// addToArchive streams a fetched file into the archive, returning the bytes
+// written and, if checksum is set, their SHA-256. The entry takes its
+// timestamp and permissions from meta where set, else from storage, unless
+// the archive must be reproducible: storage's can change without the ETag
+// changing, so the archive's defaults stand in for them.
+func (h *Handler) addToArchive(aw archive.Writer, f fetchedFile, meta models.ObjectMetadata, reproducible, checksum bool) (int64, string, error) {
+	var src io.Reader = f.buf
+	var hasher hash.Hash
+	if checksum {
+		hasher = sha256.New()
+		src = io.TeeReader(f.buf, hasher)
+	}
+
+	entry := archive.Entry{
+		Name: filepath.Base(f.key),
+		Size: f.obj.Size,
+	}
+	if !reproducible {
+		entry.ModTime, entry.Mode = f.obj.ModTime, f.obj.Mode
+	}
+	if !meta.ModTime.IsZero() {
+		entry.ModTime = meta.ModTime
+	}
+	if mode, err := meta.FileMode(); err == nil && mode != 0 {
+		entry.Mode = mode
+	}
+
+	n, err := aw.AddFile(entry, src)
+	if err != nil || !checksum {
+		return n, "", err
+	}
+	return n, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// sortedKeys returns a copy of keys ordered by archive entry name, then key
+func sortedKeys(keys []string) []string {
+	sorted := append([]string(nil), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := filepath.Base(sorted[i]), filepath.Base(sorted[j])
+		if a != b {
+			return a < b
+		}
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
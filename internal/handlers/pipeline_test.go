@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/archive"
+	"zipperfly/internal/models"
+	"zipperfly/internal/storage"
+)
+
+// blockingArchive is an archive.Writer whose AddFile waits for release,
+// standing in for a stalled client
+type blockingArchive struct {
+	release chan struct{}
+	mu      sync.Mutex
+	names   []string
+}
+
+func (a *blockingArchive) AddFile(entry archive.Entry, r io.Reader) (int64, error) {
+	<-a.release
+	a.mu.Lock()
+	a.names = append(a.names, entry.Name)
+	a.mu.Unlock()
+	return io.Copy(io.Discard, r)
+}
+
+func (a *blockingArchive) AddDirectory(entry archive.Entry) error { return nil }
+
+func (a *blockingArchive) Close() error { return nil }
+
+// countingStorage records how many objects have been fully read
+type countingStorage struct {
+	mockDownloadStorage
+	mu   sync.Mutex
+	read int
+}
+
+type countingReader struct {
+	io.Reader
+	s *countingStorage
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		r.s.mu.Lock()
+		r.s.read++
+		r.s.mu.Unlock()
+	}
+	return n, err
+}
+
+func (s *countingStorage) GetObject(ctx context.Context, bucket, key string) (*storage.Object, error) {
+	obj, err := s.mockDownloadStorage.GetObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	obj.ReadCloser = io.NopCloser(&countingReader{Reader: obj.ReadCloser, s: s})
+	return obj, nil
+}
+
+func (s *countingStorage) fullyRead() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read
+}
+
+func TestStreamFilesFromStorage_BoundedPrefetch(t *testing.T) {
+	store := &countingStorage{mockDownloadStorage: mockDownloadStorage{files: map[string]string{
+		"bucket:1.txt": "one",
+		"bucket:2.txt": "two",
+		"bucket:3.txt": "three",
+		"bucket:4.txt": "four",
+		"bucket:5.txt": "five",
+	}}}
+	h := &Handler{
+		logger:           zap.NewNop(),
+		storage:          store,
+		metrics:          sharedMetrics,
+		maxConcurrent:    2,
+		entryOrder:       EntryOrderRecord,
+		spoolMemoryLimit: 1 << 10,
+		spoolDir:         t.TempDir(),
+	}
+	record := &models.DownloadRecord{Bucket: "bucket", Objects: []string{"1.txt", "2.txt", "3.txt", "4.txt", "5.txt"}}
+	aw := &blockingArchive{release: make(chan struct{})}
+
+	type result struct {
+		count int
+		err   error
+	}
+	done := make(chan result, 1)
+	var inBytes int64
+	go func() {
+		n, err := h.streamFilesFromStorage(context.Background(), aw, record, false, &inBytes, nil, nil)
+		done <- result{n, err}
+	}()
+
+	// With the writer stalled, fetches fill the prefetch window and stop there
+	deadline := time.Now().Add(2 * time.Second)
+	for store.fullyRead() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := store.fullyRead(); got != 2 {
+		t.Errorf("objects prefetched while writer stalled = %d, want 2", got)
+	}
+
+	close(aw.release)
+	res := <-done
+	if res.err != nil || res.count != 5 {
+		t.Fatalf("streamFilesFromStorage() = %d, %v; want 5, nil", res.count, res.err)
+	}
+	want := []string{"1.txt", "2.txt", "3.txt", "4.txt", "5.txt"}
+	for i, name := range want {
+		if aw.names[i] != name {
+			t.Errorf("entry %d = %s, want %s", i, aw.names[i], name)
+		}
+	}
+	if inBytes != int64(len("onetwothreefourfive")) {
+		t.Errorf("inBytes = %d, want %d", inBytes, len("onetwothreefourfive"))
+	}
+}
+
+// hangingStorage serves some keys from a body that sends a few bytes and then
+// hangs (or trickles forever), like an S3 stream dying mid-copy
+type hangingStorage struct {
+	mockDownloadStorage
+	hang    map[string]bool
+	trickle map[string]bool
+}
+
+func (s *hangingStorage) GetObject(ctx context.Context, bucket, key string) (*storage.Object, error) {
+	if !s.hang[key] && !s.trickle[key] {
+		return s.mockDownloadStorage.GetObject(ctx, bucket, key)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		if _, err := pw.Write([]byte("partial")); err != nil || !s.trickle[key] {
+			return
+		}
+		for {
+			time.Sleep(5 * time.Millisecond)
+			if _, err := pw.Write([]byte(".")); err != nil {
+				return
+			}
+		}
+	}()
+	return &storage.Object{ReadCloser: pr, Size: -1}, nil
+}
+
+func TestStreamFilesFromStorage_StalledFetch(t *testing.T) {
+	tests := []struct {
+		name             string
+		ignoreMissing    bool
+		fileFetchTimeout time.Duration
+		stallTimeout     time.Duration
+		wantErr          error
+		wantNames        []string
+	}{
+		{
+			name:          "stalled file skipped",
+			ignoreMissing: true,
+			stallTimeout:  50 * time.Millisecond,
+			wantNames:     []string{"ok.txt"},
+		},
+		{
+			name:         "stalled file fails archive",
+			stallTimeout: 50 * time.Millisecond,
+			wantErr:      errFileStalled,
+		},
+		{
+			name:             "trickling file times out",
+			ignoreMissing:    true,
+			fileFetchTimeout: 100 * time.Millisecond,
+			stallTimeout:     50 * time.Millisecond,
+			wantNames:        []string{"ok.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &hangingStorage{
+				mockDownloadStorage: mockDownloadStorage{files: map[string]string{"bucket:ok.txt": "ok"}},
+				hang:                map[string]bool{"hang.txt": true},
+				trickle:             map[string]bool{"trickle.txt": true},
+			}
+			h := &Handler{
+				logger:           zap.NewNop(),
+				storage:          store,
+				metrics:          sharedMetrics,
+				ignoreMissing:    tt.ignoreMissing,
+				maxConcurrent:    2,
+				entryOrder:       EntryOrderRecord,
+				spoolMemoryLimit: 1 << 10,
+				spoolDir:         t.TempDir(),
+				fileFetchTimeout: tt.fileFetchTimeout,
+				stallTimeout:     tt.stallTimeout,
+			}
+			objects := []string{"hang.txt", "ok.txt"}
+			if tt.fileFetchTimeout > 0 {
+				objects = []string{"trickle.txt", "ok.txt"}
+			}
+			record := &models.DownloadRecord{Bucket: "bucket", Objects: objects}
+			release := make(chan struct{})
+			close(release)
+			aw := &blockingArchive{release: release}
+
+			var inBytes int64
+			done := make(chan error, 1)
+			go func() {
+				_, err := h.streamFilesFromStorage(context.Background(), aw, record, false, &inBytes, nil, nil)
+				done <- err
+			}()
+
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("streamFilesFromStorage() hung on a stalled fetch")
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("streamFilesFromStorage() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("streamFilesFromStorage() error = %v", err)
+			}
+			if len(aw.names) != len(tt.wantNames) || aw.names[0] != tt.wantNames[0] {
+				t.Errorf("entries = %v, want %v", aw.names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestStreamFilesFromStorage_FileResults(t *testing.T) {
+	store := &mockDownloadStorage{files: map[string]string{
+		"bucket:a.txt": "alpha",
+		"bucket:c.txt": "charlie",
+	}}
+	h := &Handler{
+		logger:           zap.NewNop(),
+		storage:          store,
+		metrics:          sharedMetrics,
+		maxConcurrent:    2,
+		ignoreMissing:    true,
+		entryOrder:       EntryOrderRecord,
+		spoolMemoryLimit: 1 << 10,
+		spoolDir:         t.TempDir(),
+	}
+	record := &models.DownloadRecord{Bucket: "bucket", Objects: []string{"a.txt", "b.txt", "c.txt"}}
+	aw := &blockingArchive{release: make(chan struct{})}
+	close(aw.release)
+
+	var inBytes int64
+	var results []models.FileResult
+	n, err := h.streamFilesFromStorage(context.Background(), aw, record, false, &inBytes, nil, &results)
+	if err != nil || n != 2 {
+		t.Fatalf("streamFilesFromStorage() = %d, %v; want 2, nil", n, err)
+	}
+
+	want := []models.FileResult{
+		{Key: "a.txt", Status: "success", Bytes: 5},
+		{Key: "b.txt", Status: "missing"},
+		{Key: "c.txt", Status: "success", Bytes: 7},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("results = %+v, want %d entries", results, len(want))
+	}
+	for i, w := range want {
+		got := results[i]
+		if got.Key != w.Key || got.Status != w.Status || got.Bytes != w.Bytes {
+			t.Errorf("result %d = %+v, want %+v", i, got, w)
+		}
+		if (got.Error != "") != (w.Status != "success") {
+			t.Errorf("result %d error = %q, want one only for failures", i, got.Error)
+		}
+	}
+}
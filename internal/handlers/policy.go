@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/auth"
+	"zipperfly/internal/database"
+	"zipperfly/internal/models"
+)
+
+// checkAccessPolicy enforces a record's own access rules: its allowed_ips
+// against the client address, and its bound_user_id against the user the
+// request's JWT names
+func checkAccessPolicy(clientIP string, record *models.DownloadRecord, identity auth.Identity) error {
+	if len(record.AllowedIPs) > 0 {
+		networks, err := record.AllowedNetworks()
+		if err != nil {
+			return fmt.Errorf("record access policy is invalid: %w", err)
+		}
+		ip := net.ParseIP(clientIP)
+		if ip == nil || !containsIP(networks, ip) {
+			return errors.New("client address not allowed for this download")
+		}
+	}
+
+	if record.BoundUserID != "" && identity.User != record.BoundUserID {
+		return errors.New("download is bound to another user")
+	}
+	return nil
+}
+
+// claimDownload counts a limited record's download (max_downloads or
+// single_use) as it starts, so concurrent requests can't all pass the limit
+// check before any of them finishes. It writes the error response and
+// returns false if the limit was reached. Stores that can't claim
+// atomically count the download when it completes. A claimed download must
+// be released with releaseDownload.
+func (h *Handler) claimDownload(w http.ResponseWriter, r *http.Request, plan *downloadPlan) bool {
+	claimer, ok := h.db.(database.DownloadClaimer)
+	if !ok {
+		return true
+	}
+
+	claimed, err := claimer.ClaimDownload(r.Context(), plan.id, downloadLimit(plan.record))
+	if err != nil {
+		http.Error(w, "failed to claim download", http.StatusInternalServerError)
+		h.log(r.Context()).Error("failed to claim download", zap.Error(err), zap.String("id", plan.id))
+		h.metrics.RequestsTotal.WithLabelValues("500").Inc()
+		return false
+	}
+	if !claimed {
+		http.Error(w, "download limit reached", http.StatusGone)
+		h.log(r.Context()).Warn("download limit reached by concurrent downloads", zap.String("id", plan.id))
+		h.metrics.RequestsTotal.WithLabelValues("410").Inc()
+		return false
+	}
+	plan.claimed = true
+	return true
+}
+
+// releaseDownload gives back the download's claim unless it was counted,
+// so a download that fails, e.g. on a storage error or a client
+// disconnect, doesn't use up the link. It uses a fresh context since the
+// request context may be done.
+func (h *Handler) releaseDownload(ctx context.Context, plan *downloadPlan) {
+	if !plan.claimed || plan.counted {
+		return
+	}
+	plan.claimed = false
+	if err := h.db.(database.DownloadClaimer).ReleaseDownload(context.Background(), plan.id); err != nil {
+		h.log(ctx).Error("failed to release download claim", zap.Error(err), zap.String("id", plan.id))
+	}
+}
+
+// countDownload counts the download against the record's limit, once,
+// incrementing the download count unless it was already claimed. It uses a
+// fresh context since the request context is done once the response is
+// written.
+func (h *Handler) countDownload(ctx context.Context, plan *downloadPlan) {
+	if plan.counted {
+		return
+	}
+	plan.counted = true
+	if plan.claimed {
+		return
+	}
+	if err := h.db.IncrementDownloadCount(context.Background(), plan.id); err != nil {
+		h.log(ctx).Error("failed to increment download count", zap.Error(err), zap.String("id", plan.id))
+	}
+}
+
+// downloadLimit returns how many times record may be downloaded, 0 for
+// no limit
+func downloadLimit(record *models.DownloadRecord) int {
+	if record.SingleUse {
+		return 1
+	}
+	return max(record.MaxDownloads, 0)
+}
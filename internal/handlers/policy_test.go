@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/auth"
+	"zipperfly/internal/models"
+)
+
+// mockClaimingDB is a mockDownloadDB that can claim downloads atomically
+type mockClaimingDB struct {
+	mockDownloadDB
+	mu         sync.Mutex
+	increments int
+	releases   int
+}
+
+func (m *mockClaimingDB) GetRecord(ctx context.Context, id string) (*models.DownloadRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, err := m.mockDownloadDB.GetRecord(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	copied := *record
+	return &copied, nil
+}
+
+func (m *mockClaimingDB) IncrementDownloadCount(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.increments++
+	return m.mockDownloadDB.IncrementDownloadCount(ctx, id)
+}
+
+func (m *mockClaimingDB) ClaimDownload(ctx context.Context, id string, limit int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record := m.records[id]
+	if record.DownloadCount >= limit {
+		return false, nil
+	}
+	record.DownloadCount++
+	return true, nil
+}
+
+func (m *mockClaimingDB) ReleaseDownload(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.releases++
+	m.records[id].DownloadCount--
+	return nil
+}
+
+func TestCheckAccessPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		record   models.DownloadRecord
+		clientIP string
+		identity auth.Identity
+		wantErr  bool
+	}{
+		{name: "no policy", clientIP: "203.0.113.7"},
+		{name: "allowed network", record: models.DownloadRecord{AllowedIPs: []string{"203.0.113.0/24"}}, clientIP: "203.0.113.7"},
+		{name: "allowed address", record: models.DownloadRecord{AllowedIPs: []string{"198.51.100.1", "2001:db8::1"}}, clientIP: "2001:db8::1"},
+		{name: "other address", record: models.DownloadRecord{AllowedIPs: []string{"203.0.113.0/24"}}, clientIP: "198.51.100.1", wantErr: true},
+		{name: "invalid entry", record: models.DownloadRecord{AllowedIPs: []string{"office"}}, clientIP: "198.51.100.1", wantErr: true},
+		{name: "bound user", record: models.DownloadRecord{BoundUserID: "42"}, identity: auth.Identity{User: "42"}},
+		{name: "other user", record: models.DownloadRecord{BoundUserID: "42"}, identity: auth.Identity{User: "7"}, wantErr: true},
+		{name: "bound without a user", record: models.DownloadRecord{BoundUserID: "42"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkAccessPolicy(tt.clientIP, &tt.record, tt.identity)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkAccessPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandler_Download_BoundUser(t *testing.T) {
+	secret := []byte("jwt-secret")
+	token := func(claims jwt.MapClaims) string {
+		claims["sub"] = "test"
+		claims["exp"] = jwt.NewNumericDate(time.Now().Add(time.Hour))
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	jwtVerifier, err := auth.NewJWTVerifier(context.Background(), secret, nil, "", "", "", "user_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{name: "bound user", token: token(jwt.MapClaims{"user_id": "42"}), wantStatus: http.StatusOK},
+		{name: "numeric user claim", token: token(jwt.MapClaims{"user_id": 42}), wantStatus: http.StatusOK},
+		{name: "other user", token: token(jwt.MapClaims{"user_id": "7"}), wantStatus: http.StatusForbidden},
+		{name: "no user claim", token: token(jwt.MapClaims{}), wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt"}, BoundUserID: "42"},
+			}}
+			storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha"}}
+			verifier := auth.NewVerifier(map[string][]byte{"": []byte("test-secret")}, true, 1, sharedMetrics, jwtVerifier)
+
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				MaxConcurrent:    10,
+				EntryOrder:       "record",
+				SpoolMemoryLimit: 1 << 20,
+				SanitizeCharset:  "ascii",
+			})
+
+			req := httptest.NewRequest("GET", "/test", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			h.Download(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_Download_SingleUse(t *testing.T) {
+	db := &mockClaimingDB{mockDownloadDB: mockDownloadDB{records: map[string]*models.DownloadRecord{
+		"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt"}, SingleUse: true},
+	}}}
+	storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha"}}
+	verifier := auth.NewVerifier(map[string][]byte{"": []byte("test-secret")}, false, 1, sharedMetrics, nil)
+
+	h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+		MaxConcurrent:    10,
+		EntryOrder:       "record",
+		SpoolMemoryLimit: 1 << 20,
+		SanitizeCharset:  "ascii",
+	})
+
+	for i, tt := range []struct {
+		method     string
+		wantStatus int
+	}{
+		{http.MethodHead, http.StatusOK},
+		{http.MethodGet, http.StatusOK},
+		{http.MethodGet, http.StatusGone},
+		{http.MethodHead, http.StatusGone},
+	} {
+		req := httptest.NewRequest(tt.method, "/test", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "test"})
+		w := httptest.NewRecorder()
+		h.Download(w, req)
+
+		if w.Code != tt.wantStatus {
+			t.Fatalf("request %d (%s): status = %d, want %d: %s", i+1, tt.method, w.Code, tt.wantStatus, w.Body.String())
+		}
+	}
+	if count := db.records["test"].DownloadCount; count != 1 || db.increments != 0 {
+		t.Errorf("download count = %d after %d increments, want 1 claimed", count, db.increments)
+	}
+}
+
+func TestHandler_Download_MaxDownloadsConcurrent(t *testing.T) {
+	db := &mockClaimingDB{mockDownloadDB: mockDownloadDB{records: map[string]*models.DownloadRecord{
+		"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt"}, MaxDownloads: 1},
+	}}}
+	// The fetch is slow enough that every request passes the limit check
+	// before the first one finishes
+	storage := &mockDownloadStorage{
+		files:  map[string]string{"bucket:a.txt": "alpha"},
+		delays: map[string]time.Duration{"bucket:a.txt": 50 * time.Millisecond},
+	}
+	verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+	h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+		MaxConcurrent:    10,
+		SpoolMemoryLimit: 1 << 20,
+		SanitizeCharset:  "ascii",
+	})
+
+	const requests = 8
+	codes := make([]int, requests)
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			h.Download(w, req)
+			codes[i] = w.Code
+		}()
+	}
+	wg.Wait()
+
+	served := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			served++
+		case http.StatusGone:
+		default:
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if served != 1 {
+		t.Errorf("%d of %d concurrent downloads served, want 1 (statuses %v)", served, requests, codes)
+	}
+	if count := db.records["test"].DownloadCount; count != 1 || db.increments != 0 {
+		t.Errorf("download count = %d after %d increments, want 1 claimed", count, db.increments)
+	}
+}
+
+func TestHandler_Download_SingleUseReleasedOnFailure(t *testing.T) {
+	db := &mockClaimingDB{mockDownloadDB: mockDownloadDB{records: map[string]*models.DownloadRecord{
+		"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt"}, SingleUse: true},
+	}}}
+	// The object can't be fetched at first, failing the download
+	storage := &mockDownloadStorage{files: map[string]string{}}
+	h := NewDownloadHandler(zap.NewNop(), db, storage, auth.NewVerifier(nil, false, 1, sharedMetrics, nil), sharedMetrics, HandlerOptions{
+		MaxConcurrent:    10,
+		SpoolMemoryLimit: 1 << 20,
+		SanitizeCharset:  "ascii",
+	})
+
+	download := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "test"})
+		w := httptest.NewRecorder()
+		h.Download(w, req)
+		return w
+	}
+
+	if w := download(); w.Header().Get(StatusTrailer) != "failed" {
+		t.Fatalf("first download: status %d, trailer %q, want failed", w.Code, w.Header().Get(StatusTrailer))
+	}
+	if count := db.records["test"].DownloadCount; count != 0 || db.releases != 1 {
+		t.Fatalf("download count = %d after %d releases, want the claim released", count, db.releases)
+	}
+
+	storage.files["bucket:a.txt"] = "alpha"
+	if w := download(); w.Code != http.StatusOK || w.Header().Get(StatusTrailer) != "completed" {
+		t.Fatalf("retry: status %d, trailer %q, want a completed download", w.Code, w.Header().Get(StatusTrailer))
+	}
+	if w := download(); w.Code != http.StatusGone {
+		t.Errorf("third download: status %d, want %d", w.Code, http.StatusGone)
+	}
+	if count := db.records["test"].DownloadCount; count != 1 || db.releases != 1 {
+		t.Errorf("download count = %d after %d releases, want 1 with one release", count, db.releases)
+	}
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"zipperfly/internal/auth"
+)
+
+// Progress stream timing: how often a stream checks for changes, how long
+// it waits for the download to start, how often it sends a keepalive while
+// nothing changes, and how long a finished download stays visible
+const (
+	progressInterval  = 500 * time.Millisecond
+	progressWait      = 30 * time.Second
+	progressKeepalive = 15 * time.Second
+	progressRetention = time.Minute
+)
+
+// Progress status while the archive is still streaming; once finished it is
+// the download status: completed, partial, or failed
+const ProgressStreaming = "streaming"
+
+// DownloadProgress is a snapshot of a streaming download, sent as the data
+// of each progress event
+type DownloadProgress struct {
+	RequestID      string `json:"request_id"`
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	FilesTotal     int    `json:"files_total"`
+	FilesCompleted int    `json:"files_completed"`
+	BytesWritten   int64  `json:"bytes_written"`
+}
+
+// done reports whether the download has finished
+func (p DownloadProgress) done() bool {
+	return p.Status != ProgressStreaming
+}
+
+// progressTracker holds the progress of streaming downloads by request ID
+type progressTracker struct {
+	mu        sync.Mutex
+	downloads map[string]*DownloadProgress
+}
+
+// start registers a download; it replaces any earlier one with the same request ID
+func (t *progressTracker) start(requestID, id string, filesTotal int) {
+	if requestID == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.downloads[requestID] = &DownloadProgress{
+		RequestID:  requestID,
+		ID:         id,
+		Status:     ProgressStreaming,
+		FilesTotal: filesTotal,
+	}
+}
+
+// update applies fn to the download for requestID, if it is tracked
+func (t *progressTracker) update(requestID string, fn func(p *DownloadProgress)) {
+	if requestID == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p, ok := t.downloads[requestID]; ok {
+		fn(p)
+	}
+}
+
+// finish records the download's final status and forgets it after progressRetention
+func (t *progressTracker) finish(requestID, status string) {
+	if requestID == "" {
+		return
+	}
+	t.mu.Lock()
+	p, ok := t.downloads[requestID]
+	if ok {
+		p.Status = status
+	}
+	t.mu.Unlock()
+	if !ok {
+		return
+	}
+	time.AfterFunc(progressRetention, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.downloads[requestID] == p {
+			delete(t.downloads, requestID)
+		}
+	})
+}
+
+// get returns a snapshot of the download for requestID
+func (t *progressTracker) get(requestID string) (DownloadProgress, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.downloads[requestID]
+	if !ok {
+		return DownloadProgress{}, false
+	}
+	return *p, true
+}
+
+// progressWriter adds the bytes written through it to a tracked download
+type progressWriter struct {
+	w         io.Writer
+	tracker   *progressTracker
+	requestID string
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.tracker.update(pw.requestID, func(dp *DownloadProgress) { dp.BytesWritten += int64(n) })
+	return n, err
+}
+
+// Progress streams a download's progress as Server-Sent Events. The download
+// is identified by its request ID (?request_id=, the X-Request-ID it was
+// started with) and the request is signed like the download itself. A
+// "progress" event is sent on every change, and a final "done" event once
+// the download finishes or fails to start within progressWait.
+func (h *Handler) Progress(w http.ResponseWriter, r *http.Request) {
+	if !h.progressEvents {
+		http.NotFound(w, r)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	query := r.URL.Query()
+	if err := h.verifier.Verify(r.Context(), auth.RequestParams(r, id)); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		h.metrics.RequestsTotal.WithLabelValues("401").Inc()
+		return
+	}
+	requestID := query.Get("request_id")
+	if requestID == "" {
+		http.Error(w, "missing request_id", http.StatusBadRequest)
+		h.metrics.RequestsTotal.WithLabelValues("400").Inc()
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // stop nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	h.metrics.RequestsTotal.WithLabelValues("200").Inc()
+	rc := http.NewResponseController(w)
+
+	send := func(event string, p DownloadProgress) error {
+		data, _ := json.Marshal(p)
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	started := time.Now()
+	lastSent := started
+	var last DownloadProgress
+	seen := false
+
+	for {
+		p, ok := h.progress.get(requestID)
+		ok = ok && p.ID == id
+		switch {
+		case ok && p.done():
+			send("done", p)
+			return
+		case ok && (!seen || p != last):
+			if send("progress", p) != nil {
+				return
+			}
+			seen, last, lastSent = true, p, time.Now()
+		case !ok && (seen || time.Since(started) > progressWait):
+			// Never started, or forgotten since
+			send("done", DownloadProgress{RequestID: requestID, ID: id, Status: "unknown"})
+			return
+		case time.Since(lastSent) >= progressKeepalive:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+			lastSent = time.Now()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
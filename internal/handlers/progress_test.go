@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/auth"
+	"zipperfly/internal/models"
+)
+
+func TestProgressTracker(t *testing.T) {
+	tracker := &progressTracker{downloads: make(map[string]*DownloadProgress)}
+
+	tracker.start("req-1", "test", 2)
+	tracker.update("req-1", func(p *DownloadProgress) { p.FilesCompleted++ })
+	pw := &progressWriter{w: &strings.Builder{}, tracker: tracker, requestID: "req-1"}
+	pw.Write([]byte("12345"))
+
+	p, ok := tracker.get("req-1")
+	if !ok {
+		t.Fatal("get() found no progress for a started download")
+	}
+	want := DownloadProgress{RequestID: "req-1", ID: "test", Status: ProgressStreaming, FilesTotal: 2, FilesCompleted: 1, BytesWritten: 5}
+	if p != want {
+		t.Errorf("get() = %+v, want %+v", p, want)
+	}
+	if p.done() {
+		t.Error("done() = true while streaming")
+	}
+
+	tracker.finish("req-1", "completed")
+	if p, _ := tracker.get("req-1"); !p.done() || p.Status != "completed" {
+		t.Errorf("status after finish = %q, want completed", p.Status)
+	}
+
+	// Downloads without a request ID are never tracked
+	tracker.start("", "test", 1)
+	if _, ok := tracker.get(""); ok {
+		t.Error("get(\"\") found progress for an untracked download")
+	}
+}
+
+func TestHandler_Progress(t *testing.T) {
+	db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+		"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt", "b.txt"}},
+	}}
+	storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha", "bucket:b.txt": "bravo"}}
+	verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+	newHandler := func(progressEvents bool) *Handler {
+		return NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+			MaxConcurrent:    10,
+			SpoolMemoryLimit: 1 << 20,
+			SanitizeCharset:  "ascii",
+			ProgressEvents:   progressEvents,
+		})
+	}
+	serve := func(handler http.HandlerFunc, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "test"})
+		w := httptest.NewRecorder()
+		RequestIDMiddleware(handler).ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		h := newHandler(false)
+		if w := serve(h.Progress, "/test/progress?request_id=req-1"); w.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", w.Code)
+		}
+	})
+
+	t.Run("missing request ID", func(t *testing.T) {
+		h := newHandler(true)
+		if w := serve(h.Progress, "/test/progress"); w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", w.Code)
+		}
+	})
+
+	t.Run("finished download", func(t *testing.T) {
+		h := newHandler(true)
+		download := serve(h.Download, "/test?request_id=req-1")
+		if download.Code != http.StatusOK {
+			t.Fatalf("download status = %d, want 200", download.Code)
+		}
+
+		w := serve(h.Progress, "/test/progress?request_id=req-1")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+		if got := w.Header().Get("Content-Type"); got != "text/event-stream" {
+			t.Errorf("Content-Type = %q, want text/event-stream", got)
+		}
+
+		event, data, _ := strings.Cut(strings.TrimSpace(w.Body.String()), "\n")
+		if event != "event: done" {
+			t.Fatalf("event = %q, want the done event", event)
+		}
+		var p DownloadProgress
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &p); err != nil {
+			t.Fatalf("failed to decode progress: %v", err)
+		}
+		want := DownloadProgress{
+			RequestID:      "req-1",
+			ID:             "test",
+			Status:         "completed",
+			FilesTotal:     2,
+			FilesCompleted: 2,
+			BytesWritten:   int64(download.Body.Len()),
+		}
+		if p != want {
+			t.Errorf("progress = %+v, want %+v", p, want)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"zipperfly/internal/limits"
+	"zipperfly/internal/metrics"
+)
+
+// queuePoll is how often a queued download checks for a free slot, to
+// notice slots freed by other replicas sharing them through Redis; a slot
+// freed by this handler wakes its waiters at once
+const queuePoll = 250 * time.Millisecond
+
+// slotQueue lets downloads wait a bounded time for a MAX_ACTIVE_DOWNLOADS
+// slot instead of being turned away as soon as all are in use: up to
+// maxLen wait at a time, each for up to maxWait, so a brief spike is
+// absorbed rather than bounced. Waiters aren't served strictly in order.
+type slotQueue struct {
+	slots   limits.Slots
+	maxLen  int           // 0 = no queue
+	maxWait time.Duration // 0 = no queue
+	metrics *metrics.Metrics
+
+	mu      sync.Mutex
+	waiting int
+	freed   chan struct{} // closed, and replaced, whenever a slot is released
+}
+
+func newSlotQueue(slots limits.Slots, maxLen int, maxWait time.Duration, m *metrics.Metrics) *slotQueue {
+	return &slotQueue{slots: slots, maxLen: maxLen, maxWait: maxWait, metrics: m, freed: make(chan struct{})}
+}
+
+// acquire takes a slot, waiting for one to be freed if all are in use and
+// the queue has room. release must be called once when ok is true.
+func (q *slotQueue) acquire(ctx context.Context) (release func(), ok bool, err error) {
+	release, ok, err = q.tryAcquire(ctx)
+	if ok || err != nil || q.maxLen <= 0 || q.maxWait <= 0 {
+		return release, ok, err
+	}
+
+	start := time.Now()
+	if !q.join() {
+		q.metrics.DownloadQueueWait.WithLabelValues("full").Observe(0)
+		return nil, false, nil
+	}
+	defer q.leave()
+
+	timer := time.NewTimer(q.maxWait)
+	defer timer.Stop()
+	poll := time.NewTicker(queuePoll)
+	defer poll.Stop()
+	for {
+		// Take the channel before trying, so a slot freed in between
+		// still wakes this waiter
+		q.mu.Lock()
+		freed := q.freed
+		q.mu.Unlock()
+
+		release, ok, err = q.tryAcquire(ctx)
+		if ok || err != nil {
+			q.metrics.DownloadQueueWait.WithLabelValues("acquired").Observe(time.Since(start).Seconds())
+			return release, ok, err
+		}
+
+		select {
+		case <-freed:
+		case <-poll.C:
+		case <-timer.C:
+			q.metrics.DownloadQueueWait.WithLabelValues("timeout").Observe(time.Since(start).Seconds())
+			return nil, false, nil
+		case <-ctx.Done():
+			q.metrics.DownloadQueueWait.WithLabelValues("cancelled").Observe(time.Since(start).Seconds())
+			return nil, false, nil
+		}
+	}
+}
+
+// tryAcquire takes a free slot without waiting, wrapping its release to
+// wake the waiters
+func (q *slotQueue) tryAcquire(ctx context.Context) (func(), bool, error) {
+	release, ok, err := q.slots.TryAcquire(ctx)
+	if !ok || err != nil {
+		return release, ok, err
+	}
+	return func() {
+		release()
+		q.mu.Lock()
+		close(q.freed)
+		q.freed = make(chan struct{})
+		q.mu.Unlock()
+	}, true, nil
+}
+
+// join adds a waiter, returning false if the queue is full
+func (q *slotQueue) join() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.waiting >= q.maxLen {
+		return false
+	}
+	q.waiting++
+	q.metrics.DownloadQueueDepth.Inc()
+	return true
+}
+
+func (q *slotQueue) leave() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.waiting--
+	q.metrics.DownloadQueueDepth.Dec()
+}
+
+// retryAfter is the Retry-After, in seconds, sent with a rejection at
+// capacity: the queue's max wait, or 1 without a queue
+func (q *slotQueue) retryAfter() string {
+	return strconv.Itoa(max(1, int(math.Ceil(q.maxWait.Seconds()))))
+}
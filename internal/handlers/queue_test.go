@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"zipperfly/internal/limits"
+	"zipperfly/internal/metrics"
+)
+
+func TestSlotQueue(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxLen     int
+		maxWait    time.Duration
+		releaseIn  time.Duration // when the held slot is freed, 0 = never
+		wantOK     bool
+		wantResult string // queue wait result observed, "" for none
+	}{
+		{name: "no queue", maxLen: 0, maxWait: time.Second, releaseIn: 10 * time.Millisecond},
+		{name: "slot freed while waiting", maxLen: 1, maxWait: time.Second, releaseIn: 10 * time.Millisecond, wantOK: true, wantResult: "acquired"},
+		{name: "wait runs out", maxLen: 1, maxWait: 20 * time.Millisecond, wantResult: "timeout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := metrics.NewWithRegistry(prometheus.NewRegistry())
+			q := newSlotQueue(limits.NewLocalSlots(1), tt.maxLen, tt.maxWait, m)
+
+			held, ok, _ := q.acquire(context.Background())
+			if !ok {
+				t.Fatal("acquire() of a free slot failed")
+			}
+			if tt.releaseIn > 0 {
+				time.AfterFunc(tt.releaseIn, held)
+			}
+
+			start := time.Now()
+			release, ok, err := q.acquire(context.Background())
+			if err != nil || ok != tt.wantOK {
+				t.Fatalf("acquire() = %v, %v, want ok %v", ok, err, tt.wantOK)
+			}
+			if ok {
+				release()
+				// Woken by the release, not the poll
+				if waited := time.Since(start); waited >= queuePoll {
+					t.Errorf("acquire() waited %v for a freed slot", waited)
+				}
+			}
+
+			// Only the wanted result is observed: looking it up adds no
+			// series if it is already there
+			series := 0
+			if tt.wantResult != "" {
+				m.DownloadQueueWait.WithLabelValues(tt.wantResult)
+				series = 1
+			}
+			if got := testutil.CollectAndCount(m.DownloadQueueWait); got != series {
+				t.Errorf("DownloadQueueWait has %d results, want only %q", got, tt.wantResult)
+			}
+			if depth := testutil.ToFloat64(m.DownloadQueueDepth); depth != 0 {
+				t.Errorf("DownloadQueueDepth = %v after acquire(), want 0", depth)
+			}
+		})
+	}
+}
+
+func TestSlotQueue_Full(t *testing.T) {
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	q := newSlotQueue(limits.NewLocalSlots(1), 1, time.Second, m)
+	held, _, _ := q.acquire(context.Background())
+	defer held()
+
+	// One download waits, so the next finds the queue full
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting := make(chan struct{})
+	go func() {
+		defer close(waiting)
+		q.acquire(ctx)
+	}()
+	for testutil.ToFloat64(m.DownloadQueueDepth) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	if _, ok, _ := q.acquire(context.Background()); ok {
+		t.Error("acquire() with a full queue succeeded")
+	}
+	if waited := time.Since(start); waited > 100*time.Millisecond {
+		t.Errorf("acquire() with a full queue waited %v", waited)
+	}
+	if got := q.retryAfter(); got != "1" {
+		t.Errorf("retryAfter() = %q, want %q", got, "1")
+	}
+
+	cancel()
+	<-waiting
+	if depth := testutil.ToFloat64(m.DownloadQueueDepth); depth != 0 {
+		t.Errorf("DownloadQueueDepth = %v after the waiter left, want 0", depth)
+	}
+}
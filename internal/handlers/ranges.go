@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"zipperfly/internal/models"
+	"zipperfly/internal/storage"
+)
+
+var errRangeNotSatisfiable = errors.New("requested range not satisfiable")
+
+// errRangeSent is returned by rangeWriter once the whole range is sent, to
+// stop building the rest of the archive. It isn't a failure.
+var errRangeSent = errors.New("requested range sent")
+
+// byteRange is an inclusive byte range of the archive
+type byteRange struct {
+	start, end int64
+}
+
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+// parseRange parses a Range header against an archive of size bytes.
+// ok is false for headers that aren't a single byte range, which are
+// answered with the whole archive; errRangeNotSatisfiable means the range
+// lies outside it.
+func parseRange(header string, size int64) (byteRange, bool, error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return byteRange{}, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return byteRange{}, false, nil
+	}
+
+	// Suffix range: the last n bytes
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return byteRange{}, false, nil
+		}
+		if n == 0 || size == 0 {
+			return byteRange{}, false, errRangeNotSatisfiable
+		}
+		return byteRange{start: max(size-n, 0), end: size - 1}, true, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, false, nil
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return byteRange{}, false, nil
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return byteRange{}, false, errRangeNotSatisfiable
+	}
+	return byteRange{start: start, end: end}, true, nil
+}
+
+// archiveETag identifies the bytes of a reproducible archive. They depend
+// only on the objects, as of versions (see objectVersions), and their sizes
+// and metadata, the directories and extra files, and the entry order.
+func archiveETag(record *models.DownloadRecord, extras []extraFile, entryOrder string, versions map[string]int64) string {
+	type extra struct{ Name, Content string }
+	key := struct {
+		Bucket     string
+		Objects    []string
+		Versions   map[string]int64
+		Sizes      map[string]int64
+		Metadata   map[string]models.ObjectMetadata
+		Dirs       []string
+		Extras     []extra
+		EntryOrder string
+	}{
+		Bucket:     record.Bucket,
+		Objects:    record.Objects,
+		Versions:   versions,
+		Sizes:      record.ObjectSizes,
+		Metadata:   record.ObjectMetadata,
+		Dirs:       record.Directories,
+		EntryOrder: entryOrder,
+	}
+	for _, f := range extras {
+		key.Extras = append(key.Extras, extra{f.name, f.content})
+	}
+
+	data, _ := json.Marshal(key)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// objectVersions looks up when each of record's objects was last modified,
+// in Unix nanoseconds, so an object replaced with one of the same size
+// changes the archive's ETag. ok is false if storage can't look objects up
+// without fetching them, or doesn't report a modification time for one of
+// them; ranges aren't offered then, as a resumed download could splice
+// together two versions of an object.
+func (h *Handler) objectVersions(ctx context.Context, record *models.DownloadRecord) (map[string]int64, bool) {
+	if _, ok := h.storage.(storage.Stater); !ok {
+		return nil, false
+	}
+	infos, errs := storage.StatAll(ctx, h.storage, record.Bucket, record.Objects, int(h.fetchConcurrency()))
+	versions := make(map[string]int64, len(infos))
+	for i, info := range infos {
+		if errs[i] != nil || info.ModTime.IsZero() {
+			return nil, false
+		}
+		versions[record.Objects[i]] = info.ModTime.UnixNano()
+	}
+	return versions, true
+}
+
+// ifRangeMatches reports whether a Range header may be honored: there is no
+// If-Range precondition, or it names the current ETag. Dates never match
+// since archives carry no Last-Modified.
+func ifRangeMatches(r *http.Request, etag string) bool {
+	ifRange := r.Header.Get("If-Range")
+	return ifRange == "" || ifRange == etag
+}
+
+// rangeWriter passes on only the bytes of the archive stream that fall in
+// rng, sending the 206 status with the first of them. Bytes before the
+// range are discarded; a write after it fails with errRangeSent, so the
+// archive isn't built to the end for nothing.
+type rangeWriter struct {
+	w           http.ResponseWriter
+	rng         byteRange
+	off         int64 // archive offset of the next write
+	wroteHeader bool
+}
+
+func (rw *rangeWriter) Write(p []byte) (int, error) {
+	if rw.off > rw.rng.end {
+		return 0, errRangeSent
+	}
+	n := len(p)
+	start, end := rw.off, rw.off+int64(n) // end is exclusive
+	rw.off = end
+
+	lo, hi := max(start, rw.rng.start), min(end, rw.rng.end+1)
+	if lo >= hi {
+		return n, nil
+	}
+	if !rw.wroteHeader {
+		rw.w.WriteHeader(http.StatusPartialContent)
+		rw.wroteHeader = true
+	}
+	if _, err := rw.w.Write(p[lo-start : hi-start]); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// contentRange formats a Content-Range header value
+func contentRange(rng byteRange, size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", rng.start, rng.end, size)
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"zipperfly/internal/models"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    byteRange
+		wantOK  bool
+		wantErr bool
+	}{
+		{header: "bytes=0-99", want: byteRange{0, 99}, wantOK: true},
+		{header: "bytes=500-", want: byteRange{500, 999}, wantOK: true},
+		{header: "bytes=900-5000", want: byteRange{900, 999}, wantOK: true},
+		{header: "bytes=-100", want: byteRange{900, 999}, wantOK: true},
+		{header: "bytes=-5000", want: byteRange{0, 999}, wantOK: true},
+		{header: "bytes=1000-", wantErr: true},
+		{header: "bytes=-0", wantErr: true},
+		{header: "bytes=0-1,5-9"},
+		{header: "items=0-9"},
+		{header: "bytes=9-0"},
+		{header: "bytes=abc-"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got, ok, err := parseRange(tt.header, 1000)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("parseRange() = %v, %v; want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRangeWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := &rangeWriter{w: rec, rng: byteRange{start: 3, end: 7}}
+
+	for _, chunk := range []string{"ab", "cdef", "ghij"} {
+		if n, err := rw.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+	if _, err := rw.Write([]byte("kl")); !errors.Is(err, errRangeSent) {
+		t.Errorf("Write after the range = %v, want errRangeSent", err)
+	}
+	if rec.Code != 206 {
+		t.Errorf("status = %d, want 206", rec.Code)
+	}
+	if got := rec.Body.String(); got != "defgh" {
+		t.Errorf("body = %q, want defgh", got)
+	}
+}
+
+func TestArchiveETag(t *testing.T) {
+	record := &models.DownloadRecord{Bucket: "b", Objects: []string{"a.txt"}, ObjectSizes: map[string]int64{"a.txt": 5}}
+	versions := map[string]int64{"a.txt": 1700000000}
+	etag := archiveETag(record, nil, EntryOrderRecord, versions)
+
+	if archiveETag(record, nil, EntryOrderRecord, versions) != etag {
+		t.Error("ETag is not stable")
+	}
+	changed := *record
+	changed.ObjectSizes = map[string]int64{"a.txt": 6}
+	if archiveETag(&changed, nil, EntryOrderRecord, versions) == etag {
+		t.Error("ETag unchanged after object size change")
+	}
+	if archiveETag(record, nil, EntryOrderRecord, map[string]int64{"a.txt": 1700000001}) == etag {
+		t.Error("ETag unchanged after object replaced with the same size")
+	}
+	if archiveETag(record, []extraFile{{name: "NOTICE.txt", content: "x"}}, EntryOrderRecord, versions) == etag {
+		t.Error("ETag unchanged after adding an extra file")
+	}
+	if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+		t.Errorf("ETag %s is not quoted", etag)
+	}
+}
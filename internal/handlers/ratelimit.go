@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/limits"
+	"zipperfly/internal/metrics"
+)
+
+// RateLimiter limits requests per client IP and per download ID
+type RateLimiter struct {
+	logger    *zap.Logger
+	metrics   *metrics.Metrics
+	perIP     limits.Rate // nil = no per-IP limit
+	perRecord limits.Rate // nil = no per-record limit
+}
+
+// NewRateLimiter creates a rate limit keyed by client IP, as GetClientIP
+// tells them apart, and by the route's {id}. Either rate may be nil.
+func NewRateLimiter(logger *zap.Logger, m *metrics.Metrics, perIP, perRecord limits.Rate) *RateLimiter {
+	return &RateLimiter{
+		logger:    logger,
+		metrics:   m,
+		perIP:     perIP,
+		perRecord: perRecord,
+	}
+}
+
+// Middleware rejects requests over either rate with 429 and a Retry-After
+// header. If the limit store fails, requests are let through.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := GetClientIP(r)
+		if l.throttled(w, r, l.perIP, ip, zap.String("ip", ip)) {
+			return
+		}
+		if id := mux.Vars(r)["id"]; id != "" && l.throttled(w, r, l.perRecord, id, zap.String("id", id)) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// throttled takes a token for key from rate, writing the 429 response and
+// returning true if none was available
+func (l *RateLimiter) throttled(w http.ResponseWriter, r *http.Request, rate limits.Rate, key string, field zap.Field) bool {
+	if rate == nil {
+		return false
+	}
+	wait, err := rate.Reserve(r.Context(), key)
+	if err != nil {
+		l.metrics.LimiterErrorsTotal.Inc()
+		requestLogger(r.Context(), l.logger).Warn("rate limit unavailable, allowing request", field, zap.Error(err))
+		return false
+	}
+	if wait <= 0 {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "rate limit exceeded, please retry later", http.StatusTooManyRequests)
+	l.metrics.RequestsTotal.WithLabelValues("429").Inc()
+	l.metrics.RequestsThrottledTotal.Inc()
+	requestLogger(r.Context(), l.logger).Warn("request rejected: rate limit exceeded", field, zap.String("path", r.URL.Path), zap.Duration("retry_after", wait.Round(time.Millisecond)))
+	return true
+}
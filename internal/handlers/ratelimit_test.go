@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/limits"
+)
+
+// failingRate is a limits.Rate whose store is down
+type failingRate struct{}
+
+func (failingRate) Reserve(context.Context, string) (time.Duration, error) {
+	return 0, errors.New("connection refused")
+}
+
+func (failingRate) SetLimit(float64, int) {}
+
+func TestRateLimiter_Middleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		perIP     limits.Rate
+		perRecord limits.Rate
+		requests  []struct{ ip, id string }
+		want      []int
+	}{
+		{
+			name:     "per IP",
+			perIP:    limits.NewLocalRate(0.5, 2),
+			requests: []struct{ ip, id string }{{"203.0.113.7", "a"}, {"203.0.113.7", "b"}, {"203.0.113.7", "c"}, {"198.51.100.1", "a"}},
+			want:     []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests, http.StatusOK},
+		},
+		{
+			name:      "per record",
+			perRecord: limits.NewLocalRate(0.5, 1),
+			requests:  []struct{ ip, id string }{{"203.0.113.7", "a"}, {"198.51.100.1", "a"}, {"198.51.100.1", "b"}},
+			want:      []int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK},
+		},
+		{
+			name:     "store down lets requests through",
+			perIP:    failingRate{},
+			requests: []struct{ ip, id string }{{"203.0.113.7", "a"}, {"203.0.113.7", "a"}},
+			want:     []int{http.StatusOK, http.StatusOK},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewRateLimiter(zap.NewNop(), sharedMetrics, tt.perIP, tt.perRecord)
+			handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			for i, req := range tt.requests {
+				r := httptest.NewRequest(http.MethodGet, "/"+req.id, nil)
+				r.RemoteAddr = req.ip + ":5555"
+				r = mux.SetURLVars(r, map[string]string{"id": req.id})
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+
+				if w.Code != tt.want[i] {
+					t.Fatalf("request %d: status = %d, want %d", i+1, w.Code, tt.want[i])
+				}
+				if w.Code != http.StatusTooManyRequests {
+					continue
+				}
+				if retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retryAfter < 1 || retryAfter > 2 {
+					t.Errorf("Retry-After = %q, want 1-2 seconds", w.Header().Get("Retry-After"))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/models"
+	"zipperfly/internal/storage"
+)
+
+// genericContentTypes are storage defaults that say nothing about the content
+var genericContentTypes = map[string]bool{
+	"":                         true,
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+}
+
+// serveRaw streams the plan's single object as itself, with its own content
+// type and filename, instead of wrapping it in an archive
+func (h *Handler) serveRaw(w http.ResponseWriter, r *http.Request, plan *downloadPlan, start time.Time) {
+	ctx := r.Context()
+	id, record := plan.id, plan.record
+	key := record.Objects[0]
+	tenant := h.tenantLabel(record)
+
+	obj, err := h.storage.GetObject(ctx, record.Bucket, key)
+	if err != nil {
+		http.Error(w, "file not found", http.StatusNotFound)
+		h.log(ctx).Error("raw download fetch failed", zap.Error(err), zap.String("id", id), zap.String("key", key))
+		h.metrics.FilesFetchTotal.WithLabelValues("missing", tenant).Inc()
+		h.metrics.RequestsTotal.WithLabelValues("404").Inc()
+		return
+	}
+	defer obj.Close()
+
+	if h.maxFileSize > 0 && obj.Size > h.maxFileSize {
+		http.Error(w, "file exceeds MAX_FILE_SIZE", http.StatusRequestEntityTooLarge)
+		h.log(ctx).Warn("raw download over size limit", zap.String("id", id), zap.String("key", key), zap.Int64("size", obj.Size))
+		h.metrics.FilesFetchTotal.WithLabelValues("too_large", tenant).Inc()
+		h.metrics.SizeLimitsTotal.WithLabelValues("file").Inc()
+		h.metrics.RequestsTotal.WithLabelValues("413").Inc()
+		return
+	}
+
+	body := bufio.NewReader(obj)
+	for k, v := range record.CustomHeaders {
+		w.Header().Set(k, v)
+	}
+	w.Header().Set("Content-Type", rawContentType(obj, key, body))
+	w.Header().Set("Content-Disposition", contentDisposition(h.rawFilename(record.Name, key)))
+	w.Header().Set(FilesTotalHeader, "1")
+	w.Header().Set("X-Accel-Buffering", "no") // stop nginx from buffering the stream
+	if obj.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+	}
+	if !obj.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", obj.ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		h.metrics.RequestsTotal.WithLabelValues("200").Inc()
+		return
+	}
+	w.Header().Set("Trailer", StatusTrailer+", "+FilesIncludedTrailer+", "+RequestIDTrailer)
+	started := h.notifyStarted(ctx, record.Callbacks, id, 1)
+
+	var src io.Reader = body
+	if h.maxFileSize > 0 {
+		src = &fileSizeReader{r: body, key: key, limit: h.maxFileSize}
+	}
+	n, err := io.Copy(h.withCallbackProgress(ctx, h.withFlush(w, w), record.Callbacks, id, 1, start), src)
+
+	if ctx.Err() != nil {
+		h.logCancelled(ctx, id)
+	}
+
+	status, message, included := "completed", "", 1
+	limitExceeded := sizeLimitExceeded(err)
+	if err != nil {
+		status, message, included = "failed", err.Error(), 0
+		h.log(ctx).Error("raw download failed", zap.Error(err), zap.String("id", id), zap.String("key", key))
+		h.metrics.FilesFetchTotal.WithLabelValues(fetchFailureResult(err, "error"), tenant).Inc()
+		if limitExceeded != "" {
+			h.metrics.SizeLimitsTotal.WithLabelValues(limitExceeded).Inc()
+		}
+	} else {
+		h.metrics.FilesFetchTotal.WithLabelValues("success", tenant).Inc()
+		activeFromContext(ctx).fileDone()
+	}
+	w.Header().Set(StatusTrailer, status)
+	w.Header().Set(FilesIncludedTrailer, strconv.Itoa(included))
+	w.Header().Set(RequestIDTrailer, GetRequestID(ctx))
+
+	if status == "completed" && ctx.Err() == nil {
+		h.countDownload(ctx, plan)
+	}
+
+	duration := time.Since(start)
+	h.metrics.DurationHist.Observe(duration.Seconds())
+	h.metrics.OutgoingBytesHist.WithLabelValues(tenant).Observe(float64(n))
+	h.metrics.IncomingBytesHist.Observe(float64(n))
+	h.metrics.DownloadsTotal.WithLabelValues(status, tenant).Inc()
+	h.metrics.RequestsTotal.WithLabelValues("200").Inc()
+	h.metrics.FilesRequestedHist.Observe(1)
+	h.metrics.FilesSuccessHist.Observe(float64(included))
+	files := h.rawFileResult(key, status, n, err, duration)
+	h.reportThresholds(ctx, downloadSummary{id: id, status: status, duration: duration, outBytes: n, inBytes: n, files: files})
+
+	h.notifyOutcome(ctx, started, record.Callbacks, models.CallbackPayload{
+		ID:                  id,
+		Status:              status,
+		Timestamp:           time.Now().UTC().Format(time.RFC3339),
+		Message:             message,
+		DurationMs:          duration.Milliseconds(),
+		FileCount:           1,
+		CompressedSizeBytes: n,
+		LimitExceeded:       limitExceeded,
+		Files:               files,
+	})
+
+	h.log(ctx).Info("raw download handled", zap.String("id", id), zap.String("status", status), zap.Duration("duration", duration))
+}
+
+// rawFileResult is the raw download's object outcome for the callback, with
+// CALLBACK_FILE_RESULTS, and for slow and large download reports
+func (h *Handler) rawFileResult(key, status string, n int64, err error, duration time.Duration) []models.FileResult {
+	if !h.collectFileResults() {
+		return nil
+	}
+	result := models.FileResult{Key: key, Status: "success", Bytes: n, DurationMs: duration.Milliseconds()}
+	if status != "completed" {
+		result.Status = "error"
+		result.Error = err.Error()
+	}
+	return []models.FileResult{result}
+}
+
+// rawContentType returns the object's MIME type: as recorded by storage,
+// else from its extension, else sniffed from its first bytes
+func rawContentType(obj *storage.Object, key string, body *bufio.Reader) string {
+	if !genericContentTypes[obj.ContentType] {
+		return obj.ContentType
+	}
+	if ct := mime.TypeByExtension(filepath.Ext(key)); ct != "" {
+		return ct
+	}
+	head, _ := body.Peek(512)
+	return http.DetectContentType(head)
+}
+
+// rawFilename returns the download filename of a raw object: the record
+// name with the object's extension, or the object's own name
+func (h *Handler) rawFilename(name, key string) string {
+	base := filepath.Base(key)
+	if name != "" && h.sanitizeNames {
+		name = sanitizeFilename(name, h.sanitizeCharset == "ascii")
+	}
+	if name == "" {
+		return base
+	}
+	if ext := filepath.Ext(base); !strings.EqualFold(filepath.Ext(name), ext) {
+		name += ext
+	}
+	return name
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/auth"
+	"zipperfly/internal/models"
+)
+
+func TestHandler_Download_Raw(t *testing.T) {
+	tests := []struct {
+		name            string
+		record          models.DownloadRecord
+		query           string
+		wantCode        int
+		wantType        string
+		wantDisposition string
+	}{
+		{
+			name:            "query parameter",
+			record:          models.DownloadRecord{Objects: []string{"notes/a.txt"}},
+			query:           "?raw=1",
+			wantCode:        http.StatusOK,
+			wantType:        "text/plain; charset=utf-8",
+			wantDisposition: `attachment; filename="a.txt"`,
+		},
+		{
+			name:            "record flag with name",
+			record:          models.DownloadRecord{Objects: []string{"docs/q3.pdf"}, Name: "report", Raw: true},
+			wantCode:        http.StatusOK,
+			wantType:        "application/pdf",
+			wantDisposition: `attachment; filename="report.pdf"`,
+		},
+		{
+			name:            "sniffed type",
+			record:          models.DownloadRecord{Objects: []string{"page"}},
+			query:           "?raw=true",
+			wantCode:        http.StatusOK,
+			wantType:        "text/html; charset=utf-8",
+			wantDisposition: `attachment; filename="page"`,
+		},
+		{name: "query overrides record", record: models.DownloadRecord{Objects: []string{"notes/a.txt"}, Raw: true}, query: "?raw=0", wantCode: http.StatusOK, wantType: "application/zip"},
+		{name: "multiple files", record: models.DownloadRecord{Objects: []string{"notes/a.txt", "page"}}, query: "?raw=1", wantCode: http.StatusBadRequest},
+		{name: "password protected", record: models.DownloadRecord{Objects: []string{"notes/a.txt"}, Password: "secret"}, query: "?raw=1", wantCode: http.StatusBadRequest},
+		{name: "invalid parameter", record: models.DownloadRecord{Objects: []string{"notes/a.txt"}}, query: "?raw=maybe", wantCode: http.StatusBadRequest},
+	}
+
+	files := map[string]string{
+		"bucket:notes/a.txt": "alpha",
+		"bucket:docs/q3.pdf": "%PDF-1.4",
+		"bucket:page":        "<html><body>hi</body></html>",
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := tt.record
+			record.ID, record.Bucket = "test", "bucket"
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{"test": &record}}
+			verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+			h := NewDownloadHandler(zap.NewNop(), db, &mockDownloadStorage{files: files}, verifier, sharedMetrics, HandlerOptions{
+				MaxConcurrent:          10,
+				AllowPasswordProtected: true,
+				SpoolMemoryLimit:       1 << 20,
+				SanitizeCharset:        "ascii",
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/test"+tt.query, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			h.Download(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if tt.wantDisposition == "" {
+				return
+			}
+			if got := w.Header().Get("Content-Disposition"); got != tt.wantDisposition {
+				t.Errorf("Content-Disposition = %q, want %q", got, tt.wantDisposition)
+			}
+			if want := files["bucket:"+record.Objects[0]]; w.Body.String() != want {
+				t.Errorf("body = %q, want %q", w.Body.String(), want)
+			}
+			if got := w.Result().Trailer.Get(StatusTrailer); got != "completed" {
+				t.Errorf("%s trailer = %q, want completed", StatusTrailer, got)
+			}
+			if record.DownloadCount != 1 {
+				t.Errorf("DownloadCount = %d, want 1", record.DownloadCount)
+			}
+		})
+	}
+}
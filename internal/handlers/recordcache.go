@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/database"
+	"zipperfly/internal/models"
+)
+
+// recordCache keeps recently fetched records in memory: served without a
+// query for ttl, and kept for up to maxStale to serve while the database is
+// failing. Records with a download limit aren't cached, since a cached
+// download count can't enforce it.
+type recordCache struct {
+	ttl      time.Duration // 0 = always query the database
+	maxStale time.Duration // 0 = never serve a stale record
+	size     int
+
+	mu      sync.Mutex
+	records map[string]cachedRecord
+}
+
+// cachedRecord is a record and when it was fetched
+type cachedRecord struct {
+	record  models.DownloadRecord
+	fetched time.Time
+}
+
+// newRecordCache creates a cache of up to size records, or returns nil if
+// neither ttl nor maxStale is set
+func newRecordCache(ttl, maxStale time.Duration, size int) *recordCache {
+	if (ttl <= 0 && maxStale <= 0) || size <= 0 {
+		return nil
+	}
+	return &recordCache{ttl: ttl, maxStale: maxStale, size: size, records: make(map[string]cachedRecord)}
+}
+
+// get returns a copy of id's record if it was fetched less than maxAge ago
+func (c *recordCache) get(id string, maxAge time.Duration) (*models.DownloadRecord, time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.records[id]
+	if !ok {
+		return nil, 0, false
+	}
+	age := time.Since(cached.fetched)
+	if age >= maxAge {
+		return nil, age, false
+	}
+	record := cached.record
+	return &record, age, true
+}
+
+// put keeps a copy of id's record, if it has no download limit. When the
+// cache is full, expired records are dropped first, then an arbitrary one.
+func (c *recordCache) put(id string, record *models.DownloadRecord) {
+	if record.MaxDownloads > 0 || record.SingleUse {
+		c.remove(id)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.records[id]; !ok && len(c.records) >= c.size {
+		keep := max(c.ttl, c.maxStale)
+		for other, cached := range c.records {
+			if time.Since(cached.fetched) >= keep {
+				delete(c.records, other)
+			}
+		}
+		for other := range c.records {
+			if len(c.records) < c.size {
+				break
+			}
+			delete(c.records, other)
+		}
+	}
+	c.records[id] = cachedRecord{record: *record, fetched: time.Now()}
+}
+
+// remove drops id's record
+func (c *recordCache) remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.records, id)
+}
+
+// getRecord fetches a record, through the record cache if there is one.
+// With RECORD_CACHE_STALE, a database error other than the record not
+// existing is answered with the cached record, if it isn't too old.
+func (h *Handler) getRecord(ctx context.Context, id string) (*models.DownloadRecord, error) {
+	if h.records == nil {
+		return h.db.GetRecord(ctx, id)
+	}
+	if record, _, ok := h.records.get(id, h.records.ttl); ok {
+		h.metrics.RecordCacheLookups.WithLabelValues("hit").Inc()
+		return record, nil
+	}
+	h.metrics.RecordCacheLookups.WithLabelValues("miss").Inc()
+
+	record, err := h.db.GetRecord(ctx, id)
+	if err == nil {
+		h.records.put(id, record)
+		return record, nil
+	}
+	if database.IsNotFound(err) {
+		h.records.remove(id)
+		return nil, err
+	}
+	if h.records.maxStale <= 0 || ctx.Err() != nil {
+		return nil, err
+	}
+	stale, age, ok := h.records.get(id, h.records.maxStale)
+	if !ok {
+		return nil, err
+	}
+	h.metrics.DegradedRecordsServed.Inc()
+	h.log(ctx).Warn("database lookup failed, serving cached record", zap.String("id", id), zap.Duration("age", age), zap.Error(err))
+	return stale, nil
+}
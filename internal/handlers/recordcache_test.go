@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/database"
+	"zipperfly/internal/metrics"
+	"zipperfly/internal/models"
+)
+
+// flakyDB is a store whose lookups fail with err while it is set
+type flakyDB struct {
+	mockDownloadDB
+	err     error
+	queries int
+}
+
+func (f *flakyDB) GetRecord(ctx context.Context, id string) (*models.DownloadRecord, error) {
+	f.queries++
+	if f.err != nil {
+		return nil, f.err
+	}
+	record, ok := f.records[id]
+	if !ok {
+		return nil, database.ErrRecordNotFound
+	}
+	copied := *record
+	return &copied, nil
+}
+
+func TestGetRecord_Cache(t *testing.T) {
+	outage := errors.New("connection refused")
+
+	tests := []struct {
+		name        string
+		ttl         time.Duration
+		stale       time.Duration
+		record      *models.DownloadRecord
+		dbErr       error
+		wantErr     bool
+		wantQueries int // after the first, successful lookup and a second one
+		wantStale   float64
+	}{
+		{name: "fresh hit", ttl: time.Minute, record: &models.DownloadRecord{Bucket: "b"}, wantQueries: 1},
+		{name: "stale on outage", stale: time.Hour, record: &models.DownloadRecord{Bucket: "b"}, dbErr: outage, wantQueries: 2, wantStale: 1},
+		{name: "outage without stale", ttl: time.Nanosecond, record: &models.DownloadRecord{Bucket: "b"}, dbErr: outage, wantErr: true, wantQueries: 2},
+		{name: "deleted record", stale: time.Hour, record: &models.DownloadRecord{Bucket: "b"}, dbErr: database.ErrRecordNotFound, wantErr: true, wantQueries: 2},
+		{name: "limited record isn't cached", ttl: time.Minute, stale: time.Hour, record: &models.DownloadRecord{Bucket: "b", SingleUse: true}, dbErr: outage, wantErr: true, wantQueries: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := metrics.NewWithRegistry(nil)
+			db := &flakyDB{mockDownloadDB: mockDownloadDB{records: map[string]*models.DownloadRecord{"rec": tt.record}}}
+			h := NewDownloadHandler(zap.NewNop(), db, nil, nil, m, HandlerOptions{RecordCacheTTL: tt.ttl, RecordCacheStale: tt.stale, RecordCacheSize: 10})
+			ctx := context.Background()
+
+			first, err := h.getRecord(ctx, "rec")
+			if err != nil {
+				t.Fatalf("first getRecord() error = %v", err)
+			}
+			first.Objects = []string{"changed.txt"} // the handler narrows records in place
+			time.Sleep(time.Millisecond)
+
+			db.err = tt.dbErr
+			record, err := h.getRecord(ctx, "rec")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("second getRecord() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (record.Bucket != "b" || len(record.Objects) != 0) {
+				t.Errorf("second getRecord() = %+v, want the record as fetched", record)
+			}
+			if db.queries != tt.wantQueries {
+				t.Errorf("database queried %d times, want %d", db.queries, tt.wantQueries)
+			}
+			if got := testutil.ToFloat64(m.DegradedRecordsServed); got != tt.wantStale {
+				t.Errorf("degraded records served = %g, want %g", got, tt.wantStale)
+			}
+		})
+	}
+}
+
+func TestRecordCache_Size(t *testing.T) {
+	c := newRecordCache(time.Minute, 0, 2)
+	for _, id := range []string{"a", "b", "c"} {
+		c.put(id, &models.DownloadRecord{ID: id})
+	}
+	if len(c.records) != 2 {
+		t.Errorf("cache holds %d records, want 2", len(c.records))
+	}
+	if _, _, ok := c.get("c", time.Minute); !ok {
+		t.Error("get() of the newest record ok = false")
+	}
+	if newRecordCache(0, 0, 10) != nil {
+		t.Error("newRecordCache() without a TTL or stale age isn't nil")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
 type contextKey string
@@ -14,8 +15,12 @@ const requestIDKey contextKey = "request_id"
 // RequestIDMiddleware adds a unique request ID to each request
 func RequestIDMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Check if request already has an ID (from X-Request-ID header)
+		// Check if request already has an ID (from X-Request-ID header, or the
+		// request_id query parameter for links that can't set headers)
 		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = r.URL.Query().Get("request_id")
+		}
 		if requestID == "" {
 			// Generate new UUID
 			requestID = uuid.New().String()
@@ -39,3 +44,22 @@ func GetRequestID(ctx context.Context) string {
 	}
 	return ""
 }
+
+// requestLogger returns logger with the request ID from ctx, if any, as a
+// field on every entry, so a download's log lines can be correlated
+func requestLogger(ctx context.Context, logger *zap.Logger) *zap.Logger {
+	return withRequestID(logger, GetRequestID(ctx))
+}
+
+// withRequestID returns logger with a request_id field, unless id is empty
+func withRequestID(logger *zap.Logger, id string) *zap.Logger {
+	if id == "" {
+		return logger
+	}
+	return logger.With(zap.String("request_id", id))
+}
+
+// log returns h.logger with the request ID from ctx
+func (h *Handler) log(ctx context.Context) *zap.Logger {
+	return requestLogger(ctx, h.logger)
+}
@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestRequestIDMiddleware(t *testing.T) {
@@ -59,6 +63,19 @@ func TestRequestIDMiddleware(t *testing.T) {
 		}
 	})
 
+	t.Run("honors request ID query parameter", func(t *testing.T) {
+		existingID := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+		req := httptest.NewRequest("GET", "/test?request_id="+existingID, nil)
+		w := httptest.NewRecorder()
+
+		middleware.ServeHTTP(w, req)
+
+		reqID := w.Header().Get("X-Request-ID")
+		if reqID != existingID {
+			t.Errorf("X-Request-ID = %s, want %s", reqID, existingID)
+		}
+	})
+
 	t.Run("different requests get different IDs", func(t *testing.T) {
 		req1 := httptest.NewRequest("GET", "/test", nil)
 		w1 := httptest.NewRecorder()
@@ -87,3 +104,25 @@ func TestGetRequestID(t *testing.T) {
 		}
 	})
 }
+
+func TestRequestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	var ctx context.Context
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { ctx = r.Context() })
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	RequestIDMiddleware(handler).ServeHTTP(httptest.NewRecorder(), req)
+
+	requestLogger(ctx, logger).Info("with ID")
+	requestLogger(context.Background(), logger).Info("without ID")
+
+	entries := logs.All()
+	if got := entries[0].ContextMap()["request_id"]; got != "req-1" {
+		t.Errorf("request_id = %v, want req-1", got)
+	}
+	if _, ok := entries[1].ContextMap()["request_id"]; ok {
+		t.Error("request_id logged for a context without one")
+	}
+}
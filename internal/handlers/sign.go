@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"zipperfly/sign"
+)
+
+// maxSignRequestSize caps the POST /sign request body
+const maxSignRequestSize = 64 << 10
+
+// SignHandler issues signed download URLs, so integrators don't build the
+// HMAC payload themselves
+type SignHandler struct {
+	logger     *zap.Logger
+	mu         sync.RWMutex // guards signer
+	signer     sign.Signer
+	defaultTTL time.Duration
+}
+
+// NewSignHandler creates a handler that signs links with secret, using
+// version 2 signatures and naming keyID when set. publicURL is the links'
+// base; without one, links use the scheme and host the request came in on.
+func NewSignHandler(logger *zap.Logger, secret []byte, keyID, publicURL string, defaultTTL time.Duration) *SignHandler {
+	return &SignHandler{
+		logger:     logger,
+		signer:     sign.Signer{BaseURL: publicURL, Secret: secret, KeyID: keyID, Version: 2},
+		defaultTTL: defaultTTL,
+	}
+}
+
+// SetSecret replaces the secret and key ID new links are signed with,
+// e.g. when they are refreshed from a secrets manager
+func (h *SignHandler) SetSecret(secret []byte, keyID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.signer.Secret = secret
+	h.signer.KeyID = keyID
+}
+
+type signRequest struct {
+	ID        string   `json:"id"`
+	ExpiresIn int64    `json:"expires_in,omitempty"` // seconds, 0 = the default TTL
+	Files     []string `json:"files,omitempty"`      // object keys or 0-based indexes, none = the whole record
+	Format    string   `json:"format,omitempty"`     // ?format=, "" = the record's format
+	Raw       *bool    `json:"raw,omitempty"`        // ?raw=, nil = the record's setting
+	Method    string   `json:"method,omitempty"`     // GET (default), or POST for /prepare
+	Password  string   `json:"password,omitempty"`   // ?password=, for records with password_hash or password_required
+}
+
+type signResponse struct {
+	URL    string `json:"url"`
+	Expiry int64  `json:"expiry"` // Unix timestamp
+}
+
+// Sign returns a signed download URL for the requested ID
+func (h *SignHandler) Sign(w http.ResponseWriter, r *http.Request) {
+	var req signRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSignRequestSize)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresIn < 0 {
+		http.Error(w, "expires_in cannot be negative", http.StatusBadRequest)
+		return
+	}
+	method := strings.ToUpper(req.Method)
+	switch method {
+	case "", "GET", "HEAD", "POST":
+	default:
+		http.Error(w, "method must be GET or POST", http.StatusBadRequest)
+		return
+	}
+	var raw string
+	if req.Raw != nil {
+		raw = strconv.FormatBool(*req.Raw)
+	}
+
+	ttl := h.defaultTTL
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	expiry := time.Now().Add(ttl)
+
+	h.mu.RLock()
+	signer := h.signer
+	h.mu.RUnlock()
+	if signer.BaseURL == "" {
+		// Under a tenant's path prefix, links go under the same prefix
+		signer.BaseURL = requestBaseURL(r) + strings.TrimSuffix(r.URL.Path, "/sign")
+	}
+	url, err := signer.SignURL(sign.Link{
+		ID:       req.ID,
+		Expiry:   expiry,
+		Files:    req.Files,
+		Format:   req.Format,
+		Raw:      raw,
+		Method:   method,
+		Password: req.Password,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	requestLogger(r.Context(), h.logger).Info("signed download URL", zap.String("id", req.ID), zap.Time("expiry", expiry))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(signResponse{URL: url, Expiry: expiry.Unix()})
+}
+
+// requestBaseURL returns the scheme and host r was sent to, with the scheme
+// a trusted TLS-terminating proxy forwarded
+func requestBaseURL(r *http.Request) string {
+	return GetClientScheme(r) + "://" + r.Host
+}
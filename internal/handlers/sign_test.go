@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/auth"
+)
+
+func TestSignHandler_Sign(t *testing.T) {
+	secret := []byte("new-secret")
+	verifier := auth.NewVerifier(map[string][]byte{"2025": secret, "2024": []byte("old-secret")}, true, 1, sharedMetrics, nil)
+	// httptest requests come from 192.0.2.1
+	_, proxies, _ := net.ParseCIDR("192.0.2.0/24")
+
+	tests := []struct {
+		name       string
+		publicURL  string
+		body       string
+		header     map[string]string
+		remoteAddr string
+		wantStatus int
+		wantBase   string
+		wantTTL    time.Duration
+		wantFiles  string
+	}{
+		{
+			name:       "default TTL",
+			body:       `{"id": "123"}`,
+			wantStatus: http.StatusOK,
+			wantBase:   "http://zipperfly.test/123",
+			wantTTL:    time.Hour,
+		},
+		{
+			name:       "expires in and files",
+			publicURL:  "https://egress.example.com",
+			body:       `{"id": "123", "expires_in": 900, "files": ["a.txt", "2"]}`,
+			wantStatus: http.StatusOK,
+			wantBase:   "https://egress.example.com/123",
+			wantTTL:    15 * time.Minute,
+			wantFiles:  "a.txt,2",
+		},
+		{
+			name:       "forwarded proto",
+			body:       `{"id": "a b"}`,
+			header:     map[string]string{"X-Forwarded-Proto": "https"},
+			wantStatus: http.StatusOK,
+			wantBase:   "https://zipperfly.test/a%20b",
+			wantTTL:    time.Hour,
+		},
+		{
+			name:       "forwarded proto from untrusted client",
+			body:       `{"id": "123"}`,
+			header:     map[string]string{"X-Forwarded-Proto": "https"},
+			remoteAddr: "203.0.113.7:5555",
+			wantStatus: http.StatusOK,
+			wantBase:   "http://zipperfly.test/123",
+			wantTTL:    time.Hour,
+		},
+		{
+			name:       "format and raw",
+			body:       `{"id": "123", "format": "tar.gz", "raw": true}`,
+			wantStatus: http.StatusOK,
+			wantBase:   "http://zipperfly.test/123",
+			wantTTL:    time.Hour,
+		},
+		{name: "missing id", body: `{}`, wantStatus: http.StatusBadRequest},
+		{name: "unsupported method", body: `{"id": "123", "method": "DELETE"}`, wantStatus: http.StatusBadRequest},
+		{name: "negative expires in", body: `{"id": "123", "expires_in": -1}`, wantStatus: http.StatusBadRequest},
+		{name: "comma in file", body: `{"id": "123", "files": ["a,b.txt"]}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid JSON", body: `{"id": `, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewSignHandler(zap.NewNop(), secret, "2025", tt.publicURL, time.Hour)
+
+			req := httptest.NewRequest("POST", "http://zipperfly.test/sign", strings.NewReader(tt.body))
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			ClientIPMiddleware([]*net.IPNet{proxies}, "")(http.HandlerFunc(h.Sign)).ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp signResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if ttl := time.Until(time.Unix(resp.Expiry, 0)); ttl > tt.wantTTL || ttl < tt.wantTTL-time.Minute {
+				t.Errorf("expiry is %s away, want %s", ttl, tt.wantTTL)
+			}
+
+			base, rawQuery, _ := strings.Cut(resp.URL, "?")
+			if base != tt.wantBase {
+				t.Errorf("URL base = %q, want %q", base, tt.wantBase)
+			}
+			query, err := url.ParseQuery(rawQuery)
+			if err != nil {
+				t.Fatalf("invalid URL query %q: %v", rawQuery, err)
+			}
+			if query.Get("files") != tt.wantFiles {
+				t.Errorf("files = %q, want %q", query.Get("files"), tt.wantFiles)
+			}
+
+			// The link must pass the download handler's verification
+			id, _ := url.PathUnescape(base[strings.LastIndex(base, "/")+1:])
+			if err := verifier.Verify(context.Background(), auth.RequestParams(httptest.NewRequest("GET", resp.URL, nil), id)); err != nil {
+				t.Errorf("signed URL failed verification: %v", err)
+			}
+		})
+	}
+}
+
+func TestSignHandler_SetSecret(t *testing.T) {
+	h := NewSignHandler(zap.NewNop(), []byte("old-secret"), "2024", "", time.Hour)
+	h.SetSecret([]byte("new-secret"), "2025")
+
+	w := httptest.NewRecorder()
+	h.Sign(w, httptest.NewRequest("POST", "http://zipperfly.test/sign", strings.NewReader(`{"id": "123"}`)))
+	var resp signResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	verifier := auth.NewVerifier(map[string][]byte{"2025": []byte("new-secret")}, true, 1, sharedMetrics, nil)
+	if err := verifier.Verify(context.Background(), auth.RequestParams(httptest.NewRequest("GET", resp.URL, nil), "123")); err != nil {
+		t.Errorf("link signed after SetSecret failed verification: %v", err)
+	}
+}
+
+func TestSignHandler_PathPrefix(t *testing.T) {
+	h := NewSignHandler(zap.NewNop(), []byte("test-secret"), "", "", time.Hour)
+
+	w := httptest.NewRecorder()
+	h.Sign(w, httptest.NewRequest("POST", "http://zipperfly.test/acme/sign", strings.NewReader(`{"id": "123"}`)))
+	var resp signResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !strings.HasPrefix(resp.URL, "http://zipperfly.test/acme/123?") {
+		t.Errorf("URL = %q, want it under the /acme prefix", resp.URL)
+	}
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+var errSpoolClosed = errors.New("spool buffer closed")
+
+// spoolBuffer is a single-producer, single-consumer pipe whose writer never
+// blocks on the reader: the first memLimit bytes are kept in memory and the
+// rest spill to a temporary file. Reads block until data is available, so the
+// archive writer can stream a file while it is still being fetched.
+type spoolBuffer struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	memLimit int
+	dir      string
+
+	mem  []byte
+	file *os.File // spill file, created on first overflow
+	size int64    // total bytes written
+	off  int64    // read offset
+
+	done   bool  // writer finished
+	err    error // writer error, reported once the data is drained
+	closed bool  // reader closed; further writes fail
+}
+
+func newSpoolBuffer(memLimit int, dir string) *spoolBuffer {
+	b := &spoolBuffer{memLimit: memLimit, dir: dir}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *spoolBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer b.cond.Broadcast()
+
+	if b.closed {
+		return 0, errSpoolClosed
+	}
+
+	n := 0
+	if b.file == nil {
+		n = min(b.memLimit-len(b.mem), len(p))
+		b.mem = append(b.mem, p[:n]...)
+	}
+	if n < len(p) {
+		if b.file == nil {
+			f, err := os.CreateTemp(b.dir, "zipperfly-spool-*")
+			if err != nil {
+				b.size += int64(n)
+				return n, err
+			}
+			b.file = f
+		}
+		m, err := b.file.WriteAt(p[n:], b.size+int64(n)-int64(len(b.mem)))
+		n += m
+		if err != nil {
+			b.size += int64(n)
+			return n, err
+		}
+	}
+
+	b.size += int64(n)
+	return n, nil
+}
+
+// CloseWithError marks the end of the data. A non-nil err is returned to the
+// reader after the data written so far.
+func (b *spoolBuffer) CloseWithError(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done = true
+	b.err = err
+	b.cond.Broadcast()
+}
+
+func (b *spoolBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.off >= b.size && !b.done && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return 0, errSpoolClosed
+	}
+	if b.off >= b.size {
+		if b.err != nil {
+			return 0, b.err
+		}
+		return 0, io.EOF
+	}
+
+	if avail := b.size - b.off; int64(len(p)) > avail {
+		p = p[:avail]
+	}
+	if b.off < int64(len(b.mem)) {
+		n := copy(p, b.mem[b.off:])
+		b.off += int64(n)
+		return n, nil
+	}
+	n, err := b.file.ReadAt(p, b.off-int64(len(b.mem)))
+	b.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Wait blocks until the writer has finished and returns its error, if any
+func (b *spoolBuffer) Wait() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for !b.done && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed && !b.done {
+		return errSpoolClosed
+	}
+	return b.err
+}
+
+// Close releases the buffer and removes the spill file. A writer still
+// filling the buffer gets errSpoolClosed on its next write.
+func (b *spoolBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	b.mem = nil
+	b.cond.Broadcast()
+
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpoolBuffer(t *testing.T) {
+	tests := []struct {
+		name      string
+		memLimit  int
+		data      string
+		wantSpill bool
+	}{
+		{name: "fits in memory", memLimit: 64, data: "hello world"},
+		{name: "spills to disk", memLimit: 4, data: "hello world", wantSpill: true},
+		{name: "disk only", memLimit: 0, data: "hello world", wantSpill: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newSpoolBuffer(tt.memLimit, t.TempDir())
+			// Write in small chunks to exercise the memory/disk boundary
+			for _, chunk := range strings.SplitAfter(tt.data, " ") {
+				if _, err := b.Write([]byte(chunk)); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+			b.CloseWithError(nil)
+
+			if (b.file != nil) != tt.wantSpill {
+				t.Errorf("spilled = %v, want %v", b.file != nil, tt.wantSpill)
+			}
+
+			got, err := io.ReadAll(b)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if string(got) != tt.data {
+				t.Errorf("read %q, want %q", got, tt.data)
+			}
+
+			var spillPath string
+			if b.file != nil {
+				spillPath = b.file.Name()
+			}
+			if err := b.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			if spillPath != "" {
+				if _, err := os.Stat(spillPath); !os.IsNotExist(err) {
+					t.Errorf("spill file %s not removed", spillPath)
+				}
+			}
+		})
+	}
+}
+
+func TestSpoolBuffer_ConcurrentReadWrite(t *testing.T) {
+	b := newSpoolBuffer(8, t.TempDir())
+	defer b.Close()
+
+	want := strings.Repeat("0123456789", 1000)
+	go func() {
+		for i := 0; i < len(want); i += 7 {
+			b.Write([]byte(want[i:min(i+7, len(want))]))
+		}
+		b.CloseWithError(nil)
+	}()
+
+	got, err := io.ReadAll(b)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("read %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestSpoolBuffer_WriterError(t *testing.T) {
+	b := newSpoolBuffer(64, t.TempDir())
+	defer b.Close()
+
+	b.Write([]byte("partial"))
+	fetchErr := errors.New("connection reset")
+	b.CloseWithError(fetchErr)
+
+	got, err := io.ReadAll(b)
+	if !errors.Is(err, fetchErr) {
+		t.Errorf("ReadAll() error = %v, want %v", err, fetchErr)
+	}
+	if string(got) != "partial" {
+		t.Errorf("read %q, want partial", got)
+	}
+}
+
+func TestSpoolBuffer_WriteAfterClose(t *testing.T) {
+	b := newSpoolBuffer(64, t.TempDir())
+	b.Close()
+
+	if _, err := b.Write([]byte("late")); !errors.Is(err, errSpoolClosed) {
+		t.Errorf("Write() error = %v, want errSpoolClosed", err)
+	}
+}
+
+func TestSpoolBuffer_Wait(t *testing.T) {
+	b := newSpoolBuffer(64, t.TempDir())
+	defer b.Close()
+
+	waited := make(chan error, 1)
+	go func() { waited <- b.Wait() }()
+
+	select {
+	case err := <-waited:
+		t.Fatalf("Wait() returned %v before the writer finished", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	fetchErr := errors.New("stalled")
+	b.CloseWithError(fetchErr)
+	if err := <-waited; !errors.Is(err, fetchErr) {
+		t.Errorf("Wait() error = %v, want %v", err, fetchErr)
+	}
+}
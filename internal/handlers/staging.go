@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/archive"
+	"zipperfly/internal/auth"
+	"zipperfly/internal/models"
+)
+
+// Async build states reported by the status endpoint
+const (
+	BuildStatusBuilding = "building"
+	BuildStatusReady    = "ready"
+	BuildStatusFailed   = "failed"
+)
+
+// BuildStatus describes an async archive build
+type BuildStatus struct {
+	ID        string     `json:"id"`
+	Format    string     `json:"format"`
+	Status    string     `json:"status"`
+	Message   string     `json:"message,omitempty"`
+	FileCount int        `json:"file_count"`           // objects written to the archive
+	SizeBytes int64      `json:"size_bytes,omitempty"` // archive size once ready
+	StartedAt time.Time  `json:"started_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // when a ready archive is deleted
+}
+
+// stagedBuild is an archive built ahead of download into the staging directory
+type stagedBuild struct {
+	status  BuildStatus
+	path    string              // staged archive, set once ready
+	outcome string              // download status reported once served: completed or partial
+	files   []models.FileResult // per-object outcomes for the callback, with CALLBACK_FILE_RESULTS
+	etag    string
+}
+
+// stagedBuilds tracks async builds by record ID, format, and file selection
+type stagedBuilds struct {
+	mu     sync.Mutex
+	builds map[string]*stagedBuild
+}
+
+// buildKey identifies a build. A password the requester supplies is part of
+// it (as a hash), so a build encrypted with one password isn't served to a
+// request carrying another.
+func buildKey(id string, format archive.Format, files, password string) string {
+	key := id + "\x00" + string(format) + "\x00" + files
+	if password != "" {
+		sum := sha256.Sum256([]byte(password))
+		key += "\x00" + hex.EncodeToString(sum[:])
+	}
+	return key
+}
+
+// get returns a copy of the build for key, if any
+func (s *stagedBuilds) get(key string) (stagedBuild, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.builds[key]
+	if !ok {
+		return stagedBuild{}, false
+	}
+	return *b, true
+}
+
+// start registers a new build for key unless one is building or ready,
+// in which case that one is returned with started false
+func (s *stagedBuilds) start(key string, status BuildStatus) (stagedBuild, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.builds[key]; ok && b.status.Status != BuildStatusFailed {
+		return *b, false
+	}
+	b := &stagedBuild{status: status}
+	s.builds[key] = b
+	return *b, true
+}
+
+// update applies fn to the build for key under the lock
+func (s *stagedBuilds) update(key string, fn func(b *stagedBuild)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.builds[key]; ok {
+		fn(b)
+	}
+}
+
+// remove forgets the build for key if it is still b's path, and deletes the file
+func (s *stagedBuilds) remove(key, path string) {
+	s.mu.Lock()
+	if b, ok := s.builds[key]; ok && b.path == path {
+		delete(s.builds, key)
+	}
+	s.mu.Unlock()
+	os.Remove(path)
+}
+
+// Prepare starts building a record's archive into the staging directory and
+// reports the build status. GET /{id} serves the archive once it is ready.
+func (h *Handler) Prepare(w http.ResponseWriter, r *http.Request) {
+	if !h.asyncBuilds {
+		http.NotFound(w, r)
+		return
+	}
+
+	plan := h.planDownload(w, r)
+	if plan == nil {
+		return
+	}
+	if plan.raw {
+		http.Error(w, "raw downloads are served directly and can't be prepared", http.StatusBadRequest)
+		h.metrics.RequestsTotal.WithLabelValues("400").Inc()
+		return
+	}
+	key := buildKey(plan.id, plan.format, plan.files, requestPassword(r))
+
+	if b, ok := h.builds.get(key); ok && b.status.Status != BuildStatusFailed {
+		h.writeBuildStatus(w, b.status)
+		return
+	}
+
+	// Builds hold a download slot until they finish
+	release, ok := h.acquireDownloadSlot(r.Context())
+	if !ok {
+		w.Header().Set("Retry-After", h.activeDownloads.retryAfter())
+		http.Error(w, "server at capacity, please retry", http.StatusServiceUnavailable)
+		h.metrics.RequestsTotal.WithLabelValues("503").Inc()
+		h.log(r.Context()).Warn("build rejected: server at capacity", zap.String("id", plan.id))
+		return
+	}
+
+	b, started := h.builds.start(key, BuildStatus{
+		ID:        plan.id,
+		Format:    string(plan.format),
+		Status:    BuildStatusBuilding,
+		StartedAt: time.Now().UTC(),
+	})
+	if !started {
+		release()
+		h.writeBuildStatus(w, b.status)
+		return
+	}
+
+	// The build outlives the request, but keeps its request ID
+	go h.runBuild(context.WithoutCancel(r.Context()), key, plan, release)
+	h.log(r.Context()).Info("archive build started", zap.String("id", plan.id), zap.String("format", string(plan.format)))
+	h.writeBuildStatus(w, b.status)
+}
+
+// Status reports the status of an async build
+func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
+	if !h.asyncBuilds {
+		http.NotFound(w, r)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	query := r.URL.Query()
+	format, err := archive.ParseFormat(query.Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.metrics.RequestsTotal.WithLabelValues("400").Inc()
+		return
+	}
+	files := query.Get("files")
+	if err := h.verifier.Verify(r.Context(), auth.RequestParams(r, id)); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		h.metrics.RequestsTotal.WithLabelValues("401").Inc()
+		return
+	}
+
+	// Without ?format= the build uses the record's format, as downloads do
+	if query.Get("format") == "" {
+		if record, err := h.getRecord(r.Context(), id); err == nil && record.Format != "" {
+			if f, err := archive.ParseFormat(record.Format); err == nil {
+				format = f
+			}
+		}
+	}
+
+	b, ok := h.builds.get(buildKey(id, format, files, requestPassword(r)))
+	if !ok {
+		http.Error(w, "no build for this record", http.StatusNotFound)
+		h.metrics.RequestsTotal.WithLabelValues("404").Inc()
+		return
+	}
+	h.writeBuildStatus(w, b.status)
+}
+
+// writeBuildStatus writes status as JSON: 202 while building, 200 otherwise
+func (h *Handler) writeBuildStatus(w http.ResponseWriter, status BuildStatus) {
+	code := http.StatusOK
+	if status.Status == BuildStatusBuilding {
+		code = http.StatusAccepted
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+	h.metrics.RequestsTotal.WithLabelValues(fmt.Sprintf("%d", code)).Inc()
+}
+
+// runBuild writes the archive to a staging file and publishes it until the
+// staging TTL expires, then gives back the build's download slot
+func (h *Handler) runBuild(ctx context.Context, key string, plan *downloadPlan, release func()) {
+	defer release()
+
+	fail := func(err error) {
+		h.log(ctx).Error("archive build failed", zap.String("id", plan.id), zap.Error(err))
+		if limit := sizeLimitExceeded(err); limit != "" {
+			h.metrics.SizeLimitsTotal.WithLabelValues(limit).Inc()
+		}
+		h.builds.update(key, func(b *stagedBuild) {
+			b.status.Status = BuildStatusFailed
+			b.status.Message = err.Error()
+		})
+	}
+
+	if err := os.MkdirAll(h.stagingDir, 0o700); err != nil {
+		fail(fmt.Errorf("failed to create staging directory: %w", err))
+		return
+	}
+	f, err := os.CreateTemp(h.stagingDir, "zipperfly-build-*"+plan.format.Extension())
+	if err != nil {
+		fail(fmt.Errorf("failed to create staging file: %w", err))
+		return
+	}
+
+	size, successCount, files, err := h.writeStaged(ctx, f, plan)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		fail(err)
+		return
+	}
+
+	outcome := "completed"
+	message := ""
+	if successCount < len(plan.record.Objects) {
+		outcome = "partial"
+		message = fmt.Sprintf("processed %d of %d files (some files missing)", successCount, len(plan.record.Objects))
+	}
+	expires := time.Now().Add(h.stagingTTL).UTC()
+	h.builds.update(key, func(b *stagedBuild) {
+		b.path = f.Name()
+		b.outcome = outcome
+		b.files = files
+		b.etag = fmt.Sprintf(`"%x-%x"`, b.status.StartedAt.UnixNano(), size)
+		b.status.Status = BuildStatusReady
+		b.status.Message = message
+		b.status.FileCount = successCount
+		b.status.SizeBytes = size
+		b.status.ExpiresAt = &expires
+	})
+	time.AfterFunc(h.stagingTTL, func() { h.builds.remove(key, f.Name()) })
+
+	h.log(ctx).Info("archive build ready", zap.String("id", plan.id), zap.Int64("size", size), zap.Int("files", successCount))
+}
+
+// writeStaged builds the plan's archive into f, returning its size, the
+// number of objects written, and their outcomes if collected
+func (h *Handler) writeStaged(ctx context.Context, f *os.File, plan *downloadPlan) (int64, int, []models.FileResult, error) {
+	bc := &models.ByteCounter{Writer: f}
+	aw, err := archive.NewWriter(plan.format, h.limitArchiveSize(bc), plan.opts)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	successCount, _, files, err := h.buildArchive(ctx, aw, plan)
+	if err != nil {
+		aw.Close()
+		return 0, 0, nil, err
+	}
+	if c, ok := aw.(archive.Commenter); ok && successCount < len(plan.record.Objects) {
+		c.SetComment(incompleteComment(nil))
+	}
+	if err := aw.Close(); err != nil {
+		return 0, 0, nil, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return bc.Count, successCount, files, nil
+}
+
+// serveStaged serves a ready async build, with Content-Length and Range
+// support from http.ServeContent. It returns false if the staged file is
+// gone, so the caller can stream the archive instead.
+func (h *Handler) serveStaged(w http.ResponseWriter, r *http.Request, plan *downloadPlan, b stagedBuild, start time.Time) bool {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	h.setArchiveHeaders(w, plan)
+	w.Header().Set("ETag", b.etag)
+	w.Header().Set(StatusTrailer, b.outcome)
+	w.Header().Set(RequestIDTrailer, GetRequestID(r.Context()))
+	w.Header().Set(FilesIncludedTrailer, strconv.Itoa(b.status.FileCount))
+	var started <-chan struct{}
+	if r.Method != http.MethodHead {
+		started = h.notifyStarted(r.Context(), plan.record.Callbacks, plan.id, len(plan.record.Objects))
+	}
+	http.ServeContent(w, r, "", time.Time{}, f)
+
+	if r.Method == http.MethodHead {
+		h.metrics.RequestsTotal.WithLabelValues("200").Inc()
+		return true
+	}
+
+	// Count the download once the whole archive was sent, or for any range,
+	// so partial ranges can't sidestep max_downloads
+	ranged := false
+	if header := r.Header.Get("Range"); header != "" && ifRangeMatches(r, b.etag) {
+		_, ranged, _ = parseRange(header, b.status.SizeBytes)
+	}
+	if ranged || r.Context().Err() == nil {
+		h.countDownload(r.Context(), plan)
+	}
+
+	duration := time.Since(start)
+	h.metrics.DurationHist.Observe(duration.Seconds())
+	h.metrics.DownloadsTotal.WithLabelValues(b.outcome, h.tenantLabel(plan.record)).Inc()
+	h.metrics.RequestsTotal.WithLabelValues("200").Inc()
+
+	h.notifyOutcome(r.Context(), started, plan.record.Callbacks, models.CallbackPayload{
+		ID:                  plan.id,
+		Status:              b.outcome,
+		Timestamp:           time.Now().UTC().Format(time.RFC3339),
+		Message:             b.status.Message,
+		DurationMs:          duration.Milliseconds(),
+		FileCount:           len(plan.record.Objects),
+		CompressedSizeBytes: b.status.SizeBytes,
+		Encryption:          plan.encryption,
+		Files:               b.files,
+	})
+
+	h.log(r.Context()).Info("staged download served", zap.String("id", plan.id), zap.Duration("duration", duration))
+	return true
+}
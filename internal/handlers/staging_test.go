@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/auth"
+	"zipperfly/internal/models"
+)
+
+func TestHandler_Prepare(t *testing.T) {
+	record := &models.DownloadRecord{ID: "test", Bucket: "bucket", Objects: []string{"a.txt", "b.txt"}}
+	db := &mockDownloadDB{records: map[string]*models.DownloadRecord{"test": record}}
+	storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha", "bucket:b.txt": "bravo"}}
+	verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+	stagingDir := t.TempDir()
+
+	h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+		MaxConcurrent:    10,
+		SpoolMemoryLimit: 1 << 20,
+		AsyncBuilds:      true,
+		StagingDir:       stagingDir,
+		StagingTTL:       time.Hour,
+		SanitizeCharset:  "ascii",
+	})
+
+	serve := func(handler http.HandlerFunc, method, path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "test"})
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) BuildStatus {
+		var status BuildStatus
+		if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+			t.Fatalf("failed to decode build status: %v", err)
+		}
+		return status
+	}
+
+	if w := serve(h.Status, http.MethodGet, "/test/status", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("status before prepare = %d, want 404", w.Code)
+	}
+
+	w := serve(h.Prepare, http.MethodPost, "/test/prepare", nil)
+	if w.Code != http.StatusAccepted && w.Code != http.StatusOK {
+		t.Fatalf("prepare status = %d, want 202 or 200", w.Code)
+	}
+
+	// Poll until the build finishes
+	var status BuildStatus
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w := serve(h.Status, http.MethodGet, "/test/status", nil)
+		status = decode(w)
+		if status.Status != BuildStatusBuilding || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Status != BuildStatusReady || status.FileCount != 2 || status.ExpiresAt == nil {
+		t.Fatalf("build status = %+v, want ready with 2 files", status)
+	}
+
+	// A second prepare reuses the ready build
+	if w := serve(h.Prepare, http.MethodPost, "/test/prepare", nil); w.Code != http.StatusOK || decode(w).StartedAt != status.StartedAt {
+		t.Errorf("second prepare did not return the ready build")
+	}
+
+	w = serve(h.Download, http.MethodGet, "/test", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("download status = %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Content-Length"); got != strconv.FormatInt(status.SizeBytes, 10) {
+		t.Errorf("Content-Length = %q, want %d", got, status.SizeBytes)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="download.zip"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	if w.Header().Get(StatusTrailer) != "completed" || w.Header().Get(FilesTotalHeader) != "2" || w.Header().Get(FilesIncludedTrailer) != "2" {
+		t.Errorf("staged download status headers = %v", w.Header())
+	}
+	whole := w.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(whole), int64(len(whole)))
+	if err != nil || len(zr.File) != 2 {
+		t.Fatalf("staged archive unreadable: %v", err)
+	}
+	if record.DownloadCount != 1 {
+		t.Errorf("DownloadCount = %d, want 1", record.DownloadCount)
+	}
+
+	// Ranges come from the staged file
+	w = serve(h.Download, http.MethodGet, "/test", http.Header{"Range": {"bytes=0-9"}})
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), whole[:10]) {
+		t.Errorf("range response = %d %q, want 206 with the first 10 bytes", w.Code, w.Body.Bytes())
+	}
+	if record.DownloadCount != 2 {
+		t.Errorf("DownloadCount = %d after a partial range, want 2", record.DownloadCount)
+	}
+
+	// Expiry removes the staged file and its build
+	b, _ := h.builds.get(buildKey("test", "zip", "", ""))
+	h.builds.remove(buildKey("test", "zip", "", ""), b.path)
+	if _, err := os.Stat(b.path); !os.IsNotExist(err) {
+		t.Errorf("staged file still exists after removal")
+	}
+	if w := serve(h.Status, http.MethodGet, "/test/status", nil); w.Code != http.StatusNotFound {
+		t.Errorf("status after expiry = %d, want 404", w.Code)
+	}
+}
+
+func TestHandler_Prepare_Disabled(t *testing.T) {
+	db := &mockDownloadDB{records: map[string]*models.DownloadRecord{"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt"}}}}
+	storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha"}}
+	verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+
+	h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+		MaxConcurrent:    10,
+		SpoolMemoryLimit: 1 << 20,
+		SanitizeCharset:  "ascii",
+	})
+
+	for name, handler := range map[string]http.HandlerFunc{"prepare": h.Prepare, "status": h.Status} {
+		req := httptest.NewRequest(http.MethodGet, "/test/"+name, nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "test"})
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusNotFound {
+			body, _ := io.ReadAll(w.Body)
+			t.Errorf("%s with async builds disabled = %d %q, want 404", name, w.Code, body)
+		}
+	}
+}
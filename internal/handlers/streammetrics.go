@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"zipperfly/internal/adaptive"
+	"zipperfly/internal/metrics"
+)
+
+// streamMetricsChunk is the most ReadFrom sends between metrics updates
+const streamMetricsChunk = 1 << 20
+
+// streamMetricsWriter counts a response's body in the streaming metrics as
+// it is written: StreamedBytesTotal, the throughput gauge, and
+// BytesInFlight until done is called, and the time spent writing as
+// adaptive concurrency's backpressure. Once the download is cancelled from
+// the admin listener, writes fail.
+type streamMetricsWriter struct {
+	http.ResponseWriter
+	metrics  *metrics.Metrics
+	adaptive *adaptive.Controller // nil = fixed limits
+	bytes    int64                // body bytes written, counted in BytesInFlight
+	active   *activeDownload      // the download's admin listing, nil until planned
+	streamed func()               // ends the stream for adaptive, nil until the first write
+}
+
+func (w *streamMetricsWriter) Write(b []byte) (int, error) {
+	if err := w.active.err(); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	n, err := w.ResponseWriter.Write(b)
+	w.blocked(start)
+	w.add(int64(n))
+	return n, err
+}
+
+// ReadFrom keeps the underlying writer's io.ReaderFrom, so staged archives
+// are still sent with sendfile, but in chunks of the underlying reader so
+// the metrics keep moving during a long copy
+func (w *streamMetricsWriter) ReadFrom(src io.Reader) (int64, error) {
+	lr, limited := src.(*io.LimitedReader)
+	if !limited {
+		lr = &io.LimitedReader{R: src, N: -1}
+	}
+
+	var total int64
+	for lr.N != 0 {
+		if err := w.active.err(); err != nil {
+			return total, err
+		}
+		chunk := int64(streamMetricsChunk)
+		if lr.N > 0 && lr.N < chunk {
+			chunk = lr.N
+		}
+		start := time.Now()
+		n, err := io.Copy(w.ResponseWriter, io.LimitReader(lr.R, chunk))
+		w.blocked(start)
+		total += n
+		w.add(n)
+		if lr.N > 0 {
+			lr.N -= n
+		}
+		if err != nil || n < chunk {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *streamMetricsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *streamMetricsWriter) add(n int64) {
+	w.bytes += n
+	w.active.addBytes(n)
+	w.metrics.AddStreamedBytes(n)
+	w.metrics.BytesInFlight.Add(float64(n))
+}
+
+// blocked records the time since start, spent writing, with adaptive
+// concurrency. The response counts as streaming from its first write.
+func (w *streamMetricsWriter) blocked(start time.Time) {
+	if w.adaptive == nil {
+		return
+	}
+	if w.streamed == nil {
+		w.streamed = w.adaptive.Stream()
+	}
+	w.adaptive.Blocked(time.Since(start))
+}
+
+// done removes the response from BytesInFlight once it has finished
+func (w *streamMetricsWriter) done() {
+	w.metrics.BytesInFlight.Sub(float64(w.bytes))
+	if w.streamed != nil {
+		w.streamed()
+	}
+}
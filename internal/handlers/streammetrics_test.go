@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStreamMetricsWriter(t *testing.T) {
+	streamedBefore := testutil.ToFloat64(sharedMetrics.StreamedBytesTotal)
+	inFlightBefore := testutil.ToFloat64(sharedMetrics.BytesInFlight)
+
+	rec := httptest.NewRecorder()
+	sw := &streamMetricsWriter{ResponseWriter: rec, metrics: sharedMetrics}
+	sw.Write([]byte("alpha"))
+
+	// A limited copy larger than a chunk, as http.ServeContent does it
+	body := bytes.Repeat([]byte("x"), streamMetricsChunk+100)
+	n, err := io.CopyN(sw, bytes.NewReader(body), streamMetricsChunk+10)
+	if err != nil || n != streamMetricsChunk+10 {
+		t.Fatalf("CopyN() = %d, %v; want %d, nil", n, err, streamMetricsChunk+10)
+	}
+	// And one that runs to EOF
+	if n, err := sw.ReadFrom(strings.NewReader("omega")); err != nil || n != 5 {
+		t.Fatalf("ReadFrom() = %d, %v; want 5, nil", n, err)
+	}
+
+	want := float64(5 + streamMetricsChunk + 10 + 5)
+	if rec.Body.Len() != int(want) {
+		t.Errorf("body length = %d, want %g", rec.Body.Len(), want)
+	}
+	if got := testutil.ToFloat64(sharedMetrics.StreamedBytesTotal) - streamedBefore; got != want {
+		t.Errorf("streamed bytes = %g, want %g", got, want)
+	}
+	if got := testutil.ToFloat64(sharedMetrics.BytesInFlight) - inFlightBefore; got != want {
+		t.Errorf("bytes in flight = %g, want %g", got, want)
+	}
+
+	sw.done()
+	if got := testutil.ToFloat64(sharedMetrics.BytesInFlight) - inFlightBefore; got != 0 {
+		t.Errorf("bytes in flight after done = %g, want 0", got)
+	}
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import "zipperfly/internal/models"
+
+// tenantLabel returns the tenant label value of a record's download
+// metrics: its bucket or tenant field, per METRICS_TENANT_LABEL, capped at
+// METRICS_TENANT_LIMIT distinct values. It is "" when the label is off,
+// which Prometheus treats the same as no label.
+func (h *Handler) tenantLabel(record *models.DownloadRecord) string {
+	switch h.metricsTenantLabel {
+	case "bucket":
+		return h.tenants.Value(record.Bucket)
+	case "tenant":
+		return h.tenants.Value(record.Tenant)
+	}
+	return ""
+}
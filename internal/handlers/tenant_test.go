@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"testing"
+
+	"zipperfly/internal/metrics"
+	"zipperfly/internal/models"
+)
+
+func TestHandler_TenantLabel(t *testing.T) {
+	tests := []struct {
+		name   string
+		label  string
+		record models.DownloadRecord
+		want   string
+	}{
+		{name: "off", record: models.DownloadRecord{Bucket: "acme-files", Tenant: "acme"}, want: ""},
+		{name: "bucket", label: "bucket", record: models.DownloadRecord{Bucket: "acme-files", Tenant: "acme"}, want: "acme-files"},
+		{name: "tenant", label: "tenant", record: models.DownloadRecord{Bucket: "acme-files", Tenant: "acme"}, want: "acme"},
+		{name: "record without tenant", label: "tenant", record: models.DownloadRecord{Bucket: "acme-files"}, want: ""},
+		{name: "over the limit", label: "bucket", record: models.DownloadRecord{Bucket: "globex-files"}, want: metrics.OtherLabel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{metricsTenantLabel: tt.label, tenants: metrics.NewLabelCap(2)}
+			h.tenants.Value("acme-files")
+			h.tenants.Value("acme")
+
+			if got := h.tenantLabel(&tt.record); got != tt.want {
+				t.Errorf("tenantLabel() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
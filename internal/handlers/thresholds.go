@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/models"
+)
+
+// notableFilesLogged is how many of its slowest or largest files a slow or
+// large download's log entry lists
+const notableFilesLogged = 10
+
+// downloadSummary describes a finished download for reportThresholds
+type downloadSummary struct {
+	id       string
+	status   string
+	duration time.Duration
+	outBytes int64               // bytes sent to the client
+	inBytes  int64               // bytes read from storage
+	files    []models.FileResult // per-object outcomes, in write order
+}
+
+// reportThresholds logs a warning, and counts it in DownloadThresholdsTotal,
+// for a download that took longer than SLOW_DOWNLOAD_THRESHOLD or sent more
+// than LARGE_DOWNLOAD_THRESHOLD bytes, listing its slowest or largest files
+func (h *Handler) reportThresholds(ctx context.Context, s downloadSummary) {
+	fields := []zap.Field{
+		zap.String("id", s.id),
+		zap.String("status", s.status),
+		zap.Duration("duration", s.duration),
+		zap.Int64("bytes_out", s.outBytes),
+		zap.Int64("bytes_in", s.inBytes),
+		zap.Int("file_count", len(s.files)),
+	}
+
+	if h.slowDownloadThreshold > 0 && s.duration > h.slowDownloadThreshold {
+		h.metrics.DownloadThresholdsTotal.WithLabelValues("duration").Inc()
+		h.log(ctx).Warn("slow download", append(fields,
+			zap.Duration("threshold", h.slowDownloadThreshold),
+			zap.Any("slowest_files", topFiles(s.files, func(f models.FileResult) int64 { return f.DurationMs })),
+		)...)
+	}
+	if h.largeDownloadThreshold > 0 && s.outBytes > h.largeDownloadThreshold {
+		h.metrics.DownloadThresholdsTotal.WithLabelValues("size").Inc()
+		h.log(ctx).Warn("large download", append(fields,
+			zap.Int64("threshold", h.largeDownloadThreshold),
+			zap.Any("largest_files", topFiles(s.files, func(f models.FileResult) int64 { return f.Bytes })),
+		)...)
+	}
+}
+
+// collectFileResults reports whether downloads need per-object outcomes:
+// for the callback payload, or for slow and large download reports
+func (h *Handler) collectFileResults() bool {
+	return h.callbackFileResults || h.slowDownloadThreshold > 0 || h.largeDownloadThreshold > 0
+}
+
+// topFiles returns up to notableFilesLogged of files, largest by first
+func topFiles(files []models.FileResult, by func(models.FileResult) int64) []models.FileResult {
+	top := slices.Clone(files)
+	slices.SortStableFunc(top, func(a, b models.FileResult) int { return cmp.Compare(by(b), by(a)) })
+	if len(top) > notableFilesLogged {
+		top = top[:notableFilesLogged]
+	}
+	return top
+}
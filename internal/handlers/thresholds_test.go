@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"zipperfly/internal/metrics"
+	"zipperfly/internal/models"
+)
+
+func TestHandler_ReportThresholds(t *testing.T) {
+	files := []models.FileResult{
+		{Key: "small-slow.txt", Status: "success", Bytes: 10, DurationMs: 900},
+		{Key: "large-fast.bin", Status: "success", Bytes: 5000, DurationMs: 5},
+		{Key: "medium.txt", Status: "success", Bytes: 500, DurationMs: 100},
+	}
+
+	tests := []struct {
+		name     string
+		slow     time.Duration
+		large    int64
+		duration time.Duration
+		outBytes int64
+		want     []string // warning messages, in order
+		first    string   // first listed file of the first warning
+	}{
+		{name: "thresholds off", duration: time.Hour, outBytes: 1 << 40},
+		{name: "under both", slow: time.Minute, large: 1 << 20, duration: time.Second, outBytes: 1024},
+		{name: "slow", slow: time.Second, duration: 2 * time.Second, outBytes: 1024, want: []string{"slow download"}, first: "small-slow.txt"},
+		{name: "large", large: 1024, duration: 2 * time.Second, outBytes: 5510, want: []string{"large download"}, first: "large-fast.bin"},
+		{name: "slow and large", slow: time.Second, large: 1024, duration: 2 * time.Second, outBytes: 5510, want: []string{"slow download", "large download"}, first: "small-slow.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.WarnLevel)
+			m := metrics.NewWithRegistry(nil)
+			h := &Handler{logger: zap.New(core), metrics: m, slowDownloadThreshold: tt.slow, largeDownloadThreshold: tt.large}
+
+			h.reportThresholds(context.Background(), downloadSummary{id: "abc", status: "completed", duration: tt.duration, outBytes: tt.outBytes, inBytes: 5510, files: files})
+
+			entries := logs.All()
+			if len(entries) != len(tt.want) {
+				t.Fatalf("logged %d warnings, want %d", len(entries), len(tt.want))
+			}
+			for i, msg := range tt.want {
+				if entries[i].Message != msg {
+					t.Errorf("warning %d = %q, want %q", i, entries[i].Message, msg)
+				}
+				if got := entries[i].ContextMap()["file_count"]; got != int64(len(files)) {
+					t.Errorf("file_count = %v, want %d", got, len(files))
+				}
+			}
+			if len(entries) > 0 {
+				listed := entries[0].ContextMap()["slowest_files"]
+				if listed == nil {
+					listed = entries[0].ContextMap()["largest_files"]
+				}
+				if got := listed.([]models.FileResult)[0].Key; got != tt.first {
+					t.Errorf("first listed file = %q, want %q", got, tt.first)
+				}
+			}
+
+			wantDuration, wantSize := 0.0, 0.0
+			for _, msg := range tt.want {
+				if msg == "slow download" {
+					wantDuration++
+				} else {
+					wantSize++
+				}
+			}
+			if got := testutil.ToFloat64(m.DownloadThresholdsTotal.WithLabelValues("duration")); got != wantDuration {
+				t.Errorf("duration threshold count = %v, want %v", got, wantDuration)
+			}
+			if got := testutil.ToFloat64(m.DownloadThresholdsTotal.WithLabelValues("size")); got != wantSize {
+				t.Errorf("size threshold count = %v, want %v", got, wantSize)
+			}
+		})
+	}
+}
+
+func TestTopFiles(t *testing.T) {
+	var files []models.FileResult
+	for i := range notableFilesLogged + 5 {
+		files = append(files, models.FileResult{Key: fmt.Sprintf("file%02d", i), Bytes: int64(i)})
+	}
+
+	top := topFiles(files, func(f models.FileResult) int64 { return f.Bytes })
+	if len(top) != notableFilesLogged {
+		t.Fatalf("len(top) = %d, want %d", len(top), notableFilesLogged)
+	}
+	if top[0].Key != "file14" || top[len(top)-1].Key != "file05" {
+		t.Errorf("top = %s..%s, want file14..file05", top[0].Key, top[len(top)-1].Key)
+	}
+	if files[0].Key != "file00" {
+		t.Error("topFiles reordered its input")
+	}
+}
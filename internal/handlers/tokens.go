@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/auth"
+	"zipperfly/internal/metrics"
+	"zipperfly/internal/tokens"
+)
+
+// DownloadTokens lets browsers start a download without a signed URL ever
+// reaching their history, proxies, or Referer headers. A form POST of a
+// download link's parameters to /{id} is verified as a GET of the link
+// would be, then redirected to /{token}, a short-lived token that stands
+// in for the link and can be redeemed once.
+type DownloadTokens struct {
+	logger         *zap.Logger
+	metrics        *metrics.Metrics
+	verifier       *auth.Verifier
+	store          tokens.Store
+	ttl            time.Duration
+	allowedOrigins []string // "" = any origin may post
+}
+
+// NewDownloadTokens creates download tokens kept in store for ttl. With
+// allowedOrigins set, browser POSTs from other origins are refused.
+func NewDownloadTokens(logger *zap.Logger, m *metrics.Metrics, verifier *auth.Verifier, store tokens.Store, ttl time.Duration, allowedOrigins []string) *DownloadTokens {
+	return &DownloadTokens{
+		logger:         logger,
+		metrics:        m,
+		verifier:       verifier,
+		store:          store,
+		ttl:            ttl,
+		allowedOrigins: allowedOrigins,
+	}
+}
+
+// Issue handles POST /{id}: it verifies the posted link parameters and
+// redirects to the token issued for them with 303 See Other
+func (d *DownloadTokens) Issue(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	// Browsers send Origin with every cross-site POST, so a page on another
+	// site can't start a download by replaying a form
+	if origin := r.Header.Get("Origin"); origin != "" && len(d.allowedOrigins) > 0 && !slices.Contains(d.allowedOrigins, origin) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		requestLogger(r.Context(), d.logger).Warn("download token refused: origin not allowed", zap.String("id", id), zap.String("origin", origin))
+		d.metrics.RequestsTotal.WithLabelValues("403").Inc()
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		d.metrics.RequestsTotal.WithLabelValues("400").Inc()
+		return
+	}
+
+	req := auth.FormParams(r, id)
+	identity, err := d.verifier.Authenticate(ctx, req)
+	if err != nil {
+		statusCode := http.StatusUnauthorized
+		if strings.Contains(err.Error(), "expired") {
+			statusCode = http.StatusGone
+		}
+		http.Error(w, err.Error(), statusCode)
+		requestLogger(r.Context(), d.logger).Warn("download token refused", zap.String("id", id), zap.Error(err))
+		d.metrics.RequestsTotal.WithLabelValues(fmt.Sprintf("%d", statusCode)).Inc()
+		return
+	}
+
+	token, err := d.store.Issue(ctx, tokens.Grant{
+		ID:       id,
+		Files:    req.Files,
+		Format:   req.Format,
+		Raw:      req.Raw,
+		Password: req.Password,
+		User:     identity.User,
+	}, d.ttl)
+	if err != nil {
+		http.Error(w, "download tokens unavailable", http.StatusServiceUnavailable)
+		requestLogger(r.Context(), d.logger).Error("failed to issue download token", zap.String("id", id), zap.Error(err))
+		d.metrics.RequestsTotal.WithLabelValues("503").Inc()
+		return
+	}
+	d.metrics.DownloadTokensTotal.WithLabelValues("issued").Inc()
+
+	// A relative reference resolves next to /{id}, also behind a proxy that
+	// serves zipperfly under a path prefix
+	w.Header().Set("Location", token)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(http.StatusSeeOther)
+	d.metrics.RequestsTotal.WithLabelValues("303").Inc()
+}
+
+// Middleware redeems the token in GET /{token} and passes the request on
+// as the download it stands for: the record's ID and the token's file
+// selection, format, raw, and password replace the request's own, and its
+// context carries the verified identity (auth.WithGrant). Tokens that are
+// unknown, expired, or already used get 410 Gone. A download refused before
+// it started, e.g. at capacity or while draining, gives the token back, so
+// the browser can retry it. Other requests pass through unchanged.
+func (d *DownloadTokens) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := mux.Vars(r)["id"]
+		if r.Method != http.MethodGet || !tokens.IsToken(token) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		grant, err := d.store.Redeem(r.Context(), token)
+		if err != nil {
+			http.Error(w, "download tokens unavailable", http.StatusServiceUnavailable)
+			requestLogger(r.Context(), d.logger).Error("failed to redeem download token", zap.Error(err))
+			d.metrics.RequestsTotal.WithLabelValues("503").Inc()
+			return
+		}
+		if grant == nil {
+			http.Error(w, "download token expired or already used", http.StatusGone)
+			requestLogger(r.Context(), d.logger).Warn("invalid download token")
+			d.metrics.DownloadTokensTotal.WithLabelValues("invalid").Inc()
+			d.metrics.RequestsTotal.WithLabelValues("410").Inc()
+			return
+		}
+		d.metrics.DownloadTokensTotal.WithLabelValues("redeemed").Inc()
+
+		query := url.Values{}
+		for name, value := range map[string]string{"files": grant.Files, "format": grant.Format, "raw": grant.Raw, "password": grant.Password} {
+			if value != "" {
+				query.Set(name, value)
+			}
+		}
+		r = r.Clone(auth.WithGrant(r.Context(), auth.Identity{User: grant.User}))
+		r.URL.RawQuery = query.Encode()
+		r.Header.Del(PasswordHeader)
+		tw := &tokenWriter{ResponseWriter: w}
+		next.ServeHTTP(tw, mux.SetURLVars(r, map[string]string{"id": grant.ID}))
+
+		if tw.status >= http.StatusBadRequest {
+			if err := d.store.Restore(context.Background(), token, *grant); err != nil {
+				requestLogger(r.Context(), d.logger).Error("failed to restore download token", zap.Error(err))
+				return
+			}
+			d.metrics.DownloadTokensTotal.WithLabelValues("restored").Inc()
+		}
+	})
+}
+
+// tokenWriter records the status of a download started with a token, to
+// tell whether it was refused before streaming
+type tokenWriter struct {
+	http.ResponseWriter
+	status int // first final status written, 0 = none yet
+}
+
+func (w *tokenWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *tokenWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// ReadFrom hands staged archives to the underlying writer's io.ReaderFrom,
+// as accessLogWriter does
+func (w *tokenWriter) ReadFrom(src io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return io.Copy(w.ResponseWriter, src)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *tokenWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
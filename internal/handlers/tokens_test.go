@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/auth"
+	"zipperfly/internal/models"
+	"zipperfly/internal/tokens"
+	"zipperfly/sign"
+)
+
+func TestDownloadTokens(t *testing.T) {
+	secret := []byte("test-secret")
+	expiry := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	signed := url.Values{
+		"expiry":    {expiry},
+		"format":    {"tar"},
+		"signature": {sign.SignatureV2(secret, sign.Params{Method: "GET", ID: "test", Expiry: expiry, Format: "tar"})},
+		"v":         {"2"},
+	}
+	forged := url.Values{"expiry": {expiry}, "signature": {"forged"}}
+
+	tests := []struct {
+		name       string
+		form       url.Values
+		origin     string
+		wantStatus int
+	}{
+		{name: "signed form", form: signed, wantStatus: http.StatusSeeOther},
+		{name: "allowed origin", form: signed, origin: "https://app.example.com", wantStatus: http.StatusSeeOther},
+		{name: "other origin", form: signed, origin: "https://evil.example.com", wantStatus: http.StatusForbidden},
+		{name: "forged signature", form: forged, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+				"test": {ID: "test", Bucket: "bucket", Objects: []string{"a.txt"}},
+			}}
+			storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha"}}
+			verifier := auth.NewVerifier(map[string][]byte{"": secret}, true, 1, sharedMetrics, nil)
+			h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+				MaxConcurrent:    10,
+				EntryOrder:       "record",
+				SpoolMemoryLimit: 1 << 20,
+				SanitizeCharset:  "ascii",
+			})
+			d := NewDownloadTokens(zap.NewNop(), sharedMetrics, verifier, tokens.NewMemory(), time.Minute, []string{"https://app.example.com"})
+
+			req := httptest.NewRequest("POST", "/test", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			req = mux.SetURLVars(req, map[string]string{"id": "test"})
+			w := httptest.NewRecorder()
+			d.Issue(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("POST status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusSeeOther {
+				return
+			}
+			token := w.Header().Get("Location")
+			if !tokens.IsToken(token) || w.Header().Get("Cache-Control") != "no-store" {
+				t.Fatalf("Location = %q, Cache-Control = %q", token, w.Header().Get("Cache-Control"))
+			}
+
+			// The token downloads the signed link once; the request's own
+			// parameters can't widen it
+			download := d.Middleware(http.HandlerFunc(h.Download))
+			for i, wantStatus := range []int{http.StatusOK, http.StatusGone} {
+				req := httptest.NewRequest("GET", "/"+token+"?format=zip", nil)
+				req = mux.SetURLVars(req, map[string]string{"id": token})
+				w := httptest.NewRecorder()
+				download.ServeHTTP(w, req)
+
+				if w.Code != wantStatus {
+					t.Fatalf("GET %d status = %d, want %d: %s", i+1, w.Code, wantStatus, w.Body.String())
+				}
+				if wantStatus == http.StatusOK && w.Header().Get("Content-Type") != "application/x-tar" {
+					t.Errorf("Content-Type = %q, want the signed tar format", w.Header().Get("Content-Type"))
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadTokens_Middleware_PassThrough(t *testing.T) {
+	d := NewDownloadTokens(zap.NewNop(), sharedMetrics, nil, tokens.NewMemory(), time.Minute, nil)
+
+	for _, tt := range []struct{ method, id string }{
+		{"GET", "019ad1fc-a742-709e-81e2-59eff89576a5"},
+		{"HEAD", tokens.Prefix + "abc"},
+	} {
+		var reached bool
+		handler := d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reached = mux.Vars(r)["id"] == tt.id
+		}))
+		req := mux.SetURLVars(httptest.NewRequest(tt.method, "/"+tt.id, nil), map[string]string{"id": tt.id})
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if !reached {
+			t.Errorf("%s /%s was not passed through unchanged", tt.method, tt.id)
+		}
+	}
+}
+
+func TestDownloadTokens_RestoredWhenRefused(t *testing.T) {
+	db := &mockDownloadDB{records: map[string]*models.DownloadRecord{}}
+	storage := &mockDownloadStorage{files: map[string]string{"bucket:a.txt": "alpha"}}
+	verifier := auth.NewVerifier(nil, false, 1, sharedMetrics, nil)
+	h := NewDownloadHandler(zap.NewNop(), db, storage, verifier, sharedMetrics, HandlerOptions{
+		MaxConcurrent:    10,
+		SpoolMemoryLimit: 1 << 20,
+		SanitizeCharset:  "ascii",
+	})
+	store := tokens.NewMemory()
+	d := NewDownloadTokens(zap.NewNop(), sharedMetrics, verifier, store, time.Minute, nil)
+	token, err := store.Issue(context.Background(), tokens.Grant{ID: "test"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	download := d.Middleware(http.HandlerFunc(h.Download))
+	get := func() int {
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/"+token, nil), map[string]string{"id": token})
+		w := httptest.NewRecorder()
+		download.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Refused before streaming, the token stays usable; once the download
+	// is served, it is used up
+	if code := get(); code != http.StatusNotFound {
+		t.Fatalf("GET before the record exists: status = %d, want 404", code)
+	}
+	db.records["test"] = &models.DownloadRecord{ID: "test", Bucket: "bucket", Objects: []string{"a.txt"}}
+	for i, want := range []int{http.StatusOK, http.StatusGone} {
+		if code := get(); code != want {
+			t.Fatalf("GET %d: status = %d, want %d", i+1, code, want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/auth"
+	"zipperfly/internal/storage"
+)
+
+// ValidationReport describes whether a record's download can succeed,
+// from a lookup of each object in storage
+type ValidationReport struct {
+	ID            string   `json:"id"`
+	Valid         bool     `json:"valid"`          // every object exists within the size limit and could be checked
+	FileCount     int      `json:"file_count"`     // objects checked, after the selection and extension filters
+	TotalSize     int64    `json:"total_size"`     // bytes of the objects found, whose size storage reports
+	Missing       []string `json:"missing"`        // objects that don't exist
+	TooLarge      []string `json:"too_large"`      // objects over MAX_FILE_SIZE
+	Unavailable   []string `json:"unavailable"`    // objects storage failed to look up
+	IgnoreMissing bool     `json:"ignore_missing"` // missing objects are skipped rather than failing the download
+}
+
+// Validate checks that a record's objects exist, without fetching them, so
+// the application can warn before a download that would fail or come out
+// incomplete. It takes the download link, signed as for GET /{id}, and
+// ?files= narrows the check to a selection.
+func (h *Handler) Validate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	identity, err := h.verifier.Authenticate(ctx, auth.RequestParams(r, id))
+	if err != nil {
+		statusCode := http.StatusUnauthorized
+		if strings.Contains(err.Error(), "expired") {
+			statusCode = http.StatusGone
+		}
+		http.Error(w, err.Error(), statusCode)
+		h.metrics.RequestsTotal.WithLabelValues(fmt.Sprintf("%d", statusCode)).Inc()
+		return
+	}
+
+	record, err := h.getRecord(ctx, id)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		h.log(ctx).Warn("validated record not found", zap.Error(err), zap.String("id", id))
+		h.metrics.RequestsTotal.WithLabelValues("404").Inc()
+		return
+	}
+	if err := checkAccessPolicy(GetClientIP(r), record, identity); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		h.metrics.RequestsTotal.WithLabelValues("403").Inc()
+		return
+	}
+	if !h.isBucketAllowed(record.Bucket) {
+		http.Error(w, "bucket not allowed", http.StatusForbidden)
+		h.metrics.RequestsTotal.WithLabelValues("403").Inc()
+		return
+	}
+
+	objects := record.Objects
+	if files := r.URL.Query().Get("files"); files != "" {
+		if objects, err = selectObjects(objects, files); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			h.metrics.RequestsTotal.WithLabelValues("400").Inc()
+			return
+		}
+	}
+	objects = h.filterFilesByExtension(objects)
+
+	report := ValidationReport{
+		ID:            id,
+		FileCount:     len(objects),
+		Missing:       []string{},
+		TooLarge:      []string{},
+		Unavailable:   []string{},
+		IgnoreMissing: h.ignoreMissing,
+	}
+	infos, errs := storage.StatAll(ctx, h.storage, record.Bucket, objects, int(h.fetchConcurrency()))
+	for i, err := range errs {
+		key := objects[i]
+		switch {
+		case storage.IsNotFound(err):
+			report.Missing = append(report.Missing, key)
+		case err != nil:
+			report.Unavailable = append(report.Unavailable, key)
+			h.log(ctx).Warn("object lookup failed", zap.String("id", id), zap.String("key", key), zap.Error(err))
+		default:
+			if h.maxFileSize > 0 && infos[i].Size > h.maxFileSize {
+				report.TooLarge = append(report.TooLarge, key)
+			}
+			if infos[i].Size > 0 {
+				report.TotalSize += infos[i].Size
+			}
+		}
+	}
+	report.Valid = len(report.Missing) == 0 && len(report.TooLarge) == 0 && len(report.Unavailable) == 0 &&
+		(len(objects) > 0 || len(record.Directories) > 0 || h.allowEmptyRecords)
+
+	h.log(ctx).Info("validated record", zap.String("id", id), zap.Bool("valid", report.Valid), zap.Int("files", report.FileCount), zap.Int("missing", len(report.Missing)))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+	h.metrics.RequestsTotal.WithLabelValues("200").Inc()
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/auth"
+	"zipperfly/internal/models"
+	"zipperfly/internal/storage"
+)
+
+// statStorage adds StatObject to mockDownloadStorage, reporting keys
+// without content as not found, failing keys in broken, and modification
+// times from modTimes
+type statStorage struct {
+	mockDownloadStorage
+	broken   map[string]bool
+	modTimes map[string]time.Time // bucket:key -> last modified, zero if absent
+}
+
+func (s *statStorage) StatObject(ctx context.Context, bucket, key string) (*storage.ObjectInfo, error) {
+	if s.broken[key] {
+		return nil, errors.New("connection reset")
+	}
+	content, ok := s.files[bucket+":"+key]
+	if !ok {
+		return nil, storage.ErrObjectNotFound
+	}
+	return &storage.ObjectInfo{Size: int64(len(content)), ModTime: s.modTimes[bucket+":"+key]}, nil
+}
+
+func TestHandler_Validate(t *testing.T) {
+	store := &statStorage{
+		mockDownloadStorage: mockDownloadStorage{files: map[string]string{
+			"bucket:a.txt": "alpha",
+			"bucket:b.txt": "bravo!",
+			"bucket:c.txt": "charlie charlie",
+		}},
+		broken: map[string]bool{"d.txt": true},
+	}
+	db := &mockDownloadDB{records: map[string]*models.DownloadRecord{
+		"ok":      {ID: "ok", Bucket: "bucket", Objects: []string{"a.txt", "b.txt"}},
+		"missing": {ID: "missing", Bucket: "bucket", Objects: []string{"a.txt", "gone.txt"}},
+		"broken":  {ID: "broken", Bucket: "bucket", Objects: []string{"a.txt", "d.txt"}},
+		"large":   {ID: "large", Bucket: "bucket", Objects: []string{"a.txt", "c.txt"}},
+		"other":   {ID: "other", Bucket: "other", Objects: []string{"a.txt"}},
+	}}
+
+	tests := []struct {
+		name            string
+		id              string
+		query           string
+		wantStatus      int
+		wantValid       bool
+		wantCount       int
+		wantSize        int64
+		wantMissing     []string
+		wantTooLarge    []string
+		wantUnavailable []string
+	}{
+		{name: "all present", id: "ok", wantStatus: http.StatusOK, wantValid: true, wantCount: 2, wantSize: 11},
+		{name: "missing object", id: "missing", wantStatus: http.StatusOK, wantCount: 2, wantSize: 5, wantMissing: []string{"gone.txt"}},
+		{name: "lookup failure", id: "broken", wantStatus: http.StatusOK, wantCount: 2, wantSize: 5, wantUnavailable: []string{"d.txt"}},
+		{name: "too large", id: "large", wantStatus: http.StatusOK, wantCount: 2, wantSize: 20, wantTooLarge: []string{"c.txt"}},
+		{name: "selection", id: "missing", query: "?files=0", wantStatus: http.StatusOK, wantValid: true, wantCount: 1, wantSize: 5},
+		{name: "bad selection", id: "ok", query: "?files=9", wantStatus: http.StatusBadRequest},
+		{name: "unknown record", id: "nope", wantStatus: http.StatusNotFound},
+		{name: "bucket not allowed", id: "other", wantStatus: http.StatusForbidden},
+	}
+
+	h := NewDownloadHandler(zap.NewNop(), db, store, auth.NewVerifier(nil, false, 1, sharedMetrics, nil), sharedMetrics, HandlerOptions{
+		MaxConcurrent:  2,
+		MaxFileSize:    10,
+		AllowedBuckets: []string{"bucket"},
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/"+tt.id+"/validate"+tt.query, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": tt.id})
+			w := httptest.NewRecorder()
+			h.Validate(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var report ValidationReport
+			if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+				t.Fatalf("failed to decode report: %v", err)
+			}
+			if report.ID != tt.id || report.Valid != tt.wantValid || report.FileCount != tt.wantCount || report.TotalSize != tt.wantSize {
+				t.Errorf("report = %+v, want valid %v, %d files, %d bytes", report, tt.wantValid, tt.wantCount, tt.wantSize)
+			}
+			if !slices.Equal(report.Missing, tt.wantMissing) {
+				t.Errorf("missing = %v, want %v", report.Missing, tt.wantMissing)
+			}
+			if !slices.Equal(report.TooLarge, tt.wantTooLarge) {
+				t.Errorf("too_large = %v, want %v", report.TooLarge, tt.wantTooLarge)
+			}
+			if !slices.Equal(report.Unavailable, tt.wantUnavailable) {
+				t.Errorf("unavailable = %v, want %v", report.Unavailable, tt.wantUnavailable)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"zipperfly/internal/version"
+)
+
+// Version returns the build metadata of the running binary: version, git
+// commit, build date, and Go version
+func Version(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version.Get())
+}
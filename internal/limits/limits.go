@@ -0,0 +1,156 @@
+// Package limits provides the rate and concurrency limits, kept either in
+// process or in Redis so that every replica shares them.
+package limits
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Rate is a token bucket per key
+type Rate interface {
+	// Reserve takes a token from key's bucket, returning 0 if one was
+	// available and otherwise how long until one will be
+	Reserve(ctx context.Context, key string) (time.Duration, error)
+	// SetLimit changes the rate to perSecond per key, allowing bursts of
+	// burst, e.g. on a config reload. perSecond <= 0 lifts the limit.
+	SetLimit(perSecond float64, burst int)
+}
+
+// Slots caps how much work runs at once
+type Slots interface {
+	// TryAcquire takes a slot without waiting, returning whether one was
+	// free. release gives it back, and must be called once when ok is true.
+	TryAcquire(ctx context.Context) (release func(), ok bool, err error)
+	// SetLimit changes the number of slots, e.g. on a config reload. Slots
+	// already taken stay taken; n <= 0 lifts the limit.
+	SetLimit(n int)
+}
+
+// localIdleTTL is how long a key's bucket is kept after its last request
+const localIdleTTL = 10 * time.Minute
+
+// LocalRate is a Rate kept in process memory
+type LocalRate struct {
+	mu        sync.Mutex
+	limit     rate.Limit // <= 0 = unlimited
+	burst     int
+	buckets   map[string]*localBucket
+	lastSweep time.Time
+}
+
+type localBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewLocalRate creates an in-process rate of perSecond per key, allowing
+// bursts of burst; perSecond <= 0 is unlimited
+func NewLocalRate(perSecond float64, burst int) *LocalRate {
+	l := &LocalRate{
+		buckets:   make(map[string]*localBucket),
+		lastSweep: time.Now(),
+	}
+	l.SetLimit(perSecond, burst)
+	return l
+}
+
+// Reserve implements Rate
+func (l *LocalRate) Reserve(_ context.Context, key string) (time.Duration, error) {
+	return l.reserve(key, time.Now()), nil
+}
+
+// SetLimit implements Rate. Keys keep the tokens they have, up to the new
+// burst.
+func (l *LocalRate) SetLimit(perSecond float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = rate.Limit(perSecond)
+	l.burst = max(burst, 1)
+	if perSecond <= 0 {
+		clear(l.buckets)
+		return
+	}
+	for _, b := range l.buckets {
+		b.limiter.SetLimit(l.limit)
+		b.limiter.SetBurst(l.burst)
+	}
+}
+
+func (l *LocalRate) reserve(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit <= 0 {
+		return 0
+	}
+
+	// Drop idle buckets now and then, so the map doesn't grow with every
+	// key seen
+	if now.Sub(l.lastSweep) > localIdleTTL {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) > localIdleTTL {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &localBucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	res := b.limiter.ReserveN(now, 1)
+	wait := res.DelayFrom(now)
+	if wait > 0 {
+		// Rejected requests don't use up the tokens of later ones
+		res.CancelAt(now)
+	}
+	return wait
+}
+
+// LocalSlots is Slots kept in process memory
+type LocalSlots struct {
+	mu   sync.Mutex
+	n    int // <= 0 = unlimited
+	used int // counted even when unlimited, so a new limit includes them
+}
+
+// NewLocalSlots creates n in-process slots; n <= 0 is unlimited
+func NewLocalSlots(n int) *LocalSlots {
+	return &LocalSlots{n: n}
+}
+
+// TryAcquire implements Slots
+func (s *LocalSlots) TryAcquire(context.Context) (func(), bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.n > 0 && s.used >= s.n {
+		return nil, false, nil
+	}
+	s.used++
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			s.mu.Lock()
+			s.used--
+			s.mu.Unlock()
+		})
+	}
+	return release, true, nil
+}
+
+// SetLimit implements Slots
+func (s *LocalSlots) SetLimit(n int) {
+	s.mu.Lock()
+	s.n = n
+	s.mu.Unlock()
+}
@@ -0,0 +1,110 @@
+package limits
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocalRate_Reserve(t *testing.T) {
+	l := NewLocalRate(1, 2)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if wait := l.reserve("203.0.113.7", now); wait != 0 {
+			t.Fatalf("burst request %d wait = %s, want 0", i+1, wait)
+		}
+	}
+	// Rejected requests don't push the next token further out
+	for i := 0; i < 3; i++ {
+		if wait := l.reserve("203.0.113.7", now); wait <= 0 || wait > time.Second {
+			t.Fatalf("throttled request wait = %s, want (0, 1s]", wait)
+		}
+	}
+	if wait := l.reserve("203.0.113.7", now.Add(time.Second)); wait != 0 {
+		t.Errorf("wait after refill = %s, want 0", wait)
+	}
+
+	// Keys have their own buckets
+	if wait := l.reserve("198.51.100.1", now); wait != 0 {
+		t.Errorf("other key wait = %s, want 0", wait)
+	}
+
+	// Idle buckets are dropped
+	l.reserve("198.51.100.1", now.Add(2*localIdleTTL))
+	if _, ok := l.buckets["203.0.113.7"]; ok {
+		t.Error("idle bucket was not dropped")
+	}
+}
+
+func TestLocalSlots_TryAcquire(t *testing.T) {
+	s := NewLocalSlots(2)
+	ctx := context.Background()
+
+	release1, ok, _ := s.TryAcquire(ctx)
+	if !ok {
+		t.Fatal("first TryAcquire() ok = false")
+	}
+	if _, ok, _ := s.TryAcquire(ctx); !ok {
+		t.Fatal("second TryAcquire() ok = false")
+	}
+	if _, ok, _ := s.TryAcquire(ctx); ok {
+		t.Fatal("TryAcquire() over the limit ok = true")
+	}
+	release1()
+	if _, ok, _ := s.TryAcquire(ctx); !ok {
+		t.Error("TryAcquire() after release ok = false")
+	}
+}
+
+func TestLocalRate_SetLimit(t *testing.T) {
+	l := NewLocalRate(1, 1)
+	now := time.Now()
+
+	l.reserve("203.0.113.7", now)
+	if wait := l.reserve("203.0.113.7", now); wait <= 0 {
+		t.Fatalf("throttled request wait = %s, want > 0", wait)
+	}
+
+	// A larger burst applies to keys already seen
+	l.SetLimit(1, 3)
+	if wait := l.reserve("203.0.113.7", now.Add(2*time.Second)); wait != 0 {
+		t.Errorf("wait after raising the burst = %s, want 0", wait)
+	}
+
+	// No limit lets everything through
+	l.SetLimit(0, 0)
+	for i := 0; i < 10; i++ {
+		if wait := l.reserve("203.0.113.7", now); wait != 0 {
+			t.Fatalf("unlimited request %d wait = %s, want 0", i+1, wait)
+		}
+	}
+}
+
+func TestLocalSlots_SetLimit(t *testing.T) {
+	s := NewLocalSlots(0)
+	ctx := context.Background()
+
+	// Unlimited slots still count, so a new limit includes them
+	var releases []func()
+	for i := 0; i < 3; i++ {
+		release, ok, _ := s.TryAcquire(ctx)
+		if !ok {
+			t.Fatalf("unlimited TryAcquire() %d ok = false", i+1)
+		}
+		releases = append(releases, release)
+	}
+	s.SetLimit(2)
+	if _, ok, _ := s.TryAcquire(ctx); ok {
+		t.Fatal("TryAcquire() with 3 of 2 slots taken ok = true")
+	}
+	releases[0]()
+	releases[0]() // releasing twice is harmless
+	if _, ok, _ := s.TryAcquire(ctx); ok {
+		t.Fatal("TryAcquire() with 2 of 2 slots taken ok = true")
+	}
+	releases[1]()
+	if _, ok, _ := s.TryAcquire(ctx); !ok {
+		t.Error("TryAcquire() with 1 of 2 slots taken ok = false")
+	}
+}
@@ -0,0 +1,191 @@
+package limits
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// slotLease is how long a slot outlives a replica that stopped renewing it,
+// e.g. after a crash
+const slotLease = time.Minute
+
+// rateScript is GCRA, the token bucket as one timestamp per key: the
+// theoretical arrival time of the next request. It returns 0 when the
+// request is allowed, else the milliseconds until it would be. Redis's
+// clock is used so that every replica agrees; times are in milliseconds
+// since Lua stores numbers with 14 significant digits.
+var rateScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + tonumber(t[2]) / 1000
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then tat = now end
+local next_tat = tat + interval
+local allow_at = next_tat - interval * burst
+if now < allow_at then
+	return math.ceil(allow_at - now)
+end
+redis.call('SET', KEYS[1], next_tat, 'PX', math.ceil(next_tat - now))
+return 0
+`)
+
+// acquireScript adds a lease to the slot set if fewer than the limit are
+// live, dropping expired ones first. It returns 1 if the slot was taken.
+var acquireScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], now + tonumber(ARGV[2]), ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+
+// renewScript extends a lease that is still held
+var renewScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+if redis.call('ZADD', KEYS[1], 'XX', 'CH', now + tonumber(ARGV[1]), ARGV[2]) == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+// Redis keeps limits in Redis, shared by every replica using the same
+// server and key prefix
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedis connects to the Redis server at url
+func NewRedis(ctx context.Context, url, prefix string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("redis parse url error: %w", err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("redis connect error: %w", err)
+	}
+	return &Redis{client: client, prefix: prefix}, nil
+}
+
+// Close closes the connection
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+// Rate returns a shared rate of perSecond per key, allowing bursts of burst;
+// perSecond <= 0 is unlimited. name separates it from other rates.
+func (r *Redis) Rate(name string, perSecond float64, burst int) Rate {
+	rate := &redisRate{client: r.client, prefix: r.prefix + "rate:" + name + ":"}
+	rate.SetLimit(perSecond, burst)
+	return rate
+}
+
+// Slots returns n shared slots; n <= 0 is unlimited. name separates them
+// from other slots.
+func (r *Redis) Slots(name string, n int) Slots {
+	slots := &redisSlots{client: r.client, key: r.prefix + "slots:" + name}
+	slots.SetLimit(n)
+	return slots
+}
+
+type redisRate struct {
+	client *redis.Client
+	prefix string
+
+	mu       sync.Mutex
+	interval float64 // milliseconds between tokens, 0 = unlimited
+	burst    int
+}
+
+func (r *redisRate) SetLimit(perSecond float64, burst int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interval = 0
+	if perSecond > 0 {
+		r.interval = 1000 / perSecond
+	}
+	r.burst = max(burst, 1)
+}
+
+func (r *redisRate) Reserve(ctx context.Context, key string) (time.Duration, error) {
+	r.mu.Lock()
+	interval, burst := r.interval, r.burst
+	r.mu.Unlock()
+	if interval == 0 {
+		return 0, nil
+	}
+
+	wait, err := rateScript.Run(ctx, r.client, []string{r.prefix + key}, interval, burst).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("redis rate limit error: %w", err)
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+type redisSlots struct {
+	client *redis.Client
+	key    string
+	n      atomic.Int64 // <= 0 = unlimited
+}
+
+// SetLimit changes the number of slots. While unlimited, slots aren't taken
+// in Redis at all, so downloads started then don't count toward a new limit.
+func (s *redisSlots) SetLimit(n int) {
+	s.n.Store(int64(n))
+}
+
+func (s *redisSlots) TryAcquire(ctx context.Context) (func(), bool, error) {
+	n := s.n.Load()
+	if n <= 0 {
+		return func() {}, true, nil
+	}
+
+	lease := uuid.New().String()
+	leaseMS := strconv.FormatInt(slotLease.Milliseconds(), 10)
+	ok, err := acquireScript.Run(ctx, s.client, []string{s.key}, n, leaseMS, lease).Int()
+	if err != nil {
+		return nil, false, fmt.Errorf("redis slot error: %w", err)
+	}
+	if ok != 1 {
+		return nil, false, nil
+	}
+
+	// Renew the lease while the slot is held, so a long download keeps it
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(slotLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				renewScript.Run(context.Background(), s.client, []string{s.key}, leaseMS, lease)
+			}
+		}
+	}()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			close(done)
+			s.client.ZRem(context.Background(), s.key, lease)
+		})
+	}
+	return release, true, nil
+}
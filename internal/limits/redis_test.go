@@ -0,0 +1,100 @@
+package limits
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestRedis connects to a local Redis under a per-test prefix, skipping
+// the test when none is running
+func newTestRedis(t *testing.T) *Redis {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping redis test in short mode")
+	}
+	r, err := NewRedis(context.Background(), "redis://localhost:6379/0", "zipperfly-test:"+t.Name()+":")
+	if err != nil {
+		t.Skipf("Redis not available: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		keys, _ := r.client.Keys(ctx, r.prefix+"*").Result()
+		if len(keys) > 0 {
+			r.client.Del(ctx, keys...)
+		}
+		r.Close()
+	})
+	return r
+}
+
+func TestRedis_Rate(t *testing.T) {
+	r := newTestRedis(t)
+	ctx := context.Background()
+
+	// Two replicas share one bucket
+	a, b := r.Rate("ip", 1, 2), r.Rate("ip", 1, 2)
+	for i, rate := range []Rate{a, b} {
+		if wait, err := rate.Reserve(ctx, "203.0.113.7"); err != nil || wait != 0 {
+			t.Fatalf("burst request %d: wait = %s, err = %v, want 0", i+1, wait, err)
+		}
+	}
+	wait, err := a.Reserve(ctx, "203.0.113.7")
+	if err != nil || wait <= 0 || wait > time.Second {
+		t.Fatalf("throttled request: wait = %s, err = %v, want (0, 1s]", wait, err)
+	}
+	if wait, err := b.Reserve(ctx, "198.51.100.1"); err != nil || wait != 0 {
+		t.Errorf("other key: wait = %s, err = %v, want 0", wait, err)
+	}
+}
+
+func TestRedis_Slots(t *testing.T) {
+	r := newTestRedis(t)
+	ctx := context.Background()
+
+	a, b := r.Slots("downloads", 1), r.Slots("downloads", 1)
+	release, ok, err := a.TryAcquire(ctx)
+	if err != nil || !ok {
+		t.Fatalf("TryAcquire() ok = %v, err = %v", ok, err)
+	}
+	if _, ok, err := b.TryAcquire(ctx); err != nil || ok {
+		t.Fatalf("TryAcquire() on another replica ok = %v, err = %v, want false", ok, err)
+	}
+	release()
+	release() // releasing twice is harmless
+	if release, ok, err := b.TryAcquire(ctx); err != nil || !ok {
+		t.Errorf("TryAcquire() after release ok = %v, err = %v", ok, err)
+	} else {
+		release()
+	}
+}
+
+func TestRedis_SetLimit(t *testing.T) {
+	r := newTestRedis(t)
+	ctx := context.Background()
+
+	rate := r.Rate("ip", 0, 0)
+	for i := 0; i < 5; i++ {
+		if wait, err := rate.Reserve(ctx, "203.0.113.7"); err != nil || wait != 0 {
+			t.Fatalf("unlimited request %d: wait = %s, err = %v, want 0", i+1, wait, err)
+		}
+	}
+	rate.SetLimit(1, 1)
+	rate.Reserve(ctx, "203.0.113.7")
+	if wait, err := rate.Reserve(ctx, "203.0.113.7"); err != nil || wait <= 0 {
+		t.Errorf("throttled request: wait = %s, err = %v, want > 0", wait, err)
+	}
+
+	slots := r.Slots("downloads", 1)
+	release, ok, err := slots.TryAcquire(ctx)
+	if err != nil || !ok {
+		t.Fatalf("TryAcquire() ok = %v, err = %v", ok, err)
+	}
+	defer release()
+	slots.SetLimit(2)
+	if release, ok, err := slots.TryAcquire(ctx); err != nil || !ok {
+		t.Errorf("TryAcquire() after raising the limit ok = %v, err = %v", ok, err)
+	} else {
+		release()
+	}
+}
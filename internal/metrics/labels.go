@@ -0,0 +1,40 @@
+package metrics
+
+import "sync"
+
+// OtherLabel replaces label values past a LabelCap's limit
+const OtherLabel = "other"
+
+// LabelCap bounds the cardinality of a label fed from request data: the
+// first limit distinct values pass through, later ones become OtherLabel.
+// It is safe for concurrent use.
+type LabelCap struct {
+	limit int
+
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+// NewLabelCap returns a LabelCap allowing limit distinct values
+func NewLabelCap(limit int) *LabelCap {
+	return &LabelCap{limit: limit, seen: make(map[string]struct{})}
+}
+
+// Value returns value if it is one of the first limit distinct values seen,
+// OtherLabel otherwise. The empty value is passed through uncounted.
+func (c *LabelCap) Value(value string) string {
+	if value == "" {
+		return ""
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.seen[value]; ok {
+		return value
+	}
+	if len(c.seen) >= c.limit {
+		return OtherLabel
+	}
+	c.seen[value] = struct{}{}
+	return value
+}
@@ -0,0 +1,25 @@
+package metrics
+
+import "testing"
+
+func TestLabelCap_Value(t *testing.T) {
+	c := NewLabelCap(2)
+
+	steps := []struct {
+		value string
+		want  string
+	}{
+		{"acme", "acme"},
+		{"", ""},
+		{"globex", "globex"},
+		{"initech", OtherLabel},
+		{"acme", "acme"},
+		{"globex", "globex"},
+		{"umbrella", OtherLabel},
+	}
+	for _, s := range steps {
+		if got := c.Value(s.value); got != s.want {
+			t.Errorf("Value(%q) = %q, want %q", s.value, got, s.want)
+		}
+	}
+}
@@ -1,12 +1,17 @@
 package metrics
 
 import (
+    "net/http"
     "runtime"
     "sync"
+    "sync/atomic"
     "time"
 
     "github.com/prometheus/client_golang/prometheus"
     "github.com/prometheus/client_golang/prometheus/promauto"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+
+    "zipperfly/internal/version"
 )
 
 var (
@@ -20,43 +25,75 @@ type Metrics struct {
 	RequestsTotal *prometheus.CounterVec
 
 	// Download outcomes
-	DownloadsTotal *prometheus.CounterVec // by status: completed, failed, partial
+	DownloadsTotal *prometheus.CounterVec // by status: completed, failed, partial; and tenant
 
 	// File-level metrics
 	FilesRequestedHist prometheus.Histogram // Total files requested per download
 	FilesSuccessHist   prometheus.Histogram // Files successfully fetched per download
-	FilesFetchTotal    *prometheus.CounterVec // Total file fetches by result: success, missing, error
+	FilesFetchTotal    *prometheus.CounterVec // Total file fetches by result: success, missing, error, timeout, stalled, too_large; and tenant
 	MissingFilesTotal  prometheus.Counter // Total count of missing files encountered
 
 	// Performance metrics
 	DurationHist      prometheus.Histogram
-	OutgoingBytesHist prometheus.Histogram
+	OutgoingBytesHist *prometheus.HistogramVec // by tenant
 	IncomingBytesHist prometheus.Histogram
 
+	// Streaming, updated while responses are written
+	StreamedBytesTotal prometheus.Counter // bytes sent to clients, counted as they go out
+	BytesInFlight      prometheus.Gauge   // bytes sent so far by responses still streaming
+	ThroughputGauge    prometheus.Gauge   // bytes per second sent to clients, over the last second
+	streamed           atomic.Int64       // bytes sent since the last throughput update
+
 	// Backend performance
 	DatabaseQueryDuration *prometheus.HistogramVec // DB query latency by db_type
 	StorageFetchDuration  *prometheus.HistogramVec // Storage fetch latency by storage_type
+	StorageRetriesDenied  prometheus.Counter       // storage retries skipped because the download's retry budget ran out
+	StorageRetriesLate    prometheus.Counter       // storage retries skipped because they couldn't finish before the deadline
+	StorageHedgedRequests *prometheus.CounterVec   // hedged S3 fetches, by result: won, lost, failed
+	StorageFailovers      *prometheus.CounterVec   // objects fetched from the failover storage, by result: success, failure
+	RecordCacheLookups    *prometheus.CounterVec   // record cache lookups, by result: hit, miss
+	DegradedRecordsServed prometheus.Counter       // stale cached records served because the database failed
 
 	// Authentication/Security
 	SignatureFailuresTotal prometheus.Counter
 	ExpiredRequestsTotal   prometheus.Counter
+	RequestsThrottledTotal prometheus.Counter // requests rejected by a rate limit
+	LimiterErrorsTotal     prometheus.Counter // limit checks that failed, letting the request through
+	RequestsBlockedTotal   *prometheus.CounterVec // requests refused by the client filters, by reason: ip, country
+	APIKeyRequestsTotal    *prometheus.CounterVec // requests authenticated by an API key, by key name
+	DownloadTokensTotal    *prometheus.CounterVec // one-time download tokens, by event: issued, redeemed, restored, invalid
 
 	// Callback metrics
 	CallbacksTotal    *prometheus.CounterVec // by status: success, failure
 	CallbackRetries   prometheus.Counter
+	EventsTotal       *prometheus.CounterVec // by type and status: success, failure
 
 	// Concurrency
 	ActiveDownloads    prometheus.Gauge
 	ActiveFileFetches  prometheus.Gauge
+	DownloadQueueDepth prometheus.Gauge         // downloads waiting for a slot
+	DownloadQueueWait  *prometheus.HistogramVec // time queued for a slot, by result: acquired, timeout, full, cancelled
+	AdaptiveScale      prometheus.Gauge         // scale adaptive concurrency applies to the limits, 0.1 to 1
+	AdaptiveReductions *prometheus.CounterVec   // cuts to the adaptive scale, by reason: memory, backpressure
 
 	// ZIP statistics
-	CompressionRatio prometheus.Histogram
+	CompressionRatio   prometheus.Histogram
+	Zip64ArchivesTotal prometheus.Counter // ZIP archives that needed Zip64 records
+	SizeLimitsTotal    *prometheus.CounterVec // downloads stopped by a size limit, by limit: file, archive
+
+	// Slow and large downloads
+	DownloadThresholdsTotal *prometheus.CounterVec // downloads over a reporting threshold, by threshold: duration, size
 
 	// Client behavior
 	ClientDisconnectsTotal prometheus.Counter
 
+	// Shutdown
+	ShutdownCutOffTotal prometheus.Counter // downloads still streaming when SHUTDOWN_TIMEOUT ran out
+
 	// Circuit breaker
 	CircuitBreakerState *prometheus.GaugeVec // by backend: storage, database
+	CircuitBreakersOpen *prometheus.GaugeVec // by backend: breakers open, more than one with per-bucket storage breakers
+	CircuitBreakerTransitions *prometheus.CounterVec // by backend and state changed to: open, half-open, closed
 
 	// Health checks
 	HealthStatus      *prometheus.GaugeVec // by component: database, storage (1=healthy, 0=unhealthy)
@@ -65,147 +102,333 @@ type Metrics struct {
 	// System metrics
 	MemoryGauge     prometheus.Gauge
 	GoroutinesGauge prometheus.Gauge
+	BuildInfo       prometheus.GaugeFunc // always 1, labeled with the build's version, commit, build date, and Go version
+
+	// Config reloads
+	ConfigReloadsTotal *prometheus.CounterVec // by result: success, failure
+
+	registerer prometheus.Registerer // nil = unregistered
 }
 
-// New creates and registers all metrics
+// New returns the process-wide metrics, registered with the default
+// Prometheus registry on first use
 func New() *Metrics {
     metricsOnce.Do(func() {
-        defaultMetrics = &Metrics{
-            // HTTP requests
-            RequestsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
-                Name: "zipperfly_requests_total",
-                Help: "Total number of HTTP requests by status code",
-            }, []string{"status"}),
-
-            // Download outcomes
-            DownloadsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
-                Name: "zipperfly_downloads_total",
-                Help: "Total number of download attempts by outcome (completed, failed, partial)",
-            }, []string{"status"}),
-
-            // File-level metrics
-            FilesRequestedHist: promauto.NewHistogram(prometheus.HistogramOpts{
-                Name:    "zipperfly_files_requested",
-                Help:    "Number of files requested per download",
-                Buckets: []float64{1, 5, 10, 20, 50, 100, 200, 500, 1000, 5000},
-            }),
-            FilesSuccessHist: promauto.NewHistogram(prometheus.HistogramOpts{
-                Name:    "zipperfly_files_success",
-                Help:    "Number of files successfully fetched per download",
-                Buckets: []float64{1, 5, 10, 20, 50, 100, 200, 500, 1000, 5000},
-            }),
-            FilesFetchTotal: promauto.NewCounterVec(prometheus.CounterOpts{
-                Name: "zipperfly_files_fetch_total",
-                Help: "Total file fetch attempts by result (success, missing, error)",
-            }, []string{"result"}),
-            MissingFilesTotal: promauto.NewCounter(prometheus.CounterOpts{
-                Name: "zipperfly_missing_files_total",
-                Help: "Total count of missing files encountered across all downloads",
-            }),
-
-            // Performance metrics
-            DurationHist: promauto.NewHistogram(prometheus.HistogramOpts{
-                Name:    "zipperfly_request_duration_seconds",
-                Help:    "Request duration in seconds",
-                Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800}, // 1s to 30min
-            }),
-            OutgoingBytesHist: promauto.NewHistogram(prometheus.HistogramOpts{
-                Name:    "zipperfly_outgoing_bytes",
-                Help:    "Outgoing bytes per response (compressed ZIP size)",
-                Buckets: prometheus.ExponentialBuckets(1024, 2, 35), // Up to ~32GB+
-            }),
-            IncomingBytesHist: promauto.NewHistogram(prometheus.HistogramOpts{
-                Name:    "zipperfly_incoming_bytes",
-                Help:    "Incoming bytes from storage per request (uncompressed)",
-                Buckets: prometheus.ExponentialBuckets(1024, 2, 35), // Up to ~32GB+
-            }),
-
-            // Backend performance
-            DatabaseQueryDuration: promauto.NewHistogramVec(prometheus.HistogramOpts{
-                Name:    "zipperfly_database_query_duration_seconds",
-                Help:    "Database query duration in seconds",
-                Buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
-            }, []string{"db_type"}),
-            StorageFetchDuration: promauto.NewHistogramVec(prometheus.HistogramOpts{
-                Name:    "zipperfly_storage_fetch_duration_seconds",
-                Help:    "Storage fetch duration per file in seconds",
-                Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
-            }, []string{"storage_type", "result"}),
-
-            // Authentication/Security
-            SignatureFailuresTotal: promauto.NewCounter(prometheus.CounterOpts{
-                Name: "zipperfly_signature_failures_total",
-                Help: "Total number of failed signature verifications",
-            }),
-            ExpiredRequestsTotal: promauto.NewCounter(prometheus.CounterOpts{
-                Name: "zipperfly_expired_requests_total",
-                Help: "Total number of requests with expired timestamps",
-            }),
-
-            // Callback metrics
-            CallbacksTotal: promauto.NewCounterVec(prometheus.CounterOpts{
-                Name: "zipperfly_callbacks_total",
-                Help: "Total number of callback attempts by status",
-            }, []string{"status"}),
-            CallbackRetries: promauto.NewCounter(prometheus.CounterOpts{
-                Name: "zipperfly_callback_retries_total",
-                Help: "Total number of callback retry attempts",
-            }),
-
-            // Concurrency
-            ActiveDownloads: promauto.NewGauge(prometheus.GaugeOpts{
-                Name: "zipperfly_active_downloads",
-                Help: "Number of currently active downloads",
-            }),
-            ActiveFileFetches: promauto.NewGauge(prometheus.GaugeOpts{
-                Name: "zipperfly_active_file_fetches",
-                Help: "Number of currently active file fetches",
-            }),
-
-            // ZIP statistics
-            CompressionRatio: promauto.NewHistogram(prometheus.HistogramOpts{
-                Name:    "zipperfly_compression_ratio",
-                Help:    "Compression ratio (compressed/uncompressed)",
-                Buckets: []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1.0},
-            }),
-
-            // Client behavior
-            ClientDisconnectsTotal: promauto.NewCounter(prometheus.CounterOpts{
-                Name: "zipperfly_client_disconnects_total",
-                Help: "Total number of client disconnects during download",
-            }),
-
-            // Circuit breaker
-            CircuitBreakerState: promauto.NewGaugeVec(prometheus.GaugeOpts{
-                Name: "zipperfly_circuit_breaker_state",
-                Help: "Circuit breaker state (0=closed, 1=open, 2=half-open)",
-            }, []string{"backend"}),
-
-            // Health checks
-            HealthStatus: promauto.NewGaugeVec(prometheus.GaugeOpts{
-                Name: "zipperfly_health_status",
-                Help: "Health status by component (1=healthy, 0=unhealthy)",
-            }, []string{"component"}),
-            HealthChecksFailed: promauto.NewCounterVec(prometheus.CounterOpts{
-                Name: "zipperfly_health_checks_failed_total",
-                Help: "Total number of failed health checks by component",
-            }, []string{"component"}),
-
-            // System metrics
-            MemoryGauge: promauto.NewGauge(prometheus.GaugeOpts{
-                Name: "zipperfly_memory_heap_alloc_bytes",
-                Help: "Current heap allocation in bytes",
-            }),
-            GoroutinesGauge: promauto.NewGauge(prometheus.GaugeOpts{
-                Name: "zipperfly_goroutines",
-                Help: "Number of goroutines",
-            }),
-	    }
+        defaultMetrics = NewWithRegistry(prometheus.DefaultRegisterer)
     })
 
     return defaultMetrics
 }
 
+// NewWithRegistry creates an independent set of metrics registered with
+// reg, e.g. a prometheus.NewRegistry() when embedding zipperfly in a larger
+// binary or running several instances in one test. A nil reg leaves them
+// unregistered. Registering two sets with the same registry panics.
+func NewWithRegistry(reg prometheus.Registerer) *Metrics {
+    factory := promauto.With(reg)
+
+    return &Metrics{
+        // HTTP requests
+        RequestsTotal: factory.NewCounterVec(prometheus.CounterOpts{
+            Name: "zipperfly_requests_total",
+            Help: "Total number of HTTP requests by status code",
+        }, []string{"status"}),
+
+        // Download outcomes
+        DownloadsTotal: factory.NewCounterVec(prometheus.CounterOpts{
+            Name: "zipperfly_downloads_total",
+            Help: "Total number of download attempts by outcome (completed, failed, partial) and tenant",
+        }, []string{"status", "tenant"}),
+
+        // File-level metrics
+        FilesRequestedHist: factory.NewHistogram(prometheus.HistogramOpts{
+            Name:    "zipperfly_files_requested",
+            Help:    "Number of files requested per download",
+            Buckets: []float64{1, 5, 10, 20, 50, 100, 200, 500, 1000, 5000},
+        }),
+        FilesSuccessHist: factory.NewHistogram(prometheus.HistogramOpts{
+            Name:    "zipperfly_files_success",
+            Help:    "Number of files successfully fetched per download",
+            Buckets: []float64{1, 5, 10, 20, 50, 100, 200, 500, 1000, 5000},
+        }),
+        FilesFetchTotal: factory.NewCounterVec(prometheus.CounterOpts{
+            Name: "zipperfly_files_fetch_total",
+            Help: "Total file fetch attempts by result (success, missing, error, timeout, stalled, too_large) and tenant",
+        }, []string{"result", "tenant"}),
+        MissingFilesTotal: factory.NewCounter(prometheus.CounterOpts{
+            Name: "zipperfly_missing_files_total",
+            Help: "Total count of missing files encountered across all downloads",
+        }),
+
+        // Performance metrics
+        DurationHist: factory.NewHistogram(prometheus.HistogramOpts{
+            Name:    "zipperfly_request_duration_seconds",
+            Help:    "Request duration in seconds",
+            Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800}, // 1s to 30min
+        }),
+        OutgoingBytesHist: factory.NewHistogramVec(prometheus.HistogramOpts{
+            Name:    "zipperfly_outgoing_bytes",
+            Help:    "Outgoing bytes per response (compressed ZIP size) by tenant",
+            Buckets: prometheus.ExponentialBuckets(1024, 2, 35), // Up to ~32GB+
+        }, []string{"tenant"}),
+        IncomingBytesHist: factory.NewHistogram(prometheus.HistogramOpts{
+            Name:    "zipperfly_incoming_bytes",
+            Help:    "Incoming bytes from storage per request (uncompressed)",
+            Buckets: prometheus.ExponentialBuckets(1024, 2, 35), // Up to ~32GB+
+        }),
+
+        // Streaming
+        StreamedBytesTotal: factory.NewCounter(prometheus.CounterOpts{
+            Name: "zipperfly_streamed_bytes_total",
+            Help: "Total bytes sent to clients, counted as they are written",
+        }),
+        BytesInFlight: factory.NewGauge(prometheus.GaugeOpts{
+            Name: "zipperfly_bytes_in_flight",
+            Help: "Bytes sent so far by downloads still streaming",
+        }),
+        ThroughputGauge: factory.NewGauge(prometheus.GaugeOpts{
+            Name: "zipperfly_throughput_bytes_per_second",
+            Help: "Bytes per second sent to clients over the last second",
+        }),
+
+        // Backend performance
+        DatabaseQueryDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+            Name:    "zipperfly_database_query_duration_seconds",
+            Help:    "Database query duration in seconds",
+            Buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+        }, []string{"db_type"}),
+        StorageFailovers: factory.NewCounterVec(prometheus.CounterOpts{
+            Name: "zipperfly_storage_failovers_total",
+            Help: "Objects fetched from the failover storage after the primary failed, by result: success, failure",
+        }, []string{"result"}),
+        RecordCacheLookups: factory.NewCounterVec(prometheus.CounterOpts{
+            Name: "zipperfly_record_cache_lookups_total",
+            Help: "Record cache lookups, by result: hit (served without querying the database) or miss",
+        }, []string{"result"}),
+        DegradedRecordsServed: factory.NewCounter(prometheus.CounterOpts{
+            Name: "zipperfly_degraded_records_served_total",
+            Help: "Stale cached records served because the database lookup failed (RECORD_CACHE_STALE)",
+        }),
+        StorageFetchDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+            Name:    "zipperfly_storage_fetch_duration_seconds",
+            Help:    "Storage fetch duration per file in seconds",
+            Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+        }, []string{"storage_type", "result"}),
+        StorageRetriesDenied: factory.NewCounter(prometheus.CounterOpts{
+            Name: "zipperfly_storage_retries_denied_total",
+            Help: "Storage fetch retries skipped because the download's retry budget ran out",
+        }),
+        StorageRetriesLate: factory.NewCounter(prometheus.CounterOpts{
+            Name: "zipperfly_storage_retries_past_deadline_total",
+            Help: "Storage fetch retries skipped because they couldn't finish before the fetch's deadline",
+        }),
+        StorageHedgedRequests: factory.NewCounterVec(prometheus.CounterOpts{
+            Name: "zipperfly_storage_hedged_requests_total",
+            Help: "S3 fetches that sent a second, hedged request, by result: won (the hedge answered first), lost, or failed",
+        }, []string{"result"}),
+
+        // Authentication/Security
+        SignatureFailuresTotal: factory.NewCounter(prometheus.CounterOpts{
+            Name: "zipperfly_signature_failures_total",
+            Help: "Total number of failed signature verifications",
+        }),
+        ExpiredRequestsTotal: factory.NewCounter(prometheus.CounterOpts{
+            Name: "zipperfly_expired_requests_total",
+            Help: "Total number of requests with expired timestamps",
+        }),
+        RequestsThrottledTotal: factory.NewCounter(prometheus.CounterOpts{
+            Name: "zipperfly_requests_throttled_total",
+            Help: "Total number of requests rejected by a rate limit",
+        }),
+        LimiterErrorsTotal: factory.NewCounter(prometheus.CounterOpts{
+            Name: "zipperfly_limiter_errors_total",
+            Help: "Total number of rate or concurrency limit checks that failed and let the request through",
+        }),
+        RequestsBlockedTotal: factory.NewCounterVec(prometheus.CounterOpts{
+            Name: "zipperfly_requests_blocked_total",
+            Help: "Total number of requests refused by the IP or country filters, by reason",
+        }, []string{"reason"}),
+        APIKeyRequestsTotal: factory.NewCounterVec(prometheus.CounterOpts{
+            Name: "zipperfly_api_key_requests_total",
+            Help: "Total number of requests authenticated by an API key, by key name",
+        }, []string{"key"}),
+        DownloadTokensTotal: factory.NewCounterVec(prometheus.CounterOpts{
+            Name: "zipperfly_download_tokens_total",
+            Help: "Total number of one-time download tokens issued, redeemed, restored, or presented invalid, by event",
+        }, []string{"event"}),
+
+        // Callback metrics
+        CallbacksTotal: factory.NewCounterVec(prometheus.CounterOpts{
+            Name: "zipperfly_callbacks_total",
+            Help: "Total number of callback attempts by status",
+        }, []string{"status"}),
+        CallbackRetries: factory.NewCounter(prometheus.CounterOpts{
+            Name: "zipperfly_callback_retries_total",
+            Help: "Total number of callback retry attempts",
+        }),
+        EventsTotal: factory.NewCounterVec(prometheus.CounterOpts{
+            Name: "zipperfly_events_total",
+            Help: "Total number of download lifecycle events published to the event bus by type and status",
+        }, []string{"type", "status"}),
+
+        // Concurrency
+        ActiveDownloads: factory.NewGauge(prometheus.GaugeOpts{
+            Name: "zipperfly_active_downloads",
+            Help: "Number of currently active downloads",
+        }),
+        ActiveFileFetches: factory.NewGauge(prometheus.GaugeOpts{
+            Name: "zipperfly_active_file_fetches",
+            Help: "Number of currently active file fetches",
+        }),
+        DownloadQueueDepth: factory.NewGauge(prometheus.GaugeOpts{
+            Name: "zipperfly_download_queue_depth",
+            Help: "Number of downloads waiting for a MAX_ACTIVE_DOWNLOADS slot",
+        }),
+        DownloadQueueWait: factory.NewHistogramVec(prometheus.HistogramOpts{
+            Name:    "zipperfly_download_queue_wait_seconds",
+            Help:    "Time downloads waited for a slot at capacity, by result: acquired, timeout, full, or cancelled",
+            Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+        }, []string{"result"}),
+        AdaptiveScale: factory.NewGauge(prometheus.GaugeOpts{
+            Name: "zipperfly_adaptive_concurrency_scale",
+            Help: "Scale adaptive concurrency applies to MAX_CONCURRENT and MAX_ACTIVE_DOWNLOADS, from 0.1 to 1",
+        }),
+        AdaptiveReductions: factory.NewCounterVec(prometheus.CounterOpts{
+            Name: "zipperfly_adaptive_concurrency_reductions_total",
+            Help: "Cuts to the adaptive concurrency scale, by reason: memory or backpressure",
+        }, []string{"reason"}),
+
+        // ZIP statistics
+        CompressionRatio: factory.NewHistogram(prometheus.HistogramOpts{
+            Name:    "zipperfly_compression_ratio",
+            Help:    "Compression ratio (compressed/uncompressed)",
+            Buckets: []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1.0},
+        }),
+        Zip64ArchivesTotal: factory.NewCounter(prometheus.CounterOpts{
+            Name: "zipperfly_zip64_archives_total",
+            Help: "Total number of ZIP archives that needed Zip64 records (over 4 GiB or 65,535 entries)",
+        }),
+        SizeLimitsTotal: factory.NewCounterVec(prometheus.CounterOpts{
+            Name: "zipperfly_size_limit_exceeded_total",
+            Help: "Total number of downloads stopped by MAX_FILE_SIZE (limit=file) or MAX_ARCHIVE_SIZE (limit=archive)",
+        }, []string{"limit"}),
+
+        // Slow and large downloads
+        DownloadThresholdsTotal: factory.NewCounterVec(prometheus.CounterOpts{
+            Name: "zipperfly_download_thresholds_exceeded_total",
+            Help: "Downloads over SLOW_DOWNLOAD_THRESHOLD or LARGE_DOWNLOAD_THRESHOLD, by threshold (duration, size)",
+        }, []string{"threshold"}),
+
+        // Client behavior
+        ClientDisconnectsTotal: factory.NewCounter(prometheus.CounterOpts{
+            Name: "zipperfly_client_disconnects_total",
+            Help: "Total number of client disconnects during download",
+        }),
+
+        // Shutdown
+        ShutdownCutOffTotal: factory.NewCounter(prometheus.CounterOpts{
+            Name: "zipperfly_shutdown_cut_off_downloads_total",
+            Help: "Downloads cut off because they were still streaming when SHUTDOWN_TIMEOUT ran out",
+        }),
+
+        // Circuit breaker
+        CircuitBreakerState: factory.NewGaugeVec(prometheus.GaugeOpts{
+            Name: "zipperfly_circuit_breaker_state",
+            Help: "Circuit breaker state (0=closed, 1=open, 2=half-open); with per-bucket breakers, open if any is, else half-open if any is",
+        }, []string{"backend"}),
+        CircuitBreakersOpen: factory.NewGaugeVec(prometheus.GaugeOpts{
+            Name: "zipperfly_circuit_breakers_open",
+            Help: "Number of circuit breakers open, per backend; more than one only with per-bucket storage breakers",
+        }, []string{"backend"}),
+        CircuitBreakerTransitions: factory.NewCounterVec(prometheus.CounterOpts{
+            Name: "zipperfly_circuit_breaker_transitions_total",
+            Help: "Circuit breaker state changes, by backend and the state changed to",
+        }, []string{"backend", "state"}),
+
+        // Health checks
+        HealthStatus: factory.NewGaugeVec(prometheus.GaugeOpts{
+            Name: "zipperfly_health_status",
+            Help: "Health status by component (1=healthy, 0=unhealthy)",
+        }, []string{"component"}),
+        HealthChecksFailed: factory.NewCounterVec(prometheus.CounterOpts{
+            Name: "zipperfly_health_checks_failed_total",
+            Help: "Total number of failed health checks by component",
+        }, []string{"component"}),
+
+        // System metrics
+        MemoryGauge: factory.NewGauge(prometheus.GaugeOpts{
+            Name: "zipperfly_memory_heap_alloc_bytes",
+            Help: "Current heap allocation in bytes",
+        }),
+        GoroutinesGauge: factory.NewGauge(prometheus.GaugeOpts{
+            Name: "zipperfly_goroutines",
+            Help: "Number of goroutines",
+        }),
+        BuildInfo: factory.NewGaugeFunc(prometheus.GaugeOpts{
+            Name:        "zipperfly_build_info",
+            Help:        "Build metadata of the running binary, as labels; always 1",
+            ConstLabels: buildLabels(),
+        }, func() float64 { return 1 }),
+
+        // Config reloads
+        ConfigReloadsTotal: factory.NewCounterVec(prometheus.CounterOpts{
+            Name: "zipperfly_config_reloads_total",
+            Help: "Config reloads on SIGHUP by result (success, failure)",
+        }, []string{"result"}),
+
+        registerer: reg,
+    }
+}
+
+// buildLabels returns the zipperfly_build_info labels
+func buildLabels() prometheus.Labels {
+	info := version.Get()
+	return prometheus.Labels{
+		"version":    info.Version,
+		"commit":     info.Commit,
+		"build_date": info.BuildDate,
+		"go_version": info.GoVersion,
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format: the default
+// registry's for New, or the registry given to NewWithRegistry if it can be
+// gathered from
+func (m *Metrics) Handler() http.Handler {
+	gatherer, ok := m.registerer.(prometheus.Gatherer)
+	if !ok || m.registerer == prometheus.DefaultRegisterer {
+		return promhttp.Handler()
+	}
+	return promhttp.InstrumentMetricHandler(m.registerer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+}
+
+// AddStreamedBytes counts n bytes written to a client, for
+// StreamedBytesTotal and ThroughputGauge
+func (m *Metrics) AddStreamedBytes(n int64) {
+	m.StreamedBytesTotal.Add(float64(n))
+	m.streamed.Add(n)
+}
+
+// StartThroughputCollector starts a goroutine that sets ThroughputGauge
+// every second from the bytes streamed since, so it drops to zero when
+// every stream stalls
+func (m *Metrics) StartThroughputCollector() {
+	go func() {
+		last := time.Now()
+		for {
+			time.Sleep(time.Second)
+			now := time.Now()
+			m.updateThroughput(now.Sub(last))
+			last = now
+		}
+	}()
+}
+
+// updateThroughput sets ThroughputGauge to the bytes streamed over elapsed
+func (m *Metrics) updateThroughput(elapsed time.Duration) {
+	m.ThroughputGauge.Set(float64(m.streamed.Swap(0)) / elapsed.Seconds())
+}
+
 // StartRuntimeMetricsCollector starts a goroutine that updates runtime metrics
 func (m *Metrics) StartRuntimeMetricsCollector() {
 	go func() {
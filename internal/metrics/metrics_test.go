@@ -4,6 +4,11 @@ import (
 	"runtime"
 	"testing"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"zipperfly/internal/version"
 )
 
 func TestNew_SingletonAndFieldsNonNil(t *testing.T) {
@@ -30,11 +35,66 @@ func TestNew_SingletonAndFieldsNonNil(t *testing.T) {
 	if m1.StorageFetchDuration == nil {
 		t.Error("StorageFetchDuration is nil")
 	}
+	if m1.Zip64ArchivesTotal == nil {
+		t.Error("Zip64ArchivesTotal is nil")
+	}
 	if m1.MemoryGauge == nil || m1.GoroutinesGauge == nil {
 		t.Error("runtime gauges are nil")
 	}
 }
 
+func TestNewWithRegistry_Independent(t *testing.T) {
+	reg1, reg2 := prometheus.NewRegistry(), prometheus.NewRegistry()
+	m1 := NewWithRegistry(reg1)
+	m2 := NewWithRegistry(reg2)
+	if m1 == m2 || m1 == New() {
+		t.Fatal("NewWithRegistry() returned a shared instance")
+	}
+
+	m1.RequestsTotal.WithLabelValues("200").Inc()
+	if got := testutil.ToFloat64(m1.RequestsTotal.WithLabelValues("200")); got != 1 {
+		t.Errorf("first instance count = %g, want 1", got)
+	}
+	if got := testutil.ToFloat64(m2.RequestsTotal.WithLabelValues("200")); got != 0 {
+		t.Errorf("second instance count = %g, want 0", got)
+	}
+
+	if n, err := testutil.GatherAndCount(reg1, "zipperfly_requests_total"); err != nil || n != 1 {
+		t.Errorf("GatherAndCount() = %d, %v; want 1, nil", n, err)
+	}
+
+	// Unregistered metrics work too
+	NewWithRegistry(nil).DownloadsTotal.WithLabelValues("completed", "").Inc()
+}
+
+func TestMetrics_BuildInfo(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	NewWithRegistry(reg)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "zipperfly_build_info" {
+			continue
+		}
+		metric := family.GetMetric()[0]
+		if got := metric.GetGauge().GetValue(); got != 1 {
+			t.Errorf("zipperfly_build_info = %g, want 1", got)
+		}
+		labels := map[string]string{}
+		for _, label := range metric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if labels["version"] != version.Version || labels["go_version"] == "" {
+			t.Errorf("zipperfly_build_info labels = %v, want version %q and a Go version", labels, version.Version)
+		}
+		return
+	}
+	t.Error("zipperfly_build_info not registered")
+}
+
 func TestStartRuntimeMetricsCollector_LaunchesGoroutine(t *testing.T) {
 	m := New()
 
@@ -50,3 +110,21 @@ func TestStartRuntimeMetricsCollector_LaunchesGoroutine(t *testing.T) {
 		t.Fatalf("expected goroutine count to stay the same or increase, before=%d after=%d", before, after)
 	}
 }
+
+func TestMetrics_Throughput(t *testing.T) {
+	m := New()
+	m.updateThroughput(time.Second) // drop bytes counted by other tests
+
+	m.AddStreamedBytes(3000)
+	m.AddStreamedBytes(1000)
+	m.updateThroughput(2 * time.Second)
+	if got := testutil.ToFloat64(m.ThroughputGauge); got != 2000 {
+		t.Errorf("throughput = %g, want 2000", got)
+	}
+
+	// A stalled second reads as zero
+	m.updateThroughput(time.Second)
+	if got := testutil.ToFloat64(m.ThroughputGauge); got != 0 {
+		t.Errorf("throughput after a stall = %g, want 0", got)
+	}
+}
@@ -1,27 +1,353 @@
 package models
 
-import "io"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
 
 // DownloadRecord represents a download entry from the database
 type DownloadRecord struct {
-	ID            string            `json:"id"`
-	Bucket        string            `json:"bucket"`
-	Objects       []string          `json:"objects"`
-	Name          string            `json:"name,omitempty"`
-	Callback      string            `json:"callback,omitempty"`
-	Password      string            `json:"password,omitempty"`       // Optional ZIP password
-	CustomHeaders map[string]string `json:"custom_headers,omitempty"` // Optional custom HTTP headers
+	ID               string                    `json:"id"`
+	Bucket           string                    `json:"bucket"`
+	Objects          []string                  `json:"objects"`
+	Name             string                    `json:"name,omitempty"`
+	Callbacks        Callbacks                 `json:"callback,omitempty"`          // Optional completion notifications: a URL, a Callback object, or an array of them
+	Password         string                    `json:"password,omitempty"`          // Optional ZIP password
+	CustomHeaders    map[string]string         `json:"custom_headers,omitempty"`    // Optional custom HTTP headers
+	DownloadCount    int                       `json:"download_count,omitempty"`    // Completed downloads so far
+	MaxDownloads     int                       `json:"max_downloads,omitempty"`     // Optional download limit, 0 = unlimited
+	StoreOnly        bool                      `json:"store_only,omitempty"`        // Write ZIP entries uncompressed
+	CompressionLevel int                       `json:"compression_level,omitempty"` // Deflate level 1-9, 0 = server default
+	Encryption       string                    `json:"encryption,omitempty"`        // ZIP encryption: "zipcrypto" or "aes256", "" = server default
+	Manifest         bool                      `json:"manifest,omitempty"`          // Append a manifest listing files, sizes, and checksums
+	ExtraFiles       map[string]string         `json:"extra_files,omitempty"`       // Extra archive entries: file name -> inline content
+	ObjectSizes      map[string]int64          `json:"object_sizes,omitempty"`      // Object key -> size in bytes; lets store-only ZIPs send Content-Length
+	Format           string                    `json:"format,omitempty"`            // Archive format when the URL has no ?format=, "" = zip
+	StoreExtensions  []string                  `json:"store_extensions,omitempty"`  // Extensions written uncompressed, in addition to ZIP_STORE_EXTENSIONS
+	ObjectMetadata   map[string]ObjectMetadata `json:"object_metadata,omitempty"`   // Object key -> entry timestamp and permissions, overriding storage
+	Directories      []string                  `json:"directories,omitempty"`       // Directory entries to create, e.g. "photos/2024", so empty folders survive extraction
+	Raw              bool                      `json:"raw,omitempty"`               // Serve the record's single object as itself instead of in an archive
+	AllowedIPs       []string                  `json:"allowed_ips,omitempty"`       // Client IPs or CIDRs that may download, none = any
+	BoundUserID      string                    `json:"bound_user_id,omitempty"`     // Only a JWT whose user claim (JWT_USER_CLAIM) matches may download
+	SingleUse        bool                      `json:"single_use,omitempty"`        // Refuse every request once one download has started
+	PasswordHash     string                    `json:"password_hash,omitempty"`     // bcrypt hash the requester's ZIP password must match
+	PasswordRequired bool                      `json:"password_required,omitempty"` // The requester must supply the ZIP password
+	Tenant           string                    `json:"tenant,omitempty"`            // Customer the download is attributed to in metrics (METRICS_TENANT_LABEL=tenant)
+}
+
+// AllowedNetworks parses AllowedIPs, each a CIDR or a single IP
+func (r *DownloadRecord) AllowedNetworks() ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range r.AllowedIPs {
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed_ips entry %q", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ObjectMetadata sets the archive entry attributes of one object
+type ObjectMetadata struct {
+	ModTime time.Time `json:"mtime,omitempty"` // zero = use the storage modification time
+	Mode    string    `json:"mode,omitempty"`  // octal permission bits, e.g. "0644"; "" = use storage
+}
+
+// FileMode parses Mode, returning 0 if it is unset
+func (m ObjectMetadata) FileMode() (fs.FileMode, error) {
+	if m.Mode == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(m.Mode, 8, 32)
+	if err != nil || mode > uint64(fs.ModePerm) {
+		return 0, fmt.Errorf("invalid mode %q: must be octal permission bits such as 0644", m.Mode)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// Callback is a request sent when a download finishes. It is stored as a
+// plain URL, or as a JSON object when it needs more than a POST of the payload.
+type Callback struct {
+	URL             string            `json:"url"`
+	Method          string            `json:"method,omitempty"`           // POST, PUT, or PATCH; "" = POST
+	Headers         map[string]string `json:"headers,omitempty"`          // Extra request headers
+	BearerToken     string            `json:"bearer_token,omitempty"`     // Sent as "Authorization: Bearer <token>"
+	PayloadTemplate string            `json:"payload_template,omitempty"` // text/template over CallbackPayload; "" = the payload as JSON
+}
+
+// callbackFields is Callback without its JSON methods
+type callbackFields Callback
+
+// ParseCallback parses a stored callback: a URL, or a JSON Callback object.
+// An empty string is no callback.
+func ParseCallback(s string) (*Callback, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(s, "{") {
+		return &Callback{URL: s}, nil
+	}
+	var c Callback
+	if err := json.Unmarshal([]byte(s), (*callbackFields)(&c)); err != nil {
+		return nil, fmt.Errorf("invalid callback: %w", err)
+	}
+	return &c, nil
+}
+
+// Encode returns the callback as stored: the bare URL when nothing else is
+// set, otherwise a JSON object. A nil callback encodes as "".
+func (c *Callback) Encode() string {
+	if c == nil {
+		return ""
+	}
+	if c.Method == "" && len(c.Headers) == 0 && c.BearerToken == "" && c.PayloadTemplate == "" {
+		return c.URL
+	}
+	data, _ := json.Marshal((*callbackFields)(c))
+	return string(data)
+}
+
+// MarshalJSON encodes the callback as Encode does
+func (c *Callback) MarshalJSON() ([]byte, error) {
+	if encoded := c.Encode(); !strings.HasPrefix(encoded, "{") {
+		return json.Marshal(encoded)
+	}
+	return json.Marshal((*callbackFields)(c))
+}
+
+// UnmarshalJSON accepts a URL string or a Callback object
+func (c *Callback) UnmarshalJSON(data []byte) error {
+	var url string
+	if err := json.Unmarshal(data, &url); err == nil {
+		*c = Callback{URL: url}
+		return nil
+	}
+	return json.Unmarshal(data, (*callbackFields)(c))
+}
+
+// Callbacks are the callbacks a download notifies. They are stored like a
+// single Callback when there is one, and as a JSON array otherwise.
+type Callbacks []*Callback
+
+// ParseCallbacks parses stored callbacks: a URL, a JSON Callback object, or
+// a JSON array of either. An empty string is no callbacks.
+func ParseCallbacks(s string) (Callbacks, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "[") {
+		c, err := ParseCallback(s)
+		if c == nil || err != nil {
+			return nil, err
+		}
+		return Callbacks{c}, nil
+	}
+	var cs []*Callback
+	if err := json.Unmarshal([]byte(s), &cs); err != nil {
+		return nil, fmt.Errorf("invalid callbacks: %w", err)
+	}
+	return Callbacks(cs), nil
+}
+
+// Encode returns the callbacks as stored: as Callback.Encode for one, a
+// JSON array for more, and "" for none
+func (cs Callbacks) Encode() string {
+	switch len(cs) {
+	case 0:
+		return ""
+	case 1:
+		return cs[0].Encode()
+	}
+	data, _ := json.Marshal([]*Callback(cs))
+	return string(data)
+}
+
+// MarshalJSON encodes a single callback on its own, and more as an array
+func (cs Callbacks) MarshalJSON() ([]byte, error) {
+	if len(cs) == 1 {
+		return json.Marshal(cs[0])
+	}
+	return json.Marshal([]*Callback(cs))
+}
+
+// UnmarshalJSON accepts a URL string, a Callback object, or an array of either
+func (cs *Callbacks) UnmarshalJSON(data []byte) error {
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		return json.Unmarshal(trimmed, (*[]*Callback)(cs))
+	}
+	if bytes.Equal(trimmed, []byte("null")) {
+		*cs = nil
+		return nil
+	}
+	var c Callback
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	*cs = Callbacks{&c}
+	return nil
+}
+
+// Validate checks each callback, naming the one at fault when there are several
+func (cs Callbacks) Validate() error {
+	for i, c := range cs {
+		if c == nil {
+			return fmt.Errorf("callback %d is empty", i)
+		}
+		if err := c.Validate(); err != nil {
+			if len(cs) > 1 {
+				return fmt.Errorf("callback %d: %w", i, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks the method and payload template
+func (c *Callback) Validate() error {
+	if c.URL == "" {
+		return errors.New("url is required")
+	}
+	switch strings.ToUpper(c.Method) {
+	case "", "POST", "PUT", "PATCH":
+	default:
+		return fmt.Errorf("method %q must be POST, PUT, or PATCH", c.Method)
+	}
+	if _, err := c.Template(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Template parses PayloadTemplate, returning nil if it is unset. Besides
+// the payload fields, templates can use {{json .Field}} to insert a value
+// as JSON, quoting and escaping strings.
+func (c *Callback) Template() (*template.Template, error) {
+	if c.PayloadTemplate == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("payload").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(c.PayloadTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload_template: %w", err)
+	}
+	return tmpl, nil
 }
 
 // CallbackPayload is sent to the callback URL after processing
 type CallbackPayload struct {
-	ID                  string `json:"id"`
-	Status              string `json:"status"`
+	ID                  string       `json:"id"`
+	RequestID           string       `json:"request_id,omitempty"` // X-Request-ID of the download request
+	ClientIP            string       `json:"client_ip,omitempty"`  // address of the client, as resolved with TRUSTED_PROXIES
+	Status              string       `json:"status"`
+	Timestamp           string       `json:"timestamp"`
+	Message             string       `json:"message,omitempty"`
+	DurationMs          int64        `json:"duration_ms"`
+	FileCount           int          `json:"file_count"`
+	CompressedSizeBytes int64        `json:"compressed_size_bytes"`
+	Encryption          string       `json:"encryption,omitempty"`     // ZIP encryption method, set for password-protected downloads
+	LimitExceeded       string       `json:"limit_exceeded,omitempty"` // "file" or "archive" when MAX_FILE_SIZE or MAX_ARCHIVE_SIZE stopped the download
+	Files               []FileResult `json:"files,omitempty"`          // per-object outcomes, with CALLBACK_FILE_RESULTS
+}
+
+// FileResult is the outcome of one requested object
+type FileResult struct {
+	Key        string `json:"key"`
+	Status     string `json:"status"` // success, missing, or error
+	Bytes      int64  `json:"bytes"`  // bytes read from storage
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// DownloadEvent is a download lifecycle event published to the event bus.
+// Type is started, completed, partial, failed, or client_disconnect; the
+// embedded payload matches the callback body, with Status set to the
+// download's outcome (or "started").
+type DownloadEvent struct {
+	Type string `json:"type"`
+	CallbackPayload
+}
+
+// BreakerEvent is a circuit breaker state change published to the event bus,
+// with CIRCUIT_BREAKER_EVENTS. Type is always circuit_breaker.
+type BreakerEvent struct {
+	Type                string `json:"type"`
+	Breaker             string `json:"breaker"`       // storage, storage_<tenant>, or callback
+	Key                 string `json:"key,omitempty"` // bucket of a per-bucket storage breaker
+	From                string `json:"from"`          // closed, open, or half-open
+	To                  string `json:"to"`
 	Timestamp           string `json:"timestamp"`
-	Message             string `json:"message,omitempty"`
-	DurationMs          int64  `json:"duration_ms"`
-	FileCount           int    `json:"file_count"`
-	CompressedSizeBytes int64  `json:"compressed_size_bytes"`
+	Requests            uint32 `json:"requests"`             // requests since the breaker last closed, when it opened from closed
+	TotalFailures       uint32 `json:"total_failures"`       // failures among them
+	ConsecutiveFailures uint32 `json:"consecutive_failures"` // failures in a row that tripped it
+}
+
+// CallbackJob is a callback waiting in the durable callback queue
+type CallbackJob struct {
+	ID          string          `json:"id"`
+	Callback    *Callback       `json:"callback"`
+	Payload     CallbackPayload `json:"payload"`
+	Attempts    int             `json:"attempts"`             // Failed deliveries so far
+	LastError   string          `json:"last_error,omitempty"` // Why the last delivery failed
+	CreatedAt   time.Time       `json:"created_at"`           // When the job was queued, for CALLBACK_MAX_ELAPSED
+	NextAttempt time.Time       `json:"-"`                    // Stored beside the job, so queues can order by it
+}
+
+// AuthorizationRequest is sent to the authorization webhook before streaming
+type AuthorizationRequest struct {
+	ID       string            `json:"id"`
+	ClientIP string            `json:"client_ip"`
+	Query    map[string]string `json:"query,omitempty"`
+}
+
+// APIKey is a key that programmatic clients send in place of a signature
+type APIKey struct {
+	Name      string  // who the key belongs to, for logs and metrics
+	RateLimit float64 // requests/second; 0 = the default rate, negative = unlimited
+}
+
+// IsPlainFileName reports whether name is usable as a top-level archive entry:
+// non-empty, with no path separators, and not "." or ".."
+func IsPlainFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// IsDirectoryPath reports whether name is usable as an archive directory:
+// a relative slash-separated path, optionally ending in a slash, with no
+// empty, ".", or ".." segments and no backslashes
+func IsDirectoryPath(name string) bool {
+	name = strings.TrimSuffix(name, "/")
+	if name == "" || strings.Contains(name, `\`) {
+		return false
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
 }
 
 // ByteCounter wraps an io.Writer and counts bytes written
@@ -2,6 +2,10 @@ package models
 
 import (
 	"bytes"
+	"encoding/json"
+	"io/fs"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -81,3 +85,206 @@ func TestByteCounter_Concurrent(t *testing.T) {
 		t.Errorf("ByteCounter.Count = %d, want %d", bc.Count, expectedCount)
 	}
 }
+
+func TestObjectMetadata_FileMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    fs.FileMode
+		wantErr bool
+	}{
+		{mode: "", want: 0},
+		{mode: "0644", want: 0o644},
+		{mode: "755", want: 0o755},
+		{mode: "0888", wantErr: true},
+		{mode: "01777", wantErr: true},
+		{mode: "rw-r--r--", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			got, err := ObjectMetadata{Mode: tt.mode}.FileMode()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FileMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("FileMode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsDirectoryPath(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "photos", want: true},
+		{name: "photos/2024/", want: true},
+		{name: ""},
+		{name: "/"},
+		{name: "/etc"},
+		{name: "a//b"},
+		{name: "a/../b"},
+		{name: "./a"},
+		{name: `a\b`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsDirectoryPath(tt.name); got != tt.want {
+				t.Errorf("IsDirectoryPath(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  string
+		want    *Callback
+		wantErr bool
+	}{
+		{name: "empty", stored: "", want: nil},
+		{name: "plain URL", stored: "https://example.com/hook", want: &Callback{URL: "https://example.com/hook"}},
+		{
+			name:   "object",
+			stored: `{"url": "https://example.com/hook", "method": "PUT", "headers": {"X-Tenant": "7"}, "bearer_token": "t0k"}`,
+			want:   &Callback{URL: "https://example.com/hook", Method: "PUT", Headers: map[string]string{"X-Tenant": "7"}, BearerToken: "t0k"},
+		},
+		{name: "invalid object", stored: `{"url": `, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCallback(tt.stored)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCallback() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCallback() = %+v, want %+v", got, tt.want)
+			}
+			if !tt.wantErr && tt.stored != "" {
+				// Encoding round-trips through ParseCallback
+				again, err := ParseCallback(got.Encode())
+				if err != nil || !reflect.DeepEqual(again, got) {
+					t.Errorf("ParseCallback(Encode()) = %+v, %v; want %+v", again, err, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCallbacks(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  string
+		want    Callbacks
+		wantErr bool
+	}{
+		{name: "empty", stored: "", want: nil},
+		{name: "plain URL", stored: "https://example.com/hook", want: Callbacks{{URL: "https://example.com/hook"}}},
+		{name: "object", stored: `{"url": "https://example.com/hook", "method": "PUT"}`, want: Callbacks{{URL: "https://example.com/hook", Method: "PUT"}}},
+		{
+			name:   "array",
+			stored: `["https://example.com/a", {"url": "https://example.com/b", "bearer_token": "t0k"}]`,
+			want:   Callbacks{{URL: "https://example.com/a"}, {URL: "https://example.com/b", BearerToken: "t0k"}},
+		},
+		{name: "invalid array", stored: `["https://example.com/a", 7]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCallbacks(tt.stored)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCallbacks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCallbacks() = %+v, want %+v", got, tt.want)
+			}
+			if !tt.wantErr {
+				// Encoding round-trips, and a single callback is stored as before
+				again, err := ParseCallbacks(got.Encode())
+				if err != nil || !reflect.DeepEqual(again, got) {
+					t.Errorf("ParseCallbacks(Encode()) = %+v, %v; want %+v", again, err, got)
+				}
+				if len(got) == 1 && got.Encode() != got[0].Encode() {
+					t.Errorf("Encode() = %s, want %s", got.Encode(), got[0].Encode())
+				}
+			}
+		})
+	}
+}
+
+func TestCallbacks_JSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want Callbacks
+	}{
+		{name: "string", json: `"https://example.com/hook"`, want: Callbacks{{URL: "https://example.com/hook"}}},
+		{name: "object", json: `{"url":"https://example.com/hook","method":"PATCH"}`, want: Callbacks{{URL: "https://example.com/hook", Method: "PATCH"}}},
+		{name: "array", json: `["https://example.com/a","https://example.com/b"]`, want: Callbacks{{URL: "https://example.com/a"}, {URL: "https://example.com/b"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Callbacks
+			if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal() = %+v, want %+v", got, tt.want)
+			}
+			data, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(data) != tt.json {
+				t.Errorf("Marshal() = %s, want %s", data, tt.json)
+			}
+		})
+	}
+}
+
+func TestCallbacks_Validate(t *testing.T) {
+	valid := Callbacks{{URL: "https://example.com/a"}, {URL: "https://example.com/b", Method: "PUT"}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+
+	invalid := Callbacks{{URL: "https://example.com/a"}, {URL: "https://example.com/b", Method: "DELETE"}}
+	if err := invalid.Validate(); err == nil || !strings.HasPrefix(err.Error(), "callback 1:") {
+		t.Errorf("Validate() error = %v, want one naming callback 1", err)
+	}
+}
+
+func TestCallback_JSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want Callback
+	}{
+		{name: "string", json: `"https://example.com/hook"`, want: Callback{URL: "https://example.com/hook"}},
+		{name: "object", json: `{"url":"https://example.com/hook","bearer_token":"t0k"}`, want: Callback{URL: "https://example.com/hook", BearerToken: "t0k"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Callback
+			if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal() = %+v, want %+v", got, tt.want)
+			}
+			data, err := json.Marshal(&got)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(data) != tt.json {
+				t.Errorf("Marshal() = %s, want %s", data, tt.json)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Package retry paces the retries of storage fetches and callbacks:
+// exponential backoff with jitter, capped at a max delay, and a budget of
+// retries per request, so an outage isn't met with a storm of retries from
+// every download in flight.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+type budgetKey struct{}
+
+// ErrDeadline is returned by Wait when ctx's deadline leaves no time for
+// the retry
+var ErrDeadline = errors.New("not enough time left before the deadline to retry")
+
+// Backoff returns the delay before retry number attempt (from 1): base
+// doubled for each earlier retry, with up to 25% jitter either way so
+// requests that failed together don't all retry at the same moment, and
+// never more than max (0 = no cap)
+func Backoff(base, max time.Duration, attempt int) time.Duration {
+	if base <= 0 || attempt < 1 {
+		return 0
+	}
+	d := base
+	for i := 1; i < attempt && (max <= 0 || d < max) && d <= 1<<61; i++ {
+		d *= 2
+	}
+
+	low, high := d-d/4, d+d/4
+	if max > 0 && high > max {
+		high = max
+		low = min(low, max-max/4)
+	}
+	return low + time.Duration(rand.Int64N(int64(high-low)+1))
+}
+
+// WithBudget returns ctx with a budget of n retries, shared by everything
+// that retries under it, e.g. every fetch of one archive. n <= 0 returns
+// ctx without a budget, so retries are only limited per attempt.
+func WithBudget(ctx context.Context, n int) context.Context {
+	if n <= 0 {
+		return ctx
+	}
+	budget := &atomic.Int64{}
+	budget.Store(int64(n))
+	return context.WithValue(ctx, budgetKey{}, budget)
+}
+
+// Spend takes a retry from ctx's budget, and reports whether there was one
+// left; without a budget it always succeeds
+func Spend(ctx context.Context) bool {
+	budget, ok := ctx.Value(budgetKey{}).(*atomic.Int64)
+	return !ok || budget.Add(-1) >= 0
+}
+
+// Wait waits for d before a retry that takes about need, e.g. as long as
+// the attempt before it. If ctx's deadline would pass before the retry
+// could finish, it returns ErrDeadline at once rather than sleep for an
+// attempt that can't succeed; if ctx ends while waiting, its error.
+func Wait(ctx context.Context, d, need time.Duration) error {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < max(d, 0)+max(need, 0) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return ErrDeadline
+	}
+	return Sleep(ctx, d)
+}
+
+// Sleep waits for d, or returns ctx's error if it ends first
+func Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,105 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		max     time.Duration
+		attempt int
+		low     time.Duration
+		high    time.Duration
+	}{
+		{name: "first retry", attempt: 1, low: 750 * time.Millisecond, high: 1250 * time.Millisecond},
+		{name: "second retry", attempt: 2, low: 1500 * time.Millisecond, high: 2500 * time.Millisecond},
+		{name: "fourth retry", attempt: 4, low: 6 * time.Second, high: 10 * time.Second},
+		{name: "capped", max: 5 * time.Second, attempt: 4, low: 3750 * time.Millisecond, high: 5 * time.Second},
+		{name: "cap above the delay", max: time.Minute, attempt: 2, low: 1500 * time.Millisecond, high: 2500 * time.Millisecond},
+		{name: "cap within the jitter", max: 1100 * time.Millisecond, attempt: 1, low: 750 * time.Millisecond, high: 1100 * time.Millisecond},
+		{name: "many retries capped", max: time.Hour, attempt: 200, low: 45 * time.Minute, high: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 50; i++ {
+				got := Backoff(time.Second, tt.max, tt.attempt)
+				if got < tt.low || got > tt.high {
+					t.Fatalf("Backoff(1s, %v, %d) = %v, want within [%v, %v]", tt.max, tt.attempt, got, tt.low, tt.high)
+				}
+			}
+		})
+	}
+
+	if got := Backoff(0, time.Minute, 3); got != 0 {
+		t.Errorf("Backoff(0, 1m, 3) = %v, want 0", got)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	ctx := WithBudget(context.Background(), 2)
+	for i, want := range []bool{true, true, false, false} {
+		if got := Spend(ctx); got != want {
+			t.Errorf("Spend() #%d = %v, want %v", i+1, got, want)
+		}
+	}
+
+	// Without a budget, retries are only limited per attempt
+	unlimited := WithBudget(context.Background(), 0)
+	for i := 0; i < 10; i++ {
+		if !Spend(unlimited) {
+			t.Fatalf("Spend() without a budget = false")
+		}
+	}
+}
+
+func TestSleep(t *testing.T) {
+	if err := Sleep(context.Background(), time.Millisecond); err != nil {
+		t.Errorf("Sleep() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if err := Sleep(ctx, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Errorf("Sleep() on a cancelled context error = %v, want %v", err, context.Canceled)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Sleep() on a cancelled context waited")
+	}
+}
+
+func TestWait(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		delay   time.Duration
+		need    time.Duration
+		wantErr error
+	}{
+		{name: "no deadline", ctx: context.Background(), delay: time.Millisecond, need: time.Hour},
+		{name: "fits", ctx: ctx, delay: time.Millisecond, need: 100 * time.Millisecond},
+		{name: "delay past the deadline", ctx: ctx, delay: time.Minute, wantErr: ErrDeadline},
+		{name: "attempt past the deadline", ctx: ctx, delay: time.Millisecond, need: 5 * time.Second, wantErr: ErrDeadline},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			err := Wait(tt.ctx, tt.delay, tt.need)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Wait() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil && time.Since(start) > 100*time.Millisecond {
+				t.Error("Wait() slept before a retry that can't finish")
+			}
+		})
+	}
+}
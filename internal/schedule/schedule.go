@@ -0,0 +1,247 @@
+package schedule
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window is a recurring weekly time range, e.g. "Mon-Fri 08:00-18:00".
+// Windows whose end is before their start wrap past midnight.
+type Window struct {
+	days  [7]bool // indexed by time.Weekday
+	start int     // minutes since midnight
+	end   int     // minutes since midnight
+}
+
+// Schedule decides whether downloads are allowed at a given time
+type Schedule struct {
+	access      []Window // if set, downloads are only allowed inside these
+	maintenance []Window // downloads are never allowed inside these
+	location    *time.Location
+}
+
+// maxLookahead bounds the search for the next open time
+const maxLookahead = 7 * 24 * time.Hour
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// New builds a schedule from window specs and an IANA timezone name.
+// Returns nil if no windows are configured (always open).
+func New(access, maintenance []string, timezone string) (*Schedule, error) {
+	if len(access) == 0 && len(maintenance) == 0 {
+		return nil, nil
+	}
+
+	loc := time.UTC
+	if timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", timezone, err)
+		}
+	}
+
+	accessWindows, err := ParseWindows(access)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access window: %w", err)
+	}
+	maintenanceWindows, err := ParseWindows(maintenance)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance window: %w", err)
+	}
+
+	return &Schedule{
+		access:      accessWindows,
+		maintenance: maintenanceWindows,
+		location:    loc,
+	}, nil
+}
+
+// ParseWindows parses a list of window specs
+func ParseWindows(specs []string) ([]Window, error) {
+	windows := make([]Window, 0, len(specs))
+	for _, spec := range specs {
+		w, err := ParseWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// ParseWindow parses a single window spec of the form "[DAYS ]HH:MM-HH:MM".
+// DAYS is a weekday ("Sun") or a range ("Mon-Fri"); omitted means every day.
+func ParseWindow(spec string) (Window, error) {
+	var w Window
+
+	fields := strings.Fields(spec)
+	var daySpec, timeSpec string
+	switch len(fields) {
+	case 1:
+		timeSpec = fields[0]
+	case 2:
+		daySpec, timeSpec = fields[0], fields[1]
+	default:
+		return w, fmt.Errorf("%q: expected \"[DAYS ]HH:MM-HH:MM\"", spec)
+	}
+
+	if daySpec == "" {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	} else {
+		first, last, found := strings.Cut(daySpec, "-")
+		from, ok := weekdays[strings.ToLower(first)]
+		if !ok {
+			return w, fmt.Errorf("%q: unknown day %q", spec, first)
+		}
+		to := from
+		if found {
+			if to, ok = weekdays[strings.ToLower(last)]; !ok {
+				return w, fmt.Errorf("%q: unknown day %q", spec, last)
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+
+	startStr, endStr, found := strings.Cut(timeSpec, "-")
+	if !found {
+		return w, fmt.Errorf("%q: expected time range HH:MM-HH:MM", spec)
+	}
+	var err error
+	if w.start, err = parseClock(startStr); err != nil {
+		return w, fmt.Errorf("%q: %w", spec, err)
+	}
+	if w.end, err = parseClock(endStr); err != nil {
+		return w, fmt.Errorf("%q: %w", spec, err)
+	}
+
+	return w, nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight ("24:00" is allowed)
+func parseClock(s string) (int, error) {
+	hStr, mStr, found := strings.Cut(s, ":")
+	if !found {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	h, err := strconv.Atoi(hStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	m, err := strconv.Atoi(mStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// Contains reports whether t falls inside the window
+func (w Window) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	prevDay := (day + 6) % 7
+
+	switch {
+	case w.start == w.end:
+		// Whole day
+		return w.days[day]
+	case w.start < w.end:
+		return w.days[day] && minute >= w.start && minute < w.end
+	default:
+		// Wraps past midnight: the tail belongs to the previous day's window
+		return (w.days[day] && minute >= w.start) || (w.days[prevDay] && minute < w.end)
+	}
+}
+
+// InMaintenance reports whether t falls inside a maintenance window
+func (s *Schedule) InMaintenance(t time.Time) bool {
+	if s == nil {
+		return false
+	}
+	t = t.In(s.location)
+	for _, w := range s.maintenance {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether downloads are permitted at t
+func (s *Schedule) Allowed(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+	if s.InMaintenance(t) {
+		return false
+	}
+	if len(s.access) == 0 {
+		return true
+	}
+	t = t.In(s.location)
+	for _, w := range s.access {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextAllowed returns how long until downloads are next permitted after t.
+// Returns 0 if they are allowed now, or if no open time is found within a week.
+func (s *Schedule) NextAllowed(t time.Time) time.Duration {
+	if s.Allowed(t) {
+		return 0
+	}
+	// Whether downloads are allowed only changes where a window starts or
+	// ends, or at midnight, so only those times need checking
+	local := t.In(s.location)
+	bounds := s.boundaries()
+	for day := 0; day <= 7; day++ {
+		for _, minute := range bounds {
+			next := time.Date(local.Year(), local.Month(), local.Day()+day, minute/60, minute%60, 0, 0, s.location)
+			if !next.After(t) {
+				continue
+			}
+			if next.Sub(t) > maxLookahead {
+				return 0
+			}
+			if s.Allowed(next) {
+				return next.Sub(t)
+			}
+		}
+	}
+	return 0
+}
+
+// boundaries returns the minutes of the day at which a window starts or
+// ends, and midnight, in order
+func (s *Schedule) boundaries() []int {
+	bounds := []int{0}
+	for _, w := range slices.Concat(s.access, s.maintenance) {
+		bounds = append(bounds, w.start%(24*60), w.end%(24*60))
+	}
+	slices.Sort(bounds)
+	return slices.Compact(bounds)
+}
@@ -0,0 +1,188 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+// Monday 2024-06-03 is used as the reference week in these tests
+func at(day, hour, minute int) time.Time {
+	return time.Date(2024, 6, 3+day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		wantErr bool
+	}{
+		{name: "time only", spec: "08:00-18:00"},
+		{name: "single day", spec: "Sun 02:00-04:00"},
+		{name: "day range", spec: "Mon-Fri 09:30-17:30"},
+		{name: "lowercase days", spec: "sat-sun 00:00-24:00"},
+		{name: "overnight", spec: "22:00-06:00"},
+		{name: "unknown day", spec: "Funday 08:00-18:00", wantErr: true},
+		{name: "missing range", spec: "Mon 08:00", wantErr: true},
+		{name: "bad hour", spec: "25:00-26:00", wantErr: true},
+		{name: "bad minute", spec: "08:60-09:00", wantErr: true},
+		{name: "too many fields", spec: "Mon 08:00 - 09:00", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseWindow(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseWindow(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWindow_Contains(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		at   time.Time
+		want bool
+	}{
+		{name: "inside weekday window", spec: "Mon-Fri 08:00-18:00", at: at(0, 12, 0), want: true},
+		{name: "start is inclusive", spec: "Mon-Fri 08:00-18:00", at: at(0, 8, 0), want: true},
+		{name: "end is exclusive", spec: "Mon-Fri 08:00-18:00", at: at(0, 18, 0), want: false},
+		{name: "weekend excluded", spec: "Mon-Fri 08:00-18:00", at: at(5, 12, 0), want: false},
+		{name: "wrapping day range", spec: "Fri-Mon 08:00-18:00", at: at(6, 12, 0), want: true},
+		{name: "overnight before midnight", spec: "Fri 22:00-02:00", at: at(4, 23, 0), want: true},
+		{name: "overnight after midnight", spec: "Fri 22:00-02:00", at: at(5, 1, 0), want: true},
+		{name: "overnight wrong day", spec: "Fri 22:00-02:00", at: at(4, 1, 0), want: false},
+		{name: "whole day", spec: "Sun 00:00-00:00", at: at(6, 15, 0), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := ParseWindow(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseWindow(%q) error = %v", tt.spec, err)
+			}
+			if got := w.Contains(tt.at); got != tt.want {
+				t.Errorf("Contains(%v) = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew_NoWindows(t *testing.T) {
+	s, err := New(nil, nil, "")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if s != nil {
+		t.Fatalf("expected nil schedule when no windows configured")
+	}
+	if !s.Allowed(time.Now()) {
+		t.Errorf("nil schedule should always allow downloads")
+	}
+}
+
+func TestNew_InvalidInput(t *testing.T) {
+	if _, err := New([]string{"08:00-18:00"}, nil, "Not/AZone"); err == nil {
+		t.Errorf("expected error for invalid timezone")
+	}
+	if _, err := New([]string{"whenever"}, nil, ""); err == nil {
+		t.Errorf("expected error for invalid access window")
+	}
+	if _, err := New(nil, []string{"Sun"}, ""); err == nil {
+		t.Errorf("expected error for invalid maintenance window")
+	}
+}
+
+func TestSchedule_Allowed(t *testing.T) {
+	s, err := New([]string{"Mon-Fri 08:00-18:00"}, []string{"Wed 12:00-13:00"}, "")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		at              time.Time
+		wantAllowed     bool
+		wantMaintenance bool
+		wantRetryAfter  time.Duration
+	}{
+		{name: "inside access window", at: at(0, 9, 0), wantAllowed: true},
+		{name: "before access window", at: at(0, 7, 30), wantRetryAfter: 30 * time.Minute},
+		{name: "during maintenance", at: at(2, 12, 15), wantMaintenance: true, wantRetryAfter: 45 * time.Minute},
+		{name: "friday evening waits for monday", at: at(4, 18, 0), wantRetryAfter: 62 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.Allowed(tt.at); got != tt.wantAllowed {
+				t.Errorf("Allowed() = %v, want %v", got, tt.wantAllowed)
+			}
+			if got := s.InMaintenance(tt.at); got != tt.wantMaintenance {
+				t.Errorf("InMaintenance() = %v, want %v", got, tt.wantMaintenance)
+			}
+			if got := s.NextAllowed(tt.at); got != tt.wantRetryAfter {
+				t.Errorf("NextAllowed() = %v, want %v", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestSchedule_Timezone(t *testing.T) {
+	s, err := New([]string{"09:00-17:00"}, nil, "America/New_York")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+
+	// 14:00 UTC is 10:00 in New York (EDT)
+	if !s.Allowed(at(0, 14, 0)) {
+		t.Errorf("expected 14:00 UTC to be inside the New York window")
+	}
+	// 10:00 UTC is 06:00 in New York
+	if s.Allowed(at(0, 10, 0)) {
+		t.Errorf("expected 10:00 UTC to be outside the New York window")
+	}
+}
+
+func TestSchedule_NextAllowed(t *testing.T) {
+	// Stepping minute by minute finds the same next open time as jumping to
+	// window boundaries, just slower
+	stepped := func(s *Schedule, t time.Time) time.Duration {
+		if s.Allowed(t) {
+			return 0
+		}
+		next := t.Truncate(time.Minute)
+		for next.Sub(t) < maxLookahead {
+			next = next.Add(time.Minute)
+			if s.Allowed(next) {
+				return next.Sub(t)
+			}
+		}
+		return 0
+	}
+
+	schedules := []struct {
+		access, maintenance []string
+	}{
+		{access: []string{"Mon-Fri 08:00-18:00"}, maintenance: []string{"Wed 12:00-13:00"}},
+		{access: []string{"Sat 22:00-02:00"}},
+		{access: []string{"Tue 00:00-00:00"}},
+		{maintenance: []string{"01:00-05:30", "Sun 00:00-24:00"}},
+		{access: []string{"Fri-Mon 09:15-09:45", "Wed 23:59-00:01"}, maintenance: []string{"Mon 09:30-10:00"}},
+		{access: []string{"Mon 09:00-10:00"}, maintenance: []string{"Mon 08:00-11:00"}},
+	}
+	for _, sc := range schedules {
+		s, err := New(sc.access, sc.maintenance, "")
+		if err != nil {
+			t.Fatalf("New(%v, %v) error = %v", sc.access, sc.maintenance, err)
+		}
+		for day := range 7 {
+			for minute := 0; minute < 24*60; minute += 37 {
+				at := at(day, minute/60, minute%60).Add(13 * time.Second)
+				if got, want := s.NextAllowed(at), stepped(s, at); got != want {
+					t.Errorf("%v / %v: NextAllowed(%v) = %v, want %v", sc.access, sc.maintenance, at, got, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// secretsManagerAPI is the part of the Secrets Manager client AWSSource uses
+type secretsManagerAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// AWSSource reads a secret from AWS Secrets Manager
+type AWSSource struct {
+	client   secretsManagerAPI
+	secretID string
+}
+
+// NewAWS creates a source reading secretID (a name or ARN), with
+// credentials and region from the standard AWS environment (AWS_REGION,
+// AWS_ACCESS_KEY_ID, an instance or task role, ...)
+func NewAWS(ctx context.Context, secretID string) (*AWSSource, error) {
+	awsCfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &AWSSource{
+		client:   secretsmanager.NewFromConfig(awsCfg),
+		secretID: secretID,
+	}, nil
+}
+
+// Fetch reads the current version of the secret, which must be a JSON
+// object stored as the secret string
+func (a *AWSSource) Fetch(ctx context.Context) (map[string]string, error) {
+	out, err := a.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(a.secretID)})
+	if err != nil {
+		return nil, fmt.Errorf("secrets manager error: %w", err)
+	}
+	if out.SecretString == nil {
+		return nil, errors.New("secret has no string value; binary secrets are not supported")
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(*out.SecretString), &fields); err != nil {
+		return nil, fmt.Errorf("secret is not a JSON object: %w", err)
+	}
+	return decodeValues(fields)
+}
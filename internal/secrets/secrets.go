@@ -0,0 +1,128 @@
+// Package secrets fetches settings from an external secrets manager
+// (HashiCorp Vault or AWS Secrets Manager), for platforms where secrets
+// can't be passed in environment variables.
+//
+// A secret is a JSON object of setting names to values, e.g.
+// {"SIGNING_SECRET": "...", "DB_URL": "..."}. The values are exported into
+// the environment before the configuration is loaded, so any setting can
+// come from the manager.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/config"
+)
+
+// Source fetches the current secret values
+type Source interface {
+	Fetch(ctx context.Context) (map[string]string, error)
+}
+
+// New creates the source cfg configures, or returns nil for
+// SECRETS_PROVIDER=none
+func New(ctx context.Context, cfg *config.SecretsConfig) (Source, error) {
+	switch cfg.Provider {
+	case "none":
+		return nil, nil
+	case "vault":
+		return NewVault(cfg.VaultAddr, cfg.VaultToken, cfg.Path), nil
+	case "aws":
+		return NewAWS(ctx, cfg.Path)
+	default:
+		return nil, fmt.Errorf("unsupported secrets provider: %s", cfg.Provider)
+	}
+}
+
+// Export sets an environment variable for each value, overriding any set
+// by the environment or config file
+func Export(values map[string]string) error {
+	for name, value := range values {
+		if err := os.Setenv(name, value); err != nil {
+			return fmt.Errorf("failed to export %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Watch fetches secrets from src every interval until ctx is done. When
+// any value differs from the last fetch (starting from current), the new
+// values are exported (and removed names unset) and onChange is called with the names that changed.
+// A failed fetch is logged and the previous values are kept.
+func Watch(ctx context.Context, src Source, interval time.Duration, current map[string]string, logger *zap.Logger, onChange func(changed []string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		values, err := src.Fetch(ctx)
+		if err != nil {
+			logger.Error("failed to refresh secrets", zap.Error(err))
+			continue
+		}
+		changed := changedNames(current, values)
+		if len(changed) == 0 {
+			continue
+		}
+		if err := Export(values); err != nil {
+			logger.Error("failed to refresh secrets", zap.Error(err))
+			continue
+		}
+		for name := range current {
+			if _, ok := values[name]; !ok {
+				os.Unsetenv(name)
+			}
+		}
+		current = values
+		logger.Info("secrets changed", zap.Strings("names", changed))
+		onChange(changed)
+	}
+}
+
+// changedNames returns the sorted names whose values differ between old and
+// new, including names only one of them has
+func changedNames(old, new map[string]string) []string {
+	var changed []string
+	for name, value := range new {
+		if prev, ok := old[name]; !ok || prev != value {
+			changed = append(changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// decodeValues parses a secret's JSON object. Values that aren't strings
+// are kept as their JSON text, so {"MAX_FILE_SIZE": 1024} works too.
+func decodeValues(fields map[string]json.RawMessage) (map[string]string, error) {
+	values := make(map[string]string, len(fields))
+	for name, raw := range fields {
+		if string(raw) == "null" {
+			return nil, fmt.Errorf("secret %s is null", name)
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			values[name] = s
+			continue
+		}
+		values[name] = string(raw)
+	}
+	return values, nil
+}
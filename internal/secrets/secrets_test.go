@@ -0,0 +1,193 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/config"
+)
+
+func TestNew(t *testing.T) {
+	src, err := New(context.Background(), &config.SecretsConfig{Provider: "none"})
+	if err != nil || src != nil {
+		t.Errorf("New(none) = %v, %v; want nil, nil", src, err)
+	}
+	if _, err := New(context.Background(), &config.SecretsConfig{Provider: "keychain"}); err == nil {
+		t.Error("New() error = nil, want an error for an unknown provider")
+	}
+}
+
+func TestVaultSource_Fetch(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "kv version 2",
+			status: http.StatusOK,
+			body:   `{"data": {"data": {"SIGNING_SECRET": "s3cret", "MAX_FILE_SIZE": 1024}, "metadata": {"version": 3}}}`,
+			want:   map[string]string{"SIGNING_SECRET": "s3cret", "MAX_FILE_SIZE": "1024"},
+		},
+		{
+			name:   "kv version 1",
+			status: http.StatusOK,
+			body:   `{"data": {"SIGNING_SECRET": "s3cret"}}`,
+			want:   map[string]string{"SIGNING_SECRET": "s3cret"},
+		},
+		{name: "forbidden", status: http.StatusForbidden, body: `{"errors": ["permission denied"]}`, wantErr: true},
+		{name: "invalid json", status: http.StatusOK, body: `nope`, wantErr: true},
+		{name: "null value", status: http.StatusOK, body: `{"data": {"DB_URL": null}}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/secret/data/zipperfly" {
+					t.Errorf("path = %q, want /v1/secret/data/zipperfly", r.URL.Path)
+				}
+				if got := r.Header.Get("X-Vault-Token"); got != "t0ken" {
+					t.Errorf("X-Vault-Token = %q, want t0ken", got)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			got, err := NewVault(server.URL+"/", "t0ken", "/secret/data/zipperfly").Fetch(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Fetch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Fetch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeSecretsManager returns a fixed secret string
+type fakeSecretsManager struct {
+	secret *string
+	err    error
+}
+
+func (f *fakeSecretsManager) GetSecretValue(_ context.Context, params *secretsmanager.GetSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &secretsmanager.GetSecretValueOutput{Name: params.SecretId, SecretString: f.secret}, nil
+}
+
+func TestAWSSource_Fetch(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  *fakeSecretsManager
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "json object",
+			client: &fakeSecretsManager{secret: aws.String(`{"DB_URL": "postgres://db/zipperfly", "ENFORCE_SIGNING": true}`)},
+			want:   map[string]string{"DB_URL": "postgres://db/zipperfly", "ENFORCE_SIGNING": "true"},
+		},
+		{name: "binary secret", client: &fakeSecretsManager{}, wantErr: true},
+		{name: "plain string", client: &fakeSecretsManager{secret: aws.String("s3cret")}, wantErr: true},
+		{name: "api error", client: &fakeSecretsManager{err: errors.New("AccessDeniedException")}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &AWSSource{client: tt.client, secretID: "prod/zipperfly"}
+			got, err := src.Fetch(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Fetch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Fetch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// sequenceSource returns each of its results in turn, repeating the last
+type sequenceSource struct {
+	mu      sync.Mutex
+	results []map[string]string
+}
+
+func (s *sequenceSource) Fetch(context.Context) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := s.results[0]
+	if len(s.results) > 1 {
+		s.results = s.results[1:]
+	}
+	if values == nil {
+		return nil, errors.New("vault is sealed")
+	}
+	return values, nil
+}
+
+func TestWatch(t *testing.T) {
+	t.Setenv("ZIPPERFLY_TEST_A", "1")
+	t.Setenv("ZIPPERFLY_TEST_B", "old")
+	t.Setenv("ZIPPERFLY_TEST_C", "gone")
+	current := map[string]string{"ZIPPERFLY_TEST_A": "1", "ZIPPERFLY_TEST_B": "old", "ZIPPERFLY_TEST_C": "gone"}
+	src := &sequenceSource{results: []map[string]string{
+		current,
+		nil, // a failed fetch keeps the current values
+		{"ZIPPERFLY_TEST_A": "1", "ZIPPERFLY_TEST_B": "new"},
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan []string, 1)
+	go Watch(ctx, src, time.Millisecond, current, zap.NewNop(), func(changed []string) {
+		changes <- changed
+		cancel()
+	})
+
+	select {
+	case changed := <-changes:
+		if want := []string{"ZIPPERFLY_TEST_B", "ZIPPERFLY_TEST_C"}; !reflect.DeepEqual(changed, want) {
+			t.Errorf("changed = %v, want %v", changed, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("onChange was not called")
+	}
+	if got := os.Getenv("ZIPPERFLY_TEST_B"); got != "new" {
+		t.Errorf("ZIPPERFLY_TEST_B = %q, want new", got)
+	}
+	if _, ok := os.LookupEnv("ZIPPERFLY_TEST_C"); ok {
+		t.Error("ZIPPERFLY_TEST_C is still set, want it unset")
+	}
+}
+
+func TestDecodeValues(t *testing.T) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(`{"A": "x", "B": 2, "C": false, "D": ["y"]}`), &fields); err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeValues(fields)
+	if err != nil {
+		t.Fatalf("decodeValues() error = %v", err)
+	}
+	want := map[string]string{"A": "x", "B": "2", "C": "false", "D": `["y"]`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeValues() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,69 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// vaultTimeout bounds each read from Vault
+const vaultTimeout = 10 * time.Second
+
+// VaultSource reads a secret from a Vault KV secrets engine, version 1 or 2
+type VaultSource struct {
+	client *http.Client
+	url    string
+	token  string
+}
+
+// NewVault creates a source reading path (e.g. "secret/data/zipperfly" for
+// KV version 2) from the Vault server at addr, authenticating with token
+func NewVault(addr, token, path string) *VaultSource {
+	return &VaultSource{
+		client: &http.Client{Timeout: vaultTimeout},
+		url:    strings.TrimRight(addr, "/") + "/v1/" + strings.TrimLeft(path, "/"),
+		token:  token,
+	}
+}
+
+// Fetch reads the secret's key/value pairs
+func (v *VaultSource) Fetch(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("vault request error: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault request error: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("vault returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("invalid vault response: %w", err)
+	}
+
+	// KV version 2 nests the values under data.data, next to data.metadata
+	fields := secret.Data
+	if nested, ok := fields["data"]; ok {
+		if _, ok := fields["metadata"]; ok {
+			fields = nil
+			if err := json.Unmarshal(nested, &fields); err != nil {
+				return nil, fmt.Errorf("invalid vault response: %w", err)
+			}
+		}
+	}
+	return decodeValues(fields)
+}
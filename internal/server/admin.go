@@ -0,0 +1,42 @@
+package server
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+
+	"zipperfly/internal/config"
+	"zipperfly/internal/handlers"
+)
+
+// newAdminHandler returns the routes of the admin listener: the pprof
+// profiles, expvar's /debug/vars, a goroutine dump, and the active downloads
+// with a way to cancel them, behind basic auth when ADMIN_USERNAME and
+// ADMIN_PASSWORD are set. The active downloads, which expose client
+// addresses and can cut downloads off, are only served with credentials.
+// They get their own mux, never http.DefaultServeMux, so nothing else can
+// add to them.
+func newAdminHandler(cfg *config.Config, downloadHandler *handlers.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/goroutines", goroutineDump)
+
+	if cfg.AdminUsername != "" && cfg.AdminPassword != "" {
+		mux.HandleFunc("GET /admin/downloads", downloadHandler.ActiveDownloads)
+		mux.HandleFunc("DELETE /admin/downloads/{request_id}", downloadHandler.CancelDownload)
+		return handlers.BasicAuth(cfg.AdminUsername, cfg.AdminPassword)(mux)
+	}
+	return mux
+}
+
+// goroutineDump writes the stack of every goroutine, as a crash would
+func goroutineDump(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"zipperfly/internal/config"
+	"zipperfly/internal/handlers"
+)
+
+func TestNewAdminHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        config.Config
+		path       string
+		setAuth    bool
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "vars", path: "/debug/vars", wantStatus: http.StatusOK, wantBody: "memstats"},
+		{name: "goroutines", path: "/debug/goroutines", wantStatus: http.StatusOK, wantBody: "goroutine "},
+		{name: "pprof index", path: "/debug/pprof/", wantStatus: http.StatusOK, wantBody: "heap"},
+		{name: "heap profile", path: "/debug/pprof/heap?debug=1", wantStatus: http.StatusOK, wantBody: "heap profile"},
+		{name: "unknown path", path: "/metrics", wantStatus: http.StatusNotFound},
+		{name: "active downloads without ADMIN_USERNAME", path: "/admin/downloads", wantStatus: http.StatusNotFound},
+		{
+			name:       "without credentials",
+			cfg:        config.Config{AdminUsername: "ops", AdminPassword: "s3cret"},
+			path:       "/debug/vars",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "with credentials",
+			cfg:        config.Config{AdminUsername: "ops", AdminPassword: "s3cret"},
+			path:       "/debug/vars",
+			setAuth:    true,
+			wantStatus: http.StatusOK,
+			wantBody:   "memstats",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.setAuth {
+				req.SetBasicAuth("ops", "s3cret")
+			}
+			w := httptest.NewRecorder()
+			newAdminHandler(&tt.cfg, &handlers.Handler{}).ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body doesn't contain %q: %.200s", tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestNew_Admin(t *testing.T) {
+	if s := newTestServer(t, &config.Config{Port: "0"}); s.admin != nil {
+		t.Error("admin listener created without ADMIN_PORT")
+	}
+
+	s := newTestServer(t, &config.Config{Port: "0", AdminPort: "6060"})
+	if s.admin == nil || s.admin.Addr != ":6060" {
+		t.Fatalf("admin listener = %+v, want one on :6060", s.admin)
+	}
+
+	// The public listener doesn't serve the debug endpoints
+	w := httptest.NewRecorder()
+	s.srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	if w.Code == http.StatusOK {
+		t.Errorf("public listener served /debug/vars")
+	}
+}
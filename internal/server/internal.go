@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net/http"
+
+	"zipperfly/internal/config"
+	"zipperfly/internal/handlers"
+	"zipperfly/internal/metrics"
+)
+
+// newInternalHandler returns the routes of the internal listener: /metrics,
+// behind basic auth when METRICS_USERNAME and METRICS_PASSWORD are set, and
+// the health checks, which probes reach without credentials. admin, if not
+// nil, serves every other path, when the admin listener shares the port.
+func newInternalHandler(cfg *config.Config, m *metrics.Metrics, healthHandler *handlers.HealthHandler, admin http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler(cfg, m))
+	mux.HandleFunc("GET /livez", healthHandler.Live)
+	mux.HandleFunc("GET /readyz", healthHandler.Ready)
+	mux.HandleFunc("GET /health", healthHandler.Health)
+	if admin != nil {
+		mux.Handle("/", admin)
+	}
+	return mux
+}
+
+// metricsHandler returns the Prometheus endpoint, behind basic auth when
+// METRICS_USERNAME and METRICS_PASSWORD are set
+func metricsHandler(cfg *config.Config, m *metrics.Metrics) http.Handler {
+	if cfg.MetricsUsername != "" && cfg.MetricsPassword != "" {
+		return handlers.BasicAuth(cfg.MetricsUsername, cfg.MetricsPassword)(m.Handler())
+	}
+	return m.Handler()
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// systemdListenFDsStart is the first file descriptor systemd passes with
+// socket activation, after stdin, stdout, and stderr
+const systemdListenFDsStart = 3
+
+// listen returns the public listener: the socket systemd passed, if started
+// by socket activation, else UNIX_SOCKET, else TCP on addr
+func (s *Server) listen(addr string) (net.Listener, error) {
+	if ln, err := systemdListener(s.logger); ln != nil || err != nil {
+		return ln, err
+	}
+	if s.cfg.UnixSocket != "" {
+		return listenUnix(s.cfg.UnixSocket, s.cfg.UnixSocketMode)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// systemdListener returns the first socket systemd passed to this process
+// (LISTEN_PID and LISTEN_FDS, see sd_listen_fds(3)), or nil if it passed
+// none. The variables are unset, so child processes don't take them as
+// their own.
+func systemdListener(logger *zap.Logger) (net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if pid == "" || fds == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q from systemd", fds)
+	}
+	if n > 1 {
+		logger.Warn("systemd passed several sockets, only the first is used", zap.Int("sockets", n))
+	}
+
+	f := os.NewFile(systemdListenFDsStart, "systemd socket")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use the socket systemd passed: %w", err)
+	}
+	return ln, nil
+}
+
+// listenUnix listens on a unix socket at path with permissions mode,
+// replacing the socket a previous run left behind. A socket still in use,
+// or anything else at path, is left alone and an error returned.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode().Type() == fs.ModeSocket {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unix socket %s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// The listener removes the socket file when closed
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/config"
+)
+
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "zipperfly.sock")
+
+	ln, err := listenUnix(path, 0600)
+	if err != nil {
+		t.Fatalf("listenUnix() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %o, want 600", info.Mode().Perm())
+	}
+
+	// A socket in use isn't taken over
+	if _, err := listenUnix(path, 0600); err == nil {
+		t.Error("expected error for a socket in use")
+	}
+
+	// A socket left behind is replaced
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln, err = listenUnix(path, 0660)
+	if err != nil {
+		t.Fatalf("listenUnix() over a stale socket error = %v", err)
+	}
+	ln.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket not removed on close: %v", err)
+	}
+
+	// Other files are left alone
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenUnix(file, 0600); err == nil {
+		t.Error("expected error for a regular file")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("regular file removed: %v", err)
+	}
+}
+
+func TestSystemdListener(t *testing.T) {
+	// Variables meant for another process are ignored
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	if ln, err := systemdListener(zap.NewNop()); ln != nil || err != nil {
+		t.Errorf("systemdListener() for another process = %v, %v", ln, err)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "0")
+	if _, err := systemdListener(zap.NewNop()); err == nil {
+		t.Error("expected error for LISTEN_FDS=0")
+	}
+	if _, ok := os.LookupEnv("LISTEN_PID"); ok {
+		t.Error("LISTEN_PID not unset")
+	}
+}
+
+func TestServer_StartUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zipperfly.sock")
+	s := newTestServer(t, &config.Config{Port: "0", UnixSocket: path, UnixSocketMode: 0660})
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.srv.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		},
+		Timeout: 5 * time.Second,
+	}
+	resp, err := client.Get("http://zipperfly/livez")
+	if err != nil {
+		t.Fatalf("GET /livez over the socket: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Option customizes a Server for programs that embed zipperfly, passed to
+// New after the handlers
+type Option func(*options)
+
+type options struct {
+	router             *mux.Router                       // nil = a new one
+	middleware         []func(http.Handler) http.Handler // around every routed request
+	downloadMiddleware []func(http.Handler) http.Handler // around the signing and download endpoints
+}
+
+// WithRouter registers the routes on r instead of a new router, so the
+// embedding program can serve its own routes on the public listener. Routes
+// it registers on r before New take precedence over zipperfly's; all go
+// through the same middleware.
+func WithRouter(r *mux.Router) Option {
+	return func(o *options) {
+		o.router = r
+	}
+}
+
+// WithMiddleware wraps every routed request in mw, the first outermost,
+// after the request ID and client address are resolved and inside the
+// access log, so requests it rejects are still logged
+func WithMiddleware(mw ...func(http.Handler) http.Handler) Option {
+	return func(o *options) {
+		o.middleware = append(o.middleware, mw...)
+	}
+}
+
+// WithDownloadMiddleware wraps the signing and download endpoints, the
+// default ones and every tenant profile's, in mw, the first outermost. It
+// runs after the client filters, rate limits, API keys, and signing
+// credentials, before any record lookup.
+func WithDownloadMiddleware(mw ...func(http.Handler) http.Handler) Option {
+	return func(o *options) {
+		o.downloadMiddleware = append(o.downloadMiddleware, mw...)
+	}
+}
+
+// chain wraps h in mw, the first outermost
+func chain(h http.Handler, mw []func(http.Handler) http.Handler) http.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
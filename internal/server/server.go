@@ -7,57 +7,293 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
 	"zipperfly/internal/config"
 	"zipperfly/internal/handlers"
 	"zipperfly/internal/metrics"
 )
 
+// cutOffGrace is how long downloads cut off at shutdown get to report
+// their failure before the connections are closed
+const cutOffGrace = 5 * time.Second
+
 // Server wraps the HTTP server
 type Server struct {
-	logger *zap.Logger
-	cfg    *config.Config
-	srv    *http.Server
+	logger    *zap.Logger
+	cfg       *config.Config
+	srv       *http.Server
+	admin     *http.Server      // nil = no admin listener, or one shared with internal
+	internal  *http.Server      // nil = metrics and health checks on the public listener
+	downloads *handlers.Handler // drained on shutdown, with the tenant profiles' that share its downloads
+}
+
+// Tenant is a tenant profile's handlers, served instead of the default ones
+// to requests for its hosts or under its path prefix
+type Tenant struct {
+	Name           string
+	Hosts          []string // empty = any host
+	PathPrefix     string   // "" = none
+	Download       *handlers.Handler
+	Sign           *handlers.SignHandler
+	DownloadTokens *handlers.DownloadTokens // nil = download tokens disabled
 }
 
-// New creates a new server instance
-func New(logger *zap.Logger, cfg *config.Config, m *metrics.Metrics, downloadHandler *handlers.Handler, healthHandler *handlers.HealthHandler) *Server {
-	r := mux.NewRouter()
+// New creates a new server instance. ipFilter and rateLimiter, if not nil,
+// guard the download endpoints, apiKeys, if not nil, lets them
+// authenticate with an API key, downloadTokens, if not nil, enables
+// one-time download tokens, and accessLog, if not nil, gets an entry per
+// request. tenants are matched in order, before the default handlers.
+// opts customize the router and middleware for embedding programs.
+func New(logger *zap.Logger, cfg *config.Config, m *metrics.Metrics, downloadHandler *handlers.Handler, healthHandler *handlers.HealthHandler, signHandler *handlers.SignHandler, rateLimiter *handlers.RateLimiter, ipFilter *handlers.IPFilter, apiKeys *handlers.APIKeyAuth, downloadTokens *handlers.DownloadTokens, accessLog *zap.Logger, tenants []Tenant, opts ...Option) *Server {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	r := o.router
+	if r == nil {
+		r = mux.NewRouter()
+	}
 
 	// Add request ID middleware
 	r.Use(handlers.RequestIDMiddleware)
 
-	// Metrics endpoint with optional basic auth
-	metricsHandler := promhttp.Handler()
-	if cfg.MetricsUsername != "" && cfg.MetricsPassword != "" {
-		authMiddleware := handlers.BasicAuth(cfg.MetricsUsername, cfg.MetricsPassword)
-		r.Handle("/metrics", authMiddleware(metricsHandler))
+	// Resolve the client address, trusting forwarding headers only from
+	// TRUSTED_PROXIES, and reading only CLIENT_IP_HEADER if set
+	r.Use(handlers.ClientIPMiddleware(cfg.TrustedProxies, cfg.ClientIPHeader))
+
+	// Log every routed request (if enabled)
+	if accessLog != nil {
+		r.Use(handlers.AccessLogMiddleware(accessLog, cfg.AccessLogSampleRate))
+	}
+
+	// The embedding program's middleware (if any)
+	for _, mw := range o.middleware {
+		r.Use(mux.MiddlewareFunc(mw))
+	}
+
+	// Metrics endpoint with optional basic auth, and health endpoints:
+	// liveness, readiness, and /health as an alias of readiness. With
+	// INTERNAL_PORT they're served there instead.
+	if cfg.InternalPort == "" {
+		r.Handle("/metrics", metricsHandler(cfg, m))
+		r.HandleFunc("/livez", healthHandler.Live).Methods("GET")
+		r.HandleFunc("/readyz", healthHandler.Ready).Methods("GET")
+		r.HandleFunc("/health", healthHandler.Health).Methods("GET")
+	}
+
+	// Build metadata endpoint
+	r.HandleFunc("/version", handlers.Version).Methods("GET")
+
+	// Tenant profiles, on a subrouter per host, or one for any host under
+	// the path prefix
+	for _, tenant := range tenants {
+		hosts := tenant.Hosts
+		if len(hosts) == 0 {
+			hosts = []string{""}
+		}
+		for _, host := range hosts {
+			route := r.NewRoute()
+			if host != "" {
+				route = route.Host(host)
+			}
+			if tenant.PathPrefix != "" {
+				route = route.PathPrefix(tenant.PathPrefix)
+			}
+			handleDownloads(route.Subrouter(), tenant.PathPrefix, cfg, tenant.Download, tenant.Sign, rateLimiter, ipFilter, apiKeys, tenant.DownloadTokens, o.downloadMiddleware)
+		}
+	}
+
+	handleDownloads(r, "", cfg, downloadHandler, signHandler, rateLimiter, ipFilter, apiKeys, downloadTokens, o.downloadMiddleware)
+
+	// Rewrite error responses as problem JSON or an HTML page (if enabled),
+	// around the router so its 404s are too
+	errorPage := cfg.ErrorPage
+	if errorPage == nil {
+		errorPage = handlers.DefaultErrorPage
+	}
+	handler := handlers.ErrorResponseMiddleware(cfg.ErrorFormat, errorPage)(r)
+
+	// Let browser pages on CORS_ALLOWED_ORIGINS call the API (if enabled),
+	// around the router so it sees preflight requests no route accepts
+	if len(cfg.CORSAllowedOrigins) > 0 {
+		handler = handlers.CORSMiddleware(handlers.CORSOptions{
+			AllowedOrigins:   cfg.CORSAllowedOrigins,
+			AllowedMethods:   cfg.CORSAllowedMethods,
+			AllowedHeaders:   cfg.CORSAllowedHeaders,
+			ExposedHeaders:   cfg.CORSExposedHeaders,
+			AllowCredentials: cfg.CORSAllowCredentials,
+			MaxAge:           cfg.CORSMaxAge,
+		})(handler)
+	}
+
+	// Accept HTTP/2 without TLS (if enabled), with prior knowledge or an
+	// h2c upgrade, from proxies that reach the HTTP listener or unix socket
+	// over it. HTTPS negotiates HTTP/2 itself (ENABLE_HTTP2).
+	if cfg.EnableH2C {
+		handler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: cfg.IdleTimeout})
+	}
+
+	s := &Server{
+		logger:    logger,
+		cfg:       cfg,
+		srv:       newHTTPServer(cfg, handler),
+		downloads: downloadHandler,
+	}
+	if !cfg.EnableHTTP2 {
+		// A non-nil empty map keeps net/http from configuring HTTP/2
+		s.srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+
+	// Profiling and debug endpoints on their own port (if enabled), so they
+	// are never reachable through the public listener
+	var admin http.Handler
+	if cfg.AdminPort != "" {
+		admin = newAdminHandler(cfg, downloadHandler)
+	}
+	if cfg.AdminPort != "" && cfg.AdminPort != cfg.InternalPort {
+		s.admin = newHTTPServer(cfg, admin)
+		s.admin.Addr = ":" + cfg.AdminPort
+	}
+
+	// Metrics and health checks on an internal port (if enabled), shared
+	// with the admin endpoints when ADMIN_PORT is the same
+	if cfg.InternalPort != "" {
+		var shared http.Handler
+		if cfg.AdminPort == cfg.InternalPort {
+			shared = admin
+		}
+		s.internal = newHTTPServer(cfg, newInternalHandler(cfg, m, healthHandler, shared))
+		s.internal.Addr = ":" + cfg.InternalPort
+	}
+
+	return s
+}
+
+// newHTTPServer creates an http.Server for handler with the hardening
+// settings: READ_HEADER_TIMEOUT against clients that trickle their headers,
+// IDLE_TIMEOUT for keep-alive connections, WRITE_TIMEOUT, which the
+// streaming endpoints clear, and MAX_HEADER_BYTES
+func newHTTPServer(cfg *config.Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// handleDownloads registers the signing and download endpoints on r, served
+// by the given handlers, under BASE_PATH. pathPrefix is the one r matches,
+// a tenant profile's, which the legacy layout's redirects keep. mw wraps
+// every endpoint, inside the built-in guards.
+func handleDownloads(r *mux.Router, pathPrefix string, cfg *config.Config, downloadHandler *handlers.Handler, signHandler *handlers.SignHandler, rateLimiter *handlers.RateLimiter, ipFilter *handlers.IPFilter, apiKeys *handlers.APIKeyAuth, downloadTokens *handlers.DownloadTokens, mw []func(http.Handler) http.Handler) {
+	if cfg.BasePath != "" {
+		// The redirects are registered after the endpoints, which take
+		// precedence, so /download/status is record "status" rather than
+		// the status of record "download"
+		if cfg.LegacyRedirects {
+			defer handleLegacyRedirects(r, pathPrefix, cfg.BasePath)
+		}
+		r = r.PathPrefix(cfg.BasePath).Subrouter()
+	}
+
+	// REQUEST_TIMEOUT and WRITE_TIMEOUT bound the endpoints that don't
+	// stream their response; downloads and progress streams run as long as
+	// they make progress, bounded by STALL_TIMEOUT and FILE_FETCH_TIMEOUT
+	// instead
+	timeout := handlers.Timeout(cfg.RequestTimeout)
+
+	// Signed URL endpoint, behind basic auth (404 unless SIGN_USERNAME and
+	// SIGN_PASSWORD are set)
+	if cfg.SignUsername != "" && cfg.SignPassword != "" {
+		authMiddleware := handlers.BasicAuth(cfg.SignUsername, cfg.SignPassword)
+		r.Handle("/sign", authMiddleware(timeout(chain(http.HandlerFunc(signHandler.Sign), mw)))).Methods("POST")
 	} else {
-		r.Handle("/metrics", metricsHandler)
+		r.HandleFunc("/sign", http.NotFound).Methods("POST")
 	}
 
-	// Health endpoint
-	r.HandleFunc("/health", healthHandler.Health).Methods("GET")
+	// Client filters, per-IP and per-record rate limits, then API keys, on
+	// the download endpoints (if enabled), and the embedding program's
+	// middleware, all before any record lookup
+	limit := func(h http.HandlerFunc) http.Handler {
+		handler := chain(h, mw)
+		if apiKeys != nil {
+			handler = apiKeys.Middleware(handler)
+		}
+		if rateLimiter != nil {
+			handler = rateLimiter.Middleware(handler)
+		}
+		if ipFilter != nil {
+			handler = ipFilter.Middleware(handler)
+		}
+		return handler
+	}
 
-	// Download endpoint
-	r.HandleFunc("/{id}", downloadHandler.Download).Methods("GET")
+	// Download endpoint, redeeming one-time download tokens (if enabled)
+	download := downloadHandler.Download
+	if downloadTokens != nil {
+		download = downloadTokens.Middleware(http.HandlerFunc(download)).ServeHTTP
+	}
+	r.Handle("/{id}", handlers.NoWriteDeadline(limit(download))).Methods("GET", "HEAD")
 
-	return &Server{
-		logger: logger,
-		cfg:    cfg,
-		srv:    &http.Server{Handler: r},
+	// Download token endpoint, for signed form POSTs (404 unless
+	// DOWNLOAD_TOKENS is enabled)
+	if downloadTokens != nil {
+		r.Handle("/{id}", timeout(limit(downloadTokens.Issue))).Methods("POST")
+	} else {
+		r.HandleFunc("/{id}", http.NotFound).Methods("POST")
 	}
+
+	// Async build endpoints (404 unless ASYNC_BUILDS is enabled)
+	r.Handle("/{id}/prepare", timeout(limit(downloadHandler.Prepare))).Methods("POST")
+	r.Handle("/{id}/status", timeout(limit(downloadHandler.Status))).Methods("GET")
+
+	// Download progress stream (404 unless PROGRESS_EVENTS is enabled)
+	r.Handle("/{id}/progress", handlers.NoWriteDeadline(limit(downloadHandler.Progress))).Methods("GET")
+
+	// Pre-download check of the record's objects
+	r.Handle("/{id}/validate", timeout(limit(downloadHandler.Validate))).Methods("GET")
+}
+
+// handleLegacyRedirects redirects the endpoints' paths without BASE_PATH to
+// it, keeping the query, so links signed before it was set keep working.
+// 308 keeps the method, for POST /sign and download token forms.
+func handleLegacyRedirects(r *mux.Router, pathPrefix, basePath string) {
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == pathPrefix+basePath {
+			// The base path itself isn't a record
+			http.NotFound(w, req)
+			return
+		}
+		target := *req.URL
+		target.Path = pathPrefix + basePath + strings.TrimPrefix(req.URL.Path, pathPrefix)
+		target.RawPath = ""
+		http.Redirect(w, req, target.RequestURI(), http.StatusPermanentRedirect)
+	})
+	r.Handle("/sign", redirect).Methods("POST")
+	r.Handle("/{id}", redirect).Methods("GET", "HEAD", "POST")
+	r.Handle("/{id}/{endpoint:prepare|status|progress|validate}", redirect)
 }
 
-// Start starts the HTTP server
+// Start starts the HTTP server, on PORT or HTTPS_PORT unless a unix socket
+// or a socket passed by systemd is used instead
 func (s *Server) Start() error {
+	if s.admin != nil {
+		s.startAdmin()
+	}
+	if s.internal != nil {
+		s.startInternal()
+	}
 	if s.cfg.EnableHTTPS {
 		return s.startHTTPS()
 	}
@@ -65,11 +301,14 @@ func (s *Server) Start() error {
 }
 
 func (s *Server) startHTTP() error {
-	s.srv.Addr = ":" + s.cfg.Port
-	s.logger.Info("starting HTTP server", zap.String("addr", s.srv.Addr))
+	ln, err := s.listen(":" + s.cfg.Port)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("starting HTTP server", zap.Stringer("addr", ln.Addr()))
 
 	go func() {
-		if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			s.logger.Fatal("HTTP server error", zap.Error(err))
 		}
 	}()
@@ -77,28 +316,83 @@ func (s *Server) startHTTP() error {
 	return nil
 }
 
-func (s *Server) startHTTPS() error {
-	m := &autocert.Manager{
-		Prompt:     autocert.AcceptTOS,
-		HostPolicy: autocert.HostWhitelist(s.cfg.LetsEncryptDomains...),
-		Cache:      autocert.DirCache(s.cfg.LetsEncryptCacheDir),
-		Email:      s.cfg.LetsEncryptEmail,
+func (s *Server) startAdmin() {
+	if s.cfg.AdminUsername == "" {
+		s.logger.Warn("admin listener has no authentication; keep ADMIN_PORT off public networks")
 	}
+	s.logger.Info("starting admin server", zap.String("addr", s.admin.Addr))
 
-	// HTTP server for ACME challenges and redirects
 	go func() {
-		s.logger.Info("starting HTTP server for challenges/redirects", zap.String("addr", ":80"))
-		if err := http.ListenAndServe(":80", m.HTTPHandler(nil)); err != nil {
-			s.logger.Error("HTTP server error", zap.Error(err))
+		if err := s.admin.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("admin server error", zap.Error(err))
 		}
 	}()
+}
 
-	s.srv.Addr = ":443"
-	s.srv.TLSConfig = &tls.Config{GetCertificate: m.GetCertificate}
-	s.logger.Info("starting HTTPS server", zap.String("addr", s.srv.Addr), zap.Strings("domains", s.cfg.LetsEncryptDomains))
+func (s *Server) startInternal() {
+	if s.cfg.AdminPort == s.cfg.InternalPort && s.cfg.AdminUsername == "" {
+		s.logger.Warn("admin listener has no authentication; keep ADMIN_PORT off public networks")
+	}
+	s.logger.Info("starting internal server", zap.String("addr", s.internal.Addr), zap.Bool("admin", s.cfg.AdminPort == s.cfg.InternalPort))
 
 	go func() {
-		if err := s.srv.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := s.internal.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("internal server error", zap.Error(err))
+		}
+	}()
+}
+
+// startHTTPS starts the HTTPS listener on HTTPS_PORT, or the socket listen
+// picks, with the certificate
+// in TLS_CERT_FILE if set, or else one from Let's Encrypt, whose challenges
+// are answered on port 80
+func (s *Server) startHTTPS() error {
+	tlsCfg := &tls.Config{}
+	if s.cfg.TLSCertFile != "" {
+		certs, err := newCertReloader(s.logger, s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		tlsCfg.GetCertificate = certs.GetCertificate
+	} else {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(s.cfg.LetsEncryptDomains...),
+			Cache:      autocert.DirCache(s.cfg.LetsEncryptCacheDir),
+			Email:      s.cfg.LetsEncryptEmail,
+		}
+		tlsCfg.GetCertificate = m.GetCertificate
+
+		// HTTP server for ACME challenges and redirects
+		challenges := newHTTPServer(s.cfg, m.HTTPHandler(nil))
+		challenges.Addr = ":80"
+		go func() {
+			s.logger.Info("starting HTTP server for challenges/redirects", zap.String("addr", challenges.Addr))
+			if err := challenges.ListenAndServe(); err != nil {
+				s.logger.Error("HTTP server error", zap.Error(err))
+			}
+		}()
+	}
+
+	// Require client certificates (if enabled)
+	if err := applyClientAuth(tlsCfg, s.cfg); err != nil {
+		return err
+	}
+
+	ln, err := s.listen(":" + s.cfg.HTTPSPort)
+	if err != nil {
+		return err
+	}
+	s.srv.TLSConfig = tlsCfg
+	s.logger.Info("starting HTTPS server",
+		zap.Stringer("addr", ln.Addr()),
+		zap.String("cert_file", s.cfg.TLSCertFile),
+		zap.Strings("domains", s.cfg.LetsEncryptDomains),
+		zap.Bool("client_certs", s.cfg.ClientCAFile != ""),
+	)
+
+	go func() {
+		if err := s.srv.ServeTLS(ln, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			s.logger.Fatal("HTTPS server error", zap.Error(err))
 		}
 	}()
@@ -112,12 +406,43 @@ func (s *Server) WaitForShutdown() error {
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 	<-stop
 
-	s.logger.Info("shutting down server...")
+	return s.shutdown()
+}
+
+// shutdown stops the server: new downloads are refused with 503 at once,
+// and those in progress get up to SHUTDOWN_TIMEOUT to finish before they're
+// cut off and their connections closed
+func (s *Server) shutdown() error {
+	s.downloads.Drain()
+	s.logger.Info("shutting down server, draining active downloads",
+		zap.Int("active_downloads", s.downloads.ActiveCount()),
+		zap.Duration("timeout", s.cfg.ShutdownTimeout),
+	)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
 	defer cancel()
 
-	if err := s.srv.Shutdown(ctx); err != nil {
+	if s.admin != nil {
+		// Don't wait for profiles in progress
+		s.admin.Close()
+	}
+	err := s.srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		cutOff := s.downloads.CutOff()
+		s.logger.Warn("drain timeout reached, cutting off active downloads", zap.Int("downloads", cutOff))
+
+		// Let them report their failure, e.g. to callbacks, first
+		deadline := time.Now().Add(cutOffGrace)
+		for s.downloads.ActiveCount() > 0 && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+		err = s.srv.Close()
+	}
+	if s.internal != nil {
+		// Kept up until the downloads are done, for probes and a last scrape
+		s.internal.Close()
+	}
+	if err != nil {
 		return err
 	}
 
@@ -2,33 +2,46 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
+	"golang.org/x/net/http2"
 
+	"zipperfly/internal/auth"
 	"zipperfly/internal/config"
 	"zipperfly/internal/handlers"
 	"zipperfly/internal/metrics"
+	"zipperfly/internal/tokens"
+	"zipperfly/internal/version"
 )
 
 // newTestServer is a small helper to construct a Server with minimal deps.
-func newTestServer(t *testing.T, cfg *config.Config) *Server {
+func newTestServer(t *testing.T, cfg *config.Config, opts ...Option) *Server {
 	t.Helper()
 
 	logger := zap.NewNop()
 	m := metrics.New()
 
-	// Zero-value handlers are fine here because we never actually invoke
-	// their methods in these tests — we just need non-nil pointers for New().
-	downloadHandler := &handlers.Handler{}
+	// A zero-value health handler is fine here because we never actually
+	// invoke its methods in these tests — we just need a non-nil pointer for
+	// New(). The download handler is drained on shutdown.
+	downloadHandler := handlers.NewDownloadHandler(logger, nil, nil, nil, m, handlers.HandlerOptions{})
 	healthHandler := &handlers.HealthHandler{}
+	signHandler := handlers.NewSignHandler(logger, []byte("test-secret"), "", "", time.Hour)
 
-	return New(logger, cfg, m, downloadHandler, healthHandler)
+	return New(logger, cfg, m, downloadHandler, healthHandler, signHandler, nil, nil, nil, nil, nil, nil, opts...)
 }
 
 func TestNew_MetricsWithoutAuth(t *testing.T) {
@@ -52,6 +65,57 @@ func TestNew_MetricsWithoutAuth(t *testing.T) {
 	}
 }
 
+func TestNew_Livez(t *testing.T) {
+	s := newTestServer(t, &config.Config{Port: "0"})
+
+	w := httptest.NewRecorder()
+	s.srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/livez", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 for /livez, got %d", w.Code)
+	}
+}
+
+func TestNew_Version(t *testing.T) {
+	s := newTestServer(t, &config.Config{Port: "0"})
+
+	w := httptest.NewRecorder()
+	s.srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 for /version, got %d", w.Code)
+	}
+	var info version.Info
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatalf("failed to decode /version: %v", err)
+	}
+	if info.Version != version.Version || info.GoVersion == "" {
+		t.Errorf("/version = %+v, want version %q and a Go version", info, version.Version)
+	}
+}
+
+func TestNew_MetricsCustomRegistry(t *testing.T) {
+	logger := zap.NewNop()
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	m.RequestsTotal.WithLabelValues("200").Inc()
+	signHandler := handlers.NewSignHandler(logger, []byte("test-secret"), "", "", time.Hour)
+	s := New(logger, &config.Config{Port: "0"}, m, &handlers.Handler{}, &handlers.HealthHandler{}, signHandler, nil, nil, nil, nil, nil, nil)
+
+	w := httptest.NewRecorder()
+	s.srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `zipperfly_requests_total{status="200"} 1`) {
+		t.Errorf("metrics of the custom registry not served:\n%.500s", w.Body.String())
+	}
+	// Only the custom registry is served, not the default one
+	if strings.Contains(w.Body.String(), "go_goroutines") {
+		t.Error("default registry served alongside the custom one")
+	}
+}
+
 func TestNew_MetricsWithAuth(t *testing.T) {
 	cfg := &config.Config{
 		Port:            "0",
@@ -153,3 +217,428 @@ func TestServer_WaitForShutdown(t *testing.T) {
 		t.Fatal("WaitForShutdown did not return within timeout")
 	}
 }
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	s := newTestServer(t, &config.Config{Port: "0", ShutdownTimeout: 200 * time.Millisecond})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.srv.Serve(ln)
+
+	// A request in progress holds up shutdown until the timeout
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET /livez HTTP/1.1\r\nHost: localhost\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if err := s.shutdown(); err != nil {
+		t.Fatalf("shutdown() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("shutdown() took %s, want about the 200ms timeout", elapsed)
+	}
+
+	// The connection is closed once the timeout runs out
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("connection not closed by the server: %v", err)
+	}
+}
+
+func TestNew_Sign(t *testing.T) {
+	tests := []struct {
+		name       string
+		username   string
+		password   string
+		setAuth    bool
+		wantStatus int
+	}{
+		{name: "disabled", wantStatus: http.StatusNotFound},
+		{name: "without credentials", username: "signer", password: "s3cret", wantStatus: http.StatusUnauthorized},
+		{name: "with credentials", username: "signer", password: "s3cret", setAuth: true, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, &config.Config{Port: "0", SignUsername: tt.username, SignPassword: tt.password})
+
+			req := httptest.NewRequest(http.MethodPost, "/sign", strings.NewReader(`{"id": "123"}`))
+			if tt.setAuth {
+				req.SetBasicAuth("signer", "s3cret")
+			}
+			w := httptest.NewRecorder()
+			s.srv.Handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestNew_DownloadTokens(t *testing.T) {
+	logger := zap.NewNop()
+	m := metrics.New()
+	signHandler := handlers.NewSignHandler(logger, []byte("test-secret"), "", "", time.Hour)
+	verifier := auth.NewVerifier(map[string][]byte{"": []byte("test-secret")}, true, 1, m, nil)
+	downloadTokens := handlers.NewDownloadTokens(logger, m, verifier, tokens.NewMemory(), time.Minute, nil)
+
+	tests := []struct {
+		name           string
+		downloadTokens *handlers.DownloadTokens
+		wantStatus     int
+	}{
+		{name: "disabled", wantStatus: http.StatusNotFound},
+		{name: "enabled", downloadTokens: downloadTokens, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(logger, &config.Config{Port: "0"}, m, &handlers.Handler{}, &handlers.HealthHandler{}, signHandler, nil, nil, nil, tt.downloadTokens, nil, nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/123", strings.NewReader("signature=forged"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			s.srv.Handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestNew_ReadHeaderTimeout(t *testing.T) {
+	s := newTestServer(t, &config.Config{Port: "0", ReadHeaderTimeout: 50 * time.Millisecond})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.srv.Serve(ln)
+	t.Cleanup(func() { s.srv.Close() })
+
+	// A client that never finishes its headers is disconnected
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET /livez HTTP/1.1\r\nHost: localhost\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Errorf("connection not closed by the server: %v", err)
+	}
+}
+
+func TestNew_Tenants(t *testing.T) {
+	logger := zap.NewNop()
+	m := metrics.New()
+	newSign := func(publicURL string) *handlers.SignHandler {
+		return handlers.NewSignHandler(logger, []byte("test-secret"), "", publicURL, time.Hour)
+	}
+	tenants := []Tenant{
+		{Name: "acme", Hosts: []string{"files.acme.com"}, Download: &handlers.Handler{}, Sign: newSign("https://acme.example.com")},
+		{Name: "globex", PathPrefix: "/globex", Download: &handlers.Handler{}, Sign: newSign("https://globex.example.com")},
+	}
+	cfg := &config.Config{Port: "0", SignUsername: "signer", SignPassword: "s3cret"}
+	s := New(logger, cfg, m, &handlers.Handler{}, &handlers.HealthHandler{}, newSign("https://default.example.com"), nil, nil, nil, nil, nil, tenants)
+
+	tests := []struct {
+		name    string
+		url     string
+		wantURL string
+	}{
+		{name: "tenant host", url: "http://files.acme.com/sign", wantURL: "https://acme.example.com/123?"},
+		{name: "tenant host with port", url: "http://files.acme.com:8080/sign", wantURL: "https://acme.example.com/123?"},
+		{name: "tenant path prefix", url: "http://zipperfly.test/globex/sign", wantURL: "https://globex.example.com/123?"},
+		{name: "default", url: "http://zipperfly.test/sign", wantURL: "https://default.example.com/123?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(`{"id": "123"}`))
+			req.SetBasicAuth("signer", "s3cret")
+			w := httptest.NewRecorder()
+			s.srv.Handler.ServeHTTP(w, req)
+
+			var resp struct {
+				URL string `json:"url"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("status %d, failed to decode response: %v", w.Code, err)
+			}
+			if !strings.HasPrefix(resp.URL, tt.wantURL) {
+				t.Errorf("URL = %q, want prefix %q", resp.URL, tt.wantURL)
+			}
+		})
+	}
+
+	// Endpoints outside the download routes aren't shadowed by a tenant
+	req := httptest.NewRequest(http.MethodGet, "http://files.acme.com/livez", nil)
+	w := httptest.NewRecorder()
+	s.srv.Handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("/livez on a tenant host: status = %d, want 200", w.Code)
+	}
+}
+
+func TestNew_BasePath(t *testing.T) {
+	logger := zap.NewNop()
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+	newSign := func() *handlers.SignHandler {
+		return handlers.NewSignHandler(logger, []byte("test-secret"), "", "", time.Hour)
+	}
+	tenants := []Tenant{{Name: "globex", PathPrefix: "/globex", Download: &handlers.Handler{}, Sign: newSign()}}
+
+	tests := []struct {
+		name            string
+		legacyRedirects bool
+		method          string
+		path            string
+		wantStatus      int
+		wantLocation    string
+		wantURL         string
+	}{
+		{name: "sign", legacyRedirects: true, method: "POST", path: "/download/sign", wantStatus: http.StatusOK, wantURL: "http://zipperfly.test/download/123?"},
+		{name: "tenant sign", legacyRedirects: true, method: "POST", path: "/globex/download/sign", wantStatus: http.StatusOK, wantURL: "http://zipperfly.test/globex/download/123?"},
+		{name: "legacy download", legacyRedirects: true, method: "GET", path: "/123?expires=1&signature=abc", wantStatus: http.StatusPermanentRedirect, wantLocation: "/download/123?expires=1&signature=abc"},
+		{name: "legacy tenant download", legacyRedirects: true, method: "GET", path: "/globex/123?expires=1", wantStatus: http.StatusPermanentRedirect, wantLocation: "/globex/download/123?expires=1"},
+		{name: "legacy progress", legacyRedirects: true, method: "GET", path: "/123/progress", wantStatus: http.StatusPermanentRedirect, wantLocation: "/download/123/progress"},
+		{name: "legacy validate", legacyRedirects: true, method: "GET", path: "/123/validate?expires=1", wantStatus: http.StatusPermanentRedirect, wantLocation: "/download/123/validate?expires=1"},
+		{name: "legacy sign", legacyRedirects: true, method: "POST", path: "/sign", wantStatus: http.StatusPermanentRedirect, wantLocation: "/download/sign"},
+		{name: "base path itself", legacyRedirects: true, method: "GET", path: "/download", wantStatus: http.StatusNotFound},
+		{name: "livez", legacyRedirects: true, method: "GET", path: "/livez", wantStatus: http.StatusOK},
+		{name: "metrics", legacyRedirects: true, method: "GET", path: "/metrics", wantStatus: http.StatusOK},
+		{name: "without redirects", method: "GET", path: "/123", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Port: "0", BasePath: "/download", LegacyRedirects: tt.legacyRedirects, SignUsername: "signer", SignPassword: "s3cret"}
+			s := New(logger, cfg, m, &handlers.Handler{}, &handlers.HealthHandler{}, newSign(), nil, nil, nil, nil, nil, tenants)
+
+			req := httptest.NewRequest(tt.method, "http://zipperfly.test"+tt.path, strings.NewReader(`{"id": "123"}`))
+			req.SetBasicAuth("signer", "s3cret")
+			w := httptest.NewRecorder()
+			s.srv.Handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			if tt.wantURL != "" {
+				var resp struct {
+					URL string `json:"url"`
+				}
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if !strings.HasPrefix(resp.URL, tt.wantURL) {
+					t.Errorf("URL = %q, want prefix %q", resp.URL, tt.wantURL)
+				}
+			}
+		})
+	}
+}
+
+func TestNew_Hardening(t *testing.T) {
+	cfg := &config.Config{
+		Port:              "0",
+		AdminPort:         "0",
+		ReadHeaderTimeout: 5 * time.Second,
+		IdleTimeout:       time.Minute,
+		WriteTimeout:      30 * time.Second,
+		MaxHeaderBytes:    16 << 10,
+	}
+	s := newTestServer(t, cfg)
+	for name, srv := range map[string]*http.Server{"public": s.srv, "admin": s.admin} {
+		if srv.ReadHeaderTimeout != cfg.ReadHeaderTimeout || srv.IdleTimeout != cfg.IdleTimeout || srv.WriteTimeout != cfg.WriteTimeout || srv.MaxHeaderBytes != cfg.MaxHeaderBytes {
+			t.Errorf("%s server has ReadHeaderTimeout %s, IdleTimeout %s, WriteTimeout %s, MaxHeaderBytes %d, want the configured ones", name, srv.ReadHeaderTimeout, srv.IdleTimeout, srv.WriteTimeout, srv.MaxHeaderBytes)
+		}
+	}
+	if s.srv.TLSNextProto == nil {
+		t.Error("TLSNextProto = nil with HTTP/2 disabled, want an empty map")
+	}
+
+	cfg.EnableHTTP2 = true
+	if s := newTestServer(t, cfg); s.srv.TLSNextProto != nil {
+		t.Error("TLSNextProto set with HTTP/2 enabled, want nil so net/http configures it")
+	}
+}
+
+func TestNew_CORS(t *testing.T) {
+	cfg := &config.Config{
+		Port:               "0",
+		CORSAllowedOrigins: []string{"https://app.example.com"},
+		CORSAllowedMethods: []string{"GET", "HEAD", "POST"},
+		CORSExposedHeaders: []string{"X-Request-ID"},
+	}
+	s := newTestServer(t, cfg)
+
+	// Preflight requests are answered although no route accepts OPTIONS
+	req := httptest.NewRequest(http.MethodOptions, "/sign", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	s.srv.Handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("preflight: status %d, Access-Control-Allow-Origin %q", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/livez", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	w = httptest.NewRecorder()
+	s.srv.Handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Expose-Headers") != "X-Request-ID" {
+		t.Errorf("GET: status %d, Access-Control-Expose-Headers %q", w.Code, w.Header().Get("Access-Control-Expose-Headers"))
+	}
+
+	// Without allowed origins there are no CORS headers
+	s = newTestServer(t, &config.Config{Port: "0"})
+	w = httptest.NewRecorder()
+	s.srv.Handler.ServeHTTP(w, req)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("CORS disabled: Access-Control-Allow-Origin = %q", got)
+	}
+}
+
+func TestNew_InternalPort(t *testing.T) {
+	// BASE_PATH keeps /{id} from matching the root paths
+	s := newTestServer(t, &config.Config{Port: "0", BasePath: "/download", InternalPort: "9100", AdminPort: "6060"})
+
+	for _, path := range []string{"/metrics", "/livez"} {
+		w := httptest.NewRecorder()
+		s.srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("public %s: status = %d, want 404", path, w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		s.internal.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("internal %s: status = %d, want 200", path, w.Code)
+		}
+	}
+
+	// The admin endpoints stay on their own listener
+	w := httptest.NewRecorder()
+	s.internal.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/downloads", nil))
+	if w.Code != http.StatusNotFound || s.admin == nil {
+		t.Errorf("internal /admin/downloads: status = %d, admin listener %v", w.Code, s.admin != nil)
+	}
+
+	// Sharing the admin port, one listener serves both, with the admin
+	// endpoints behind ADMIN_USERNAME and the health checks open
+	s = newTestServer(t, &config.Config{Port: "0", InternalPort: "6060", AdminPort: "6060", AdminUsername: "admin", AdminPassword: "secret"})
+	if s.admin != nil {
+		t.Error("admin listener started on the internal port")
+	}
+	w = httptest.NewRecorder()
+	s.internal.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/downloads", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("shared /admin/downloads without credentials: status = %d, want 401", w.Code)
+	}
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/admin/downloads", nil)
+	req.SetBasicAuth("admin", "secret")
+	s.internal.Handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("shared /admin/downloads with credentials: status = %d, want 200", w.Code)
+	}
+	w = httptest.NewRecorder()
+	s.internal.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("shared /livez: status = %d, want 200", w.Code)
+	}
+}
+
+func TestNew_ErrorFormat(t *testing.T) {
+	s := newTestServer(t, &config.Config{Port: "0", BasePath: "/download", ErrorFormat: "json"})
+
+	w := httptest.NewRecorder()
+	s.srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("unrouted path: status %d, Content-Type %q, want 404 problem JSON", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestNew_Options(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/custom", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	header := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Frame-Options", "DENY")
+			next.ServeHTTP(w, r)
+		})
+	}
+	deny := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "company auth required", http.StatusForbidden)
+		})
+	}
+	s := newTestServer(t, &config.Config{Port: "0", BasePath: "/download"}, WithRouter(r), WithMiddleware(header), WithDownloadMiddleware(deny))
+
+	tests := []struct {
+		method, path string
+		wantStatus   int
+	}{
+		{http.MethodGet, "/custom", http.StatusTeapot},
+		{http.MethodGet, "/version", http.StatusOK},
+		{http.MethodGet, "/download/abc", http.StatusForbidden},
+		{http.MethodPost, "/download/abc/prepare", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.srv.Handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, w.Code, tt.wantStatus)
+		}
+		if got := w.Header().Get("X-Frame-Options"); got != "DENY" {
+			t.Errorf("%s %s: X-Frame-Options = %q, want the global middleware's", tt.method, tt.path, got)
+		}
+	}
+}
+
+func TestNew_H2C(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		s := newTestServer(t, &config.Config{Port: "0", EnableH2C: enabled})
+		ts := httptest.NewServer(s.srv.Handler)
+
+		// An HTTP/2 client with prior knowledge, over plain TCP
+		client := &http.Client{Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		}}
+		resp, err := client.Get(ts.URL + "/version")
+		if enabled {
+			if err != nil {
+				t.Fatalf("h2c request: %v", err)
+			}
+			resp.Body.Close()
+			if resp.ProtoMajor != 2 || resp.StatusCode != http.StatusOK {
+				t.Errorf("h2c request: %s %d, want HTTP/2.0 200", resp.Proto, resp.StatusCode)
+			}
+		} else if err == nil {
+			resp.Body.Close()
+			t.Errorf("h2c request succeeded with ENABLE_H2C off: %s %d", resp.Proto, resp.StatusCode)
+		}
+		ts.Close()
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/config"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes, at most, when a TLS handshake needs the certificate
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves the certificate in TLS_CERT_FILE and TLS_KEY_FILE,
+// loading it again once either file changes, so a rotated certificate is
+// picked up without a restart. If the new files don't load, e.g. when only
+// one has been replaced so far, the current certificate is kept.
+type certReloader struct {
+	logger   *zap.Logger
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // the latest modification time of the files loaded
+	checked time.Time
+}
+
+// newCertReloader loads the certificate in certFile and keyFile
+func newCertReloader(logger *zap.Logger, certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{logger: logger, certFile: certFile, keyFile: keyFile}
+	modTime, err := c.modified()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(modTime); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate returns the current certificate, for tls.Config
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) >= certCheckInterval {
+		c.checked = time.Now()
+		if modTime, err := c.modified(); err != nil {
+			c.logger.Error("failed to check TLS certificate files, keeping the current certificate", zap.Error(err))
+		} else if !modTime.Equal(c.modTime) {
+			if err := c.load(modTime); err != nil {
+				c.logger.Error("failed to reload TLS certificate, keeping the current one", zap.Error(err))
+			} else {
+				c.logger.Info("reloaded TLS certificate", zap.String("cert_file", c.certFile), zap.Time("not_after", c.cert.Leaf.NotAfter))
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+// modified returns the latest modification time of the certificate files
+func (c *certReloader) modified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// load reads the certificate files, last modified at modTime
+func (c *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS_CERT_FILE and TLS_KEY_FILE: %w", err)
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	c.checked = time.Now()
+	return nil
+}
+
+// applyClientAuth makes tlsCfg require a client certificate that chains to
+// CLIENT_CA_FILE and, when CLIENT_CERT_NAMES is set, carries one of those
+// names. It leaves tlsCfg alone when CLIENT_CA_FILE is empty.
+func applyClientAuth(tlsCfg *tls.Config, cfg *config.Config) error {
+	if cfg.ClientCAFile == "" {
+		return nil
+	}
+
+	pem, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return fmt.Errorf("failed to read CLIENT_CA_FILE: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in CLIENT_CA_FILE %q", cfg.ClientCAFile)
+	}
+	tlsCfg.ClientCAs = pool
+	tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+
+	if len(cfg.ClientCertNames) > 0 {
+		allowed := cfg.ClientCertNames
+		tlsCfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("client presented no certificate")
+			}
+			cert := cs.PeerCertificates[0]
+			for _, name := range certNames(cert) {
+				if slices.Contains(allowed, name) {
+					return nil
+				}
+			}
+			return fmt.Errorf("client certificate %q is not in CLIENT_CERT_NAMES", cert.Subject.CommonName)
+		}
+	}
+	return nil
+}
+
+// certNames returns the subject common name and every DNS, email, and URI
+// subject alternative name of cert
+func certNames(cert *x509.Certificate) []string {
+	var names []string
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
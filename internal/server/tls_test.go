@@ -0,0 +1,236 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"zipperfly/internal/config"
+)
+
+// testCA is a throwaway certificate authority for client certificates
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// writePEM writes the CA certificate as a PEM bundle
+func (ca *testCA) writePEM(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write CA: %v", err)
+	}
+	return path
+}
+
+// issue signs a client certificate for commonName and dnsNames
+func (ca *testCA) issue(t *testing.T, commonName string, dnsNames ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate client key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to create client certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestApplyClientAuth(t *testing.T) {
+	ca := newTestCA(t)
+	caPath := ca.writePEM(t)
+	otherCA := newTestCA(t)
+
+	tests := []struct {
+		name       string
+		names      []string
+		clientCert *tls.Certificate
+		wantOK     bool
+	}{
+		{name: "no certificate", wantOK: false},
+		{name: "any name", clientCert: ptr(ca.issue(t, "billing")), wantOK: true},
+		{name: "allowed common name", names: []string{"billing"}, clientCert: ptr(ca.issue(t, "billing")), wantOK: true},
+		{name: "allowed DNS name", names: []string{"reports.internal"}, clientCert: ptr(ca.issue(t, "reports", "reports.internal")), wantOK: true},
+		{name: "name not allowed", names: []string{"billing"}, clientCert: ptr(ca.issue(t, "reports", "reports.internal")), wantOK: false},
+		{name: "other CA", clientCert: ptr(otherCA.issue(t, "billing")), wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			srv.TLS = &tls.Config{}
+			if err := applyClientAuth(srv.TLS, &config.Config{ClientCAFile: caPath, ClientCertNames: tt.names}); err != nil {
+				t.Fatalf("applyClientAuth() error = %v", err)
+			}
+			srv.StartTLS()
+			defer srv.Close()
+
+			client := srv.Client()
+			transport := client.Transport.(*http.Transport)
+			if tt.clientCert != nil {
+				transport.TLSClientConfig.Certificates = []tls.Certificate{*tt.clientCert}
+			}
+
+			resp, err := client.Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err == nil) != tt.wantOK {
+				t.Errorf("request error = %v, want ok %v", err, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestApplyClientAuth_Config(t *testing.T) {
+	tlsCfg := &tls.Config{}
+	if err := applyClientAuth(tlsCfg, &config.Config{}); err != nil || tlsCfg.ClientAuth != tls.NoClientCert {
+		t.Errorf("without CLIENT_CA_FILE: error = %v, ClientAuth = %v", err, tlsCfg.ClientAuth)
+	}
+
+	if err := applyClientAuth(&tls.Config{}, &config.Config{ClientCAFile: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Error("expected error for missing CA file")
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyClientAuth(&tls.Config{}, &config.Config{ClientCAFile: empty}); err == nil {
+		t.Error("expected error for CA file without certificates")
+	}
+}
+
+// writeKeyPair writes cert and its ECDSA key as PEM files at certFile and
+// keyFile, last modified at modTime
+func writeKeyPair(t *testing.T, cert tls.Certificate, certFile, keyFile string, modTime time.Time) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour)
+
+	first := ca.issue(t, "first")
+	writeKeyPair(t, first, certFile, keyFile, start)
+	certs, err := newCertReloader(zap.NewNop(), certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertReloader() error = %v", err)
+	}
+
+	// serving returns the common name of the certificate served
+	serving := func() string {
+		t.Helper()
+		certs.mu.Lock()
+		certs.checked = time.Time{} // skip the check interval
+		certs.mu.Unlock()
+		cert, err := certs.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("GetCertificate() error = %v", err)
+		}
+		return cert.Leaf.Subject.CommonName
+	}
+
+	if got := serving(); got != "first" {
+		t.Errorf("initial certificate = %q, want first", got)
+	}
+
+	// A rotated certificate is picked up
+	writeKeyPair(t, ca.issue(t, "second"), certFile, keyFile, start.Add(time.Minute))
+	if got := serving(); got != "second" {
+		t.Errorf("after rotation certificate = %q, want second", got)
+	}
+
+	// A certificate that doesn't match its key keeps the current one
+	third := ca.issue(t, "third")
+	third.PrivateKey = first.PrivateKey
+	writeKeyPair(t, third, certFile, keyFile, start.Add(2*time.Minute))
+	if got := serving(); got != "second" {
+		t.Errorf("after invalid rotation certificate = %q, want second", got)
+	}
+
+	// Missing files keep the current one
+	os.Remove(keyFile)
+	if got := serving(); got != "second" {
+		t.Errorf("after removing the key certificate = %q, want second", got)
+	}
+
+	if _, err := newCertReloader(zap.NewNop(), certFile, keyFile); err == nil {
+		t.Error("expected error for missing key file")
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"zipperfly/internal/metrics"
+)
+
+// FailoverProvider fetches from a primary provider and, when that fails or
+// its circuit breaker is open, from a secondary one, e.g. a replica bucket
+// in another region
+type FailoverProvider struct {
+	primary   Provider
+	secondary Provider
+	bucket    func(primary string) string // the secondary's bucket for a primary bucket
+	metrics   *metrics.Metrics
+}
+
+// NewFailover creates a provider that fails over from primary to
+// secondary. bucket maps a primary bucket to the secondary's, nil = the
+// same name.
+func NewFailover(primary, secondary Provider, bucket func(string) string, m *metrics.Metrics) *FailoverProvider {
+	if bucket == nil {
+		bucket = func(primary string) string { return primary }
+	}
+	return &FailoverProvider{primary: primary, secondary: secondary, bucket: bucket, metrics: m}
+}
+
+// GetObject fetches an object from the primary, or from the secondary if
+// the primary fails. If both fail, the error wraps both of theirs.
+func (f *FailoverProvider) GetObject(ctx context.Context, bucket, key string) (*Object, error) {
+	obj, err := f.primary.GetObject(ctx, bucket, key)
+	if err == nil || ctx.Err() != nil {
+		return obj, err
+	}
+
+	obj, failoverErr := f.secondary.GetObject(ctx, f.bucket(bucket), key)
+	if failoverErr != nil {
+		f.metrics.StorageFailovers.WithLabelValues("failure").Inc()
+		return nil, errors.Join(err, fmt.Errorf("failover: %w", failoverErr))
+	}
+	f.metrics.StorageFailovers.WithLabelValues("success").Inc()
+	return obj, nil
+}
+
+// StatObject looks an object up in the primary, or in the secondary if
+// that fails, like GetObject
+func (f *FailoverProvider) StatObject(ctx context.Context, bucket, key string) (*ObjectInfo, error) {
+	info, err := Stat(ctx, f.primary, bucket, key)
+	if err == nil || ctx.Err() != nil {
+		return info, err
+	}
+	info, failoverErr := Stat(ctx, f.secondary, f.bucket(bucket), key)
+	if failoverErr != nil {
+		return nil, errors.Join(err, fmt.Errorf("failover: %w", failoverErr))
+	}
+	return info, nil
+}
+
+// HealthCheck succeeds if either provider is reachable, since downloads
+// still work while one of them is
+func (f *FailoverProvider) HealthCheck(ctx context.Context) error {
+	err := f.primary.HealthCheck(ctx)
+	if err == nil {
+		return nil
+	}
+	if failoverErr := f.secondary.HealthCheck(ctx); failoverErr != nil {
+		return errors.Join(err, fmt.Errorf("failover: %w", failoverErr))
+	}
+	return nil
+}
+
+// SetCredentials replaces the primary's credentials, if it has any to
+// replace; the secondary's are its own
+func (f *FailoverProvider) SetCredentials(accessKeyID, secretAccessKey string) error {
+	setter, ok := f.primary.(CredentialsSetter)
+	if !ok {
+		return errors.New("primary storage provider has no credentials to refresh")
+	}
+	return setter.SetCredentials(accessKeyID, secretAccessKey)
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"zipperfly/internal/circuitbreaker"
+	"zipperfly/internal/config"
+	"zipperfly/internal/metrics"
+)
+
+func TestFailoverProvider_GetObject(t *testing.T) {
+	m := metrics.NewWithRegistry(nil)
+	cfg := &config.Config{CircuitBreakerThreshold: 5, CircuitBreakerTimeout: time.Minute, CircuitBreakerMaxRequests: 1}
+	newLocal := func(files map[string]string) *LocalProvider {
+		dir := t.TempDir()
+		for name, content := range files {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		p, err := NewLocalProvider(dir, m, circuitbreaker.NewPerKey("storage", cfg, m), time.Second, 0, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	primary := newLocal(map[string]string{"assets/both.txt": "primary"})
+	secondary := newLocal(map[string]string{"assets-eu/both.txt": "replica", "assets-eu/replica-only.txt": "replica"})
+	f := NewFailover(primary, secondary, func(bucket string) string { return bucket + "-eu" }, m)
+
+	tests := []struct {
+		name        string
+		key         string
+		want        string
+		wantErr     bool
+		wantSuccess float64
+		wantFailure float64
+	}{
+		{name: "primary", key: "both.txt", want: "primary"},
+		{name: "failover", key: "replica-only.txt", want: "replica", wantSuccess: 1},
+		{name: "both fail", key: "missing.txt", wantErr: true, wantSuccess: 1, wantFailure: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj, err := f.GetObject(context.Background(), "assets", tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetObject() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				data, _ := io.ReadAll(obj)
+				obj.Close()
+				if string(data) != tt.want {
+					t.Errorf("GetObject() read %q, want %q", data, tt.want)
+				}
+			}
+			if got := testutil.ToFloat64(m.StorageFailovers.WithLabelValues("success")); got != tt.wantSuccess {
+				t.Errorf("successful failovers = %g, want %g", got, tt.wantSuccess)
+			}
+			if got := testutil.ToFloat64(m.StorageFailovers.WithLabelValues("failure")); got != tt.wantFailure {
+				t.Errorf("failed failovers = %g, want %g", got, tt.wantFailure)
+			}
+		})
+	}
+
+	if info, err := f.StatObject(context.Background(), "assets", "replica-only.txt"); err != nil || info.Size != int64(len("replica")) {
+		t.Errorf("StatObject() = %+v, %v, want the replica's size", info, err)
+	}
+	if _, err := f.StatObject(context.Background(), "assets", "missing.txt"); !IsNotFound(err) {
+		t.Errorf("StatObject() of a missing object error = %v, want not found", err)
+	}
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	hedgeWindow     = 1000 // recent response times the percentile is taken over
+	hedgeMinSamples = 50   // responses seen before hedging starts
+	hedgeRecompute  = 50   // responses between recomputing the percentile
+)
+
+// hedger decides when to hedge a storage request: once it has been waiting
+// longer than a percentile of recent response times, a second request is
+// likely to answer before it does
+type hedger struct {
+	percentile float64
+	minDelay   time.Duration
+
+	mu      sync.Mutex
+	samples []time.Duration // ring buffer of the last hedgeWindow response times
+	next    int             // where the next sample goes once the buffer is full
+	pending int             // samples since the delay was computed
+	current time.Duration   // the percentile, 0 until hedgeMinSamples are seen
+}
+
+// newHedger returns a hedger for percentile (e.g. 95), or nil if percentile
+// is 0, turning hedging off
+func newHedger(percentile float64, minDelay time.Duration) *hedger {
+	if percentile <= 0 {
+		return nil
+	}
+	return &hedger{percentile: percentile, minDelay: minDelay, samples: make([]time.Duration, 0, hedgeWindow)}
+}
+
+// delay returns how long to wait for a request before hedging it, and false
+// if it shouldn't be hedged: hedging is off or too few responses were seen
+func (h *hedger) delay() (time.Duration, bool) {
+	if h == nil {
+		return 0, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.current == 0 {
+		return 0, false
+	}
+	return max(h.current, h.minDelay), true
+}
+
+// observe records the response time of a request that succeeded
+func (h *hedger) observe(d time.Duration) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) < hedgeWindow {
+		h.samples = append(h.samples, d)
+	} else {
+		h.samples[h.next] = d
+		h.next = (h.next + 1) % hedgeWindow
+	}
+
+	h.pending++
+	if len(h.samples) < hedgeMinSamples || (h.current != 0 && h.pending < hedgeRecompute) {
+		return
+	}
+	h.pending = 0
+	sorted := slices.Clone(h.samples)
+	slices.Sort(sorted)
+	h.current = max(sorted[int(float64(len(sorted)-1)*h.percentile/100)], time.Nanosecond)
+}
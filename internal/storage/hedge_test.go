@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHedger(t *testing.T) {
+	tests := []struct {
+		name       string
+		percentile float64
+		minDelay   time.Duration
+		samples    int
+		wantDelay  time.Duration
+		wantHedge  bool
+	}{
+		{name: "off", percentile: 0, samples: 100},
+		{name: "too few samples", percentile: 90, samples: hedgeMinSamples - 1},
+		{name: "p90", percentile: 90, samples: 100, wantDelay: 90 * time.Millisecond, wantHedge: true},
+		{name: "p50", percentile: 50, samples: 100, wantDelay: 50 * time.Millisecond, wantHedge: true},
+		{name: "min delay", percentile: 50, minDelay: 200 * time.Millisecond, samples: 100, wantDelay: 200 * time.Millisecond, wantHedge: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHedger(tt.percentile, tt.minDelay)
+			// Response times of 1ms up to 100ms; the percentile is computed
+			// at the 50th and again at the 100th
+			for i := 1; i <= tt.samples; i++ {
+				h.observe(time.Duration(i) * time.Millisecond)
+			}
+
+			delay, ok := h.delay()
+			if ok != tt.wantHedge || delay != tt.wantDelay {
+				t.Errorf("delay() = %v, %v, want %v, %v", delay, ok, tt.wantDelay, tt.wantHedge)
+			}
+		})
+	}
+}
+
+func TestHedger_Window(t *testing.T) {
+	h := newHedger(50, 0)
+	for i := 0; i < hedgeWindow; i++ {
+		h.observe(time.Second)
+	}
+	// Recent responses push the old ones out of the window
+	for i := 0; i < hedgeWindow; i++ {
+		h.observe(time.Millisecond)
+	}
+	if delay, _ := h.delay(); delay != time.Millisecond {
+		t.Errorf("delay() = %v, want %v", delay, time.Millisecond)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -12,6 +11,7 @@ import (
 
 	"zipperfly/internal/circuitbreaker"
 	"zipperfly/internal/metrics"
+	"zipperfly/internal/retry"
 )
 
 // LocalProvider implements Provider for local filesystem storage
@@ -22,10 +22,11 @@ type LocalProvider struct {
 	fetchTimeout   time.Duration
 	maxRetries     int
 	retryDelay     time.Duration
+	maxDelay       time.Duration // cap on the delay between retries, 0 = none
 }
 
 // NewLocalProvider creates a new local filesystem storage provider
-func NewLocalProvider(basePath string, m *metrics.Metrics, cb *circuitbreaker.Breaker, fetchTimeout time.Duration, maxRetries int, retryDelay time.Duration) (*LocalProvider, error) {
+func NewLocalProvider(basePath string, m *metrics.Metrics, cb *circuitbreaker.Breaker, fetchTimeout time.Duration, maxRetries int, retryDelay, maxDelay time.Duration) (*LocalProvider, error) {
 	// Ensure base path exists and is a directory
 	info, err := os.Stat(basePath)
 	if err != nil {
@@ -48,13 +49,14 @@ func NewLocalProvider(basePath string, m *metrics.Metrics, cb *circuitbreaker.Br
 		fetchTimeout:   fetchTimeout,
 		maxRetries:     maxRetries,
 		retryDelay:     retryDelay,
+		maxDelay:       maxDelay,
 	}, nil
 }
 
 // GetObject retrieves a file from the local filesystem
 // bucket: optional path prefix within basePath (can be empty)
 // key: file path relative to bucket (or basePath if bucket is empty)
-func (l *LocalProvider) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+func (l *LocalProvider) GetObject(ctx context.Context, bucket, key string) (*Object, error) {
 	start := time.Now()
 	var resultLabel string
 	defer func() {
@@ -66,35 +68,29 @@ func (l *LocalProvider) GetObject(ctx context.Context, bucket, key string) (io.R
 	l.metrics.ActiveFileFetches.Inc()
 	defer l.metrics.ActiveFileFetches.Dec()
 
-	// Execute with circuit breaker
-	result, err := l.circuitBreaker.Execute(func() (interface{}, error) {
-		// Build the full path - bucket is optional and treated as a prefix
-		pathComponents := []string{l.basePath}
-
-		if bucket != "" {
-			// Split bucket by / to handle paths like "foo/bar/baz"
-			pathComponents = append(pathComponents, bucket)
-		}
-
-		pathComponents = append(pathComponents, key)
-		fullPath := filepath.Join(pathComponents...)
-
-		// Clean the path to resolve any .. or . segments
-		fullPath = filepath.Clean(fullPath)
-
-		// Security: ensure the resolved path is still within basePath
-		if !strings.HasPrefix(fullPath, l.basePath) {
+	// Execute with the bucket's circuit breaker
+	result, err := l.circuitBreaker.ExecuteFor(bucket, func() (interface{}, error) {
+		fullPath, err := l.path(bucket, key)
+		if err != nil {
 			resultLabel = "error"
-			return nil, fmt.Errorf("path traversal attempt detected: bucket=%s, key=%s", bucket, key)
+			return nil, err
 		}
 
-		// Retry loop with exponential backoff
+		// Retry loop with jittered exponential backoff
 		var lastErr error
 		for attempt := 0; attempt <= l.maxRetries; attempt++ {
 			if attempt > 0 {
-				// Exponential backoff: retryDelay * 2^(attempt-1)
-				delay := l.retryDelay * time.Duration(1<<(attempt-1))
-				time.Sleep(delay)
+				// Opening a file takes no time to speak of, so a retry
+				// only needs its delay to fit before the deadline
+				if err := retry.Wait(ctx, retry.Backoff(l.retryDelay, l.maxDelay, attempt), 0); err != nil {
+					if errors.Is(err, retry.ErrDeadline) {
+						l.metrics.StorageRetriesLate.Inc()
+						lastErr = fmt.Errorf("%w: %w", lastErr, err)
+					} else {
+						lastErr = err
+					}
+					break
+				}
 			}
 
 			// Check context cancellation
@@ -108,8 +104,14 @@ func (l *LocalProvider) GetObject(ctx context.Context, bucket, key string) (io.R
 			// Open the file
 			file, err := os.Open(fullPath)
 			if err == nil {
+				info, statErr := file.Stat()
+				if statErr != nil {
+					file.Close()
+					resultLabel = "error"
+					return nil, fmt.Errorf("failed to stat file: %w", statErr)
+				}
 				resultLabel = "success"
-				return file, nil
+				return &Object{ReadCloser: file, Size: info.Size(), ModTime: info.ModTime(), Mode: info.Mode().Perm()}, nil
 			}
 
 			lastErr = err
@@ -118,6 +120,13 @@ func (l *LocalProvider) GetObject(ctx context.Context, bucket, key string) (io.R
 			if !isLocalRetryableError(err) || attempt == l.maxRetries {
 				break
 			}
+
+			// Stop once the download's retries are spent, so an outage
+			// doesn't keep every file of the archive retrying in turn
+			if !retry.Spend(ctx) {
+				l.metrics.StorageRetriesDenied.Inc()
+				break
+			}
 		}
 
 		resultLabel = "error"
@@ -128,7 +137,45 @@ func (l *LocalProvider) GetObject(ctx context.Context, bucket, key string) (io.R
 		return nil, err
 	}
 
-	return result.(io.ReadCloser), nil
+	return result.(*Object), nil
+}
+
+// StatObject looks a file up without opening it
+func (l *LocalProvider) StatObject(ctx context.Context, bucket, key string) (*ObjectInfo, error) {
+	fullPath, err := l.path(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%w: %s is not a regular file", ErrObjectNotFound, key)
+	}
+	return &ObjectInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// path returns the file of key, under bucket within the base path
+// (bucket is optional and treated as a prefix, e.g. "foo/bar/baz")
+func (l *LocalProvider) path(bucket, key string) (string, error) {
+	pathComponents := []string{l.basePath}
+	if bucket != "" {
+		pathComponents = append(pathComponents, bucket)
+	}
+	pathComponents = append(pathComponents, key)
+
+	// Join cleans the path, resolving any .. or . segments
+	fullPath := filepath.Join(pathComponents...)
+
+	// Security: ensure the resolved path is still within basePath
+	if !strings.HasPrefix(fullPath, l.basePath) {
+		return "", fmt.Errorf("path traversal attempt detected: bucket=%s, key=%s", bucket, key)
+	}
+	return fullPath, nil
 }
 
 // isLocalRetryableError determines if a local filesystem error should trigger a retry
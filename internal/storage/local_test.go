@@ -44,7 +44,7 @@ func TestLocalProvider_GetObject(t *testing.T) {
 	}
 	cb := circuitbreaker.New("test-storage", cfg, sharedMetrics)
 
-	provider, err := NewLocalProvider(tmpDir, sharedMetrics, cb, 5*time.Second, 3, time.Second)
+	provider, err := NewLocalProvider(tmpDir, sharedMetrics, cb, 5*time.Second, 3, time.Second, 30*time.Second)
 	if err != nil {
 		t.Fatalf("NewLocalProvider() error = %v", err)
 	}
@@ -109,14 +109,54 @@ func TestLocalProvider_GetObject(t *testing.T) {
 					t.Errorf("GetObject() unexpected error = %v", err)
 					return
 				}
-				if reader != nil {
-					reader.Close()
+				if reader.Size != int64(len(testContent)) {
+					t.Errorf("GetObject() Size = %d, want %d", reader.Size, len(testContent))
 				}
+				if reader.ModTime.IsZero() {
+					t.Error("GetObject() ModTime is zero")
+				}
+				if reader.Mode == 0 {
+					t.Error("GetObject() Mode is zero")
+				}
+				reader.Close()
 			}
 		})
 	}
 }
 
+func TestLocalProvider_StatObject(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("test content"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(tmpDir, "subdir"), 0755); err != nil {
+		t.Fatalf("failed to create subdir: %v", err)
+	}
+
+	cfg := &config.Config{CircuitBreakerThreshold: 5, CircuitBreakerTimeout: 10 * time.Second}
+	provider, err := NewLocalProvider(tmpDir, sharedMetrics, circuitbreaker.New("test-storage", cfg, sharedMetrics), 5*time.Second, 0, time.Second, 0)
+	if err != nil {
+		t.Fatalf("NewLocalProvider() error = %v", err)
+	}
+
+	info, err := provider.StatObject(context.Background(), "", "test.txt")
+	if err != nil {
+		t.Fatalf("StatObject() error = %v", err)
+	}
+	if info.Size != int64(len("test content")) || info.ModTime.IsZero() {
+		t.Errorf("StatObject() = %+v, want size %d and a modification time", info, len("test content"))
+	}
+
+	for _, key := range []string{"missing.txt", "subdir"} {
+		if _, err := provider.StatObject(context.Background(), "", key); !IsNotFound(err) {
+			t.Errorf("StatObject(%q) error = %v, want not found", key, err)
+		}
+	}
+	if _, err := provider.StatObject(context.Background(), "", "../../etc/passwd"); err == nil || IsNotFound(err) {
+		t.Errorf("StatObject() of a path outside the base error = %v, want a traversal error", err)
+	}
+}
+
 func TestLocalProvider_HealthCheck(t *testing.T) {
 	tmpDir := t.TempDir()
 	cfg := &config.Config{
@@ -126,7 +166,7 @@ func TestLocalProvider_HealthCheck(t *testing.T) {
 	}
 	cb := circuitbreaker.New("test-storage-health", cfg, sharedMetrics)
 
-	provider, err := NewLocalProvider(tmpDir, sharedMetrics, cb, 5*time.Second, 3, time.Second)
+	provider, err := NewLocalProvider(tmpDir, sharedMetrics, cb, 5*time.Second, 3, time.Second, 30*time.Second)
 	if err != nil {
 		t.Fatalf("NewLocalProvider() error = %v", err)
 	}
@@ -161,7 +201,7 @@ func TestNewLocalProvider_InvalidPath(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := NewLocalProvider(tt.path, sharedMetrics, cb, 5*time.Second, 3, time.Second)
+			_, err := NewLocalProvider(tt.path, sharedMetrics, cb, 5*time.Second, 3, time.Second, 30*time.Second)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("NewLocalProvider() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -5,26 +5,39 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 
 	appconfig "zipperfly/internal/config"
 	"zipperfly/internal/circuitbreaker"
 	"zipperfly/internal/metrics"
+	"zipperfly/internal/retry"
 )
 
 // S3Provider implements Provider for S3-compatible storage
 type S3Provider struct {
 	client         *s3.Client
+	credentials    *staticCredentials // nil = the default AWS credential chain
 	circuitBreaker *circuitbreaker.Breaker
 	metrics        *metrics.Metrics
 	fetchTimeout   time.Duration
 	maxRetries     int
 	retryDelay     time.Duration
+	maxDelay       time.Duration // cap on the delay between retries, 0 = none
+	hedge          *hedger       // nil = requests aren't hedged
+
+	// HealthCheck probe: S3_HEALTH_CHECK mode, and the bucket and key it uses
+	healthCheck       string
+	healthCheckBucket string
+	healthCheckKey    string
 }
 
 // NewS3Provider creates a new S3-compatible storage provider
@@ -40,14 +53,11 @@ func NewS3Provider(ctx context.Context, cfg *appconfig.Config, m *metrics.Metric
 	}
 
 	// Static credentials (typical for MinIO and many S3-compatible providers)
+	var creds *staticCredentials
 	if cfg.S3AccessKeyID != "" && cfg.S3SecretAccessKey != "" {
-		cfgOpts = append(cfgOpts, config.WithCredentialsProvider(
-			credentials.NewStaticCredentialsProvider(
-				cfg.S3AccessKeyID,
-				cfg.S3SecretAccessKey,
-				"",
-			),
-		))
+		creds = &staticCredentials{}
+		creds.set(cfg.S3AccessKeyID, cfg.S3SecretAccessKey)
+		cfgOpts = append(cfgOpts, config.WithCredentialsProvider(creds))
 	}
 
 	// Custom endpoint (MinIO, Wasabi, etc.)
@@ -77,20 +87,64 @@ func NewS3Provider(ctx context.Context, cfg *appconfig.Config, m *metrics.Metric
 
 	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
 		o.UsePathStyle = usePathStyle
+		if creds != nil {
+			// Bypass the config's credentials cache, which would keep the
+			// first static key forever
+			o.Credentials = creds
+		}
 	})
 
 	return &S3Provider{
 		client:         client,
+		credentials:    creds,
 		circuitBreaker: cb,
 		metrics:        m,
 		fetchTimeout:   cfg.StorageFetchTimeout,
 		maxRetries:     cfg.StorageMaxRetries,
 		retryDelay:     cfg.StorageRetryDelay,
+		maxDelay:       cfg.StorageRetryMaxDelay,
+		hedge:          newHedger(cfg.StorageHedgePercentile, cfg.StorageHedgeMinDelay),
+
+		healthCheck:       cfg.S3HealthCheck,
+		healthCheckBucket: cfg.S3HealthCheckBucket,
+		healthCheckKey:    cfg.S3HealthCheckKey,
 	}, nil
 }
 
+// SetCredentials replaces the static access key, e.g. when it is refreshed
+// from a secrets manager. Requests already signed keep the old key.
+func (s *S3Provider) SetCredentials(accessKeyID, secretAccessKey string) error {
+	if s.credentials == nil {
+		return errors.New("S3 credentials come from the AWS credential chain, not S3_ACCESS_KEY_ID")
+	}
+	if accessKeyID == "" || secretAccessKey == "" {
+		return errors.New("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY must both be set")
+	}
+	s.credentials.set(accessKeyID, secretAccessKey)
+	return nil
+}
+
+// staticCredentials serves a static access key that can be replaced while
+// the client is in use
+type staticCredentials struct {
+	current atomic.Pointer[aws.Credentials]
+}
+
+func (c *staticCredentials) set(accessKeyID, secretAccessKey string) {
+	c.current.Store(&aws.Credentials{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		Source:          "S3_ACCESS_KEY_ID",
+	})
+}
+
+// Retrieve returns the current key
+func (c *staticCredentials) Retrieve(context.Context) (aws.Credentials, error) {
+	return *c.current.Load(), nil
+}
+
 // GetObject retrieves an object from S3
-func (s *S3Provider) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+func (s *S3Provider) GetObject(ctx context.Context, bucket, key string) (*Object, error) {
 	start := time.Now()
 	var resultLabel string
 	defer func() {
@@ -102,37 +156,72 @@ func (s *S3Provider) GetObject(ctx context.Context, bucket, key string) (io.Read
 	s.metrics.ActiveFileFetches.Inc()
 	defer s.metrics.ActiveFileFetches.Dec()
 
-	// Execute with circuit breaker
-	result, err := s.circuitBreaker.Execute(func() (interface{}, error) {
-		// Retry loop with exponential backoff
+	// Execute with the bucket's circuit breaker
+	result, err := s.circuitBreaker.ExecuteFor(bucket, func() (interface{}, error) {
+		// Retry loop with jittered exponential backoff, skipping retries
+		// that can't finish before the deadline
 		var lastErr error
+		var lastAttempt time.Duration
 		for attempt := 0; attempt <= s.maxRetries; attempt++ {
 			if attempt > 0 {
-				// Exponential backoff: retryDelay * 2^(attempt-1)
-				delay := s.retryDelay * time.Duration(1<<(attempt-1))
-				time.Sleep(delay)
+				if err := retry.Wait(ctx, retry.Backoff(s.retryDelay, s.maxDelay, attempt), min(lastAttempt, s.fetchTimeout)); err != nil {
+					if errors.Is(err, retry.ErrDeadline) {
+						s.metrics.StorageRetriesLate.Inc()
+						lastErr = fmt.Errorf("%w: %w", lastErr, err)
+					} else {
+						lastErr = err
+					}
+					break
+				}
 			}
+			attemptStart := time.Now()
 
-			// Apply timeout to this attempt
-			fetchCtx, cancel := context.WithTimeout(ctx, s.fetchTimeout)
-			defer cancel()
+			// The timeout applies until the response headers arrive. The body is
+			// bound to fetchCtx too, so it is only cancelled once closed; stalls
+			// while reading it are the caller's to detect.
+			fetchCtx, cancel := context.WithCancel(ctx)
+			timer := time.AfterFunc(s.fetchTimeout, cancel)
 
-			output, err := s.client.GetObject(fetchCtx, &s3.GetObjectInput{
+			output, err := s.fetch(fetchCtx, &s3.GetObjectInput{
 				Bucket: aws.String(bucket),
 				Key:    aws.String(key),
 			})
+			if !timer.Stop() && err == nil {
+				output.Body.Close()
+				err = fmt.Errorf("s3 fetch timed out after %s: %w", s.fetchTimeout, context.DeadlineExceeded)
+			}
 
 			if err == nil {
 				resultLabel = "success"
-				return output.Body, nil
+				obj := &Object{ReadCloser: &cancelOnClose{ReadCloser: output.Body, cancel: cancel}, Size: -1}
+				if output.ContentLength != nil {
+					obj.Size = *output.ContentLength
+				}
+				if output.LastModified != nil {
+					obj.ModTime = *output.LastModified
+				}
+				if output.ContentType != nil {
+					obj.ContentType = *output.ContentType
+				}
+				applyFileMetadata(obj, output.Metadata)
+				return obj, nil
 			}
 
+			cancel()
 			lastErr = err
+			lastAttempt = time.Since(attemptStart)
 
 			// Check if error is retryable
 			if !isRetryableError(err) || attempt == s.maxRetries {
 				break
 			}
+
+			// Stop once the download's retries are spent, so an outage
+			// doesn't keep every file of the archive retrying in turn
+			if !retry.Spend(ctx) {
+				s.metrics.StorageRetriesDenied.Inc()
+				break
+			}
 		}
 
 		resultLabel = "error"
@@ -143,7 +232,148 @@ func (s *S3Provider) GetObject(ctx context.Context, bucket, key string) (io.Read
 		return nil, err
 	}
 
-	return result.(io.ReadCloser), nil
+	return result.(*Object), nil
+}
+
+// fetch sends a GetObject request. With hedging on, a second one is sent if
+// the first hasn't answered within the hedge delay, and whichever succeeds
+// first is returned; the other is cancelled. Both requests are bound to ctx,
+// so the winner's body is released when ctx is.
+func (s *S3Provider) fetch(ctx context.Context, input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	type response struct {
+		output *s3.GetObjectOutput
+		err    error
+		hedge  bool
+	}
+	responses := make(chan response, 2)
+	send := func(hedge bool) context.CancelFunc {
+		reqCtx, cancel := context.WithCancel(ctx)
+		input := *input // the SDK's middleware writes to the input
+		go func() {
+			sent := time.Now()
+			output, err := s.client.GetObject(reqCtx, &input)
+			if err == nil {
+				s.hedge.observe(time.Since(sent))
+			}
+			responses <- response{output: output, err: err, hedge: hedge}
+		}()
+		return cancel
+	}
+
+	delay, ok := s.hedge.delay()
+	if !ok {
+		sent := time.Now()
+		output, err := s.client.GetObject(ctx, input)
+		if err == nil {
+			s.hedge.observe(time.Since(sent))
+		}
+		return output, err
+	}
+
+	cancels := []context.CancelFunc{send(false)}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var firstErr error
+	for pending := 1; pending > 0; {
+		select {
+		case <-timer.C:
+			cancels = append(cancels, send(true))
+			pending++
+		case r := <-responses:
+			pending--
+			if r.err != nil {
+				if firstErr == nil {
+					firstErr = r.err
+				}
+				continue
+			}
+
+			if len(cancels) == 1 {
+				return r.output, nil
+			}
+
+			// Cancel the other request, closing its body if it answers anyway
+			result, loser := "lost", 1
+			if r.hedge {
+				result, loser = "won", 0
+			}
+			s.metrics.StorageHedgedRequests.WithLabelValues(result).Inc()
+			cancels[loser]()
+			if pending > 0 {
+				go func() {
+					if r := <-responses; r.err == nil {
+						r.output.Body.Close()
+					}
+				}()
+			}
+			return r.output, nil
+		}
+	}
+
+	if len(cancels) > 1 {
+		s.metrics.StorageHedgedRequests.WithLabelValues("failed").Inc()
+	}
+	for _, cancel := range cancels {
+		cancel()
+	}
+	return nil, firstErr
+}
+
+// applyFileMetadata applies the file attributes that sync tools store in
+// user metadata: "mtime" in Unix seconds, possibly fractional (rclone,
+// s3fs), overrides LastModified, and "mode" sets the permission bits, octal
+// with a leading 0 (rclone) or decimal (s3fs). Unparseable values are ignored.
+func applyFileMetadata(obj *Object, metadata map[string]string) {
+	if v, ok := metadata["mtime"]; ok {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+			whole, frac := math.Modf(secs)
+			obj.ModTime = time.Unix(int64(whole), int64(frac*1e9)).UTC()
+		}
+	}
+	if v, ok := metadata["mode"]; ok {
+		base := 10
+		if strings.HasPrefix(v, "0") {
+			base = 8
+		}
+		if mode, err := strconv.ParseUint(v, base, 32); err == nil {
+			obj.Mode = fs.FileMode(mode).Perm()
+		}
+	}
+}
+
+// cancelOnClose releases a request context when its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// StatObject looks an object up with a HEAD request, bounded by the fetch
+// timeout. It bypasses the circuit breaker, so looking up missing objects
+// doesn't trip it for downloads.
+func (s *S3Provider) StatObject(ctx context.Context, bucket, key string) (*ObjectInfo, error) {
+	headCtx, cancel := context.WithTimeout(ctx, s.fetchTimeout)
+	defer cancel()
+	output, err := s.client.HeadObject(headCtx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	info := &ObjectInfo{Size: -1}
+	if output.ContentLength != nil {
+		info.Size = *output.ContentLength
+	}
+	if output.LastModified != nil {
+		info.ModTime = *output.LastModified
+	}
+	return info, nil
 }
 
 // isRetryableError determines if an error should trigger a retry
@@ -163,14 +393,28 @@ func isRetryableError(err error) bool {
 	return true
 }
 
-// HealthCheck performs a lightweight connectivity check to S3
+// HealthCheck performs a lightweight connectivity check to S3, as set by
+// S3_HEALTH_CHECK: ListBuckets, which needs no bucket name but is often
+// denied by IAM policies scoped to one bucket; HeadBucket or HeadObject on a
+// configured bucket and key; or none, which always succeeds
 func (s *S3Provider) HealthCheck(ctx context.Context) error {
-	// Use ListBuckets as a lightweight operation to verify S3 connectivity
-	// This doesn't require knowing a specific bucket name
 	checkCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	_, err := s.client.ListBuckets(checkCtx, &s3.ListBucketsInput{})
+	var err error
+	switch s.healthCheck {
+	case "none":
+		return nil
+	case "head_bucket":
+		_, err = s.client.HeadBucket(checkCtx, &s3.HeadBucketInput{Bucket: aws.String(s.healthCheckBucket)})
+	case "head_object":
+		_, err = s.client.HeadObject(checkCtx, &s3.HeadObjectInput{
+			Bucket: aws.String(s.healthCheckBucket),
+			Key:    aws.String(s.healthCheckKey),
+		})
+	default:
+		_, err = s.client.ListBuckets(checkCtx, &s3.ListBucketsInput{})
+	}
 	if err != nil {
 		return fmt.Errorf("s3 connectivity check failed: %w", err)
 	}
@@ -2,12 +2,22 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
 	appconfig "zipperfly/internal/config"
 	"zipperfly/internal/circuitbreaker"
 	"zipperfly/internal/metrics"
+	"zipperfly/internal/retry"
 )
 
 func baseS3TestConfig() *appconfig.Config {
@@ -69,3 +79,298 @@ func TestNewS3Provider_UsePathStyleFalse(t *testing.T) {
 		t.Errorf("expected UsePathStyle=false on s3 client options when cfg.S3UsePathStyle=false")
 	}
 }
+
+func TestS3Provider_SetCredentials(t *testing.T) {
+	ctx := context.Background()
+	cfg := baseS3TestConfig()
+	m := metrics.New()
+
+	provider, err := NewS3Provider(ctx, cfg, m, circuitbreaker.New("storage", cfg, m))
+	if err != nil {
+		t.Fatalf("NewS3Provider returned error: %v", err)
+	}
+	if err := provider.SetCredentials("new-access-key", "new-secret-key"); err != nil {
+		t.Fatalf("SetCredentials returned error: %v", err)
+	}
+	creds, err := provider.client.Options().Credentials.Retrieve(ctx)
+	if err != nil {
+		t.Fatalf("Retrieve returned error: %v", err)
+	}
+	if creds.AccessKeyID != "new-access-key" || creds.SecretAccessKey != "new-secret-key" {
+		t.Errorf("client credentials = %s/%s, want the new key", creds.AccessKeyID, creds.SecretAccessKey)
+	}
+	if err := provider.SetCredentials("", "new-secret-key"); err == nil {
+		t.Error("SetCredentials with an empty access key succeeded, want an error")
+	}
+
+	cfg.S3AccessKeyID, cfg.S3SecretAccessKey = "", ""
+	chain, err := NewS3Provider(ctx, cfg, m, circuitbreaker.New("storage", cfg, m))
+	if err != nil {
+		t.Fatalf("NewS3Provider returned error: %v", err)
+	}
+	if err := chain.SetCredentials("new-access-key", "new-secret-key"); err == nil {
+		t.Error("SetCredentials without static credentials succeeded, want an error")
+	}
+}
+
+func TestS3Provider_HealthCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		wantRequest string // method and path S3 receives, "" for none
+	}{
+		{name: "list buckets", mode: "list_buckets", wantRequest: "GET /"},
+		{name: "head bucket", mode: "head_bucket", wantRequest: "HEAD /health-bucket"},
+		{name: "head object", mode: "head_object", wantRequest: "HEAD /health-bucket/probe.txt"},
+		{name: "none", mode: "none"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Method + " " + r.URL.Path
+				if r.Method == http.MethodGet {
+					w.Write([]byte(`<ListAllMyBucketsResult></ListAllMyBucketsResult>`))
+				}
+			}))
+			defer server.Close()
+
+			cfg := baseS3TestConfig()
+			cfg.S3Endpoint = server.URL
+			cfg.S3HealthCheck = tt.mode
+			cfg.S3HealthCheckBucket = "health-bucket"
+			cfg.S3HealthCheckKey = "probe.txt"
+			m := metrics.New()
+
+			provider, err := NewS3Provider(context.Background(), cfg, m, circuitbreaker.New("storage", cfg, m))
+			if err != nil {
+				t.Fatalf("NewS3Provider returned error: %v", err)
+			}
+			if err := provider.HealthCheck(context.Background()); err != nil {
+				t.Errorf("HealthCheck() error = %v", err)
+			}
+			if got != tt.wantRequest {
+				t.Errorf("S3 received %q, want %q", got, tt.wantRequest)
+			}
+		})
+	}
+}
+
+func TestS3Provider_StatObject(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.URL.Path != "/bucket/present.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", "42")
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+	}))
+	defer server.Close()
+
+	cfg := baseS3TestConfig()
+	cfg.S3Endpoint = server.URL
+	m := metrics.NewWithRegistry(nil)
+	provider, err := NewS3Provider(context.Background(), cfg, m, circuitbreaker.New("storage", cfg, m))
+	if err != nil {
+		t.Fatalf("NewS3Provider returned error: %v", err)
+	}
+
+	info, err := provider.StatObject(context.Background(), "bucket", "present.txt")
+	if err != nil {
+		t.Fatalf("StatObject() error = %v", err)
+	}
+	if info.Size != 42 || info.ModTime.IsZero() {
+		t.Errorf("StatObject() = %+v, want size 42 and a modification time", info)
+	}
+	if _, err := provider.StatObject(context.Background(), "bucket", "missing.txt"); !IsNotFound(err) {
+		t.Errorf("StatObject() of a missing object error = %v, want not found", err)
+	}
+	if requests[0] != "HEAD /bucket/present.txt" {
+		t.Errorf("S3 received %q, want a HEAD request", requests[0])
+	}
+}
+
+func TestApplyFileMetadata(t *testing.T) {
+	lastModified := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		wantTime time.Time
+		wantMode fs.FileMode
+	}{
+		{name: "no metadata", wantTime: lastModified},
+		{name: "integer mtime", metadata: map[string]string{"mtime": "1700000000"}, wantTime: time.Unix(1700000000, 0).UTC()},
+		{name: "fractional mtime", metadata: map[string]string{"mtime": "1700000000.5"}, wantTime: time.Unix(1700000000, 5e8).UTC()},
+		{name: "octal mode", metadata: map[string]string{"mode": "0100755"}, wantTime: lastModified, wantMode: 0o755},
+		{name: "decimal mode", metadata: map[string]string{"mode": "33188"}, wantTime: lastModified, wantMode: 0o644},
+		{name: "invalid values ignored", metadata: map[string]string{"mtime": "yesterday", "mode": "rwx"}, wantTime: lastModified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &Object{ModTime: lastModified}
+			applyFileMetadata(obj, tt.metadata)
+			if !obj.ModTime.Equal(tt.wantTime) || obj.Mode != tt.wantMode {
+				t.Errorf("ModTime, Mode = %s, %v; want %s, %v", obj.ModTime, obj.Mode, tt.wantTime, tt.wantMode)
+			}
+		})
+	}
+}
+
+func TestS3Provider_GetObjectRetryBudget(t *testing.T) {
+	tests := []struct {
+		name         string
+		budget       int
+		wantRequests int
+		wantDenied   float64
+	}{
+		{name: "unlimited", budget: 0, wantRequests: 8},
+		{name: "budget shared by fetches", budget: 1, wantRequests: 3, wantDenied: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(http.StatusForbidden)
+			}))
+			defer server.Close()
+
+			cfg := baseS3TestConfig()
+			cfg.S3Endpoint = server.URL
+			cfg.StorageMaxRetries = 3
+			cfg.StorageRetryDelay = time.Millisecond
+			cfg.CircuitBreakerThreshold = 100
+			m := metrics.NewWithRegistry(prometheus.NewRegistry())
+
+			provider, err := NewS3Provider(context.Background(), cfg, m, circuitbreaker.New("storage", cfg, m))
+			if err != nil {
+				t.Fatalf("NewS3Provider returned error: %v", err)
+			}
+
+			ctx := retry.WithBudget(context.Background(), tt.budget)
+			for _, key := range []string{"a.txt", "b.txt"} {
+				if _, err := provider.GetObject(ctx, "bucket", key); err == nil {
+					t.Fatalf("GetObject(%q) succeeded, want an error", key)
+				}
+			}
+
+			if got := int(requests.Load()); got != tt.wantRequests {
+				t.Errorf("S3 received %d requests, want %d", got, tt.wantRequests)
+			}
+			if got := testutil.ToFloat64(m.StorageRetriesDenied); got != tt.wantDenied {
+				t.Errorf("StorageRetriesDenied = %v, want %v", got, tt.wantDenied)
+			}
+		})
+	}
+}
+
+func TestS3Provider_GetObjectDeadline(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(150 * time.Millisecond)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	cfg := baseS3TestConfig()
+	cfg.S3Endpoint = server.URL
+	cfg.StorageMaxRetries = 3
+	cfg.StorageRetryDelay = time.Millisecond
+	cfg.CircuitBreakerThreshold = 100
+	m := metrics.NewWithRegistry(prometheus.NewRegistry())
+
+	provider, err := NewS3Provider(context.Background(), cfg, m, circuitbreaker.New("storage", cfg, m))
+	if err != nil {
+		t.Fatalf("NewS3Provider returned error: %v", err)
+	}
+
+	// The first attempt takes 150ms, leaving too little of the 250ms for
+	// another
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	_, err = provider.GetObject(ctx, "bucket", "slow.txt")
+	if !errors.Is(err, retry.ErrDeadline) {
+		t.Errorf("GetObject() error = %v, want %v", err, retry.ErrDeadline)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("S3 received %d requests, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.StorageRetriesLate); got != 1 {
+		t.Errorf("StorageRetriesLate = %v, want 1", got)
+	}
+}
+
+func TestS3Provider_GetObjectHedged(t *testing.T) {
+	tests := []struct {
+		name       string
+		percentile float64
+		wantResult string // hedged request result counted, "" for none
+	}{
+		{name: "hedging off", percentile: 0},
+		{name: "hedge answers first", percentile: 50, wantResult: "won"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The first request is slow, later ones answer at once
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) == 1 {
+					select {
+					case <-r.Context().Done():
+						return
+					case <-time.After(500 * time.Millisecond):
+					}
+				}
+				w.Write([]byte("content"))
+			}))
+			defer server.Close()
+
+			cfg := baseS3TestConfig()
+			cfg.S3Endpoint = server.URL
+			cfg.StorageHedgePercentile = tt.percentile
+			cfg.StorageHedgeMinDelay = 20 * time.Millisecond
+			m := metrics.NewWithRegistry(prometheus.NewRegistry())
+
+			provider, err := NewS3Provider(context.Background(), cfg, m, circuitbreaker.New("storage", cfg, m))
+			if err != nil {
+				t.Fatalf("NewS3Provider returned error: %v", err)
+			}
+			for i := 0; i < hedgeMinSamples; i++ {
+				provider.hedge.observe(time.Millisecond)
+			}
+
+			start := time.Now()
+			obj, err := provider.GetObject(context.Background(), "bucket", "a.txt")
+			if err != nil {
+				t.Fatalf("GetObject() error = %v", err)
+			}
+			body, _ := io.ReadAll(obj)
+			obj.Close()
+			if string(body) != "content" {
+				t.Errorf("body = %q, want %q", body, "content")
+			}
+
+			hedged := time.Since(start) < 400*time.Millisecond
+			if hedged != (tt.wantResult != "") {
+				t.Errorf("GetObject() took %v, hedged = %v", time.Since(start), hedged)
+			}
+			for _, result := range []string{"won", "lost", "failed"} {
+				want := 0.0
+				if result == tt.wantResult {
+					want = 1
+				}
+				if got := testutil.ToFloat64(m.StorageHedgedRequests.WithLabelValues(result)); got != want {
+					t.Errorf("StorageHedgedRequests{result=%q} = %v, want %v", result, got, want)
+				}
+			}
+		})
+	}
+}
@@ -2,25 +2,136 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 
 	"zipperfly/internal/circuitbreaker"
 	"zipperfly/internal/config"
 	"zipperfly/internal/metrics"
 )
 
+// Object is an open storage object. Callers must Close it.
+type Object struct {
+	io.ReadCloser
+	Size        int64       // content length in bytes, -1 if unknown
+	ModTime     time.Time   // last modification time, zero if unknown
+	Mode        fs.FileMode // permission bits, 0 if unknown
+	ContentType string      // MIME type recorded by storage, "" if unknown
+}
+
 // Provider defines the interface for storage backends
 type Provider interface {
 	// GetObject retrieves an object from storage
 	// bucket: the bucket name (S3) or base path (local)
 	// key: the object key/path
-	GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error)
+	GetObject(ctx context.Context, bucket, key string) (*Object, error)
 
 	// HealthCheck performs a lightweight connectivity check
 	HealthCheck(ctx context.Context) error
 }
 
+// ObjectInfo describes a storage object without opening it
+type ObjectInfo struct {
+	Size    int64     // content length in bytes, -1 if unknown
+	ModTime time.Time // last modification time, zero if unknown
+}
+
+// Stater is implemented by providers that can look an object up without
+// fetching it, e.g. with an S3 HEAD request. Use Stat, which falls back to
+// opening the object for other providers.
+type Stater interface {
+	// StatObject returns the object's size and modification time, or an
+	// error IsNotFound recognises if it doesn't exist
+	StatObject(ctx context.Context, bucket, key string) (*ObjectInfo, error)
+}
+
+// ErrObjectNotFound is returned for a key that names no object, e.g. a
+// directory of local storage
+var ErrObjectNotFound = errors.New("object not found")
+
+// Stat looks an object up with the provider's StatObject, or by opening and
+// closing it if the provider has none
+func Stat(ctx context.Context, p Provider, bucket, key string) (*ObjectInfo, error) {
+	if stater, ok := p.(Stater); ok {
+		return stater.StatObject(ctx, bucket, key)
+	}
+	obj, err := p.GetObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	obj.Close()
+	return &ObjectInfo{Size: obj.Size, ModTime: obj.ModTime}, nil
+}
+
+// StatAll looks up each of keys in bucket with Stat, up to concurrency at
+// a time, and returns their infos and errors in the order of keys
+func StatAll(ctx context.Context, p Provider, bucket string, keys []string, concurrency int) ([]*ObjectInfo, []error) {
+	infos := make([]*ObjectInfo, len(keys))
+	errs := make([]error, len(keys))
+	sem := make(chan struct{}, max(concurrency, 1))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			infos[i], errs[i] = Stat(ctx, p, bucket, key)
+		}()
+	}
+	wg.Wait()
+	return infos, errs
+}
+
+// IsNotFound reports whether err from GetObject or StatObject means the
+// object doesn't exist, as opposed to storage failing. Each provider
+// reports a missing object its own way.
+func IsNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	return errors.Is(err, ErrObjectNotFound) ||
+		errors.Is(err, fs.ErrNotExist) ||
+		errors.As(err, &noSuchKey) ||
+		errors.As(err, &notFound)
+}
+
+// BucketAllowed checks a record's bucket against an ALLOWED_BUCKETS list;
+// an empty list allows any bucket. An entry matches the bucket exactly or,
+// for local storage, any path beneath it (e.g. "uploads" permits
+// "uploads/2024"). A bucket with a ".." segment never matches, since local
+// storage would resolve it outside the entry.
+func BucketAllowed(allowed []string, bucket string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	if slices.Contains(strings.Split(bucket, "/"), "..") {
+		return false
+	}
+	bucket = strings.Trim(path.Clean("/"+bucket), "/")
+	for _, entry := range allowed {
+		entry = strings.Trim(entry, "/")
+		if bucket == entry || strings.HasPrefix(bucket, entry+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// CredentialsSetter is implemented by providers whose credentials can be
+// replaced while in use
+type CredentialsSetter interface {
+	SetCredentials(accessKeyID, secretAccessKey string) error
+}
+
 // New creates a new storage provider based on configuration
 func New(ctx context.Context, cfg *config.Config, m *metrics.Metrics, cb *circuitbreaker.Breaker) (Provider, error) {
 	switch cfg.StorageType {
@@ -30,7 +141,7 @@ func New(ctx context.Context, cfg *config.Config, m *metrics.Metrics, cb *circui
 		if cfg.StoragePath == "" {
 			return nil, fmt.Errorf("STORAGE_PATH required for local storage")
 		}
-		return NewLocalProvider(cfg.StoragePath, m, cb, cfg.StorageFetchTimeout, cfg.StorageMaxRetries, cfg.StorageRetryDelay)
+		return NewLocalProvider(cfg.StoragePath, m, cb, cfg.StorageFetchTimeout, cfg.StorageMaxRetries, cfg.StorageRetryDelay, cfg.StorageRetryMaxDelay)
 	default:
 		return nil, fmt.Errorf("unsupported storage type: %s", cfg.StorageType)
 	}
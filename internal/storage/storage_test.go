@@ -141,3 +141,80 @@ func TestNew_UnsupportedStorageType(t *testing.T) {
 		t.Errorf("error = %q, want %q", err.Error(), expectedErr)
 	}
 }
+
+func TestBucketAllowed(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowedBuckets []string
+		bucket         string
+		want           bool
+	}{
+		{
+			name:   "empty allowlist allows all",
+			bucket: "anything",
+			want:   true,
+		},
+		{
+			name:           "exact match",
+			allowedBuckets: []string{"exports", "reports"},
+			bucket:         "reports",
+			want:           true,
+		},
+		{
+			name:           "path beneath root",
+			allowedBuckets: []string{"uploads"},
+			bucket:         "uploads/2024/06",
+			want:           true,
+		},
+		{
+			name:           "slashes are ignored",
+			allowedBuckets: []string{"/uploads/"},
+			bucket:         "uploads/2024",
+			want:           true,
+		},
+		{
+			name:           "shared prefix is not a path root",
+			allowedBuckets: []string{"uploads"},
+			bucket:         "uploads-private",
+			want:           false,
+		},
+		{
+			name:           "bucket not in list",
+			allowedBuckets: []string{"exports"},
+			bucket:         "secrets",
+			want:           false,
+		},
+		{
+			name:           "parent segment can't escape the root",
+			allowedBuckets: []string{"uploads"},
+			bucket:         "uploads/../secrets",
+			want:           false,
+		},
+		{
+			name:           "parent segment within the root",
+			allowedBuckets: []string{"uploads"},
+			bucket:         "uploads/2024/../2023",
+			want:           false,
+		},
+		{
+			name:           "dot segments are cleaned",
+			allowedBuckets: []string{"uploads"},
+			bucket:         "./uploads//2024/.",
+			want:           true,
+		},
+		{
+			name:           "empty bucket rejected when list set",
+			allowedBuckets: []string{"exports"},
+			bucket:         "",
+			want:           false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := BucketAllowed(tt.allowedBuckets, tt.bucket); got != tt.want {
+				t.Errorf("BucketAllowed(%v, %q) = %v, want %v", tt.allowedBuckets, tt.bucket, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package tokens
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keeps tokens in Redis, shared by every replica using the same
+// server and key prefix
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedis connects to the Redis server at url
+func NewRedis(ctx context.Context, url, prefix string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("redis parse url error: %w", err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("redis connect error: %w", err)
+	}
+	return &Redis{client: client, prefix: prefix}, nil
+}
+
+// Close closes the connection
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+// Issue implements Store
+func (r *Redis) Issue(ctx context.Context, grant Grant, ttl time.Duration) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	grant.Expires = time.Now().Add(ttl)
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return "", err
+	}
+	if err := r.client.Set(ctx, r.prefix+token, data, ttl).Err(); err != nil {
+		return "", fmt.Errorf("redis token error: %w", err)
+	}
+	return token, nil
+}
+
+// Redeem implements Store. GETDEL (Redis 6.2 or later) reads and removes
+// the token at once, so concurrent requests can't both redeem it.
+func (r *Redis) Redeem(ctx context.Context, token string) (*Grant, error) {
+	data, err := r.client.GetDel(ctx, r.prefix+token).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis token error: %w", err)
+	}
+	var grant Grant
+	if err := json.Unmarshal(data, &grant); err != nil {
+		return nil, fmt.Errorf("invalid token data: %w", err)
+	}
+	return &grant, nil
+}
+
+// Restore implements Store, keeping the token only for the rest of its
+// original TTL
+func (r *Redis) Restore(ctx context.Context, token string, grant Grant) error {
+	ttl := time.Until(grant.Expires)
+	if ttl <= 0 {
+		return nil
+	}
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+	if err := r.client.Set(ctx, r.prefix+token, data, ttl).Err(); err != nil {
+		return fmt.Errorf("redis token error: %w", err)
+	}
+	return nil
+}
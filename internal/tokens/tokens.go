@@ -0,0 +1,111 @@
+// Package tokens provides one-time download tokens: short-lived names for a
+// verified download request, redeemed once in place of its signature. They
+// are kept either in process or in Redis so that every replica shares them.
+package tokens
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix starts every token, telling tokens apart from record IDs
+const Prefix = "dt_"
+
+// Grant is the download request a token stands for
+type Grant struct {
+	ID       string    `json:"id"`
+	Files    string    `json:"files,omitempty"`
+	Format   string    `json:"format,omitempty"`
+	Raw      string    `json:"raw,omitempty"`
+	Password string    `json:"password,omitempty"`
+	User     string    `json:"user,omitempty"` // the verified request's JWT user, "" = none
+	Expires  time.Time `json:"expires"`        // when the token expires, set by Issue
+}
+
+// Store keeps tokens until they are redeemed or expire
+type Store interface {
+	// Issue stores grant under a new token that expires after ttl
+	Issue(ctx context.Context, grant Grant, ttl time.Duration) (string, error)
+	// Redeem removes token and returns its grant, or nil if the token is
+	// unknown, expired, or already redeemed
+	Redeem(ctx context.Context, token string) (*Grant, error)
+	// Restore puts a redeemed grant back under token until it expires, for
+	// a download refused before it started. An expired grant is dropped.
+	Restore(ctx context.Context, token string, grant Grant) error
+}
+
+// IsToken reports whether s has the form of a token
+func IsToken(s string) bool {
+	return strings.HasPrefix(s, Prefix)
+}
+
+// newToken returns a random token
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return Prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Memory is a Store kept in process memory
+type Memory struct {
+	mu     sync.Mutex
+	grants map[string]Grant
+}
+
+// NewMemory creates an in-process token store
+func NewMemory() *Memory {
+	return &Memory{grants: make(map[string]Grant)}
+}
+
+// Issue implements Store
+func (m *Memory) Issue(_ context.Context, grant Grant, ttl time.Duration) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Drop expired tokens, so the map doesn't grow with unredeemed ones
+	for t, g := range m.grants {
+		if now.After(g.Expires) {
+			delete(m.grants, t)
+		}
+	}
+	grant.Expires = now.Add(ttl)
+	m.grants[token] = grant
+	return token, nil
+}
+
+// Redeem implements Store
+func (m *Memory) Redeem(_ context.Context, token string) (*Grant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g, ok := m.grants[token]
+	if !ok {
+		return nil, nil
+	}
+	delete(m.grants, token)
+	if time.Now().After(g.Expires) {
+		return nil, nil
+	}
+	return &g, nil
+}
+
+// Restore implements Store
+func (m *Memory) Restore(_ context.Context, token string, grant Grant) error {
+	if time.Now().After(grant.Expires) {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.grants[token] = grant
+	return nil
+}
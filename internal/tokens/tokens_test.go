@@ -0,0 +1,119 @@
+package tokens
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testStore checks that store issues one-time, expiring tokens
+func testStore(t *testing.T, store Store) {
+	t.Helper()
+	ctx := context.Background()
+	grant := Grant{ID: "123", Files: "a.txt", Format: "tar", User: "42"}
+
+	token, err := store.Issue(ctx, grant, time.Minute)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	if !IsToken(token) {
+		t.Errorf("token %q lacks the %q prefix", token, Prefix)
+	}
+
+	got, err := store.Redeem(ctx, token)
+	if err != nil || got == nil {
+		t.Fatalf("Redeem() = %+v, %v, want %+v", got, err, grant)
+	}
+	if until := time.Until(got.Expires); until <= 0 || until > time.Minute {
+		t.Errorf("Redeem().Expires in %s, want within the TTL", until)
+	}
+	redeemed := *got
+	if got.Expires = (time.Time{}); *got != grant {
+		t.Fatalf("Redeem() = %+v, want %+v", got, grant)
+	}
+	if got, err := store.Redeem(ctx, token); err != nil || got != nil {
+		t.Errorf("second Redeem() = %+v, %v, want nil", got, err)
+	}
+
+	// A restored grant can be redeemed again, until it expires
+	if err := store.Restore(ctx, token, redeemed); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if got, err := store.Redeem(ctx, token); err != nil || got == nil || got.ID != grant.ID {
+		t.Errorf("Redeem(restored) = %+v, %v, want %+v", got, err, grant)
+	}
+	redeemed.Expires = time.Now().Add(-time.Second)
+	if err := store.Restore(ctx, token, redeemed); err != nil {
+		t.Fatalf("Restore(expired) error = %v", err)
+	}
+	if got, err := store.Redeem(ctx, token); err != nil || got != nil {
+		t.Errorf("Redeem(restored after expiry) = %+v, %v, want nil", got, err)
+	}
+	if got, err := store.Redeem(ctx, Prefix+"unknown"); err != nil || got != nil {
+		t.Errorf("Redeem(unknown) = %+v, %v, want nil", got, err)
+	}
+
+	// Concurrent redemptions succeed once
+	token, err = store.Issue(ctx, grant, time.Minute)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	var redemptions atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, _ := store.Redeem(ctx, token); got != nil {
+				redemptions.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := redemptions.Load(); n != 1 {
+		t.Errorf("token redeemed %d times, want 1", n)
+	}
+
+	// Expired tokens can't be redeemed
+	token, err = store.Issue(ctx, grant, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got, err := store.Redeem(ctx, token); err != nil || got != nil {
+		t.Errorf("Redeem(expired) = %+v, %v, want nil", got, err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestMemory_DropsExpired(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+	if _, err := m.Issue(ctx, Grant{ID: "old"}, time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, err := m.Issue(ctx, Grant{ID: "new"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.grants) != 1 {
+		t.Errorf("store holds %d tokens, want 1", len(m.grants))
+	}
+}
+
+func TestRedis(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping redis test in short mode")
+	}
+	r, err := NewRedis(context.Background(), "redis://localhost:6379/0", "zipperfly-test:"+t.Name()+":")
+	if err != nil {
+		t.Skipf("Redis not available: %v", err)
+	}
+	defer r.Close()
+	testStore(t, r)
+}
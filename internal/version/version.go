@@ -0,0 +1,50 @@
+// Package version holds the build metadata of the running binary, set at
+// build time with -ldflags:
+//
+//	go build -ldflags "-X zipperfly/internal/version.Version=v1.2.3 \
+//	  -X zipperfly/internal/version.Commit=$(git rev-parse HEAD) \
+//	  -X zipperfly/internal/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/server
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags -X; Commit and BuildDate fall back to the VCS stamp Go
+// adds to binaries built inside a git checkout
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// Info is the build metadata, as served at /version
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build metadata, with "unknown" for anything not recorded
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildDate: BuildDate, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = s.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
@@ -0,0 +1,23 @@
+package version
+
+import "testing"
+
+func TestGet(t *testing.T) {
+	defer func(v, c, d string) { Version, Commit, BuildDate = v, c, d }(Version, Commit, BuildDate)
+
+	Version, Commit, BuildDate = "v1.2.3", "abc123", "2024-01-15T10:30:00Z"
+	info := Get()
+	if info.Version != "v1.2.3" || info.Commit != "abc123" || info.BuildDate != "2024-01-15T10:30:00Z" {
+		t.Errorf("Get() = %+v, want the ldflags values", info)
+	}
+	if info.GoVersion == "" {
+		t.Error("Get() has no Go version")
+	}
+
+	// Test binaries carry no VCS stamp
+	Commit, BuildDate = "", ""
+	info = Get()
+	if info.Commit != "unknown" || info.BuildDate != "unknown" {
+		t.Errorf("Get() = %+v, want unknown commit and build date", info)
+	}
+}
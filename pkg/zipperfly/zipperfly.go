@@ -0,0 +1,215 @@
+// Package zipperfly embeds zipperfly's download endpoints in another Go
+// program, so a service can serve archives from its own records and storage
+// inside its existing router instead of running the server alongside it.
+//
+//	h := zipperfly.NewHandler(store, provider, zipperfly.Options{
+//		Secrets: map[string][]byte{"": []byte("abc-123")},
+//	})
+//	mux.Handle("/download/", http.StripPrefix("/download", h))
+//
+// The handler serves the record endpoints of the server: GET and HEAD
+// /{id}, GET /{id}/validate, and with the matching options POST
+// /{id}/prepare, GET /{id}/status, and GET /{id}/progress. Links are signed as for the server,
+// e.g. with package zipperfly/sign. Store looks records up, Provider fetches
+// their objects, and Archiver is the archive writer the handler streams
+// them into, also usable on its own.
+package zipperfly
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"zipperfly/internal/archive"
+	"zipperfly/internal/auth"
+	"zipperfly/internal/database"
+	"zipperfly/internal/handlers"
+	"zipperfly/internal/metrics"
+	"zipperfly/internal/models"
+	"zipperfly/internal/storage"
+)
+
+// Record is a download record: the bucket, the object keys archived, and
+// the options of the download
+type Record = models.DownloadRecord
+
+// Callback is a URL notified of a download's progress and outcome, with
+// the request to send it
+type Callback = models.Callback
+
+// Callbacks are the callbacks of a Record
+type Callbacks = models.Callbacks
+
+// ObjectMetadata sets the archive entry timestamp and permissions of one
+// of a Record's objects
+type ObjectMetadata = models.ObjectMetadata
+
+// Store looks up download records and counts their downloads
+type Store = database.Store
+
+// Object is an open storage object, returned by Provider
+type Object = storage.Object
+
+// Provider fetches the objects of a record from storage
+type Provider = storage.Provider
+
+// ErrRecordNotFound is returned by a Store for an unknown record ID
+var ErrRecordNotFound = database.ErrRecordNotFound
+
+// Archiver writes entries to an archive stream. It is not safe for
+// concurrent use.
+type Archiver = archive.Writer
+
+// Entry describes a file added with an Archiver
+type Entry = archive.Entry
+
+// Format is an archive format
+type Format = archive.Format
+
+// Archive formats
+const (
+	FormatZip    = archive.FormatZip
+	FormatTar    = archive.FormatTar
+	FormatTarGz  = archive.FormatTarGz
+	FormatTarZst = archive.FormatTarZst
+)
+
+// ArchiveOptions configures an Archiver
+type ArchiveOptions = archive.Options
+
+// NewArchiver returns an Archiver producing format on w
+func NewArchiver(format Format, w io.Writer, opts ArchiveOptions) (Archiver, error) {
+	return archive.NewWriter(format, w, opts)
+}
+
+// Options configures a Handler. The zero value serves unsigned links with
+// the server's defaults.
+type Options struct {
+	Secrets             map[string][]byte     // signing secrets by key ID, "" for one without; nil = no signatures checked
+	EnforceSigning      bool                  // reject unsigned links
+	MinSignatureVersion int                   // oldest signature scheme accepted, 0 = 1
+	Logger              *zap.Logger           // nil = no logging
+	Registerer          prometheus.Registerer // where the metrics are registered, nil = nowhere
+	TrustedProxies      []*net.IPNet          // proxies whose forwarding headers are trusted
+	ClientIPHeader      string                // the one forwarding header TrustedProxies set, e.g. "x-forwarded-for"; "" = any
+
+	MaxConcurrent      int64    // parallel fetches per download, 0 = 10
+	IgnoreMissing      bool     // skip files that can't be fetched instead of failing
+	MaxFilesPerRequest int      // 0 = unlimited
+	MaxFileSize        int64    // 0 = unlimited
+	MaxArchiveSize     int64    // 0 = unlimited
+	AllowedBuckets     []string // nil = any bucket
+	AllowedExtensions  []string // nil = any extension
+	BlockedExtensions  []string
+	CompressionLevel   int           // Deflate level (1-9), 0 = library default
+	ZipStoreOnly       bool          // write every ZIP entry uncompressed
+	SpoolMemoryLimit   int           // bytes of each prefetched file kept in memory, 0 = 1 MiB
+	SpoolDir           string        // "" = the system temp directory
+	StallTimeout       time.Duration // max gap between reads of one file, 0 = disabled
+	FlushInterval      time.Duration // max time streamed bytes wait in buffers, 0 = until they fill
+	AsyncBuilds        bool          // serve POST /{id}/prepare and GET /{id}/status
+	StagingDir         string        // where async builds are staged, "" = zipperfly-staging in the system temp directory
+	StagingTTL         time.Duration // how long staged builds are kept, 0 = 1h
+	ProgressEvents     bool          // serve GET /{id}/progress
+	DisableCallbacks   bool          // don't notify records' callback URLs
+}
+
+// Handler serves the download endpoints of zipperfly
+type Handler struct {
+	download *handlers.Handler
+	routes   http.Handler
+}
+
+// NewHandler returns a Handler serving the records of store from provider
+func NewHandler(store Store, provider Provider, opts Options) *Handler {
+	logger := opts.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	reg := opts.Registerer
+	if reg == nil {
+		reg = prometheus.NewRegistry()
+	}
+	m := metrics.NewWithRegistry(reg)
+
+	minVersion := opts.MinSignatureVersion
+	if minVersion == 0 {
+		minVersion = 1
+	}
+	verifier := auth.NewVerifier(opts.Secrets, opts.EnforceSigning, minVersion, m, nil)
+
+	maxConcurrent := opts.MaxConcurrent
+	if maxConcurrent == 0 {
+		maxConcurrent = 10
+	}
+	spoolMemoryLimit := opts.SpoolMemoryLimit
+	if spoolMemoryLimit == 0 {
+		spoolMemoryLimit = 1 << 20
+	}
+	stagingDir := opts.StagingDir
+	if stagingDir == "" {
+		stagingDir = filepath.Join(os.TempDir(), "zipperfly-staging")
+	}
+	stagingTTL := opts.StagingTTL
+	if stagingTTL == 0 {
+		stagingTTL = time.Hour
+	}
+
+	download := handlers.NewDownloadHandler(logger, store, provider, verifier, m, handlers.HandlerOptions{
+		MaxConcurrent:      maxConcurrent,
+		IgnoreMissing:      opts.IgnoreMissing,
+		MaxFilesPerRequest: opts.MaxFilesPerRequest,
+		MaxFileSize:        opts.MaxFileSize,
+		MaxArchiveSize:     opts.MaxArchiveSize,
+		AllowedBuckets:     opts.AllowedBuckets,
+		AllowedExtensions:  opts.AllowedExtensions,
+		BlockedExtensions:  opts.BlockedExtensions,
+		CompressionLevel:   opts.CompressionLevel,
+		ZipStoreOnly:       opts.ZipStoreOnly,
+		SpoolMemoryLimit:   spoolMemoryLimit,
+		SpoolDir:           opts.SpoolDir,
+		StallTimeout:       opts.StallTimeout,
+		FlushInterval:      opts.FlushInterval,
+		AsyncBuilds:        opts.AsyncBuilds,
+		StagingDir:         stagingDir,
+		StagingTTL:         stagingTTL,
+		ProgressEvents:     opts.ProgressEvents,
+		DisableCallbacks:   opts.DisableCallbacks,
+	})
+
+	r := mux.NewRouter()
+	r.Use(handlers.RequestIDMiddleware)
+	r.Use(handlers.ClientIPMiddleware(opts.TrustedProxies, opts.ClientIPHeader))
+	r.Handle("/{id}", handlers.NoWriteDeadline(http.HandlerFunc(download.Download))).Methods("GET", "HEAD")
+	r.HandleFunc("/{id}/prepare", download.Prepare).Methods("POST")
+	r.HandleFunc("/{id}/status", download.Status).Methods("GET")
+	r.Handle("/{id}/progress", handlers.NoWriteDeadline(http.HandlerFunc(download.Progress))).Methods("GET")
+	r.HandleFunc("/{id}/validate", download.Validate).Methods("GET")
+
+	return &Handler{download: download, routes: r}
+}
+
+// ServeHTTP serves a download endpoint, with paths relative to where the
+// handler is mounted
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.routes.ServeHTTP(w, r)
+}
+
+// Drain refuses new downloads with 503, ahead of shutting down the server
+// the handler is mounted on; downloads in progress carry on
+func (h *Handler) Drain() {
+	h.download.Drain()
+}
+
+// CutOff cancels the downloads still in progress, e.g. once the server's
+// shutdown timeout runs out, and returns how many there were
+func (h *Handler) CutOff() int {
+	return h.download.CutOff()
+}
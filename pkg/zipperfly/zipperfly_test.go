@@ -0,0 +1,172 @@
+package zipperfly_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"zipperfly/pkg/zipperfly"
+	"zipperfly/sign"
+)
+
+// memoryStore is a Store of records held in memory
+type memoryStore map[string]*zipperfly.Record
+
+func (s memoryStore) GetRecord(ctx context.Context, id string) (*zipperfly.Record, error) {
+	record, ok := s[id]
+	if !ok {
+		return nil, zipperfly.ErrRecordNotFound
+	}
+	copied := *record
+	return &copied, nil
+}
+
+func (s memoryStore) GetRecords(ctx context.Context, ids []string) (map[string]*zipperfly.Record, error) {
+	records := make(map[string]*zipperfly.Record)
+	for _, id := range ids {
+		if record, err := s.GetRecord(ctx, id); err == nil {
+			records[id] = record
+		}
+	}
+	return records, nil
+}
+
+func (s memoryStore) IncrementDownloadCount(ctx context.Context, id string) error {
+	if record, ok := s[id]; ok {
+		record.DownloadCount++
+	}
+	return nil
+}
+
+func (s memoryStore) HealthCheck(ctx context.Context) error { return nil }
+func (s memoryStore) Close() error                          { return nil }
+
+// memoryProvider is a Provider of objects held in memory, by bucket/key
+type memoryProvider map[string]string
+
+func (p memoryProvider) GetObject(ctx context.Context, bucket, key string) (*zipperfly.Object, error) {
+	content, ok := p[bucket+"/"+key]
+	if !ok {
+		return nil, zipperfly.ErrRecordNotFound
+	}
+	return &zipperfly.Object{ReadCloser: io.NopCloser(strings.NewReader(content)), Size: int64(len(content))}, nil
+}
+
+func (p memoryProvider) HealthCheck(ctx context.Context) error { return nil }
+
+func TestHandler(t *testing.T) {
+	store := memoryStore{"report": {ID: "report", Bucket: "docs", Objects: []string{"a.txt", "b.txt"}}}
+	provider := memoryProvider{"docs/a.txt": "alpha", "docs/b.txt": "bravo"}
+	secret := []byte("abc-123")
+
+	mux := http.NewServeMux()
+	mux.Handle("/download/", http.StripPrefix("/download", zipperfly.NewHandler(store, provider, zipperfly.Options{
+		Secrets:        map[string][]byte{"": secret},
+		EnforceSigning: true,
+	})))
+
+	signer := sign.Signer{BaseURL: "http://example.com/download", Secret: secret}
+	signed, err := signer.URL("report", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("URL() error = %v", err)
+	}
+	unknown, _ := signer.URL("missing", time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+	}{
+		{name: "signed", url: signed, wantStatus: http.StatusOK},
+		{name: "unsigned", url: "http://example.com/download/report", wantStatus: http.StatusUnauthorized},
+		{name: "unknown record", url: unknown, wantStatus: http.StatusNotFound},
+		{name: "async builds off", url: "http://example.com/download/report/status", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+			if err != nil {
+				t.Fatalf("failed to read ZIP: %v", err)
+			}
+			if len(zr.File) != 2 {
+				t.Errorf("ZIP has %d entries, want 2", len(zr.File))
+			}
+		})
+	}
+}
+
+func TestNewArchiver(t *testing.T) {
+	var buf bytes.Buffer
+	aw, err := zipperfly.NewArchiver(zipperfly.FormatZip, &buf, zipperfly.ArchiveOptions{})
+	if err != nil {
+		t.Fatalf("NewArchiver() error = %v", err)
+	}
+	if _, err := aw.AddFile(zipperfly.Entry{Name: "hello.txt", Size: 5}, strings.NewReader("hello")); err != nil {
+		t.Fatalf("AddFile() error = %v", err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("failed to read ZIP: %v", err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "hello.txt" {
+		t.Errorf("ZIP entries = %v, want hello.txt", zr.File)
+	}
+}
+
+func TestRecord_CallbacksAndMetadata(t *testing.T) {
+	notified := make(chan string, 2)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notified <- r.Method
+	}))
+	defer callback.Close()
+
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := memoryStore{"report": {
+		ID:             "report",
+		Bucket:         "docs",
+		Objects:        []string{"a.txt"},
+		Callbacks:      zipperfly.Callbacks{&zipperfly.Callback{URL: callback.URL}},
+		ObjectMetadata: map[string]zipperfly.ObjectMetadata{"a.txt": {ModTime: mtime, Mode: "0600"}},
+	}}
+	provider := memoryProvider{"docs/a.txt": "alpha"}
+	h := zipperfly.NewHandler(store, provider, zipperfly.Options{})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/report", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("failed to read ZIP: %v", err)
+	}
+	if f := zr.File[0]; !f.Modified.Equal(mtime) || f.Mode().Perm() != 0o600 {
+		t.Errorf("entry modified %s, mode %s, want %s and 0600", f.Modified, f.Mode().Perm(), mtime)
+	}
+
+	select {
+	case <-notified:
+	case <-time.After(5 * time.Second):
+		t.Error("callback not notified")
+	}
+}
@@ -0,0 +1,175 @@
+package sign
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPayload(t *testing.T) {
+	tests := []struct {
+		name   string
+		expiry string
+		files  string
+		want   string
+	}{
+		{name: "id only", want: "123"},
+		{name: "expiry", expiry: "1764460487", want: "123|1764460487"},
+		{name: "files", files: "a.txt,0", want: "123||a.txt,0"},
+		{name: "expiry and files", expiry: "1764460487", files: "a.txt,0", want: "123|1764460487|a.txt,0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Payload("123", tt.expiry, tt.files); got != tt.want {
+				t.Errorf("Payload() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignature(t *testing.T) {
+	// Matches PHP's hash_hmac('sha256', '123|1764460487', 'abc-123') from the README example
+	want := "3a8ee1d95f324f11148ccb9e64b7887ff1166303ca0056e71612f490128aabea"
+	if got := Signature([]byte("abc-123"), "123", "1764460487", ""); got != want {
+		t.Errorf("Signature() = %q, want %q", got, want)
+	}
+}
+
+func TestPayloadV2(t *testing.T) {
+	tests := []struct {
+		name   string
+		params Params
+		want   string
+	}{
+		{name: "id only", params: Params{ID: "123"}, want: "v2\nGET\n123\n\n\n\n"},
+		{name: "HEAD signs as GET", params: Params{Method: "HEAD", ID: "123", Expiry: "1764460487"}, want: "v2\nGET\n123\n1764460487\n\n\n"},
+		{
+			name:   "all parameters",
+			params: Params{Method: "post", ID: "123", Expiry: "1764460487", Files: "a.txt,0", Format: "tar.gz", Raw: "true"},
+			want:   "v2\nPOST\n123\n1764460487\na.txt,0\ntar.gz\ntrue",
+		},
+		{name: "password", params: Params{ID: "123", Password: "hunter2"}, want: "v2\nGET\n123\n\n\n\n\nhunter2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PayloadV2(tt.params); got != tt.want {
+				t.Errorf("PayloadV2() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSigner_URL(t *testing.T) {
+	expiry := time.Unix(1764460487, 0)
+
+	tests := []struct {
+		name    string
+		signer  Signer
+		id      string
+		expiry  time.Time
+		files   []string
+		want    url.Values
+		wantErr bool
+	}{
+		{
+			name:   "expiry",
+			signer: Signer{BaseURL: "https://egress.example.com/", Secret: []byte("abc-123")},
+			id:     "123",
+			expiry: expiry,
+			want:   url.Values{"expiry": {"1764460487"}, "signature": {Signature([]byte("abc-123"), "123", "1764460487", "")}},
+		},
+		{
+			name:   "no expiry",
+			signer: Signer{BaseURL: "https://egress.example.com", Secret: []byte("abc-123")},
+			id:     "123",
+			want:   url.Values{"signature": {Signature([]byte("abc-123"), "123", "", "")}},
+		},
+		{
+			name:   "files and key ID",
+			signer: Signer{BaseURL: "https://egress.example.com", Secret: []byte("new-secret"), KeyID: "2025"},
+			id:     "123",
+			expiry: expiry,
+			files:  []string{"a.txt", "2"},
+			want: url.Values{
+				"expiry":    {"1764460487"},
+				"files":     {"a.txt,2"},
+				"kid":       {"2025"},
+				"signature": {Signature([]byte("new-secret"), "123", "1764460487", "a.txt,2")},
+			},
+		},
+		{
+			name:   "version 2",
+			signer: Signer{BaseURL: "https://egress.example.com", Secret: []byte("abc-123"), Version: 2},
+			id:     "123",
+			expiry: expiry,
+			want: url.Values{
+				"expiry":    {"1764460487"},
+				"v":         {"2"},
+				"signature": {SignatureV2([]byte("abc-123"), Params{ID: "123", Expiry: "1764460487"})},
+			},
+		},
+		{name: "missing id", signer: Signer{Secret: []byte("abc-123")}, wantErr: true},
+		{name: "unknown version", signer: Signer{Secret: []byte("abc-123"), Version: 3}, id: "123", wantErr: true},
+		{name: "comma in file", signer: Signer{Secret: []byte("abc-123")}, id: "123", files: []string{"a,b.txt"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.signer.URL(tt.id, tt.expiry, tt.files...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("URL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			u, err := url.Parse(got)
+			if err != nil {
+				t.Fatalf("URL() = %q: %v", got, err)
+			}
+			if base := u.Scheme + "://" + u.Host + u.Path; base != "https://egress.example.com/"+tt.id {
+				t.Errorf("URL() base = %q, want https://egress.example.com/%s", base, tt.id)
+			}
+			if u.RawQuery != tt.want.Encode() {
+				t.Errorf("URL() query = %q, want %q", u.RawQuery, tt.want.Encode())
+			}
+		})
+	}
+}
+
+func TestSigner_Sign(t *testing.T) {
+	s := Signer{Secret: []byte("abc-123"), Version: 2}
+	query, err := s.Sign(Link{ID: "123", Format: "tar.gz", Raw: "true", Method: "POST"})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	want := url.Values{
+		"format":    {"tar.gz"},
+		"raw":       {"true"},
+		"v":         {"2"},
+		"signature": {SignatureV2([]byte("abc-123"), Params{Method: "POST", ID: "123", Format: "tar.gz", Raw: "true"})},
+	}
+	if query.Encode() != want.Encode() {
+		t.Errorf("Sign() = %q, want %q", query.Encode(), want.Encode())
+	}
+}
+
+func TestSigner_Sign_Password(t *testing.T) {
+	s := Signer{Secret: []byte("abc-123"), Version: 2}
+	query, err := s.Sign(Link{ID: "123", Password: "hunter2"})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if query.Get("password") != "hunter2" {
+		t.Errorf("password = %q, want hunter2", query.Get("password"))
+	}
+	if want := SignatureV2([]byte("abc-123"), Params{ID: "123", Password: "hunter2"}); query.Get("signature") != want {
+		t.Errorf("signature = %q, want it to cover the password", query.Get("signature"))
+	}
+
+	if _, err := (Signer{Secret: []byte("abc-123")}).Sign(Link{ID: "123", Password: "hunter2"}); err == nil {
+		t.Error("Sign() with a password and a version 1 signature succeeded, want an error")
+	}
+}
@@ -292,26 +292,52 @@ func runDownloadSuite(t *testing.T, cfg *config.Config, seed func(ctx context.Co
 	}
 
 	// Create verifier and handler
-	verifier := auth.NewVerifier(cfg.SigningSecret, cfg.EnforceSigning, m)
-	downloadHandler := handlers.NewHandler(
-		logger,
-		db,
-		storageProvider,
-		verifier,
-		m,
-		false,               // no Prometheus handler wrapping here
-		false,               // no debug logging injection
-		cfg.IgnoreMissing,   // ignore missing files?
-		cfg.MaxConcurrent,   // max concurrent file fetches
-		cfg.CallbackMaxRetries,
-		cfg.CallbackRetryDelay,
-		cfg.AllowPasswordProtected,
-		cfg.AllowedExtensions,
-		cfg.BlockedExtensions,
-		cfg.MaxActiveDownloads,
-		cfg.RateLimitPerIP,
-		cfg.MaxFilesPerRequest,
-	)
+	verifier := auth.NewVerifier(cfg.SigningSecrets, cfg.EnforceSigning, cfg.MinSignatureVersion, m, nil)
+	downloadHandler := handlers.NewDownloadHandler(logger, db, storageProvider, verifier, m, handlers.HandlerOptions{
+		IgnoreMissing:          cfg.IgnoreMissing,
+		MaxConcurrent:          cfg.MaxConcurrent,
+		CallbackMaxRetries:     cfg.CallbackMaxRetries,
+		CallbackRetryDelay:     cfg.CallbackRetryDelay,
+		AllowPasswordProtected: cfg.AllowPasswordProtected,
+		AllowedExtensions:      cfg.AllowedExtensions,
+		BlockedExtensions:      cfg.BlockedExtensions,
+		MaxFilesPerRequest:     cfg.MaxFilesPerRequest,
+		AllowedBuckets:         cfg.AllowedBuckets,
+		AuthWebhookURL:         cfg.AuthWebhookURL,
+		AuthWebhookTimeout:     cfg.AuthWebhookTimeout,
+		ZstdLevel:              cfg.ZstdLevel,
+		ZipStoreOnly:           cfg.ZipStoreOnly,
+		ZipStoreExtensions:     cfg.ZipStoreExtensions,
+		CompressionLevel:       cfg.CompressionLevel,
+		ZipEncryption:          cfg.ZipEncryption,
+		ArchiveManifest:        cfg.ArchiveManifest,
+		ManifestFormat:         cfg.ManifestFormat,
+		ExtraFiles:             cfg.ExtraFiles,
+		EntryOrder:             cfg.EntryOrder,
+		SpoolMemoryLimit:       cfg.SpoolMemoryLimit,
+		SpoolDir:               cfg.SpoolDir,
+		FileFetchTimeout:       cfg.FileFetchTimeout,
+		StallTimeout:           cfg.StallTimeout,
+		AbortOnStreamError:     cfg.AbortOnStreamError,
+		AsyncBuilds:            cfg.AsyncBuilds,
+		StagingDir:             cfg.StagingDir,
+		StagingTTL:             cfg.StagingTTL,
+		SanitizeCharset:        cfg.SanitizeCharset,
+		AllowEmptyRecords:      cfg.AllowEmptyRecords,
+		MaxFileSize:            cfg.MaxFileSize,
+		MaxArchiveSize:         cfg.MaxArchiveSize,
+		ProgressEvents:         cfg.ProgressEvents,
+		DisableCallbacks:       cfg.DisableCallbacks,
+		CallbackStarted:        cfg.CallbackStarted,
+		CallbackProgressBytes:  cfg.CallbackProgressBytes,
+		CallbackFileResults:    cfg.CallbackFileResults,
+		CallbackMaxElapsed:     cfg.CallbackMaxElapsed,
+		DefaultCallbacks:       cfg.DefaultCallbacks,
+		MetricsTenantLabel:     cfg.MetricsTenantLabel,
+		MetricsTenantLimit:     cfg.MetricsTenantLimit,
+		SlowDownloadThreshold:  cfg.SlowDownloadThreshold,
+		LargeDownloadThreshold: cfg.LargeDownloadThreshold,
+	})
 
 	runDownloadTests(t, downloadHandler)
 }
@@ -333,11 +359,10 @@ func TestIntegration_LocalStorage_PostgreSQL(t *testing.T) {
 		StorageType:               "local",
 		StoragePath:               getAbsPath(fixturesDir),
 		EnforceSigning:            false,
-		SigningSecret:             []byte("test-secret"),
+		SigningSecrets:            map[string][]byte{"": []byte("test-secret")},
 		DatabaseQueryTimeout:      5 * time.Second,
 		StorageFetchTimeout:       10 * time.Second,
 		RequestTimeout:            30 * time.Second,
-		MaxFileSize:               0,
 		MaxFilesPerRequest:        0,
 		StorageMaxRetries:         3,
 		StorageRetryDelay:         time.Second,
@@ -371,11 +396,10 @@ func TestIntegration_LocalStorage_MySQL(t *testing.T) {
 		StorageType:               "local",
 		StoragePath:               getAbsPath(fixturesDir),
 		EnforceSigning:            false,
-		SigningSecret:             []byte("test-secret"),
+		SigningSecrets:            map[string][]byte{"": []byte("test-secret")},
 		DatabaseQueryTimeout:      5 * time.Second,
 		StorageFetchTimeout:       10 * time.Second,
 		RequestTimeout:            30 * time.Second,
-		MaxFileSize:               0,
 		MaxFilesPerRequest:        0,
 		StorageMaxRetries:         3,
 		StorageRetryDelay:         time.Second,
@@ -460,11 +484,10 @@ func TestIntegration_LocalStorage_Redis(t *testing.T) {
 		StorageType:               "local",
 		StoragePath:               getAbsPath(fixturesDir),
 		EnforceSigning:            false,
-		SigningSecret:             []byte("test-secret"),
+		SigningSecrets:            map[string][]byte{"": []byte("test-secret")},
 		DatabaseQueryTimeout:      5 * time.Second,
 		StorageFetchTimeout:       10 * time.Second,
 		RequestTimeout:            30 * time.Second,
-		MaxFileSize:               0,
 		MaxFilesPerRequest:        0,
 		StorageMaxRetries:         3,
 		StorageRetryDelay:         time.Second,
@@ -508,12 +531,11 @@ func TestIntegration_S3_PostgreSQL(t *testing.T) {
 
 		// Download behavior
 		EnforceSigning: false,
-		SigningSecret:  []byte("test-secret"),
+		SigningSecrets: map[string][]byte{"": []byte("test-secret")},
 
 		DatabaseQueryTimeout:      5 * time.Second,
 		StorageFetchTimeout:       10 * time.Second,
 		RequestTimeout:            30 * time.Second,
-		MaxFileSize:               0,
 		MaxFilesPerRequest:        0,
 		StorageMaxRetries:         3,
 		StorageRetryDelay:         time.Second,
@@ -553,12 +575,11 @@ func TestIntegration_S3_MySQL(t *testing.T) {
 
 		// Download behavior
 		EnforceSigning: false,
-		SigningSecret:  []byte("test-secret"),
+		SigningSecrets: map[string][]byte{"": []byte("test-secret")},
 
 		DatabaseQueryTimeout:      5 * time.Second,
 		StorageFetchTimeout:       10 * time.Second,
 		RequestTimeout:            30 * time.Second,
-		MaxFileSize:               0,
 		MaxFilesPerRequest:        0,
 		StorageMaxRetries:         3,
 		StorageRetryDelay:         time.Second,
@@ -596,12 +617,11 @@ func TestIntegration_S3_Redis(t *testing.T) {
 
 		// Download behavior
 		EnforceSigning: false,
-		SigningSecret:  []byte("test-secret"),
+		SigningSecrets: map[string][]byte{"": []byte("test-secret")},
 
 		DatabaseQueryTimeout:      5 * time.Second,
 		StorageFetchTimeout:       10 * time.Second,
 		RequestTimeout:            30 * time.Second,
-		MaxFileSize:               0,
 		MaxFilesPerRequest:        0,
 		StorageMaxRetries:         3,
 		StorageRetryDelay:         time.Second,